@@ -0,0 +1,207 @@
+package audio
+
+import (
+	"errors"
+	"io"
+)
+
+var adtsSampleRates = [...]int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// ADTSHeader is a decoded ADTS (raw AAC stream) frame header
+type ADTSHeader struct {
+	// Profile is the AAC audio object type minus one (1 is AAC-LC)
+	Profile    int
+	SampleRate int
+	// Channels is 0 when the layout is given in-band
+	Channels int
+	// FrameLength is the frame size in bytes, header included
+	FrameLength int
+	// Samples is the number of PCM samples per channel in the frame
+	Samples   int
+	Protected bool
+}
+
+// ParseADTSHeader decodes the seven header bytes at the start of b
+func ParseADTSHeader(b []byte) (ADTSHeader, bool) {
+	var h ADTSHeader
+	// Sync word, then layer must be 0
+	if len(b) < 7 || b[0] != 0xFF || b[1]&0xF6 != 0xF0 {
+		return h, false
+	}
+	rateIndex := int(b[2]>>2) & 0xF
+	if rateIndex >= len(adtsSampleRates) {
+		return h, false
+	}
+	h.Protected = b[1]&0x1 == 0
+	h.Profile = int(b[2] >> 6)
+	h.SampleRate = adtsSampleRates[rateIndex]
+	h.Channels = int(b[2]&0x1)<<2 | int(b[3]>>6)
+	h.FrameLength = int(b[3]&0x3)<<11 | int(b[4])<<3 | int(b[5]>>5)
+	h.Samples = 1024 * (int(b[6]&0x3) + 1)
+
+	headerSize := 7
+	if h.Protected {
+		headerSize = 9
+	}
+	if h.FrameLength < headerSize {
+		return h, false
+	}
+	return h, true
+}
+
+// compatible reports whether two headers can belong to the same stream
+func (h ADTSHeader) compatible(other ADTSHeader) bool {
+	return h.Profile == other.Profile && h.SampleRate == other.SampleRate && h.Channels == other.Channels
+}
+
+// ADTSInfo describes the layout and length of an ADTS stream
+type ADTSInfo struct {
+	// AudioStart is the offset of the first frame, after any ID3v2 tag
+	AudioStart int64
+	// AudioEnd is the offset just past the last frame
+	AudioEnd int64
+	Header   ADTSHeader
+	Frames   int
+	Samples  int64
+}
+
+// ReadADTSInfo walks every frame header to find the exact stream length
+func ReadADTSInfo(r io.ReaderAt, size int64) (*ADTSInfo, error) {
+	end := size
+	if _, ok := readID3v1(r, size); ok {
+		end -= 128
+	}
+	start, err := syncADTS(r, skipID3v2(r), end, nil)
+	if err != nil {
+		return nil, err
+	}
+	info := &ADTSInfo{AudioStart: start, AudioEnd: start}
+
+	err = walkADTS(r, start, end, func(off int64, h ADTSHeader) bool {
+		if info.Frames == 0 {
+			info.Header = h
+		}
+		info.Frames++
+		info.Samples += int64(h.Samples)
+		info.AudioEnd = off + int64(h.FrameLength)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if info.Frames == 0 {
+		return nil, errors.New("adts: no frames found")
+	}
+	return info, nil
+}
+
+// BuildADTSFrameIndex walks the frame headers of the whole stream. Frames
+// are assumed to hold the same number of samples as the first one.
+func BuildADTSFrameIndex(r io.ReaderAt, size int64) (*FrameIndex, error) {
+	end := size
+	if _, ok := readID3v1(r, size); ok {
+		end -= 128
+	}
+	start, err := syncADTS(r, skipID3v2(r), end, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &FrameIndex{}
+	err = walkADTS(r, start, end, func(off int64, h ADTSHeader) bool {
+		if len(idx.Offsets) == 0 {
+			idx.SampleRate = h.SampleRate
+			idx.SamplesPerFrame = h.Samples
+		}
+		idx.Offsets = append(idx.Offsets, off)
+		idx.End = off + int64(h.FrameLength)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(idx.Offsets) == 0 {
+		return nil, errors.New("adts: no frames found")
+	}
+	return idx, nil
+}
+
+// Duration returns the stream length in seconds
+func (info *ADTSInfo) Duration() float64 {
+	return float64(info.Samples) / float64(info.Header.SampleRate)
+}
+
+// syncADTS returns the offset of the first frame at or after off whose
+// successor is also a frame of the same stream. When ref is set, frames
+// must be compatible with it.
+func syncADTS(r io.ReaderAt, off, end int64, ref *ADTSHeader) (int64, error) {
+	buf := make([]byte, 16*1024)
+	for off < end {
+		b := buf[:min(int64(len(buf)), end-off)]
+		if err := readFull(r, b, off); err != nil {
+			return 0, err
+		}
+		last := off+int64(len(b)) >= end
+
+		i := 0
+		for ; i+7 <= len(b); i++ {
+			h, ok := ParseADTSHeader(b[i:])
+			if !ok || (ref != nil && !h.compatible(*ref)) {
+				continue
+			}
+			next := i + h.FrameLength
+			if next+7 > len(b) {
+				if last {
+					if off+int64(next) == end {
+						return off + int64(i), nil
+					}
+					continue
+				}
+				// Read again from here so the next header is in the window
+				break
+			}
+			if nh, ok := ParseADTSHeader(b[next:]); ok && nh.compatible(h) {
+				return off + int64(i), nil
+			}
+		}
+		if last {
+			break
+		}
+		off += int64(max(i, 1))
+	}
+	return 0, errors.New("adts: no frame sync found")
+}
+
+// walkADTS calls fn with the offset and header of every frame between
+// start and end, resynchronising after damaged data, until fn returns false
+func walkADTS(r io.ReaderAt, start, end int64, fn func(off int64, h ADTSHeader) bool) error {
+	var first *ADTSHeader
+	var header [7]byte
+	pos := start
+	for pos+7 <= end {
+		if err := readFull(r, header[:], pos); err != nil {
+			return err
+		}
+		h, ok := ParseADTSHeader(header[:])
+		if ok && first != nil && !h.compatible(*first) {
+			ok = false
+		}
+		if !ok || pos+int64(h.FrameLength) > end {
+			next, err := syncADTS(r, pos+1, end, first)
+			if err != nil {
+				// Trailing junk such as an APE tag
+				return nil
+			}
+			pos = next
+			continue
+		}
+		if first == nil {
+			first = &h
+		}
+		if !fn(pos, h) {
+			return nil
+		}
+		pos += int64(h.FrameLength)
+	}
+	return nil
+}
@@ -0,0 +1,382 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// Kinds of spoken word recordings, as flagged by their tags
+const (
+	KindAudiobook = "audiobook"
+	KindPodcast   = "podcast"
+)
+
+// Chapter is a titled section of a recording, in seconds
+type Chapter struct {
+	Title string  `json:"title"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// finishChapters sorts chapters, closes each at the start of the next and
+// the last at the end of the recording, and names untitled ones
+func finishChapters(chapters []Chapter, duration float64) []Chapter {
+	if len(chapters) == 0 {
+		return nil
+	}
+	sort.SliceStable(chapters, func(i, j int) bool { return chapters[i].Start < chapters[j].Start })
+	for i := range chapters {
+		c := &chapters[i]
+		if i+1 < len(chapters) {
+			c.End = chapters[i+1].Start
+		} else if duration > c.Start {
+			c.End = duration
+		} else {
+			c.End = max(c.End, c.Start)
+		}
+		if strings.TrimSpace(c.Title) == "" {
+			c.Title = fmt.Sprintf("Chapter %d", i+1)
+		}
+	}
+	return chapters
+}
+
+// Chapters returns the chapters of the CHAP frames. When a top-level CTOC
+// frame exists only the chapters it lists, directly or through nested
+// tables of contents, are returned.
+func (t *ID3Tag) Chapters(duration float64) []Chapter {
+	chapters := map[string]Chapter{}
+	var order []string
+	tocs := map[string][]string{}
+	topLevel := ""
+	for _, f := range t.Frames {
+		switch f.ID {
+		case "CHAP":
+			id, rest, ok := bytes.Cut(f.Data, []byte{0})
+			if !ok || len(rest) < 16 {
+				continue
+			}
+			frames, _, _ := parseID3Frames(rest[16:], t.Version)
+			sub := &ID3Tag{Version: t.Version, Frames: frames}
+			title := sub.Text("TIT2")
+			if title == "" {
+				title = sub.Text("TIT3")
+			}
+			chapters[string(id)] = Chapter{
+				Title: title,
+				Start: float64(binary.BigEndian.Uint32(rest[0:4])) / 1000,
+				End:   float64(binary.BigEndian.Uint32(rest[4:8])) / 1000,
+			}
+			order = append(order, string(id))
+		case "CTOC":
+			id, rest, ok := bytes.Cut(f.Data, []byte{0})
+			if !ok || len(rest) < 2 {
+				continue
+			}
+			flags, count := rest[0], int(rest[1])
+			rest = rest[2:]
+			var children []string
+			for i := 0; i < count; i++ {
+				child, next, ok := bytes.Cut(rest, []byte{0})
+				if !ok {
+					break
+				}
+				children = append(children, string(child))
+				rest = next
+			}
+			tocs[string(id)] = children
+			if flags&0x02 != 0 && topLevel == "" {
+				topLevel = string(id)
+			}
+		}
+	}
+
+	if topLevel != "" {
+		order = nil
+		seen := map[string]bool{}
+		var walk func(id string)
+		walk = func(id string) {
+			if seen[id] {
+				return
+			}
+			seen[id] = true
+			if _, ok := chapters[id]; ok {
+				order = append(order, id)
+			}
+			for _, child := range tocs[id] {
+				walk(child)
+			}
+		}
+		walk(topLevel)
+	}
+	list := make([]Chapter, 0, len(order))
+	for _, id := range order {
+		list = append(list, chapters[id])
+	}
+	return finishChapters(list, duration)
+}
+
+// Kind returns KindPodcast for tags with a PCST frame, which marks podcast
+// episodes
+func (t *ID3Tag) Kind() string {
+	if _, ok := t.Frame("PCST"); ok {
+		return KindPodcast
+	}
+	return ""
+}
+
+// kindFromGenre recognises the genres used for spoken word recordings
+func kindFromGenre(genre string) string {
+	switch strings.ToLower(strings.TrimSpace(genre)) {
+	case "audiobook", "audiobooks", "audio book", "hörbuch":
+		return KindAudiobook
+	case "podcast", "podcasts":
+		return KindPodcast
+	}
+	return ""
+}
+
+// Kind returns the media kind recorded in the stik item, or KindPodcast for
+// items flagged with pcst
+func (m *MP4Metadata) Kind() string {
+	if v := m.Items["stik"]; len(v) >= 1 {
+		switch v[0] {
+		case 2:
+			return KindAudiobook
+		case 21:
+			return KindPodcast
+		}
+	}
+	if v := m.Items["pcst"]; len(v) >= 1 && v[0] != 0 {
+		return KindPodcast
+	}
+	return ""
+}
+
+// readMP4Chapters reads the chapters of a QuickTime chapter track referenced
+// by the sound track, or else those of a Nero chpl box
+func readMP4Chapters(r io.ReaderAt, moov MP4Box, duration float64) []Chapter {
+	if chapters := readMP4ChapterTrack(r, moov); len(chapters) > 0 {
+		return finishChapters(chapters, duration)
+	}
+	chpl, ok := findMP4Box(r, moov.DataOffset(), moov.End(), "udta", "chpl")
+	if !ok {
+		return nil
+	}
+	data, err := readMP4Payload(r, chpl)
+	if err != nil || len(data) < 5 {
+		return nil
+	}
+	pos := 4
+	if data[0] == 1 {
+		pos += 4
+	}
+	if pos >= len(data) {
+		return nil
+	}
+	count := int(data[pos])
+	pos++
+	var chapters []Chapter
+	for i := 0; i < count && pos+9 <= len(data); i++ {
+		// Start times are in units of 100 ns
+		start := float64(binary.BigEndian.Uint64(data[pos:pos+8])) / 1e7
+		length := int(data[pos+8])
+		pos += 9
+		if pos+length > len(data) {
+			break
+		}
+		chapters = append(chapters, Chapter{Title: DecodeText(data[pos : pos+length]), Start: start})
+		pos += length
+	}
+	return finishChapters(chapters, duration)
+}
+
+// readMP4ChapterTrack reads the text samples of the track that the sound
+// track's tref/chap box points to
+func readMP4ChapterTrack(r io.ReaderAt, moov MP4Box) []Chapter {
+	sound, ok := findMP4SoundTrack(r, moov)
+	if !ok {
+		return nil
+	}
+	chap, ok := findMP4Box(r, sound.DataOffset(), sound.End(), "tref", "chap")
+	if !ok {
+		return nil
+	}
+	refs, err := readMP4Payload(r, chap)
+	if err != nil || len(refs) < 4 {
+		return nil
+	}
+	trak, ok := findMP4Track(r, moov, binary.BigEndian.Uint32(refs[0:4]))
+	if !ok {
+		return nil
+	}
+
+	mdhd, ok := findMP4Box(r, trak.DataOffset(), trak.End(), "mdia", "mdhd")
+	if !ok {
+		return nil
+	}
+	data, err := readMP4Payload(r, mdhd)
+	if err != nil {
+		return nil
+	}
+	timescale, _ := parseMP4TimeHeader(data)
+	if timescale == 0 {
+		return nil
+	}
+	samples, err := readMP4Samples(r, trak)
+	if err != nil {
+		return nil
+	}
+
+	chapters := make([]Chapter, 0, len(samples))
+	for _, s := range samples {
+		chapter := Chapter{Start: float64(s.time) / float64(timescale)}
+		if s.size >= 2 && s.size <= 64*1024 {
+			text := make([]byte, s.size)
+			if err := readFull(r, text, s.offset); err == nil {
+				n := min(int(binary.BigEndian.Uint16(text[0:2])), len(text)-2)
+				chapter.Title = decodeMP4Text(text[2 : 2+n])
+			}
+		}
+		chapters = append(chapters, chapter)
+	}
+	return chapters
+}
+
+// decodeMP4Text decodes a text sample, which is UTF-8 or UTF-16 with a byte
+// order mark
+func decodeMP4Text(b []byte) string {
+	if len(b) >= 2 && (b[0] == 0xFE && b[1] == 0xFF || b[0] == 0xFF && b[1] == 0xFE) {
+		order := binary.ByteOrder(binary.BigEndian)
+		if b[0] == 0xFF {
+			order = binary.LittleEndian
+		}
+		units := make([]uint16, 0, len(b)/2)
+		for i := 2; i+1 < len(b); i += 2 {
+			units = append(units, order.Uint16(b[i:]))
+		}
+		return string(utf16.Decode(units))
+	}
+	return DecodeText(b)
+}
+
+// findMP4Track returns the trak with the given track ID
+func findMP4Track(r io.ReaderAt, moov MP4Box, id uint32) (MP4Box, bool) {
+	boxes, _ := readMP4Boxes(r, moov.DataOffset(), moov.End())
+	for _, trak := range boxes {
+		if trak.Type != "trak" {
+			continue
+		}
+		tkhd, ok := findMP4Box(r, trak.DataOffset(), trak.End(), "tkhd")
+		if !ok {
+			continue
+		}
+		data, err := readMP4Payload(r, tkhd)
+		if err != nil || len(data) < 24 {
+			continue
+		}
+		pos := 12
+		if data[0] == 1 {
+			pos = 20
+		}
+		if binary.BigEndian.Uint32(data[pos:pos+4]) == id {
+			return trak, true
+		}
+	}
+	return MP4Box{}, false
+}
+
+// mp4Sample locates a sample of a track
+type mp4Sample struct {
+	// time is the decoding time in the track's timescale
+	time   uint64
+	offset int64
+	size   int64
+}
+
+// maxMP4Samples bounds the sample tables read for chapter tracks
+const maxMP4Samples = 10000
+
+// readMP4Samples resolves the sample table of a track into sample times,
+// offsets and sizes
+func readMP4Samples(r io.ReaderAt, trak MP4Box) ([]mp4Sample, error) {
+	stbl, ok := findMP4Box(r, trak.DataOffset(), trak.End(), "mdia", "minf", "stbl")
+	if !ok {
+		return nil, fmt.Errorf("mp4: no sample table")
+	}
+	table := func(name string) []byte {
+		box, ok := findMP4Box(r, stbl.DataOffset(), stbl.End(), name)
+		if !ok {
+			return nil
+		}
+		data, err := readMP4Payload(r, box)
+		if err != nil || len(data) < 8 {
+			return nil
+		}
+		return data
+	}
+	stts, stsz, stsc := table("stts"), table("stsz"), table("stsc")
+	if stts == nil || stsz == nil || len(stsz) < 12 || stsc == nil {
+		return nil, fmt.Errorf("mp4: incomplete sample table")
+	}
+
+	var samples []mp4Sample
+	var t uint64
+	for i, n := 0, int(binary.BigEndian.Uint32(stts[4:8])); i < n && 8+8*i+8 <= len(stts); i++ {
+		count := binary.BigEndian.Uint32(stts[8+8*i:])
+		delta := uint64(binary.BigEndian.Uint32(stts[12+8*i:]))
+		for j := uint32(0); j < count && len(samples) < maxMP4Samples; j++ {
+			samples = append(samples, mp4Sample{time: t})
+			t += delta
+		}
+	}
+
+	fixed := int64(binary.BigEndian.Uint32(stsz[4:8]))
+	count := min(int(binary.BigEndian.Uint32(stsz[8:12])), len(samples))
+	samples = samples[:count]
+	for i := range samples {
+		samples[i].size = fixed
+		if fixed == 0 && 12+4*i+4 <= len(stsz) {
+			samples[i].size = int64(binary.BigEndian.Uint32(stsz[12+4*i:]))
+		}
+	}
+
+	var chunks []int64
+	if stco := table("stco"); stco != nil {
+		for i, n := 0, int(binary.BigEndian.Uint32(stco[4:8])); i < n && 8+4*i+4 <= len(stco); i++ {
+			chunks = append(chunks, int64(binary.BigEndian.Uint32(stco[8+4*i:])))
+		}
+	} else if co64 := table("co64"); co64 != nil {
+		for i, n := 0, int(binary.BigEndian.Uint32(co64[4:8])); i < n && 8+8*i+8 <= len(co64); i++ {
+			chunks = append(chunks, int64(binary.BigEndian.Uint64(co64[8+8*i:])))
+		}
+	}
+
+	// stsc runs give the samples per chunk from their first chunk onwards
+	type run struct{ first, perChunk int }
+	var runs []run
+	for i, n := 0, int(binary.BigEndian.Uint32(stsc[4:8])); i < n && 8+12*i+12 <= len(stsc); i++ {
+		runs = append(runs, run{int(binary.BigEndian.Uint32(stsc[8+12*i:])), int(binary.BigEndian.Uint32(stsc[12+12*i:]))})
+	}
+	sample := 0
+	for chunk := range chunks {
+		perChunk := 0
+		for _, r := range runs {
+			if r.first <= chunk+1 {
+				perChunk = r.perChunk
+			}
+		}
+		offset := chunks[chunk]
+		for j := 0; j < perChunk && sample < len(samples); j++ {
+			samples[sample].offset = offset
+			offset += samples[sample].size
+			sample++
+		}
+	}
+	return samples[:sample], nil
+}
@@ -0,0 +1,124 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// id3Chapter builds a CHAP frame body with a TIT2 sub-frame in v2.4 layout
+func id3Chapter(id string, startMS, endMS uint32, title string) []byte {
+	data := append([]byte(id), 0)
+	data = binary.BigEndian.AppendUint32(data, startMS)
+	data = binary.BigEndian.AppendUint32(data, endMS)
+	data = append(data, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF)
+	if title != "" {
+		text := append([]byte{3}, title...)
+		data = append(data, "TIT2"...)
+		data = append(data, putSyncsafe(len(text))...)
+		data = append(data, 0, 0)
+		data = append(data, text...)
+	}
+	return data
+}
+
+// id3TOC builds a CTOC frame body listing children
+func id3TOC(id string, topLevel bool, children ...string) []byte {
+	flags := byte(0x01)
+	if topLevel {
+		flags |= 0x02
+	}
+	data := append([]byte(id), 0, flags, byte(len(children)))
+	for _, c := range children {
+		data = append(append(data, c...), 0)
+	}
+	return data
+}
+
+func TestID3Chapters(t *testing.T) {
+	tag := &ID3Tag{Version: 4, Frames: []ID3Frame{
+		{ID: "CHAP", Data: id3Chapter("ch2", 60000, 0, "Second")},
+		{ID: "CHAP", Data: id3Chapter("ch1", 0, 60000, "First")},
+		{ID: "CHAP", Data: id3Chapter("ch3", 90000, 95000, "")},
+		{ID: "CHAP", Data: id3Chapter("ad", 120000, 125000, "Advert")},
+		{ID: "CTOC", Data: id3TOC("toc", true, "ch1", "part")},
+		{ID: "CTOC", Data: id3TOC("part", false, "ch2", "ch3")},
+	}}
+	want := []Chapter{
+		{Title: "First", Start: 0, End: 60},
+		{Title: "Second", Start: 60, End: 90},
+		{Title: "Chapter 3", Start: 90, End: 180},
+	}
+	if got := tag.Chapters(180); !reflect.DeepEqual(got, want) {
+		t.Errorf("Chapters = %+v, want %+v", got, want)
+	}
+
+	// Without a table of contents every chapter counts
+	tag.Frames = tag.Frames[:4]
+	if got := tag.Chapters(0); len(got) != 4 || got[3] != (Chapter{Title: "Advert", Start: 120, End: 125}) {
+		t.Errorf("Chapters without CTOC = %+v", got)
+	}
+	if got := (&ID3Tag{}).Chapters(100); got != nil {
+		t.Errorf("Chapters of an untagged file = %+v", got)
+	}
+}
+
+func TestMP4NeroChapters(t *testing.T) {
+	chpl := []byte{1, 0, 0, 0, 0, 0, 0, 0, 2}
+	for _, c := range []struct {
+		start uint64
+		title string
+	}{{0, "Intro"}, {305_000_000, "Part Two"}} {
+		chpl = binary.BigEndian.AppendUint64(chpl, c.start)
+		chpl = append(chpl, byte(len(c.title)))
+		chpl = append(chpl, c.title...)
+	}
+	file := mp4Box("moov", mp4Box("udta", mp4Box("chpl", chpl)))
+	r := bytes.NewReader(file)
+	moov, _ := findMP4Box(r, 0, int64(len(file)), "moov")
+
+	want := []Chapter{{Title: "Intro", Start: 0, End: 30.5}, {Title: "Part Two", Start: 30.5, End: 60}}
+	if got := readMP4Chapters(r, moov, 60); !reflect.DeepEqual(got, want) {
+		t.Errorf("chapters = %+v, want %+v", got, want)
+	}
+}
+
+func TestDecodeMP4Text(t *testing.T) {
+	tests := map[string]string{
+		"plain":              "plain",
+		"\xfe\xff\x00H\x00i": "Hi",
+		"\xff\xfeH\x00i\x00": "Hi",
+		"caf\xc3\xa9":        "café",
+	}
+	for in, want := range tests {
+		if got := decodeMP4Text([]byte(in)); got != want {
+			t.Errorf("decodeMP4Text(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestKind(t *testing.T) {
+	if kind := (&ID3Tag{Frames: []ID3Frame{{ID: "PCST", Data: []byte{0, 0, 0, 1}}}}).Kind(); kind != KindPodcast {
+		t.Errorf("ID3 kind = %q", kind)
+	}
+	tests := []struct {
+		items map[string][]byte
+		want  string
+	}{
+		{map[string][]byte{"stik": {2}}, KindAudiobook},
+		{map[string][]byte{"stik": {21}}, KindPodcast},
+		{map[string][]byte{"pcst": {1}}, KindPodcast},
+		{map[string][]byte{"stik": {1}, "pcst": {0}}, ""},
+	}
+	for _, tt := range tests {
+		if got := (&MP4Metadata{Items: tt.items}).Kind(); got != tt.want {
+			t.Errorf("MP4 kind of %v = %q, want %q", tt.items, got, tt.want)
+		}
+	}
+	for genre, want := range map[string]string{" Audiobook ": KindAudiobook, "Hörbuch": KindAudiobook, "Podcasts": KindPodcast, "Jazz": ""} {
+		if got := kindFromGenre(genre); got != want {
+			t.Errorf("kindFromGenre(%q) = %q, want %q", genre, got, want)
+		}
+	}
+}
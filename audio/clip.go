@@ -0,0 +1,338 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// ErrEmptyClip is returned when a clip would contain no audio, usually
+// because it starts past the end of the stream
+var ErrEmptyClip = errors.New("clip contains no audio")
+
+// Clip is a cut of an audio stream, planned from its frame headers. The
+// audio is copied from the source as the clip is written, so long clips are
+// not held in memory.
+type Clip struct {
+	// Start is the source time of the clip's first sample, in seconds
+	Start float64
+	// Size is the length of the clip in bytes
+	Size int64
+
+	r io.ReaderAt
+	// header is written before the audio
+	header []byte
+	// parts are the source ranges of the audio
+	parts []clipPart
+	// trailer is written after the audio
+	trailer []byte
+	// flacFrames is the number of FLAC frames to renumber as they are copied
+	// from the single part, or 0 for other formats
+	flacFrames int
+}
+
+// clipPart is a range of the source
+type clipPart struct {
+	offset, length int64
+}
+
+// add appends a source range, extending the last one when they meet
+func (c *Clip) add(offset, length int64) {
+	if n := len(c.parts); n > 0 && c.parts[n-1].offset+c.parts[n-1].length == offset {
+		c.parts[n-1].length += length
+	} else {
+		c.parts = append(c.parts, clipPart{offset, length})
+	}
+	c.Size += length
+}
+
+// WriteTo writes the clip to w
+func (c *Clip) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(c.header)
+	written := int64(n)
+	if err != nil {
+		return written, err
+	}
+	if c.flacFrames > 0 {
+		n, err := c.writeFLACFrames(w)
+		return written + n, err
+	}
+	for _, part := range c.parts {
+		n, err := io.Copy(w, io.NewSectionReader(c.r, part.offset, part.length))
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if n < part.length {
+			return written, io.ErrUnexpectedEOF
+		}
+	}
+	n, err = w.Write(c.trailer)
+	return written + int64(n), err
+}
+
+// Bytes returns the whole clip
+func (c *Clip) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(int(c.Size))
+	if _, err := c.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ClipMP3 cuts the frames between the byte offsets from and to, which must
+// be frame boundaries, and prepends a Xing header describing the clip so
+// players report the right duration. start is the time of the frame at from.
+func ClipMP3(r io.ReaderAt, info *MP3Info, from, to int64, start float64) (*Clip, error) {
+	to = min(to, info.AudioEnd)
+	if from >= to {
+		return nil, ErrEmptyClip
+	}
+
+	frames := 0
+	var first []byte
+	var header [4]byte
+	for pos := from; pos+4 <= to; {
+		if err := readFull(r, header[:], pos); err != nil {
+			return nil, err
+		}
+		h, ok := ParseMP3FrameHeader(header[:])
+		if !ok || !h.compatible(info.Header) {
+			pos++
+			continue
+		}
+		if first == nil {
+			first = append([]byte(nil), header[:]...)
+		}
+		frames++
+		pos += int64(h.FrameSize())
+	}
+	if frames == 0 {
+		return nil, ErrEmptyClip
+	}
+
+	c := &Clip{Start: start, r: r, header: mp3XingFrame(first, frames, int(to-from))}
+	c.Size = int64(len(c.header))
+	c.add(from, to-from)
+	return c, nil
+}
+
+// mp3XingFrame builds a silent frame carrying a Xing header for a stream
+// of the given number of frames and bytes, not counting the new frame
+func mp3XingFrame(header []byte, frames, bytes int) []byte {
+	b := []byte{header[0], header[1] | 0x1, header[2] &^ 0x2, header[3]}
+	h, _ := ParseMP3FrameHeader(b)
+	offset := 4 + h.sideInfoSize()
+
+	// Raise the bitrate until the frame is large enough for the header
+	for h.FrameSize() < offset+16 && b[2]>>4 < 14 {
+		b[2] += 0x10
+		h, _ = ParseMP3FrameHeader(b)
+	}
+
+	frame := make([]byte, h.FrameSize())
+	copy(frame, b)
+	copy(frame[offset:], "Xing")
+	binary.BigEndian.PutUint32(frame[offset+4:], 0x3)
+	binary.BigEndian.PutUint32(frame[offset+8:], uint32(frames))
+	binary.BigEndian.PutUint32(frame[offset+12:], uint32(len(frame)+bytes))
+	return frame
+}
+
+// ClipADTS cuts the ADTS frames overlapping [start, start+duration) seconds
+func ClipADTS(r io.ReaderAt, size int64, start, duration float64) (*Clip, error) {
+	end := size
+	if _, ok := readID3v1(r, size); ok {
+		end -= 128
+	}
+	first, err := syncADTS(r, skipID3v2(r), end, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Clip{r: r}
+	var sample, from, to int64
+	err = walkADTS(r, first, end, func(off int64, h ADTSHeader) bool {
+		if sample == 0 {
+			from = int64(math.Floor(start * float64(h.SampleRate)))
+			to = int64(math.Ceil((start + duration) * float64(h.SampleRate)))
+		}
+		if sample >= to {
+			return false
+		}
+		if sample+int64(h.Samples) > from {
+			if c.Size == 0 {
+				c.Start = float64(sample) / float64(h.SampleRate)
+			}
+			c.add(off, int64(h.FrameLength))
+		}
+		sample += int64(h.Samples)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if c.Size == 0 {
+		return nil, ErrEmptyClip
+	}
+	return c, nil
+}
+
+// ClipFLAC cuts the FLAC frames overlapping [start, start+duration)
+// seconds into a new stream. Frames are renumbered from zero and the
+// STREAMINFO block is rewritten for the clip; Vorbis comments are kept.
+func ClipFLAC(r io.ReaderAt, size int64, start, duration float64) (*Clip, error) {
+	meta, err := ReadFLACMetadata(r)
+	if err != nil {
+		return nil, err
+	}
+	rate := float64(meta.Info.SampleRate)
+	if rate == 0 {
+		return nil, errors.New("flac: unknown sample rate")
+	}
+	from := int64(math.Floor(start * rate))
+	to := int64(math.Ceil((start + duration) * rate))
+
+	// Jump to the last seek point before the clip
+	offset, sample := meta.AudioStart, int64(0)
+	if table, ok := meta.Block(FLACSeekTable); ok {
+		for p := table.Data; len(p) >= 18; p = p[18:] {
+			pointSample := binary.BigEndian.Uint64(p)
+			if pointSample == math.MaxUint64 || int64(pointSample) > from {
+				break
+			}
+			sample = int64(pointSample)
+			offset = meta.AudioStart + int64(binary.BigEndian.Uint64(p[8:]))
+		}
+	}
+
+	// Find the frames and their size once renumbered; they are read again
+	// as the clip is written
+	c := &Clip{r: r}
+	fr := newFLACFrameReader(io.NewSectionReader(r, offset, size-offset))
+	var clipSamples, framesSize int64
+	for sample < to {
+		frame, h, err := fr.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if sample+int64(h.blockSize) > from {
+			if c.flacFrames == 0 {
+				c.Start = float64(sample) / rate
+				c.parts = []clipPart{{offset + fr.offset, size - offset - fr.offset}}
+			}
+			n := uint64(c.flacFrames)
+			if h.variable {
+				n = uint64(clipSamples)
+			}
+			// renumber keeps everything but the coded number
+			framesSize += int64(len(frame) - h.length + 4 + len(appendFLACNumber(nil, n)) + len(h.tail) + 1)
+			c.flacFrames++
+			clipSamples += int64(h.blockSize)
+		}
+		sample += int64(h.blockSize)
+	}
+	if clipSamples == 0 {
+		return nil, ErrEmptyClip
+	}
+
+	// STREAMINFO with the clip length, and unknown frame sizes and MD5
+	info := append([]byte(nil), meta.Blocks[0].Data[:34]...)
+	clear(info[4:10])
+	packed := binary.BigEndian.Uint64(info[10:18])
+	binary.BigEndian.PutUint64(info[10:18], packed&^0xFFFFFFFFF|uint64(clipSamples))
+	clear(info[18:34])
+
+	blocks := []FLACBlock{{Type: FLACStreamInfo, Data: info}}
+	if comments, ok := meta.Block(FLACVorbisComment); ok {
+		blocks = append(blocks, comments)
+	}
+
+	c.header = []byte("fLaC")
+	for i, b := range blocks {
+		blockType := b.Type
+		if i == len(blocks)-1 {
+			blockType |= 0x80
+		}
+		n := len(b.Data)
+		c.header = append(c.header, blockType, byte(n>>16), byte(n>>8), byte(n))
+		c.header = append(c.header, b.Data...)
+	}
+	c.Size = int64(len(c.header)) + framesSize
+	return c, nil
+}
+
+// writeFLACFrames copies the frames of a FLAC clip, renumbering them
+func (c *Clip) writeFLACFrames(w io.Writer) (int64, error) {
+	part := c.parts[0]
+	fr := newFLACFrameReader(io.NewSectionReader(c.r, part.offset, part.length))
+	var written, samples int64
+	for i := 0; i < c.flacFrames; i++ {
+		frame, h, err := fr.next()
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return written, err
+		}
+		n := uint64(i)
+		if h.variable {
+			n = uint64(samples)
+		}
+		m, err := w.Write(h.renumber(frame, n))
+		written += int64(m)
+		if err != nil {
+			return written, err
+		}
+		samples += int64(h.blockSize)
+	}
+	return written, nil
+}
+
+// ClipWAV cuts the sample frames within [start, start+duration) under a
+// new RIFF header with the original fmt chunk
+func ClipWAV(r io.ReaderAt, size int64, start, duration float64) (*Clip, error) {
+	info, err := readWAVInfo(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, err
+	}
+	if info.BlockAlign == 0 || info.SampleRate == 0 {
+		return nil, errors.New("wav: invalid fmt chunk")
+	}
+	rate := float64(info.SampleRate)
+	frames := min(info.DataSize, size-info.DataOffset) / int64(info.BlockAlign)
+	from := min(int64(math.Floor(start*rate)), frames)
+	to := min(int64(math.Ceil((start+duration)*rate)), frames)
+	if from >= to {
+		return nil, ErrEmptyClip
+	}
+
+	dataSize := (to - from) * int64(info.BlockAlign)
+	fmtSize := len(info.fmtChunk)
+	headerSize := 12 + 8 + fmtSize + fmtSize%2 + 8
+	header := make([]byte, headerSize)
+
+	copy(header, "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(int64(headerSize)+dataSize+dataSize%2-8))
+	copy(header[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(header[16:], uint32(fmtSize))
+	copy(header[20:], info.fmtChunk)
+	pos := 20 + fmtSize + fmtSize%2
+	copy(header[pos:], "data")
+	binary.LittleEndian.PutUint32(header[pos+4:], uint32(dataSize))
+
+	c := &Clip{Start: float64(from) / rate, r: r, header: header, Size: int64(headerSize)}
+	c.add(info.DataOffset+from*int64(info.BlockAlign), dataSize)
+	if dataSize%2 == 1 {
+		// Odd-sized chunks end with a pad byte
+		c.trailer = []byte{0}
+		c.Size++
+	}
+	return c, nil
+}
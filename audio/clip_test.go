@@ -0,0 +1,183 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+)
+
+// checkClip writes a clip and checks that it matches its planned size
+func checkClip(t *testing.T, c *Clip) []byte {
+	t.Helper()
+	data, err := c.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(data)) != c.Size {
+		t.Fatalf("clip is %d bytes, planned %d", len(data), c.Size)
+	}
+	return data
+}
+
+func TestClipMP3(t *testing.T) {
+	header := []byte{0xFF, 0xFB, 0x90, 0x00} // MPEG-1 Layer III, 128 kbit/s, 44.1 kHz
+	h, _ := ParseMP3FrameHeader(header)
+	size := h.FrameSize()
+	var stream []byte
+	for i := range 10 {
+		frame := make([]byte, size)
+		copy(frame, header)
+		frame[4] = byte(i)
+		stream = append(stream, frame...)
+	}
+	info, err := ReadMP3Info(bytes.NewReader(stream), int64(len(stream)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from, to := int64(2*size), int64(5*size)
+	c, err := ClipMP3(bytes.NewReader(stream), info, from, to, 2*h.Duration())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Start != 2*h.Duration() {
+		t.Errorf("Start = %v, want %v", c.Start, 2*h.Duration())
+	}
+	data := checkClip(t, c)
+	xing := data[:len(data)-int(to-from)]
+	if !bytes.Contains(xing, []byte("Xing")) || binary.BigEndian.Uint32(xing[4+32+8:]) != 3 {
+		t.Error("clip does not start with a Xing frame counting 3 frames")
+	}
+	if !bytes.Equal(data[len(xing):], stream[from:to]) {
+		t.Error("clip frames differ from the source")
+	}
+
+	if _, err := ClipMP3(bytes.NewReader(stream), info, to, to, 0); err != ErrEmptyClip {
+		t.Errorf("empty range err = %v, want ErrEmptyClip", err)
+	}
+}
+
+// flacTestStream builds a stream of fixed 4096 sample frames at 44.1 kHz,
+// numbered from first
+func flacTestStream(first uint64, frames int) []byte {
+	info := make([]byte, 34)
+	binary.BigEndian.PutUint16(info[0:], 4096)
+	binary.BigEndian.PutUint16(info[2:], 4096)
+	binary.BigEndian.PutUint64(info[10:], 44100<<44|1<<41|15<<36|uint64(frames*4096))
+	stream := append([]byte("fLaC"), 0x80, 0, 0, 34)
+	stream = append(stream, info...)
+	for i := range frames {
+		stream = append(stream, flacTestFrame(0xF8, 0xC9, 0x08, first+uint64(i), nil, bytes.Repeat([]byte{byte(i)}, 50))...)
+	}
+	return stream
+}
+
+func TestClipFLAC(t *testing.T) {
+	// Frames numbered from 120 take two bytes from 128 on and one once
+	// renumbered, so the clip is smaller than the frames it copies
+	stream := flacTestStream(120, 20)
+	frame := 4096.0 / 44100
+	c, err := ClipFLAC(bytes.NewReader(stream), int64(len(stream)), 5.5*frame, 4*frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(c.Start-5*frame) > 1e-9 {
+		t.Errorf("Start = %v, want the start of frame 5 (%v)", c.Start, 5*frame)
+	}
+	data := checkClip(t, c)
+
+	meta, err := ReadFLACMetadata(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Info.TotalSamples != 5*4096 {
+		t.Errorf("STREAMINFO has %d samples, want %d", meta.Info.TotalSamples, 5*4096)
+	}
+	fr := newFLACFrameReader(bytes.NewReader(data[meta.AudioStart:]))
+	for i := range 5 {
+		frame, h, err := fr.next()
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if h.number != uint64(i) || frame[len(frame)-3] != byte(5+i) {
+			t.Errorf("frame %d is numbered %d with payload %d", i, h.number, frame[len(frame)-3])
+		}
+	}
+	if _, _, err := fr.next(); err != io.EOF {
+		t.Errorf("after the clip err = %v, want EOF", err)
+	}
+}
+
+func TestClipWAV(t *testing.T) {
+	// 8-bit mono at 1024 Hz, so odd lengths need a pad byte
+	fmtChunk := make([]byte, 16)
+	binary.LittleEndian.PutUint16(fmtChunk[0:], 1)
+	binary.LittleEndian.PutUint16(fmtChunk[2:], 1)
+	binary.LittleEndian.PutUint32(fmtChunk[4:], 1024)
+	binary.LittleEndian.PutUint32(fmtChunk[8:], 1024)
+	binary.LittleEndian.PutUint16(fmtChunk[12:], 1)
+	binary.LittleEndian.PutUint16(fmtChunk[14:], 8)
+	samples := make([]byte, 100)
+	for i := range samples {
+		samples[i] = byte(i)
+	}
+	stream := []byte("RIFF\x00\x00\x00\x00WAVEfmt \x10\x00\x00\x00")
+	stream = append(stream, fmtChunk...)
+	stream = append(stream, "data\x64\x00\x00\x00"...)
+	stream = append(stream, samples...)
+
+	c, err := ClipWAV(bytes.NewReader(stream), int64(len(stream)), 8.0/1024, 5.0/1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Start != 8.0/1024 {
+		t.Errorf("Start = %v, want %v", c.Start, 8.0/1024)
+	}
+	data := checkClip(t, c)
+	if got := binary.LittleEndian.Uint32(data[4:]); int(got) != len(data)-8 {
+		t.Errorf("RIFF size = %d, want %d", got, len(data)-8)
+	}
+	// Samples 8 to 12 and the pad byte
+	if tail := data[len(data)-6:]; !bytes.Equal(tail, []byte{8, 9, 10, 11, 12, 0}) {
+		t.Errorf("clip ends % x", tail)
+	}
+}
+
+// adtsTestStream builds AAC-LC stereo frames at 44.1 kHz of 100 bytes each,
+// the payload of frame i filled with i
+func adtsTestStream(frames int) []byte {
+	var stream []byte
+	for i := range frames {
+		frame := bytes.Repeat([]byte{byte(i)}, 100)
+		copy(frame, []byte{0xFF, 0xF1, 0x50, 0x80, 100 >> 3, 100 << 5 & 0xE0, 0xFC})
+		stream = append(stream, frame...)
+	}
+	return stream
+}
+
+func TestClipADTS(t *testing.T) {
+	stream := adtsTestStream(10)
+	info, err := ReadADTSInfo(bytes.NewReader(stream), int64(len(stream)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := 1024.0 / 44100
+	if d := info.Duration(); d < 9.99*frame || d > 10.01*frame {
+		t.Errorf("duration = %v, want 10 frames", d)
+	}
+
+	// Frames 2 to 4 overlap the clip
+	c, err := ClipADTS(bytes.NewReader(stream), int64(len(stream)), 2.5*frame, 2*frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clip := checkClip(t, c); !bytes.Equal(clip, stream[200:500]) {
+		t.Errorf("clip is %d bytes ending with frame %d, want frames 2 to 4", len(clip), clip[len(clip)-1])
+	}
+
+	if _, err := ClipADTS(bytes.NewReader(stream), int64(len(stream)), 20*frame, frame); err != ErrEmptyClip {
+		t.Errorf("clip past the end err = %v, want ErrEmptyClip", err)
+	}
+}
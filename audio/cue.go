@@ -0,0 +1,253 @@
+package audio
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// cueFramesPerSecond is the resolution of cue sheet times (CD frames)
+const cueFramesPerSecond = 75
+
+// CueSheet is a parsed cue sheet describing the tracks of one or more files
+type CueSheet struct {
+	Title      string
+	Performer  string
+	Genre      string
+	Comment    string
+	Year       int
+	DiscNumber int
+	Files      []CueFile
+}
+
+// CueFile is a FILE entry and the tracks it contains
+type CueFile struct {
+	// Name is the file name as written in the sheet, relative to the sheet
+	Name   string
+	Type   string
+	Tracks []CueTrack
+}
+
+// CueTrack is a TRACK entry
+type CueTrack struct {
+	Number    int
+	Title     string
+	Performer string
+	ISRC      string
+	// Start is the INDEX 01 time in seconds from the start of the file
+	Start float64
+	// Pregap is the INDEX 00 time, or equal to Start when there is none
+	Pregap float64
+}
+
+// ParseCueSheet reads a cue sheet. Sheets that are not valid UTF-8 are
+// decoded as Latin-1, which is what most rippers write.
+func ParseCueSheet(r io.Reader) (*CueSheet, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	sheet := &CueSheet{}
+	var file *CueFile
+	var track *CueTrack
+	hasPregap := false
+
+	// finishTrack defaults the pregap of the track being closed
+	finishTrack := func() {
+		if track != nil && !hasPregap {
+			track.Pregap = track.Start
+		}
+		track, hasPregap = nil, false
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(DecodeText(data)))
+	for line := 1; scanner.Scan(); line++ {
+		fields := cueFields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		arg := func(i int) string {
+			if i < len(fields) {
+				return fields[i]
+			}
+			return ""
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "FILE":
+			finishTrack()
+			if arg(1) == "" {
+				return nil, fmt.Errorf("cue: line %d: FILE without a name", line)
+			}
+			sheet.Files = append(sheet.Files, CueFile{Name: arg(1), Type: strings.ToUpper(arg(2))})
+			file = &sheet.Files[len(sheet.Files)-1]
+
+		case "TRACK":
+			finishTrack()
+			if file == nil {
+				return nil, fmt.Errorf("cue: line %d: TRACK before FILE", line)
+			}
+			n, err := strconv.Atoi(arg(1))
+			if err != nil {
+				return nil, fmt.Errorf("cue: line %d: invalid track number %q", line, arg(1))
+			}
+			file.Tracks = append(file.Tracks, CueTrack{Number: n, Start: -1})
+			track = &file.Tracks[len(file.Tracks)-1]
+
+		case "INDEX":
+			if track == nil {
+				return nil, fmt.Errorf("cue: line %d: INDEX outside a track", line)
+			}
+			t, err := parseCueTime(arg(2))
+			if err != nil {
+				return nil, fmt.Errorf("cue: line %d: %w", line, err)
+			}
+			switch arg(1) {
+			case "00":
+				track.Pregap, hasPregap = t, true
+			case "01":
+				track.Start = t
+			}
+
+		case "TITLE":
+			if track != nil {
+				track.Title = arg(1)
+			} else {
+				sheet.Title = arg(1)
+			}
+
+		case "PERFORMER":
+			if track != nil {
+				track.Performer = arg(1)
+			} else {
+				sheet.Performer = arg(1)
+			}
+
+		case "ISRC":
+			if track != nil {
+				track.ISRC = arg(1)
+			}
+
+		case "REM":
+			switch strings.ToUpper(arg(1)) {
+			case "GENRE":
+				sheet.Genre = arg(2)
+			case "DATE":
+				sheet.Year = parseYear(arg(2))
+			case "COMMENT":
+				sheet.Comment = arg(2)
+			case "DISCNUMBER":
+				sheet.DiscNumber, _ = strconv.Atoi(arg(2))
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	finishTrack()
+
+	for _, f := range sheet.Files {
+		for _, t := range f.Tracks {
+			if t.Start < 0 {
+				return nil, fmt.Errorf("cue: track %d has no INDEX 01", t.Number)
+			}
+		}
+	}
+	if sheet.TrackCount() == 0 {
+		return nil, errors.New("cue: no tracks")
+	}
+	return sheet, nil
+}
+
+// TrackCount returns the number of tracks across all files
+func (s *CueSheet) TrackCount() int {
+	n := 0
+	for _, f := range s.Files {
+		n += len(f.Tracks)
+	}
+	return n
+}
+
+// TrackTags returns the tags of a track. Album-level fields missing from
+// the sheet are taken from fallback, usually the tags of the file itself.
+func (s *CueSheet) TrackTags(t CueTrack, fallback Tags) Tags {
+	tags := Tags{
+		Title:       t.Title,
+		Artist:      t.Performer,
+		Album:       s.Title,
+		AlbumArtist: s.Performer,
+		Genre:       s.Genre,
+		Comment:     s.Comment,
+		Year:        s.Year,
+		TrackNumber: t.Number,
+		TrackTotal:  s.TrackCount(),
+		DiscNumber:  s.DiscNumber,
+	}
+	tags.merge(Tags{
+		Album:       fallback.Album,
+		AlbumArtist: fallback.AlbumArtist,
+		Genre:       fallback.Genre,
+		Year:        fallback.Year,
+		DiscNumber:  fallback.DiscNumber,
+		DiscTotal:   fallback.DiscTotal,
+	})
+	if tags.Artist == "" {
+		tags.Artist = tags.AlbumArtist
+	}
+	if tags.Artist == "" {
+		tags.Artist = fallback.Artist
+	}
+	return tags
+}
+
+// parseCueTime parses an "mm:ss:ff" time, where ff counts CD frames
+func parseCueTime(s string) (float64, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	var v [3]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid time %q", s)
+		}
+		v[i] = n
+	}
+	if v[1] >= 60 || v[2] >= cueFramesPerSecond {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	frames := (v[0]*60+v[1])*cueFramesPerSecond + v[2]
+	return float64(frames) / cueFramesPerSecond, nil
+}
+
+// cueFields splits a line on whitespace, keeping double-quoted strings
+// together without their quotes
+func cueFields(line string) []string {
+	var fields []string
+	var b strings.Builder
+	inQuotes, inField := false, false
+	for _, c := range strings.TrimSpace(line) {
+		switch {
+		case c == '"':
+			inQuotes = !inQuotes
+			inField = true
+		case (c == ' ' || c == '\t') && !inQuotes:
+			if inField {
+				fields = append(fields, b.String())
+				b.Reset()
+				inField = false
+			}
+		default:
+			b.WriteRune(c)
+			inField = true
+		}
+	}
+	if inField {
+		fields = append(fields, b.String())
+	}
+	return fields
+}
@@ -0,0 +1,74 @@
+package audio
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCueSheet(t *testing.T) {
+	sheet, err := ParseCueSheet(strings.NewReader("\xef\xbb\xbf" + `REM GENRE Jazz
+REM DATE 1959
+PERFORMER "Miles Davis"
+TITLE "Kind of Blue"
+FILE "album.flac" WAVE
+  TRACK 01 AUDIO
+    TITLE "So What"
+    ISRC USSM15900113
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    TITLE "Freddie Freeloader"
+    PERFORMER "Miles Davis Sextet"
+    INDEX 00 09:20:30
+    INDEX 01 09:22:00
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sheet.Title != "Kind of Blue" || sheet.Genre != "Jazz" || sheet.Year != 1959 || sheet.TrackCount() != 2 {
+		t.Fatalf("sheet = %+v", sheet)
+	}
+	f := sheet.Files[0]
+	if f.Name != "album.flac" || f.Type != "WAVE" {
+		t.Errorf("file %q of type %q", f.Name, f.Type)
+	}
+	first, second := f.Tracks[0], f.Tracks[1]
+	if first.Start != 0 || first.Pregap != 0 || first.ISRC != "USSM15900113" {
+		t.Errorf("track 1 = %+v", first)
+	}
+	if second.Start != 562 || second.Pregap != 560.4 {
+		t.Errorf("track 2 starts at %v after a pregap from %v", second.Start, second.Pregap)
+	}
+
+	tags := sheet.TrackTags(second, Tags{Album: "Other", DiscTotal: 1})
+	if tags.Title != "Freddie Freeloader" || tags.Artist != "Miles Davis Sextet" || tags.AlbumArtist != "Miles Davis" ||
+		tags.Album != "Kind of Blue" || tags.TrackNumber != 2 || tags.TrackTotal != 2 || tags.DiscTotal != 1 {
+		t.Errorf("tags = %+v", tags)
+	}
+	if tags := sheet.TrackTags(first, Tags{}); tags.Artist != "Miles Davis" {
+		t.Errorf("track 1 artist = %q, want the album performer", tags.Artist)
+	}
+}
+
+func TestParseCueSheetLatin1(t *testing.T) {
+	sheet, err := ParseCueSheet(strings.NewReader("FILE \"a.wav\" WAVE\nTRACK 1 AUDIO\nTITLE \"Caf\xe9\"\nINDEX 01 00:01:00\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if title := sheet.Files[0].Tracks[0].Title; title != "Café" {
+		t.Errorf("title = %q", title)
+	}
+}
+
+func TestParseCueSheetInvalid(t *testing.T) {
+	for _, sheet := range []string{
+		"",
+		"TRACK 01 AUDIO\nINDEX 01 00:00:00\n",
+		"FILE \"a.wav\" WAVE\nTRACK 01 AUDIO\nTITLE \"No index\"\n",
+		"FILE \"a.wav\" WAVE\nTRACK 01 AUDIO\nINDEX 01 00:60:00\n",
+		"FILE \"a.wav\" WAVE\nTRACK 01 AUDIO\nINDEX 01 00:00:75\n",
+	} {
+		if _, err := ParseCueSheet(strings.NewReader(sheet)); err == nil {
+			t.Errorf("accepted %q", sheet)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package audio
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/hajimehoshi/go-mp3"
+	"github.com/mewkiz/flac"
+)
+
+// ErrUnsupportedFormat is returned when no decoder exists for a format
+var ErrUnsupportedFormat = errors.New("unsupported audio format")
+
+// Decoder produces interleaved PCM samples normalised to [-1, 1]
+type Decoder interface {
+	SampleRate() int
+	Channels() int
+	// Read fills buf with interleaved samples and returns how many were
+	// written. It returns io.EOF once the stream is exhausted.
+	Read(buf []float32) (int, error)
+}
+
+// CanDecode reports whether NewDecoder supports a format
+func CanDecode(format Format) bool {
+	return format == FormatMP3 || format == FormatFLAC || format == FormatWAV
+}
+
+// NewDecoder returns a pure Go decoder for the given format.
+// The reader is consumed sequentially; it is never seeked.
+func NewDecoder(r io.Reader, format Format) (Decoder, error) {
+	// Hide any Seek method so decoders don't pre-scan the whole object
+	br := bufio.NewReaderSize(struct{ io.Reader }{r}, 64*1024)
+
+	switch format {
+	case FormatMP3:
+		return newMP3Decoder(br)
+	case FormatFLAC:
+		return newFLACDecoder(br)
+	case FormatWAV:
+		return newWAVDecoder(br)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedFormat, format)
+	}
+}
+
+// mp3Decoder adapts go-mp3, which always yields 16-bit stereo. Mono
+// streams come out with both channels alike, so only the left one is kept.
+type mp3Decoder struct {
+	dec      *mp3.Decoder
+	channels int
+	raw      []byte
+}
+
+func newMP3Decoder(br *bufio.Reader) (*mp3Decoder, error) {
+	channels, err := peekMP3Channels(br)
+	if err != nil {
+		return nil, err
+	}
+	dec, err := mp3.NewDecoder(br)
+	if err != nil {
+		return nil, fmt.Errorf("mp3: %w", err)
+	}
+	return &mp3Decoder{dec: dec, channels: channels}, nil
+}
+
+// peekMP3Channels skips any leading ID3v2 tags and reads the channel count
+// from the first frame header without consuming the frame
+func peekMP3Channels(br *bufio.Reader) (int, error) {
+	for {
+		header, err := br.Peek(10)
+		if err != nil || string(header[:3]) != "ID3" || header[3] == 0xFF || header[4] == 0xFF {
+			break
+		}
+		size := syncsafe(header[6:10]) + 10
+		if header[3] == 4 && header[5]&0x10 != 0 {
+			size += 10 // footer
+		}
+		if _, err := br.Discard(int(size)); err != nil {
+			return 0, fmt.Errorf("mp3: %w", err)
+		}
+	}
+	buf, _ := br.Peek(br.Size())
+	_, h, ok := findMP3Frame(buf)
+	if !ok {
+		return 0, errors.New("mp3: no frame sync found")
+	}
+	return h.Channels(), nil
+}
+
+func (d *mp3Decoder) SampleRate() int { return d.dec.SampleRate() }
+func (d *mp3Decoder) Channels() int   { return d.channels }
+
+func (d *mp3Decoder) Read(buf []float32) (int, error) {
+	// Bytes per output sample: one 16-bit sample, or a stereo pair for mono
+	stride := 2
+	if d.channels == 1 {
+		stride = 4
+	}
+	if cap(d.raw) < len(buf)*stride {
+		d.raw = make([]byte, len(buf)*stride)
+	}
+	raw := d.raw[:len(buf)*stride]
+
+	n, err := io.ReadFull(d.dec, raw)
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	samples := n / stride
+	for i := 0; i < samples; i++ {
+		j := i * stride
+		buf[i] = float32(int16(uint16(raw[j])|uint16(raw[j+1])<<8)) / 32768
+	}
+	if samples == 0 && err == nil {
+		err = io.EOF
+	}
+	return samples, err
+}
+
+// flacDecoder adapts mewkiz/flac frames to interleaved samples
+type flacDecoder struct {
+	stream  *flac.Stream
+	scale   float32
+	block   []float32
+	pending []float32
+}
+
+func newFLACDecoder(r io.Reader) (*flacDecoder, error) {
+	stream, err := flac.New(r)
+	if err != nil {
+		return nil, fmt.Errorf("flac: %w", err)
+	}
+	return &flacDecoder{
+		stream: stream,
+		scale:  float32(int64(1) << (stream.Info.BitsPerSample - 1)),
+	}, nil
+}
+
+func (d *flacDecoder) SampleRate() int { return int(d.stream.Info.SampleRate) }
+func (d *flacDecoder) Channels() int   { return int(d.stream.Info.NChannels) }
+
+func (d *flacDecoder) Read(buf []float32) (int, error) {
+	for len(d.pending) == 0 {
+		frame, err := d.stream.ParseNext()
+		if err != nil {
+			return 0, err
+		}
+		channels := len(frame.Subframes)
+		blockSize := int(frame.BlockSize)
+		d.block = d.block[:0]
+		for i := 0; i < blockSize; i++ {
+			for ch := 0; ch < channels; ch++ {
+				d.block = append(d.block, float32(frame.Subframes[ch].Samples[i])/d.scale)
+			}
+		}
+		d.pending = d.block
+	}
+
+	n := copy(buf, d.pending)
+	d.pending = d.pending[n:]
+	return n, nil
+}
@@ -0,0 +1,63 @@
+package audio
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// silentMP3 returns MPEG-1 Layer III frames at 128 kbps and 44.1 kHz whose
+// zeroed side info decodes to silence
+func silentMP3(frames int, mono bool) []byte {
+	header := []byte{0xFF, 0xFB, 0x90, 0x44}
+	if mono {
+		header[3] = 0xC4
+	}
+	var b bytes.Buffer
+	for range frames {
+		frame := make([]byte, 417)
+		copy(frame, header)
+		b.Write(frame)
+	}
+	return b.Bytes()
+}
+
+func TestMP3DecoderChannels(t *testing.T) {
+	const frames = 8
+	tag := id3v24Tag(id3v24Frame("TIT2", 0, 0, "\x03x"))
+	cases := []struct {
+		name     string
+		data     []byte
+		channels int
+	}{
+		{"stereo", silentMP3(frames, false), 2},
+		{"mono", silentMP3(frames, true), 1},
+		{"mono after ID3v2", append(tag, silentMP3(frames, true)...), 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dec, err := NewDecoder(bytes.NewReader(c.data), FormatMP3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dec.Channels() != c.channels || dec.SampleRate() != 44100 {
+				t.Fatalf("Channels = %d, SampleRate = %d; want %d, 44100", dec.Channels(), dec.SampleRate(), c.channels)
+			}
+			total := 0
+			buf := make([]float32, 1000)
+			for {
+				n, err := dec.Read(buf)
+				total += n
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if want := frames * 1152 * c.channels; total != want {
+				t.Errorf("decoded %d samples, want %d", total, want)
+			}
+		})
+	}
+}
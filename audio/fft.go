@@ -0,0 +1,53 @@
+package audio
+
+import (
+	"math"
+	"math/bits"
+	"math/cmplx"
+)
+
+// fft performs an in-place iterative radix-2 FFT. len(x) must be a power of two.
+func fft(x []complex128) {
+	n := len(x)
+	if n <= 1 {
+		return
+	}
+	shift := 64 - uint(bits.Len(uint(n-1)))
+
+	// Bit-reversal permutation
+	for i := 0; i < n; i++ {
+		j := int(bits.Reverse64(uint64(i)) >> shift)
+		if j > i {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		half := size / 2
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < half; k++ {
+				a := x[start+k]
+				b := x[start+k+half] * w
+				x[start+k] = a + b
+				x[start+k+half] = a - b
+				w *= step
+			}
+		}
+	}
+}
+
+// hannWindow returns a Hann window of length n
+func hannWindow(n int) []float64 {
+	w := make([]float64, n)
+	for i := range w {
+		w[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n-1))
+	}
+	return w
+}
+
+// isPowerOfTwo reports whether n is a positive power of two
+func isPowerOfTwo(n int) bool {
+	return n > 0 && n&(n-1) == 0
+}
@@ -0,0 +1,250 @@
+package audio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math/bits"
+)
+
+var (
+	flacCRC8Table  = makeCRC8Table(0x07)
+	flacCRC16Table = makeCRC16Table(0x8005)
+)
+
+func makeCRC8Table(poly byte) [256]byte {
+	var table [256]byte
+	for i := range table {
+		c := byte(i)
+		for range 8 {
+			if c&0x80 != 0 {
+				c = c<<1 ^ poly
+			} else {
+				c <<= 1
+			}
+		}
+		table[i] = c
+	}
+	return table
+}
+
+func makeCRC16Table(poly uint16) [256]uint16 {
+	var table [256]uint16
+	for i := range table {
+		c := uint16(i) << 8
+		for range 8 {
+			if c&0x8000 != 0 {
+				c = c<<1 ^ poly
+			} else {
+				c <<= 1
+			}
+		}
+		table[i] = c
+	}
+	return table
+}
+
+func flacCRC8(b []byte) byte {
+	var crc byte
+	for _, v := range b {
+		crc = flacCRC8Table[crc^v]
+	}
+	return crc
+}
+
+func flacCRC16(crc uint16, b ...byte) uint16 {
+	for _, v := range b {
+		crc = crc<<8 ^ flacCRC16Table[byte(crc>>8)^v]
+	}
+	return crc
+}
+
+// flacFrameHeader is a decoded FLAC frame header
+type flacFrameHeader struct {
+	// variable is set for variable block size streams, whose frames are
+	// numbered by sample rather than by frame
+	variable  bool
+	number    uint64
+	blockSize int
+	// tail holds the explicit block size and sample rate bytes that follow
+	// the coded number
+	tail []byte
+	// length is the header size including its CRC-8
+	length int
+}
+
+// parseFLACFrameHeader decodes a frame header and verifies its CRC-8
+func parseFLACFrameHeader(b []byte) (flacFrameHeader, bool) {
+	var h flacFrameHeader
+	if len(b) < 6 || b[0] != 0xFF || b[1]&0xFE != 0xF8 || b[3]&0x1 != 0 {
+		return h, false
+	}
+	h.variable = b[1]&0x1 != 0
+
+	sizeCode := int(b[2] >> 4)
+	rateCode := int(b[2] & 0xF)
+	if sizeCode == 0 || rateCode == 15 || b[3]>>4 >= 11 {
+		return h, false
+	}
+
+	number, n, ok := readFLACNumber(b[4:])
+	if !ok {
+		return h, false
+	}
+	h.number = number
+	pos := 4 + n
+
+	tailLen := 0
+	switch sizeCode {
+	case 6:
+		tailLen++
+	case 7:
+		tailLen += 2
+	}
+	switch rateCode {
+	case 12:
+		tailLen++
+	case 13, 14:
+		tailLen += 2
+	}
+	if len(b) < pos+tailLen+1 {
+		return h, false
+	}
+	h.tail = append([]byte(nil), b[pos:pos+tailLen]...)
+
+	switch {
+	case sizeCode == 1:
+		h.blockSize = 192
+	case sizeCode <= 5:
+		h.blockSize = 576 << (sizeCode - 2)
+	case sizeCode == 6:
+		h.blockSize = int(h.tail[0]) + 1
+	case sizeCode == 7:
+		h.blockSize = int(binary.BigEndian.Uint16(h.tail)) + 1
+	default:
+		h.blockSize = 256 << (sizeCode - 8)
+	}
+
+	pos += tailLen
+	if flacCRC8(b[:pos]) != b[pos] {
+		return h, false
+	}
+	h.length = pos + 1
+	return h, true
+}
+
+// readFLACNumber decodes the UTF-8 style coded frame or sample number
+func readFLACNumber(b []byte) (uint64, int, bool) {
+	if len(b) == 0 {
+		return 0, 0, false
+	}
+	c := b[0]
+	if c&0x80 == 0 {
+		return uint64(c), 1, true
+	}
+	n := bits.LeadingZeros8(^c)
+	if n < 2 || n > 7 || len(b) < n {
+		return 0, 0, false
+	}
+	v := uint64(c & (0x7F >> n))
+	for _, cont := range b[1:n] {
+		if cont&0xC0 != 0x80 {
+			return 0, 0, false
+		}
+		v = v<<6 | uint64(cont&0x3F)
+	}
+	return v, n, true
+}
+
+// appendFLACNumber encodes a frame or sample number in the UTF-8 style coding
+func appendFLACNumber(b []byte, v uint64) []byte {
+	if v < 0x80 {
+		return append(b, byte(v))
+	}
+	n := 2
+	for n < 7 && v >= 1<<(5*n+1) {
+		n++
+	}
+	b = append(b, byte(0xFF)<<(8-n)|byte(v>>(6*(n-1))))
+	for i := n - 2; i >= 0; i-- {
+		b = append(b, 0x80|byte(v>>(6*i))&0x3F)
+	}
+	return b
+}
+
+// renumber returns a copy of a frame with a new frame or sample number,
+// recomputing both checksums
+func (h flacFrameHeader) renumber(frame []byte, number uint64) []byte {
+	out := make([]byte, 0, len(frame)+8)
+	out = append(out, frame[:4]...)
+	out = appendFLACNumber(out, number)
+	out = append(out, h.tail...)
+	out = append(out, flacCRC8(out))
+	out = append(out, frame[h.length:len(frame)-2]...)
+	return binary.BigEndian.AppendUint16(out, flacCRC16(0, out...))
+}
+
+// flacFrameReader splits a FLAC stream into frames without decoding them
+type flacFrameReader struct {
+	br *bufio.Reader
+	// pos counts the bytes consumed and offset is where the last frame
+	// returned by next starts
+	pos, offset int64
+}
+
+func newFLACFrameReader(r io.Reader) *flacFrameReader {
+	return &flacFrameReader{br: bufio.NewReaderSize(r, 64*1024)}
+}
+
+// next returns the next frame. Frames are delimited by a valid CRC-16
+// followed by another valid frame header or the end of the stream.
+func (fr *flacFrameReader) next() ([]byte, flacFrameHeader, error) {
+	var h flacFrameHeader
+	for {
+		head, _ := fr.br.Peek(16)
+		if len(head) < 6 {
+			return nil, h, io.EOF
+		}
+		var ok bool
+		if h, ok = parseFLACFrameHeader(head); ok {
+			break
+		}
+		// Skip damaged data up to the next frame
+		fr.br.Discard(1)
+		fr.pos++
+	}
+	fr.offset = fr.pos
+
+	frame := make([]byte, 0, 8*1024)
+	var crc uint16
+	end := 0
+	for {
+		c, err := fr.br.ReadByte()
+		if err == nil {
+			fr.pos++
+		}
+		if err == io.EOF {
+			if end == 0 {
+				return nil, h, io.ErrUnexpectedEOF
+			}
+			// Drop trailing data such as an ID3v1 tag
+			return frame[:end], h, nil
+		}
+		if err != nil {
+			return nil, h, err
+		}
+		frame = append(frame, c)
+		crc = flacCRC16(crc, c)
+
+		if crc == 0 && len(frame) > h.length+2 {
+			end = len(frame)
+			next, _ := fr.br.Peek(16)
+			if len(next) == 0 {
+				return frame, h, nil
+			}
+			if _, ok := parseFLACFrameHeader(next); ok {
+				return frame, h, nil
+			}
+		}
+	}
+}
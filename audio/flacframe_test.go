@@ -0,0 +1,163 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+func TestFLACCRC(t *testing.T) {
+	check := []byte("123456789")
+	if got := flacCRC8(check); got != 0xF4 {
+		t.Errorf("flacCRC8 = %#x, want 0xf4", got)
+	}
+	if got := flacCRC16(0, check...); got != 0xFEE8 {
+		t.Errorf("flacCRC16 = %#x, want 0xfee8", got)
+	}
+	// Incremental updates match a single pass
+	if got := flacCRC16(flacCRC16(0, check[:4]...), check[4:]...); got != 0xFEE8 {
+		t.Errorf("incremental flacCRC16 = %#x, want 0xfee8", got)
+	}
+}
+
+func TestFLACNumberRoundTrip(t *testing.T) {
+	tests := []struct {
+		v    uint64
+		size int
+	}{
+		{0, 1},
+		{0x7F, 1},
+		{0x80, 2},
+		{0x7FF, 2},
+		{0x800, 3},
+		{0xFFFF, 3},
+		{0x10000, 4},
+		{0x1FFFFF, 4},
+		{0x200000, 5},
+		{0x3FFFFFF, 5},
+		{0x4000000, 6},
+		{0x7FFFFFFF, 6},
+		{0x80000000, 7},
+		{1<<36 - 1, 7},
+	}
+	for _, tt := range tests {
+		b := appendFLACNumber(nil, tt.v)
+		if len(b) != tt.size {
+			t.Errorf("appendFLACNumber(%#x) is %d bytes, want %d", tt.v, len(b), tt.size)
+		}
+		v, n, ok := readFLACNumber(b)
+		if !ok || v != tt.v || n != len(b) {
+			t.Errorf("readFLACNumber(% x) = %#x, %d, %v, want %#x", b, v, n, ok, tt.v)
+		}
+	}
+}
+
+func TestReadFLACNumberInvalid(t *testing.T) {
+	for _, b := range [][]byte{
+		nil,
+		{0x80},       // continuation byte first
+		{0xC2},       // truncated
+		{0xC2, 0x41}, // bad continuation
+		{0xFF, 0x80}, // 8 byte coding
+	} {
+		if _, _, ok := readFLACNumber(b); ok {
+			t.Errorf("readFLACNumber(% x) succeeded", b)
+		}
+	}
+}
+
+// flacTestFrame builds a frame with the given header bytes 1 to 3, number,
+// header tail and payload, with valid checksums
+func flacTestFrame(b1, b2, b3 byte, number uint64, tail, payload []byte) []byte {
+	frame := []byte{0xFF, b1, b2, b3}
+	frame = appendFLACNumber(frame, number)
+	frame = append(frame, tail...)
+	frame = append(frame, flacCRC8(frame))
+	frame = append(frame, payload...)
+	return binary.BigEndian.AppendUint16(frame, flacCRC16(0, frame...))
+}
+
+func TestParseFLACFrameHeader(t *testing.T) {
+	tests := []struct {
+		name      string
+		frame     []byte
+		variable  bool
+		number    uint64
+		blockSize int
+	}{
+		{"fixed 4096", flacTestFrame(0xF8, 0xC9, 0x08, 7, nil, []byte{1, 2, 3}), false, 7, 4096},
+		{"fixed 1152", flacTestFrame(0xF8, 0x39, 0x08, 300, nil, []byte{1}), false, 300, 1152},
+		{"8 bit size", flacTestFrame(0xF8, 0x69, 0x08, 1, []byte{0xFF}, []byte{1}), false, 1, 256},
+		{"16 bit size and rate", flacTestFrame(0xF8, 0x7D, 0x08, 2, []byte{0x01, 0x00, 0xAC, 0x44}, []byte{1}), false, 2, 257},
+		{"variable", flacTestFrame(0xF9, 0xC9, 0x08, 1<<33, nil, []byte{9}), true, 1 << 33, 4096},
+	}
+	for _, tt := range tests {
+		h, ok := parseFLACFrameHeader(tt.frame)
+		if !ok {
+			t.Errorf("%s: header not parsed", tt.name)
+			continue
+		}
+		if h.variable != tt.variable || h.number != tt.number || h.blockSize != tt.blockSize {
+			t.Errorf("%s: got variable %v, number %d, block size %d", tt.name, h.variable, h.number, h.blockSize)
+		}
+	}
+
+	frame := flacTestFrame(0xF8, 0xC9, 0x08, 7, nil, nil)
+	frame[4] ^= 1
+	if _, ok := parseFLACFrameHeader(frame); ok {
+		t.Error("header with a bad CRC-8 was accepted")
+	}
+}
+
+func TestFLACRenumber(t *testing.T) {
+	payload := bytes.Repeat([]byte{0xA5, 0x5A}, 100)
+	for _, tt := range []struct {
+		from, to uint64
+		tail     []byte
+		b2       byte
+	}{
+		{1000, 0, nil, 0xC9},
+		{5, 70000, nil, 0xC9},
+		{3, 4, []byte{0xFF}, 0x69},
+	} {
+		frame := flacTestFrame(0xF8, tt.b2, 0x08, tt.from, tt.tail, payload)
+		h, ok := parseFLACFrameHeader(frame)
+		if !ok {
+			t.Fatal("header not parsed")
+		}
+		out := h.renumber(frame, tt.to)
+
+		got, ok := parseFLACFrameHeader(out)
+		if !ok || got.number != tt.to || got.blockSize != h.blockSize {
+			t.Errorf("renumber %d to %d: header %+v, ok %v", tt.from, tt.to, got, ok)
+		}
+		if crc := flacCRC16(0, out...); crc != 0 {
+			t.Errorf("renumber %d to %d: CRC-16 residue %#x", tt.from, tt.to, crc)
+		}
+		if !bytes.Equal(out[got.length:len(out)-2], payload) {
+			t.Errorf("renumber %d to %d: payload changed", tt.from, tt.to)
+		}
+	}
+}
+
+func TestFLACFrameReader(t *testing.T) {
+	first := flacTestFrame(0xF8, 0xC9, 0x08, 0, nil, []byte("first frame"))
+	second := flacTestFrame(0xF8, 0xC9, 0x08, 1, nil, []byte("second"))
+	stream := append(append([]byte("junk"), first...), second...)
+	stream = append(stream, "TAG"...)
+
+	fr := newFLACFrameReader(bytes.NewReader(stream))
+	for i, want := range [][]byte{first, second} {
+		frame, h, err := fr.next()
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if !bytes.Equal(frame, want) || h.number != uint64(i) {
+			t.Errorf("frame %d = % x, want % x", i, frame, want)
+		}
+	}
+	if _, _, err := fr.next(); err != io.EOF {
+		t.Errorf("after the last frame err = %v, want EOF", err)
+	}
+}
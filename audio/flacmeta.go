@@ -0,0 +1,135 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// FLAC metadata block types
+const (
+	FLACStreamInfo    byte = 0
+	FLACPadding       byte = 1
+	FLACApplication   byte = 2
+	FLACSeekTable     byte = 3
+	FLACVorbisComment byte = 4
+	FLACCueSheet      byte = 5
+	FLACPicture       byte = 6
+)
+
+// FLACBlock is a raw FLAC metadata block
+type FLACBlock struct {
+	Type byte
+	Data []byte
+}
+
+// FLACInfo is the decoded STREAMINFO block
+type FLACInfo struct {
+	MinBlockSize  int
+	MaxBlockSize  int
+	MinFrameSize  int
+	MaxFrameSize  int
+	SampleRate    int
+	Channels      int
+	BitsPerSample int
+	TotalSamples  int64
+}
+
+// FLACMetadata is the metadata header of a FLAC stream
+type FLACMetadata struct {
+	// Start is the offset of the "fLaC" marker (after any ID3v2 tag)
+	Start int64
+	// AudioStart is the offset of the first audio frame
+	AudioStart int64
+	Info       FLACInfo
+	Blocks     []FLACBlock
+}
+
+// ReadFLACMetadata reads all metadata blocks of a FLAC stream
+func ReadFLACMetadata(r io.ReaderAt) (*FLACMetadata, error) {
+	start := skipID3v2(r)
+	var marker [4]byte
+	if err := readFull(r, marker[:], start); err != nil {
+		return nil, fmt.Errorf("flac: %w", err)
+	}
+	if string(marker[:]) != "fLaC" {
+		return nil, errors.New("flac: missing stream marker")
+	}
+
+	meta := &FLACMetadata{Start: start}
+	offset := start + 4
+	for {
+		var header [4]byte
+		if err := readFull(r, header[:], offset); err != nil {
+			return nil, fmt.Errorf("flac: %w", err)
+		}
+		last := header[0]&0x80 != 0
+		length := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+		block := FLACBlock{Type: header[0] & 0x7F, Data: make([]byte, length)}
+		if err := readFull(r, block.Data, offset+4); err != nil {
+			return nil, fmt.Errorf("flac: %w", err)
+		}
+		meta.Blocks = append(meta.Blocks, block)
+		offset += 4 + int64(length)
+
+		if block.Type == FLACStreamInfo {
+			info, err := parseFLACInfo(block.Data)
+			if err != nil {
+				return nil, err
+			}
+			meta.Info = info
+		}
+		if last {
+			break
+		}
+	}
+	if len(meta.Blocks) == 0 || meta.Blocks[0].Type != FLACStreamInfo {
+		return nil, errors.New("flac: STREAMINFO must be the first block")
+	}
+	meta.AudioStart = offset
+	return meta, nil
+}
+
+func parseFLACInfo(b []byte) (FLACInfo, error) {
+	if len(b) < 34 {
+		return FLACInfo{}, errors.New("flac: short STREAMINFO")
+	}
+	packed := binary.BigEndian.Uint64(b[10:18])
+	return FLACInfo{
+		MinBlockSize:  int(binary.BigEndian.Uint16(b[0:2])),
+		MaxBlockSize:  int(binary.BigEndian.Uint16(b[2:4])),
+		MinFrameSize:  int(b[4])<<16 | int(b[5])<<8 | int(b[6]),
+		MaxFrameSize:  int(b[7])<<16 | int(b[8])<<8 | int(b[9]),
+		SampleRate:    int(packed >> 44),
+		Channels:      int(packed>>41&0x7) + 1,
+		BitsPerSample: int(packed>>36&0x1F) + 1,
+		TotalSamples:  int64(packed & 0xFFFFFFFFF),
+	}, nil
+}
+
+// Block returns the first metadata block of the given type
+func (m *FLACMetadata) Block(blockType byte) (FLACBlock, bool) {
+	for _, b := range m.Blocks {
+		if b.Type == blockType {
+			return b, true
+		}
+	}
+	return FLACBlock{}, false
+}
+
+// Comments returns the Vorbis comments, or an empty set when there are none
+func (m *FLACMetadata) Comments() VorbisComments {
+	if b, ok := m.Block(FLACVorbisComment); ok {
+		return parseVorbisComments(b.Data)
+	}
+	return VorbisComments{}
+}
+
+// Duration returns the stream length in seconds, or 0 when unknown
+func (m *FLACMetadata) Duration() float64 {
+	if m.Info.SampleRate == 0 {
+		return 0
+	}
+	return float64(m.Info.TotalSamples) / float64(m.Info.SampleRate)
+}
@@ -0,0 +1,39 @@
+package audio
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Format identifies an audio container/codec by its file extension
+type Format string
+
+const (
+	FormatUnknown Format = ""
+	FormatMP3     Format = "mp3"
+	FormatWAV     Format = "wav"
+	FormatFLAC    Format = "flac"
+	FormatOGG     Format = "ogg"
+	FormatM4A     Format = "m4a"
+	FormatAAC     Format = "aac"
+)
+
+// FormatFromName returns the audio format implied by a file name
+func FormatFromName(name string) Format {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".mp3":
+		return FormatMP3
+	case ".wav":
+		return FormatWAV
+	case ".flac":
+		return FormatFLAC
+	case ".ogg", ".oga":
+		return FormatOGG
+	case ".m4a", ".m4b":
+		return FormatM4A
+	case ".aac":
+		return FormatAAC
+	default:
+		return FormatUnknown
+	}
+}
@@ -0,0 +1,96 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// frameIndexMagic identifies a serialised FrameIndex
+const frameIndexMagic = "FIDX"
+
+// FrameIndex records the offset of every frame in an MP3 or ADTS stream,
+// giving frame-accurate seeking and segmenting without a seek table
+type FrameIndex struct {
+	SampleRate      int
+	SamplesPerFrame int
+	Offsets         []int64
+	// End is the offset just past the last frame
+	End int64
+}
+
+// BuildFrameIndex walks the frame headers of an MP3 or ADTS stream
+func BuildFrameIndex(r io.ReaderAt, size int64, format Format) (*FrameIndex, error) {
+	switch format {
+	case FormatMP3:
+		info, err := ReadMP3Info(r, size)
+		if err != nil {
+			return nil, err
+		}
+		return BuildMP3FrameIndex(r, info)
+	case FormatAAC:
+		return BuildADTSFrameIndex(r, size)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedFormat, format)
+	}
+}
+
+// Duration returns the playing time of the indexed frames in seconds
+func (idx *FrameIndex) Duration() float64 {
+	return float64(len(idx.Offsets)*idx.SamplesPerFrame) / float64(idx.SampleRate)
+}
+
+// Seek returns the offset of the frame playing at t seconds together with
+// the exact start time of that frame
+func (idx *FrameIndex) Seek(t float64) (int64, float64) {
+	i := max(int(t*float64(idx.SampleRate)/float64(idx.SamplesPerFrame)), 0)
+	if i >= len(idx.Offsets) {
+		return idx.End, idx.Duration()
+	}
+	return idx.Offsets[i], float64(i*idx.SamplesPerFrame) / float64(idx.SampleRate)
+}
+
+// MarshalBinary encodes the index as the magic "FIDX", the sample rate,
+// samples per frame and frame count (uint32), the end and first frame
+// offsets (uint64), then the size of every frame but the last (uint32),
+// all little-endian
+func (idx *FrameIndex) MarshalBinary() ([]byte, error) {
+	if len(idx.Offsets) == 0 {
+		return nil, errors.New("empty frame index")
+	}
+	data := make([]byte, 0, 32+4*len(idx.Offsets))
+	data = append(data, frameIndexMagic...)
+	data = binary.LittleEndian.AppendUint32(data, uint32(idx.SampleRate))
+	data = binary.LittleEndian.AppendUint32(data, uint32(idx.SamplesPerFrame))
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(idx.Offsets)))
+	data = binary.LittleEndian.AppendUint64(data, uint64(idx.End))
+	data = binary.LittleEndian.AppendUint64(data, uint64(idx.Offsets[0]))
+	for i := 1; i < len(idx.Offsets); i++ {
+		data = binary.LittleEndian.AppendUint32(data, uint32(idx.Offsets[i]-idx.Offsets[i-1]))
+	}
+	return data, nil
+}
+
+// ParseFrameIndex decodes an index written by MarshalBinary
+func ParseFrameIndex(data []byte) (*FrameIndex, error) {
+	if len(data) < 32 || string(data[:4]) != frameIndexMagic {
+		return nil, errors.New("invalid frame index")
+	}
+	idx := &FrameIndex{
+		SampleRate:      int(binary.LittleEndian.Uint32(data[4:])),
+		SamplesPerFrame: int(binary.LittleEndian.Uint32(data[8:])),
+		End:             int64(binary.LittleEndian.Uint64(data[16:])),
+	}
+	count := int(binary.LittleEndian.Uint32(data[12:]))
+	if count == 0 || idx.SampleRate == 0 || idx.SamplesPerFrame == 0 || len(data) != 32+4*(count-1) {
+		return nil, errors.New("invalid frame index")
+	}
+
+	idx.Offsets = make([]int64, count)
+	idx.Offsets[0] = int64(binary.LittleEndian.Uint64(data[24:]))
+	for i := 1; i < count; i++ {
+		idx.Offsets[i] = idx.Offsets[i-1] + int64(binary.LittleEndian.Uint32(data[32+4*(i-1):]))
+	}
+	return idx, nil
+}
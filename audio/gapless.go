@@ -0,0 +1,92 @@
+package audio
+
+import (
+	"strconv"
+	"strings"
+)
+
+// mp3DecoderDelay is the latency of the MP3 synthesis filterbank, which
+// decoders add on top of the encoder delay
+const mp3DecoderDelay = 529
+
+// Gapless holds the priming and padding samples an encoder added around the
+// audio. Players drop them from the decoded output to play sample-exact and
+// without gaps between consecutive tracks.
+type Gapless struct {
+	// EncoderDelay is the number of samples to drop from the start of the
+	// decoded stream
+	EncoderDelay int `json:"encoderDelay"`
+	// Padding is the number of samples to drop from the end
+	Padding int `json:"padding"`
+	// Samples is the number of valid samples per channel, 0 when unknown
+	Samples int64 `json:"samples,omitempty"`
+}
+
+// parseLAMETag reads the encoder delay and padding from the LAME extension
+// that follows the Xing/Info fields at pos. LAME and ffmpeg both write it.
+func (info *MP3Info) parseLAMETag(frame []byte, pos int) {
+	if len(frame) < pos+24 || !isEncoderName(frame[pos:pos+4]) {
+		return
+	}
+	info.Encoder = strings.TrimRight(string(frame[pos:pos+9]), "\x00 ")
+	// Two 12-bit fields after the version string, revision, lowpass,
+	// ReplayGain, encoding flags and bitrate
+	b := frame[pos+21:]
+	info.EncoderDelay = int(b[0])<<4 | int(b[1]>>4)
+	info.EncoderPadding = int(b[1]&0xF)<<8 | int(b[2])
+}
+
+// isEncoderName reports whether b looks like the start of an encoder
+// version string such as "LAME3.100" or "Lavc60.3"
+func isEncoderName(b []byte) bool {
+	for _, c := range b {
+		if (c < 'A' || c > 'Z') && (c < 'a' || c > 'z') {
+			return false
+		}
+	}
+	return true
+}
+
+// Gapless returns the trimming information from the LAME tag, or nil when
+// the stream has none. Trims are relative to the decoded audio frames that
+// follow the Xing/Info frame and include the MP3 decoder delay.
+func (info *MP3Info) Gapless() *Gapless {
+	if info.EncoderDelay == 0 && info.EncoderPadding == 0 {
+		return nil
+	}
+	g := &Gapless{
+		EncoderDelay: info.EncoderDelay + mp3DecoderDelay,
+		Padding:      max(info.EncoderPadding-mp3DecoderDelay, 0),
+	}
+	if info.Frames > 0 {
+		total := int64(info.Frames)*int64(info.Header.Samples()) - int64(info.EncoderDelay+info.EncoderPadding)
+		g.Samples = max(total, 0)
+	}
+	return g
+}
+
+// Gapless parses the iTunSMPB item that iTunes and most AAC encoders write:
+// hexadecimal fields holding a zero, the encoder delay, the padding and the
+// valid sample count. It returns nil when the item is missing or malformed.
+func (m *MP4Metadata) Gapless() *Gapless {
+	fields := strings.Fields(m.Freeform["iTunSMPB"])
+	if len(fields) < 4 {
+		return nil
+	}
+	var values [3]int64
+	for i := range values {
+		v, err := strconv.ParseInt(fields[i+1], 16, 64)
+		if err != nil || v < 0 {
+			return nil
+		}
+		values[i] = v
+	}
+	if values[0] == 0 && values[1] == 0 && values[2] == 0 {
+		return nil
+	}
+	return &Gapless{
+		EncoderDelay: int(values[0]),
+		Padding:      int(values[1]),
+		Samples:      values[2],
+	}
+}
@@ -0,0 +1,78 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// lameStream builds an Info frame with a LAME tag followed by empty audio
+// frames, all MPEG-1 Layer III at 128 kbit/s and 44.1 kHz
+func lameStream(frames, delay, padding int) []byte {
+	header := []byte{0xFF, 0xFB, 0x90, 0x00}
+	h, _ := ParseMP3FrameHeader(header)
+	info := make([]byte, h.FrameSize())
+	copy(info, header)
+	xing := 4 + h.sideInfoSize()
+	copy(info[xing:], "Info")
+	binary.BigEndian.PutUint32(info[xing+4:], 0x1)
+	binary.BigEndian.PutUint32(info[xing+8:], uint32(frames))
+	lame := info[xing+12:]
+	copy(lame, "LAME3.100")
+	lame[21] = byte(delay >> 4)
+	lame[22] = byte(delay<<4) | byte(padding>>8)
+	lame[23] = byte(padding)
+
+	stream := info
+	for range frames {
+		frame := make([]byte, h.FrameSize())
+		copy(frame, header)
+		stream = append(stream, frame...)
+	}
+	return stream
+}
+
+func TestMP3Gapless(t *testing.T) {
+	stream := lameStream(100, 576, 1200)
+	info, err := ReadMP3Info(bytes.NewReader(stream), int64(len(stream)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Encoder != "LAME3.100" || info.EncoderDelay != 576 || info.EncoderPadding != 1200 {
+		t.Fatalf("encoder %q, delay %d, padding %d", info.Encoder, info.EncoderDelay, info.EncoderPadding)
+	}
+	// Decoders add 529 samples of their own latency, which the end padding
+	// already covers
+	want := Gapless{EncoderDelay: 576 + 529, Padding: 1200 - 529, Samples: 100*1152 - 576 - 1200}
+	if g := info.Gapless(); g == nil || *g != want {
+		t.Errorf("Gapless() = %+v, want %+v", g, want)
+	}
+
+	plain := lameStream(10, 0, 0)
+	info, err = ReadMP3Info(bytes.NewReader(plain), int64(len(plain)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := info.Gapless(); g != nil {
+		t.Errorf("Gapless() = %+v without trims", g)
+	}
+}
+
+func TestMP4Gapless(t *testing.T) {
+	tests := []struct {
+		smpb string
+		want *Gapless
+	}{
+		{" 00000000 00000840 000001CA 00000000003F9E76 00000000 00000000", &Gapless{EncoderDelay: 2112, Padding: 458, Samples: 4169334}},
+		{" 00000000 00000000 00000000 0000000000000000", nil},
+		{" 00000000 00000840", nil},
+		{" 00000000 zz 000001CA 00000000003F9E76", nil},
+	}
+	for _, tt := range tests {
+		m := &MP4Metadata{Freeform: map[string]string{"iTunSMPB": tt.smpb}}
+		got := m.Gapless()
+		if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+			t.Errorf("Gapless(%q) = %+v, want %+v", tt.smpb, got, tt.want)
+		}
+	}
+}
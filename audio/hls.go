@@ -0,0 +1,52 @@
+package audio
+
+import "math"
+
+// Segment is a run of whole frames cut from a packed audio stream
+type Segment struct {
+	Offset   int64
+	Size     int64
+	Start    float64
+	Duration float64
+}
+
+// Segments splits the indexed stream into runs of frames lasting close to
+// target seconds. Only the last segment may be shorter.
+func (idx *FrameIndex) Segments(target float64) []Segment {
+	frameDuration := float64(idx.SamplesPerFrame) / float64(idx.SampleRate)
+	perSegment := max(int(math.Round(target/frameDuration)), 1)
+
+	var segments []Segment
+	for first := 0; first < len(idx.Offsets); first += perSegment {
+		last := min(first+perSegment, len(idx.Offsets))
+		end := idx.End
+		if last < len(idx.Offsets) {
+			end = idx.Offsets[last]
+		}
+		segments = append(segments, Segment{
+			Offset:   idx.Offsets[first],
+			Size:     end - idx.Offsets[first],
+			Start:    float64(first) * frameDuration,
+			Duration: float64(last-first) * frameDuration,
+		})
+	}
+	return segments
+}
+
+// PackedAudioTimestamp returns the ID3v2.4 tag that HLS requires at the
+// start of every packed audio segment: a PRIV frame holding the 33-bit,
+// 90 kHz MPEG-2 timestamp of the segment's first sample
+func PackedAudioTimestamp(start float64) []byte {
+	const owner = "com.apple.streaming.transportStreamTimestamp\x00"
+	ts := uint64(math.Round(start*90000)) & (1<<33 - 1)
+
+	payload := append([]byte(owner), byte(ts>>56), byte(ts>>48), byte(ts>>40), byte(ts>>32),
+		byte(ts>>24), byte(ts>>16), byte(ts>>8), byte(ts))
+
+	frame := append([]byte("PRIV"), putSyncsafe(len(payload))...)
+	frame = append(frame, 0, 0)
+	frame = append(frame, payload...)
+
+	tag := append([]byte{'I', 'D', '3', 4, 0, 0}, putSyncsafe(len(frame))...)
+	return append(tag, frame...)
+}
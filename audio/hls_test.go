@@ -0,0 +1,71 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestBuildADTSFrameIndex(t *testing.T) {
+	stream := adtsTestStream(10)
+	idx, err := BuildFrameIndex(bytes.NewReader(stream), int64(len(stream)), FormatAAC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.Offsets) != 10 || idx.Offsets[3] != 300 || idx.End != 1000 {
+		t.Errorf("%d frames, frame 3 at %d, end %d", len(idx.Offsets), idx.Offsets[3], idx.End)
+	}
+	if idx.SampleRate != 44100 || idx.SamplesPerFrame != 1024 {
+		t.Errorf("%d Hz, %d samples per frame", idx.SampleRate, idx.SamplesPerFrame)
+	}
+}
+
+func TestSegments(t *testing.T) {
+	// 100 frames of 1024 samples at 44.1 kHz last about 2.32 s
+	idx := &FrameIndex{SampleRate: 44100, SamplesPerFrame: 1024, End: 10000}
+	for i := range 100 {
+		idx.Offsets = append(idx.Offsets, int64(i*100))
+	}
+	frame := 1024.0 / 44100
+
+	segments := idx.Segments(0.5)
+	// 0.5 s rounds to 22 frames, so the fifth segment holds the last 12
+	if len(segments) != 5 {
+		t.Fatalf("%d segments, want 5", len(segments))
+	}
+	var size int64
+	for i, s := range segments {
+		if s.Offset != size {
+			t.Errorf("segment %d starts at %d, want %d", i, s.Offset, size)
+		}
+		if math.Abs(s.Start-float64(22*i)*frame) > 1e-9 {
+			t.Errorf("segment %d starts at %v s", i, s.Start)
+		}
+		size += s.Size
+	}
+	if size != idx.End {
+		t.Errorf("segments cover %d bytes, want %d", size, idx.End)
+	}
+	if last := segments[4]; math.Abs(last.Duration-12*frame) > 1e-9 {
+		t.Errorf("last segment lasts %v, want 12 frames", last.Duration)
+	}
+}
+
+func TestPackedAudioTimestamp(t *testing.T) {
+	tag := PackedAudioTimestamp(10)
+	if string(tag[:3]) != "ID3" || tag[3] != 4 {
+		t.Fatalf("tag starts % x", tag[:4])
+	}
+	if size := syncsafe(tag[6:10]); int(size) != len(tag)-10 {
+		t.Errorf("tag size %d, want %d", size, len(tag)-10)
+	}
+	frame := tag[10:]
+	owner := "com.apple.streaming.transportStreamTimestamp\x00"
+	if string(frame[:4]) != "PRIV" || !bytes.HasPrefix(frame[10:], []byte(owner)) {
+		t.Fatalf("frame is %q", frame)
+	}
+	if ts := binary.BigEndian.Uint64(frame[10+len(owner):]); ts != 900000 {
+		t.Errorf("timestamp = %d, want 900000", ts)
+	}
+}
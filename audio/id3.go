@@ -0,0 +1,439 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// ID3Frame is a raw ID3v2 frame. IDs from v2.2 tags are mapped to their
+// four-character v2.3 equivalents where one exists.
+type ID3Frame struct {
+	ID   string
+	Data []byte
+	// raw is the frame as stored in a v2.3 or v2.4 tag, header and flags
+	// included, so that rewriting the tag can copy it unchanged
+	raw []byte
+}
+
+// ID3Tag is a parsed ID3v2 tag
+type ID3Tag struct {
+	Version int
+	// Size is the total number of bytes the tag occupies, header included
+	Size   int64
+	Frames []ID3Frame
+	// opaque holds the compressed and encrypted frames, which are not
+	// decoded but kept when the tag is rewritten
+	opaque []ID3Frame
+	// err reports a frame that overran the tag; the frames after it are
+	// missing
+	err error
+}
+
+// id3v22Frames maps three-character v2.2 frame IDs to v2.3 IDs
+var id3v22Frames = map[string]string{
+	"TT2": "TIT2", "TP1": "TPE1", "TP2": "TPE2", "TAL": "TALB", "TCO": "TCON",
+	"TYE": "TYER", "TRK": "TRCK", "TPA": "TPOS", "COM": "COMM", "ULT": "USLT",
+	"SLT": "SYLT", "PIC": "APIC", "TXX": "TXXX", "TCM": "TCOM",
+}
+
+// id3v2Size returns the size of an ID3v2 tag starting at offset, or 0 when there is none
+func id3v2Size(r io.ReaderAt, offset int64) int64 {
+	var header [10]byte
+	if err := readFull(r, header[:], offset); err != nil {
+		return 0
+	}
+	if string(header[0:3]) != "ID3" || header[3] == 0xFF || header[4] == 0xFF {
+		return 0
+	}
+	size := int64(syncsafe(header[6:10])) + 10
+	if header[3] == 4 && header[5]&0x10 != 0 {
+		size += 10 // footer
+	}
+	return size
+}
+
+// skipID3v2 returns the offset of the first byte after any leading ID3v2 tags
+func skipID3v2(r io.ReaderAt) int64 {
+	var offset int64
+	for {
+		size := id3v2Size(r, offset)
+		if size == 0 {
+			return offset
+		}
+		offset += size
+	}
+}
+
+// ReadID3v2 parses the ID3v2 tag at the start of r
+func ReadID3v2(r io.ReaderAt) (*ID3Tag, error) {
+	size := id3v2Size(r, 0)
+	if size == 0 {
+		return nil, errors.New("id3: no ID3v2 tag")
+	}
+	raw := make([]byte, size)
+	if err := readFull(r, raw, 0); err != nil {
+		return nil, err
+	}
+	return parseID3v2(raw)
+}
+
+func parseID3v2(raw []byte) (*ID3Tag, error) {
+	if len(raw) < 10 {
+		return nil, errors.New("id3: short tag")
+	}
+	version := int(raw[3])
+	flags := raw[5]
+	tagSize := int(syncsafe(raw[6:10]))
+	if tagSize > len(raw)-10 {
+		tagSize = len(raw) - 10
+	}
+	body := raw[10 : 10+tagSize]
+
+	if version < 2 || version > 4 {
+		return nil, errors.New("id3: unsupported version " + strconv.Itoa(version))
+	}
+	if flags&0x80 != 0 && version < 4 {
+		body = removeUnsync(body)
+	}
+	if flags&0x40 != 0 && version >= 3 && len(body) >= 4 {
+		// Skip the extended header
+		extSize := int(binary.BigEndian.Uint32(body[0:4]))
+		if version == 4 {
+			extSize = int(syncsafe(body[0:4]))
+		} else {
+			extSize += 4
+		}
+		if extSize > len(body) {
+			extSize = len(body)
+		}
+		body = body[extSize:]
+	}
+
+	tag := &ID3Tag{Version: version, Size: int64(len(raw))}
+	tag.Frames, tag.opaque, tag.err = parseID3Frames(body, version)
+	return tag, nil
+}
+
+// parseID3Frames parses the frames of a tag body, or those embedded in a
+// CHAP or CTOC frame. Compressed and encrypted frames are returned apart as
+// opaque, and err reports a frame running past the end of the body.
+func parseID3Frames(body []byte, version int) (frames, opaque []ID3Frame, err error) {
+	for len(body) > 0 {
+		var id string
+		var size int
+		var formatFlags byte
+		headerLen := 10
+
+		if version == 2 {
+			if len(body) < 6 {
+				return frames, opaque, checkID3Padding(body)
+			}
+			headerLen = 6
+			id = string(body[0:3])
+			size = int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+			if mapped, ok := id3v22Frames[id]; ok {
+				id = mapped
+			}
+		} else {
+			if len(body) < 10 {
+				return frames, opaque, checkID3Padding(body)
+			}
+			id = string(body[0:4])
+			if version == 4 {
+				size = int(syncsafe(body[4:8]))
+			} else {
+				size = int(binary.BigEndian.Uint32(body[4:8]))
+			}
+			formatFlags = body[9]
+		}
+		if id[0] == 0 {
+			return frames, opaque, checkID3Padding(body)
+		}
+		if headerLen+size > len(body) {
+			return frames, opaque, fmt.Errorf("id3: frame %q overruns the tag", id)
+		}
+
+		frame := ID3Frame{ID: id, Data: body[headerLen : headerLen+size]}
+		if version > 2 {
+			frame.raw = body[:headerLen+size]
+		}
+		body = body[headerLen+size:]
+		if size == 0 {
+			// Empty frames are invalid and carry nothing
+			continue
+		}
+
+		switch version {
+		case 4:
+			if formatFlags&0x0C != 0 {
+				// Compressed or encrypted frames are not decoded
+				opaque = append(opaque, frame)
+				continue
+			}
+			if formatFlags&0x40 != 0 && len(frame.Data) >= 1 {
+				frame.Data = frame.Data[1:]
+			}
+			if formatFlags&0x01 != 0 && len(frame.Data) >= 4 {
+				frame.Data = frame.Data[4:]
+			}
+			if formatFlags&0x02 != 0 {
+				frame.Data = removeUnsync(frame.Data)
+			}
+		case 3:
+			if formatFlags&0xC0 != 0 {
+				opaque = append(opaque, frame)
+				continue
+			}
+			if formatFlags&0x20 != 0 && len(frame.Data) >= 1 {
+				frame.Data = frame.Data[1:]
+			}
+		}
+		frames = append(frames, frame)
+	}
+	return frames, opaque, nil
+}
+
+// checkID3Padding checks that the rest of a tag body is padding, reporting a
+// truncated frame otherwise
+func checkID3Padding(rest []byte) error {
+	for _, b := range rest {
+		if b != 0 {
+			return errors.New("id3: truncated frame")
+		}
+	}
+	return nil
+}
+
+// Frame returns the first frame with the given ID
+func (t *ID3Tag) Frame(id string) (ID3Frame, bool) {
+	for _, f := range t.Frames {
+		if f.ID == id {
+			return f, true
+		}
+	}
+	return ID3Frame{}, false
+}
+
+// Text returns the value of a text information frame such as TIT2
+func (t *ID3Tag) Text(id string) string {
+	f, ok := t.Frame(id)
+	if !ok || len(f.Data) == 0 {
+		return ""
+	}
+	values := splitID3Strings(f.Data[0], f.Data[1:])
+	return strings.Join(values, "; ")
+}
+
+// Comment returns the first COMM frame text without a content descriptor
+func (t *ID3Tag) Comment() string {
+	for _, f := range t.Frames {
+		if f.ID != "COMM" || len(f.Data) < 5 {
+			continue
+		}
+		desc, text := splitID3Pair(f.Data[0], f.Data[4:])
+		if desc == "" {
+			return text
+		}
+	}
+	return ""
+}
+
+// Tags maps the common text frames to Tags
+func (t *ID3Tag) Tags() Tags {
+	tags := Tags{
+		Title:       t.Text("TIT2"),
+		Artist:      t.Text("TPE1"),
+		Album:       t.Text("TALB"),
+		AlbumArtist: t.Text("TPE2"),
+		Genre:       resolveID3Genre(t.Text("TCON")),
+		Comment:     t.Comment(),
+	}
+	tags.TrackNumber, tags.TrackTotal = parseNumberPair(t.Text("TRCK"))
+	tags.DiscNumber, tags.DiscTotal = parseNumberPair(t.Text("TPOS"))
+	year := t.Text("TDRC")
+	if year == "" {
+		year = t.Text("TYER")
+	}
+	tags.Year = parseYear(year)
+	return tags
+}
+
+// decodeID3String decodes text in one of the four ID3v2 encodings
+func decodeID3String(encoding byte, b []byte) string {
+	switch encoding {
+	case 1, 2:
+		bigEndian := encoding == 2
+		if len(b) >= 2 {
+			if b[0] == 0xFF && b[1] == 0xFE {
+				bigEndian, b = false, b[2:]
+			} else if b[0] == 0xFE && b[1] == 0xFF {
+				bigEndian, b = true, b[2:]
+			}
+		}
+		units := make([]uint16, 0, len(b)/2)
+		for i := 0; i+1 < len(b); i += 2 {
+			if bigEndian {
+				units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+			} else {
+				units = append(units, uint16(b[i+1])<<8|uint16(b[i]))
+			}
+		}
+		return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+	case 3:
+		return strings.TrimRight(string(b), "\x00")
+	default:
+		runes := make([]rune, len(b))
+		for i, c := range b {
+			runes[i] = rune(c)
+		}
+		return strings.TrimRight(string(runes), "\x00")
+	}
+}
+
+// id3Terminator returns the string terminator for an encoding
+func id3Terminator(encoding byte) []byte {
+	if encoding == 1 || encoding == 2 {
+		return []byte{0, 0}
+	}
+	return []byte{0}
+}
+
+// indexID3Terminator finds the terminator for an encoding, respecting UTF-16 alignment
+func indexID3Terminator(encoding byte, b []byte) int {
+	term := id3Terminator(encoding)
+	if len(term) == 1 {
+		return bytes.IndexByte(b, 0)
+	}
+	for i := 0; i+1 < len(b); i += 2 {
+		if b[i] == 0 && b[i+1] == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// splitID3Strings splits a null separated list of strings
+func splitID3Strings(encoding byte, b []byte) []string {
+	var values []string
+	for len(b) > 0 {
+		i := indexID3Terminator(encoding, b)
+		if i < 0 {
+			values = append(values, decodeID3String(encoding, b))
+			break
+		}
+		if s := decodeID3String(encoding, b[:i]); s != "" {
+			values = append(values, s)
+		}
+		b = b[i+len(id3Terminator(encoding)):]
+	}
+	return values
+}
+
+// splitID3Pair splits a terminated descriptor from the text that follows it
+func splitID3Pair(encoding byte, b []byte) (string, string) {
+	i := indexID3Terminator(encoding, b)
+	if i < 0 {
+		return decodeID3String(encoding, b), ""
+	}
+	return decodeID3String(encoding, b[:i]), decodeID3String(encoding, b[i+len(id3Terminator(encoding)):])
+}
+
+// resolveID3Genre expands numeric genre references such as "(17)" or "17"
+func resolveID3Genre(genre string) string {
+	g := strings.TrimSpace(genre)
+	if strings.HasPrefix(g, "(") {
+		if end := strings.Index(g, ")"); end > 0 {
+			if rest := strings.TrimSpace(g[end+1:]); rest != "" {
+				return rest
+			}
+			g = g[1:end]
+		}
+	}
+	if n, err := strconv.Atoi(g); err == nil && n >= 0 && n < len(id3v1Genres) {
+		return id3v1Genres[n]
+	}
+	return genre
+}
+
+// readID3v1 parses a trailing 128-byte ID3v1 tag
+func readID3v1(r io.ReaderAt, size int64) (Tags, bool) {
+	if size < 128 {
+		return Tags{}, false
+	}
+	var b [128]byte
+	if err := readFull(r, b[:], size-128); err != nil || string(b[0:3]) != "TAG" {
+		return Tags{}, false
+	}
+	field := func(f []byte) string {
+		if i := bytes.IndexByte(f, 0); i >= 0 {
+			f = f[:i]
+		}
+		return strings.TrimSpace(decodeID3String(0, f))
+	}
+	tags := Tags{
+		Title:   field(b[3:33]),
+		Artist:  field(b[33:63]),
+		Album:   field(b[63:93]),
+		Year:    parseYear(field(b[93:97])),
+		Comment: field(b[97:127]),
+	}
+	// ID3v1.1 stores the track number in the last comment byte
+	if b[125] == 0 && b[126] != 0 {
+		tags.Comment = field(b[97:125])
+		tags.TrackNumber = int(b[126])
+	}
+	if int(b[127]) < len(id3v1Genres) {
+		tags.Genre = id3v1Genres[b[127]]
+	}
+	return tags, true
+}
+
+func syncsafe(b []byte) uint32 {
+	return uint32(b[0]&0x7F)<<21 | uint32(b[1]&0x7F)<<14 | uint32(b[2]&0x7F)<<7 | uint32(b[3]&0x7F)
+}
+
+// putSyncsafe encodes a 28-bit size as four 7-bit bytes
+func putSyncsafe(n int) []byte {
+	return []byte{byte(n>>21) & 0x7F, byte(n>>14) & 0x7F, byte(n>>7) & 0x7F, byte(n) & 0x7F}
+}
+
+// removeUnsync reverses ID3 unsynchronisation (0xFF 0x00 -> 0xFF)
+func removeUnsync(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		out = append(out, b[i])
+		if b[i] == 0xFF && i+1 < len(b) && b[i+1] == 0 {
+			i++
+		}
+	}
+	return out
+}
+
+// id3v1Genres lists the ID3v1 genres including the Winamp extensions
+var id3v1Genres = []string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge", "Hip-Hop",
+	"Jazz", "Metal", "New Age", "Oldies", "Other", "Pop", "R&B", "Rap",
+	"Reggae", "Rock", "Techno", "Industrial", "Alternative", "Ska", "Death Metal", "Pranks",
+	"Soundtrack", "Euro-Techno", "Ambient", "Trip-Hop", "Vocal", "Jazz+Funk", "Fusion", "Trance",
+	"Classical", "Instrumental", "Acid", "House", "Game", "Sound Clip", "Gospel", "Noise",
+	"AlternRock", "Bass", "Soul", "Punk", "Space", "Meditative", "Instrumental Pop", "Instrumental Rock",
+	"Ethnic", "Gothic", "Darkwave", "Techno-Industrial", "Electronic", "Pop-Folk", "Eurodance", "Dream",
+	"Southern Rock", "Comedy", "Cult", "Gangsta", "Top 40", "Christian Rap", "Pop/Funk", "Jungle",
+	"Native American", "Cabaret", "New Wave", "Psychedelic", "Rave", "Showtunes", "Trailer", "Lo-Fi",
+	"Tribal", "Acid Punk", "Acid Jazz", "Polka", "Retro", "Musical", "Rock & Roll", "Hard Rock",
+	"Folk", "Folk-Rock", "National Folk", "Swing", "Fast Fusion", "Bebob", "Latin", "Revival",
+	"Celtic", "Bluegrass", "Avantgarde", "Gothic Rock", "Progressive Rock", "Psychedelic Rock", "Symphonic Rock", "Slow Rock",
+	"Big Band", "Chorus", "Easy Listening", "Acoustic", "Humour", "Speech", "Chanson", "Opera",
+	"Chamber Music", "Sonata", "Symphony", "Booty Bass", "Primus", "Porn Groove", "Satire", "Slow Jam",
+	"Club", "Tango", "Samba", "Folklore", "Ballad", "Power Ballad", "Rhythmic Soul", "Freestyle",
+	"Duet", "Punk Rock", "Drum Solo", "A capella", "Euro-House", "Dance Hall", "Goa", "Drum & Bass",
+	"Club-House", "Hardcore", "Terror", "Indie", "BritPop", "Negerpunk", "Polsk Punk", "Beat",
+	"Christian Gangsta Rap", "Heavy Metal", "Black Metal", "Crossover", "Contemporary Christian", "Christian Rock", "Merengue", "Salsa",
+	"Thrash Metal", "Anime", "JPop", "Synthpop",
+}
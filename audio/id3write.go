@@ -0,0 +1,274 @@
+package audio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// id3Padding is left after rewritten tags so that later edits by other
+// taggers need not move the audio
+const id3Padding = 1024
+
+// rewriteID3File replaces the ID3v2 tag at the start of an MP3 or ADTS
+// stream, adding one when missing, and updates any trailing ID3v1 tag
+func rewriteID3File(r io.ReaderAt, size int64, u TagUpdate) ([]tagPart, error) {
+	tag, err := updatedID3v2(r, u)
+	if err != nil {
+		return nil, err
+	}
+	audioStart := skipID3v2(r)
+
+	var v1 [128]byte
+	if _, ok := readID3v1(r, size); ok {
+		if err := readFull(r, v1[:], size-128); err != nil {
+			return nil, err
+		}
+		updateID3v1(v1[:], u)
+		return []tagPart{bytesPart(tag), sourcePart(r, audioStart, size-128), bytesPart(v1[:])}, nil
+	}
+	return []tagPart{bytesPart(tag), sourcePart(r, audioStart, size)}, nil
+}
+
+// updatedID3v2 returns the file's ID3v2 tag with the update applied, or a
+// new tag when it has none. v2.3 tags stay v2.3; others are written as v2.4.
+// Frames the update does not touch are copied as they are, and tags that
+// cannot be rewritten without losing frames are refused.
+func updatedID3v2(r io.ReaderAt, u TagUpdate) ([]byte, error) {
+	size := id3v2Size(r, 0)
+	if size == 0 {
+		return encodeID3v2(updateID3Frames(nil, 4, u), 4), nil
+	}
+	if skipID3v2(r) != size {
+		return nil, fmt.Errorf("%w: the file has several ID3v2 tags", ErrUnsupportedTag)
+	}
+	tag, err := ReadID3v2(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedTag, err)
+	}
+	if tag.err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedTag, tag.err)
+	}
+
+	version := 4
+	frames := tag.Frames
+	switch tag.Version {
+	case 2:
+		if frames, err = upgradeID3v22(frames); err != nil {
+			return nil, err
+		}
+	case 3:
+		version = 3
+	}
+	frames = updateID3Frames(frames, version, u)
+	return encodeID3v2(append(frames, tag.opaque...), version), nil
+}
+
+// updateID3Frames replaces the frames of the fields set in u
+func updateID3Frames(frames []ID3Frame, version int, u TagUpdate) []ID3Frame {
+	final := u.Apply((&ID3Tag{Frames: frames}).Tags())
+
+	set := func(id, value string) {
+		frames = removeID3Frames(frames, id)
+		if value != "" {
+			frames = append(frames, ID3Frame{ID: id, Data: encodeID3Text(value, version)})
+		}
+	}
+	if u.Title != nil {
+		set("TIT2", final.Title)
+	}
+	if u.Artist != nil {
+		set("TPE1", final.Artist)
+	}
+	if u.Album != nil {
+		set("TALB", final.Album)
+	}
+	if u.AlbumArtist != nil {
+		set("TPE2", final.AlbumArtist)
+	}
+	if u.Genre != nil {
+		set("TCON", final.Genre)
+	}
+	if u.Year != nil {
+		frames = removeID3Frames(frames, "TYER", "TDAT", "TDRC")
+		if version == 3 {
+			set("TYER", yearString(final.Year))
+		} else {
+			set("TDRC", yearString(final.Year))
+		}
+	}
+	if u.TrackNumber != nil || u.TrackTotal != nil {
+		set("TRCK", numberPair(final.TrackNumber, final.TrackTotal))
+	}
+	if u.DiscNumber != nil || u.DiscTotal != nil {
+		set("TPOS", numberPair(final.DiscNumber, final.DiscTotal))
+	}
+	if u.Comment != nil {
+		// Only the comment without a descriptor is the one Tags reports
+		kept := frames[:0]
+		for _, f := range frames {
+			if f.ID == "COMM" && len(f.Data) >= 5 {
+				if desc, _ := splitID3Pair(f.Data[0], f.Data[4:]); desc == "" {
+					continue
+				}
+			}
+			kept = append(kept, f)
+		}
+		frames = kept
+		if final.Comment != "" {
+			text := encodeID3Text(final.Comment, version)
+			data := append([]byte{text[0]}, "eng"...)
+			data = append(data, id3Terminator(text[0])...)
+			frames = append(frames, ID3Frame{ID: "COMM", Data: append(data, text[1:]...)})
+		}
+	}
+	return frames
+}
+
+// removeID3Frames drops every frame with one of the given IDs
+func removeID3Frames(frames []ID3Frame, ids ...string) []ID3Frame {
+	kept := make([]ID3Frame, 0, len(frames))
+	for _, f := range frames {
+		drop := false
+		for _, id := range ids {
+			if f.ID == id {
+				drop = true
+				break
+			}
+		}
+		if !drop {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// upgradeID3v22 converts the frames of a v2.2 tag to their v2.3 layout,
+// refusing tags with frames that have no v2.3 equivalent
+func upgradeID3v22(frames []ID3Frame) ([]ID3Frame, error) {
+	upgraded := make([]ID3Frame, 0, len(frames))
+	for _, f := range frames {
+		if len(f.ID) != 4 {
+			return nil, fmt.Errorf("%w: ID3v2.2 frame %q has no ID3v2.4 equivalent", ErrUnsupportedTag, f.ID)
+		}
+		if f.ID == "APIC" {
+			// PIC holds a three-letter image format instead of a MIME type
+			if len(f.Data) < 5 {
+				continue
+			}
+			format := strings.ToLower(strings.TrimRight(string(f.Data[1:4]), "\x00 "))
+			if format == "jpg" {
+				format = "jpeg"
+			}
+			data := append([]byte{f.Data[0]}, "image/"+format...)
+			data = append(data, 0)
+			f.Data = append(data, f.Data[4:]...)
+		}
+		upgraded = append(upgraded, f)
+	}
+	return upgraded, nil
+}
+
+// encodeID3Text encodes a text frame body: UTF-8 for v2.4, and Latin-1 or
+// UTF-16 with a byte order mark for v2.3
+func encodeID3Text(s string, version int) []byte {
+	if version >= 4 {
+		return append([]byte{3}, s...)
+	}
+	latin1 := make([]byte, 0, len(s)+1)
+	latin1 = append(latin1, 0)
+	for _, c := range s {
+		if c > 0xFF {
+			data := []byte{1, 0xFF, 0xFE}
+			for _, unit := range utf16.Encode([]rune(s)) {
+				data = binary.LittleEndian.AppendUint16(data, unit)
+			}
+			return data
+		}
+		latin1 = append(latin1, byte(c))
+	}
+	return latin1
+}
+
+// encodeID3v2 serialises frames as a tag of the given version, without
+// unsynchronisation or an extended header. Frames read from a tag of that
+// version are written exactly as they were.
+func encodeID3v2(frames []ID3Frame, version int) []byte {
+	var body []byte
+	for _, f := range frames {
+		if f.raw != nil {
+			body = append(body, f.raw...)
+			continue
+		}
+		body = append(body, f.ID...)
+		if version >= 4 {
+			body = append(body, putSyncsafe(len(f.Data))...)
+		} else {
+			body = binary.BigEndian.AppendUint32(body, uint32(len(f.Data)))
+		}
+		body = append(body, 0, 0)
+		body = append(body, f.Data...)
+	}
+	body = append(body, make([]byte, id3Padding)...)
+
+	tag := append([]byte{'I', 'D', '3', byte(version), 0, 0}, putSyncsafe(len(body))...)
+	return append(tag, body...)
+}
+
+// updateID3v1 applies an update to a 128-byte ID3v1 tag in place. Values
+// are truncated to the fixed field sizes.
+func updateID3v1(b []byte, u TagUpdate) {
+	put := func(field []byte, s string) {
+		clear(field)
+		i := 0
+		for _, c := range s {
+			if i == len(field) {
+				break
+			}
+			if c > 0xFF {
+				c = '?'
+			}
+			field[i] = byte(c)
+			i++
+		}
+	}
+	if u.Title != nil {
+		put(b[3:33], *u.Title)
+	}
+	if u.Artist != nil {
+		put(b[33:63], *u.Artist)
+	}
+	if u.Album != nil {
+		put(b[63:93], *u.Album)
+	}
+	if u.Year != nil {
+		put(b[93:97], yearString(*u.Year))
+	}
+	// ID3v1.1 keeps the track number in the last byte of the comment
+	v11 := b[125] == 0 && b[126] != 0
+	if u.Comment != nil {
+		if v11 {
+			put(b[97:125], *u.Comment)
+		} else {
+			put(b[97:127], *u.Comment)
+		}
+	}
+	if u.TrackNumber != nil {
+		if n := *u.TrackNumber; n > 0 && n < 256 {
+			b[125], b[126] = 0, byte(n)
+		} else if v11 {
+			b[126] = 0
+		}
+	}
+	if u.Genre != nil {
+		b[127] = 0xFF
+		for i, g := range id3v1Genres {
+			if strings.EqualFold(g, *u.Genre) {
+				b[127] = byte(i)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+// rewriteTestFile applies an update to an in-memory file
+func rewriteTestFile(t *testing.T, data []byte, format Format, u TagUpdate) []byte {
+	t.Helper()
+	r, size, err := RewriteTags(bytes.NewReader(data), int64(len(data)), format, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(out)) != size {
+		t.Fatalf("rewrote %d bytes, reported %d", len(out), size)
+	}
+	return out
+}
+
+// id3v23Tag builds a v2.3 tag from frames given as ID and body pairs
+func id3v23Tag(frames ...string) []byte {
+	var body []byte
+	for i := 0; i+1 < len(frames); i += 2 {
+		body = append(body, frames[i]...)
+		body = binary.BigEndian.AppendUint32(body, uint32(len(frames[i+1])))
+		body = append(body, 0, 0)
+		body = append(body, frames[i+1]...)
+	}
+	return append(append([]byte{'I', 'D', '3', 3, 0, 0}, putSyncsafe(len(body))...), body...)
+}
+
+// id3v24Frame builds a v2.4 frame with the given status and format flags
+func id3v24Frame(id string, status, format byte, body string) []byte {
+	frame := append([]byte(id), putSyncsafe(len(body))...)
+	return append(append(frame, status, format), body...)
+}
+
+// id3v24Tag builds a v2.4 tag from encoded frames
+func id3v24Tag(frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	return append(append([]byte{'I', 'D', '3', 4, 0, 0}, putSyncsafe(len(body))...), body...)
+}
+
+// id3v1Tag builds a 128-byte ID3v1.1 tag
+func id3v1Tag(title string, track byte) []byte {
+	b := make([]byte, 128)
+	copy(b, "TAG")
+	copy(b[3:33], title)
+	b[126] = track
+	b[127] = 0xFF
+	return b
+}
+
+func TestRewriteID3(t *testing.T) {
+	picture := "\x00image/png\x00\x03\x00\x89PNG picture data"
+	audio := bytes.Repeat([]byte{0xFF, 0xFB, 0x90, 0x00}, 8)
+	var file []byte
+	file = append(file, id3v23Tag("TIT2", "\x00Old title", "TPE1", "\x00Artist", "APIC", picture)...)
+	file = append(file, audio...)
+	file = append(file, id3v1Tag("Old title", 3)...)
+
+	title, year, track := "Snow ☃", 2001, 7
+	out := rewriteTestFile(t, file, FormatMP3, TagUpdate{Title: &title, Year: &year, TrackNumber: &track})
+
+	tag, err := ReadID3v2(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag.Version != 3 {
+		t.Errorf("version = %d, want the tag to stay v2.3", tag.Version)
+	}
+	tags := tag.Tags()
+	if tags.Title != title || tags.Artist != "Artist" || tags.Year != year || tags.TrackNumber != track {
+		t.Errorf("tags = %+v", tags)
+	}
+	kept := false
+	for _, f := range tag.Frames {
+		kept = kept || f.ID == "APIC" && string(f.Data) == picture
+	}
+	if !kept {
+		t.Error("APIC frame was not kept")
+	}
+
+	start := skipID3v2(bytes.NewReader(out))
+	if !bytes.Equal(out[start:len(out)-128], audio) {
+		t.Error("audio changed")
+	}
+	v1, ok := readID3v1(bytes.NewReader(out), int64(len(out)))
+	if !ok || v1.Title != "Snow ?" || v1.Year != year || v1.TrackNumber != track {
+		t.Errorf("ID3v1 tags = %+v", v1)
+	}
+}
+
+func TestRewriteID3AddsTag(t *testing.T) {
+	audio := bytes.Repeat([]byte{0xFF, 0xFB, 0x90, 0x00}, 8)
+	artist := "Artist"
+	out := rewriteTestFile(t, audio, FormatMP3, TagUpdate{Artist: &artist})
+
+	tag, err := ReadID3v2(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag.Version != 4 || tag.Tags().Artist != artist {
+		t.Errorf("tag = v2.%d with %+v", tag.Version, tag.Tags())
+	}
+	if !bytes.Equal(out[tag.Size:], audio) {
+		t.Error("audio changed")
+	}
+}
+
+func TestRewriteID3RemovesFields(t *testing.T) {
+	file := append(id3v23Tag("TIT2", "\x00Title", "COMM", "\x00eng\x00Remark", "COMM", "\x00engnote\x00Kept"), 0xFF, 0xFB)
+	empty := ""
+	out := rewriteTestFile(t, file, FormatMP3, TagUpdate{Title: &empty, Comment: &empty})
+
+	tag, err := ReadID3v2(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, f := range tag.Frames {
+		ids = append(ids, f.ID)
+	}
+	if len(ids) != 1 || ids[0] != "COMM" || tag.Tags().Comment != "" {
+		t.Errorf("frames = %v, tags = %+v", ids, tag.Tags())
+	}
+}
+
+func TestRewriteID3KeepsFlaggedFrames(t *testing.T) {
+	audio := bytes.Repeat([]byte{0xFF, 0xFB, 0x90, 0x00}, 8)
+	// A picture with a data length indicator and the read-only flag, a
+	// compressed frame and a grouped one
+	picture := id3v24Frame("APIC", 0x10, 0x01, "\x00\x00\x00\x14\x00image/png\x00\x03\x00\x89PNG data")
+	compressed := id3v24Frame("TXXX", 0, 0x09, "\x00\x00\x00\x20x\x9c compressed")
+	grouped := id3v24Frame("TPE1", 0, 0x40, "\x07\x03Artist")
+	file := append(id3v24Tag(id3v24Frame("TIT2", 0, 0, "\x03Old"), picture, compressed, grouped), audio...)
+
+	title := "New"
+	out := rewriteTestFile(t, file, FormatMP3, TagUpdate{Title: &title})
+	for name, frame := range map[string][]byte{"picture": picture, "compressed": compressed, "grouped": grouped} {
+		if !bytes.Contains(out, frame) {
+			t.Errorf("%s frame was not copied unchanged", name)
+		}
+	}
+	tag, err := ReadID3v2(bytes.NewReader(out))
+	if err != nil || tag.err != nil {
+		t.Fatal(err, tag.err)
+	}
+	if tags := tag.Tags(); tags.Title != title || tags.Artist != "Artist" {
+		t.Errorf("tags = %+v", tags)
+	}
+
+	// v2.3 compressed and encrypted frames are kept as well
+	v23 := append(id3v23Tag("TIT2", "\x00Old"), audio...)
+	flagged := []byte("TXXX\x00\x00\x00\x05\x00\x40\x01data")
+	v23 = append(v23[:10], append(flagged, v23[10:]...)...)
+	copy(v23[6:10], putSyncsafe(len(v23)-10-len(audio)))
+	if out := rewriteTestFile(t, v23, FormatMP3, TagUpdate{Title: &title}); !bytes.Contains(out, flagged) {
+		t.Error("encrypted v2.3 frame was not copied unchanged")
+	}
+}
+
+func TestRewriteID3RefusesDamagedTags(t *testing.T) {
+	audio := bytes.Repeat([]byte{0xFF, 0xFB, 0x90, 0x00}, 8)
+	overrun := id3v23Tag("TIT2", "\x00Title", "APIC", "\x00image/png\x00\x03\x00data")
+	binary.BigEndian.PutUint32(overrun[30:], 1000)
+	stacked := append(id3v23Tag("TIT2", "\x00First"), id3v23Tag("TPE1", "\x00Second")...)
+	garbage := append(id3v23Tag("TIT2", "\x00Title"), 0, 0, 'x')
+	copy(garbage[6:10], putSyncsafe(len(garbage)-10))
+	v22 := []byte("ID3\x02\x00\x00\x00\x00\x00\x12TT2\x00\x00\x04\x00OldTEN\x00\x00\x02\x00x")
+
+	title := "New"
+	for name, tag := range map[string][]byte{"overrun": overrun, "stacked": stacked, "garbage": garbage, "v2.2": v22} {
+		file := append(tag, audio...)
+		if _, _, err := RewriteTags(bytes.NewReader(file), int64(len(file)), FormatMP3, TagUpdate{Title: &title}); !errors.Is(err, ErrUnsupportedTag) {
+			t.Errorf("%s: err = %v, want ErrUnsupportedTag", name, err)
+		}
+	}
+
+	// Reading still returns the frames before the damage
+	tag, err := ReadID3v2(bytes.NewReader(overrun))
+	if err != nil || tag.err == nil || tag.Tags().Title != "Title" {
+		t.Errorf("damaged tag read as %+v, %v", tag, err)
+	}
+}
+
+func TestRewriteID3v22(t *testing.T) {
+	frames := "TT2\x00\x00\x04\x00OldPIC\x00\x00\x0a\x00JPG\x03\x00jpeg"
+	file := append(append([]byte("ID3\x02\x00\x00"), putSyncsafe(len(frames))...), frames...)
+	file = append(file, 0xFF, 0xFB, 0x90, 0x00)
+
+	title := "New"
+	out := rewriteTestFile(t, file, FormatMP3, TagUpdate{Title: &title})
+	tag, err := ReadID3v2(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	picture, ok := tag.Frame("APIC")
+	if tag.Version != 4 || tag.Tags().Title != title || !ok || string(picture.Data) != "\x00image/jpeg\x00\x03\x00jpeg" {
+		t.Errorf("upgraded tag v2.%d with %+v", tag.Version, tag.Frames)
+	}
+}
+
+func TestRewriteID3RoundTrip(t *testing.T) {
+	file := append(id3v23Tag("TIT2", "\x00Title", "TPE1", "\x00Artist", "TXXX", "\x00key\x00value", "APIC", "\x00image/png\x00\x03\x00data"), 0xFF, 0xFB)
+	original, err := ReadID3v2(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed, comment, year := "Changed", "Remark", 1999
+	once := rewriteTestFile(t, file, FormatMP3, TagUpdate{Title: &changed, Comment: &comment, Year: &year})
+	if twice := rewriteTestFile(t, once, FormatMP3, TagUpdate{Title: &changed, Comment: &comment, Year: &year}); !bytes.Equal(twice, once) {
+		t.Error("applying the update again changed the file")
+	}
+
+	// Undoing the update gives back the original tags and frames
+	title, empty, zero := "Title", "", 0
+	back := rewriteTestFile(t, once, FormatMP3, TagUpdate{Title: &title, Comment: &empty, Year: &zero})
+	tag, err := ReadID3v2(bytes.NewReader(back))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag.Tags() != original.Tags() || len(tag.Frames) != len(original.Frames) {
+		t.Errorf("round trip gave %+v, want %+v", tag.Frames, original.Frames)
+	}
+}
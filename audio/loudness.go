@@ -0,0 +1,275 @@
+package audio
+
+import (
+	"errors"
+	"io"
+	"math"
+)
+
+// ReplayGainReference is the ReplayGain 2.0 target loudness in LUFS
+const ReplayGainReference = -18.0
+
+// Loudness is the result of an EBU R128 / ITU-R BS.1770-4 measurement
+type Loudness struct {
+	// Integrated is the gated programme loudness in LUFS
+	Integrated float64
+	// TruePeak is the maximum inter-sample peak in dBTP
+	TruePeak float64
+	// SamplePeak is the maximum absolute sample value (linear, 1.0 = full scale)
+	SamplePeak float64
+	// GatedBlocks is the number of 400 ms blocks that passed both gates.
+	// It weights the track when combining loudness across an album.
+	GatedBlocks int
+}
+
+// biquad is a direct form I second-order IIR filter
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
+
+// kWeighting returns the BS.1770 pre-filter (high shelf) and RLB high-pass
+// filter, with coefficients derived for the given sample rate
+func kWeighting(sampleRate int) (biquad, biquad) {
+	fs := float64(sampleRate)
+
+	f0, gain, q := 1681.974450955533, 3.999843853973347, 0.7071752369554196
+	k := math.Tan(math.Pi * f0 / fs)
+	vh := math.Pow(10, gain/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf := biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	f0, q = 38.13547087602444, 0.5003270373238773
+	k = math.Tan(math.Pi * f0 / fs)
+	a0 = 1 + k/q + k*k
+	highpass := biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+	return shelf, highpass
+}
+
+// channelWeight returns the BS.1770 weighting for a channel. For 5.1 layouts
+// (L R C LFE Ls Rs) the LFE is ignored and surrounds get +1.5 dB.
+func channelWeight(channels, ch int) float64 {
+	if channels < 6 {
+		return 1
+	}
+	switch ch {
+	case 3:
+		return 0
+	case 4, 5:
+		return 1.41
+	default:
+		return 1
+	}
+}
+
+// truePeakMeter estimates inter-sample peaks by polyphase oversampling
+type truePeakMeter struct {
+	factor  int
+	phases  [][]float64
+	history [][]float64
+	pos     int
+	peak    float64
+}
+
+func newTruePeakMeter(sampleRate, channels int) *truePeakMeter {
+	factor := 4
+	if sampleRate >= 96000 {
+		factor = 2
+	}
+	if sampleRate >= 192000 {
+		factor = 1
+	}
+
+	// Windowed-sinc low-pass interpolation filter split into polyphase branches
+	const tapsPerPhase = 12
+	taps := tapsPerPhase * factor
+	centre := float64(taps-1) / 2
+	phases := make([][]float64, factor)
+	for p := range phases {
+		phases[p] = make([]float64, tapsPerPhase)
+	}
+	for n := 0; n < taps; n++ {
+		x := (float64(n) - centre) / float64(factor)
+		sinc := 1.0
+		if x != 0 {
+			sinc = math.Sin(math.Pi*x) / (math.Pi * x)
+		}
+		window := 0.5 - 0.5*math.Cos(2*math.Pi*float64(n)/float64(taps-1))
+		phases[n%factor][n/factor] = sinc * window
+	}
+
+	history := make([][]float64, channels)
+	for ch := range history {
+		history[ch] = make([]float64, tapsPerPhase)
+	}
+	return &truePeakMeter{factor: factor, phases: phases, history: history}
+}
+
+// add feeds one frame of samples (one per channel)
+func (m *truePeakMeter) add(frame []float32) {
+	taps := len(m.history[0])
+	for ch, s := range frame {
+		m.history[ch][m.pos] = float64(s)
+	}
+	for ch := range frame {
+		hist := m.history[ch]
+		for _, phase := range m.phases {
+			var acc float64
+			for k, coeff := range phase {
+				acc += coeff * hist[(m.pos-k+taps)%taps]
+			}
+			m.peak = max(m.peak, math.Abs(acc))
+		}
+	}
+	m.pos = (m.pos + 1) % taps
+}
+
+// MeasureLoudness decodes the whole stream and measures integrated
+// loudness (with absolute -70 LUFS and relative -10 LU gates), true peak
+// and sample peak
+func MeasureLoudness(dec Decoder) (*Loudness, error) {
+	channels := dec.Channels()
+	sampleRate := dec.SampleRate()
+	if channels < 1 || sampleRate < 1 {
+		return nil, errors.New("loudness: invalid stream parameters")
+	}
+
+	shelves := make([]biquad, channels)
+	highpasses := make([]biquad, channels)
+	for ch := range shelves {
+		shelves[ch], highpasses[ch] = kWeighting(sampleRate)
+	}
+	peakMeter := newTruePeakMeter(sampleRate, channels)
+
+	// Blocks are 400 ms long with 75% overlap, so track energy per 100 ms step
+	step := sampleRate / 10
+	var steps []float64
+	var stepEnergy float64
+	stepFill := 0
+	samplePeak := 0.0
+
+	buf := make([]float32, 4096*channels)
+	for {
+		n, err := dec.Read(buf)
+		for i := 0; i+channels <= n; i += channels {
+			frame := buf[i : i+channels]
+			for ch, s := range frame {
+				samplePeak = max(samplePeak, math.Abs(float64(s)))
+				y := highpasses[ch].process(shelves[ch].process(float64(s)))
+				stepEnergy += channelWeight(channels, ch) * y * y
+			}
+			peakMeter.add(frame)
+
+			stepFill++
+			if stepFill == step {
+				steps = append(steps, stepEnergy/float64(step))
+				stepEnergy, stepFill = 0, 0
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var blocks []float64
+	for i := 3; i < len(steps); i++ {
+		blocks = append(blocks, (steps[i-3]+steps[i-2]+steps[i-1]+steps[i])/4)
+	}
+
+	result := &Loudness{
+		Integrated: math.Inf(-1),
+		TruePeak:   amplitudeToDB(max(peakMeter.peak, samplePeak)),
+		SamplePeak: samplePeak,
+	}
+
+	// Absolute gate
+	const absoluteGate = -70.0
+	var sum float64
+	count := 0
+	for _, z := range blocks {
+		if energyToLUFS(z) > absoluteGate {
+			sum += z
+			count++
+		}
+	}
+	if count == 0 {
+		return result, nil
+	}
+
+	// Relative gate, 10 LU below the absolute-gated loudness
+	relativeGate := energyToLUFS(sum/float64(count)) - 10
+	sum, count = 0, 0
+	for _, z := range blocks {
+		if l := energyToLUFS(z); l > absoluteGate && l > relativeGate {
+			sum += z
+			count++
+		}
+	}
+	if count > 0 {
+		result.Integrated = energyToLUFS(sum / float64(count))
+		result.GatedBlocks = count
+	}
+	return result, nil
+}
+
+// CombineLoudness returns the loudness of several programmes played back to
+// back, weighting each by its number of gated blocks
+func CombineLoudness(parts []Loudness) float64 {
+	var sum float64
+	blocks := 0
+	for _, p := range parts {
+		if p.GatedBlocks == 0 || math.IsInf(p.Integrated, -1) {
+			continue
+		}
+		sum += float64(p.GatedBlocks) * LUFSToEnergy(p.Integrated)
+		blocks += p.GatedBlocks
+	}
+	if blocks == 0 {
+		return math.Inf(-1)
+	}
+	return energyToLUFS(sum / float64(blocks))
+}
+
+// ReplayGain returns the gain in dB that brings loudness to the ReplayGain 2.0 reference
+func ReplayGain(lufs float64) float64 {
+	return ReplayGainReference - lufs
+}
+
+func energyToLUFS(z float64) float64 {
+	return -0.691 + 10*math.Log10(z)
+}
+
+// LUFSToEnergy is the inverse of the BS.1770 loudness formula
+func LUFSToEnergy(lufs float64) float64 {
+	return math.Pow(10, (lufs+0.691)/10)
+}
+
+func amplitudeToDB(a float64) float64 {
+	if a <= 0 {
+		return math.Inf(-1)
+	}
+	return 20 * math.Log10(a)
+}
@@ -0,0 +1,96 @@
+package audio
+
+import (
+	"io"
+	"math"
+	"testing"
+)
+
+// sliceDecoder serves interleaved samples from memory
+type sliceDecoder struct {
+	rate, channels int
+	samples        []float32
+}
+
+func (d *sliceDecoder) SampleRate() int { return d.rate }
+func (d *sliceDecoder) Channels() int   { return d.channels }
+
+func (d *sliceDecoder) Read(buf []float32) (int, error) {
+	if len(d.samples) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(buf, d.samples)
+	d.samples = d.samples[n:]
+	return n, nil
+}
+
+// tone appends seconds of a 1 kHz sine at dBFS to every channel
+func tone(samples []float32, rate, channels int, seconds, dBFS float64) []float32 {
+	amplitude := math.Pow(10, dBFS/20)
+	for i := range int(seconds * float64(rate)) {
+		v := float32(amplitude * math.Sin(2*math.Pi*1000*float64(i)/float64(rate)))
+		for range channels {
+			samples = append(samples, v)
+		}
+	}
+	return samples
+}
+
+func TestMeasureLoudness(t *testing.T) {
+	// EBU Tech 3341 case 1: a stereo 1 kHz tone at -23 dBFS reads -23 LUFS
+	dec := &sliceDecoder{rate: 48000, channels: 2, samples: tone(nil, 48000, 2, 20, -23)}
+	l, err := MeasureLoudness(dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(l.Integrated+23) > 0.1 {
+		t.Errorf("integrated = %.2f LUFS, want -23", l.Integrated)
+	}
+	if math.Abs(20*math.Log10(l.SamplePeak)+23) > 0.01 || l.TruePeak < 20*math.Log10(l.SamplePeak) {
+		t.Errorf("sample peak %.4f, true peak %.2f dBTP", l.SamplePeak, l.TruePeak)
+	}
+}
+
+func TestMeasureLoudnessGates(t *testing.T) {
+	// The quiet half is 20 LU down, so the relative gate leaves it out
+	samples := tone(nil, 48000, 2, 10, -23)
+	samples = tone(samples, 48000, 2, 10, -43)
+	l, err := MeasureLoudness(&sliceDecoder{rate: 48000, channels: 2, samples: samples})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(l.Integrated+23) > 0.2 {
+		t.Errorf("integrated = %.2f LUFS, want -23 with the quiet half gated", l.Integrated)
+	}
+	if l.GatedBlocks < 90 || l.GatedBlocks > 100 {
+		t.Errorf("%d gated blocks, want those of the loud 10 s", l.GatedBlocks)
+	}
+
+	// Everything below -70 LUFS is gated absolutely
+	l, err = MeasureLoudness(&sliceDecoder{rate: 48000, channels: 2, samples: tone(nil, 48000, 2, 5, -80)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !math.IsInf(l.Integrated, -1) || l.GatedBlocks != 0 {
+		t.Errorf("got %.2f LUFS from %d blocks, want no loudness", l.Integrated, l.GatedBlocks)
+	}
+}
+
+func TestCombineLoudness(t *testing.T) {
+	// Weighted by blocks in the energy domain, ignoring silent parts
+	got := CombineLoudness([]Loudness{
+		{Integrated: -20, GatedBlocks: 100},
+		{Integrated: -30, GatedBlocks: 100},
+		{Integrated: math.Inf(-1)},
+	})
+	want := energyToLUFS((LUFSToEnergy(-20) + LUFSToEnergy(-30)) / 2)
+	if math.Abs(got-want) > 1e-9 {
+		t.Errorf("CombineLoudness = %v, want %v", got, want)
+	}
+	if !math.IsInf(CombineLoudness(nil), -1) {
+		t.Error("no parts should have no loudness")
+	}
+	if g := ReplayGain(-14); g != -4 {
+		t.Errorf("ReplayGain(-14) = %v, want -4", g)
+	}
+}
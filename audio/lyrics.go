@@ -0,0 +1,333 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrNoLyrics is returned when a file carries no lyrics
+var ErrNoLyrics = errors.New("no lyrics found")
+
+// Lyrics sources reported in Lyrics.Source
+const (
+	LyricsSourceLRC    = "lrc"
+	LyricsSourceSYLT   = "id3-sylt"
+	LyricsSourceUSLT   = "id3-uslt"
+	LyricsSourceVorbis = "vorbis"
+	LyricsSourceMP4    = "mp4"
+)
+
+// Lyrics are the plain or time-synced lyrics of a track
+type Lyrics struct {
+	// Synced is set when every line has a start time
+	Synced   bool   `json:"synced"`
+	Language string `json:"language,omitempty"`
+	// Source tells where the lyrics were found, e.g. "lrc" or "id3-sylt"
+	Source string      `json:"source"`
+	Lines  []LyricLine `json:"lines"`
+}
+
+// LyricLine is a line of lyrics
+type LyricLine struct {
+	// Start is the time in seconds at which the line begins, 0 when unsynced
+	Start float64 `json:"start"`
+	Text  string  `json:"text"`
+}
+
+// ParseLRC parses an LRC file. Lines may carry several [mm:ss.xx] stamps
+// and the [offset:] tag is applied; word-level <mm:ss.xx> stamps are
+// dropped. Text without any stamp is returned as plain lyrics.
+func ParseLRC(data []byte) *Lyrics {
+	text := DecodeText(data)
+	lyrics := &Lyrics{Source: LyricsSourceLRC}
+	var plain []string
+	offset := 0.0
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		var stamps []float64
+		rest := strings.TrimSpace(line)
+		for strings.HasPrefix(rest, "[") {
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				break
+			}
+			tag := rest[1:end]
+			if t, ok := parseLRCTime(tag); ok {
+				stamps = append(stamps, t)
+			} else if key, value, ok := strings.Cut(tag, ":"); ok && len(stamps) == 0 && isLRCTagKey(key) {
+				// ID tags such as [ar:Artist] or [offset:+250]
+				if strings.EqualFold(strings.TrimSpace(key), "offset") {
+					if ms, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+						offset = float64(ms) / 1000
+					}
+				}
+				rest = ""
+				break
+			} else {
+				break
+			}
+			rest = rest[end+1:]
+		}
+		rest = strings.TrimSpace(stripLRCWordStamps(rest))
+
+		if len(stamps) == 0 {
+			if rest != "" || len(plain) > 0 {
+				plain = append(plain, rest)
+			}
+			continue
+		}
+		for _, t := range stamps {
+			lyrics.Lines = append(lyrics.Lines, LyricLine{Start: t, Text: rest})
+		}
+	}
+
+	if len(lyrics.Lines) == 0 {
+		lyrics.Lines = plainLines(plain)
+		return lyrics
+	}
+	// A positive offset shows lyrics earlier
+	for i := range lyrics.Lines {
+		lyrics.Lines[i].Start = max(lyrics.Lines[i].Start-offset, 0)
+	}
+	sort.SliceStable(lyrics.Lines, func(i, j int) bool { return lyrics.Lines[i].Start < lyrics.Lines[j].Start })
+	lyrics.Synced = true
+	return lyrics
+}
+
+// isLRCTagKey reports whether s can name an ID tag such as "ar" or
+// "offset", as opposed to a section marker like "[Verse 1: Artist]"
+func isLRCTagKey(s string) bool {
+	if s == "" || len(s) > 8 {
+		return false
+	}
+	for _, c := range s {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseLRCTime parses "mm:ss", "mm:ss.xx" or "mm:ss:xx"
+func parseLRCTime(s string) (float64, bool) {
+	mins, sec, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, false
+	}
+	if i := strings.IndexByte(sec, ':'); i >= 0 {
+		sec = sec[:i] + "." + sec[i+1:]
+	}
+	m, err := strconv.Atoi(mins)
+	if err != nil || m < 0 {
+		return 0, false
+	}
+	secs, err := strconv.ParseFloat(sec, 64)
+	if err != nil || secs < 0 || secs >= 60 || strings.ContainsAny(sec, "eE+-") {
+		return 0, false
+	}
+	return float64(m)*60 + secs, true
+}
+
+// stripLRCWordStamps removes enhanced LRC <mm:ss.xx> word timings
+func stripLRCWordStamps(s string) string {
+	if !strings.Contains(s, "<") {
+		return s
+	}
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(s, '<')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(s[start:], '>')
+		if end < 0 {
+			break
+		}
+		if _, ok := parseLRCTime(s[start+1 : start+end]); !ok {
+			b.WriteString(s[:start+end+1])
+		} else {
+			b.WriteString(s[:start])
+		}
+		s = s[start+end+1:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+// plainLines turns unsynced text into lines, keeping blank lines between
+// stanzas but not at the end
+func plainLines(text []string) []LyricLine {
+	for len(text) > 0 && text[len(text)-1] == "" {
+		text = text[:len(text)-1]
+	}
+	lines := make([]LyricLine, len(text))
+	for i, t := range text {
+		lines[i] = LyricLine{Text: t}
+	}
+	return lines
+}
+
+// parseLyricsText parses embedded lyrics, which taggers often fill with
+// LRC text, reporting synced lyrics with the LRC source replaced
+func parseLyricsText(text, source string) *Lyrics {
+	lyrics := ParseLRC([]byte(text))
+	lyrics.Source = source
+	if len(lyrics.Lines) == 0 {
+		return nil
+	}
+	return lyrics
+}
+
+// String renders the lyrics as LRC text, or as plain text when unsynced
+func (l *Lyrics) String() string {
+	var b strings.Builder
+	for _, line := range l.Lines {
+		if l.Synced {
+			cs := int(line.Start*100 + 0.5)
+			fmt.Fprintf(&b, "[%02d:%02d.%02d]", cs/6000, cs/100%60, cs%100)
+		}
+		b.WriteString(line.Text)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// ReadEmbeddedLyrics returns the lyrics stored in a file's tags, preferring
+// synced lyrics: ID3v2 SYLT and USLT frames, Vorbis LYRICS comments or the
+// MP4 ©lyr atom
+func ReadEmbeddedLyrics(r io.ReaderAt, size int64, format Format) (*Lyrics, error) {
+	var found []*Lyrics
+
+	switch format {
+	case FormatMP3, FormatAAC, FormatFLAC:
+		if tag, err := ReadID3v2(r); err == nil {
+			found = append(found, tag.Lyrics()...)
+		}
+	}
+	switch format {
+	case FormatFLAC:
+		if flac, err := ReadFLACMetadata(r); err == nil {
+			found = append(found, flac.Comments().Lyrics()...)
+		}
+	case FormatOGG:
+		if ogg, err := ReadOggMetadata(r, size); err == nil {
+			found = append(found, ogg.Comments.Lyrics()...)
+		}
+	case FormatM4A:
+		if mp4, err := ReadMP4Metadata(r, size); err == nil {
+			if l := parseLyricsText(mp4.Text("\xa9lyr"), LyricsSourceMP4); l != nil {
+				found = append(found, l)
+			}
+		}
+	}
+
+	for _, l := range found {
+		if l.Synced {
+			return l, nil
+		}
+	}
+	if len(found) == 0 {
+		return nil, ErrNoLyrics
+	}
+	return found[0], nil
+}
+
+// Lyrics returns the lyrics of every SYLT and USLT frame
+func (t *ID3Tag) Lyrics() []*Lyrics {
+	var found []*Lyrics
+	for _, f := range t.Frames {
+		var l *Lyrics
+		switch f.ID {
+		case "SYLT":
+			l = parseSYLT(f.Data)
+		case "USLT":
+			if len(f.Data) < 5 {
+				continue
+			}
+			_, text := splitID3Pair(f.Data[0], f.Data[4:])
+			l = parseLyricsText(text, LyricsSourceUSLT)
+		}
+		if l != nil {
+			if len(f.Data) >= 4 {
+				l.Language = strings.Trim(string(f.Data[1:4]), "\x00 ")
+			}
+			found = append(found, l)
+		}
+	}
+	return found
+}
+
+// parseSYLT decodes a synchronised lyrics frame with millisecond stamps.
+// Entries starting with a line break begin a new line; when no entry does,
+// each entry is a line of its own.
+func parseSYLT(data []byte) *Lyrics {
+	// Encoding, language, timestamp format, content type, descriptor
+	if len(data) < 6 || data[4] != 2 {
+		return nil
+	}
+	encoding := data[0]
+	b := data[6:]
+	i := indexID3Terminator(encoding, b)
+	if i < 0 {
+		return nil
+	}
+	b = b[i+len(id3Terminator(encoding)):]
+
+	type entry struct {
+		text  string
+		start float64
+	}
+	var entries []entry
+	breaks := false
+	for len(b) > 0 {
+		i := indexID3Terminator(encoding, b)
+		if i < 0 || len(b) < i+len(id3Terminator(encoding))+4 {
+			break
+		}
+		text := decodeID3String(encoding, b[:i])
+		b = b[i+len(id3Terminator(encoding)):]
+		ms := binary.BigEndian.Uint32(b)
+		b = b[4:]
+		if len(entries) > 0 && (strings.HasPrefix(text, "\n") || strings.HasPrefix(text, "\r")) {
+			breaks = true
+		}
+		entries = append(entries, entry{text, float64(ms) / 1000})
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	lyrics := &Lyrics{Synced: true, Source: LyricsSourceSYLT}
+	for i, e := range entries {
+		text := strings.TrimLeft(e.text, "\r\n")
+		if breaks && i > 0 && text == e.text {
+			last := &lyrics.Lines[len(lyrics.Lines)-1]
+			last.Text += text
+			continue
+		}
+		lyrics.Lines = append(lyrics.Lines, LyricLine{Start: e.start, Text: text})
+	}
+	for i := range lyrics.Lines {
+		lyrics.Lines[i].Text = strings.TrimSpace(lyrics.Lines[i].Text)
+	}
+	return lyrics
+}
+
+// Lyrics returns the lyrics of the LYRICS and UNSYNCEDLYRICS comments
+func (vc VorbisComments) Lyrics() []*Lyrics {
+	var found []*Lyrics
+	for _, name := range []string{"LYRICS", "UNSYNCEDLYRICS"} {
+		for _, text := range vc[name] {
+			if l := parseLyricsText(text, LyricsSourceVorbis); l != nil {
+				found = append(found, l)
+			}
+		}
+	}
+	return found
+}
@@ -0,0 +1,94 @@
+package audio
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestParseLRC(t *testing.T) {
+	lyrics := ParseLRC([]byte("[ar:Artist]\r\n[offset:+500]\r\n[Verse 1: Singer]\r\n" +
+		"[00:12.00][01:02.50]Chorus line\r\n[00:05.20]<00:05.20>First <00:06.00>line\r\n[00:00.30]Intro\r\n"))
+	want := []LyricLine{
+		{Start: 0, Text: "Intro"},
+		{Start: 4.7, Text: "First line"},
+		{Start: 11.5, Text: "Chorus line"},
+		{Start: 62, Text: "Chorus line"},
+	}
+	if !lyrics.Synced || lyrics.Source != LyricsSourceLRC {
+		t.Fatalf("lyrics = %+v", lyrics)
+	}
+	if len(lyrics.Lines) != len(want) {
+		t.Fatalf("lines = %+v", lyrics.Lines)
+	}
+	for i, line := range lyrics.Lines {
+		if line.Text != want[i].Text || line.Start < want[i].Start-1e-9 || line.Start > want[i].Start+1e-9 {
+			t.Errorf("line %d = %+v, want %+v", i, line, want[i])
+		}
+	}
+	if s := lyrics.String(); s != "[00:00.00]Intro\n[00:04.70]First line\n[00:11.50]Chorus line\n[01:02.00]Chorus line\n" {
+		t.Errorf("String() = %q", s)
+	}
+}
+
+func TestParseLRCPlain(t *testing.T) {
+	lyrics := ParseLRC([]byte("\nFirst verse\n\nSecond verse\n\n"))
+	want := []LyricLine{{Text: "First verse"}, {Text: ""}, {Text: "Second verse"}}
+	if lyrics.Synced || !reflect.DeepEqual(lyrics.Lines, want) {
+		t.Errorf("lyrics = %+v", lyrics)
+	}
+	if s := lyrics.String(); s != "First verse\n\nSecond verse\n" {
+		t.Errorf("String() = %q", s)
+	}
+}
+
+func TestParseLRCTime(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+		ok   bool
+	}{
+		{"01:02.50", 62.5, true},
+		{"00:07:25", 7.25, true},
+		{"3:04", 184, true},
+		{"00:60.00", 0, false},
+		{"-1:00", 0, false},
+		{"00:1e1", 0, false},
+		{"ar", 0, false},
+	}
+	for _, tt := range tests {
+		if got, ok := parseLRCTime(tt.in); got != tt.want || ok != tt.ok {
+			t.Errorf("parseLRCTime(%q) = %v, %v", tt.in, got, ok)
+		}
+	}
+}
+
+func TestParseSYLT(t *testing.T) {
+	// Latin-1, "eng", millisecond stamps, lyrics content, empty descriptor
+	data := []byte{0, 'e', 'n', 'g', 2, 1, 0}
+	for _, e := range []struct {
+		text string
+		ms   uint32
+	}{{"Hello ", 1000}, {"world", 1500}, {"\nSecond", 4000}, {" line", 4500}} {
+		data = append(data, e.text...)
+		data = append(data, 0)
+		data = binary.BigEndian.AppendUint32(data, e.ms)
+	}
+	tag := &ID3Tag{Frames: []ID3Frame{{ID: "SYLT", Data: data}}}
+	found := tag.Lyrics()
+	if len(found) != 1 {
+		t.Fatalf("found %d lyrics", len(found))
+	}
+	want := []LyricLine{{Start: 1, Text: "Hello world"}, {Start: 4, Text: "Second line"}}
+	if l := found[0]; !l.Synced || l.Language != "eng" || l.Source != LyricsSourceSYLT || !reflect.DeepEqual(l.Lines, want) {
+		t.Errorf("lyrics = %+v", l)
+	}
+}
+
+func TestVorbisLyrics(t *testing.T) {
+	vc := VorbisComments{"LYRICS": {"[00:01.00]Synced"}, "UNSYNCEDLYRICS": {"Plain"}}
+	found := vc.Lyrics()
+	if len(found) != 2 || !found[0].Synced || found[1].Synced || found[1].Lines[0].Text != "Plain" {
+		t.Errorf("lyrics = %+v", found)
+	}
+}
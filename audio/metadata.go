@@ -0,0 +1,122 @@
+package audio
+
+import (
+	"fmt"
+	"io"
+)
+
+// Metadata is the descriptive and technical information read from a file
+type Metadata struct {
+	Tags
+	Duration   float64
+	SampleRate int
+	Channels   int
+	// Bitrate is the average bitrate in bits per second
+	Bitrate int
+	// Gapless is set when the encoder recorded its delay and padding
+	Gapless *Gapless
+	// Kind is KindAudiobook or KindPodcast when the tags say so
+	Kind     string
+	Chapters []Chapter
+}
+
+// ReadMetadata reads tags and stream properties without decoding audio
+func ReadMetadata(r io.ReaderAt, size int64, format Format) (*Metadata, error) {
+	meta := &Metadata{}
+
+	switch format {
+	case FormatMP3:
+		info, err := ReadMP3Info(r, size)
+		if err != nil {
+			return nil, err
+		}
+		meta.Duration = info.Duration()
+		meta.SampleRate = info.Header.SampleRate
+		meta.Channels = info.Header.Channels()
+		meta.Bitrate = info.Bitrate()
+		meta.Gapless = info.Gapless()
+		if tag, err := ReadID3v2(r); err == nil {
+			meta.Tags = tag.Tags()
+			meta.Kind = tag.Kind()
+			meta.Chapters = tag.Chapters(meta.Duration)
+		}
+		if v1, ok := readID3v1(r, size); ok {
+			meta.Tags.merge(v1)
+		}
+
+	case FormatAAC:
+		info, err := ReadADTSInfo(r, size)
+		if err != nil {
+			return nil, err
+		}
+		meta.Duration = info.Duration()
+		meta.SampleRate = info.Header.SampleRate
+		meta.Channels = info.Header.Channels
+		meta.Bitrate = int(float64(info.AudioEnd-info.AudioStart) * 8 / meta.Duration)
+		if tag, err := ReadID3v2(r); err == nil {
+			meta.Tags = tag.Tags()
+			meta.Kind = tag.Kind()
+			meta.Chapters = tag.Chapters(meta.Duration)
+		}
+
+	case FormatFLAC:
+		flac, err := ReadFLACMetadata(r)
+		if err != nil {
+			return nil, err
+		}
+		meta.Tags = flac.Comments().Tags()
+		meta.Duration = flac.Duration()
+		meta.SampleRate = flac.Info.SampleRate
+		meta.Channels = flac.Info.Channels
+		if tag, err := ReadID3v2(r); err == nil {
+			meta.Tags.merge(tag.Tags())
+		}
+
+	case FormatWAV:
+		info, err := readWAVInfo(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return nil, err
+		}
+		meta.SampleRate = info.SampleRate
+		meta.Channels = info.Channels
+		meta.Bitrate = info.SampleRate * info.BlockAlign * 8
+		if info.BlockAlign > 0 && info.SampleRate > 0 {
+			dataSize := min(info.DataSize, size-info.DataOffset)
+			meta.Duration = float64(dataSize/int64(info.BlockAlign)) / float64(info.SampleRate)
+		}
+
+	case FormatOGG:
+		ogg, err := ReadOggMetadata(r, size)
+		if err != nil {
+			return nil, err
+		}
+		meta.Tags = ogg.Comments.Tags()
+		meta.Duration = ogg.Duration
+		meta.SampleRate = ogg.SampleRate
+		meta.Channels = ogg.Channels
+
+	case FormatM4A:
+		mp4, err := ReadMP4Metadata(r, size)
+		if err != nil {
+			return nil, err
+		}
+		meta.Tags = mp4.Tags()
+		meta.Duration = mp4.Duration
+		meta.SampleRate = mp4.SampleRate
+		meta.Channels = mp4.Channels
+		meta.Gapless = mp4.Gapless()
+		meta.Kind = mp4.Kind()
+		meta.Chapters = mp4.Chapters
+
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedFormat, format)
+	}
+
+	if meta.Kind == "" {
+		meta.Kind = kindFromGenre(meta.Genre)
+	}
+	if meta.Bitrate == 0 && meta.Duration > 0 {
+		meta.Bitrate = int(float64(size) * 8 / meta.Duration)
+	}
+	return meta, nil
+}
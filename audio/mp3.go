@@ -0,0 +1,310 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// MPEG audio versions as stored in MP3FrameHeader.Version
+const (
+	MPEG1  = 1
+	MPEG2  = 2
+	MPEG25 = 25
+)
+
+var mp3Bitrates = map[int][3][16]int{
+	MPEG1: {
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, -1},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, -1},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, -1},
+	},
+	MPEG2: {
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, -1},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, -1},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, -1},
+	},
+}
+
+var mp3SampleRates = map[int][3]int{
+	MPEG1:  {44100, 48000, 32000},
+	MPEG2:  {22050, 24000, 16000},
+	MPEG25: {11025, 12000, 8000},
+}
+
+// MP3FrameHeader is a decoded MPEG audio frame header
+type MP3FrameHeader struct {
+	Version     int
+	Layer       int
+	Protected   bool
+	Bitrate     int // bits per second
+	SampleRate  int
+	Padding     bool
+	ChannelMode int // 3 is mono
+}
+
+// ParseMP3FrameHeader decodes the four header bytes at the start of b
+func ParseMP3FrameHeader(b []byte) (MP3FrameHeader, bool) {
+	var h MP3FrameHeader
+	if len(b) < 4 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return h, false
+	}
+
+	switch (b[1] >> 3) & 0x3 {
+	case 0:
+		h.Version = MPEG25
+	case 2:
+		h.Version = MPEG2
+	case 3:
+		h.Version = MPEG1
+	default:
+		return h, false
+	}
+	layerBits := (b[1] >> 1) & 0x3
+	if layerBits == 0 {
+		return h, false
+	}
+	h.Layer = 4 - int(layerBits)
+	h.Protected = b[1]&0x1 == 0
+
+	bitrateIndex := int(b[2] >> 4)
+	rateIndex := int((b[2] >> 2) & 0x3)
+	if bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		// Free format streams are not supported
+		return h, false
+	}
+	table := mp3Bitrates[MPEG1]
+	if h.Version != MPEG1 {
+		table = mp3Bitrates[MPEG2]
+	}
+	h.Bitrate = table[h.Layer-1][bitrateIndex] * 1000
+	h.SampleRate = mp3SampleRates[h.Version][rateIndex]
+	h.Padding = b[2]&0x2 != 0
+	h.ChannelMode = int(b[3] >> 6)
+	return h, true
+}
+
+// Samples returns the number of PCM samples per channel in a frame
+func (h MP3FrameHeader) Samples() int {
+	switch {
+	case h.Layer == 1:
+		return 384
+	case h.Layer == 3 && h.Version != MPEG1:
+		return 576
+	default:
+		return 1152
+	}
+}
+
+// FrameSize returns the frame length in bytes, header included
+func (h MP3FrameHeader) FrameSize() int {
+	if h.Layer == 1 {
+		size := 12 * h.Bitrate / h.SampleRate
+		if h.Padding {
+			size++
+		}
+		return size * 4
+	}
+	size := h.Samples() / 8 * h.Bitrate / h.SampleRate
+	if h.Padding {
+		size++
+	}
+	return size
+}
+
+// Channels returns 1 for mono streams and 2 otherwise
+func (h MP3FrameHeader) Channels() int {
+	if h.ChannelMode == 3 {
+		return 1
+	}
+	return 2
+}
+
+// Duration returns the playing time of one frame in seconds
+func (h MP3FrameHeader) Duration() float64 {
+	return float64(h.Samples()) / float64(h.SampleRate)
+}
+
+// sideInfoSize returns the size of the Layer III side information
+func (h MP3FrameHeader) sideInfoSize() int {
+	if h.Version == MPEG1 {
+		if h.ChannelMode == 3 {
+			return 17
+		}
+		return 32
+	}
+	if h.ChannelMode == 3 {
+		return 9
+	}
+	return 17
+}
+
+// compatible reports whether two headers can belong to the same stream
+func (h MP3FrameHeader) compatible(other MP3FrameHeader) bool {
+	return h.Version == other.Version && h.Layer == other.Layer && h.SampleRate == other.SampleRate
+}
+
+// MP3Info describes the layout and length of an MP3 stream
+type MP3Info struct {
+	// AudioStart is the offset of the first frame, after any ID3v2 tag.
+	// When a Xing/Info or VBRI frame is present it starts here too.
+	AudioStart int64
+	// AudioEnd is the offset just past the last frame, before any ID3v1 tag
+	AudioEnd int64
+	Header   MP3FrameHeader
+	// VBRHeader is "Xing", "Info", "VBRI" or empty
+	VBRHeader string
+	// Frames and Bytes come from the VBR header and are 0 when unknown
+	Frames int
+	Bytes  int64
+	// TOC is the Xing seek table: 100 entries scaled to 256
+	TOC []byte
+	// Encoder, EncoderDelay and EncoderPadding come from the LAME tag that
+	// follows a Xing/Info header. Delay and padding are in samples.
+	Encoder        string
+	EncoderDelay   int
+	EncoderPadding int
+
+	// vbriTable holds the cumulative byte offset, relative to the end of
+	// the VBRI frame, of every vbriFramesPerEntry frames
+	vbriTable          []int64
+	vbriFramesPerEntry int
+}
+
+// ReadMP3Info locates the first frame and reads any Xing/Info or VBRI header
+func ReadMP3Info(r io.ReaderAt, size int64) (*MP3Info, error) {
+	start := skipID3v2(r)
+	info := &MP3Info{AudioEnd: size}
+	if _, ok := readID3v1(r, size); ok {
+		info.AudioEnd -= 128
+	}
+
+	// Look for two consecutive frames to avoid false syncs in junk data
+	const searchLimit = 256 * 1024
+	buf := make([]byte, min(searchLimit, max(info.AudioEnd-start, 0)))
+	if err := readFull(r, buf, start); err != nil {
+		return nil, err
+	}
+	i, h, ok := findMP3Frame(buf)
+	if !ok {
+		return nil, errors.New("mp3: no frame sync found")
+	}
+	info.AudioStart = start + int64(i)
+	info.Header = h
+
+	first := make([]byte, min(int64(info.Header.FrameSize()), info.AudioEnd-info.AudioStart))
+	if err := readFull(r, first, info.AudioStart); err != nil {
+		return nil, err
+	}
+	info.parseVBRHeader(first)
+	return info, nil
+}
+
+// findMP3Frame returns the offset and header of the first frame in buf that
+// is followed by a compatible one, or by the end of buf
+func findMP3Frame(buf []byte) (int, MP3FrameHeader, bool) {
+	for i := 0; i+4 <= len(buf); i++ {
+		h, ok := ParseMP3FrameHeader(buf[i:])
+		if !ok {
+			continue
+		}
+		next := i + h.FrameSize()
+		if next+4 <= len(buf) {
+			nh, ok := ParseMP3FrameHeader(buf[next:])
+			if !ok || !h.compatible(nh) {
+				continue
+			}
+		}
+		return i, h, true
+	}
+	return 0, MP3FrameHeader{}, false
+}
+
+// parseVBRHeader reads a Xing/Info or VBRI header from the first frame
+func (info *MP3Info) parseVBRHeader(frame []byte) {
+	xing := 4 + info.Header.sideInfoSize()
+	if len(frame) >= xing+8 {
+		tag := string(frame[xing : xing+4])
+		if tag == "Xing" || tag == "Info" {
+			info.VBRHeader = tag
+			flags := binary.BigEndian.Uint32(frame[xing+4:])
+			pos := xing + 8
+			if flags&0x1 != 0 && len(frame) >= pos+4 {
+				info.Frames = int(binary.BigEndian.Uint32(frame[pos:]))
+				pos += 4
+			}
+			if flags&0x2 != 0 && len(frame) >= pos+4 {
+				info.Bytes = int64(binary.BigEndian.Uint32(frame[pos:]))
+				pos += 4
+			}
+			if flags&0x4 != 0 && len(frame) >= pos+100 {
+				info.TOC = append([]byte(nil), frame[pos:pos+100]...)
+				pos += 100
+			}
+			if flags&0x8 != 0 {
+				// VBR quality indicator
+				pos += 4
+			}
+			info.parseLAMETag(frame, pos)
+			return
+		}
+	}
+
+	// VBRI headers always sit 32 bytes after the frame header
+	const vbri = 4 + 32
+	if len(frame) >= vbri+18 && string(frame[vbri:vbri+4]) == "VBRI" {
+		info.VBRHeader = "VBRI"
+		info.Bytes = int64(binary.BigEndian.Uint32(frame[vbri+10:]))
+		info.Frames = int(binary.BigEndian.Uint32(frame[vbri+14:]))
+		info.parseVBRITable(frame[vbri:])
+	}
+}
+
+// parseVBRITable reads the VBRI seek table, which follows the fixed header
+// as a list of scaled byte counts for runs of framesPerEntry frames
+func (info *MP3Info) parseVBRITable(vbri []byte) {
+	if len(vbri) < 26 {
+		return
+	}
+	entries := int(binary.BigEndian.Uint16(vbri[18:]))
+	scale := int64(binary.BigEndian.Uint16(vbri[20:]))
+	entrySize := int(binary.BigEndian.Uint16(vbri[22:]))
+	framesPerEntry := int(binary.BigEndian.Uint16(vbri[24:]))
+	if entries == 0 || entrySize < 1 || entrySize > 4 || framesPerEntry == 0 || len(vbri) < 26+entries*entrySize {
+		return
+	}
+
+	table := make([]int64, entries+1)
+	pos := 26
+	for i := 0; i < entries; i++ {
+		var v int64
+		for _, b := range vbri[pos : pos+entrySize] {
+			v = v<<8 | int64(b)
+		}
+		pos += entrySize
+		table[i+1] = table[i] + v*scale
+	}
+	info.vbriTable = table
+	info.vbriFramesPerEntry = framesPerEntry
+}
+
+// Duration returns the stream length in seconds, estimated from the
+// bitrate when the stream carries no frame count
+func (info *MP3Info) Duration() float64 {
+	if info.Frames > 0 {
+		return float64(info.Frames) * info.Header.Duration()
+	}
+	if info.Header.Bitrate == 0 {
+		return 0
+	}
+	return float64(info.AudioEnd-info.AudioStart) * 8 / float64(info.Header.Bitrate)
+}
+
+// Bitrate returns the average bitrate in bits per second
+func (info *MP3Info) Bitrate() int {
+	duration := info.Duration()
+	if info.Frames > 0 && duration > 0 {
+		return int(float64(info.AudioEnd-info.AudioStart) * 8 / duration)
+	}
+	return info.Header.Bitrate
+}
@@ -0,0 +1,199 @@
+package audio
+
+import (
+	"errors"
+	"io"
+	"math"
+)
+
+// FirstAudioFrame returns the offset of the first frame carrying audio,
+// skipping the Xing/Info or VBRI header frame
+func (info *MP3Info) FirstAudioFrame() int64 {
+	if info.VBRHeader != "" {
+		return info.AudioStart + int64(info.Header.FrameSize())
+	}
+	return info.AudioStart
+}
+
+// SeekOffset estimates the byte offset of the frame playing at t seconds
+// from the Xing TOC or VBRI table, or from the bitrate of a CBR stream
+// marked with an Info header. It reports false when the stream carries no
+// usable table, in which case a frame index is needed. The offset may fall
+// inside a frame; use SyncFrame to align it.
+func (info *MP3Info) SeekOffset(t float64) (int64, bool) {
+	if t <= 0 {
+		return info.FirstAudioFrame(), true
+	}
+	duration := info.Duration()
+
+	switch {
+	case len(info.TOC) == 100 && info.Frames > 0:
+		if t >= duration {
+			return info.AudioEnd, true
+		}
+		bytes := info.Bytes
+		if bytes <= 0 || bytes > info.AudioEnd-info.AudioStart {
+			bytes = info.AudioEnd - info.AudioStart
+		}
+		// Interpolate between the two TOC entries surrounding t
+		percent := t / duration * 100
+		i := int(percent)
+		fa := float64(info.TOC[i])
+		fb := 256.0
+		if i < 99 {
+			fb = float64(info.TOC[i+1])
+		}
+		fx := fa + (fb-fa)*(percent-float64(i))
+		return info.AudioStart + int64(fx/256*float64(bytes)), true
+
+	case len(info.vbriTable) > 0:
+		entry := t / info.Header.Duration() / float64(info.vbriFramesPerEntry)
+		i := int(entry)
+		if i >= len(info.vbriTable)-1 {
+			return info.AudioEnd, true
+		}
+		a, b := info.vbriTable[i], info.vbriTable[i+1]
+		return info.FirstAudioFrame() + a + int64(float64(b-a)*(entry-float64(i))), true
+
+	case info.VBRHeader == "Info" && info.Header.Bitrate > 0:
+		if info.Frames > 0 && t >= duration {
+			return info.AudioEnd, true
+		}
+		return info.FirstAudioFrame() + int64(t*float64(info.Header.Bitrate)/8), true
+	}
+	return 0, false
+}
+
+// OffsetTime estimates the playback time of the frame at a byte offset
+// from the same table as SeekOffset, rounded to a whole frame. It reports
+// false when SeekOffset would.
+func (info *MP3Info) OffsetTime(off int64) (float64, bool) {
+	if off <= info.FirstAudioFrame() {
+		return 0, true
+	}
+	duration := info.Duration()
+	var t float64
+
+	switch {
+	case len(info.TOC) == 100 && info.Frames > 0:
+		bytes := info.Bytes
+		if bytes <= 0 || bytes > info.AudioEnd-info.AudioStart {
+			bytes = info.AudioEnd - info.AudioStart
+		}
+		// Find the TOC entries surrounding the offset and interpolate
+		fx := float64(off-info.AudioStart) / float64(bytes) * 256
+		i := 0
+		for i < 99 && float64(info.TOC[i+1]) <= fx {
+			i++
+		}
+		fa := float64(info.TOC[i])
+		fb := 256.0
+		if i < 99 {
+			fb = float64(info.TOC[i+1])
+		}
+		percent := float64(i)
+		if fb > fa {
+			percent += min(max((fx-fa)/(fb-fa), 0), 1)
+		}
+		t = percent / 100 * duration
+
+	case len(info.vbriTable) > 0:
+		rel := off - info.FirstAudioFrame()
+		i := 0
+		for i < len(info.vbriTable)-1 && info.vbriTable[i+1] <= rel {
+			i++
+		}
+		entry := float64(i)
+		if i < len(info.vbriTable)-1 {
+			if a, b := info.vbriTable[i], info.vbriTable[i+1]; b > a {
+				entry += min(max(float64(rel-a)/float64(b-a), 0), 1)
+			}
+		}
+		t = entry * float64(info.vbriFramesPerEntry) * info.Header.Duration()
+
+	case info.VBRHeader == "Info" && info.Header.Bitrate > 0:
+		t = float64(off-info.FirstAudioFrame()) * 8 / float64(info.Header.Bitrate)
+
+	default:
+		return 0, false
+	}
+
+	frame := info.Header.Duration()
+	t = math.Round(max(t, 0)/frame) * frame
+	if info.Frames > 0 {
+		t = min(t, duration)
+	}
+	return t, true
+}
+
+// SyncFrame returns the offset of the first frame at or after off, checking
+// that the following frame header is valid too so that sync-like bytes in
+// audio data are skipped. It returns AudioEnd when no frame is left.
+func (info *MP3Info) SyncFrame(r io.ReaderAt, off int64) (int64, error) {
+	off = max(off, info.AudioStart)
+	buf := make([]byte, 16*1024)
+	for off < info.AudioEnd {
+		b := buf[:min(int64(len(buf)), info.AudioEnd-off)]
+		if err := readFull(r, b, off); err != nil {
+			return 0, err
+		}
+		last := off+int64(len(b)) >= info.AudioEnd
+
+		i := 0
+		for ; i+4 <= len(b); i++ {
+			h, ok := ParseMP3FrameHeader(b[i:])
+			if !ok || !h.compatible(info.Header) {
+				continue
+			}
+			next := i + h.FrameSize()
+			if next+4 > len(b) {
+				if last {
+					return off + int64(i), nil
+				}
+				// Read again from here so the next header is in the window
+				break
+			}
+			if nh, ok := ParseMP3FrameHeader(b[next:]); ok && nh.compatible(h) {
+				return off + int64(i), nil
+			}
+		}
+		if last {
+			break
+		}
+		off += int64(max(i, 1))
+	}
+	return info.AudioEnd, nil
+}
+
+// BuildMP3FrameIndex walks the frame headers of the whole stream
+func BuildMP3FrameIndex(r io.ReaderAt, info *MP3Info) (*FrameIndex, error) {
+	idx := &FrameIndex{
+		SampleRate:      info.Header.SampleRate,
+		SamplesPerFrame: info.Header.Samples(),
+	}
+
+	pos := info.FirstAudioFrame()
+	var header [4]byte
+	for pos+4 <= info.AudioEnd {
+		if err := readFull(r, header[:], pos); err != nil {
+			return nil, err
+		}
+		h, ok := ParseMP3FrameHeader(header[:])
+		if !ok || !h.compatible(info.Header) {
+			// Resynchronise after junk or a damaged frame
+			next, err := info.SyncFrame(r, pos+1)
+			if err != nil {
+				return nil, err
+			}
+			pos = next
+			continue
+		}
+		idx.Offsets = append(idx.Offsets, pos)
+		pos += int64(h.FrameSize())
+	}
+	if len(idx.Offsets) == 0 {
+		return nil, errors.New("mp3: no audio frames found")
+	}
+	idx.End = min(pos, info.AudioEnd)
+	return idx, nil
+}
@@ -0,0 +1,162 @@
+package audio
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// vbrTestStream builds MPEG-1 Layer III frames alternating between 128 and
+// 160 kbit/s at 44.1 kHz, with a few junk bytes after the third frame
+func vbrTestStream(frames int) ([]byte, []int64) {
+	var stream []byte
+	var offsets []int64
+	for i := range frames {
+		header := []byte{0xFF, 0xFB, 0x90, 0x00}
+		if i%2 == 1 {
+			header[2] = 0xA0
+		}
+		h, _ := ParseMP3FrameHeader(header)
+		frame := make([]byte, h.FrameSize())
+		copy(frame, header)
+		offsets = append(offsets, int64(len(stream)))
+		stream = append(stream, frame...)
+		if i == 2 {
+			stream = append(stream, 0xFF, 0xFB, 0x00)
+		}
+	}
+	return stream, offsets
+}
+
+func TestMP3FrameIndex(t *testing.T) {
+	stream, offsets := vbrTestStream(20)
+	info, err := ReadMP3Info(bytes.NewReader(stream), int64(len(stream)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := BuildMP3FrameIndex(bytes.NewReader(stream), info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(idx.Offsets, offsets) || idx.End != int64(len(stream)) {
+		t.Fatalf("offsets %v end %d, want %v end %d", idx.Offsets, idx.End, offsets, len(stream))
+	}
+
+	frame := 1152.0 / 44100
+	off, start := idx.Seek(5.5 * frame)
+	if off != offsets[5] || start != 5*frame {
+		t.Errorf("Seek = %d at %v, want %d at %v", off, start, offsets[5], 5*frame)
+	}
+	if off, start := idx.Seek(100); off != idx.End || start != idx.Duration() {
+		t.Errorf("Seek past the end = %d at %v", off, start)
+	}
+
+	data, err := idx.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseFrameIndex(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, idx) {
+		t.Errorf("round trip gave %+v", parsed)
+	}
+	if _, err := ParseFrameIndex(data[:len(data)-1]); err == nil {
+		t.Error("truncated index accepted")
+	}
+}
+
+func TestSyncFrameSkipsFalseSync(t *testing.T) {
+	stream, offsets := vbrTestStream(6)
+	info, err := ReadMP3Info(bytes.NewReader(stream), int64(len(stream)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The junk after frame 2 starts like a header but is not followed by one
+	off, err := info.SyncFrame(bytes.NewReader(stream), offsets[3]-3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if off != offsets[3] {
+		t.Errorf("SyncFrame = %d, want %d", off, offsets[3])
+	}
+}
+
+func TestSeekOffsetCBR(t *testing.T) {
+	header := MP3FrameHeader{Version: MPEG1, Layer: 3, Bitrate: 128000, SampleRate: 44100}
+	size := int64(header.FrameSize())
+	info := MP3Info{AudioStart: 100, AudioEnd: 100 + size*1001, Header: header, VBRHeader: "Info", Frames: 1000}
+	if off, ok := info.SeekOffset(0); !ok || off != 100+size {
+		t.Errorf("SeekOffset(0) = %d, want the frame after the Info header", off)
+	}
+	if off, ok := info.SeekOffset(2); !ok || off != 100+size+32000 {
+		t.Errorf("SeekOffset(2) = %d, want 2 s of 128 kbit/s after the header", off)
+	}
+	if _, ok := (&MP3Info{Header: header}).SeekOffset(2); ok {
+		t.Error("seek offset estimated without a table")
+	}
+}
+
+func TestOffsetTimeInvertsSeekOffset(t *testing.T) {
+	header := MP3FrameHeader{Version: MPEG1, Layer: 3, Bitrate: 128000, SampleRate: 44100}
+	frame := header.Duration()
+	frames := 2000
+	size := int64(header.FrameSize())
+	audioEnd := size * int64(frames+1)
+
+	// A seek table where the second half of the track takes three times
+	// the bytes of the first
+	skewed := make([]byte, 100)
+	for i := range skewed {
+		if i < 50 {
+			skewed[i] = byte(i * 64 / 50)
+		} else {
+			skewed[i] = byte(64 + (i-50)*192/50)
+		}
+	}
+	linear := make([]byte, 100)
+	for i := range linear {
+		linear[i] = byte(i * 256 / 100)
+	}
+	vbri := make([]int64, frames/10+1)
+	for i := range vbri {
+		vbri[i] = int64(i) * 10 * size
+	}
+
+	tests := []struct {
+		name string
+		info MP3Info
+	}{
+		{"cbr", MP3Info{AudioEnd: audioEnd, Header: header, VBRHeader: "Info", Frames: frames}},
+		{"linear toc", MP3Info{AudioEnd: audioEnd, Header: header, VBRHeader: "Xing", Frames: frames, TOC: linear}},
+		{"skewed toc", MP3Info{AudioEnd: audioEnd, Header: header, VBRHeader: "Xing", Frames: frames, TOC: skewed}},
+		{"vbri", MP3Info{AudioEnd: audioEnd, Header: header, VBRHeader: "VBRI", vbriTable: vbri, vbriFramesPerEntry: 10}},
+	}
+	for _, tt := range tests {
+		for _, want := range []float64{0, 1, 10.5, 26, 40} {
+			off, ok := tt.info.SeekOffset(want)
+			if !ok {
+				t.Fatalf("%s: no seek offset", tt.name)
+			}
+			got, ok := tt.info.OffsetTime(off)
+			if !ok {
+				t.Fatalf("%s: no offset time", tt.name)
+			}
+			if math.Abs(got-want) > frame {
+				t.Errorf("%s: OffsetTime(SeekOffset(%v)) = %v", tt.name, want, got)
+			}
+			if n := got / frame; math.Abs(n-math.Round(n)) > 1e-6 {
+				t.Errorf("%s: time %v is not on a frame boundary", tt.name, got)
+			}
+		}
+	}
+}
+
+func TestOffsetTimeWithoutTable(t *testing.T) {
+	info := MP3Info{AudioEnd: 100000, Header: MP3FrameHeader{Version: MPEG1, Layer: 3, Bitrate: 128000, SampleRate: 44100}}
+	if _, ok := info.OffsetTime(5000); ok {
+		t.Error("time estimated without a seek table")
+	}
+}
@@ -0,0 +1,238 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// MP4Box is the header of an ISO base media (MP4) box
+type MP4Box struct {
+	Type       string
+	Offset     int64
+	HeaderSize int64
+	Size       int64
+}
+
+// DataOffset returns the offset of the box payload
+func (b MP4Box) DataOffset() int64 { return b.Offset + b.HeaderSize }
+
+// End returns the offset just past the box
+func (b MP4Box) End() int64 { return b.Offset + b.Size }
+
+// readMP4Boxes lists the boxes stored back to back in [start, end)
+func readMP4Boxes(r io.ReaderAt, start, end int64) ([]MP4Box, error) {
+	var boxes []MP4Box
+	for offset := start; offset+8 <= end; {
+		var header [16]byte
+		if err := readFull(r, header[:8], offset); err != nil {
+			return boxes, err
+		}
+		box := MP4Box{
+			Type:       string(header[4:8]),
+			Offset:     offset,
+			HeaderSize: 8,
+			Size:       int64(binary.BigEndian.Uint32(header[0:4])),
+		}
+		switch box.Size {
+		case 0:
+			box.Size = end - offset
+		case 1:
+			if err := readFull(r, header[8:16], offset+8); err != nil {
+				return boxes, err
+			}
+			box.HeaderSize = 16
+			box.Size = int64(binary.BigEndian.Uint64(header[8:16]))
+		}
+		if box.Size < box.HeaderSize || offset+box.Size > end {
+			return boxes, fmt.Errorf("mp4: invalid %q box size %d", box.Type, box.Size)
+		}
+		boxes = append(boxes, box)
+		offset += box.Size
+	}
+	return boxes, nil
+}
+
+// mp4ChildrenOffset returns where the children of a container box start.
+// "meta" is a full box in ISO files but a plain container in QuickTime ones.
+func mp4ChildrenOffset(r io.ReaderAt, box MP4Box) int64 {
+	if box.Type != "meta" {
+		return box.DataOffset()
+	}
+	var probe [8]byte
+	if err := readFull(r, probe[:], box.DataOffset()); err == nil && string(probe[4:8]) == "hdlr" {
+		return box.DataOffset()
+	}
+	return box.DataOffset() + 4
+}
+
+// findMP4Box follows a path of box types such as moov/udta/meta/ilst
+func findMP4Box(r io.ReaderAt, start, end int64, path ...string) (MP4Box, bool) {
+	var found MP4Box
+	for _, name := range path {
+		boxes, _ := readMP4Boxes(r, start, end)
+		ok := false
+		for _, box := range boxes {
+			if box.Type == name {
+				found, ok = box, true
+				break
+			}
+		}
+		if !ok {
+			return MP4Box{}, false
+		}
+		start, end = mp4ChildrenOffset(r, found), found.End()
+	}
+	return found, true
+}
+
+// readMP4Payload reads the payload of a box
+func readMP4Payload(r io.ReaderAt, box MP4Box) ([]byte, error) {
+	data := make([]byte, box.Size-box.HeaderSize)
+	if err := readFull(r, data, box.DataOffset()); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// MP4Metadata is the information read from an MP4/M4A file
+type MP4Metadata struct {
+	Duration   float64
+	SampleRate int
+	Channels   int
+	// Items maps ilst item types (e.g. "\xa9nam") to their first data payload
+	Items map[string][]byte
+	// Freeform maps "----" item names (e.g. "iTunSMPB") to their text value
+	Freeform map[string]string
+	Chapters []Chapter
+}
+
+// ReadMP4Metadata reads the movie header, the first sound track, iTunes-style
+// tags and chapters
+func ReadMP4Metadata(r io.ReaderAt, size int64) (*MP4Metadata, error) {
+	moov, ok := findMP4Box(r, 0, size, "moov")
+	if !ok {
+		return nil, errors.New("mp4: no moov box")
+	}
+	meta := &MP4Metadata{Items: map[string][]byte{}, Freeform: map[string]string{}}
+
+	if mvhd, ok := findMP4Box(r, moov.DataOffset(), moov.End(), "mvhd"); ok {
+		if data, err := readMP4Payload(r, mvhd); err == nil {
+			if timescale, duration := parseMP4TimeHeader(data); timescale > 0 {
+				meta.Duration = float64(duration) / float64(timescale)
+			}
+		}
+	}
+
+	if trak, ok := findMP4SoundTrack(r, moov); ok {
+		if stsd, ok := findMP4Box(r, trak.DataOffset(), trak.End(), "mdia", "minf", "stbl", "stsd"); ok {
+			if data, err := readMP4Payload(r, stsd); err == nil && len(data) >= 8+8+28 {
+				entry := data[8+8:]
+				meta.Channels = int(binary.BigEndian.Uint16(entry[16:18]))
+				meta.SampleRate = int(binary.BigEndian.Uint16(entry[24:26]))
+			}
+		}
+	}
+
+	if ilst, ok := findMP4Box(r, moov.DataOffset(), moov.End(), "udta", "meta", "ilst"); ok {
+		items, _ := readMP4Boxes(r, ilst.DataOffset(), ilst.End())
+		for _, item := range items {
+			children, _ := readMP4Boxes(r, item.DataOffset(), item.End())
+			var name string
+			for _, child := range children {
+				payload, err := readMP4Payload(r, child)
+				if err != nil {
+					continue
+				}
+				switch child.Type {
+				case "name":
+					if len(payload) >= 4 {
+						name = string(payload[4:])
+					}
+				case "data":
+					if len(payload) < 8 {
+						continue
+					}
+					if item.Type == "----" {
+						if name != "" {
+							meta.Freeform[name] = string(payload[8:])
+						}
+					} else if _, seen := meta.Items[item.Type]; !seen {
+						meta.Items[item.Type] = payload[8:]
+					}
+				}
+			}
+		}
+	}
+	meta.Chapters = readMP4Chapters(r, moov, meta.Duration)
+	return meta, nil
+}
+
+// findMP4SoundTrack returns the first trak whose handler is "soun"
+func findMP4SoundTrack(r io.ReaderAt, moov MP4Box) (MP4Box, bool) {
+	boxes, _ := readMP4Boxes(r, moov.DataOffset(), moov.End())
+	for _, trak := range boxes {
+		if trak.Type != "trak" {
+			continue
+		}
+		hdlr, ok := findMP4Box(r, trak.DataOffset(), trak.End(), "mdia", "hdlr")
+		if !ok {
+			continue
+		}
+		data, err := readMP4Payload(r, hdlr)
+		if err == nil && len(data) >= 12 && string(data[8:12]) == "soun" {
+			return trak, true
+		}
+	}
+	return MP4Box{}, false
+}
+
+// parseMP4TimeHeader extracts timescale and duration from an mvhd or mdhd payload
+func parseMP4TimeHeader(data []byte) (uint32, uint64) {
+	if len(data) < 20 {
+		return 0, 0
+	}
+	if data[0] == 1 {
+		if len(data) < 32 {
+			return 0, 0
+		}
+		return binary.BigEndian.Uint32(data[20:24]), binary.BigEndian.Uint64(data[24:32])
+	}
+	return binary.BigEndian.Uint32(data[12:16]), uint64(binary.BigEndian.Uint32(data[16:20]))
+}
+
+// Text returns a UTF-8 item such as "\xa9nam"
+func (m *MP4Metadata) Text(item string) string {
+	return strings.TrimRight(string(m.Items[item]), "\x00")
+}
+
+// Tags maps iTunes-style items to Tags
+func (m *MP4Metadata) Tags() Tags {
+	tags := Tags{
+		Title:       m.Text("\xa9nam"),
+		Artist:      m.Text("\xa9ART"),
+		Album:       m.Text("\xa9alb"),
+		AlbumArtist: m.Text("aART"),
+		Genre:       m.Text("\xa9gen"),
+		Comment:     m.Text("\xa9cmt"),
+		Year:        parseYear(m.Text("\xa9day")),
+	}
+	if tags.Genre == "" {
+		if g := m.Items["gnre"]; len(g) >= 2 {
+			if n := int(binary.BigEndian.Uint16(g)) - 1; n >= 0 && n < len(id3v1Genres) {
+				tags.Genre = id3v1Genres[n]
+			}
+		}
+	}
+	if v := m.Items["trkn"]; len(v) >= 6 {
+		tags.TrackNumber = int(binary.BigEndian.Uint16(v[2:4]))
+		tags.TrackTotal = int(binary.BigEndian.Uint16(v[4:6]))
+	}
+	if v := m.Items["disk"]; len(v) >= 6 {
+		tags.DiscNumber = int(binary.BigEndian.Uint16(v[2:4]))
+		tags.DiscTotal = int(binary.BigEndian.Uint16(v[4:6]))
+	}
+	return tags
+}
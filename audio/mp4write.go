@@ -0,0 +1,288 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// rewriteMP4Tags replaces the iTunes-style items in moov/udta/meta/ilst,
+// creating the boxes when missing. The moov box is rebuilt in memory; when
+// its size changes a following free box absorbs the difference if it can,
+// otherwise the chunk offsets of media data placed after moov are shifted.
+func rewriteMP4Tags(r io.ReaderAt, size int64, u TagUpdate) ([]tagPart, error) {
+	top, err := readMP4Boxes(r, 0, size)
+	if err != nil {
+		return nil, err
+	}
+	moovIndex := -1
+	for i, box := range top {
+		if box.Type == "moov" {
+			moovIndex = i
+			break
+		}
+	}
+	if moovIndex < 0 {
+		return nil, errors.New("mp4: no moov box")
+	}
+	moov := top[moovIndex]
+	if moov.Size > 64<<20 {
+		return nil, errors.New("mp4: moov box too large")
+	}
+	buf := make([]byte, moov.Size)
+	if err := readFull(r, buf, moov.Offset); err != nil {
+		return nil, err
+	}
+	if _, ok := findMP4Box(bytes.NewReader(buf), 0, int64(len(buf)), "moov", "mvex"); ok {
+		return nil, errors.New("mp4: fragmented files are not supported")
+	}
+
+	current := Tags{}
+	if meta, err := ReadMP4Metadata(r, size); err == nil {
+		current = meta.Tags()
+	}
+	buf, err = updateMP4Items(buf, u, u.Apply(current))
+	if err != nil {
+		return nil, err
+	}
+
+	end := moov.End()
+	delta := int64(len(buf)) - moov.Size
+	if delta != 0 && moovIndex+1 < len(top) {
+		if next := top[moovIndex+1]; (next.Type == "free" || next.Type == "skip") && next.HeaderSize == 8 {
+			if free := next.Size - delta; free >= 8 && free <= math.MaxUint32 {
+				buf = append(buf, mp4Box(next.Type, make([]byte, free-8))...)
+				end, delta = next.End(), 0
+			}
+		}
+	}
+	if delta != 0 {
+		if err := shiftMP4ChunkOffsets(buf, moov.Offset, delta); err != nil {
+			return nil, err
+		}
+	}
+	return []tagPart{sourcePart(r, 0, moov.Offset), bytesPart(buf), sourcePart(r, end, size)}, nil
+}
+
+// updateMP4Items rewrites the ilst box of an in-memory moov box
+func updateMP4Items(moov []byte, u TagUpdate, final Tags) ([]byte, error) {
+	r := bytes.NewReader(moov)
+	size := int64(len(moov))
+
+	// The deepest existing box on the path and its ancestors
+	path := []string{"moov", "udta", "meta", "ilst"}
+	var chain []MP4Box
+	for i := range path {
+		box, ok := findMP4Box(r, 0, size, path[:i+1]...)
+		if !ok {
+			break
+		}
+		chain = append(chain, box)
+	}
+
+	var items []byte
+	if len(chain) == len(path) {
+		ilst := chain[len(chain)-1]
+		boxes, err := readMP4Boxes(r, ilst.DataOffset(), ilst.End())
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range boxes {
+			if !mp4ItemReplaced(item.Type, u) {
+				items = append(items, moov[item.Offset:item.End()]...)
+			}
+		}
+	}
+	items = append(items, mp4NewItems(u, final)...)
+
+	// Build the missing boxes from the inside out
+	insert := mp4Box("ilst", items)
+	for i := len(path) - 2; i >= len(chain); i-- {
+		switch path[i] {
+		case "meta":
+			hdlr := mp4Box("hdlr", append(make([]byte, 8), "mdirappl\x00\x00\x00\x00\x00\x00\x00\x00\x00"...))
+			insert = mp4Box("meta", append(append(make([]byte, 4), hdlr...), insert...))
+		default:
+			insert = mp4Box(path[i], insert)
+		}
+	}
+
+	// Replace ilst, or append the new boxes to the deepest existing one
+	var start, end int64
+	ancestors := chain
+	if len(chain) == len(path) {
+		start, end = chain[len(chain)-1].Offset, chain[len(chain)-1].End()
+		ancestors = chain[:len(chain)-1]
+	} else {
+		start = chain[len(chain)-1].End()
+		end = start
+	}
+
+	delta := int64(len(insert)) - (end - start)
+	out := make([]byte, 0, int64(len(moov))+delta)
+	out = append(out, moov[:start]...)
+	out = append(out, insert...)
+	out = append(out, moov[end:]...)
+	for _, box := range ancestors {
+		if err := resizeMP4Box(out, box, delta); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// mp4ItemReplaced reports whether an ilst item holds a field set in u
+func mp4ItemReplaced(item string, u TagUpdate) bool {
+	switch item {
+	case "\xa9nam":
+		return u.Title != nil
+	case "\xa9ART":
+		return u.Artist != nil
+	case "\xa9alb":
+		return u.Album != nil
+	case "aART":
+		return u.AlbumArtist != nil
+	case "\xa9gen", "gnre":
+		return u.Genre != nil
+	case "\xa9cmt":
+		return u.Comment != nil
+	case "\xa9day":
+		return u.Year != nil
+	case "trkn":
+		return u.TrackNumber != nil || u.TrackTotal != nil
+	case "disk":
+		return u.DiscNumber != nil || u.DiscTotal != nil
+	}
+	return false
+}
+
+// mp4NewItems encodes the items for the fields set in u
+func mp4NewItems(u TagUpdate, final Tags) []byte {
+	var items []byte
+	text := func(item, value string) {
+		if value != "" {
+			// Data type 1 is UTF-8 text
+			items = append(items, mp4Box(item, mp4Box("data", append([]byte{0, 0, 0, 1, 0, 0, 0, 0}, value...)))...)
+		}
+	}
+	pair := func(item string, n, total int, trailer int) {
+		if n == 0 && total == 0 {
+			return
+		}
+		data := make([]byte, 8+6+trailer)
+		binary.BigEndian.PutUint16(data[10:12], uint16(n))
+		binary.BigEndian.PutUint16(data[12:14], uint16(total))
+		items = append(items, mp4Box(item, mp4Box("data", data))...)
+	}
+
+	if u.Title != nil {
+		text("\xa9nam", final.Title)
+	}
+	if u.Artist != nil {
+		text("\xa9ART", final.Artist)
+	}
+	if u.Album != nil {
+		text("\xa9alb", final.Album)
+	}
+	if u.AlbumArtist != nil {
+		text("aART", final.AlbumArtist)
+	}
+	if u.Genre != nil {
+		text("\xa9gen", final.Genre)
+	}
+	if u.Comment != nil {
+		text("\xa9cmt", final.Comment)
+	}
+	if u.Year != nil {
+		text("\xa9day", yearString(final.Year))
+	}
+	if u.TrackNumber != nil || u.TrackTotal != nil {
+		pair("trkn", final.TrackNumber, final.TrackTotal, 2)
+	}
+	if u.DiscNumber != nil || u.DiscTotal != nil {
+		pair("disk", final.DiscNumber, final.DiscTotal, 0)
+	}
+	return items
+}
+
+// mp4Box encodes a box with a 32-bit size
+func mp4Box(boxType string, payload []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(payload)))
+	b = append(b, boxType...)
+	return append(b, payload...)
+}
+
+// resizeMP4Box adds delta to the size field of a box in buf
+func resizeMP4Box(buf []byte, box MP4Box, delta int64) error {
+	newSize := box.Size + delta
+	switch {
+	case box.HeaderSize == 16:
+		binary.BigEndian.PutUint64(buf[box.Offset+8:], uint64(newSize))
+	case binary.BigEndian.Uint32(buf[box.Offset:]) == 0:
+		// Extends to the end of its parent
+	case newSize > math.MaxUint32:
+		return fmt.Errorf("mp4: %q box too large", box.Type)
+	default:
+		binary.BigEndian.PutUint32(buf[box.Offset:], uint32(newSize))
+	}
+	return nil
+}
+
+// shiftMP4ChunkOffsets adds delta to the stco and co64 entries of every
+// track that point past the moov box, which starts at moovOffset in the
+// file and is held in buf
+func shiftMP4ChunkOffsets(buf []byte, moovOffset, delta int64) error {
+	r := bytes.NewReader(buf)
+	moov, ok := findMP4Box(r, 0, int64(len(buf)), "moov")
+	if !ok {
+		return errors.New("mp4: no moov box")
+	}
+	traks, err := readMP4Boxes(r, moov.DataOffset(), moov.End())
+	if err != nil {
+		return err
+	}
+	for _, trak := range traks {
+		if trak.Type != "trak" {
+			continue
+		}
+		for _, table := range []string{"stco", "co64"} {
+			box, ok := findMP4Box(r, trak.DataOffset(), trak.End(), "mdia", "minf", "stbl", table)
+			if !ok {
+				continue
+			}
+			data := buf[box.DataOffset():box.End()]
+			if len(data) < 8 {
+				return fmt.Errorf("mp4: short %s box", table)
+			}
+			count := int(binary.BigEndian.Uint32(data[4:8]))
+			width := 4
+			if table == "co64" {
+				width = 8
+			}
+			if len(data) < 8+count*width {
+				return fmt.Errorf("mp4: short %s box", table)
+			}
+			for i := range count {
+				entry := data[8+i*width:]
+				if width == 8 {
+					if offset := int64(binary.BigEndian.Uint64(entry)); offset > moovOffset {
+						binary.BigEndian.PutUint64(entry, uint64(offset+delta))
+					}
+					continue
+				}
+				offset := int64(binary.BigEndian.Uint32(entry))
+				if offset <= moovOffset {
+					continue
+				}
+				if offset+delta > math.MaxUint32 {
+					return errors.New("mp4: chunk offset overflow")
+				}
+				binary.BigEndian.PutUint32(entry, uint32(offset+delta))
+			}
+		}
+	}
+	return nil
+}
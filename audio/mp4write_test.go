@@ -0,0 +1,129 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// mp4TestFile builds an M4A file with one sound track whose single chunk
+// is the given audio, stored in mdat after moov. udta may be nil; a free
+// box of the given size follows moov when free > 0.
+func mp4TestFile(audio, udta []byte, free int) []byte {
+	ftyp := mp4Box("ftyp", []byte("M4A \x00\x00\x00\x00"))
+	hdlr := mp4Box("hdlr", append(make([]byte, 8), "soun\x00\x00\x00\x00\x00\x00\x00\x00\x00"...))
+	stco := func(offset int) []byte {
+		return mp4Box("stco", binary.BigEndian.AppendUint32([]byte{0, 0, 0, 0, 0, 0, 0, 1}, uint32(offset)))
+	}
+	build := func(offset int) []byte {
+		trak := mp4Box("trak", mp4Box("mdia", append(hdlr, mp4Box("minf", mp4Box("stbl", stco(offset)))...)))
+		return mp4Box("moov", append(trak, udta...))
+	}
+	moov := build(0)
+	offset := len(ftyp) + len(moov) + free + 8
+	file := append(ftyp, build(offset)...)
+	if free > 0 {
+		file = append(file, mp4Box("free", make([]byte, free-8))...)
+	}
+	return append(file, mp4Box("mdat", audio)...)
+}
+
+// mp4TestItem builds an ilst text item
+func mp4TestItem(item, value string) []byte {
+	return mp4Box(item, mp4Box("data", append([]byte{0, 0, 0, 1, 0, 0, 0, 0}, value...)))
+}
+
+// mp4ChunkOffset returns the first stco entry of the sound track
+func mp4ChunkOffset(t *testing.T, file []byte) int64 {
+	t.Helper()
+	r := bytes.NewReader(file)
+	box, ok := findMP4Box(r, 0, int64(len(file)), "moov", "trak", "mdia", "minf", "stbl", "stco")
+	if !ok {
+		t.Fatal("no stco box")
+	}
+	return int64(binary.BigEndian.Uint32(file[box.DataOffset()+8:]))
+}
+
+func TestRewriteMP4Tags(t *testing.T) {
+	audio := []byte("audio samples")
+	meta := append(make([]byte, 4), mp4Box("ilst", append(mp4TestItem("\xa9nam", "Old"), mp4TestItem("\xa9too", "Encoder")...))...)
+	file := mp4TestFile(audio, mp4Box("udta", mp4Box("meta", meta)), 0)
+
+	title, track, total := "A much longer title", 2, 10
+	out := rewriteTestFile(t, file, FormatM4A, TagUpdate{Title: &title, TrackNumber: &track, TrackTotal: &total})
+
+	m, err := ReadMP4Metadata(bytes.NewReader(out), int64(len(out)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags := m.Tags(); tags.Title != title || tags.TrackNumber != track || tags.TrackTotal != total {
+		t.Errorf("tags = %+v", tags)
+	}
+	if m.Text("\xa9too") != "Encoder" {
+		t.Error("untouched item was dropped")
+	}
+	offset := mp4ChunkOffset(t, out)
+	if offset == mp4ChunkOffset(t, file) || !bytes.Equal(out[offset:offset+int64(len(audio))], audio) {
+		t.Errorf("chunk offset %d does not point at the moved audio", offset)
+	}
+}
+
+func TestRewriteMP4TagsUsesFreeSpace(t *testing.T) {
+	audio := []byte("audio samples")
+	file := mp4TestFile(audio, nil, 256)
+
+	artist := "Artist"
+	out := rewriteTestFile(t, file, FormatM4A, TagUpdate{Artist: &artist})
+
+	if len(out) != len(file) {
+		t.Errorf("file grew from %d to %d bytes", len(file), len(out))
+	}
+	if mp4ChunkOffset(t, out) != mp4ChunkOffset(t, file) || !bytes.HasSuffix(out, audio) {
+		t.Error("audio moved")
+	}
+	m, err := ReadMP4Metadata(bytes.NewReader(out), int64(len(out)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags := m.Tags(); tags.Artist != artist {
+		t.Errorf("tags = %+v", tags)
+	}
+}
+
+func TestRewriteMP4TagsRoundTrip(t *testing.T) {
+	audio := []byte("audio samples")
+	cover := mp4Box("covr", mp4Box("data", append([]byte{0, 0, 0, 14, 0, 0, 0, 0}, "\x89PNG data"...)))
+	freeform := mp4Box("----", append(append(mp4Box("mean", []byte("\x00\x00\x00\x00com.apple.iTunes")),
+		mp4Box("name", []byte("\x00\x00\x00\x00iTunSMPB"))...), mp4Box("data", []byte("\x00\x00\x00\x01\x00\x00\x00\x00 00000000 00000840"))...))
+	items := append(append(mp4TestItem("\xa9nam", "Title"), cover...), freeform...)
+	file := mp4TestFile(audio, mp4Box("udta", mp4Box("meta", append(make([]byte, 4), mp4Box("ilst", items)...))), 0)
+
+	changed, genre := "Changed", "Jazz"
+	once := rewriteTestFile(t, file, FormatM4A, TagUpdate{Title: &changed, Genre: &genre})
+	for name, item := range map[string][]byte{"cover": cover, "freeform": freeform} {
+		if !bytes.Contains(once, item) {
+			t.Errorf("%s item was not copied unchanged", name)
+		}
+	}
+	if twice := rewriteTestFile(t, once, FormatM4A, TagUpdate{Title: &changed, Genre: &genre}); !bytes.Equal(twice, once) {
+		t.Error("applying the update again changed the file")
+	}
+
+	title, empty := "Title", ""
+	back := rewriteTestFile(t, once, FormatM4A, TagUpdate{Title: &title, Genre: &empty})
+	before, err := ReadMP4Metadata(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := ReadMP4Metadata(bytes.NewReader(back), int64(len(back)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Tags() != before.Tags() || len(after.Items) != len(before.Items) || after.Freeform["iTunSMPB"] != before.Freeform["iTunSMPB"] {
+		t.Errorf("round trip gave %+v, want %+v", after, before)
+	}
+	offset := mp4ChunkOffset(t, back)
+	if !bytes.Equal(back[offset:offset+int64(len(audio))], audio) {
+		t.Error("chunk offset does not point at the audio")
+	}
+}
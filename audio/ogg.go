@@ -0,0 +1,120 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// OggMetadata is the information read from an Ogg Vorbis or Opus stream
+type OggMetadata struct {
+	Codec      string // "vorbis" or "opus"
+	SampleRate int
+	Channels   int
+	Duration   float64
+	Comments   VorbisComments
+}
+
+// oggPage is a parsed Ogg page header with its segment data
+type oggPage struct {
+	segments []int
+	data     []byte
+	size     int64
+}
+
+func readOggPage(r io.ReaderAt, offset int64) (*oggPage, error) {
+	var header [27]byte
+	if err := readFull(r, header[:], offset); err != nil {
+		return nil, err
+	}
+	if string(header[0:4]) != "OggS" {
+		return nil, errors.New("ogg: missing page capture pattern")
+	}
+	table := make([]byte, header[26])
+	if err := readFull(r, table, offset+27); err != nil {
+		return nil, err
+	}
+	page := &oggPage{}
+	total := 0
+	for _, s := range table {
+		page.segments = append(page.segments, int(s))
+		total += int(s)
+	}
+	page.data = make([]byte, total)
+	if err := readFull(r, page.data, offset+27+int64(len(table))); err != nil {
+		return nil, err
+	}
+	page.size = 27 + int64(len(table)) + int64(total)
+	return page, nil
+}
+
+// ReadOggMetadata reads the identification and comment headers and the final granule position
+func ReadOggMetadata(r io.ReaderAt, size int64) (*OggMetadata, error) {
+	// Reassemble the first two packets from the leading pages
+	var packets [][]byte
+	var current []byte
+	offset := int64(0)
+	for len(packets) < 2 && offset < size {
+		page, err := readOggPage(r, offset)
+		if err != nil {
+			return nil, err
+		}
+		offset += page.size
+		pos := 0
+		for _, seg := range page.segments {
+			current = append(current, page.data[pos:pos+seg]...)
+			pos += seg
+			if seg < 255 {
+				packets = append(packets, current)
+				current = nil
+				if len(packets) == 2 {
+					break
+				}
+			}
+		}
+	}
+	if len(packets) < 2 {
+		return nil, errors.New("ogg: missing header packets")
+	}
+
+	meta := &OggMetadata{}
+	id, comments := packets[0], packets[1]
+	preSkip := int64(0)
+	switch {
+	case len(id) >= 16 && bytes.HasPrefix(id, []byte("\x01vorbis")):
+		meta.Codec = "vorbis"
+		meta.Channels = int(id[11])
+		meta.SampleRate = int(binary.LittleEndian.Uint32(id[12:16]))
+		if bytes.HasPrefix(comments, []byte("\x03vorbis")) {
+			meta.Comments = parseVorbisComments(comments[7:])
+		}
+	case len(id) >= 19 && bytes.HasPrefix(id, []byte("OpusHead")):
+		meta.Codec = "opus"
+		meta.Channels = int(id[9])
+		preSkip = int64(binary.LittleEndian.Uint16(id[10:12]))
+		// Opus granule positions always count 48 kHz samples
+		meta.SampleRate = 48000
+		if bytes.HasPrefix(comments, []byte("OpusTags")) {
+			meta.Comments = parseVorbisComments(comments[8:])
+		}
+	default:
+		return nil, errors.New("ogg: unsupported codec")
+	}
+	if meta.Comments == nil {
+		meta.Comments = VorbisComments{}
+	}
+
+	// The last page carries the total sample count
+	tail := min(size, 64*1024)
+	buf := make([]byte, tail)
+	if err := readFull(r, buf, size-tail); err == nil {
+		if i := bytes.LastIndex(buf, []byte("OggS")); i >= 0 && i+14 <= len(buf) {
+			granule := int64(binary.LittleEndian.Uint64(buf[i+6 : i+14]))
+			if granule > preSkip && meta.SampleRate > 0 {
+				meta.Duration = float64(granule-preSkip) / float64(meta.SampleRate)
+			}
+		}
+	}
+	return meta, nil
+}
@@ -0,0 +1,238 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// rewriteOggTags replaces the comment header of an Ogg Vorbis or Opus
+// stream. The header pages after the identification page are rebuilt, and
+// when their number changes the sequence numbers of the following pages
+// are shifted and their checksums recomputed as the copy streams.
+func rewriteOggTags(r io.ReaderAt, size int64, u TagUpdate) ([]tagPart, error) {
+	first, err := readOggPageBytes(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	serial := binary.LittleEndian.Uint32(first[14:18])
+	id := oggPacketData(first)
+
+	// Vorbis has a comment and a setup header, Opus only a comment header
+	headers := 0
+	prefix := ""
+	switch {
+	case bytes.HasPrefix(id, []byte("\x01vorbis")):
+		headers, prefix = 2, "\x03vorbis"
+	case bytes.HasPrefix(id, []byte("OpusHead")):
+		headers, prefix = 1, "OpusTags"
+	default:
+		return nil, errors.New("ogg: unsupported codec")
+	}
+
+	// Reassemble the header packets, which must end on a page boundary
+	var packets [][]byte
+	var current []byte
+	offset := int64(len(first))
+	oldPages := 0
+	for len(packets) < headers || current != nil {
+		if offset >= size {
+			return nil, errors.New("ogg: missing header packets")
+		}
+		page, err := readOggPageBytes(r, offset)
+		if err != nil {
+			return nil, err
+		}
+		if binary.LittleEndian.Uint32(page[14:18]) != serial {
+			return nil, errors.New("ogg: multiplexed streams are not supported")
+		}
+		offset += int64(len(page))
+		oldPages++
+
+		table := page[27 : 27+int(page[26])]
+		data := page[27+len(table):]
+		for _, seg := range table {
+			if len(packets) == headers {
+				return nil, errors.New("ogg: audio data shares a page with the headers")
+			}
+			current = append(current, data[:seg]...)
+			data = data[seg:]
+			if seg < 255 {
+				packets = append(packets, current)
+				current = nil
+			}
+		}
+	}
+	headerEnd := offset
+
+	comment := packets[0]
+	if !bytes.HasPrefix(comment, []byte(prefix)) {
+		return nil, errors.New("ogg: missing comment header")
+	}
+	list, rest, err := parseVorbisCommentList(comment[len(prefix):])
+	if err != nil {
+		return nil, fmt.Errorf("ogg: %w", err)
+	}
+	list.update(u)
+	packets[0] = append(append([]byte(prefix), list.encode()...), rest...)
+
+	seq := binary.LittleEndian.Uint32(first[18:22]) + 1
+	pages, count := buildOggPages(packets, serial, seq)
+
+	parts := []tagPart{bytesPart(first), bytesPart(pages)}
+	if delta := uint32(count - oldPages); delta != 0 {
+		renumber := &oggRenumberReader{r: r, offset: headerEnd, end: size, serial: serial, delta: delta}
+		return append(parts, tagPart{renumber, size - headerEnd}), nil
+	}
+	return append(parts, sourcePart(r, headerEnd, size)), nil
+}
+
+// readOggPageBytes reads a whole page, header included
+func readOggPageBytes(r io.ReaderAt, offset int64) ([]byte, error) {
+	var header [27]byte
+	if err := readFull(r, header[:], offset); err != nil {
+		return nil, err
+	}
+	if string(header[0:4]) != "OggS" {
+		return nil, errors.New("ogg: missing page capture pattern")
+	}
+	table := make([]byte, header[26])
+	if err := readFull(r, table, offset+27); err != nil {
+		return nil, err
+	}
+	size := 27 + len(table)
+	for _, s := range table {
+		size += int(s)
+	}
+	page := make([]byte, size)
+	if err := readFull(r, page, offset); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// oggPacketData returns the segment data of a page
+func oggPacketData(page []byte) []byte {
+	return page[27+int(page[26]):]
+}
+
+// buildOggPages lays packets out on pages starting at sequence number seq
+// and returns the pages and their count. Pages on which no packet ends get
+// a granule position of -1, as the specification requires.
+func buildOggPages(packets [][]byte, serial, seq uint32) ([]byte, int) {
+	type segment struct {
+		data []byte
+		last bool
+	}
+	var segments []segment
+	for _, p := range packets {
+		for len(p) >= 255 {
+			segments = append(segments, segment{p[:255], false})
+			p = p[255:]
+		}
+		segments = append(segments, segment{p, true})
+	}
+
+	var out []byte
+	count := 0
+	continued := false
+	for len(segments) > 0 {
+		n := min(len(segments), 255)
+		page := segments[:n]
+		segments = segments[n:]
+
+		header := make([]byte, 27, 27+n)
+		copy(header, "OggS")
+		if continued {
+			header[5] = 0x01
+		}
+		granule := ^uint64(0)
+		for _, s := range page {
+			if s.last {
+				granule = 0
+			}
+		}
+		binary.LittleEndian.PutUint64(header[6:14], granule)
+		binary.LittleEndian.PutUint32(header[14:18], serial)
+		binary.LittleEndian.PutUint32(header[18:22], seq)
+		header[26] = byte(n)
+		for _, s := range page {
+			header = append(header, byte(len(s.data)))
+		}
+		for _, s := range page {
+			header = append(header, s.data...)
+		}
+		binary.LittleEndian.PutUint32(header[22:26], oggCRC(header))
+		out = append(out, header...)
+
+		continued = !page[n-1].last
+		seq++
+		count++
+	}
+	return out, count
+}
+
+// oggRenumberReader copies Ogg pages, adding delta to the sequence number
+// of the pages of one stream. Anything that is not a page is copied as is.
+type oggRenumberReader struct {
+	r      io.ReaderAt
+	offset int64
+	end    int64
+	serial uint32
+	delta  uint32
+	buf    []byte
+}
+
+func (o *oggRenumberReader) Read(p []byte) (int, error) {
+	for len(o.buf) == 0 {
+		if o.offset >= o.end {
+			return 0, io.EOF
+		}
+		page, err := readOggPageBytes(o.r, o.offset)
+		if err != nil {
+			// Trailing junk after the last page
+			n, err := io.NewSectionReader(o.r, o.offset, o.end-o.offset).Read(p)
+			o.offset += int64(n)
+			return n, err
+		}
+		o.offset += int64(len(page))
+		if binary.LittleEndian.Uint32(page[14:18]) == o.serial {
+			seq := binary.LittleEndian.Uint32(page[18:22]) + o.delta
+			binary.LittleEndian.PutUint32(page[18:22], seq)
+			binary.LittleEndian.PutUint32(page[22:26], 0)
+			binary.LittleEndian.PutUint32(page[22:26], oggCRC(page))
+		}
+		o.buf = page
+	}
+	n := copy(p, o.buf)
+	o.buf = o.buf[n:]
+	return n, nil
+}
+
+var oggCRCTable = func() [256]uint32 {
+	var table [256]uint32
+	for i := range table {
+		crc := uint32(i) << 24
+		for range 8 {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+// oggCRC computes the page checksum, which must be taken with the
+// checksum field zeroed
+func oggCRC(page []byte) uint32 {
+	crc := uint32(0)
+	for _, b := range page {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	return crc
+}
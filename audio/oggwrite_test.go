@@ -0,0 +1,75 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+func TestOggCRC(t *testing.T) {
+	if got := oggCRC([]byte("123456789")); got != 0x89A1897F {
+		t.Errorf("oggCRC = %#x, want 0x89a1897f", got)
+	}
+}
+
+// oggTestPage builds a single-segment page with a valid checksum
+func oggTestPage(serial, seq uint32, data []byte) []byte {
+	page := make([]byte, 27, 28+len(data))
+	copy(page, "OggS")
+	binary.LittleEndian.PutUint32(page[14:18], serial)
+	binary.LittleEndian.PutUint32(page[18:22], seq)
+	page[26] = 1
+	page = append(page, byte(len(data)))
+	page = append(page, data...)
+	binary.LittleEndian.PutUint32(page[22:26], oggCRC(page))
+	return page
+}
+
+// oggPageValid reports whether a page's stored checksum matches its content
+func oggPageValid(page []byte) bool {
+	stored := binary.LittleEndian.Uint32(page[22:26])
+	zeroed := append([]byte(nil), page...)
+	binary.LittleEndian.PutUint32(zeroed[22:26], 0)
+	return oggCRC(zeroed) == stored
+}
+
+func TestOggRenumberReader(t *testing.T) {
+	pages := [][]byte{
+		oggTestPage(1, 2, []byte("audio")),
+		oggTestPage(7, 5, []byte("other stream")),
+		oggTestPage(1, 3, []byte("more audio")),
+	}
+	var stream []byte
+	for _, page := range pages {
+		stream = append(stream, page...)
+	}
+	stream = append(stream, "junk"...)
+
+	r := &oggRenumberReader{r: bytes.NewReader(stream), end: int64(len(stream)), serial: 1, delta: 4}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != len(stream) || !bytes.HasSuffix(out, []byte("junk")) {
+		t.Fatalf("output is %d bytes, want %d ending in the trailing data", len(out), len(stream))
+	}
+
+	offset := 0
+	for i, want := range []uint32{6, 5, 7} {
+		page := out[offset : offset+len(pages[i])]
+		offset += len(pages[i])
+		if seq := binary.LittleEndian.Uint32(page[18:22]); seq != want {
+			t.Errorf("page %d sequence = %d, want %d", i, seq, want)
+		}
+		if !oggPageValid(page) {
+			t.Errorf("page %d checksum is invalid", i)
+		}
+		if !bytes.Equal(oggPacketData(page), oggPacketData(pages[i])) {
+			t.Errorf("page %d data changed", i)
+		}
+	}
+	if !bytes.Equal(out[len(pages[0]):len(pages[0])+len(pages[1])], pages[1]) {
+		t.Error("page of another stream was changed")
+	}
+}
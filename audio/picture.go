@@ -0,0 +1,158 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ErrNoPicture is returned for files without embedded artwork
+var ErrNoPicture = errors.New("no embedded picture")
+
+// pictureFrontCover is the ID3 APIC and FLAC PICTURE type of front covers
+const pictureFrontCover = 3
+
+// Picture is artwork embedded in an audio file
+type Picture struct {
+	MIMEType string
+	Data     []byte
+}
+
+// ReadPicture returns the front cover embedded in an MP3, AAC, FLAC or
+// MP4 file, or failing that its first picture
+func ReadPicture(r io.ReaderAt, size int64, format Format) (*Picture, error) {
+	var pictures []picture
+	switch format {
+	case FormatMP3, FormatAAC:
+		tag, err := ReadID3v2(r)
+		if err != nil {
+			return nil, ErrNoPicture
+		}
+		pictures = tag.pictures()
+
+	case FormatFLAC:
+		flac, err := ReadFLACMetadata(r)
+		if err != nil {
+			return nil, err
+		}
+		for _, block := range flac.Blocks {
+			if block.Type == FLACPicture {
+				if p, ok := parseFLACPicture(block.Data); ok {
+					pictures = append(pictures, p)
+				}
+			}
+		}
+		if tag, err := ReadID3v2(r); err == nil {
+			pictures = append(pictures, tag.pictures()...)
+		}
+
+	case FormatM4A:
+		meta, err := ReadMP4Metadata(r, size)
+		if err != nil {
+			return nil, err
+		}
+		if data := meta.Items["covr"]; len(data) > 0 {
+			pictures = append(pictures, picture{kind: pictureFrontCover, Picture: Picture{Data: data}})
+		}
+	}
+
+	var best *picture
+	for i, p := range pictures {
+		if best == nil || (p.kind == pictureFrontCover && best.kind != pictureFrontCover) {
+			best = &pictures[i]
+		}
+	}
+	if best == nil {
+		return nil, ErrNoPicture
+	}
+	if !strings.HasPrefix(best.MIMEType, "image/") {
+		best.MIMEType = http.DetectContentType(best.Data)
+	}
+	return &best.Picture, nil
+}
+
+// picture is an embedded picture with its ID3/FLAC picture type
+type picture struct {
+	Picture
+	kind int
+}
+
+// pictures returns the APIC frames of a tag
+func (t *ID3Tag) pictures() []picture {
+	var list []picture
+	for _, f := range t.Frames {
+		if f.ID != "APIC" || len(f.Data) < 2 {
+			continue
+		}
+		encoding, b := f.Data[0], f.Data[1:]
+		var mime string
+		if t.Version == 2 {
+			// v2.2 PIC frames name the image format in three characters
+			if len(b) < 4 {
+				continue
+			}
+			mime, b = "image/"+strings.ToLower(strings.TrimSpace(string(b[:3]))), b[3:]
+		} else {
+			end := bytes.IndexByte(b, 0)
+			if end < 0 || end+1 >= len(b) {
+				continue
+			}
+			mime, b = strings.ToLower(string(b[:end])), b[end+1:]
+		}
+		if mime == "image/jpg" {
+			mime = "image/jpeg"
+		}
+		kind := int(b[0])
+		end := indexID3Terminator(encoding, b[1:])
+		if end < 0 {
+			continue
+		}
+		data := b[1+end+len(id3Terminator(encoding)):]
+		if len(data) > 0 {
+			list = append(list, picture{kind: kind, Picture: Picture{MIMEType: mime, Data: data}})
+		}
+	}
+	return list
+}
+
+// parseFLACPicture decodes a METADATA_BLOCK_PICTURE
+func parseFLACPicture(b []byte) (picture, bool) {
+	// field reads a 32-bit length followed by that many bytes
+	field := func() ([]byte, bool) {
+		if len(b) < 4 {
+			return nil, false
+		}
+		n := binary.BigEndian.Uint32(b)
+		if uint64(n) > uint64(len(b)-4) {
+			return nil, false
+		}
+		v := b[4 : 4+n]
+		b = b[4+n:]
+		return v, true
+	}
+	if len(b) < 4 {
+		return picture{}, false
+	}
+	kind := int(binary.BigEndian.Uint32(b))
+	b = b[4:]
+	mime, ok := field()
+	if !ok {
+		return picture{}, false
+	}
+	if _, ok := field(); !ok {
+		return picture{}, false
+	}
+	// Width, height, depth and palette size
+	if len(b) < 16 {
+		return picture{}, false
+	}
+	b = b[16:]
+	data, ok := field()
+	if !ok || len(data) == 0 {
+		return picture{}, false
+	}
+	return picture{kind: kind, Picture: Picture{MIMEType: strings.ToLower(string(mime)), Data: data}}, true
+}
@@ -0,0 +1,75 @@
+package audio
+
+import "io"
+
+// readFull reads exactly len(b) bytes at off. Unlike a bare ReadAt it
+// accepts io.EOF when the read still filled b.
+func readFull(r io.ReaderAt, b []byte, off int64) error {
+	n, err := r.ReadAt(b, off)
+	if n == len(b) {
+		return nil
+	}
+	if err == nil || err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// blockSize is the unit in which BlockReaderAt fetches from its source
+const blockSize = 64 * 1024
+
+// maxCachedBlocks bounds the memory held by a BlockReaderAt
+const maxCachedBlocks = 64
+
+// BlockReaderAt caches fixed-size blocks of an underlying ReaderAt so that
+// many small reads (e.g. walking MP4 boxes over HTTP) become a few large ones
+type BlockReaderAt struct {
+	r      io.ReaderAt
+	size   int64
+	blocks map[int64][]byte
+	order  []int64
+}
+
+// NewBlockReaderAt wraps r, which holds size bytes
+func NewBlockReaderAt(r io.ReaderAt, size int64) *BlockReaderAt {
+	return &BlockReaderAt{r: r, size: size, blocks: make(map[int64][]byte)}
+}
+
+// ReadAt implements io.ReaderAt
+func (b *BlockReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= b.size {
+			return n, io.EOF
+		}
+		index := pos / blockSize
+		block, err := b.block(index)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], block[pos-index*blockSize:])
+	}
+	return n, nil
+}
+
+func (b *BlockReaderAt) block(index int64) ([]byte, error) {
+	if block, ok := b.blocks[index]; ok {
+		return block, nil
+	}
+	start := index * blockSize
+	block := make([]byte, min(blockSize, b.size-start))
+	if err := readFull(b.r, block, start); err != nil {
+		return nil, err
+	}
+	if len(b.order) == maxCachedBlocks {
+		delete(b.blocks, b.order[0])
+		b.order = b.order[1:]
+	}
+	b.blocks[index] = block
+	b.order = append(b.order, index)
+	return block, nil
+}
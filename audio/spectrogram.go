@@ -0,0 +1,192 @@
+package audio
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"math/cmplx"
+)
+
+// Spectrogram holds the peak power per frequency row for consecutive time columns
+type Spectrogram struct {
+	SampleRate int
+	FFTSize    int
+	Height     int
+	// columns[c][row] is linear power; row 0 is 0 Hz, the last row is Nyquist
+	columns [][]float32
+}
+
+// ComputeSpectrogram decodes the whole stream (mixed to mono) and runs a
+// short-time FFT with 50% overlap. To bound memory, adjacent columns are
+// merged by peak whenever more than maxColumns would be kept.
+func ComputeSpectrogram(dec Decoder, fftSize, height, maxColumns int) (*Spectrogram, error) {
+	if !isPowerOfTwo(fftSize) || fftSize < 64 {
+		return nil, errors.New("spectrogram: fft size must be a power of two >= 64")
+	}
+	if height < 1 || maxColumns < 2 {
+		return nil, errors.New("spectrogram: invalid dimensions")
+	}
+
+	channels := dec.Channels()
+	window := hannWindow(fftSize)
+	var windowSum float64
+	for _, v := range window {
+		windowSum += v
+	}
+	norm := 2 / windowSum
+	bins := fftSize / 2
+
+	spec := &Spectrogram{SampleRate: dec.SampleRate(), FFTSize: fftSize, Height: height}
+	samples := make([]float64, 0, fftSize)
+	spectrum := make([]complex128, fftSize)
+	buf := make([]float32, 4096*channels)
+
+	var current []float32
+	group, grouped := 1, 0
+
+	analyse := func() {
+		for i := range spectrum {
+			spectrum[i] = complex(samples[i]*window[i], 0)
+		}
+		fft(spectrum)
+
+		if current == nil {
+			current = make([]float32, height)
+		}
+		for row := 0; row < height; row++ {
+			lo := row * bins / height
+			hi := max(lo+1, (row+1)*bins/height)
+			var peak float64
+			for bin := lo; bin < hi && bin <= bins; bin++ {
+				mag := cmplx.Abs(spectrum[bin]) * norm
+				peak = max(peak, mag*mag)
+			}
+			current[row] = max(current[row], float32(peak))
+		}
+
+		grouped++
+		if grouped < group {
+			return
+		}
+		spec.columns = append(spec.columns, current)
+		current, grouped = nil, 0
+
+		if len(spec.columns) >= maxColumns {
+			spec.columns = mergeColumns(spec.columns)
+			group *= 2
+		}
+	}
+
+	for {
+		n, err := dec.Read(buf)
+		for i := 0; i+channels <= n; i += channels {
+			var v float32
+			for ch := 0; ch < channels; ch++ {
+				v += buf[i+ch]
+			}
+			samples = append(samples, float64(v)/float64(channels))
+			if len(samples) == fftSize {
+				analyse()
+				// Slide the window forward by half its length
+				samples = append(samples[:0], samples[fftSize/2:]...)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(spec.columns) == 0 && current == nil && len(samples) > 0 {
+		// Zero-pad very short input to a single frame
+		samples = append(samples, make([]float64, fftSize-len(samples))...)
+		analyse()
+	}
+	if current != nil {
+		spec.columns = append(spec.columns, current)
+	}
+	if len(spec.columns) == 0 {
+		return nil, errors.New("spectrogram: no audio decoded")
+	}
+	return spec, nil
+}
+
+// mergeColumns halves the number of columns by taking pairwise peaks
+func mergeColumns(columns [][]float32) [][]float32 {
+	merged := columns[:0]
+	for i := 0; i < len(columns); i += 2 {
+		col := columns[i]
+		if i+1 < len(columns) {
+			for row, v := range columns[i+1] {
+				col[row] = max(col[row], v)
+			}
+		}
+		merged = append(merged, col)
+	}
+	return merged
+}
+
+// Render draws the spectrogram with time on the x axis and frequency rising
+// upwards. Power below minDB (relative to full scale) maps to the first colour.
+func (s *Spectrogram) Render(width int, cmap ColorMap, minDB float64) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, s.Height))
+	cols := len(s.columns)
+	peaks := make([]float32, s.Height)
+
+	for x := 0; x < width; x++ {
+		lo := x * cols / width
+		hi := max(lo+1, (x+1)*cols/width)
+		for row := range peaks {
+			peaks[row] = 0
+		}
+		for c := lo; c < hi && c < cols; c++ {
+			for row, v := range s.columns[c] {
+				peaks[row] = max(peaks[row], v)
+			}
+		}
+
+		for row, power := range peaks {
+			db := minDB
+			if power > 0 {
+				db = 10 * math.Log10(float64(power))
+			}
+			t := (db - minDB) / -minDB
+			img.SetRGBA(x, s.Height-1-row, cmap.At(t))
+		}
+	}
+	return img
+}
+
+// ColorMap is a gradient defined by evenly spaced colour stops
+type ColorMap []color.RGBA
+
+// ColorMaps are the colour maps available for spectrogram rendering
+var ColorMaps = map[string]ColorMap{
+	"gray":    {{0, 0, 0, 255}, {255, 255, 255, 255}},
+	"hot":     {{0, 0, 0, 255}, {230, 0, 0, 255}, {255, 210, 0, 255}, {255, 255, 255, 255}},
+	"viridis": {{68, 1, 84, 255}, {59, 82, 139, 255}, {33, 145, 140, 255}, {94, 201, 98, 255}, {253, 231, 37, 255}},
+	"magma":   {{0, 0, 4, 255}, {59, 15, 112, 255}, {140, 41, 129, 255}, {222, 73, 104, 255}, {254, 159, 109, 255}, {252, 253, 191, 255}},
+	"inferno": {{0, 0, 4, 255}, {66, 10, 104, 255}, {147, 38, 103, 255}, {221, 81, 58, 255}, {252, 165, 10, 255}, {252, 255, 164, 255}},
+}
+
+// At returns the colour for t in [0, 1]
+func (c ColorMap) At(t float64) color.RGBA {
+	if t <= 0 || math.IsNaN(t) {
+		return c[0]
+	}
+	if t >= 1 {
+		return c[len(c)-1]
+	}
+	pos := t * float64(len(c)-1)
+	i := int(pos)
+	frac := pos - float64(i)
+	a, b := c[i], c[i+1]
+	lerp := func(x, y uint8) uint8 {
+		return uint8(float64(x) + (float64(y)-float64(x))*frac)
+	}
+	return color.RGBA{lerp(a.R, b.R), lerp(a.G, b.G), lerp(a.B, b.B), 255}
+}
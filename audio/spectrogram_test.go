@@ -0,0 +1,88 @@
+package audio
+
+import (
+	"bytes"
+	"math"
+	"math/cmplx"
+	"testing"
+)
+
+func TestFFT(t *testing.T) {
+	// A cosine completing 3 cycles puts half its energy in bins 3 and n-3
+	n := 16
+	x := make([]complex128, n)
+	for i := range x {
+		x[i] = complex(math.Cos(2*math.Pi*3*float64(i)/float64(n)), 0)
+	}
+	fft(x)
+	for k, v := range x {
+		want := 0.0
+		if k == 3 || k == n-3 {
+			want = float64(n) / 2
+		}
+		if math.Abs(cmplx.Abs(v)-want) > 1e-9 {
+			t.Errorf("bin %d = %v, want magnitude %v", k, cmplx.Abs(v), want)
+		}
+	}
+}
+
+func TestComputeSpectrogram(t *testing.T) {
+	// 2 seconds of a 1 kHz tone at 8 kHz
+	rate := 8000
+	samples := make([]int16, 2*rate)
+	for i := range samples {
+		samples[i] = int16(16000 * math.Sin(2*math.Pi*1000*float64(i)/float64(rate)))
+	}
+	dec, err := NewDecoder(bytes.NewReader(pcmWAV(rate, 1, samples)), FormatWAV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, err := ComputeSpectrogram(dec, 256, 64, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(spec.columns); n < 8 || n > 16 {
+		t.Errorf("%d columns, want merging to keep 8 to 16", n)
+	}
+	// Rows cover 4 kHz, so 1 kHz is a quarter of the way up
+	for c, col := range spec.columns {
+		peak := 0
+		for row, v := range col {
+			if v > col[peak] {
+				peak = row
+			}
+		}
+		if peak < 15 || peak > 16 {
+			t.Fatalf("column %d peaks in row %d, want 16", c, peak)
+		}
+	}
+
+	img := spec.Render(32, ColorMaps["gray"], -120)
+	if b := img.Bounds(); b.Dx() != 32 || b.Dy() != 64 {
+		t.Errorf("image is %v", b)
+	}
+	// The tone is brighter than the top row, far from it
+	if tone, top := img.RGBAAt(0, 63-16), img.RGBAAt(0, 0); tone.R <= top.R {
+		t.Errorf("tone pixel %v is not brighter than %v", tone, top)
+	}
+}
+
+func TestComputeSpectrogramInvalid(t *testing.T) {
+	dec, _ := NewDecoder(bytes.NewReader(pcmWAV(8000, 1, make([]int16, 100))), FormatWAV)
+	if _, err := ComputeSpectrogram(dec, 100, 64, 16); err == nil {
+		t.Error("fft size 100 accepted")
+	}
+}
+
+func TestColorMapAt(t *testing.T) {
+	gray := ColorMaps["gray"]
+	if c := gray.At(-1); c != gray[0] {
+		t.Errorf("At(-1) = %v", c)
+	}
+	if c := gray.At(2); c != gray[1] {
+		t.Errorf("At(2) = %v", c)
+	}
+	if c := gray.At(0.5); c.R != 127 || c.G != 127 || c.B != 127 {
+		t.Errorf("At(0.5) = %v", c)
+	}
+}
@@ -0,0 +1,160 @@
+package audio
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Tags is the descriptive metadata common to all supported tag formats
+type Tags struct {
+	Title       string `json:"title,omitempty"`
+	Artist      string `json:"artist,omitempty"`
+	Album       string `json:"album,omitempty"`
+	AlbumArtist string `json:"albumArtist,omitempty"`
+	Genre       string `json:"genre,omitempty"`
+	Comment     string `json:"comment,omitempty"`
+	Year        int    `json:"year,omitempty"`
+	TrackNumber int    `json:"trackNumber,omitempty"`
+	TrackTotal  int    `json:"trackTotal,omitempty"`
+	DiscNumber  int    `json:"discNumber,omitempty"`
+	DiscTotal   int    `json:"discTotal,omitempty"`
+}
+
+// merge fills empty fields of t from other
+func (t *Tags) merge(other Tags) {
+	fill := func(dst *string, src string) {
+		if *dst == "" {
+			*dst = src
+		}
+	}
+	fillInt := func(dst *int, src int) {
+		if *dst == 0 {
+			*dst = src
+		}
+	}
+	fill(&t.Title, other.Title)
+	fill(&t.Artist, other.Artist)
+	fill(&t.Album, other.Album)
+	fill(&t.AlbumArtist, other.AlbumArtist)
+	fill(&t.Genre, other.Genre)
+	fill(&t.Comment, other.Comment)
+	fillInt(&t.Year, other.Year)
+	fillInt(&t.TrackNumber, other.TrackNumber)
+	fillInt(&t.TrackTotal, other.TrackTotal)
+	fillInt(&t.DiscNumber, other.DiscNumber)
+	fillInt(&t.DiscTotal, other.DiscTotal)
+}
+
+// parseNumberPair parses "3" or "3/12"
+func parseNumberPair(s string) (int, int) {
+	num, total, _ := strings.Cut(strings.TrimSpace(s), "/")
+	n, _ := strconv.Atoi(strings.TrimSpace(num))
+	t, _ := strconv.Atoi(strings.TrimSpace(total))
+	return n, t
+}
+
+// parseYear extracts the year from values such as "1969" or "1969-08-15"
+func parseYear(s string) int {
+	s = strings.TrimSpace(s)
+	if len(s) < 4 {
+		return 0
+	}
+	year, err := strconv.Atoi(s[:4])
+	if err != nil {
+		return 0
+	}
+	return year
+}
+
+// DecodeText strips a UTF-8 byte order mark and decodes text that is not
+// valid UTF-8 as Latin-1, as written by older rippers and taggers
+func DecodeText(data []byte) string {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if utf8.Valid(data) {
+		return string(data)
+	}
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+// VorbisComments holds Vorbis comment fields keyed by upper-cased name
+type VorbisComments map[string][]string
+
+// Get returns the first value of a field
+func (vc VorbisComments) Get(name string) string {
+	if values := vc[strings.ToUpper(name)]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Tags maps the common Vorbis comment fields to Tags
+func (vc VorbisComments) Tags() Tags {
+	tags := Tags{
+		Title:       vc.Get("TITLE"),
+		Artist:      strings.Join(vc["ARTIST"], "; "),
+		Album:       vc.Get("ALBUM"),
+		AlbumArtist: vc.Get("ALBUMARTIST"),
+		Genre:       strings.Join(vc["GENRE"], "; "),
+		Comment:     vc.Get("COMMENT"),
+		Year:        parseYear(vc.Get("DATE")),
+	}
+	if tags.AlbumArtist == "" {
+		tags.AlbumArtist = vc.Get("ALBUM ARTIST")
+	}
+	if tags.Comment == "" {
+		tags.Comment = vc.Get("DESCRIPTION")
+	}
+	tags.TrackNumber, tags.TrackTotal = parseNumberPair(vc.Get("TRACKNUMBER"))
+	tags.DiscNumber, tags.DiscTotal = parseNumberPair(vc.Get("DISCNUMBER"))
+	if tags.TrackTotal == 0 {
+		tags.TrackTotal, _ = strconv.Atoi(vc.Get("TRACKTOTAL"))
+	}
+	if tags.DiscTotal == 0 {
+		tags.DiscTotal, _ = strconv.Atoi(vc.Get("DISCTOTAL"))
+	}
+	return tags
+}
+
+// parseVorbisComments decodes a Vorbis comment block (without framing bit)
+func parseVorbisComments(b []byte) VorbisComments {
+	vc := VorbisComments{}
+	readLen := func() (int, bool) {
+		if len(b) < 4 {
+			return 0, false
+		}
+		n := int(uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24)
+		b = b[4:]
+		return n, n >= 0 && n <= len(b)
+	}
+
+	vendorLen, ok := readLen()
+	if !ok {
+		return vc
+	}
+	b = b[vendorLen:]
+
+	if len(b) < 4 {
+		return vc
+	}
+	count := int(uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24)
+	b = b[4:]
+	for i := 0; i < count; i++ {
+		n, ok := readLen()
+		if !ok {
+			break
+		}
+		field := string(b[:n])
+		b = b[n:]
+		if name, value, found := strings.Cut(field, "="); found {
+			name = strings.ToUpper(name)
+			vc[name] = append(vc[name], value)
+		}
+	}
+	return vc
+}
@@ -0,0 +1,149 @@
+package audio
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// ErrUnsupportedTag is returned for tags that cannot be rewritten without
+// losing data, such as damaged ID3v2 tags
+var ErrUnsupportedTag = errors.New("unsupported tag")
+
+// TagUpdate lists the tag fields to change. Nil fields are left alone;
+// empty strings and zero numbers remove the field from the file.
+type TagUpdate struct {
+	Title       *string `json:"title"`
+	Artist      *string `json:"artist"`
+	Album       *string `json:"album"`
+	AlbumArtist *string `json:"albumArtist"`
+	Genre       *string `json:"genre"`
+	Comment     *string `json:"comment"`
+	Year        *int    `json:"year"`
+	TrackNumber *int    `json:"trackNumber"`
+	TrackTotal  *int    `json:"trackTotal"`
+	DiscNumber  *int    `json:"discNumber"`
+	DiscTotal   *int    `json:"discTotal"`
+}
+
+// Empty reports whether the update changes nothing
+func (u TagUpdate) Empty() bool {
+	return u == TagUpdate{}
+}
+
+// Apply returns t with the update applied
+func (u TagUpdate) Apply(t Tags) Tags {
+	set := func(dst *string, src *string) {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	setInt := func(dst *int, src *int) {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	set(&t.Title, u.Title)
+	set(&t.Artist, u.Artist)
+	set(&t.Album, u.Album)
+	set(&t.AlbumArtist, u.AlbumArtist)
+	set(&t.Genre, u.Genre)
+	set(&t.Comment, u.Comment)
+	setInt(&t.Year, u.Year)
+	setInt(&t.TrackNumber, u.TrackNumber)
+	setInt(&t.TrackTotal, u.TrackTotal)
+	setInt(&t.DiscNumber, u.DiscNumber)
+	setInt(&t.DiscTotal, u.DiscTotal)
+	return t
+}
+
+// Validate rejects values that no tag format can store
+func (u TagUpdate) Validate() error {
+	for name, v := range map[string]*int{
+		"year": u.Year, "trackNumber": u.TrackNumber, "trackTotal": u.TrackTotal,
+		"discNumber": u.DiscNumber, "discTotal": u.DiscTotal,
+	} {
+		if v != nil && (*v < 0 || *v > 65535) {
+			return fmt.Errorf("%s must be between 0 and 65535", name)
+		}
+	}
+	return nil
+}
+
+// CanWriteTags reports whether RewriteTags supports a format
+func CanWriteTags(format Format) bool {
+	switch format {
+	case FormatMP3, FormatAAC, FormatFLAC, FormatOGG, FormatM4A:
+		return true
+	}
+	return false
+}
+
+// RewriteTags returns a reader producing a copy of the file with the update
+// applied, and the size of that copy. Only the tag area is rebuilt in
+// memory; the audio is streamed from r as the result is read, so r must
+// stay open until then. The update is written to every tag the file
+// carries (e.g. ID3v1 as well as ID3v2) so that no stale value shows
+// through.
+func RewriteTags(r io.ReaderAt, size int64, format Format, u TagUpdate) (io.Reader, int64, error) {
+	var parts []tagPart
+	var err error
+	switch format {
+	case FormatMP3, FormatAAC:
+		parts, err = rewriteID3File(r, size, u)
+	case FormatFLAC:
+		parts, err = rewriteFLACTags(r, size, u)
+	case FormatOGG:
+		parts, err = rewriteOggTags(r, size, u)
+	case FormatM4A:
+		parts, err = rewriteMP4Tags(r, size, u)
+	default:
+		return nil, 0, fmt.Errorf("%w: cannot write tags to %q", ErrUnsupportedFormat, format)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	readers := make([]io.Reader, len(parts))
+	total := int64(0)
+	for i, p := range parts {
+		readers[i] = p.reader
+		total += p.size
+	}
+	return io.MultiReader(readers...), total, nil
+}
+
+// tagPart is a piece of a rewritten file: new bytes or a range of the source
+type tagPart struct {
+	reader io.Reader
+	size   int64
+}
+
+func bytesPart(b []byte) tagPart {
+	return tagPart{bytes.NewReader(b), int64(len(b))}
+}
+
+func sourcePart(r io.ReaderAt, start, end int64) tagPart {
+	return tagPart{io.NewSectionReader(r, start, end-start), end - start}
+}
+
+// numberPair formats "3" or "3/12" for ID3 TRCK and TPOS frames
+func numberPair(n, total int) string {
+	if total > 0 {
+		return strconv.Itoa(n) + "/" + strconv.Itoa(total)
+	}
+	if n > 0 {
+		return strconv.Itoa(n)
+	}
+	return ""
+}
+
+// yearString formats a year, or "" to remove it
+func yearString(year int) string {
+	if year == 0 {
+		return ""
+	}
+	return strconv.Itoa(year)
+}
@@ -0,0 +1,184 @@
+package audio
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+const (
+	// Tempo is searched between minTempo and maxTempo BPM
+	minTempo = 60.0
+	maxTempo = 200.0
+	// maxTempoSeconds bounds the analysed part of long tracks
+	maxTempoSeconds = 600
+	// minTempoCorrelation is the weakest periodicity, relative to the
+	// envelope's energy, reported as a beat
+	minTempoCorrelation = 0.3
+	// minOnsetStrength is the weakest mean onset strength per frequency bin
+	// of a stream with a beat
+	minOnsetStrength = 0.005
+)
+
+// TempoMeter estimates the tempo of a stream from the periodicity of its
+// onsets. Samples are fed with Write while the stream is decoded for
+// another purpose, so the track is only decoded once.
+type TempoMeter struct {
+	channels int
+	// rate is the number of onset strength values per second
+	rate float64
+	size int
+	hop  int
+
+	window   []float64
+	frame    []float64
+	fill     int
+	spectrum []complex128
+	previous []float64
+	// onsets is the spectral flux of each hop
+	onsets []float64
+	limit  int
+}
+
+// NewTempoMeter returns a meter for interleaved samples of a stream
+func NewTempoMeter(sampleRate, channels int) *TempoMeter {
+	// About 23 ms windows with 50% overlap
+	size := 256
+	for size < sampleRate/43 {
+		size *= 2
+	}
+	m := &TempoMeter{
+		channels: max(channels, 1),
+		size:     size,
+		hop:      size / 2,
+		window:   hannWindow(size),
+		frame:    make([]float64, size),
+		spectrum: make([]complex128, size),
+	}
+	m.rate = float64(sampleRate) / float64(m.hop)
+	m.limit = int(maxTempoSeconds * m.rate)
+	return m
+}
+
+// Write adds interleaved samples
+func (m *TempoMeter) Write(samples []float32) {
+	for i := 0; i+m.channels <= len(samples) && len(m.onsets) < m.limit; i += m.channels {
+		var mono float64
+		for _, s := range samples[i : i+m.channels] {
+			mono += float64(s)
+		}
+		m.frame[m.fill] = mono / float64(m.channels)
+		m.fill++
+		if m.fill == m.size {
+			m.addOnset()
+			copy(m.frame, m.frame[m.hop:])
+			m.fill -= m.hop
+		}
+	}
+}
+
+// addOnset appends the increase of the log magnitude spectrum since the
+// previous frame
+func (m *TempoMeter) addOnset() {
+	for i, s := range m.frame {
+		m.spectrum[i] = complex(s*m.window[i], 0)
+	}
+	fft(m.spectrum)
+
+	bins := m.size / 2
+	current := make([]float64, bins)
+	flux := 0.0
+	for k := range current {
+		current[k] = math.Log1p(100 * cmplx.Abs(m.spectrum[k]))
+		if m.previous != nil {
+			flux += max(current[k]-m.previous[k], 0)
+		}
+	}
+	m.previous = current
+	m.onsets = append(m.onsets, flux)
+}
+
+// Tempo returns the estimated tempo in BPM, or 0 when the stream has no
+// discernible beat
+func (m *TempoMeter) Tempo() float64 {
+	minLag := int(m.rate * 60 / maxTempo)
+	maxLag := int(math.Ceil(m.rate * 60 / minTempo))
+	// At least a few beats at the slowest tempo
+	if len(m.onsets) < 4*maxLag {
+		return 0
+	}
+
+	// Remove the local mean so sustained loudness does not count as onsets
+	half := int(m.rate / 4)
+	envelope := make([]float64, len(m.onsets))
+	var sum float64
+	for i := range m.onsets {
+		lo, hi := max(i-half, 0), min(i+half+1, len(m.onsets))
+		mean := 0.0
+		for _, v := range m.onsets[lo:hi] {
+			mean += v
+		}
+		mean /= float64(hi - lo)
+		envelope[i] = max(m.onsets[i]-mean, 0)
+		sum += envelope[i]
+	}
+	// Steady tones have almost no flux
+	if sum/float64(len(envelope)*len(m.previous)) < minOnsetStrength {
+		return 0
+	}
+	// Widen the onset peaks, so beats whose period falls between two lags
+	// still correlate
+	smoothed := make([]float64, len(envelope))
+	for i := range envelope {
+		for k, weight := range []float64{1, 2, 3, 2, 1} {
+			if j := i + k - 2; j >= 0 && j < len(envelope) {
+				smoothed[i] += weight / 9 * envelope[j]
+			}
+		}
+	}
+	envelope = smoothed
+
+	// Correlate deviations from the mean, so that only periodic onsets
+	// correlate
+	mean := sum / float64(len(envelope))
+	for i := range envelope {
+		envelope[i] -= mean
+	}
+
+	autocorrelation := func(lag int) float64 {
+		if lag >= len(envelope) {
+			return 0
+		}
+		var acc float64
+		for i := lag; i < len(envelope); i++ {
+			acc += envelope[i] * envelope[i-lag]
+		}
+		return acc / float64(len(envelope)-lag)
+	}
+
+	scores := make([]float64, maxLag+2)
+	best := 0
+	for lag := minLag; lag <= maxLag+1; lag++ {
+		bpm := 60 * m.rate / float64(lag)
+		// Periodicity at twice the period reinforces a beat, and a prior
+		// centred on 120 BPM resolves octave ambiguity
+		octaves := math.Log2(bpm / 120)
+		scores[lag] = (autocorrelation(lag) + 0.5*autocorrelation(2*lag)) * math.Exp(-0.5*octaves*octaves)
+		if lag <= maxLag && (best == 0 || scores[lag] > scores[best]) {
+			best = lag
+		}
+	}
+	// Without a beat the envelope barely correlates with itself
+	if scores[best] <= minTempoCorrelation*autocorrelation(0) {
+		return 0
+	}
+
+	// Parabolic interpolation between neighbouring lags
+	lag := float64(best)
+	if best > minLag {
+		a, b, c := scores[best-1], scores[best], scores[best+1]
+		if d := a - 2*b + c; d < 0 {
+			lag += 0.5 * (a - c) / d
+		}
+	}
+	return math.Round(600*m.rate/lag) / 10
+}
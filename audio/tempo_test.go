@@ -0,0 +1,59 @@
+package audio
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+// clickTrack returns stereo samples with a short noise burst on every beat
+func clickTrack(rate int, bpm, seconds float64) []float32 {
+	rng := rand.New(rand.NewPCG(1, 2))
+	n := int(float64(rate) * seconds)
+	period := float64(rate) * 60 / bpm
+	burst := rate / 100
+	samples := make([]float32, 2*n)
+	for beat := 0.0; int(beat) < n; beat += period {
+		for i := int(beat); i < min(int(beat)+burst, n); i++ {
+			v := float32(rng.Float64()*2-1) * 0.8
+			samples[2*i], samples[2*i+1] = v, v
+		}
+	}
+	return samples
+}
+
+func TestTempoMeter(t *testing.T) {
+	for _, bpm := range []float64{90, 120, 150} {
+		m := NewTempoMeter(44100, 2)
+		samples := clickTrack(44100, bpm, 30)
+		// Feed in uneven blocks, as a decoder would
+		for len(samples) > 0 {
+			n := min(len(samples), 2*1151)
+			m.Write(samples[:n])
+			samples = samples[n:]
+		}
+		if got := m.Tempo(); math.Abs(got-bpm) > 2 {
+			t.Errorf("tempo of a %v BPM click track = %v", bpm, got)
+		}
+	}
+}
+
+func TestTempoMeterNoBeat(t *testing.T) {
+	const rate = 44100
+	tone := make([]float32, 30*rate)
+	for i := range tone {
+		tone[i] = float32(0.5 * math.Sin(2*math.Pi*440*float64(i)/rate))
+	}
+	tests := map[string][]float32{
+		"tone":    tone,
+		"silence": make([]float32, 30*rate),
+		"short":   clickTrack(rate, 120, 2)[:2*rate],
+	}
+	for name, samples := range tests {
+		m := NewTempoMeter(rate, 1)
+		m.Write(samples)
+		if got := m.Tempo(); got != 0 {
+			t.Errorf("tempo of %s = %v, want 0", name, got)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// vorbisCommentList is a Vorbis comment block kept in file order, so that
+// fields the update does not touch are written back unchanged
+type vorbisCommentList struct {
+	vendor string
+	fields []string
+}
+
+// parseVorbisCommentList decodes a Vorbis comment block and returns the
+// bytes that follow it, such as the Vorbis framing bit
+func parseVorbisCommentList(b []byte) (*vorbisCommentList, []byte, error) {
+	errShort := errors.New("vorbis: truncated comment block")
+	readString := func() (string, error) {
+		if len(b) < 4 {
+			return "", errShort
+		}
+		n := binary.LittleEndian.Uint32(b)
+		if uint64(n) > uint64(len(b)-4) {
+			return "", errShort
+		}
+		s := string(b[4 : 4+n])
+		b = b[4+n:]
+		return s, nil
+	}
+
+	vendor, err := readString()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(b) < 4 {
+		return nil, nil, errShort
+	}
+	count := binary.LittleEndian.Uint32(b)
+	b = b[4:]
+	list := &vorbisCommentList{vendor: vendor}
+	for i := uint32(0); i < count; i++ {
+		field, err := readString()
+		if err != nil {
+			return nil, nil, err
+		}
+		list.fields = append(list.fields, field)
+	}
+	return list, b, nil
+}
+
+// comments returns the fields keyed by upper-cased name
+func (l *vorbisCommentList) comments() VorbisComments {
+	vc := VorbisComments{}
+	for _, field := range l.fields {
+		if name, value, ok := strings.Cut(field, "="); ok {
+			name = strings.ToUpper(name)
+			vc[name] = append(vc[name], value)
+		}
+	}
+	return vc
+}
+
+// set replaces every field with one of the given names by a single value,
+// or removes them when value is empty. The first name is the one written.
+func (l *vorbisCommentList) set(value string, names ...string) {
+	kept := l.fields[:0]
+	for _, field := range l.fields {
+		name, _, _ := strings.Cut(field, "=")
+		drop := false
+		for _, n := range names {
+			if strings.EqualFold(name, n) {
+				drop = true
+				break
+			}
+		}
+		if !drop {
+			kept = append(kept, field)
+		}
+	}
+	l.fields = kept
+	if value != "" {
+		l.fields = append(l.fields, names[0]+"="+value)
+	}
+}
+
+// update applies a tag update, replacing the fields that Tags reads
+func (l *vorbisCommentList) update(u TagUpdate) {
+	final := u.Apply(l.comments().Tags())
+
+	count := func(n int) string {
+		if n == 0 {
+			return ""
+		}
+		return strconv.Itoa(n)
+	}
+	if u.Title != nil {
+		l.set(final.Title, "TITLE")
+	}
+	if u.Artist != nil {
+		l.set(final.Artist, "ARTIST")
+	}
+	if u.Album != nil {
+		l.set(final.Album, "ALBUM")
+	}
+	if u.AlbumArtist != nil {
+		l.set(final.AlbumArtist, "ALBUMARTIST", "ALBUM ARTIST")
+	}
+	if u.Genre != nil {
+		l.set(final.Genre, "GENRE")
+	}
+	if u.Comment != nil {
+		l.set(final.Comment, "COMMENT", "DESCRIPTION")
+	}
+	if u.Year != nil {
+		l.set(yearString(final.Year), "DATE")
+	}
+	if u.TrackNumber != nil || u.TrackTotal != nil {
+		l.set(count(final.TrackNumber), "TRACKNUMBER")
+		l.set(count(final.TrackTotal), "TRACKTOTAL", "TOTALTRACKS")
+	}
+	if u.DiscNumber != nil || u.DiscTotal != nil {
+		l.set(count(final.DiscNumber), "DISCNUMBER")
+		l.set(count(final.DiscTotal), "DISCTOTAL", "TOTALDISCS")
+	}
+}
+
+// encode serialises the block without framing bit
+func (l *vorbisCommentList) encode() []byte {
+	b := binary.LittleEndian.AppendUint32(nil, uint32(len(l.vendor)))
+	b = append(b, l.vendor...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(l.fields)))
+	for _, field := range l.fields {
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(field)))
+		b = append(b, field...)
+	}
+	return b
+}
+
+// maxFLACBlockSize is the largest payload a metadata block header can describe
+const maxFLACBlockSize = 1<<24 - 1
+
+// rewriteFLACTags replaces the VORBIS_COMMENT block, adding one after
+// STREAMINFO when missing. An ID3v2 tag in front of the stream is updated
+// as well, since its values fill fields the comments leave empty.
+func rewriteFLACTags(r io.ReaderAt, size int64, u TagUpdate) ([]tagPart, error) {
+	meta, err := ReadFLACMetadata(r)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &vorbisCommentList{vendor: "MediaBackend"}
+	if block, ok := meta.Block(FLACVorbisComment); ok {
+		if list, _, err = parseVorbisCommentList(block.Data); err != nil {
+			return nil, fmt.Errorf("flac: %w", err)
+		}
+	}
+	list.update(u)
+	comments := list.encode()
+	if len(comments) > maxFLACBlockSize {
+		return nil, errors.New("flac: comments too large")
+	}
+
+	// Keep the block order, dropping any extra comment blocks
+	var blocks []FLACBlock
+	written := false
+	for _, block := range meta.Blocks {
+		if block.Type == FLACVorbisComment {
+			if !written {
+				blocks = append(blocks, FLACBlock{Type: FLACVorbisComment, Data: comments})
+				written = true
+			}
+			continue
+		}
+		blocks = append(blocks, block)
+		if block.Type == FLACStreamInfo && !hasFLACBlock(meta.Blocks, FLACVorbisComment) {
+			blocks = append(blocks, FLACBlock{Type: FLACVorbisComment, Data: comments})
+			written = true
+		}
+	}
+
+	header := []byte("fLaC")
+	for i, block := range blocks {
+		blockType := block.Type
+		if i == len(blocks)-1 {
+			blockType |= 0x80
+		}
+		n := len(block.Data)
+		header = append(header, blockType, byte(n>>16), byte(n>>8), byte(n))
+		header = append(header, block.Data...)
+	}
+
+	var parts []tagPart
+	if meta.Start > 0 {
+		tag, err := updatedID3v2(r, u)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, bytesPart(tag))
+	}
+	return append(parts, bytesPart(header), sourcePart(r, meta.AudioStart, size)), nil
+}
+
+func hasFLACBlock(blocks []FLACBlock, blockType byte) bool {
+	for _, b := range blocks {
+		if b.Type == blockType {
+			return true
+		}
+	}
+	return false
+}
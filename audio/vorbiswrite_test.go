@@ -0,0 +1,139 @@
+package audio
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// flacTestFile builds a FLAC stream from metadata blocks and audio bytes
+func flacTestFile(audio []byte, blocks ...FLACBlock) []byte {
+	b := []byte("fLaC")
+	for i, block := range blocks {
+		blockType := block.Type
+		if i == len(blocks)-1 {
+			blockType |= 0x80
+		}
+		n := len(block.Data)
+		b = append(b, blockType, byte(n>>16), byte(n>>8), byte(n))
+		b = append(b, block.Data...)
+	}
+	return append(b, audio...)
+}
+
+func TestRewriteFLACTags(t *testing.T) {
+	comments := &vorbisCommentList{vendor: "reference libFLAC", fields: []string{
+		"TITLE=Old", "artist=Artist", "TRACKNUMBER=3", "TOTALTRACKS=9", "REPLAYGAIN_TRACK_GAIN=-6.5 dB",
+	}}
+	picture := FLACBlock{Type: FLACPicture, Data: []byte("picture")}
+	audio := []byte{0xFF, 0xF8, 1, 2, 3, 4}
+	file := flacTestFile(audio, FLACBlock{Type: FLACStreamInfo, Data: make([]byte, 34)},
+		FLACBlock{Type: FLACVorbisComment, Data: comments.encode()}, picture)
+
+	title, total := "New", 12
+	out := rewriteTestFile(t, file, FormatFLAC, TagUpdate{Title: &title, TrackTotal: &total})
+
+	meta, err := ReadFLACMetadata(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(meta.Blocks) != 3 || meta.Blocks[1].Type != FLACVorbisComment || !reflect.DeepEqual(meta.Blocks[2], picture) {
+		t.Fatalf("blocks = %+v", meta.Blocks)
+	}
+	list, _, err := parseVorbisCommentList(meta.Blocks[1].Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"artist=Artist", "REPLAYGAIN_TRACK_GAIN=-6.5 dB", "TITLE=New", "TRACKNUMBER=3", "TRACKTOTAL=12"}
+	if list.vendor != comments.vendor || !reflect.DeepEqual(list.fields, want) {
+		t.Errorf("comments = %q %q", list.vendor, list.fields)
+	}
+	if !bytes.Equal(out[meta.AudioStart:], audio) {
+		t.Error("audio changed")
+	}
+}
+
+func TestRewriteFLACTagsAddsComments(t *testing.T) {
+	file := flacTestFile([]byte{0xFF, 0xF8}, FLACBlock{Type: FLACStreamInfo, Data: make([]byte, 34)},
+		FLACBlock{Type: FLACPadding, Data: make([]byte, 16)})
+	artist := "Artist"
+	out := rewriteTestFile(t, file, FormatFLAC, TagUpdate{Artist: &artist})
+
+	meta, err := ReadFLACMetadata(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(meta.Blocks) != 3 || meta.Blocks[1].Type != FLACVorbisComment || meta.Blocks[2].Type != FLACPadding {
+		t.Fatalf("blocks = %+v", meta.Blocks)
+	}
+	if tags := meta.Comments().Tags(); tags.Artist != artist {
+		t.Errorf("tags = %+v", tags)
+	}
+}
+
+func TestVorbisCommentListRoundTrip(t *testing.T) {
+	list := &vorbisCommentList{vendor: "vendor", fields: []string{"A=1", "B=two=2", "no separator"}}
+	b := append(list.encode(), 1)
+	parsed, rest, err := parseVorbisCommentList(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, list) || !bytes.Equal(rest, []byte{1}) {
+		t.Errorf("parsed %+v with %v left", parsed, rest)
+	}
+	if _, _, err := parseVorbisCommentList(b[:len(b)-3]); err == nil {
+		t.Error("truncated block parsed")
+	}
+}
+
+func TestRewriteFLACTagsRoundTrip(t *testing.T) {
+	comments := &vorbisCommentList{vendor: "reference libFLAC", fields: []string{
+		"TITLE=Title", "ARTIST=First", "ARTIST=Second", "MUSICBRAINZ_TRACKID=abc",
+	}}
+	blocks := []FLACBlock{
+		{Type: FLACStreamInfo, Data: make([]byte, 34)},
+		{Type: FLACApplication, Data: []byte("riffdata")},
+		{Type: FLACVorbisComment, Data: comments.encode()},
+		{Type: FLACPicture, Data: []byte("picture")},
+	}
+	audio := []byte{0xFF, 0xF8, 1, 2, 3, 4}
+	// Some taggers put an ID3v2 tag in front, which is updated as well
+	file := append(id3v23Tag("TIT2", "\x00Title", "APIC", "\x00image/png\x00\x03\x00data"), flacTestFile(audio, blocks...)...)
+
+	changed, year := "Changed", 2020
+	once := rewriteTestFile(t, file, FormatFLAC, TagUpdate{Title: &changed, Year: &year})
+	if twice := rewriteTestFile(t, once, FormatFLAC, TagUpdate{Title: &changed, Year: &year}); !bytes.Equal(twice, once) {
+		t.Error("applying the update again changed the file")
+	}
+	if tag, err := ReadID3v2(bytes.NewReader(once)); err != nil || tag.Tags().Title != changed {
+		t.Errorf("ID3v2 tag not updated: %v", err)
+	}
+
+	title, zero := "Title", 0
+	back := rewriteTestFile(t, once, FormatFLAC, TagUpdate{Title: &title, Year: &zero})
+	meta, err := ReadFLACMetadata(bytes.NewReader(back))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(meta.Blocks) != len(blocks) || !reflect.DeepEqual(meta.Blocks[1], blocks[1]) || !reflect.DeepEqual(meta.Blocks[3], blocks[3]) {
+		t.Fatalf("blocks = %+v", meta.Blocks)
+	}
+	list, _, err := parseVorbisCommentList(meta.Blocks[2].Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"ARTIST=First", "ARTIST=Second", "MUSICBRAINZ_TRACKID=abc", "TITLE=Title"}
+	if !reflect.DeepEqual(list.fields, want) {
+		t.Errorf("comments = %q, want %q", list.fields, want)
+	}
+	if !bytes.Equal(back[meta.AudioStart:], audio) {
+		t.Error("audio changed")
+	}
+	tag, err := ReadID3v2(bytes.NewReader(back))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tag.Frame("APIC"); !ok || tag.Tags().Title != title {
+		t.Errorf("ID3v2 frames = %+v", tag.Frames)
+	}
+}
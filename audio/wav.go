@@ -0,0 +1,176 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	wavFormatPCM        = 1
+	wavFormatFloat      = 3
+	wavFormatExtensible = 0xFFFE
+)
+
+// wavInfo describes the layout of a RIFF/WAVE file
+type wavInfo struct {
+	AudioFormat   uint16
+	Channels      int
+	SampleRate    int
+	BitsPerSample int
+	BlockAlign    int
+	DataOffset    int64
+	DataSize      int64
+	// fmtChunk is the raw body of the fmt chunk
+	fmtChunk []byte
+}
+
+// readWAVInfo parses chunks up to the start of the data chunk, leaving r
+// positioned at the first sample
+func readWAVInfo(r io.Reader) (wavInfo, error) {
+	var info wavInfo
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return info, fmt.Errorf("wav: %w", err)
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return info, errors.New("wav: not a RIFF/WAVE file")
+	}
+	offset := int64(12)
+
+	haveFormat := false
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return info, fmt.Errorf("wav: missing data chunk: %w", err)
+		}
+		offset += 8
+		id := string(chunk[0:4])
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return info, errors.New("wav: short fmt chunk")
+			}
+			body := make([]byte, size)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return info, fmt.Errorf("wav: %w", err)
+			}
+			info.fmtChunk = body
+			info.AudioFormat = binary.LittleEndian.Uint16(body[0:2])
+			info.Channels = int(binary.LittleEndian.Uint16(body[2:4]))
+			info.SampleRate = int(binary.LittleEndian.Uint32(body[4:8]))
+			info.BlockAlign = int(binary.LittleEndian.Uint16(body[12:14]))
+			info.BitsPerSample = int(binary.LittleEndian.Uint16(body[14:16]))
+			if info.AudioFormat == wavFormatExtensible && size >= 26 {
+				// The sub-format GUID starts with the real format code
+				info.AudioFormat = binary.LittleEndian.Uint16(body[24:26])
+			}
+			haveFormat = true
+		case "data":
+			if !haveFormat {
+				return info, errors.New("wav: data chunk before fmt chunk")
+			}
+			info.DataOffset = offset
+			info.DataSize = size
+			return info, nil
+		default:
+			if _, err := io.CopyN(io.Discard, r, size); err != nil {
+				return info, fmt.Errorf("wav: %w", err)
+			}
+		}
+		offset += size
+		// Chunks are word aligned
+		if size%2 == 1 {
+			if _, err := io.CopyN(io.Discard, r, 1); err != nil {
+				return info, fmt.Errorf("wav: %w", err)
+			}
+			offset++
+		}
+	}
+}
+
+// wavDecoder reads integer and float PCM samples from a WAVE data chunk
+type wavDecoder struct {
+	r     io.Reader
+	info  wavInfo
+	width int
+	raw   []byte
+}
+
+func newWAVDecoder(r io.Reader) (*wavDecoder, error) {
+	info, err := readWAVInfo(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case info.AudioFormat == wavFormatPCM && info.BitsPerSample >= 8 && info.BitsPerSample <= 32:
+	case info.AudioFormat == wavFormatFloat && (info.BitsPerSample == 32 || info.BitsPerSample == 64):
+	default:
+		return nil, fmt.Errorf("%w: wav format %d with %d bits", ErrUnsupportedFormat, info.AudioFormat, info.BitsPerSample)
+	}
+	if info.Channels == 0 || info.BlockAlign == 0 {
+		return nil, errors.New("wav: invalid fmt chunk")
+	}
+
+	return &wavDecoder{
+		r:     io.LimitReader(r, info.DataSize),
+		info:  info,
+		width: info.BlockAlign / info.Channels,
+	}, nil
+}
+
+func (d *wavDecoder) SampleRate() int { return d.info.SampleRate }
+func (d *wavDecoder) Channels() int   { return d.info.Channels }
+
+func (d *wavDecoder) Read(buf []float32) (int, error) {
+	// Only read whole frames so channels stay interleaved
+	frames := len(buf) / d.info.Channels
+	if frames == 0 {
+		return 0, nil
+	}
+	want := frames * d.info.BlockAlign
+	if cap(d.raw) < want {
+		d.raw = make([]byte, want)
+	}
+	raw := d.raw[:want]
+
+	n, err := io.ReadFull(d.r, raw)
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	samples := n / d.width
+	samples -= samples % d.info.Channels
+	for i := 0; i < samples; i++ {
+		buf[i] = d.sample(raw[i*d.width : (i+1)*d.width])
+	}
+	if samples == 0 && err == nil {
+		err = io.EOF
+	}
+	return samples, err
+}
+
+func (d *wavDecoder) sample(b []byte) float32 {
+	if d.info.AudioFormat == wavFormatFloat {
+		if d.width == 8 {
+			return float32(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+		}
+		return math.Float32frombits(binary.LittleEndian.Uint32(b))
+	}
+
+	// 8-bit WAV is unsigned, wider samples are signed little endian
+	if d.width == 1 {
+		return float32(int(b[0])-128) / 128
+	}
+	var v int32
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | int32(b[i])
+	}
+	shift := 32 - 8*uint(len(b))
+	v = v << shift >> shift
+	return float32(v) / float32(int64(1)<<(8*len(b)-1))
+}
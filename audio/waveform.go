@@ -0,0 +1,228 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// waveformBlockSize is the resolution, in frames, at which peaks are
+// collected before being merged down to the requested number of points
+const waveformBlockSize = 256
+
+// Waveform holds min/max peak pairs in the layout used by audiowaveform
+type Waveform struct {
+	SampleRate      int
+	SamplesPerPixel int
+	Bits            int
+	// Data holds one min,max pair per point, scaled to Bits
+	Data []int16
+}
+
+// waveformJSON is the audiowaveform JSON representation
+type waveformJSON struct {
+	Version         int     `json:"version"`
+	Channels        int     `json:"channels"`
+	SampleRate      int     `json:"sample_rate"`
+	SamplesPerPixel int     `json:"samples_per_pixel"`
+	Bits            int     `json:"bits"`
+	Length          int     `json:"length"`
+	Data            []int16 `json:"data"`
+}
+
+// ComputeWaveform decodes the whole stream, mixing channels down to mono,
+// and returns at most points min/max pairs. bits must be 8 or 16.
+func ComputeWaveform(dec Decoder, points, bits int) (*Waveform, error) {
+	if points < 1 {
+		return nil, errors.New("waveform: points must be positive")
+	}
+	if bits != 8 && bits != 16 {
+		return nil, errors.New("waveform: bits must be 8 or 16")
+	}
+
+	channels := dec.Channels()
+	buf := make([]float32, waveformBlockSize*channels)
+	var mins, maxs []float32
+	var blockMin, blockMax float32
+	filled := 0
+
+	for {
+		n, err := dec.Read(buf)
+		for i := 0; i+channels <= n; i += channels {
+			var v float32
+			for ch := 0; ch < channels; ch++ {
+				v += buf[i+ch]
+			}
+			v /= float32(channels)
+
+			if filled == 0 || v < blockMin {
+				blockMin = v
+			}
+			if filled == 0 || v > blockMax {
+				blockMax = v
+			}
+			filled++
+			if filled == waveformBlockSize {
+				mins = append(mins, blockMin)
+				maxs = append(maxs, blockMax)
+				filled = 0
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if filled > 0 {
+		mins = append(mins, blockMin)
+		maxs = append(maxs, blockMax)
+	}
+
+	// Merge blocks so the result has no more than the requested points
+	perPoint := (len(mins) + points - 1) / points
+	if perPoint < 1 {
+		perPoint = 1
+	}
+	scale := float32(32767)
+	if bits == 8 {
+		scale = 127
+	}
+
+	wf := &Waveform{
+		SampleRate:      dec.SampleRate(),
+		SamplesPerPixel: perPoint * waveformBlockSize,
+		Bits:            bits,
+	}
+	for start := 0; start < len(mins); start += perPoint {
+		end := min(start+perPoint, len(mins))
+		lo, hi := mins[start], maxs[start]
+		for i := start + 1; i < end; i++ {
+			lo = min(lo, mins[i])
+			hi = max(hi, maxs[i])
+		}
+		wf.Data = append(wf.Data, scalePeak(lo, scale), scalePeak(hi, scale))
+	}
+	return wf, nil
+}
+
+func scalePeak(v, scale float32) int16 {
+	v *= scale
+	if v > scale {
+		v = scale
+	}
+	if v < -scale-1 {
+		v = -scale - 1
+	}
+	return int16(v)
+}
+
+// Length returns the number of min/max pairs
+func (wf *Waveform) Length() int {
+	return len(wf.Data) / 2
+}
+
+// MarshalJSON encodes the waveform in audiowaveform's JSON format
+func (wf *Waveform) MarshalJSON() ([]byte, error) {
+	data := wf.Data
+	if data == nil {
+		data = []int16{}
+	}
+	return json.Marshal(waveformJSON{
+		Version:         2,
+		Channels:        1,
+		SampleRate:      wf.SampleRate,
+		SamplesPerPixel: wf.SamplesPerPixel,
+		Bits:            wf.Bits,
+		Length:          wf.Length(),
+		Data:            data,
+	})
+}
+
+// MarshalDat encodes the waveform in audiowaveform's binary .dat format (version 2)
+func (wf *Waveform) MarshalDat() []byte {
+	var buf bytes.Buffer
+	flags := uint32(0)
+	if wf.Bits == 8 {
+		flags = 1
+	}
+	header := []any{
+		int32(2),
+		flags,
+		int32(wf.SampleRate),
+		int32(wf.SamplesPerPixel),
+		uint32(wf.Length()),
+		int32(1),
+	}
+	for _, field := range header {
+		binary.Write(&buf, binary.LittleEndian, field)
+	}
+
+	if wf.Bits == 8 {
+		for _, v := range wf.Data {
+			buf.WriteByte(byte(int8(v)))
+		}
+	} else {
+		binary.Write(&buf, binary.LittleEndian, wf.Data)
+	}
+	return buf.Bytes()
+}
+
+// ParseWaveformDat decodes an audiowaveform .dat file (version 1 or 2, single channel)
+func ParseWaveformDat(data []byte) (*Waveform, error) {
+	r := bytes.NewReader(data)
+	var version int32
+	var flags uint32
+	var sampleRate, samplesPerPixel int32
+	var length uint32
+	for _, field := range []any{&version, &flags, &sampleRate, &samplesPerPixel, &length} {
+		if err := binary.Read(r, binary.LittleEndian, field); err != nil {
+			return nil, fmt.Errorf("waveform: short header: %w", err)
+		}
+	}
+	if version == 2 {
+		var channels int32
+		if err := binary.Read(r, binary.LittleEndian, &channels); err != nil {
+			return nil, fmt.Errorf("waveform: short header: %w", err)
+		}
+		if channels != 1 {
+			return nil, fmt.Errorf("waveform: unsupported channel count %d", channels)
+		}
+	} else if version != 1 {
+		return nil, fmt.Errorf("waveform: unsupported version %d", version)
+	}
+
+	width := int64(2)
+	if flags&1 != 0 {
+		width = 1
+	}
+	if int64(length)*2*width > int64(r.Len()) {
+		return nil, errors.New("waveform: length exceeds data")
+	}
+
+	wf := &Waveform{
+		SampleRate:      int(sampleRate),
+		SamplesPerPixel: int(samplesPerPixel),
+		Bits:            16,
+		Data:            make([]int16, 2*int(length)),
+	}
+	if flags&1 != 0 {
+		wf.Bits = 8
+		raw := make([]byte, len(wf.Data))
+		if _, err := io.ReadFull(r, raw); err != nil {
+			return nil, fmt.Errorf("waveform: short data: %w", err)
+		}
+		for i, b := range raw {
+			wf.Data[i] = int16(int8(b))
+		}
+		return wf, nil
+	}
+	if err := binary.Read(r, binary.LittleEndian, wf.Data); err != nil {
+		return nil, fmt.Errorf("waveform: short data: %w", err)
+	}
+	return wf, nil
+}
@@ -0,0 +1,77 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// pcmWAV builds a 16-bit PCM WAV file from interleaved samples
+func pcmWAV(rate, channels int, samples []int16) []byte {
+	var buf bytes.Buffer
+	dataSize := 2 * len(samples)
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+dataSize))
+	buf.WriteString("WAVEfmt ")
+	for _, field := range []any{
+		uint32(16), uint16(1), uint16(channels), uint32(rate),
+		uint32(rate * channels * 2), uint16(channels * 2), uint16(16),
+	} {
+		binary.Write(&buf, binary.LittleEndian, field)
+	}
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(dataSize))
+	binary.Write(&buf, binary.LittleEndian, samples)
+	return buf.Bytes()
+}
+
+func TestComputeWaveform(t *testing.T) {
+	// Four blocks of stereo audio: silence, a positive and a negative
+	// peak, then full scale on one channel only
+	samples := make([]int16, 4*waveformBlockSize*2)
+	block := func(i int) []int16 { return samples[i*waveformBlockSize*2 : (i+1)*waveformBlockSize*2] }
+	block(1)[10], block(1)[11] = 16384, 16384
+	block(2)[20], block(2)[21] = -16384, -16384
+	block(3)[30] = 32767
+
+	dec, err := NewDecoder(bytes.NewReader(pcmWAV(8000, 2, samples)), FormatWAV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wf, err := ComputeWaveform(dec, 2, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wf.SampleRate != 8000 || wf.SamplesPerPixel != 2*waveformBlockSize || wf.Length() != 2 {
+		t.Fatalf("got %d Hz, %d samples per pixel, %d points", wf.SampleRate, wf.SamplesPerPixel, wf.Length())
+	}
+	// Channels are mixed down, so the one-sided peak is halved
+	if want := []int16{0, 63, -63, 63}; !reflect.DeepEqual(wf.Data, want) {
+		t.Errorf("data = %v, want %v", wf.Data, want)
+	}
+
+	if _, err := ComputeWaveform(dec, 10, 12); err == nil {
+		t.Error("12 bits accepted")
+	}
+}
+
+func TestWaveformDatRoundTrip(t *testing.T) {
+	for _, wf := range []*Waveform{
+		{SampleRate: 44100, SamplesPerPixel: 512, Bits: 8, Data: []int16{-128, 127, -3, 5}},
+		{SampleRate: 48000, SamplesPerPixel: 256, Bits: 16, Data: []int16{-32768, 32767, -300, 500}},
+	} {
+		got, err := ParseWaveformDat(wf.MarshalDat())
+		if err != nil {
+			t.Fatalf("%d bits: %v", wf.Bits, err)
+		}
+		if !reflect.DeepEqual(got, wf) {
+			t.Errorf("%d bits: round trip gave %+v, want %+v", wf.Bits, got, wf)
+		}
+	}
+
+	data := (&Waveform{SampleRate: 44100, SamplesPerPixel: 512, Bits: 16, Data: []int16{1, 2}}).MarshalDat()
+	if _, err := ParseWaveformDat(data[:len(data)-1]); err == nil {
+		t.Error("truncated data accepted")
+	}
+}
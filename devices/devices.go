@@ -0,0 +1,451 @@
+// Package devices keeps track of the players each user has connected and
+// relays remote control commands between them
+package devices
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"MediaBackend/playback"
+	"MediaBackend/randid"
+)
+
+const (
+	// offlineGrace is how long a disconnected device stays listed and keeps
+	// the commands sent to it, so that it picks them up on reconnecting
+	offlineGrace = time.Minute
+	// pendingTTL drops queued commands that would be surprising when late
+	pendingTTL = 30 * time.Second
+	// maxPending limits the commands queued for an offline device
+	maxPending = 20
+	// maxDevices limits the devices of a user
+	maxDevices = 20
+	// outboxSize is how many messages may wait for a slow connection before
+	// it is dropped
+	outboxSize = 64
+	// MaxNameLength limits device names and IDs
+	MaxNameLength = 100
+)
+
+// Commands understood by players
+const (
+	CommandPlay     = "play"
+	CommandPause    = "pause"
+	CommandSeek     = "seek"
+	CommandNext     = "next"
+	CommandPrevious = "previous"
+	CommandVolume   = "volume"
+	// CommandTransfer hands the now playing session over to the target,
+	// while the devices playing so far are paused
+	CommandTransfer = "transfer"
+)
+
+// Message types exchanged over a device connection
+const (
+	// MessageWelcome answers a connection with the device and its peers
+	MessageWelcome = "welcome"
+	// MessageDevices lists the user's devices whenever one changes
+	MessageDevices = "devices"
+	// MessageState reports what a device is playing
+	MessageState = "state"
+	// MessageCommand sends a command, or delivers one to its target
+	MessageCommand = "command"
+	// MessageSent confirms a command, which may be queued for its target
+	MessageSent = "sent"
+	// MessageReplaced closes a connection taken over by a reconnect
+	MessageReplaced = "replaced"
+	MessagePing     = "ping"
+	MessagePong     = "pong"
+	MessageError    = "error"
+)
+
+var (
+	// ErrNotFound is returned for commands to unknown devices
+	ErrNotFound = errors.New("device not found")
+	// ErrInvalid wraps validation errors
+	ErrInvalid = errors.New("invalid device request")
+)
+
+// Device is a player of a user
+type Device struct {
+	// ID is chosen by the device and kept across reconnects
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Type describes the device, e.g. "web", "phone" or "speaker"
+	Type      string    `json:"type,omitempty"`
+	Online    bool      `json:"online"`
+	Connected time.Time `json:"connected"`
+	LastSeen  time.Time `json:"lastSeen"`
+	State     *State    `json:"state,omitempty"`
+}
+
+// State is what a device reports about its playback
+type State struct {
+	TrackID  string  `json:"trackId,omitempty"`
+	Position float64 `json:"position"`
+	Playing  bool    `json:"playing"`
+	// Volume is between 0 and 1
+	Volume  *float64  `json:"volume,omitempty"`
+	Updated time.Time `json:"updated"`
+}
+
+// Command asks a device to act on its playback
+type Command struct {
+	ID      string `json:"id"`
+	Command string `json:"command"`
+	Target  string `json:"target"`
+	// From is the sending device, empty for commands sent over HTTP
+	From string `json:"from,omitempty"`
+	// Position is the time to seek to, in seconds
+	Position *float64 `json:"position,omitempty"`
+	Volume   *float64 `json:"volume,omitempty"`
+	// Session is the now playing session handed over by a transfer
+	Session *playback.Session `json:"session,omitempty"`
+	Sent    time.Time         `json:"sent"`
+}
+
+// Message is exchanged with devices over their connection
+type Message struct {
+	Type string `json:"type"`
+	// Ref echoes the ref of the message being answered
+	Ref     string   `json:"ref,omitempty"`
+	Device  *Device  `json:"device,omitempty"`
+	Devices []Device `json:"devices,omitempty"`
+	State   *State   `json:"state,omitempty"`
+	Command *Command `json:"command,omitempty"`
+	// Delivered tells whether a sent command reached its target or waits
+	// for it to reconnect
+	Delivered *bool  `json:"delivered,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Client is the connection of a device, which relays the messages of its
+// outbox until the registry closes it
+type Client struct {
+	user   string
+	id     string
+	outbox chan Message
+	closed bool
+}
+
+// entry is a device of a user and its current connection
+type entry struct {
+	device  Device
+	client  *Client
+	pending []Command
+	expire  *time.Timer
+}
+
+var (
+	mu    sync.Mutex
+	users = map[string]map[string]*entry{}
+)
+
+// ID returns the ID of the connected device
+func (c *Client) ID() string {
+	return c.id
+}
+
+// Outbox returns the messages for the device, closed when the connection
+// should end
+func (c *Client) Outbox() <-chan Message {
+	return c.outbox
+}
+
+// send queues a message for the device, dropping connections that do not
+// keep up. mu must be held.
+func (c *Client) send(m Message) {
+	if c.closed {
+		return
+	}
+	select {
+	case c.outbox <- m:
+	default:
+		c.close()
+	}
+}
+
+// close ends the outbox. mu must be held.
+func (c *Client) close() {
+	if !c.closed {
+		c.closed = true
+		close(c.outbox)
+	}
+}
+
+// Connect registers a device connection of a user. A device reconnecting
+// with its ID takes over from its previous connection and receives the
+// commands queued while it was away.
+func Connect(user string, d Device) (*Client, error) {
+	d.Name = strings.TrimSpace(d.Name)
+	if len(d.ID) > MaxNameLength || strings.ContainsAny(d.ID, "/?#") {
+		return nil, fmt.Errorf("%w: malformed device ID", ErrInvalid)
+	}
+	if len(d.Name) > MaxNameLength || len(d.Type) > MaxNameLength {
+		return nil, fmt.Errorf("%w: names must be at most %d bytes", ErrInvalid, MaxNameLength)
+	}
+	if d.ID == "" {
+		d.ID = randid.Hex(8)
+	}
+	if d.Name == "" {
+		d.Name = d.ID
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	devices := users[user]
+	if devices == nil {
+		devices = map[string]*entry{}
+		users[user] = devices
+	}
+	e := devices[d.ID]
+	if e == nil {
+		if len(devices) >= maxDevices && !evictOffline(devices) {
+			return nil, fmt.Errorf("%w: at most %d devices", ErrInvalid, maxDevices)
+		}
+		e = &entry{}
+		devices[d.ID] = e
+	}
+	if e.expire != nil {
+		e.expire.Stop()
+		e.expire = nil
+	}
+	if e.client != nil {
+		e.client.send(Message{Type: MessageReplaced})
+		e.client.close()
+	}
+
+	now := time.Now().UTC()
+	state := e.device.State
+	e.device = Device{ID: d.ID, Name: d.Name, Type: d.Type, Online: true, Connected: now, LastSeen: now, State: state}
+	c := &Client{user: user, id: d.ID, outbox: make(chan Message, outboxSize)}
+	e.client = c
+	device := e.device
+	c.send(Message{Type: MessageWelcome, Device: &device, Devices: list(user)})
+	for _, cmd := range e.pending {
+		if now.Sub(cmd.Sent) < pendingTTL {
+			delivered := cmd
+			c.send(Message{Type: MessageCommand, Command: &delivered})
+		}
+	}
+	e.pending = nil
+	broadcast(user, d.ID)
+	return c, nil
+}
+
+// evictOffline forgets the device that has been offline the longest to make
+// room for a new one. mu must be held.
+func evictOffline(devices map[string]*entry) bool {
+	var oldest *entry
+	for _, e := range devices {
+		if e.client == nil && (oldest == nil || e.device.LastSeen.Before(oldest.device.LastSeen)) {
+			oldest = e
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+	if oldest.expire != nil {
+		oldest.expire.Stop()
+	}
+	delete(devices, oldest.device.ID)
+	return true
+}
+
+// Close marks the device offline when its connection ends. It stays listed
+// for a minute in case it reconnects.
+func (c *Client) Close() {
+	mu.Lock()
+	defer mu.Unlock()
+	c.close()
+	e := users[c.user][c.id]
+	if e == nil || e.client != c {
+		return
+	}
+	e.client = nil
+	e.device.Online = false
+	e.device.LastSeen = time.Now().UTC()
+	e.expire = time.AfterFunc(offlineGrace, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if users[c.user][c.id] == e && e.client == nil {
+			delete(users[c.user], c.id)
+			if len(users[c.user]) == 0 {
+				delete(users, c.user)
+			}
+			broadcast(c.user, "")
+		}
+	})
+	broadcast(c.user, "")
+}
+
+// Handle processes a message received from the device
+func (c *Client) Handle(ctx context.Context, m Message) {
+	mu.Lock()
+	e := users[c.user][c.id]
+	if e == nil || e.client != c {
+		mu.Unlock()
+		return
+	}
+	e.device.LastSeen = time.Now().UTC()
+	mu.Unlock()
+
+	switch m.Type {
+	case MessagePing:
+		c.reply(Message{Type: MessagePong, Ref: m.Ref})
+
+	case MessageState:
+		if m.State == nil {
+			c.reply(Message{Type: MessageError, Ref: m.Ref, Error: "missing state"})
+			return
+		}
+		state := *m.State
+		if state.Updated.IsZero() {
+			state.Updated = time.Now().UTC()
+		}
+		mu.Lock()
+		if e.client == c {
+			e.device.State = &state
+			broadcast(c.user, c.id)
+		}
+		mu.Unlock()
+
+	case MessageCommand:
+		if m.Command == nil {
+			c.reply(Message{Type: MessageError, Ref: m.Ref, Error: "missing command"})
+			return
+		}
+		cmd := *m.Command
+		cmd.From = c.id
+		sent, delivered, err := Send(ctx, c.user, cmd)
+		if err != nil {
+			c.reply(Message{Type: MessageError, Ref: m.Ref, Error: err.Error()})
+			return
+		}
+		c.reply(Message{Type: MessageSent, Ref: m.Ref, Command: &sent, Delivered: &delivered})
+
+	default:
+		c.reply(Message{Type: MessageError, Ref: m.Ref, Error: fmt.Sprintf("unknown message type %q", m.Type)})
+	}
+}
+
+func (c *Client) reply(m Message) {
+	mu.Lock()
+	defer mu.Unlock()
+	c.send(m)
+}
+
+// checkCommand validates a command before it is sent
+func checkCommand(cmd Command) error {
+	switch cmd.Command {
+	case CommandPlay, CommandPause, CommandNext, CommandPrevious, CommandTransfer:
+	case CommandSeek:
+		if cmd.Position == nil || *cmd.Position < 0 {
+			return fmt.Errorf("%w: seek needs a position", ErrInvalid)
+		}
+	case CommandVolume:
+		if cmd.Volume == nil || *cmd.Volume < 0 || *cmd.Volume > 1 {
+			return fmt.Errorf("%w: volume must be between 0 and 1", ErrInvalid)
+		}
+	default:
+		return fmt.Errorf("%w: unknown command %q", ErrInvalid, cmd.Command)
+	}
+	if cmd.Target == "" {
+		return fmt.Errorf("%w: missing target device", ErrInvalid)
+	}
+	return nil
+}
+
+// Send delivers a command to a device of the user and reports whether it
+// arrived; commands for a device that has just disconnected wait for it to
+// come back. A transfer carries the user's now playing session and pauses
+// the devices that were playing.
+func Send(ctx context.Context, user string, cmd Command) (Command, bool, error) {
+	if err := checkCommand(cmd); err != nil {
+		return Command{}, false, err
+	}
+	if cmd.Command == CommandTransfer {
+		session, err := playback.Get(ctx, user)
+		if errors.Is(err, playback.ErrNotFound) {
+			return Command{}, false, fmt.Errorf("%w: nothing is playing", ErrInvalid)
+		} else if err != nil {
+			return Command{}, false, err
+		}
+		cmd.Session = &session
+	}
+	cmd.ID = randid.Hex(8)
+	cmd.Sent = time.Now().UTC()
+
+	mu.Lock()
+	defer mu.Unlock()
+	target := users[user][cmd.Target]
+	if target == nil {
+		return Command{}, false, ErrNotFound
+	}
+	if cmd.Command == CommandTransfer {
+		for id, e := range users[user] {
+			if id != cmd.Target && e.client != nil && e.device.State != nil && e.device.State.Playing {
+				e.client.send(Message{Type: MessageCommand, Command: &Command{
+					ID:      randid.Hex(8),
+					Command: CommandPause,
+					Target:  id,
+					From:    cmd.From,
+					Sent:    cmd.Sent,
+				}})
+			}
+		}
+	}
+	if target.client == nil {
+		target.pending = append(target.pending, cmd)
+		if len(target.pending) > maxPending {
+			target.pending = target.pending[len(target.pending)-maxPending:]
+		}
+		return cmd, false, nil
+	}
+	delivered := cmd
+	target.client.send(Message{Type: MessageCommand, Command: &delivered})
+	return cmd, true, nil
+}
+
+// List returns the devices of a user, online ones first
+func List(user string) []Device {
+	mu.Lock()
+	defer mu.Unlock()
+	return list(user)
+}
+
+// list returns the devices of a user. mu must be held.
+func list(user string) []Device {
+	devices := []Device{}
+	for _, e := range users[user] {
+		d := e.device
+		if d.State != nil {
+			state := *d.State
+			d.State = &state
+		}
+		devices = append(devices, d)
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		if devices[i].Online != devices[j].Online {
+			return devices[i].Online
+		}
+		return devices[i].Name < devices[j].Name
+	})
+	return devices
+}
+
+// broadcast sends the device list to the connected devices of a user
+// except the one whose change caused it. mu must be held.
+func broadcast(user, except string) {
+	devices := list(user)
+	for id, e := range users[user] {
+		if id != except && e.client != nil {
+			e.client.send(Message{Type: MessageDevices, Devices: devices})
+		}
+	}
+}
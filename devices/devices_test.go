@@ -0,0 +1,147 @@
+package devices
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// drain returns the messages waiting in a client's outbox
+func drain(c *Client) []Message {
+	var msgs []Message
+	for {
+		select {
+		case m, ok := <-c.outbox:
+			if !ok {
+				return msgs
+			}
+			msgs = append(msgs, m)
+		default:
+			return msgs
+		}
+	}
+}
+
+func connect(t *testing.T, user string, d Device) *Client {
+	t.Helper()
+	c, err := Connect(user, d)
+	if err != nil {
+		t.Fatalf("Connect(%q, %q): %v", user, d.ID, err)
+	}
+	return c
+}
+
+func TestConnect(t *testing.T) {
+	phone := connect(t, "connect", Device{ID: "phone", Name: " Phone ", Type: "phone"})
+	msgs := drain(phone)
+	if len(msgs) != 1 || msgs[0].Type != MessageWelcome || msgs[0].Device.Name != "Phone" {
+		t.Fatalf("welcome = %+v", msgs)
+	}
+
+	web := connect(t, "connect", Device{})
+	if web.ID() == "" {
+		t.Error("device without ID was not given one")
+	}
+	if msgs := drain(phone); len(msgs) != 1 || msgs[0].Type != MessageDevices || len(msgs[0].Devices) != 2 {
+		t.Errorf("phone got %+v, want the new device list", msgs)
+	}
+	drain(web)
+
+	again := connect(t, "connect", Device{ID: "phone", Name: "Phone"})
+	if msgs := drain(phone); len(msgs) != 1 || msgs[0].Type != MessageReplaced {
+		t.Errorf("replaced connection got %+v", msgs)
+	}
+	if _, ok := <-phone.Outbox(); ok {
+		t.Error("replaced connection was not closed")
+	}
+	if got := List("connect"); len(got) != 2 {
+		t.Errorf("List = %d devices, want 2", len(got))
+	}
+	drain(again)
+
+	for _, d := range []Device{{ID: "a/b"}, {ID: "ok", Name: string(make([]byte, MaxNameLength+1))}} {
+		if _, err := Connect("connect", d); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Connect(%+v) = %v, want ErrInvalid", d.ID, err)
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	ctx := context.Background()
+	volume, seek := 0.5, -1.0
+	for _, cmd := range []Command{
+		{Command: "shuffle", Target: "speaker"},
+		{Command: CommandPlay},
+		{Command: CommandSeek, Target: "speaker", Position: &seek},
+		{Command: CommandVolume, Target: "speaker"},
+	} {
+		if _, _, err := Send(ctx, "send", cmd); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Send(%+v) = %v, want ErrInvalid", cmd, err)
+		}
+	}
+	if _, _, err := Send(ctx, "send", Command{Command: CommandPlay, Target: "speaker"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Send to unknown device = %v, want ErrNotFound", err)
+	}
+
+	speaker := connect(t, "send", Device{ID: "speaker"})
+	drain(speaker)
+	sent, delivered, err := Send(ctx, "send", Command{Command: CommandVolume, Target: "speaker", Volume: &volume})
+	if err != nil || !delivered || sent.ID == "" {
+		t.Fatalf("Send = %+v, %v, %v", sent, delivered, err)
+	}
+	msgs := drain(speaker)
+	if len(msgs) != 1 || msgs[0].Command == nil || msgs[0].Command.ID != sent.ID {
+		t.Errorf("speaker got %+v, want the command", msgs)
+	}
+
+	// Commands for an offline device wait for it to reconnect
+	speaker.Close()
+	if d := List("send"); len(d) != 1 || d[0].Online {
+		t.Errorf("closed device listed as %+v", d)
+	}
+	if _, delivered, err := Send(ctx, "send", Command{Command: CommandPause, Target: "speaker"}); err != nil || delivered {
+		t.Fatalf("Send to offline device = %v, %v", delivered, err)
+	}
+	speaker = connect(t, "send", Device{ID: "speaker"})
+	msgs = drain(speaker)
+	if len(msgs) != 2 || msgs[1].Type != MessageCommand || msgs[1].Command.Command != CommandPause {
+		t.Errorf("reconnected device got %+v, want welcome and the queued pause", msgs)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	ctx := context.Background()
+	phone := connect(t, "handle", Device{ID: "phone"})
+	speaker := connect(t, "handle", Device{ID: "speaker"})
+	drain(phone)
+	drain(speaker)
+
+	phone.Handle(ctx, Message{Type: MessagePing, Ref: "1"})
+	if msgs := drain(phone); len(msgs) != 1 || msgs[0].Type != MessagePong || msgs[0].Ref != "1" {
+		t.Errorf("ping answered with %+v", msgs)
+	}
+
+	speaker.Handle(ctx, Message{Type: MessageState, State: &State{TrackID: "t1", Playing: true}})
+	msgs := drain(phone)
+	if len(msgs) != 1 || msgs[0].Type != MessageDevices {
+		t.Fatalf("state change sent %+v", msgs)
+	}
+	for _, d := range msgs[0].Devices {
+		if d.ID == "speaker" && (d.State == nil || d.State.TrackID != "t1" || d.State.Updated.IsZero()) {
+			t.Errorf("speaker state = %+v", d.State)
+		}
+	}
+
+	phone.Handle(ctx, Message{Type: MessageCommand, Ref: "2", Command: &Command{Command: CommandNext, Target: "speaker"}})
+	if msgs := drain(phone); len(msgs) != 1 || msgs[0].Type != MessageSent || msgs[0].Delivered == nil || !*msgs[0].Delivered {
+		t.Errorf("command confirmed with %+v", msgs)
+	}
+	if msgs := drain(speaker); len(msgs) != 1 || msgs[0].Command.From != "phone" {
+		t.Errorf("speaker got %+v, want the command from the phone", msgs)
+	}
+
+	phone.Handle(ctx, Message{Type: "dance", Ref: "3"})
+	if msgs := drain(phone); len(msgs) != 1 || msgs[0].Type != MessageError {
+		t.Errorf("unknown message answered with %+v", msgs)
+	}
+}
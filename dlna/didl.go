@@ -0,0 +1,170 @@
+package dlna
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"math"
+)
+
+// Classes of the objects in the content directory
+const (
+	ClassContainer     = "object.container"
+	ClassStorageFolder = "object.container.storageFolder"
+	ClassMusicArtist   = "object.container.person.musicArtist"
+	ClassMusicAlbum    = "object.container.album.musicAlbum"
+	ClassMusicTrack    = "object.item.audioItem.musicTrack"
+	ClassAudioBook     = "object.item.audioItem.audioBook"
+)
+
+// Object is a container or item of the content directory
+type Object struct {
+	ID       string
+	ParentID string
+	Title    string
+	Class    string
+	// Container objects are browsed into; ChildCount is their size
+	Container  bool
+	ChildCount int
+	Artist     string
+	Album      string
+	Genre      string
+	// Date is "YYYY-MM-DD"
+	Date        string
+	TrackNumber int
+	AlbumArtURI string
+	Res         *Resource
+}
+
+// Resource is the stream of an item
+type Resource struct {
+	URL          string
+	ProtocolInfo string
+	// Size is left out when unknown
+	Size int64
+	// Duration is in seconds
+	Duration float64
+	// Bitrate is in bits per second; DIDL-Lite states it in bytes
+	Bitrate         int
+	SampleFrequency int
+	Channels        int
+}
+
+type didlLite struct {
+	XMLName xml.Name     `xml:"DIDL-Lite"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	DC      string       `xml:"xmlns:dc,attr"`
+	UPnP    string       `xml:"xmlns:upnp,attr"`
+	Objects []didlObject `xml:"object"`
+}
+
+type didlObject struct {
+	// XMLName is "container" or "item"
+	XMLName     xml.Name
+	ID          string        `xml:"id,attr"`
+	ParentID    string        `xml:"parentID,attr"`
+	Restricted  int           `xml:"restricted,attr"`
+	ChildCount  *int          `xml:"childCount,attr,omitempty"`
+	Title       string        `xml:"dc:title"`
+	Creator     string        `xml:"dc:creator,omitempty"`
+	Date        string        `xml:"dc:date,omitempty"`
+	Class       string        `xml:"upnp:class"`
+	Artist      string        `xml:"upnp:artist,omitempty"`
+	Album       string        `xml:"upnp:album,omitempty"`
+	Genre       string        `xml:"upnp:genre,omitempty"`
+	TrackNumber int           `xml:"upnp:originalTrackNumber,omitempty"`
+	AlbumArtURI string        `xml:"upnp:albumArtURI,omitempty"`
+	Res         *didlResource `xml:"res,omitempty"`
+}
+
+type didlResource struct {
+	ProtocolInfo    string `xml:"protocolInfo,attr"`
+	Size            int64  `xml:"size,attr,omitempty"`
+	Duration        string `xml:"duration,attr,omitempty"`
+	Bitrate         int    `xml:"bitrate,attr,omitempty"`
+	SampleFrequency int    `xml:"sampleFrequency,attr,omitempty"`
+	Channels        int    `xml:"nrAudioChannels,attr,omitempty"`
+	URL             string `xml:",chardata"`
+}
+
+// MarshalDIDL returns the DIDL-Lite document describing objects, as sent in
+// the Result of Browse and Search
+func MarshalDIDL(objects []Object) string {
+	doc := didlLite{
+		Xmlns:   "urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/",
+		DC:      "http://purl.org/dc/elements/1.1/",
+		UPnP:    "urn:schemas-upnp-org:metadata-1-0/upnp/",
+		Objects: make([]didlObject, len(objects)),
+	}
+	for i, o := range objects {
+		d := didlObject{
+			XMLName:     xml.Name{Local: "item"},
+			ID:          o.ID,
+			ParentID:    o.ParentID,
+			Restricted:  1,
+			Title:       o.Title,
+			Creator:     o.Artist,
+			Date:        o.Date,
+			Class:       o.Class,
+			Artist:      o.Artist,
+			Album:       o.Album,
+			Genre:       o.Genre,
+			TrackNumber: o.TrackNumber,
+			AlbumArtURI: o.AlbumArtURI,
+		}
+		if o.Container {
+			d.XMLName.Local = "container"
+			count := o.ChildCount
+			d.ChildCount = &count
+		}
+		if res := o.Res; res != nil {
+			d.Res = &didlResource{
+				ProtocolInfo:    res.ProtocolInfo,
+				Size:            res.Size,
+				Bitrate:         res.Bitrate / 8,
+				SampleFrequency: res.SampleFrequency,
+				Channels:        res.Channels,
+				URL:             res.URL,
+			}
+			if res.Duration > 0 {
+				d.Res.Duration = FormatDuration(res.Duration)
+			}
+		}
+		doc.Objects[i] = d
+	}
+	out, err := xml.Marshal(doc)
+	if err != nil {
+		log.Printf("Error marshaling DIDL-Lite: %v", err)
+		return ""
+	}
+	return string(out)
+}
+
+// FormatDuration formats seconds as H:MM:SS.mmm
+func FormatDuration(seconds float64) string {
+	ms := int64(math.Round(seconds * 1000))
+	return fmt.Sprintf("%d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// dlnaFlags marks streaming transfer and DLNA 1.5 support
+const dlnaFlags = "01700000000000000000000000000000"
+
+// ContentFeatures returns the DLNA parameters of a stream, sent in the
+// contentFeatures.dlna.org header and the protocol info. byteSeek tells
+// players that ranges are served.
+func ContentFeatures(mimeType string, byteSeek bool) string {
+	features := ""
+	if mimeType == "audio/mpeg" {
+		features = "DLNA.ORG_PN=MP3;"
+	}
+	op := "00"
+	if byteSeek {
+		op = "01"
+	}
+	return features + "DLNA.ORG_OP=" + op + ";DLNA.ORG_CI=0;DLNA.ORG_FLAGS=" + dlnaFlags
+}
+
+// ProtocolInfo describes an HTTP stream of a MIME type
+func ProtocolInfo(mimeType string, byteSeek bool) string {
+	return "http-get:*:" + mimeType + ":" + ContentFeatures(mimeType, byteSeek)
+}
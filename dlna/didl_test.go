@@ -0,0 +1,52 @@
+package dlna
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatDuration(t *testing.T) {
+	for seconds, want := range map[float64]string{
+		0:        "0:00:00.000",
+		61.5:     "0:01:01.500",
+		3725.125: "1:02:05.125",
+		59.9996:  "0:01:00.000",
+	} {
+		if got := FormatDuration(seconds); got != want {
+			t.Errorf("FormatDuration(%v) = %q, want %q", seconds, got, want)
+		}
+	}
+}
+
+func TestProtocolInfo(t *testing.T) {
+	if got := ProtocolInfo("audio/mpeg", true); got != "http-get:*:audio/mpeg:DLNA.ORG_PN=MP3;DLNA.ORG_OP=01;DLNA.ORG_CI=0;DLNA.ORG_FLAGS="+dlnaFlags {
+		t.Errorf("ProtocolInfo(mp3) = %q", got)
+	}
+	if got := ContentFeatures("audio/flac", false); got != "DLNA.ORG_OP=00;DLNA.ORG_CI=0;DLNA.ORG_FLAGS="+dlnaFlags {
+		t.Errorf("ContentFeatures(flac) = %q", got)
+	}
+}
+
+func TestMarshalDIDL(t *testing.T) {
+	got := MarshalDIDL([]Object{
+		{ID: "al-1", ParentID: "0", Title: "Rock & Roll", Class: "object.container.album.musicAlbum", Container: true, ChildCount: 2},
+		{ID: "t1", ParentID: "al-1", Title: "Song", Class: "object.item.audioItem.musicTrack", Artist: "Band", TrackNumber: 3,
+			Res: &Resource{URL: "http://host/t1?a=1&b=2", ProtocolInfo: "http-get:*:audio/mpeg:*", Duration: 61.5, Bitrate: 320000}},
+	})
+	for _, want := range []string{
+		`<DIDL-Lite xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/"`,
+		`<container id="al-1" parentID="0" restricted="1" childCount="2"><dc:title>Rock &amp; Roll</dc:title>`,
+		`<item id="t1" parentID="al-1" restricted="1">`,
+		`<dc:creator>Band</dc:creator>`,
+		`<upnp:originalTrackNumber>3</upnp:originalTrackNumber>`,
+		`duration="0:01:01.500" bitrate="40000"`,
+		`>http://host/t1?a=1&amp;b=2</res>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("DIDL-Lite lacks %s:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "childCount=\"0\"") || strings.Contains(got, "size=") {
+		t.Errorf("DIDL-Lite has empty attributes:\n%s", got)
+	}
+}
@@ -0,0 +1,286 @@
+// Package dlna implements the protocol side of a UPnP AV MediaServer, so that
+// TVs, AV receivers and other DLNA players on the local network can find and
+// browse the library: SSDP discovery, the device and service descriptions,
+// SOAP control messages, DIDL-Lite metadata and ContentDirectory search
+// criteria. The handlers package serves it at /gomedia/dlna/. It is enabled
+// with DLNA_ENABLED=true, and players that cannot sign in are only let in
+// from the local network or DLNA_ALLOWED_NETWORKS.
+package dlna
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"log"
+	"net/netip"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"MediaBackend/env"
+	"MediaBackend/randid"
+)
+
+// Prefix is the path the device is served under
+const Prefix = "/gomedia/dlna/"
+
+// Device and service types announced and described
+const (
+	DeviceType            = "urn:schemas-upnp-org:device:MediaServer:1"
+	ContentDirectoryType  = "urn:schemas-upnp-org:service:ContentDirectory:1"
+	ConnectionManagerType = "urn:schemas-upnp-org:service:ConnectionManager:1"
+	serverHeader          = "Linux/1.0 UPnP/1.0 MediaBackend/1.0"
+)
+
+// Service names, as used in the service paths
+const (
+	ContentDirectory  = "ContentDirectory"
+	ConnectionManager = "ConnectionManager"
+)
+
+var (
+	enabled      = strings.EqualFold(os.Getenv("DLNA_ENABLED"), "true")
+	friendlyName = env.Or("DLNA_NAME", "MediaBackend")
+	// udn stays the same across restarts so that players remember the server
+	udn = deviceUDN(friendlyName)
+	// allowedNetworks replaces the local network as the clients let in
+	// without signing in
+	allowedNetworks = parseNetworks(os.Getenv("DLNA_ALLOWED_NETWORKS"))
+)
+
+// Enabled reports whether DLNA_ENABLED turns the media server on
+func Enabled() bool {
+	return enabled
+}
+
+// parseNetworks parses comma-separated CIDR prefixes or addresses
+func parseNetworks(spec string) []netip.Prefix {
+	var networks []netip.Prefix
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if addr, err := netip.ParseAddr(entry); err == nil {
+				networks = append(networks, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+				continue
+			}
+		}
+		network, err := netip.ParsePrefix(entry)
+		if err != nil {
+			log.Printf("⚠️  Ignoring malformed DLNA_ALLOWED_NETWORKS entry %q", entry)
+			continue
+		}
+		networks = append(networks, network.Masked())
+	}
+	return networks
+}
+
+// AllowsClient reports whether a client may use the media server without
+// signing in. Unless DLNA_ALLOWED_NETWORKS lists networks, those are
+// loopback, private and link-local addresses. remoteAddr is the "host:port"
+// of the connection; forwarding headers are not trusted.
+func AllowsClient(remoteAddr string) bool {
+	addrPort, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	addr := addrPort.Addr().Unmap().WithZone("")
+	if len(allowedNetworks) > 0 {
+		for _, network := range allowedNetworks {
+			if network.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+	return addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast()
+}
+
+// FriendlyName is the name players show for the server, from DLNA_NAME
+func FriendlyName() string {
+	return friendlyName
+}
+
+// deviceUDN derives a name-based (version 5) UUID from the host and server
+// names
+func deviceUDN(name string) string {
+	host, _ := os.Hostname()
+	sum := sha1.Sum([]byte("MediaBackend\x00" + host + "\x00" + name))
+	sum[6] = sum[6]&0x0f | 0x50
+	sum[8] = sum[8]&0x3f | 0x80
+	id := hex.EncodeToString(sum[:16])
+	return fmt.Sprintf("uuid:%s-%s-%s-%s-%s", id[0:8], id[8:12], id[12:16], id[16:20], id[20:32])
+}
+
+// systemUpdateID counts changes of the content directory, which players
+// poll to know when to browse again
+var systemUpdateID atomic.Uint32
+
+// Changed records a change of the content directory
+func Changed() {
+	systemUpdateID.Add(1)
+}
+
+// SystemUpdateID identifies the current state of the content directory
+func SystemUpdateID() uint32 {
+	return systemUpdateID.Load()
+}
+
+// NewSubscriptionID returns a random event subscription ID
+func NewSubscriptionID() string {
+	return "uuid:" + randid.UUID()
+}
+
+// ControlURL, EventURL and SCPDURL are the paths of a service's endpoints
+func ControlURL(service string) string { return Prefix + "control/" + service }
+func EventURL(service string) string   { return Prefix + "event/" + service }
+func SCPDURL(service string) string    { return Prefix + service + ".xml" }
+
+// DescriptionURL is the path of the device description
+const DescriptionURL = Prefix + "device.xml"
+
+// DeviceDescription returns the device description document
+func DeviceDescription() string {
+	var services strings.Builder
+	for _, s := range []struct{ name, serviceType string }{
+		{ContentDirectory, ContentDirectoryType},
+		{ConnectionManager, ConnectionManagerType},
+	} {
+		fmt.Fprintf(&services, `
+      <service>
+        <serviceType>%s</serviceType>
+        <serviceId>urn:upnp-org:serviceId:%s</serviceId>
+        <SCPDURL>%s</SCPDURL>
+        <controlURL>%s</controlURL>
+        <eventSubURL>%s</eventSubURL>
+      </service>`, s.serviceType, s.name, SCPDURL(s.name), ControlURL(s.name), EventURL(s.name))
+	}
+	return fmt.Sprintf(`<?xml version="1.0" encoding="utf-8"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0" xmlns:dlna="urn:schemas-dlna-org:device-1-0">
+  <specVersion><major>1</major><minor>0</minor></specVersion>
+  <device>
+    <deviceType>%s</deviceType>
+    <friendlyName>%s</friendlyName>
+    <manufacturer>MediaBackend</manufacturer>
+    <modelName>MediaBackend</modelName>
+    <modelNumber>1.0</modelNumber>
+    <UDN>%s</UDN>
+    <dlna:X_DLNADOC>DMS-1.50</dlna:X_DLNADOC>
+    <serviceList>%s
+    </serviceList>
+  </device>
+</root>
+`, DeviceType, html.EscapeString(friendlyName), udn, services.String())
+}
+
+// ServiceDescription returns the SCPD document of a service
+func ServiceDescription(service string) (string, bool) {
+	switch service {
+	case ContentDirectory:
+		return contentDirectorySCPD, true
+	case ConnectionManager:
+		return connectionManagerSCPD, true
+	}
+	return "", false
+}
+
+const contentDirectorySCPD = `<?xml version="1.0" encoding="utf-8"?>
+<scpd xmlns="urn:schemas-upnp-org:service-1-0">
+  <specVersion><major>1</major><minor>0</minor></specVersion>
+  <actionList>
+    <action><name>GetSearchCapabilities</name><argumentList>
+      <argument><name>SearchCaps</name><direction>out</direction><relatedStateVariable>SearchCapabilities</relatedStateVariable></argument>
+    </argumentList></action>
+    <action><name>GetSortCapabilities</name><argumentList>
+      <argument><name>SortCaps</name><direction>out</direction><relatedStateVariable>SortCapabilities</relatedStateVariable></argument>
+    </argumentList></action>
+    <action><name>GetSystemUpdateID</name><argumentList>
+      <argument><name>Id</name><direction>out</direction><relatedStateVariable>SystemUpdateID</relatedStateVariable></argument>
+    </argumentList></action>
+    <action><name>Browse</name><argumentList>
+      <argument><name>ObjectID</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_ObjectID</relatedStateVariable></argument>
+      <argument><name>BrowseFlag</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_BrowseFlag</relatedStateVariable></argument>
+      <argument><name>Filter</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Filter</relatedStateVariable></argument>
+      <argument><name>StartingIndex</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Index</relatedStateVariable></argument>
+      <argument><name>RequestedCount</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+      <argument><name>SortCriteria</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_SortCriteria</relatedStateVariable></argument>
+      <argument><name>Result</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Result</relatedStateVariable></argument>
+      <argument><name>NumberReturned</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+      <argument><name>TotalMatches</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+      <argument><name>UpdateID</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_UpdateID</relatedStateVariable></argument>
+    </argumentList></action>
+    <action><name>Search</name><argumentList>
+      <argument><name>ContainerID</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_ObjectID</relatedStateVariable></argument>
+      <argument><name>SearchCriteria</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_SearchCriteria</relatedStateVariable></argument>
+      <argument><name>Filter</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Filter</relatedStateVariable></argument>
+      <argument><name>StartingIndex</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Index</relatedStateVariable></argument>
+      <argument><name>RequestedCount</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+      <argument><name>SortCriteria</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_SortCriteria</relatedStateVariable></argument>
+      <argument><name>Result</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Result</relatedStateVariable></argument>
+      <argument><name>NumberReturned</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+      <argument><name>TotalMatches</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+      <argument><name>UpdateID</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_UpdateID</relatedStateVariable></argument>
+    </argumentList></action>
+  </actionList>
+  <serviceStateTable>
+    <stateVariable sendEvents="no"><name>SearchCapabilities</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>SortCapabilities</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="yes"><name>SystemUpdateID</name><dataType>ui4</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_ObjectID</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Result</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_SearchCriteria</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_BrowseFlag</name><dataType>string</dataType>
+      <allowedValueList><allowedValue>BrowseMetadata</allowedValue><allowedValue>BrowseDirectChildren</allowedValue></allowedValueList>
+    </stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Filter</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_SortCriteria</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Index</name><dataType>ui4</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Count</name><dataType>ui4</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_UpdateID</name><dataType>ui4</dataType></stateVariable>
+  </serviceStateTable>
+</scpd>
+`
+
+const connectionManagerSCPD = `<?xml version="1.0" encoding="utf-8"?>
+<scpd xmlns="urn:schemas-upnp-org:service-1-0">
+  <specVersion><major>1</major><minor>0</minor></specVersion>
+  <actionList>
+    <action><name>GetProtocolInfo</name><argumentList>
+      <argument><name>Source</name><direction>out</direction><relatedStateVariable>SourceProtocolInfo</relatedStateVariable></argument>
+      <argument><name>Sink</name><direction>out</direction><relatedStateVariable>SinkProtocolInfo</relatedStateVariable></argument>
+    </argumentList></action>
+    <action><name>GetCurrentConnectionIDs</name><argumentList>
+      <argument><name>ConnectionIDs</name><direction>out</direction><relatedStateVariable>CurrentConnectionIDs</relatedStateVariable></argument>
+    </argumentList></action>
+    <action><name>GetCurrentConnectionInfo</name><argumentList>
+      <argument><name>ConnectionID</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_ConnectionID</relatedStateVariable></argument>
+      <argument><name>RcsID</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_RcsID</relatedStateVariable></argument>
+      <argument><name>AVTransportID</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_AVTransportID</relatedStateVariable></argument>
+      <argument><name>ProtocolInfo</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_ProtocolInfo</relatedStateVariable></argument>
+      <argument><name>PeerConnectionManager</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_ConnectionManager</relatedStateVariable></argument>
+      <argument><name>PeerConnectionID</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_ConnectionID</relatedStateVariable></argument>
+      <argument><name>Direction</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Direction</relatedStateVariable></argument>
+      <argument><name>Status</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_ConnectionStatus</relatedStateVariable></argument>
+    </argumentList></action>
+  </actionList>
+  <serviceStateTable>
+    <stateVariable sendEvents="yes"><name>SourceProtocolInfo</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="yes"><name>SinkProtocolInfo</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="yes"><name>CurrentConnectionIDs</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_ConnectionStatus</name><dataType>string</dataType>
+      <allowedValueList><allowedValue>OK</allowedValue><allowedValue>ContentFormatMismatch</allowedValue><allowedValue>InsufficientBandwidth</allowedValue><allowedValue>UnreliableChannel</allowedValue><allowedValue>Unknown</allowedValue></allowedValueList>
+    </stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_ConnectionManager</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Direction</name><dataType>string</dataType>
+      <allowedValueList><allowedValue>Input</allowedValue><allowedValue>Output</allowedValue></allowedValueList>
+    </stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_ProtocolInfo</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_ConnectionID</name><dataType>i4</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_AVTransportID</name><dataType>i4</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_RcsID</name><dataType>i4</dataType></stateVariable>
+  </serviceStateTable>
+</scpd>
+`
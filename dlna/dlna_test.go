@@ -0,0 +1,51 @@
+package dlna
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestAllowsClient(t *testing.T) {
+	defer func(networks []netip.Prefix) { allowedNetworks = networks }(allowedNetworks)
+
+	allowedNetworks = nil
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"127.0.0.1:5000", true},
+		{"[::1]:5000", true},
+		{"192.168.1.20:5000", true},
+		{"10.1.2.3:5000", true},
+		{"[::ffff:172.16.0.9]:5000", true},
+		{"169.254.10.1:5000", true},
+		{"[fe80::1%eth0]:5000", true},
+		{"[fd00::5]:5000", true},
+		{"203.0.113.7:5000", false},
+		{"[2001:db8::1]:5000", false},
+		{"192.168.1.20", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := AllowsClient(tt.addr); got != tt.want {
+			t.Errorf("AllowsClient(%q) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+
+	// An allow-list replaces the local network
+	allowedNetworks = parseNetworks("10.8.0.0/16, 203.0.113.7, bogus")
+	if len(allowedNetworks) != 2 {
+		t.Fatalf("parsed %v, want two networks", allowedNetworks)
+	}
+	for addr, want := range map[string]bool{
+		"10.8.4.4:1900":     true,
+		"203.0.113.7:1900":  true,
+		"203.0.113.8:1900":  false,
+		"192.168.1.20:1900": false,
+		"127.0.0.1:1900":    false,
+	} {
+		if got := AllowsClient(addr); got != want {
+			t.Errorf("with an allow-list AllowsClient(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package dlna
+
+import (
+	"cmp"
+	"strconv"
+	"strings"
+)
+
+// SearchCapabilities lists the properties search criteria may use
+const SearchCapabilities = "dc:title,dc:creator,dc:date,upnp:class,upnp:artist,upnp:album,upnp:genre,upnp:originalTrackNumber,@id,@parentID"
+
+// Criteria is a parsed ContentDirectory search criteria
+type Criteria func(o *Object) bool
+
+// ParseCriteria parses search criteria such as
+//
+//	upnp:class derivedfrom "object.item.audioItem" and dc:title contains "blue"
+//
+// "*" matches every object. Comparisons ignore case, as the ContentDirectory
+// specification asks; and binds tighter than or.
+func ParseCriteria(s string) (Criteria, error) {
+	if strings.TrimSpace(s) == "*" || strings.TrimSpace(s) == "" {
+		return func(*Object) bool { return true }, nil
+	}
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &criteriaParser{tokens: tokens}
+	c, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, invalidCriteria()
+	}
+	return c, nil
+}
+
+func invalidCriteria() *Error {
+	return Errorf(CodeUnsupportedSearchCriteria, "Unsupported or invalid search criteria")
+}
+
+// token is a word, an operator, a parenthesis or a quoted value
+type token struct {
+	text   string
+	quoted bool
+}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, token{text: string(c)})
+			i++
+		case c == '"':
+			var value strings.Builder
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				value.WriteByte(s[i])
+			}
+			if i == len(s) {
+				return nil, invalidCriteria()
+			}
+			tokens = append(tokens, token{text: value.String(), quoted: true})
+			i++
+		default:
+			start := i
+			if strings.IndexByte("=!<>", c) >= 0 {
+				for i < len(s) && strings.IndexByte("=!<>", s[i]) >= 0 {
+					i++
+				}
+			} else {
+				for i < len(s) && strings.IndexByte(" \t\r\n()\"=!<>", s[i]) < 0 {
+					i++
+				}
+			}
+			tokens = append(tokens, token{text: s[start:i]})
+		}
+	}
+	return tokens, nil
+}
+
+type criteriaParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *criteriaParser) peek() (token, bool) {
+	if p.pos >= len(p.tokens) {
+		return token{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *criteriaParser) next() (token, bool) {
+	t, ok := p.peek()
+	if ok {
+		p.pos++
+	}
+	return t, ok
+}
+
+// keyword reports whether the next token is the unquoted word and consumes it
+func (p *criteriaParser) keyword(word string) bool {
+	if t, ok := p.peek(); ok && !t.quoted && strings.EqualFold(t.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *criteriaParser) or() (Criteria, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(o *Object) bool { return l(o) || right(o) }
+	}
+	return left, nil
+}
+
+func (p *criteriaParser) and() (Criteria, error) {
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(o *Object) bool { return l(o) && right(o) }
+	}
+	return left, nil
+}
+
+func (p *criteriaParser) operand() (Criteria, error) {
+	if p.keyword("(") {
+		c, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.keyword(")") {
+			return nil, invalidCriteria()
+		}
+		return c, nil
+	}
+	return p.relation()
+}
+
+// relation parses "property op value" or "property exists bool"
+func (p *criteriaParser) relation() (Criteria, error) {
+	property, ok := p.next()
+	if !ok || property.quoted {
+		return nil, invalidCriteria()
+	}
+	op, ok := p.next()
+	if !ok || op.quoted {
+		return nil, invalidCriteria()
+	}
+	value, ok := p.next()
+	if !ok {
+		return nil, invalidCriteria()
+	}
+	prop := property.text
+
+	if strings.EqualFold(op.text, "exists") {
+		want, err := strconv.ParseBool(value.text)
+		if err != nil || value.quoted {
+			return nil, invalidCriteria()
+		}
+		return func(o *Object) bool {
+			_, ok := o.property(prop)
+			return ok == want
+		}, nil
+	}
+	if !value.quoted {
+		return nil, invalidCriteria()
+	}
+	want := strings.ToLower(value.text)
+	var match func(have string) bool
+	switch strings.ToLower(op.text) {
+	case "=":
+		match = func(have string) bool { return have == want }
+	case "!=":
+		match = func(have string) bool { return have != want }
+	case "<", "<=", ">", ">=":
+		compare := op.text
+		match = func(have string) bool { return compareValues(have, want, compare) }
+	case "contains":
+		match = func(have string) bool { return strings.Contains(have, want) }
+	case "doesnotcontain":
+		match = func(have string) bool { return !strings.Contains(have, want) }
+	case "startswith":
+		match = func(have string) bool { return strings.HasPrefix(have, want) }
+	case "derivedfrom":
+		match = func(have string) bool { return have == want || strings.HasPrefix(have, want+".") }
+	default:
+		return nil, invalidCriteria()
+	}
+	return func(o *Object) bool {
+		have, ok := o.property(prop)
+		return ok && match(strings.ToLower(have))
+	}, nil
+}
+
+// compareValues orders numbers numerically and other values as strings
+func compareValues(have, want, op string) bool {
+	order := strings.Compare(have, want)
+	if a, err := strconv.ParseFloat(have, 64); err == nil {
+		if b, err := strconv.ParseFloat(want, 64); err == nil {
+			order = cmp.Compare(a, b)
+		}
+	}
+	switch op {
+	case "<":
+		return order < 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	}
+	return order >= 0
+}
+
+// property returns the value of a property searched by, and whether the
+// object has it
+func (o *Object) property(name string) (string, bool) {
+	var value string
+	switch name {
+	case "@id":
+		value = o.ID
+	case "@parentID":
+		value = o.ParentID
+	case "dc:title":
+		value = o.Title
+	case "upnp:class":
+		value = o.Class
+	case "dc:creator", "upnp:artist":
+		value = o.Artist
+	case "upnp:album":
+		value = o.Album
+	case "upnp:genre":
+		value = o.Genre
+	case "dc:date":
+		value = o.Date
+	case "upnp:originalTrackNumber":
+		if o.TrackNumber > 0 {
+			value = strconv.Itoa(o.TrackNumber)
+		}
+	case "res":
+		if o.Res != nil {
+			value = o.Res.URL
+		}
+	}
+	return value, value != ""
+}
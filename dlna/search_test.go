@@ -0,0 +1,91 @@
+package dlna
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseCriteria(t *testing.T) {
+	track := &Object{
+		ID:          "track-1",
+		ParentID:    "album-1",
+		Title:       "Blue in Green",
+		Class:       "object.item.audioItem.musicTrack",
+		Artist:      "Miles Davis",
+		Album:       "Kind of Blue",
+		Genre:       "Jazz",
+		Date:        "1959-08-17",
+		TrackNumber: 3,
+		Res:         &Resource{URL: "http://host/dlna/media/track-1"},
+	}
+	album := &Object{
+		ID:        "album-1",
+		ParentID:  "albums",
+		Title:     "Kind of Blue",
+		Class:     "object.container.album.musicAlbum",
+		Container: true,
+	}
+
+	tests := []struct {
+		criteria     string
+		track, album bool
+	}{
+		{"*", true, true},
+		{"", true, true},
+		{`upnp:class derivedfrom "object.item.audioItem"`, true, false},
+		{`upnp:class derivedfrom "object.item.audio"`, false, false},
+		{`upnp:class = "OBJECT.CONTAINER.ALBUM.MUSICALBUM"`, false, true},
+		{`dc:title contains "blue"`, true, true},
+		{`dc:title doesNotContain "green"`, false, true},
+		{`dc:title startsWith "kind"`, false, true},
+		{`dc:creator = "miles davis"`, true, false},
+		{`upnp:artist != "Miles Davis"`, false, false},
+		{`upnp:album = "Kind of Blue" and upnp:genre = "jazz"`, true, false},
+		{`@id = "album-1" or @parentID = "album-1"`, true, true},
+		{`dc:date >= "1959-01-01" and dc:date < "1960-01-01"`, true, false},
+		{`upnp:originalTrackNumber > "2"`, true, false},
+		{`upnp:originalTrackNumber <= "10"`, true, false},
+		{`upnp:artist exists true`, true, false},
+		{`upnp:artist exists false`, false, true},
+		{`res exists true`, true, false},
+		// and binds tighter than or
+		{`@id = "album-1" or dc:title contains "blue" and upnp:genre = "rock"`, false, true},
+		{`(@id = "album-1" or dc:title contains "blue") and upnp:genre = "jazz"`, true, false},
+		{`dc:title = "Say \"hi\""`, false, false},
+	}
+	for _, tt := range tests {
+		c, err := ParseCriteria(tt.criteria)
+		if err != nil {
+			t.Errorf("ParseCriteria(%q): %v", tt.criteria, err)
+			continue
+		}
+		if got := c(track); got != tt.track {
+			t.Errorf("%q matched the track %v, want %v", tt.criteria, got, tt.track)
+		}
+		if got := c(album); got != tt.album {
+			t.Errorf("%q matched the album %v, want %v", tt.criteria, got, tt.album)
+		}
+	}
+}
+
+func TestParseCriteriaInvalid(t *testing.T) {
+	for _, criteria := range []string{
+		`dc:title`,
+		`dc:title =`,
+		`dc:title = blue`,
+		`dc:title = "blue`,
+		`dc:title like "blue"`,
+		`"dc:title" = "blue"`,
+		`upnp:artist exists "true"`,
+		`upnp:artist exists maybe`,
+		`(dc:title = "blue"`,
+		`dc:title = "blue" and`,
+		`dc:title = "blue" "green"`,
+	} {
+		_, err := ParseCriteria(criteria)
+		var upnpErr *Error
+		if !errors.As(err, &upnpErr) || upnpErr.Code != CodeUnsupportedSearchCriteria {
+			t.Errorf("ParseCriteria(%q) err = %v, want code %d", criteria, err, CodeUnsupportedSearchCriteria)
+		}
+	}
+}
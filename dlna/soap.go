@@ -0,0 +1,127 @@
+package dlna
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxActionSize limits the size of a SOAP request
+const maxActionSize = 64 << 10
+
+// UPnP error codes returned in SOAP faults
+const (
+	CodeInvalidAction             = 401
+	CodeInvalidArgs               = 402
+	CodeActionFailed              = 501
+	CodeNoSuchObject              = 701
+	CodeUnsupportedSearchCriteria = 708
+	CodeInvalidConnectionID       = 706
+)
+
+// Error is a UPnP error, reported as a SOAP fault
+type Error struct {
+	Code        int
+	Description string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("upnp error %d: %s", e.Code, e.Description)
+}
+
+// Errorf returns an Error with the given code and description
+func Errorf(code int, format string, args ...any) *Error {
+	return &Error{Code: code, Description: fmt.Sprintf(format, args...)}
+}
+
+// Action is a SOAP action invoked on a service
+type Action struct {
+	// ServiceType is the service the action was sent to, e.g.
+	// ContentDirectoryType
+	ServiceType string
+	Name        string
+	Args        map[string]string
+}
+
+// Arg is an output argument; their order is part of the protocol
+type Arg struct {
+	Name  string
+	Value string
+}
+
+// ReadAction parses a SOAP request. The action is named by the SOAPACTION
+// header, "serviceType#name", and its arguments are the children of the
+// body element.
+func ReadAction(r *http.Request) (*Action, *Error) {
+	header := strings.Trim(r.Header.Get("SOAPACTION"), `"`)
+	serviceType, name, ok := strings.Cut(header, "#")
+	if !ok || name == "" {
+		return nil, Errorf(CodeInvalidAction, "Invalid Action")
+	}
+	var envelope struct {
+		Body struct {
+			Action struct {
+				XMLName xml.Name
+				Args    []struct {
+					XMLName xml.Name
+					Value   string `xml:",chardata"`
+				} `xml:",any"`
+			} `xml:",any"`
+		} `xml:"Body"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(r.Body, maxActionSize)).Decode(&envelope); err != nil {
+		return nil, Errorf(CodeInvalidArgs, "Invalid Args")
+	}
+	if envelope.Body.Action.XMLName.Local != name {
+		return nil, Errorf(CodeInvalidAction, "Invalid Action")
+	}
+	action := &Action{ServiceType: serviceType, Name: name, Args: map[string]string{}}
+	for _, arg := range envelope.Body.Action.Args {
+		action.Args[arg.XMLName.Local] = arg.Value
+	}
+	return action, nil
+}
+
+const (
+	envelopeStart = `<?xml version="1.0" encoding="utf-8"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`
+	envelopeEnd = `</s:Body></s:Envelope>
+`
+)
+
+// WriteResponse writes the response of an action with its output arguments
+func WriteResponse(w http.ResponseWriter, action *Action, args ...Arg) {
+	var b strings.Builder
+	b.WriteString(envelopeStart)
+	fmt.Fprintf(&b, `<u:%sResponse xmlns:u="%s">`, action.Name, action.ServiceType)
+	for _, arg := range args {
+		fmt.Fprintf(&b, "<%s>", arg.Name)
+		xml.EscapeText(&b, []byte(arg.Value))
+		fmt.Fprintf(&b, "</%s>", arg.Name)
+	}
+	fmt.Fprintf(&b, "</u:%sResponse>", action.Name)
+	b.WriteString(envelopeEnd)
+
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.Header().Set("Ext", "")
+	w.Header().Set("Server", serverHeader)
+	io.WriteString(w, b.String())
+}
+
+// WriteFault writes a UPnP error as a SOAP fault
+func WriteFault(w http.ResponseWriter, e *Error) {
+	var b strings.Builder
+	b.WriteString(envelopeStart)
+	b.WriteString(`<s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail>`)
+	fmt.Fprintf(&b, `<UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode><errorDescription>`, e.Code)
+	xml.EscapeText(&b, []byte(e.Description))
+	b.WriteString(`</errorDescription></UPnPError></detail></s:Fault>`)
+	b.WriteString(envelopeEnd)
+
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.Header().Set("Server", serverHeader)
+	w.WriteHeader(http.StatusInternalServerError)
+	io.WriteString(w, b.String())
+}
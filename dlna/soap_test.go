@@ -0,0 +1,59 @@
+package dlna
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func soapRequest(action, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, ControlURL("ContentDirectory"), strings.NewReader(body))
+	r.Header.Set("SOAPACTION", action)
+	return r
+}
+
+const browseEnvelope = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>
+<u:Browse xmlns:u="urn:schemas-upnp-org:service:ContentDirectory:1">
+<ObjectID>0</ObjectID><BrowseFlag>BrowseDirectChildren</BrowseFlag><Filter>*</Filter>
+</u:Browse></s:Body></s:Envelope>`
+
+func TestReadAction(t *testing.T) {
+	action, err := ReadAction(soapRequest(`"urn:schemas-upnp-org:service:ContentDirectory:1#Browse"`, browseEnvelope))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if action.ServiceType != "urn:schemas-upnp-org:service:ContentDirectory:1" || action.Name != "Browse" ||
+		action.Args["ObjectID"] != "0" || action.Args["BrowseFlag"] != "BrowseDirectChildren" {
+		t.Errorf("ReadAction = %+v", action)
+	}
+
+	for _, tt := range []struct {
+		header, body string
+		code         int
+	}{
+		{"Browse", browseEnvelope, CodeInvalidAction},
+		{"urn:x#Search", browseEnvelope, CodeInvalidAction},
+		{"urn:x#Browse", "<s:Envelope>", CodeInvalidArgs},
+	} {
+		if _, err := ReadAction(soapRequest(tt.header, tt.body)); err == nil || err.Code != tt.code {
+			t.Errorf("ReadAction(%q) = %v, want code %d", tt.header, err, tt.code)
+		}
+	}
+}
+
+func TestWriteResponse(t *testing.T) {
+	w := httptest.NewRecorder()
+	WriteResponse(w, &Action{ServiceType: "urn:x", Name: "Browse"}, Arg{"Result", "<a&b>"}, Arg{"NumberReturned", "1"})
+	want := `<u:BrowseResponse xmlns:u="urn:x"><Result>&lt;a&amp;b&gt;</Result><NumberReturned>1</NumberReturned></u:BrowseResponse>`
+	if !strings.Contains(w.Body.String(), want) {
+		t.Errorf("response %s lacks %s", w.Body.String(), want)
+	}
+
+	w = httptest.NewRecorder()
+	WriteFault(w, Errorf(CodeNoSuchObject, "No such object"))
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "<errorCode>701</errorCode>") {
+		t.Errorf("fault = %d %s", w.Code, w.Body.String())
+	}
+}
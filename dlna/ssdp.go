@@ -0,0 +1,215 @@
+package dlna
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	// maxAge is how long players may remember an announcement
+	maxAge = 1800
+	// announceInterval repeats announcements well before they expire
+	announceInterval = maxAge / 3 * time.Second
+	// maxResponseDelay caps the random delay spreading search responses
+	// over the MX seconds a player waits
+	maxResponseDelay = 3 * time.Second
+)
+
+// ssdpGroup is the SSDP multicast address
+var ssdpGroup = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+
+// ssdpServer answers M-SEARCH requests and sends NOTIFY announcements
+type ssdpServer struct {
+	conn    *net.UDPConn
+	packets *ipv4.PacketConn
+	port    string
+}
+
+// interfaceAddr is a multicast capable interface and its IPv4 network
+type interfaceAddr struct {
+	iface net.Interface
+	addr  *net.IPNet
+}
+
+// Start joins the SSDP group on every multicast interface, answers searches
+// for the media server and announces it periodically. port is the HTTP port
+// serving the descriptions.
+func Start(port string) error {
+	conn, err := net.ListenMulticastUDP("udp4", nil, ssdpGroup)
+	if err != nil {
+		return fmt.Errorf("listening for SSDP: %w", err)
+	}
+	s := &ssdpServer{conn: conn, packets: ipv4.NewPacketConn(conn), port: port}
+	if err := s.packets.SetMulticastTTL(2); err != nil {
+		log.Printf("Error setting SSDP multicast TTL: %v", err)
+	}
+	for _, ia := range multicastInterfaces() {
+		// The default interface has joined already
+		s.packets.JoinGroup(&ia.iface, ssdpGroup)
+	}
+	go s.serve()
+	go s.announce()
+	return nil
+}
+
+// multicastInterfaces lists the interfaces announcements are sent on
+func multicastInterfaces() []interfaceAddr {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		log.Printf("Error listing network interfaces: %v", err)
+		return nil
+	}
+	var list []interfaceAddr
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+				list = append(list, interfaceAddr{iface: iface, addr: ipnet})
+				break
+			}
+		}
+	}
+	return list
+}
+
+// localIP returns the address of this host that remote reaches it on
+func localIP(remote net.IP) net.IP {
+	for _, ia := range multicastInterfaces() {
+		if ia.addr.Contains(remote) {
+			return ia.addr.IP
+		}
+	}
+	// Let routing pick the source address; nothing is sent
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: remote, Port: ssdpGroup.Port})
+	if err != nil {
+		return net.IPv4zero
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP
+}
+
+// notificationTypes are the targets the device is announced and found as:
+// the root device, its UDN, its device type and its services
+func notificationTypes() []string {
+	return []string{"upnp:rootdevice", udn, DeviceType, ContentDirectoryType, ConnectionManagerType}
+}
+
+// usn is the unique service name of a notification type
+func usn(nt string) string {
+	if nt == udn {
+		return udn
+	}
+	return udn + "::" + nt
+}
+
+func (s *ssdpServer) location(ip net.IP) string {
+	return "http://" + net.JoinHostPort(ip.String(), s.port) + DescriptionURL
+}
+
+// serve answers M-SEARCH requests until the socket fails
+func (s *ssdpServer) serve() {
+	buf := make([]byte, 2048)
+	for {
+		n, src, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			log.Printf("Error reading SSDP: %v", err)
+			return
+		}
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:n])))
+		if err != nil || req.Method != "M-SEARCH" || req.Header.Get("MAN") != `"ssdp:discover"` {
+			continue
+		}
+		var targets []string
+		st := req.Header.Get("ST")
+		for _, nt := range notificationTypes() {
+			if st == "ssdp:all" || st == nt {
+				targets = append(targets, nt)
+			}
+		}
+		if len(targets) == 0 {
+			continue
+		}
+		mx, _ := strconv.Atoi(req.Header.Get("MX"))
+		go s.respond(src, targets, mx)
+	}
+}
+
+// respond sends a search response per target after a random delay within
+// the MX seconds the player waits
+func (s *ssdpServer) respond(to *net.UDPAddr, targets []string, mx int) {
+	if mx > 0 {
+		time.Sleep(rand.N(min(time.Duration(mx)*time.Second, maxResponseDelay)))
+	}
+	location := s.location(localIP(to.IP))
+	for _, st := range targets {
+		msg := strings.Join([]string{
+			"HTTP/1.1 200 OK",
+			"CACHE-CONTROL: max-age=" + strconv.Itoa(maxAge),
+			"DATE: " + time.Now().UTC().Format(http.TimeFormat),
+			"EXT:",
+			"LOCATION: " + location,
+			"SERVER: " + serverHeader,
+			"ST: " + st,
+			"USN: " + usn(st),
+			"", "",
+		}, "\r\n")
+		if _, err := s.conn.WriteToUDP([]byte(msg), to); err != nil {
+			log.Printf("Error answering SSDP search from %s: %v", to, err)
+			return
+		}
+	}
+}
+
+// announce sends ssdp:alive notifications on every interface, twice at
+// start since UDP may drop them, then every announceInterval
+func (s *ssdpServer) announce() {
+	s.notify()
+	time.Sleep(time.Second)
+	for {
+		s.notify()
+		time.Sleep(announceInterval)
+	}
+}
+
+func (s *ssdpServer) notify() {
+	for _, ia := range multicastInterfaces() {
+		if err := s.packets.SetMulticastInterface(&ia.iface); err != nil {
+			log.Printf("Error selecting %s for SSDP: %v", ia.iface.Name, err)
+			continue
+		}
+		location := s.location(ia.addr.IP)
+		for _, nt := range notificationTypes() {
+			msg := strings.Join([]string{
+				"NOTIFY * HTTP/1.1",
+				"HOST: " + ssdpGroup.String(),
+				"CACHE-CONTROL: max-age=" + strconv.Itoa(maxAge),
+				"LOCATION: " + location,
+				"NT: " + nt,
+				"NTS: ssdp:alive",
+				"SERVER: " + serverHeader,
+				"USN: " + usn(nt),
+				"", "",
+			}, "\r\n")
+			if _, err := s.conn.WriteToUDP([]byte(msg), ssdpGroup); err != nil {
+				log.Printf("Error announcing on %s: %v", ia.iface.Name, err)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,21 @@
+// Package env reads settings from environment variables
+package env
+
+import "os"
+
+// Or returns an environment variable, or fallback when it is unset or empty
+func Or(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// LookupOr returns an environment variable, or fallback only when it is
+// unset, so setting it to an empty value clears the default
+func LookupOr(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,25 @@
+package env
+
+import "testing"
+
+func TestOr(t *testing.T) {
+	t.Setenv("ENV_TEST_SET", "value")
+	t.Setenv("ENV_TEST_EMPTY", "")
+
+	tests := []struct {
+		key        string
+		or, lookup string
+	}{
+		{"ENV_TEST_SET", "value", "value"},
+		{"ENV_TEST_EMPTY", "fallback", ""},
+		{"ENV_TEST_UNSET", "fallback", "fallback"},
+	}
+	for _, tt := range tests {
+		if got := Or(tt.key, "fallback"); got != tt.or {
+			t.Errorf("Or(%s) = %q, want %q", tt.key, got, tt.or)
+		}
+		if got := LookupOr(tt.key, "fallback"); got != tt.lookup {
+			t.Errorf("LookupOr(%s) = %q, want %q", tt.key, got, tt.lookup)
+		}
+	}
+}
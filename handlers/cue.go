@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"MediaBackend/audio"
+	"MediaBackend/library"
+	minioClient "MediaBackend/minio"
+)
+
+// ServeMusicCueTrack streams a virtual track defined by a cue sheet, e.g.
+// /gomedia/api/music/Album/album.flac/track-03.flac, as a standalone file
+// cut from the parent at frame boundaries. Whole tracks are cached per
+// source ETag and support byte ranges; ?t= and "Range: seconds=" seek
+// within the track.
+func ServeMusicCueTrack(w http.ResponseWriter, r *http.Request, filename string) {
+	ctx := r.Context()
+
+	track, ok := library.ByPath(filename + "/" + path.Base(r.URL.Path))
+	if !ok || track.Cue == nil {
+		http.Error(w, "Track not found", http.StatusNotFound)
+		return
+	}
+	format := audio.FormatFromName(filename)
+	switch format {
+	case audio.FormatMP3, audio.FormatAAC, audio.FormatFLAC, audio.FormatWAV:
+	default:
+		http.Error(w, "Cue tracks are only available for MP3, AAC, FLAC and WAV", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	objectInfo, err := minioClient.StatObject(ctx, minioClient.MusicBucket, filename)
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		log.Printf("Error getting object info for %s: %v", filename, err)
+		return
+	}
+
+	start, end := track.Cue.Start, track.Cue.End
+	contentType := getContentType(filename)
+
+	if isTimeSeek(r) {
+		from, to, err := parseTimeRange(r)
+		if err != nil {
+			http.Error(w, "Invalid time range", http.StatusBadRequest)
+			return
+		}
+		if to >= 0 {
+			end = min(end, start+to)
+		}
+		if start+from >= end {
+			http.Error(w, "Time is past the end of the track", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		clip, err := cutClip(ctx, objectInfo, format, start+from, end-start-from)
+		if errors.Is(err, audio.ErrEmptyClip) {
+			http.Error(w, "Time is past the end of the track", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Error cutting track", http.StatusUnprocessableEntity)
+			log.Printf("Error cutting %s: %v", track.Path, err)
+			return
+		}
+		defer clip.Close()
+
+		// The clip starts on the frame containing the requested time
+		w.Header().Set("X-Seek-Time", strconv.FormatFloat(max(clip.Start-start, 0), 'f', 3, 64))
+		if clip.approximate {
+			w.Header().Set("X-Seek-Approximate", "true")
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", strconv.FormatInt(clip.Size, 10))
+		w.WriteHeader(http.StatusOK)
+		if r.Method != http.MethodHead {
+			if _, err := clip.WriteTo(w); err != nil {
+				log.Printf("Error streaming %s from %.3fs: %v", track.Path, from, err)
+			}
+		}
+		return
+	}
+
+	bounds := fmt.Sprintf("%.3f-%.3f", start, end)
+	cacheKey := minioClient.CacheKey("cuetracks", objectInfo.ETag, bounds+"."+string(format))
+	etag := fmt.Sprintf(`"%s-cue%s"`, strings.Trim(objectInfo.ETag, `"`), bounds)
+
+	approximate := false
+	data, err := minioClient.GetCached(ctx, cacheKey)
+	if err != nil {
+		if !errors.Is(err, minioClient.ErrCacheMiss) {
+			log.Printf("Error reading cached cue track %s: %v", cacheKey, err)
+		}
+
+		data, approximate, err = clipBytes(ctx, objectInfo, format, start, end-start)
+		if err != nil {
+			http.Error(w, "Error cutting track", http.StatusUnprocessableEntity)
+			log.Printf("Error cutting %s: %v", track.Path, err)
+			return
+		}
+
+		if !approximate {
+			if err := minioClient.PutCached(ctx, cacheKey, data, contentType); err != nil {
+				log.Printf("Error caching cue track %s: %v", cacheKey, err)
+			}
+		}
+		log.Printf("Generated cue track: %s (%d bytes)", track.Path, len(data))
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	setClipCaching(w, etag, approximate)
+	http.ServeContent(w, r, "", objectInfo.LastModified, bytes.NewReader(data))
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"MediaBackend/devices"
+	"MediaBackend/middleware"
+)
+
+// DeviceSocket connects a player at /gomedia/api/devices/ws?id=...&name=...&type=...
+// over WebSocket. The player reports its state and sends or receives remote
+// control commands as JSON messages; see the devices package.
+func DeviceSocket(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already answered
+		log.Printf("Error upgrading device connection: %v", err)
+		return
+	}
+	client, err := devices.Connect(middleware.User(r), devices.Device{
+		ID:   query.Get("id"),
+		Name: query.Get("name"),
+		Type: query.Get("type"),
+	})
+	if err != nil {
+		rejectSocket(conn, err)
+		return
+	}
+	defer client.Close()
+
+	serveSocket(conn, client.Outbox(), func(m devices.Message) {
+		client.Handle(r.Context(), m)
+	})
+}
+
+// ListDevices returns the user's players, with those that disconnected
+// within the last minute listed as offline
+func ListDevices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]any{"devices": devices.List(middleware.User(r))})
+}
+
+// DeviceCommand sends a remote control command to a player at
+// POST /gomedia/api/devices/{id}/commands, for clients without a device
+// connection. It answers 200 when delivered and 202 when queued for a
+// device that is reconnecting.
+func DeviceCommand(w http.ResponseWriter, r *http.Request) {
+	id, action, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/gomedia/api/devices/"), "/")
+	if !ok || id == "" || action != "commands" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var input struct {
+		Command  string   `json:"command"`
+		Position *float64 `json:"position"`
+		Volume   *float64 `json:"volume"`
+	}
+	if err := decodeJSONBody(w, r, &input); err != nil {
+		http.Error(w, "Invalid command: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	cmd, delivered, err := devices.Send(r.Context(), middleware.User(r), devices.Command{
+		Command:  input.Command,
+		Target:   id,
+		Position: input.Position,
+		Volume:   input.Volume,
+	})
+	switch {
+	case errors.Is(err, devices.ErrNotFound):
+		http.Error(w, "Device not found", http.StatusNotFound)
+	case errors.Is(err, devices.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		http.Error(w, "Error sending command", http.StatusInternalServerError)
+		log.Printf("Error sending command to device %s: %v", id, err)
+	case delivered:
+		writeJSON(w, http.StatusOK, map[string]any{"command": cmd, "delivered": true})
+	default:
+		writeJSON(w, http.StatusAccepted, map[string]any{"command": cmd, "delivered": false})
+	}
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"MediaBackend/dlna"
+	"MediaBackend/library"
+)
+
+// dlnaMimeTypes are the stream types announced by GetProtocolInfo
+var dlnaMimeTypes = []string{"audio/mpeg", "audio/flac", "audio/wav", "audio/ogg", "audio/mp4", "audio/aac"}
+
+// DLNA serves the UPnP media server under /gomedia/dlna/: the device and
+// service descriptions, the SOAP control endpoints, event subscriptions and
+// the streams and covers the content directory links to. Players cannot
+// sign in, so the auth middleware lets in clients dlna.AllowsClient accepts
+// without credentials.
+func DLNA(w http.ResponseWriter, r *http.Request) {
+	if !dlna.Enabled() {
+		http.NotFound(w, r)
+		return
+	}
+	rest := strings.TrimPrefix(r.URL.Path, dlna.Prefix)
+	switch {
+	case strings.HasPrefix(rest, "control/"):
+		dlnaControl(w, r, strings.TrimPrefix(rest, "control/"))
+	case strings.HasPrefix(rest, "event/"):
+		dlnaSubscribe(w, r)
+	case strings.HasPrefix(rest, "media/"):
+		dlnaMedia(w, r, strings.TrimPrefix(rest, "media/"))
+	case strings.HasPrefix(rest, "cover/"):
+		dlnaCover(w, r, strings.TrimPrefix(rest, "cover/"))
+	default:
+		dlnaDescription(w, r, rest)
+	}
+}
+
+// dlnaDescription serves the device description and the service SCPDs
+func dlnaDescription(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	doc, ok := "", false
+	if name == path.Base(dlna.DescriptionURL) {
+		doc, ok = dlna.DeviceDescription(), true
+	} else if service, found := strings.CutSuffix(name, ".xml"); found {
+		doc, ok = dlna.ServiceDescription(service)
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	io.WriteString(w, doc)
+}
+
+// dlnaControl runs a SOAP action of a service
+func dlnaControl(w http.ResponseWriter, r *http.Request, service string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	action, failure := dlna.ReadAction(r)
+	if failure != nil {
+		dlna.WriteFault(w, failure)
+		return
+	}
+
+	var args []dlna.Arg
+	var err error
+	switch service {
+	case dlna.ContentDirectory:
+		args, err = dlnaContentDirectory(r, action)
+	case dlna.ConnectionManager:
+		args, err = dlnaConnectionManager(action)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		if !errors.As(err, &failure) {
+			log.Printf("Error running DLNA %s: %v", action.Name, err)
+			failure = dlna.Errorf(dlna.CodeActionFailed, "Action Failed")
+		}
+		dlna.WriteFault(w, failure)
+		return
+	}
+	dlna.WriteResponse(w, action, args...)
+}
+
+// dlnaConnectionManager answers the ConnectionManager actions. The server
+// only serves HTTP streams, so there is a single, implicit connection.
+func dlnaConnectionManager(action *dlna.Action) ([]dlna.Arg, error) {
+	switch action.Name {
+	case "GetProtocolInfo":
+		source := make([]string, len(dlnaMimeTypes))
+		for i, mimeType := range dlnaMimeTypes {
+			source[i] = dlna.ProtocolInfo(mimeType, true)
+		}
+		return []dlna.Arg{{Name: "Source", Value: strings.Join(source, ",")}, {Name: "Sink"}}, nil
+	case "GetCurrentConnectionIDs":
+		return []dlna.Arg{{Name: "ConnectionIDs", Value: "0"}}, nil
+	case "GetCurrentConnectionInfo":
+		if action.Args["ConnectionID"] != "0" {
+			return nil, dlna.Errorf(dlna.CodeInvalidConnectionID, "Invalid connection reference")
+		}
+		return []dlna.Arg{
+			{Name: "RcsID", Value: "-1"},
+			{Name: "AVTransportID", Value: "-1"},
+			{Name: "ProtocolInfo"},
+			{Name: "PeerConnectionManager"},
+			{Name: "PeerConnectionID", Value: "-1"},
+			{Name: "Direction", Value: "Output"},
+			{Name: "Status", Value: "OK"},
+		}, nil
+	}
+	return nil, dlna.Errorf(dlna.CodeInvalidAction, "Invalid Action")
+}
+
+// dlnaSubscribe accepts event subscriptions so that players requiring them
+// carry on. No events are sent; players poll SystemUpdateID instead.
+func dlnaSubscribe(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "SUBSCRIBE":
+		// Renewals name their subscription
+		sid := r.Header.Get("SID")
+		if sid == "" {
+			sid = dlna.NewSubscriptionID()
+		}
+		w.Header().Set("SID", sid)
+		w.Header().Set("TIMEOUT", "Second-1800")
+	case "UNSUBSCRIBE":
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// dlnaMedia streams a track, {id}.{ext}, with the DLNA transfer headers
+func dlnaMedia(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	track, ok := library.Get(strings.TrimSuffix(name, path.Ext(name)))
+	if !ok {
+		http.Error(w, "Track not found", http.StatusNotFound)
+		return
+	}
+	// Some players match these headers case-sensitively, so they are not
+	// canonicalized
+	w.Header()["transferMode.dlna.org"] = []string{"Streaming"}
+	if r.Header.Get("getcontentFeatures.dlna.org") == "1" {
+		w.Header()["contentFeatures.dlna.org"] = []string{dlna.ContentFeatures(getContentType(track.Path), true)}
+	}
+	serveLibraryTrack(w, r, track, url.Values{})
+}
+
+// dlnaCover serves the artwork of a track
+func dlnaCover(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	track, ok := library.Get(id)
+	if !ok {
+		http.Error(w, "Track not found", http.StatusNotFound)
+		return
+	}
+	found, err := serveTrackCover(w, r, track)
+	if err != nil {
+		http.Error(w, "Error retrieving cover", http.StatusInternalServerError)
+		log.Printf("Error serving cover of %s: %v", track.Path, err)
+		return
+	}
+	if !found {
+		http.Error(w, "Cover not found", http.StatusNotFound)
+	}
+}
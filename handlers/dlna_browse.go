@@ -0,0 +1,387 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"MediaBackend/dlna"
+	"MediaBackend/library"
+	"MediaBackend/subsonic"
+)
+
+// Object IDs of the top-level containers. Folders, artists and albums below
+// them share the IDs of the Subsonic API, and tracks use their library ID.
+const (
+	dlnaRootID    = "0"
+	dlnaArtistsID = "artists"
+	dlnaAlbumsID  = "albums"
+)
+
+// dlnaView builds content directory objects from the library
+type dlnaView struct {
+	catalog *subsonic.Catalog
+	tracks  []library.Track
+	// base is the origin the player reached the server on
+	base string
+}
+
+// dlnaCatalog is the library as last browsed, shared by requests until the
+// content directory changes. Views only read it.
+var dlnaCatalog struct {
+	sync.Mutex
+	updateID uint32
+	catalog  *subsonic.Catalog
+	tracks   []library.Track
+}
+
+// newDLNAView captures the library for one request, building the catalog
+// again only when dlna.SystemUpdateID has changed. Links use the address the
+// player found by SSDP rather than PUBLIC_URL, which may not be reachable
+// from the local network.
+func newDLNAView(r *http.Request) *dlnaView {
+	// Read before the tracks, so a change meanwhile rebuilds it next time
+	updateID := dlna.SystemUpdateID()
+
+	dlnaCatalog.Lock()
+	defer dlnaCatalog.Unlock()
+	if dlnaCatalog.catalog == nil || dlnaCatalog.updateID != updateID {
+		dlnaCatalog.tracks = library.Tracks()
+		dlnaCatalog.catalog = subsonic.NewCatalog(dlnaCatalog.tracks)
+		dlnaCatalog.updateID = updateID
+	}
+	return &dlnaView{
+		catalog: dlnaCatalog.catalog,
+		tracks:  dlnaCatalog.tracks,
+		base:    "http://" + r.Host,
+	}
+}
+
+// dlnaContentDirectory answers the ContentDirectory actions
+func dlnaContentDirectory(r *http.Request, action *dlna.Action) ([]dlna.Arg, error) {
+	switch action.Name {
+	case "GetSearchCapabilities":
+		return []dlna.Arg{{Name: "SearchCaps", Value: dlna.SearchCapabilities}}, nil
+	case "GetSortCapabilities":
+		return []dlna.Arg{{Name: "SortCaps"}}, nil
+	case "GetSystemUpdateID":
+		return []dlna.Arg{{Name: "Id", Value: strconv.FormatUint(uint64(dlna.SystemUpdateID()), 10)}}, nil
+	case "Browse", "Search":
+	default:
+		return nil, dlna.Errorf(dlna.CodeInvalidAction, "Invalid Action")
+	}
+
+	start, err := dlnaCount(action, "StartingIndex")
+	if err != nil {
+		return nil, err
+	}
+	count, err := dlnaCount(action, "RequestedCount")
+	if err != nil {
+		return nil, err
+	}
+	view := newDLNAView(r)
+	var objects []dlna.Object
+	if action.Name == "Browse" {
+		objects, err = view.browse(action.Args["ObjectID"], action.Args["BrowseFlag"])
+	} else {
+		objects, err = view.search(action.Args["ContainerID"], action.Args["SearchCriteria"])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	total := len(objects)
+	objects = objects[min(start, total):]
+	if count > 0 && count < len(objects) {
+		objects = objects[:count]
+	}
+	return []dlna.Arg{
+		{Name: "Result", Value: dlna.MarshalDIDL(objects)},
+		{Name: "NumberReturned", Value: strconv.Itoa(len(objects))},
+		{Name: "TotalMatches", Value: strconv.Itoa(total)},
+		{Name: "UpdateID", Value: strconv.FormatUint(uint64(dlna.SystemUpdateID()), 10)},
+	}, nil
+}
+
+// dlnaCount parses an unsigned argument, zero when absent
+func dlnaCount(action *dlna.Action, name string) (int, error) {
+	value := action.Args[name]
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, dlna.Errorf(dlna.CodeInvalidArgs, "Invalid Args")
+	}
+	return n, nil
+}
+
+// browse returns an object itself (BrowseMetadata) or its children
+// (BrowseDirectChildren)
+func (v *dlnaView) browse(id, flag string) ([]dlna.Object, error) {
+	var self dlna.Object
+	var children []dlna.Object
+	switch {
+	case id == dlnaRootID:
+		self = dlna.Object{ID: id, ParentID: "-1", Title: dlna.FriendlyName(), Class: dlna.ClassContainer, Container: true}
+		folders, _, _ := v.folderContents("")
+		children = []dlna.Object{
+			folders,
+			{ID: dlnaArtistsID, ParentID: id, Title: "Artists", Class: dlna.ClassContainer, Container: true, ChildCount: len(v.catalog.Artists)},
+			{ID: dlnaAlbumsID, ParentID: id, Title: "Albums", Class: dlna.ClassContainer, Container: true, ChildCount: len(v.catalog.Albums)},
+		}
+	case id == dlnaArtistsID:
+		self = dlna.Object{ID: id, ParentID: dlnaRootID, Title: "Artists", Class: dlna.ClassContainer, Container: true}
+		for _, artist := range v.catalog.Artists {
+			children = append(children, v.artist(artist))
+		}
+	case id == dlnaAlbumsID:
+		self = dlna.Object{ID: id, ParentID: dlnaRootID, Title: "Albums", Class: dlna.ClassContainer, Container: true}
+		for _, album := range v.catalog.Albums {
+			children = append(children, v.album(album, id))
+		}
+	case subsonic.IsArtistID(id):
+		artist, ok := v.catalog.Artist(id)
+		if !ok {
+			return nil, dlna.Errorf(dlna.CodeNoSuchObject, "No such object")
+		}
+		self = v.artist(artist)
+		for _, album := range artist.Albums {
+			children = append(children, v.album(album, id))
+		}
+	case subsonic.IsAlbumID(id):
+		album, ok := v.catalog.Album(id)
+		if !ok {
+			return nil, dlna.Errorf(dlna.CodeNoSuchObject, "No such object")
+		}
+		self = v.album(album, album.Artist.ID)
+		for _, t := range album.Tracks {
+			children = append(children, v.item(t, id))
+		}
+	default:
+		if dir, ok := subsonic.ParseDirID(id); ok && id != "1" {
+			var found bool
+			self, children, found = v.folderContents(dir)
+			if !found {
+				return nil, dlna.Errorf(dlna.CodeNoSuchObject, "No such object")
+			}
+			break
+		}
+		t, ok := library.Get(id)
+		if !ok {
+			return nil, dlna.Errorf(dlna.CodeNoSuchObject, "No such object")
+		}
+		self = v.item(t, dlnaFolderID(folderPath(t)))
+	}
+
+	switch flag {
+	case "BrowseMetadata":
+		self.ChildCount = len(children)
+		return []dlna.Object{self}, nil
+	case "BrowseDirectChildren":
+		return children, nil
+	}
+	return nil, dlna.Errorf(dlna.CodeInvalidArgs, "Invalid Args")
+}
+
+// dlnaFolderID returns the object ID of the folder holding a path
+func dlnaFolderID(p string) string {
+	return subsonic.DirID(subsonic.ParentDir(p))
+}
+
+// folderContents returns a folder of the music bucket with its subfolders
+// and tracks, as in the Subsonic getMusicDirectory
+func (v *dlnaView) folderContents(dir string) (dlna.Object, []dlna.Object, bool) {
+	id := subsonic.DirID(dir)
+	self := dlna.Object{ID: id, ParentID: dlnaRootID, Title: "Folders", Class: dlna.ClassStorageFolder, Container: true}
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+		self.ParentID = dlnaFolderID(dir)
+		self.Title = path.Base(dir)
+	}
+
+	// Subfolders count their own entries in the same pass
+	entries := map[string]map[string]bool{}
+	var names []string
+	var files []dlna.Object
+	for _, t := range v.tracks {
+		rest, ok := strings.CutPrefix(folderPath(t), prefix)
+		if !ok {
+			continue
+		}
+		name, below, nested := strings.Cut(rest, "/")
+		if !nested {
+			files = append(files, v.item(t, id))
+			continue
+		}
+		if entries[name] == nil {
+			entries[name] = map[string]bool{}
+			names = append(names, name)
+		}
+		entry, _, _ := strings.Cut(below, "/")
+		entries[name][entry] = true
+	}
+	if dir != "" && len(names)+len(files) == 0 {
+		return dlna.Object{}, nil, false
+	}
+
+	children := make([]dlna.Object, 0, len(names)+len(files))
+	for _, name := range names {
+		children = append(children, dlna.Object{
+			ID:         subsonic.DirID(prefix + name),
+			ParentID:   id,
+			Title:      name,
+			Class:      dlna.ClassStorageFolder,
+			Container:  true,
+			ChildCount: len(entries[name]),
+		})
+	}
+	children = append(children, files...)
+	self.ChildCount = len(children)
+	return self, children, true
+}
+
+func (v *dlnaView) artist(a *subsonic.Artist) dlna.Object {
+	return dlna.Object{
+		ID:         a.ID,
+		ParentID:   dlnaArtistsID,
+		Title:      a.Name,
+		Class:      dlna.ClassMusicArtist,
+		Container:  true,
+		ChildCount: len(a.Albums),
+		Artist:     a.Name,
+	}
+}
+
+func (v *dlnaView) album(a *subsonic.Album, parentID string) dlna.Object {
+	return dlna.Object{
+		ID:          a.ID,
+		ParentID:    parentID,
+		Title:       a.Name,
+		Class:       dlna.ClassMusicAlbum,
+		Container:   true,
+		ChildCount:  len(a.Tracks),
+		Artist:      a.Artist.Name,
+		Genre:       a.Genre,
+		Date:        dlnaDate(a.Year),
+		AlbumArtURI: v.base + dlna.Prefix + "cover/" + a.Tracks[0].ID,
+	}
+}
+
+// item converts a track, with its stream at the DLNA media endpoint
+func (v *dlnaView) item(t library.Track, parentID string) dlna.Object {
+	title := t.Title
+	if title == "" {
+		title = strings.TrimSuffix(path.Base(t.Path), path.Ext(t.Path))
+	}
+	class := dlna.ClassMusicTrack
+	if t.Kind == library.KindAudiobook {
+		class = dlna.ClassAudioBook
+	}
+	res := &dlna.Resource{
+		URL:             v.base + dlna.Prefix + "media/" + t.ID + strings.ToLower(path.Ext(t.Path)),
+		ProtocolInfo:    dlna.ProtocolInfo(getContentType(t.Path), true),
+		Size:            t.Size,
+		Duration:        t.Duration,
+		Bitrate:         t.Bitrate,
+		SampleFrequency: t.SampleRate,
+		Channels:        t.Channels,
+	}
+	// Cue tracks are cut on demand, so their size is unknown
+	if t.Cue != nil {
+		res.Size = 0
+	}
+	return dlna.Object{
+		ID:          t.ID,
+		ParentID:    parentID,
+		Title:       title,
+		Class:       class,
+		Artist:      t.Artist,
+		Album:       t.Album,
+		Genre:       t.Genre,
+		Date:        dlnaDate(t.Year),
+		TrackNumber: t.TrackNumber,
+		AlbumArtURI: v.base + dlna.Prefix + "cover/" + t.ID,
+		Res:         res,
+	}
+}
+
+// dlnaDate formats a year as a DIDL-Lite date, empty when unknown
+func dlnaDate(year int) string {
+	if year <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%04d-01-01", year)
+}
+
+// search returns the artists, albums and tracks below a container that
+// match the criteria. Tracks are listed under their album.
+func (v *dlnaView) search(containerID, criteria string) ([]dlna.Object, error) {
+	match, err := dlna.ParseCriteria(criteria)
+	if err != nil {
+		return nil, err
+	}
+
+	var artists []*subsonic.Artist
+	var albums []*subsonic.Album
+	var tracks []library.Track
+	switch {
+	case containerID == dlnaRootID || containerID == dlnaArtistsID || containerID == dlnaAlbumsID:
+		artists, albums, tracks = v.catalog.Artists, v.catalog.Albums, v.tracks
+	case subsonic.IsArtistID(containerID):
+		artist, ok := v.catalog.Artist(containerID)
+		if !ok {
+			return nil, dlna.Errorf(dlna.CodeNoSuchObject, "No such object")
+		}
+		albums = artist.Albums
+		for _, album := range albums {
+			tracks = append(tracks, album.Tracks...)
+		}
+	case subsonic.IsAlbumID(containerID):
+		album, ok := v.catalog.Album(containerID)
+		if !ok {
+			return nil, dlna.Errorf(dlna.CodeNoSuchObject, "No such object")
+		}
+		tracks = album.Tracks
+	default:
+		dir, ok := subsonic.ParseDirID(containerID)
+		if !ok {
+			return nil, dlna.Errorf(dlna.CodeNoSuchObject, "No such object")
+		}
+		prefix := ""
+		if dir != "" {
+			prefix = dir + "/"
+		}
+		for _, t := range v.tracks {
+			if strings.HasPrefix(folderPath(t), prefix) {
+				tracks = append(tracks, t)
+			}
+		}
+	}
+
+	var results []dlna.Object
+	for _, artist := range artists {
+		if o := v.artist(artist); match(&o) {
+			results = append(results, o)
+		}
+	}
+	for _, album := range albums {
+		if o := v.album(album, album.Artist.ID); match(&o) {
+			results = append(results, o)
+		}
+	}
+	for _, t := range tracks {
+		parentID := dlnaFolderID(folderPath(t))
+		if album, ok := v.catalog.AlbumOf(t.ID); ok {
+			parentID = album.ID
+		}
+		if o := v.item(t, parentID); match(&o) {
+			results = append(results, o)
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"MediaBackend/history"
+	"MediaBackend/library"
+	"MediaBackend/middleware"
+)
+
+// maxScrobbleBatch bounds the listens of one scrobble request
+const maxScrobbleBatch = 1000
+
+// Scrobble records what the user plays. The body is a submission, or an
+// array of them to upload listens collected offline:
+//
+//	{"type": "listen", "trackId": "...", "time": "2024-03-01T20:15:00Z", "played": 212, "client": "web"}
+//
+// type is "listen" (the default), "skip" or "nowPlaying".
+func Scrobble(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxJSONBodySize))
+	if err != nil {
+		http.Error(w, "Request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	user := middleware.User(r)
+
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		var batch []history.Submission
+		if err := decodeStrict(data, &batch); err != nil {
+			http.Error(w, "Invalid scrobbles: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(batch) > maxScrobbleBatch {
+			http.Error(w, fmt.Sprintf("At most %d scrobbles per request", maxScrobbleBatch), http.StatusBadRequest)
+			return
+		}
+		type failure struct {
+			Index int    `json:"index"`
+			Error string `json:"error"`
+		}
+		accepted := 0
+		failures := []failure{}
+		for i, s := range batch {
+			if _, err := history.Submit(r.Context(), user, s); err != nil {
+				if !errors.Is(err, history.ErrInvalid) {
+					log.Printf("Error recording scrobble: %v", err)
+				}
+				failures = append(failures, failure{i, err.Error()})
+				continue
+			}
+			accepted++
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"accepted": accepted, "errors": failures})
+		return
+	}
+
+	var s history.Submission
+	if err := decodeStrict(data, &s); err != nil {
+		http.Error(w, "Invalid scrobble: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	listen, err := history.Submit(r.Context(), user, s)
+	switch {
+	case errors.Is(err, history.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		http.Error(w, "Error recording scrobble", http.StatusInternalServerError)
+		log.Printf("Error recording scrobble: %v", err)
+	case listen == nil:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSON(w, http.StatusOK, listen)
+	}
+}
+
+// decodeStrict decodes JSON, rejecting unknown fields like decodeJSONBody
+func decodeStrict(data []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// History lists the user's listens, newest first. Query parameters: from
+// and to (date or RFC 3339, to exclusive), track, skips=true to include
+// skipped tracks, limit (default 50, at most 1000) and cursor, taken from
+// the next field of the previous page.
+func History(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	from, err := parseTimeParam(query.Get("from"))
+	if err != nil {
+		http.Error(w, "Invalid from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseTimeParam(query.Get("to"))
+	if err != nil {
+		http.Error(w, "Invalid to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	listens, next, err := history.Listens(r.Context(), middleware.User(r), history.Query{
+		From:    from,
+		To:      to,
+		TrackID: query.Get("track"),
+		Skips:   query.Get("skips") == "true",
+		Limit:   queryInt(r, "limit", 50, 1, 1000),
+		Cursor:  query.Get("cursor"),
+	})
+	switch {
+	case errors.Is(err, history.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, "Error reading history", http.StatusInternalServerError)
+		log.Printf("Error reading history: %v", err)
+		return
+	}
+	if listens == nil {
+		listens = []history.Listen{}
+	}
+	response := map[string]any{"listens": listens}
+	if next != "" {
+		response["next"] = next
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// HistoryResource serves /gomedia/api/history/now-playing, the per-track
+// statistics at /gomedia/api/history/tracks and those of one track at
+// /gomedia/api/history/tracks/{id}
+func HistoryResource(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.User(r)
+	rest := strings.TrimPrefix(r.URL.Path, "/gomedia/api/history/")
+
+	switch {
+	case rest == "now-playing":
+		np, ok := history.CurrentlyPlaying(user)
+		if !ok {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, http.StatusOK, np)
+
+	case rest == "tracks":
+		listTrackStats(w, r, user)
+
+	case strings.HasPrefix(rest, "tracks/"):
+		id := strings.TrimPrefix(rest, "tracks/")
+		writeJSON(w, http.StatusOK, newTrackStatsView(id, history.Stats(user, id)))
+
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// trackStatsView is the play statistics of a track with the track itself,
+// which is nil once deleted from the library
+type trackStatsView struct {
+	TrackID string         `json:"trackId"`
+	Track   *library.Track `json:"track,omitempty"`
+	history.TrackStats
+}
+
+func newTrackStatsView(id string, stats history.TrackStats) trackStatsView {
+	view := trackStatsView{TrackID: id, TrackStats: stats}
+	if track, ok := library.Get(id); ok {
+		view.Track = &track
+	}
+	return view
+}
+
+// listTrackStats lists the tracks the user listened to, sorted by sort:
+// plays (the default), skips or lastPlayed
+func listTrackStats(w http.ResponseWriter, r *http.Request, user string) {
+	all := history.AllStats(user)
+	ids := make([]string, 0, len(all))
+	for id := range all {
+		ids = append(ids, id)
+	}
+
+	var less func(a, b history.TrackStats) bool
+	switch r.URL.Query().Get("sort") {
+	case "", "plays":
+		less = func(a, b history.TrackStats) bool { return a.PlayCount > b.PlayCount }
+	case "skips":
+		less = func(a, b history.TrackStats) bool { return a.SkipCount > b.SkipCount }
+	case "lastPlayed":
+		less = func(a, b history.TrackStats) bool { return a.LastPlayed.After(b.LastPlayed) }
+	default:
+		http.Error(w, "sort must be plays, skips or lastPlayed", http.StatusBadRequest)
+		return
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := all[ids[i]], all[ids[j]]
+		if less(a, b) != less(b, a) {
+			return less(a, b)
+		}
+		return ids[i] < ids[j]
+	})
+
+	limit := queryInt(r, "limit", 50, 1, 1000)
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	views := make([]trackStatsView, len(ids))
+	for i, id := range ids {
+		views[i] = newTrackStatsView(id, all[id])
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"tracks": views})
+}
+
+// parseTimeParam parses a date (2006-01-02) or RFC 3339 time, returning
+// the zero time for an empty value
+func parseTimeParam(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
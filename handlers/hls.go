@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"MediaBackend/audio"
+	minioClient "MediaBackend/minio"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// hlsSegmentDuration is the target length of HLS segments in seconds
+const hlsSegmentDuration = 10
+
+// hlsFormat returns the format of a track that can be packaged as HLS
+// packed audio, or FormatUnknown
+func hlsFormat(filename string) audio.Format {
+	switch format := audio.FormatFromName(filename); format {
+	case audio.FormatMP3, audio.FormatAAC:
+		return format
+	default:
+		return audio.FormatUnknown
+	}
+}
+
+// ServeMusicHLSPlaylist returns a VOD media playlist splitting an MP3 or
+// AAC track into segments of about ten seconds at frame boundaries
+func ServeMusicHLSPlaylist(w http.ResponseWriter, r *http.Request, filename string) {
+	ctx := r.Context()
+
+	format := hlsFormat(filename)
+	if format == audio.FormatUnknown {
+		http.Error(w, "HLS is only available for MP3 and AAC", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	objectInfo, err := minioClient.StatObject(ctx, minioClient.MusicBucket, filename)
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		log.Printf("Error getting object info for %s: %v", filename, err)
+		return
+	}
+
+	etag := fmt.Sprintf(`"%s-hls%d"`, strings.Trim(objectInfo.ETag, `"`), hlsSegmentDuration)
+	if match := r.Header.Get("If-None-Match"); match != "" && match == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	index, ok := hlsFrameIndex(w, r, objectInfo, format)
+	if !ok {
+		return
+	}
+	segments := index.Segments(hlsSegmentDuration)
+
+	longest := 0.0
+	for _, s := range segments {
+		longest = max(longest, s.Duration)
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:3\n")
+	// Segment durations rounded to the nearest second must not exceed the target
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", max(int(math.Round(longest)), 1))
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	b.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	for i, s := range segments {
+		fmt.Fprintf(&b, "#EXTINF:%.5f,\n", s.Duration)
+		// Relative to .../{path}/index.m3u8
+		fmt.Fprintf(&b, "segment-%d.%s\n", i, format)
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Content-Length", strconv.Itoa(b.Len()))
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, b.String())
+}
+
+// ServeMusicHLSSegment returns one packed audio segment of a track, prefixed
+// with the ID3 timestamp tag required by HLS. Segments are cached per ETag.
+func ServeMusicHLSSegment(w http.ResponseWriter, r *http.Request, filename string) {
+	ctx := r.Context()
+
+	format := hlsFormat(filename)
+	name := strings.TrimPrefix(path.Base(r.URL.Path), "segment-")
+	number, ext, _ := strings.Cut(name, ".")
+	n, err := strconv.Atoi(number)
+	if format == audio.FormatUnknown || ext != string(format) || err != nil || n < 0 {
+		http.Error(w, "Segment not found", http.StatusNotFound)
+		return
+	}
+
+	objectInfo, err := minioClient.StatObject(ctx, minioClient.MusicBucket, filename)
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		log.Printf("Error getting object info for %s: %v", filename, err)
+		return
+	}
+
+	cacheKey := minioClient.CacheKey("hls", objectInfo.ETag,
+		fmt.Sprintf("%d-%d.%s", hlsSegmentDuration, n, format))
+	etag := fmt.Sprintf(`"%s-hls%d-%d"`, strings.Trim(objectInfo.ETag, `"`), hlsSegmentDuration, n)
+
+	data, err := minioClient.GetCached(ctx, cacheKey)
+	if err != nil {
+		if !errors.Is(err, minioClient.ErrCacheMiss) {
+			log.Printf("Error reading cached segment %s: %v", cacheKey, err)
+		}
+
+		index, ok := hlsFrameIndex(w, r, objectInfo, format)
+		if !ok {
+			return
+		}
+		segments := index.Segments(hlsSegmentDuration)
+		if n >= len(segments) {
+			http.Error(w, "Segment not found", http.StatusNotFound)
+			return
+		}
+
+		data, err = readSegment(r, objectInfo, segments[n])
+		if err != nil {
+			http.Error(w, "Error retrieving file", http.StatusInternalServerError)
+			log.Printf("Error reading segment %d of %s: %v", n, filename, err)
+			return
+		}
+		if err := minioClient.PutCached(ctx, cacheKey, data, getContentType(filename)); err != nil {
+			log.Printf("Error caching segment %s: %v", cacheKey, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", getContentType(filename))
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, "", objectInfo.LastModified, bytes.NewReader(data))
+}
+
+// hlsFrameIndex waits for the frame index that playlists and segments are
+// cut from, writing an error response when it is not available
+func hlsFrameIndex(w http.ResponseWriter, r *http.Request, objectInfo minio.ObjectInfo, format audio.Format) (*audio.FrameIndex, bool) {
+	index, err := awaitFrameIndex(r, objectInfo, format)
+	if errors.Is(err, errFrameIndexPending) {
+		w.Header().Set("Retry-After", "10")
+		http.Error(w, "Indexing the track is taking too long", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Error reading audio stream", http.StatusUnprocessableEntity)
+		log.Printf("Error indexing frames of %s: %v", objectInfo.Key, err)
+		return nil, false
+	}
+	return index, true
+}
+
+// readSegment reads the frames of a segment and prepends its timestamp tag
+func readSegment(r *http.Request, objectInfo minio.ObjectInfo, segment audio.Segment) ([]byte, error) {
+	opts := minio.GetObjectOptions{}
+	opts.SetRange(segment.Offset, segment.Offset+segment.Size-1)
+	object, err := minioClient.Client.GetObject(r.Context(), minioClient.MusicBucket, objectInfo.Key, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	data := audio.PackedAudioTimestamp(segment.Start)
+	frames := make([]byte, segment.Size)
+	if _, err := io.ReadFull(object, frames); err != nil {
+		return nil, err
+	}
+	return append(data, frames...), nil
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"MediaBackend/jobs"
+)
+
+// ListJobs returns all background jobs that are queued, running or recently finished
+func ListJobs(w http.ResponseWriter, r *http.Request) {
+	infos := jobs.List()
+	if infos == nil {
+		infos = []jobs.Info{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"jobs": infos})
+}
+
+// GetJob returns the status of a single background job
+func GetJob(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/gomedia/api/jobs/")
+	job, ok := jobs.Get(id)
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, job.Info())
+}
+
+// writeJobAccepted tells the client that its result is being produced by a background job
+func writeJobAccepted(w http.ResponseWriter, job *jobs.Job) {
+	w.Header().Set("Location", "/gomedia/api/jobs/"+job.ID)
+	w.Header().Set("Retry-After", "2")
+	writeJSON(w, http.StatusAccepted, job.Info())
+}
+
+// waitForJob waits up to the number of seconds given in the "wait" query
+// parameter (capped at max) and reports whether the job finished
+func waitForJob(r *http.Request, job *jobs.Job, max time.Duration) bool {
+	wait := time.Duration(queryInt(r, "wait", 0, 0, int(max/time.Second))) * time.Second
+	if wait == 0 {
+		select {
+		case <-job.Done():
+			return true
+		default:
+			return false
+		}
+	}
+	return awaitJob(r, job, wait)
+}
+
+// awaitJob blocks until the job finishes, the timeout passes or the client
+// goes away, and reports whether the job finished
+func awaitJob(r *http.Request, job *jobs.Job, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-job.Done():
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// progressReader reports the fraction of an object read so far to a job
+type progressReader struct {
+	r    io.Reader
+	job  *jobs.Job
+	size int64
+	read int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+	if p.size > 0 {
+		p.job.SetProgress(float64(p.read) / float64(p.size))
+	}
+	return n, err
+}
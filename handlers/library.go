@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"MediaBackend/library"
+)
+
+// ListLibraryTracks returns every indexed track with its metadata.
+// ?kind=audiobook or podcast keeps only spoken word tracks of that kind and
+// ?kind=music only the others.
+func ListLibraryTracks(w http.ResponseWriter, r *http.Request) {
+	tracks := library.Tracks()
+	if kind := r.URL.Query().Get("kind"); kind != "" {
+		if kind == "music" {
+			kind = ""
+		}
+		filtered := []library.Track{}
+		for _, t := range tracks {
+			if t.Kind == kind {
+				filtered = append(filtered, t)
+			}
+		}
+		tracks = filtered
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"tracks": tracks})
+}
+
+// GetLibraryTrack returns a single indexed track by ID
+func GetLibraryTrack(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/gomedia/api/library/tracks/")
+	track, ok := library.Get(id)
+	if !ok {
+		http.Error(w, "Track not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, track)
+}
+
+// ScanLibrary starts a rescan of the music bucket
+func ScanLibrary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJobAccepted(w, library.ScanJob())
+}
+
+// AnalyzeLibraryLoudness starts EBU R128 analysis of tracks without loudness
+// data, or of all tracks with ?force=true
+func AnalyzeLibraryLoudness(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJobAccepted(w, library.AnalyzeLoudness(r.URL.Query().Get("force") == "true"))
+}
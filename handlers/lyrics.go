@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"MediaBackend/audio"
+	"MediaBackend/middleware"
+	minioClient "MediaBackend/minio"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// maxLyricsSize bounds LRC uploads and sidecar reads
+const maxLyricsSize = 1 << 20
+
+// ServeMusicLyrics returns the lyrics of a track from a sibling .lrc file or
+// the file's own tags (GET), uploads or replaces the .lrc file (PUT) or
+// deletes it (DELETE). GET accepts ?format=lrc to return LRC text instead
+// of JSON lines. Without authentication the lyrics are read-only.
+func ServeMusicLyrics(w http.ResponseWriter, r *http.Request, filename string) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		getLyrics(w, r, filename)
+	case http.MethodPut, http.MethodDelete:
+		if !middleware.AuthEnabled() {
+			http.Error(w, "Changing lyrics requires authentication", http.StatusForbidden)
+			return
+		}
+		if r.Method == http.MethodPut {
+			putLyrics(w, r, filename)
+		} else {
+			deleteLyrics(w, r, filename)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// lyricsSidecar returns the path of the LRC file belonging to a track
+func lyricsSidecar(filename string) string {
+	return strings.TrimSuffix(filename, path.Ext(filename)) + ".lrc"
+}
+
+func getLyrics(w http.ResponseWriter, r *http.Request, filename string) {
+	ctx := r.Context()
+
+	lyrics, err := readLyricsSidecar(ctx, filename)
+	if errors.Is(err, audio.ErrNoLyrics) {
+		lyrics, err = readEmbeddedLyrics(ctx, filename)
+	}
+	if errors.Is(err, audio.ErrNoLyrics) {
+		http.Error(w, "No lyrics found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		log.Printf("Error reading lyrics of %s: %v", filename, err)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "lrc" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, lyrics.String())
+		return
+	}
+	writeJSON(w, http.StatusOK, lyrics)
+}
+
+// readLyricsSidecar parses the LRC file next to a track, returning
+// audio.ErrNoLyrics when there is none
+func readLyricsSidecar(ctx context.Context, filename string) (*audio.Lyrics, error) {
+	key := lyricsSidecar(filename)
+	if _, err := minioClient.StatObject(ctx, minioClient.MusicBucket, key); err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, audio.ErrNoLyrics
+		}
+		return nil, err
+	}
+	object, err := minioClient.GetObject(ctx, minioClient.MusicBucket, key)
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	data, err := io.ReadAll(io.LimitReader(object, maxLyricsSize))
+	if err != nil {
+		return nil, err
+	}
+	lyrics := audio.ParseLRC(data)
+	if len(lyrics.Lines) == 0 {
+		return nil, audio.ErrNoLyrics
+	}
+	return lyrics, nil
+}
+
+// readEmbeddedLyrics reads the lyrics stored in a track's tags
+func readEmbeddedLyrics(ctx context.Context, filename string) (*audio.Lyrics, error) {
+	format := audio.FormatFromName(filename)
+	objectInfo, err := minioClient.StatObject(ctx, minioClient.MusicBucket, filename)
+	if err != nil {
+		return nil, err
+	}
+	object, err := minioClient.GetObject(ctx, minioClient.MusicBucket, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	return audio.ReadEmbeddedLyrics(audio.NewBlockReaderAt(object, objectInfo.Size), objectInfo.Size, format)
+}
+
+func putLyrics(w http.ResponseWriter, r *http.Request, filename string) {
+	ctx := r.Context()
+
+	if _, err := minioClient.StatObject(ctx, minioClient.MusicBucket, filename); err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		log.Printf("Error getting object info for %s: %v", filename, err)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxLyricsSize))
+	if err != nil {
+		http.Error(w, "Lyrics too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	lyrics := audio.ParseLRC(data)
+	if len(lyrics.Lines) == 0 {
+		http.Error(w, "No lyrics in request body", http.StatusBadRequest)
+		return
+	}
+
+	key := lyricsSidecar(filename)
+	unlock := minioClient.LockObject(minioClient.MusicBucket, key)
+	defer unlock()
+	status := http.StatusOK
+	if _, err := minioClient.StatObject(ctx, minioClient.MusicBucket, key); err != nil {
+		status = http.StatusCreated
+	}
+
+	// Store as UTF-8 so that the content type holds for Latin-1 uploads
+	text := []byte(audio.DecodeText(data))
+	if _, err := minioClient.PutObject(ctx, minioClient.MusicBucket, key, bytes.NewReader(text), int64(len(text)), "text/plain; charset=utf-8"); err != nil {
+		http.Error(w, "Error saving lyrics", http.StatusInternalServerError)
+		log.Printf("Error saving lyrics %s: %v", key, err)
+		return
+	}
+	log.Printf("Saved lyrics: %s (%d lines)", key, len(lyrics.Lines))
+	writeJSON(w, status, lyrics)
+}
+
+func deleteLyrics(w http.ResponseWriter, r *http.Request, filename string) {
+	ctx := r.Context()
+
+	key := lyricsSidecar(filename)
+	unlock := minioClient.LockObject(minioClient.MusicBucket, key)
+	defer unlock()
+	if _, err := minioClient.StatObject(ctx, minioClient.MusicBucket, key); err != nil {
+		http.Error(w, "Lyrics file not found", http.StatusNotFound)
+		return
+	}
+	if err := minioClient.RemoveObject(ctx, minioClient.MusicBucket, key); err != nil {
+		http.Error(w, "Error deleting lyrics", http.StatusInternalServerError)
+		log.Printf("Error deleting lyrics %s: %v", key, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"MediaBackend/middleware"
+)
+
+func TestChangeLyricsWithoutAuth(t *testing.T) {
+	if middleware.AuthEnabled() {
+		t.Skip("AUTH_USERS is set")
+	}
+	for _, method := range []string{http.MethodPut, http.MethodDelete} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, "/api/music/a.mp3/lyrics", strings.NewReader("[00:01.00]la"))
+		ServeMusicLyrics(w, r, "a.mp3")
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s without authentication = %d, want %d", method, w.Code, http.StatusForbidden)
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"strconv"
 	"strings"
 
+	"MediaBackend/library"
 	minioClient "MediaBackend/minio"
 
 	"github.com/minio/minio-go/v7"
@@ -24,6 +25,12 @@ func StreamMinIOMusic(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Dispatch track sub-resources such as {path}/waveform
+	if track, action := splitMusicAction(filename); action != nil {
+		action(w, r, track)
+		return
+	}
+
 	ctx := context.Background()
 
 	// Get object info for metadata
@@ -34,6 +41,12 @@ func StreamMinIOMusic(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Convert on demand when another format is requested
+	if r.URL.Query().Get("format") != "" {
+		serveTranscoded(w, r, filename, objectInfo)
+		return
+	}
+
 	fileSize := objectInfo.Size
 
 	// Set content type based on file extension
@@ -43,6 +56,12 @@ func StreamMinIOMusic(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("ETag", objectInfo.ETag)
 	w.Header().Set("Last-Modified", objectInfo.LastModified.UTC().Format(http.TimeFormat))
 
+	// Seek by time (?t= or Range: seconds=) for MP3 streams
+	if isTimeSeek(r) {
+		serveMusicTimeRange(w, r, filename, objectInfo)
+		return
+	}
+
 	// Handle range requests for streaming
 	rangeHeader := r.Header.Get("Range")
 	if rangeHeader == "" {
@@ -71,9 +90,11 @@ func StreamMinIOMusic(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// For simplicity, only handle single range requests
-	start := ranges[0].start
-	end := ranges[0].end
+	serveMusicRange(w, ctx, filename, fileSize, ranges[0].start, ranges[0].end)
+}
 
+// serveMusicRange writes bytes start-end of a music object as partial content
+func serveMusicRange(w http.ResponseWriter, ctx context.Context, filename string, fileSize, start, end int64) {
 	// Get object with range
 	opts := minio.GetObjectOptions{}
 	opts.SetRange(start, end)
@@ -111,13 +132,18 @@ func ListMinIOMusic(w http.ResponseWriter, r *http.Request) {
 		}
 
 		name := filepath.Base(object.Key)
-		files = append(files, MediaFile{
+		file := MediaFile{
 			Name:        name,
 			Size:        object.Size,
 			Path:        object.Key,
 			Url:         "/gomedia/api/music/" + name,
 			ContentType: getContentType(name),
-		})
+		}
+		if track, ok := library.ByPath(object.Key); ok {
+			file.ID = track.ID
+			file.Loudness = track.Loudness
+		}
+		files = append(files, file)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"MediaBackend/audio"
+)
+
+// musicActionHandler serves a sub-resource of a track, e.g. /gomedia/api/music/{path}/waveform
+type musicActionHandler func(w http.ResponseWriter, r *http.Request, filename string)
+
+// musicActions maps the trailing path segment of a music URL to its handler
+var musicActions = map[string]musicActionHandler{
+	"waveform":        ServeMusicWaveform,
+	"spectrogram.png": ServeMusicSpectrogram,
+	"preview":         ServeMusicPreview,
+	"index.m3u8":      ServeMusicHLSPlaylist,
+	"lyrics":          ServeMusicLyrics,
+	"tags":            ServeMusicTags,
+	"chapters":        ServeMusicChapters,
+}
+
+// musicActionPrefixes maps prefixes of the trailing segment to handlers for
+// numbered sub-resources such as HLS segments and cue sheet tracks
+var musicActionPrefixes = map[string]musicActionHandler{
+	"segment-": ServeMusicHLSSegment,
+	"track-":   ServeMusicCueTrack,
+}
+
+// splitMusicAction splits "{path}/{action}" when action names a registered
+// sub-resource, otherwise it returns the path unchanged and a nil handler
+func splitMusicAction(p string) (string, musicActionHandler) {
+	i := strings.LastIndex(p, "/")
+	if i <= 0 {
+		return p, nil
+	}
+	name := p[i+1:]
+	if action, ok := musicActions[name]; ok {
+		return p[:i], action
+	}
+	// Only under audio files, so a track named "segment-1.mp3" stays a track
+	if audio.FormatFromName(p[:i]) == audio.FormatUnknown {
+		return p, nil
+	}
+	for prefix, action := range musicActionPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return p[:i], action
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"MediaBackend/library"
+	"MediaBackend/middleware"
+	"MediaBackend/playback"
+)
+
+// sessionView is a playback session with its current track and the
+// position estimated for the time of the response
+type sessionView struct {
+	playback.Session
+	Track       *library.Track `json:"track,omitempty"`
+	PositionNow float64        `json:"positionNow"`
+	ServerTime  time.Time      `json:"serverTime"`
+}
+
+func newSessionView(s playback.Session) sessionView {
+	now := time.Now().UTC()
+	view := sessionView{Session: s, PositionNow: s.PositionAt(now), ServerTime: now}
+	if track, ok := library.Get(s.TrackID); ok {
+		view.Track = &track
+	}
+	return view
+}
+
+// PlaybackSession serves the user's now playing session: GET to resume it
+// on another device, PUT to replace it with a new queue and DELETE to end
+// it. A PUT older than the stored session answers 409 with the session.
+func PlaybackSession(w http.ResponseWriter, r *http.Request) {
+	user := middleware.User(r)
+	w.Header().Set("Cache-Control", "no-store")
+
+	switch r.Method {
+	case http.MethodGet:
+		s, err := playback.Get(r.Context(), user)
+		if err != nil {
+			writePlaybackError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, newSessionView(s))
+
+	case http.MethodPut:
+		var input struct {
+			Queue    []string  `json:"queue"`
+			Index    int       `json:"index"`
+			Position float64   `json:"position"`
+			Playing  bool      `json:"playing"`
+			Updated  time.Time `json:"updated"`
+			Device   string    `json:"device"`
+		}
+		if err := decodeJSONBody(w, r, &input); err != nil {
+			http.Error(w, "Invalid session: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		s, err := playback.Set(r.Context(), user, playback.Session{
+			Queue:    input.Queue,
+			Index:    input.Index,
+			Position: input.Position,
+			Playing:  input.Playing,
+			Updated:  input.Updated,
+			Device:   input.Device,
+		})
+		if err != nil {
+			writePlaybackError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, newSessionView(s))
+
+	case http.MethodDelete:
+		if err := playback.Delete(r.Context(), user); err != nil {
+			writePlaybackError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// PlaybackHeartbeat updates the position of the user's session from
+// {"index": 3, "trackId": "...", "position": 81.5, "playing": true}, sent
+// every few seconds while playing. A 409 carries the session another device
+// has changed since, which the sender should follow.
+func PlaybackHeartbeat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var h playback.Heartbeat
+	if err := decodeJSONBody(w, r, &h); err != nil {
+		http.Error(w, "Invalid heartbeat: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	s, err := playback.Beat(r.Context(), middleware.User(r), h)
+	if err != nil {
+		writePlaybackError(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, newSessionView(s))
+}
+
+func writePlaybackError(w http.ResponseWriter, err error) {
+	var stale *playback.StaleError
+	switch {
+	case errors.As(err, &stale):
+		writeJSON(w, http.StatusConflict, map[string]any{
+			"error":   err.Error(),
+			"current": newSessionView(stale.Current),
+		})
+	case errors.Is(err, playback.ErrNotFound):
+		http.Error(w, "No playback session", http.StatusNotFound)
+	case errors.Is(err, playback.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Error accessing playback session", http.StatusInternalServerError)
+		log.Printf("Error accessing playback session: %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"MediaBackend/library"
+	"MediaBackend/middleware"
+	"MediaBackend/playlists"
+)
+
+// maxPlaylistFileSize limits uploaded playlist files
+const maxPlaylistFileSize = 5 << 20
+
+// exportPlaylist writes a playlist as an M3U, PLS or XSPF file with
+// absolute stream URLs. Entries whose track was deleted are left out.
+func exportPlaylist(w http.ResponseWriter, r *http.Request, id string, format playlists.Format) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p, err := playlists.Get(middleware.User(r), id)
+	if err != nil {
+		writePlaylistError(w, err)
+		return
+	}
+
+	base := publicBaseURL(r)
+	tracks := make([]playlists.ExportTrack, 0, len(p.Tracks))
+	for _, trackID := range p.Tracks {
+		t, ok := library.Get(trackID)
+		if !ok {
+			continue
+		}
+		title := t.Title
+		if title == "" {
+			title = strings.TrimSuffix(path.Base(t.Path), path.Ext(t.Path))
+		}
+		tracks = append(tracks, playlists.ExportTrack{
+			Location:    base + (&url.URL{Path: t.URL}).EscapedPath(),
+			Title:       title,
+			Artist:      t.Artist,
+			Album:       t.Album,
+			TrackNumber: t.TrackNumber,
+			Duration:    t.Duration,
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := playlists.Write(&buf, format, p.Name, tracks); err != nil {
+		http.Error(w, "Error writing playlist", http.StatusInternalServerError)
+		log.Printf("Error exporting playlist %s: %v", id, err)
+		return
+	}
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{
+		"filename": p.Name + "." + string(format),
+	}))
+	w.Header().Set("Cache-Control", "private, no-cache")
+	// Smart playlists change without being updated
+	modified := p.Updated
+	if p.Rules != nil {
+		modified = time.Time{}
+	}
+	http.ServeContent(w, r, "", modified, bytes.NewReader(buf.Bytes()))
+}
+
+// ImportPlaylist creates a playlist from an uploaded M3U, PLS or XSPF file,
+// sent as the body or as the "file" field of a multipart form. Entries are
+// matched to library tracks by path and then by tags; unmatched entries are
+// reported with their line. ?name= overrides the name recorded in the file
+// and ?dryRun=true reports the matches without creating the playlist.
+func ImportPlaylist(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxPlaylistFileSize)
+
+	var body io.Reader = r.Body
+	fileName := ""
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "Missing playlist file", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		body = file
+		fileName = header.Filename
+	}
+
+	fileTitle, entries, err := playlists.Parse(body)
+	if err != nil {
+		http.Error(w, "Invalid playlist file: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(entries) == 0 {
+		http.Error(w, "Playlist file has no entries", http.StatusBadRequest)
+		return
+	}
+
+	matches := playlists.MatchEntries(entries)
+	ids := make([]string, 0, len(matches))
+	unmatched := []playlists.Match{}
+	for _, m := range matches {
+		if m.TrackID == "" {
+			unmatched = append(unmatched, m)
+		} else {
+			ids = append(ids, m.TrackID)
+		}
+	}
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = fileTitle
+	}
+	if name == "" && fileName != "" {
+		name = strings.TrimSuffix(path.Base(fileName), path.Ext(fileName))
+	}
+	if name == "" {
+		name = "Imported playlist"
+	}
+
+	result := map[string]any{
+		"matched":   len(ids),
+		"unmatched": unmatched,
+	}
+	if r.URL.Query().Get("dryRun") == "true" {
+		result["entries"] = matches
+		writeJSON(w, http.StatusOK, result)
+		return
+	}
+	if len(ids) == 0 {
+		result["error"] = "No entries matched library tracks"
+		writeJSON(w, http.StatusUnprocessableEntity, result)
+		return
+	}
+
+	created, err := playlists.Create(r.Context(), middleware.User(r), playlists.Playlist{Name: name, Tracks: ids})
+	if err != nil {
+		writePlaylistError(w, err)
+		return
+	}
+	log.Printf("Imported playlist %s: %d matched, %d unmatched", created.ID, len(ids), len(unmatched))
+	result["playlist"] = newPlaylistView(created, false)
+	w.Header().Set("Location", "/gomedia/api/playlists/"+created.ID)
+	writeJSON(w, http.StatusCreated, result)
+}
@@ -0,0 +1,435 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"MediaBackend/library"
+	"MediaBackend/middleware"
+	"MediaBackend/playlists"
+)
+
+// playlistView is a playlist as returned by the API
+type playlistView struct {
+	playlists.Playlist
+	CoverURL   string  `json:"coverUrl,omitempty"`
+	TrackCount int     `json:"trackCount"`
+	Duration   float64 `json:"duration"`
+	// Entries resolves the tracks, for single playlist responses
+	Entries []playlistEntry `json:"entries,omitempty"`
+}
+
+// playlistEntry is a track of a playlist at its position
+type playlistEntry struct {
+	Position int    `json:"position"`
+	ID       string `json:"id"`
+	// Track is nil when the track has been deleted from the library
+	Track   *library.Track `json:"track,omitempty"`
+	Missing bool           `json:"missing,omitempty"`
+}
+
+// newPlaylistView summarises a playlist, resolving its tracks when entries is set
+func newPlaylistView(p playlists.Playlist, entries bool) playlistView {
+	view := playlistView{Playlist: p, CoverURL: p.CoverURL(), TrackCount: len(p.Tracks)}
+	for i, id := range p.Tracks {
+		track, ok := library.Get(id)
+		if ok {
+			view.Duration += track.Duration
+		}
+		if !entries {
+			continue
+		}
+		entry := playlistEntry{Position: i, ID: id, Missing: !ok}
+		if ok {
+			entry.Track = &track
+		}
+		view.Entries = append(view.Entries, entry)
+	}
+	return view
+}
+
+// playlistInput is the body of create and update requests
+type playlistInput struct {
+	Name        *string  `json:"name"`
+	Description *string  `json:"description"`
+	Tracks      []string `json:"tracks"`
+	// Rules make a smart playlist; null turns it back into a manual one
+	Rules json.RawMessage `json:"rules"`
+}
+
+// rules decodes the rules of the input. set is false when they are omitted
+// and rules is nil when they are null.
+func (input playlistInput) rules() (rules *playlists.Rules, set bool, err error) {
+	if input.Rules == nil {
+		return nil, false, nil
+	}
+	if string(input.Rules) == "null" {
+		return nil, true, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(input.Rules))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&rules); err != nil {
+		return nil, true, fmt.Errorf("rules: %w", err)
+	}
+	return rules, true, nil
+}
+
+// Playlists lists the playlists of the user (GET) or creates one (POST)
+func Playlists(w http.ResponseWriter, r *http.Request) {
+	user := middleware.User(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		list := playlists.List(user)
+		views := make([]playlistView, 0, len(list))
+		for _, p := range list {
+			views = append(views, newPlaylistView(p, false))
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"playlists": views})
+
+	case http.MethodPost:
+		var input playlistInput
+		if err := decodeJSONBody(w, r, &input); err != nil {
+			http.Error(w, "Invalid playlist: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		rules, _, err := input.rules()
+		if err != nil {
+			http.Error(w, "Invalid playlist: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		p := playlists.Playlist{Tracks: input.Tracks, Rules: rules}
+		if input.Name != nil {
+			p.Name = *input.Name
+		}
+		if input.Description != nil {
+			p.Description = *input.Description
+		}
+		created, err := playlists.Create(r.Context(), user, p)
+		if err != nil {
+			writePlaylistError(w, err)
+			return
+		}
+		w.Header().Set("Location", "/gomedia/api/playlists/"+created.ID)
+		writeJSON(w, http.StatusCreated, newPlaylistView(created, true))
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// PlaylistResource serves /gomedia/api/playlists/{id}, its .m3u8, .pls and
+// .xspf exports and its sub-resources: tracks, tracks/move, dedupe, cover
+// and shuffle
+func PlaylistResource(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/gomedia/api/playlists/")
+	id, sub, _ := strings.Cut(rest, "/")
+
+	switch sub {
+	case "":
+		if base, ext, ok := strings.Cut(id, "."); ok {
+			format, known := playlists.FormatFromName(ext)
+			if !known {
+				http.Error(w, "Unsupported playlist format", http.StatusNotFound)
+				return
+			}
+			exportPlaylist(w, r, base, format)
+			return
+		}
+		servePlaylist(w, r, id)
+	case "tracks":
+		servePlaylistTracks(w, r, id)
+	case "tracks/move":
+		movePlaylistTracks(w, r, id)
+	case "dedupe":
+		dedupePlaylist(w, r, id)
+	case "cover":
+		servePlaylistCover(w, r, id)
+	case "shuffle":
+		shufflePlaylist(w, r, id)
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+func servePlaylist(w http.ResponseWriter, r *http.Request, id string) {
+	user := middleware.User(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		p, err := playlists.Get(user, id)
+		if err != nil {
+			writePlaylistError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, newPlaylistView(p, true))
+
+	case http.MethodPatch:
+		// Omitted fields are kept; "tracks" replaces the whole list, e.g. to reorder it
+		var input playlistInput
+		if err := decodeJSONBody(w, r, &input); err != nil {
+			http.Error(w, "Invalid playlist: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		rules, setRules, err := input.rules()
+		if err != nil {
+			http.Error(w, "Invalid playlist: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		updatePlaylist(w, r, id, func(p *playlists.Playlist) error {
+			if setRules {
+				p.Rules = rules
+			}
+			if input.Name != nil {
+				p.Name = *input.Name
+			}
+			if input.Description != nil {
+				p.Description = *input.Description
+			}
+			if input.Tracks != nil {
+				p.Tracks = input.Tracks
+			}
+			return nil
+		})
+
+	case http.MethodDelete:
+		if err := playlists.Delete(r.Context(), user, id); err != nil {
+			writePlaylistError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// servePlaylistTracks adds tracks (POST) or removes entries by position or
+// track ID (DELETE ?positions=0,3 or ?track={id})
+func servePlaylistTracks(w http.ResponseWriter, r *http.Request, id string) {
+	switch r.Method {
+	case http.MethodPost:
+		var input struct {
+			Tracks []string `json:"tracks"`
+			// Position defaults to the end of the playlist
+			Position *int `json:"position"`
+			// SkipDuplicates leaves out tracks already in the playlist
+			SkipDuplicates bool `json:"skipDuplicates"`
+		}
+		if err := decodeJSONBody(w, r, &input); err != nil {
+			http.Error(w, "Invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(input.Tracks) == 0 {
+			http.Error(w, "No tracks to add", http.StatusBadRequest)
+			return
+		}
+		updatePlaylist(w, r, id, func(p *playlists.Playlist) error {
+			ids := input.Tracks
+			if input.SkipDuplicates {
+				ids = nil
+				for _, t := range input.Tracks {
+					if !p.Contains(t) && !slices.Contains(ids, t) {
+						ids = append(ids, t)
+					}
+				}
+			}
+			position := -1
+			if input.Position != nil {
+				position = *input.Position
+			}
+			p.Insert(position, ids...)
+			return nil
+		})
+
+	case http.MethodDelete:
+		query := r.URL.Query()
+		var positions []int
+		for _, s := range strings.Split(query.Get("positions"), ",") {
+			if s == "" {
+				continue
+			}
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				http.Error(w, "Invalid position "+strconv.Quote(s), http.StatusBadRequest)
+				return
+			}
+			positions = append(positions, n)
+		}
+		track := query.Get("track")
+		if len(positions) == 0 && track == "" {
+			http.Error(w, "Pass positions or track", http.StatusBadRequest)
+			return
+		}
+		updatePlaylist(w, r, id, func(p *playlists.Playlist) error {
+			if err := p.RemoveAt(positions...); err != nil {
+				return err
+			}
+			if track != "" {
+				p.RemoveTrack(track)
+			}
+			return nil
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// movePlaylistTracks moves a run of entries: {"from": 3, "count": 2, "to": 0}
+func movePlaylistTracks(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var input struct {
+		From  int  `json:"from"`
+		Count *int `json:"count"`
+		To    int  `json:"to"`
+	}
+	if err := decodeJSONBody(w, r, &input); err != nil {
+		http.Error(w, "Invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	count := 1
+	if input.Count != nil {
+		count = *input.Count
+	}
+	updatePlaylist(w, r, id, func(p *playlists.Playlist) error {
+		return p.Move(input.From, count, input.To)
+	})
+}
+
+// dedupePlaylist removes repeated tracks, keeping the first occurrence
+func dedupePlaylist(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	updatePlaylist(w, r, id, func(p *playlists.Playlist) error {
+		p.Dedupe()
+		return nil
+	})
+}
+
+// shufflePlaylist picks a new order for a smart playlist sorted at random
+func shufflePlaylist(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p, err := playlists.Reshuffle(r.Context(), middleware.User(r), id)
+	if err != nil {
+		writePlaylistError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newPlaylistView(p, true))
+}
+
+// PreviewPlaylistRules evaluates smart playlist rules, posted as the body,
+// without saving a playlist
+func PreviewPlaylistRules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var rules playlists.Rules
+	if err := decodeJSONBody(w, r, &rules); err != nil {
+		http.Error(w, "Invalid rules: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	ids, err := rules.Evaluate(middleware.User(r), time.Now().UnixNano())
+	if err != nil {
+		writePlaylistError(w, err)
+		return
+	}
+	view := newPlaylistView(playlists.Playlist{Tracks: ids, Rules: &rules}, true)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"trackCount": view.TrackCount,
+		"duration":   view.Duration,
+		"entries":    view.Entries,
+	})
+}
+
+// servePlaylistCover returns (GET), uploads (PUT) or deletes (DELETE) the
+// cover image of a playlist
+func servePlaylistCover(w http.ResponseWriter, r *http.Request, id string) {
+	ctx := r.Context()
+	user := middleware.User(r)
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		object, info, err := playlists.OpenCover(ctx, user, id)
+		if err != nil {
+			writePlaylistError(w, err)
+			return
+		}
+		defer object.Close()
+		w.Header().Set("Content-Type", info.ContentType)
+		w.Header().Set("ETag", quoteETag(info.ETag))
+		w.Header().Set("Cache-Control", "private, max-age=3600")
+		http.ServeContent(w, r, "", info.LastModified, object)
+
+	case http.MethodPut:
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, playlists.MaxCoverSize))
+		if err != nil {
+			http.Error(w, "Cover image too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		p, err := playlists.SetCover(ctx, user, id, data)
+		if err != nil {
+			writePlaylistError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, newPlaylistView(p, false))
+
+	case http.MethodDelete:
+		if _, err := playlists.DeleteCover(ctx, user, id); err != nil {
+			writePlaylistError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// updatePlaylist applies fn to a playlist of the user and responds with the result
+func updatePlaylist(w http.ResponseWriter, r *http.Request, id string, fn func(p *playlists.Playlist) error) {
+	p, err := playlists.Update(r.Context(), middleware.User(r), id, fn)
+	if err != nil {
+		writePlaylistError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newPlaylistView(p, true))
+}
+
+// writePlaylistError maps playlist errors to responses. Invalid rules are
+// reported as JSON listing each problem.
+func writePlaylistError(w http.ResponseWriter, err error) {
+	var rulesErr *playlists.RulesError
+	switch {
+	case errors.As(err, &rulesErr):
+		writeJSON(w, http.StatusBadRequest, map[string]any{
+			"error":    "Invalid rules",
+			"problems": rulesErr.Problems,
+		})
+	case errors.Is(err, playlists.ErrNotFound):
+		http.Error(w, "Playlist not found", http.StatusNotFound)
+	case errors.Is(err, playlists.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Error saving playlist", http.StatusInternalServerError)
+		log.Printf("Error updating playlist: %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"MediaBackend/audio"
+	minioClient "MediaBackend/minio"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// maxPreviewDuration caps the length of generated clips, in seconds
+const maxPreviewDuration = 120
+
+// ServeMusicPreview returns a clip of a track cut at frame boundaries
+// without re-encoding. Clips are cached per source ETag.
+//
+// Query parameters:
+//   - start: clip start in seconds (default 0)
+//   - duration: clip length in seconds (default 30, at most 120)
+func ServeMusicPreview(w http.ResponseWriter, r *http.Request, filename string) {
+	ctx := r.Context()
+
+	format := audio.FormatFromName(filename)
+	if format != audio.FormatMP3 && format != audio.FormatAAC && format != audio.FormatFLAC {
+		http.Error(w, "Previews are only available for MP3, AAC and FLAC", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	// Round to milliseconds so equivalent requests share a cache entry
+	start := queryFloat(r, "start", 0, 0, 1e6)
+	duration := queryFloat(r, "duration", 30, 0.1, maxPreviewDuration)
+	startParam := strconv.FormatFloat(start, 'f', 3, 64)
+	durationParam := strconv.FormatFloat(duration, 'f', 3, 64)
+	start, _ = strconv.ParseFloat(startParam, 64)
+	duration, _ = strconv.ParseFloat(durationParam, 64)
+
+	objectInfo, err := minioClient.StatObject(ctx, minioClient.MusicBucket, filename)
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		log.Printf("Error getting object info for %s: %v", filename, err)
+		return
+	}
+
+	cacheKey := minioClient.CacheKey("previews", objectInfo.ETag,
+		fmt.Sprintf("%s-%s.%s", startParam, durationParam, format))
+	etag := fmt.Sprintf(`"%s-pv%s-%s"`, strings.Trim(objectInfo.ETag, `"`), startParam, durationParam)
+
+	approximate := false
+	data, err := minioClient.GetCached(ctx, cacheKey)
+	if err != nil {
+		if !errors.Is(err, minioClient.ErrCacheMiss) {
+			log.Printf("Error reading cached preview %s: %v", cacheKey, err)
+		}
+
+		data, approximate, err = clipBytes(ctx, objectInfo, format, start, duration)
+		if errors.Is(err, audio.ErrEmptyClip) {
+			http.Error(w, "Start is past the end of the track", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Error cutting preview", http.StatusUnprocessableEntity)
+			log.Printf("Error cutting preview of %s: %v", filename, err)
+			return
+		}
+
+		if !approximate {
+			if err := minioClient.PutCached(ctx, cacheKey, data, getContentType(filename)); err != nil {
+				log.Printf("Error caching preview %s: %v", cacheKey, err)
+			}
+		}
+		log.Printf("Generated preview: %s (%ss from %ss, %d bytes)", filename, durationParam, startParam, len(data))
+	}
+
+	w.Header().Set("Content-Type", getContentType(filename))
+	setClipCaching(w, etag, approximate)
+	// ServeContent handles Range and If-None-Match for the clip
+	http.ServeContent(w, r, "", objectInfo.LastModified, bytes.NewReader(data))
+}
+
+// setClipCaching lets clients cache an exact clip. An approximate one gets
+// no ETag, as it differs from the exact clip served for the same request.
+func setClipCaching(w http.ResponseWriter, etag string, approximate bool) {
+	if approximate {
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("X-Seek-Approximate", "true")
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("ETag", etag)
+}
+
+// trackClip is a clip of a track together with the object it reads from
+type trackClip struct {
+	*audio.Clip
+	io.Closer
+	// approximate is set when the start or end of an MP3 clip was estimated
+	// from the seek table
+	approximate bool
+}
+
+// cutClip plans the frames of [start, start+duration) seconds of a track
+// from the music bucket. The audio is read as the clip is written, so the
+// clip must be closed afterwards.
+func cutClip(ctx context.Context, objectInfo minio.ObjectInfo, format audio.Format, start, duration float64) (*trackClip, error) {
+	if format == audio.FormatMP3 {
+		// Use the same frame lookup as time-based seeking
+		seeker, err := openMP3Seeker(ctx, objectInfo.Key, objectInfo)
+		if err != nil {
+			return nil, err
+		}
+
+		from, startTime, approximate, err := seeker.seek(ctx, start)
+		if err != nil {
+			seeker.Close()
+			return nil, err
+		}
+		to, _, endApproximate, err := seeker.seek(ctx, start+duration)
+		if err != nil {
+			seeker.Close()
+			return nil, err
+		}
+		clip, err := audio.ClipMP3(seeker.reader, seeker.info, from, to, startTime)
+		if err != nil {
+			seeker.Close()
+			return nil, err
+		}
+		return &trackClip{Clip: clip, Closer: seeker, approximate: approximate || endApproximate}, nil
+	}
+
+	object, err := minioClient.GetObject(ctx, minioClient.MusicBucket, objectInfo.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := audio.NewBlockReaderAt(object, objectInfo.Size)
+	var clip *audio.Clip
+	switch format {
+	case audio.FormatAAC:
+		clip, err = audio.ClipADTS(reader, objectInfo.Size, start, duration)
+	case audio.FormatWAV:
+		clip, err = audio.ClipWAV(reader, objectInfo.Size, start, duration)
+	default:
+		clip, err = audio.ClipFLAC(reader, objectInfo.Size, start, duration)
+	}
+	if err != nil {
+		object.Close()
+		return nil, err
+	}
+	return &trackClip{Clip: clip, Closer: object}, nil
+}
+
+// clipBytes cuts a clip and reads it whole, for caching. Approximate clips
+// must not be cached, since the same request is cut exactly once the frame
+// index is built.
+func clipBytes(ctx context.Context, objectInfo minio.ObjectInfo, format audio.Format, start, duration float64) (data []byte, approximate bool, err error) {
+	clip, err := cutClip(ctx, objectInfo, format, start, duration)
+	if err != nil {
+		return nil, false, err
+	}
+	defer clip.Close()
+	data, err = clip.Bytes()
+	return data, clip.approximate, err
+}
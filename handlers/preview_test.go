@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSetClipCaching(t *testing.T) {
+	cases := []struct {
+		approximate              bool
+		cacheControl, etag, seek string
+	}{
+		{false, "public, max-age=86400", `"e-pv0.000-30.000"`, ""},
+		{true, "no-store", "", "true"},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		setClipCaching(w, `"e-pv0.000-30.000"`, c.approximate)
+		h := w.Header()
+		if h.Get("Cache-Control") != c.cacheControl || h.Get("ETag") != c.etag || h.Get("X-Seek-Approximate") != c.seek {
+			t.Errorf("approximate %v: Cache-Control %q, ETag %q, X-Seek-Approximate %q", c.approximate,
+				h.Get("Cache-Control"), h.Get("ETag"), h.Get("X-Seek-Approximate"))
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"MediaBackend/audio"
+	"MediaBackend/library"
+	"MediaBackend/middleware"
+	minioClient "MediaBackend/minio"
+	"MediaBackend/progress"
+)
+
+// positionView is a playback position with its track, which is nil once
+// deleted from the library
+type positionView struct {
+	progress.Position
+	Track *library.Track `json:"track,omitempty"`
+}
+
+func newPositionView(p progress.Position) positionView {
+	view := positionView{Position: p}
+	if track, ok := library.Get(p.TrackID); ok {
+		view.Track = &track
+	}
+	return view
+}
+
+// Positions lists the user's playback positions, most recent first, to
+// offer resuming on another device. ?finished=false leaves out finished
+// tracks.
+func Positions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	list, err := progress.Positions(r.Context(), middleware.User(r))
+	if err != nil {
+		writeProgressError(w, err)
+		return
+	}
+	views := []positionView{}
+	for _, p := range list {
+		if r.URL.Query().Get("finished") == "false" && p.Finished {
+			continue
+		}
+		views = append(views, newPositionView(p))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"positions": views})
+}
+
+// positionInput is the body of a position update
+type positionInput struct {
+	Position *float64 `json:"position"`
+	Finished bool     `json:"finished"`
+	// Updated is when the device reached the position, defaulting to now
+	Updated time.Time `json:"updated"`
+	Client  string    `json:"client"`
+}
+
+// PositionResource serves the user's position in a track at
+// /gomedia/api/positions/{trackId}: GET, PUT to save it and DELETE to
+// forget it. A PUT older than the stored position answers 409 with the
+// stored one, which the device should resume from instead.
+func PositionResource(w http.ResponseWriter, r *http.Request) {
+	trackID := strings.TrimPrefix(r.URL.Path, "/gomedia/api/positions/")
+	if trackID == "" || strings.Contains(trackID, "/") {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	user := middleware.User(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		p, err := progress.Get(r.Context(), user, trackID)
+		if err != nil {
+			writeProgressError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, newPositionView(p))
+
+	case http.MethodPut:
+		var input positionInput
+		if err := decodeJSONBody(w, r, &input); err != nil {
+			http.Error(w, "Invalid position: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if input.Position == nil {
+			http.Error(w, "Missing position", http.StatusBadRequest)
+			return
+		}
+		p, err := progress.Set(r.Context(), user, progress.Position{
+			TrackID:  trackID,
+			Position: *input.Position,
+			Finished: input.Finished,
+			Updated:  input.Updated,
+			Client:   input.Client,
+		})
+		if err != nil {
+			writeProgressError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, newPositionView(p))
+
+	case http.MethodDelete:
+		if err := progress.Delete(r.Context(), user, trackID); err != nil {
+			writeProgressError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Bookmarks lists the user's bookmarks, of one track with ?track= (GET), or
+// adds one (POST) from {"trackId": "...", "position": 754.2, "note": "..."}
+func Bookmarks(w http.ResponseWriter, r *http.Request) {
+	user := middleware.User(r)
+	switch r.Method {
+	case http.MethodGet:
+		list, err := progress.Bookmarks(r.Context(), user, r.URL.Query().Get("track"))
+		if err != nil {
+			writeProgressError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"bookmarks": list})
+
+	case http.MethodPost:
+		var input struct {
+			TrackID  string  `json:"trackId"`
+			Position float64 `json:"position"`
+			Note     string  `json:"note"`
+		}
+		if err := decodeJSONBody(w, r, &input); err != nil {
+			http.Error(w, "Invalid bookmark: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		b, err := progress.AddBookmark(r.Context(), user, progress.Bookmark{
+			TrackID:  input.TrackID,
+			Position: input.Position,
+			Note:     input.Note,
+		})
+		if err != nil {
+			writeProgressError(w, err)
+			return
+		}
+		w.Header().Set("Location", "/gomedia/api/bookmarks/"+b.ID)
+		writeJSON(w, http.StatusCreated, b)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// BookmarkResource changes (PATCH) or removes (DELETE) a bookmark at
+// /gomedia/api/bookmarks/{id}
+func BookmarkResource(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/gomedia/api/bookmarks/")
+	user := middleware.User(r)
+	switch r.Method {
+	case http.MethodPatch:
+		var update progress.BookmarkUpdate
+		if err := decodeJSONBody(w, r, &update); err != nil {
+			http.Error(w, "Invalid bookmark: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		b, err := progress.UpdateBookmark(r.Context(), user, id, update)
+		if err != nil {
+			writeProgressError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, b)
+
+	case http.MethodDelete:
+		if err := progress.DeleteBookmark(r.Context(), user, id); err != nil {
+			writeProgressError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeProgressError(w http.ResponseWriter, err error) {
+	var stale *progress.StaleError
+	switch {
+	case errors.As(err, &stale):
+		writeJSON(w, http.StatusConflict, map[string]any{
+			"error":   err.Error(),
+			"current": newPositionView(stale.Current),
+		})
+	case errors.Is(err, progress.ErrNotFound):
+		http.Error(w, "Not found", http.StatusNotFound)
+	case errors.Is(err, progress.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Error accessing playback progress", http.StatusInternalServerError)
+		log.Printf("Error accessing playback progress: %v", err)
+	}
+}
+
+// book groups the tracks of an audiobook or podcast
+type book struct {
+	Title    string   `json:"title"`
+	Author   string   `json:"author,omitempty"`
+	Kind     string   `json:"kind"`
+	Tracks   []string `json:"tracks"`
+	Duration float64  `json:"duration"`
+	Chapters int      `json:"chapters"`
+	// Position is the most recent position in any of the tracks
+	Position *progress.Position `json:"position,omitempty"`
+	Finished bool               `json:"finished"`
+}
+
+// Audiobooks lists the audiobooks (or podcasts with ?kind=podcast) of the
+// library, grouping tracks by album, with the user's progress in each
+func Audiobooks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	kind := r.URL.Query().Get("kind")
+	if kind == "" {
+		kind = library.KindAudiobook
+	}
+	if kind != library.KindAudiobook && kind != library.KindPodcast {
+		http.Error(w, "kind must be audiobook or podcast", http.StatusBadRequest)
+		return
+	}
+	positions, err := progress.Positions(r.Context(), middleware.User(r))
+	if err != nil {
+		writeProgressError(w, err)
+		return
+	}
+	byTrack := make(map[string]progress.Position, len(positions))
+	for _, p := range positions {
+		byTrack[p.TrackID] = p
+	}
+
+	tracks := library.Tracks()
+	sort.Slice(tracks, func(i, j int) bool {
+		a, b := tracks[i], tracks[j]
+		if a.DiscNumber != b.DiscNumber {
+			return a.DiscNumber < b.DiscNumber
+		}
+		if a.TrackNumber != b.TrackNumber {
+			return a.TrackNumber < b.TrackNumber
+		}
+		return a.Path < b.Path
+	})
+	books := map[string]*book{}
+	var order []string
+	for _, t := range tracks {
+		// A file cut by a cue sheet is listed through its virtual tracks
+		if t.Kind != kind || (t.Cue == nil && hasCueTracks(tracks, t.ID)) {
+			continue
+		}
+		author := t.AlbumArtist
+		if author == "" {
+			author = t.Artist
+		}
+		title := t.Album
+		if title == "" {
+			title = t.Title
+		}
+		if title == "" {
+			title = strings.TrimSuffix(t.Path[strings.LastIndex(t.Path, "/")+1:], "."+t.Format)
+		}
+		key := strings.ToLower(author + "\x00" + title)
+		if t.Album == "" {
+			key = t.ID
+		}
+		b, ok := books[key]
+		if !ok {
+			b = &book{Title: title, Author: author, Kind: kind, Finished: true}
+			books[key] = b
+			order = append(order, key)
+		}
+		b.Tracks = append(b.Tracks, t.ID)
+		b.Duration += t.Duration
+		b.Chapters += len(t.Chapters)
+		p, ok := byTrack[t.ID]
+		if !ok || !p.Finished {
+			b.Finished = false
+		}
+		if ok && (b.Position == nil || p.Updated.After(b.Position.Updated)) {
+			b.Position = &p
+		}
+	}
+
+	list := make([]*book, 0, len(order))
+	for _, key := range order {
+		list = append(list, books[key])
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return strings.ToLower(list[i].Title) < strings.ToLower(list[j].Title)
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"books": list})
+}
+
+// hasCueTracks reports whether a track is the parent of cue sheet tracks
+func hasCueTracks(tracks []library.Track, id string) bool {
+	for _, t := range tracks {
+		if t.Cue != nil && t.Cue.Parent == id {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeMusicChapters lists the chapters of a track, read from MP4 chapter
+// atoms or ID3 CHAP/CTOC frames
+func ServeMusicChapters(w http.ResponseWriter, r *http.Request, filename string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if track, ok := library.ByPath(filename); ok && track.Cue == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"trackId": track.ID, "chapters": chapterList(track.Chapters)})
+		return
+	}
+
+	// Tracks not indexed yet are read directly
+	ctx := r.Context()
+	objectInfo, err := minioClient.StatObject(ctx, minioClient.MusicBucket, filename)
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		log.Printf("Error getting object info for %s: %v", filename, err)
+		return
+	}
+	meta, err := readObjectMetadata(ctx, minioClient.MusicBucket, objectInfo, audio.FormatFromName(filename))
+	if err != nil {
+		http.Error(w, "Error reading chapters", http.StatusUnprocessableEntity)
+		log.Printf("Error reading chapters of %s: %v", filename, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"chapters": chapterList(meta.Chapters)})
+}
+
+func chapterList(chapters []audio.Chapter) []audio.Chapter {
+	if chapters == nil {
+		return []audio.Chapter{}
+	}
+	return chapters
+}
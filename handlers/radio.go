@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"MediaBackend/middleware"
+	"MediaBackend/radio"
+)
+
+// Radio starts a station with ?seed=track:{id}, artist:{name} or
+// genre:{name} and returns the first tracks of its endless queue. Passing
+// the returned next token as ?continue= fetches more. limit sets the
+// number of tracks per page (default 20, at most 100).
+func Radio(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	user := middleware.User(r)
+	limit := queryInt(r, "limit", 20, 1, 100)
+
+	var page *radio.Page
+	var err error
+	if token := query.Get("continue"); token != "" {
+		page, err = radio.Continue(r.Context(), user, token, limit)
+	} else {
+		var seed radio.Seed
+		if seed, err = radio.ParseSeed(query.Get("seed")); err == nil {
+			page, err = radio.Start(r.Context(), user, seed, limit)
+		}
+	}
+
+	switch {
+	case errors.Is(err, radio.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, radio.ErrNoSeed):
+		http.Error(w, "No tracks match the seed", http.StatusNotFound)
+	case err != nil:
+		http.Error(w, "Error building radio queue", http.StatusInternalServerError)
+		log.Printf("Error building radio queue: %v", err)
+	default:
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, page)
+	}
+}
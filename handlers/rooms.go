@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"MediaBackend/middleware"
+	"MediaBackend/rooms"
+)
+
+// roomView is a room with the links members join it by
+type roomView struct {
+	rooms.State
+	URL       string `json:"url"`
+	SocketURL string `json:"socketUrl"`
+}
+
+func newRoomView(r *http.Request, s rooms.State) roomView {
+	base := publicBaseURL(r)
+	socket := "ws" + strings.TrimPrefix(base, "http")
+	return roomView{
+		State:     s,
+		URL:       base + "/gomedia/api/rooms/" + s.ID,
+		SocketURL: socket + "/gomedia/api/rooms/" + s.ID + "/ws",
+	}
+}
+
+// Rooms lists the listening rooms the user hosts (GET) or opens one (POST)
+// from {"name": "Friday", "queue": ["{trackId}", ...]}. The room's ID,
+// shared as its link, is what others need to join.
+func Rooms(w http.ResponseWriter, r *http.Request) {
+	user := middleware.User(r)
+	switch r.Method {
+	case http.MethodGet:
+		views := []roomView{}
+		for _, s := range rooms.Hosted(user) {
+			views = append(views, newRoomView(r, s))
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"rooms": views})
+
+	case http.MethodPost:
+		var input struct {
+			Name  string   `json:"name"`
+			Queue []string `json:"queue"`
+		}
+		if err := decodeJSONBody(w, r, &input); err != nil {
+			http.Error(w, "Invalid room: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		s, err := rooms.Create(user, input.Name, input.Queue)
+		if err != nil {
+			writeRoomError(w, err)
+			return
+		}
+		w.Header().Set("Location", "/gomedia/api/rooms/"+s.ID)
+		writeJSON(w, http.StatusCreated, newRoomView(r, s))
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// RoomResource serves a listening room at /gomedia/api/rooms/{id}: GET for
+// its state, DELETE for the host to close it, and /ws to join it over
+// WebSocket and play along
+func RoomResource(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/gomedia/api/rooms/"), "/")
+	user := middleware.User(r)
+
+	switch {
+	case action == "ws":
+		joinRoom(w, r, id, user)
+
+	case action != "":
+		http.Error(w, "Not found", http.StatusNotFound)
+
+	case r.Method == http.MethodGet:
+		s, err := rooms.Get(id)
+		if err != nil {
+			writeRoomError(w, err)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, newRoomView(r, s))
+
+	case r.Method == http.MethodDelete:
+		if err := rooms.Close(id, user); err != nil {
+			writeRoomError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// joinRoom upgrades to a WebSocket connected to a room
+func joinRoom(w http.ResponseWriter, r *http.Request, id, user string) {
+	// Answer unknown rooms before upgrading, so that the link can be checked
+	if _, err := rooms.Get(id); err != nil {
+		writeRoomError(w, err)
+		return
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Error upgrading room connection: %v", err)
+		return
+	}
+	client, err := rooms.Join(id, user)
+	if err != nil {
+		rejectSocket(conn, err)
+		return
+	}
+	defer client.Leave()
+
+	serveSocket(conn, client.Outbox(), client.Handle)
+}
+
+func writeRoomError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, rooms.ErrNotFound):
+		http.Error(w, "Room not found", http.StatusNotFound)
+	case errors.Is(err, rooms.ErrForbidden):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, rooms.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Error accessing room", http.StatusInternalServerError)
+		log.Printf("Error accessing room: %v", err)
+	}
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"MediaBackend/audio"
+	"MediaBackend/jobs"
+	minioClient "MediaBackend/minio"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// mp3Seeker maps playback times of an MP3 object to frame offsets
+type mp3Seeker struct {
+	objectInfo minio.ObjectInfo
+	object     *minio.Object
+	reader     *audio.BlockReaderAt
+	info       *audio.MP3Info
+	index      *audio.FrameIndex
+	// indexKey is the cache key of the frame index
+	indexKey string
+}
+
+// openMP3Seeker reads the stream layout of an MP3 object, along with its
+// frame index when one has already been cached
+func openMP3Seeker(ctx context.Context, filename string, objectInfo minio.ObjectInfo) (*mp3Seeker, error) {
+	object, err := minioClient.GetObject(ctx, minioClient.MusicBucket, filename)
+	if err != nil {
+		return nil, err
+	}
+	s := &mp3Seeker{
+		objectInfo: objectInfo,
+		object:     object,
+		reader:     audio.NewBlockReaderAt(object, objectInfo.Size),
+		indexKey:   frameIndexKey(objectInfo),
+	}
+	s.info, err = audio.ReadMP3Info(s.reader, objectInfo.Size)
+	if err != nil {
+		object.Close()
+		return nil, err
+	}
+
+	s.index = cachedFrameIndex(ctx, s.indexKey)
+	return s, nil
+}
+
+// Close releases the underlying object
+func (s *mp3Seeker) Close() error {
+	return s.object.Close()
+}
+
+// seek returns the offset of the frame playing at t seconds and the start
+// time of that frame. Without a cached frame index the seek table gives an
+// approximate answer while the index is built in the background, and the
+// start time is estimated from the table too; files without a seek table are
+// indexed straight away.
+func (s *mp3Seeker) seek(ctx context.Context, t float64) (off int64, start float64, approximate bool, err error) {
+	if s.index == nil {
+		if off, ok := s.info.SeekOffset(t); ok {
+			indexFrames(s.objectInfo, audio.FormatMP3)
+			off, err := s.info.SyncFrame(s.reader, off)
+			if err != nil {
+				return 0, 0, false, err
+			}
+			start, _ := s.info.OffsetTime(off)
+			return off, start, true, nil
+		}
+		index, err := buildFrameIndex(ctx, s.reader, s.objectInfo.Size, audio.FormatMP3, s.indexKey)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		s.index = index
+	}
+	off, start = s.index.Seek(t)
+	return off, start, false, nil
+}
+
+// frameIndexKey returns the cache key of an object's frame index
+func frameIndexKey(objectInfo minio.ObjectInfo) string {
+	return minioClient.CacheKey("frameindex", objectInfo.ETag, "frames.idx")
+}
+
+// cachedFrameIndex returns a cached frame index, or nil when there is none
+func cachedFrameIndex(ctx context.Context, key string) *audio.FrameIndex {
+	data, err := minioClient.GetCached(ctx, key)
+	if err != nil {
+		if !errors.Is(err, minioClient.ErrCacheMiss) {
+			log.Printf("Error reading cached frame index %s: %v", key, err)
+		}
+		return nil
+	}
+	index, err := audio.ParseFrameIndex(data)
+	if err != nil {
+		log.Printf("Discarding corrupt cached frame index %s: %v", key, err)
+		return nil
+	}
+	return index
+}
+
+// loadFrameIndex returns the frame index of an MP3 or AAC object, building
+// and caching it on first use
+func loadFrameIndex(ctx context.Context, objectInfo minio.ObjectInfo, format audio.Format) (*audio.FrameIndex, error) {
+	key := frameIndexKey(objectInfo)
+	if index := cachedFrameIndex(ctx, key); index != nil {
+		return index, nil
+	}
+
+	object, err := minioClient.GetObject(ctx, minioClient.MusicBucket, objectInfo.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+	return buildFrameIndex(ctx, audio.NewBlockReaderAt(object, objectInfo.Size), objectInfo.Size, format, key)
+}
+
+// indexFrames queues a job that builds and caches the frame index of an object
+func indexFrames(objectInfo minio.ObjectInfo, format audio.Format) *jobs.Job {
+	return jobs.Default.Submit("frame-index", frameIndexKey(objectInfo), func(ctx context.Context, job *jobs.Job) error {
+		_, err := loadFrameIndex(ctx, objectInfo, format)
+		return err
+	})
+}
+
+// frameIndexTimeout bounds how long a request waits for a frame index
+const frameIndexTimeout = 2 * time.Minute
+
+// errFrameIndexPending is returned when a frame index is still being built
+// after frameIndexTimeout
+var errFrameIndexPending = errors.New("frame index is still being built")
+
+// awaitFrameIndex returns the frame index of an MP3 or AAC object. When it
+// is not cached yet the request waits for the shared indexFrames job, so
+// concurrent requests for the same track scan its frames only once.
+func awaitFrameIndex(r *http.Request, objectInfo minio.ObjectInfo, format audio.Format) (*audio.FrameIndex, error) {
+	ctx := r.Context()
+	key := frameIndexKey(objectInfo)
+	if index := cachedFrameIndex(ctx, key); index != nil {
+		return index, nil
+	}
+
+	job := indexFrames(objectInfo, format)
+	if !awaitJob(r, job, frameIndexTimeout) {
+		return nil, errFrameIndexPending
+	}
+	if err := job.Err(); err != nil {
+		return nil, err
+	}
+	if index := cachedFrameIndex(ctx, key); index != nil {
+		return index, nil
+	}
+	// The job could not cache the index
+	return loadFrameIndex(ctx, objectInfo, format)
+}
+
+// buildFrameIndex scans every frame header and caches the resulting index
+func buildFrameIndex(ctx context.Context, r io.ReaderAt, size int64, format audio.Format, key string) (*audio.FrameIndex, error) {
+	index, err := audio.BuildFrameIndex(r, size, format)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := index.MarshalBinary()
+	if err == nil {
+		err = minioClient.PutCached(ctx, key, data, "application/octet-stream")
+	}
+	if err != nil {
+		log.Printf("Error caching frame index %s: %v", key, err)
+	}
+	return index, nil
+}
+
+// parseTimeRange parses the time to seek to from the "t" query parameter or
+// a "Range: seconds=start-end" header. end is negative when open-ended.
+func parseTimeRange(r *http.Request) (start, end float64, err error) {
+	if t := r.URL.Query().Get("t"); t != "" {
+		start, err = strconv.ParseFloat(t, 64)
+		if err != nil || start < 0 {
+			return 0, 0, fmt.Errorf("invalid time %q", t)
+		}
+		return start, -1, nil
+	}
+
+	spec, ok := strings.CutPrefix(r.Header.Get("Range"), "seconds=")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid range header")
+	}
+	from, to, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid range format")
+	}
+	start, err = strconv.ParseFloat(from, 64)
+	if err != nil || start < 0 {
+		return 0, 0, fmt.Errorf("invalid range start %q", from)
+	}
+	end = -1
+	if to != "" {
+		end, err = strconv.ParseFloat(to, 64)
+		if err != nil || end <= start {
+			return 0, 0, fmt.Errorf("invalid range end %q", to)
+		}
+	}
+	return start, end, nil
+}
+
+// isTimeSeek reports whether a music request asks for a time offset
+func isTimeSeek(r *http.Request) bool {
+	return r.URL.Query().Get("t") != "" || strings.HasPrefix(r.Header.Get("Range"), "seconds=")
+}
+
+// serveMusicTimeRange streams an MP3 from the frame playing at the
+// requested time, as a byte range of the original file
+func serveMusicTimeRange(w http.ResponseWriter, r *http.Request, filename string, objectInfo minio.ObjectInfo) {
+	ctx := r.Context()
+
+	if audio.FormatFromName(filename) != audio.FormatMP3 {
+		http.Error(w, "Time-based seeking is only supported for MP3", http.StatusBadRequest)
+		return
+	}
+	from, to, err := parseTimeRange(r)
+	if err != nil {
+		http.Error(w, "Invalid time range", http.StatusBadRequest)
+		return
+	}
+
+	seeker, err := openMP3Seeker(ctx, filename, objectInfo)
+	if err != nil {
+		http.Error(w, "Error reading MP3 stream", http.StatusUnprocessableEntity)
+		log.Printf("Error opening MP3 seeker for %s: %v", filename, err)
+		return
+	}
+	defer seeker.Close()
+
+	start, startTime, approximate, err := seeker.seek(ctx, from)
+	if err != nil {
+		http.Error(w, "Error reading MP3 stream", http.StatusInternalServerError)
+		log.Printf("Error seeking %s to %.3fs: %v", filename, from, err)
+		return
+	}
+	end := objectInfo.Size - 1
+	if to >= 0 {
+		next, _, _, err := seeker.seek(ctx, to)
+		if err != nil {
+			http.Error(w, "Error reading MP3 stream", http.StatusInternalServerError)
+			log.Printf("Error seeking %s to %.3fs: %v", filename, to, err)
+			return
+		}
+		end = next - 1
+	}
+	if start > end || start >= seeker.info.AudioEnd {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", objectInfo.Size))
+		http.Error(w, "Time is past the end of the track", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	w.Header().Set("X-Seek-Time", strconv.FormatFloat(startTime, 'f', 3, 64))
+	if approximate {
+		w.Header().Set("X-Seek-Approximate", "true")
+	}
+	serveMusicRange(w, ctx, filename, objectInfo.Size, start, end)
+}
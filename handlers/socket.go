@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// pongWait is how long a silent connection is kept
+	pongWait = 60 * time.Second
+	// pingInterval keeps connections alive through proxies
+	pingInterval = 25 * time.Second
+	// writeWait bounds writes to slow connections
+	writeWait = 10 * time.Second
+	// maxSocketMessage limits messages from clients
+	maxSocketMessage = 64 * 1024
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	CheckOrigin:     checkSocketOrigin,
+}
+
+// checkSocketOrigin accepts connections from the server's own origin (or
+// PUBLIC_URL) and from clients that are not browsers, so that other sites
+// cannot drive players with the credentials a browser remembers
+func checkSocketOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	public, err := url.Parse(publicURL)
+	return err == nil && public.Host != "" && strings.EqualFold(u.Host, public.Host)
+}
+
+// serveSocket relays an outbox to a WebSocket connection and passes the
+// JSON messages read from it to handle, until the outbox is closed or the
+// connection ends
+func serveSocket[T any](conn *websocket.Conn, outbox <-chan T, handle func(T)) {
+	go writeSocket(conn, outbox)
+
+	conn.SetReadLimit(maxSocketMessage)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	for {
+		var m T
+		if err := conn.ReadJSON(&m); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) && !errors.Is(err, websocket.ErrReadLimit) {
+				log.Printf("WebSocket from %s closed: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		handle(m)
+	}
+}
+
+// writeSocket writes an outbox to its connection and pings it, until the
+// outbox is closed or a write fails
+func writeSocket[T any](conn *websocket.Conn, outbox <-chan T) {
+	ticker := time.NewTicker(pingInterval)
+	defer func() {
+		ticker.Stop()
+		conn.Close()
+	}()
+	for {
+		select {
+		case m, ok := <-outbox:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+			if err := conn.WriteJSON(m); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// rejectSocket closes an upgraded connection with the reason it was refused
+func rejectSocket(conn *websocket.Conn, err error) {
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error()), time.Now().Add(writeWait))
+	conn.Close()
+}
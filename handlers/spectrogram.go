@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image/png"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"MediaBackend/audio"
+	"MediaBackend/jobs"
+	minioClient "MediaBackend/minio"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// spectrogramMinDB is the floor of the rendered dynamic range, relative to full scale
+const spectrogramMinDB = -120
+
+// ServeMusicSpectrogram returns a frequency/time PNG of a track. Rendering
+// happens in a background job; until it has been cached the response is
+// 202 Accepted with the job status.
+//
+// Query parameters:
+//   - width: image width in pixels (default 1024)
+//   - height: image height in pixels (default 512)
+//   - colormap: gray, hot, viridis, magma or inferno (default magma)
+//   - fft: FFT size, a power of two between 256 and 16384 (default 4096)
+//   - wait: seconds to wait for the job before answering 202 (default 0)
+func ServeMusicSpectrogram(w http.ResponseWriter, r *http.Request, filename string) {
+	ctx := r.Context()
+
+	format := audio.FormatFromName(filename)
+	if !audio.CanDecode(format) {
+		http.Error(w, "Spectrograms are only available for MP3, WAV and FLAC", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	width := queryInt(r, "width", 1024, 64, 4096)
+	height := queryInt(r, "height", 512, 64, 1024)
+	fftSize := queryInt(r, "fft", 4096, 256, 16384)
+	if fftSize&(fftSize-1) != 0 {
+		http.Error(w, "fft must be a power of two", http.StatusBadRequest)
+		return
+	}
+	colormap := r.URL.Query().Get("colormap")
+	if colormap == "" {
+		colormap = "magma"
+	}
+	cmap, ok := audio.ColorMaps[colormap]
+	if !ok {
+		http.Error(w, "Unknown colormap", http.StatusBadRequest)
+		return
+	}
+
+	objectInfo, err := minioClient.StatObject(ctx, minioClient.MusicBucket, filename)
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		log.Printf("Error getting object info for %s: %v", filename, err)
+		return
+	}
+
+	cacheKey := minioClient.CacheKey("spectrograms", objectInfo.ETag,
+		fmt.Sprintf("%dx%d-%s-%d.png", width, height, colormap, fftSize))
+
+	data, err := minioClient.GetCached(ctx, cacheKey)
+	if errors.Is(err, minioClient.ErrCacheMiss) {
+		job := jobs.Default.Submit("spectrogram", cacheKey, func(ctx context.Context, job *jobs.Job) error {
+			return renderSpectrogram(ctx, job, objectInfo, format, cacheKey, width, height, fftSize, cmap)
+		})
+		if !waitForJob(r, job, time.Minute) {
+			writeJobAccepted(w, job)
+			return
+		}
+		if err := job.Err(); err != nil {
+			http.Error(w, "Error rendering spectrogram", http.StatusUnprocessableEntity)
+			return
+		}
+		data, err = minioClient.GetCached(ctx, cacheKey)
+	}
+	if err != nil {
+		http.Error(w, "Error reading spectrogram", http.StatusInternalServerError)
+		log.Printf("Error reading cached spectrogram %s: %v", cacheKey, err)
+		return
+	}
+
+	etag := fmt.Sprintf(`"%s-sg%dx%d-%s-%d"`, strings.Trim(objectInfo.ETag, `"`), width, height, colormap, fftSize)
+	if match := r.Header.Get("If-None-Match"); match != "" && match == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// renderSpectrogram decodes a track, renders its spectrogram and stores the PNG in the cache
+func renderSpectrogram(ctx context.Context, job *jobs.Job, objectInfo minio.ObjectInfo, format audio.Format,
+	cacheKey string, width, height, fftSize int, cmap audio.ColorMap) error {
+	object, err := minioClient.GetObject(ctx, minioClient.MusicBucket, objectInfo.Key)
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+
+	dec, err := audio.NewDecoder(&progressReader{r: object, job: job, size: objectInfo.Size}, format)
+	if err != nil {
+		return err
+	}
+	// Keep up to two analysis columns per pixel so merging stays accurate
+	spec, err := audio.ComputeSpectrogram(dec, fftSize, height, 2*width)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, spec.Render(width, cmap, spectrogramMinDB)); err != nil {
+		return err
+	}
+	if err := minioClient.PutCached(ctx, cacheKey, buf.Bytes(), "image/png"); err != nil {
+		return err
+	}
+	log.Printf("Rendered spectrogram: %s (%dx%d)", objectInfo.Key, width, height)
+	return nil
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"MediaBackend/stations"
+)
+
+// stationView is a station with the URL players tune in at
+type stationView struct {
+	stations.Info
+	URL string `json:"url"`
+}
+
+// ListStations returns the configured radio stations with what they are
+// playing and how many are listening
+func ListStations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	base := publicBaseURL(r)
+	views := []stationView{}
+	for _, info := range stations.List() {
+		views = append(views, stationView{Info: info, URL: base + "/gomedia/radio/" + info.Name})
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]any{"stations": views})
+}
+
+// ServeStation streams a radio station at /gomedia/radio/{station} like an
+// Icecast mount point, until the listener disconnects. Clients sending
+// Icy-MetaData: 1 get the current track as StreamTitle every
+// stations.MetaInt bytes.
+func ServeStation(w http.ResponseWriter, r *http.Request) {
+	station, err := stations.Get(strings.TrimPrefix(r.URL.Path, "/gomedia/radio/"))
+	if err != nil {
+		http.Error(w, "Station not found", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	info := station.Info()
+	metadata := r.Header.Get("Icy-MetaData") == "1"
+	header := w.Header()
+	header.Set("Content-Type", "audio/mpeg")
+	header.Set("Cache-Control", "no-cache, no-store")
+	header.Set("icy-name", station.Name)
+	header.Set("icy-pub", "0")
+	if info.Bitrate > 0 {
+		header.Set("icy-br", strconv.Itoa(info.Bitrate))
+	}
+	if metadata {
+		header.Set("icy-metaint", strconv.Itoa(stations.MetaInt))
+	}
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	listener := station.Listen()
+	defer station.Leave(listener)
+	w.WriteHeader(http.StatusOK)
+
+	var icy *stations.ICYWriter
+	if metadata {
+		icy = stations.NewICYWriter(w)
+	}
+	rc := http.NewResponseController(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case chunk, ok := <-listener.Chunks():
+			if !ok {
+				// Too slow to keep up with the station
+				return
+			}
+			if icy != nil {
+				err = icy.Write(chunk)
+			} else {
+				_, err = w.Write(chunk.Data)
+			}
+			if err != nil {
+				return
+			}
+			// Flush once the backlog is written, so a new listener's burst
+			// goes out in large writes
+			if len(listener.Chunks()) == 0 {
+				if err := rc.Flush(); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"MediaBackend/history"
+	"MediaBackend/middleware"
+)
+
+// Stats summarizes the user's listening over a period: top artists, albums,
+// tracks and genres, listening time per day and plays by hour. Query
+// parameters: from and to (date or RFC 3339, to exclusive, both truncated
+// to UTC days; the last 30 days by default) and limit (default 10, at most
+// 100) for the top lists.
+func Stats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	from, err := parseTimeParam(query.Get("from"))
+	if err != nil {
+		http.Error(w, "Invalid from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseTimeParam(query.Get("to"))
+	if err != nil {
+		http.Error(w, "Invalid to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if to.IsZero() {
+		to = time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+	}
+	if from.IsZero() {
+		from = to.AddDate(0, 0, -30)
+	}
+	if to.Sub(from) > 10*366*24*time.Hour {
+		http.Error(w, "The period must not exceed 10 years", http.StatusBadRequest)
+		return
+	}
+
+	summary, err := history.Summarize(r.Context(), middleware.User(r), from, to, queryInt(r, "limit", 10, 1, 100))
+	writeStats(w, summary, err)
+}
+
+// StatsWrapped serves the year in review at /gomedia/api/stats/wrapped/{year},
+// the current year when omitted
+func StatsWrapped(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/gomedia/api/stats/wrapped"), "/")
+	year := time.Now().UTC().Year()
+	if rest != "" {
+		y, err := strconv.Atoi(rest)
+		if err != nil || y < 1970 || y > 9999 {
+			http.Error(w, "Invalid year", http.StatusBadRequest)
+			return
+		}
+		year = y
+	}
+
+	wrapped, err := history.YearInReview(r.Context(), middleware.User(r), year, queryInt(r, "limit", 10, 1, 100))
+	writeStats(w, wrapped, err)
+}
+
+func writeStats(w http.ResponseWriter, report any, err error) {
+	switch {
+	case errors.Is(err, history.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		http.Error(w, "Error computing statistics", http.StatusInternalServerError)
+		log.Printf("Error computing statistics: %v", err)
+	default:
+		writeJSON(w, http.StatusOK, report)
+	}
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"MediaBackend/history"
+	"MediaBackend/jobs"
+	"MediaBackend/library"
+	"MediaBackend/middleware"
+	"MediaBackend/subsonic"
+)
+
+// subsonicHandler serves a Subsonic method. It returns the response to
+// write, or nil when it wrote binary content itself. Errors that are not a
+// *subsonic.Error are logged and reported as generic failures.
+type subsonicHandler func(w http.ResponseWriter, r *http.Request) (*subsonic.Response, error)
+
+// subsonicMethods maps method names to their handlers
+var subsonicMethods = map[string]subsonicHandler{
+	"ping":                      subsonicPing,
+	"getLicense":                subsonicLicense,
+	"getOpenSubsonicExtensions": subsonicExtensions,
+	"getUser":                   subsonicGetUser,
+	"getMusicFolders":           subsonicMusicFolders,
+	"getScanStatus":             subsonicScanStatus,
+	"startScan":                 subsonicStartScan,
+	"getNowPlaying":             subsonicNowPlaying,
+
+	"getIndexes":        subsonicIndexes,
+	"getMusicDirectory": subsonicMusicDirectory,
+	"getArtists":        subsonicArtists,
+	"getArtist":         subsonicArtist,
+	"getAlbum":          subsonicAlbum,
+	"getSong":           subsonicSong,
+	"getGenres":         subsonicGenres,
+	"getAlbumList":      subsonicAlbumList(false),
+	"getAlbumList2":     subsonicAlbumList(true),
+	"getRandomSongs":    subsonicRandomSongs,
+	"getSongsByGenre":   subsonicSongsByGenre,
+	"search2":           subsonicSearch(false),
+	"search3":           subsonicSearch(true),
+	"getStarred":        subsonicStarred(false),
+	"getStarred2":       subsonicStarred(true),
+
+	"stream":      subsonicStream,
+	"download":    subsonicDownload,
+	"getCoverArt": subsonicCoverArt,
+	"scrobble":    subsonicScrobble,
+
+	"getPlaylists":   subsonicPlaylists,
+	"getPlaylist":    subsonicPlaylist,
+	"createPlaylist": subsonicCreatePlaylist,
+	"updatePlaylist": subsonicUpdatePlaylist,
+	"deletePlaylist": subsonicDeletePlaylist,
+}
+
+// Subsonic serves the Subsonic API at /rest/{method}, with or without the
+// .view suffix, for GET and form POST requests. Clients authenticate with
+// u and either t and s (the MD5 of password and salt, and the salt) or p,
+// the password in clear or hex encoded as "enc:...". Responses are XML
+// unless f=json or f=jsonp.
+func Subsonic(w http.ResponseWriter, r *http.Request) {
+	method := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/rest/"), ".view")
+	if err := r.ParseForm(); err != nil {
+		subsonic.Write(w, r, subsonic.Failed(subsonic.Errorf(subsonic.CodeGeneric, "Invalid parameters")))
+		return
+	}
+	handler, ok := subsonicMethods[method]
+	if !ok {
+		subsonic.Write(w, r, subsonic.Failed(subsonic.Errorf(subsonic.CodeNotFound, "Unknown method %q", method)))
+		return
+	}
+	// OpenSubsonic requires the extension list to be public
+	if method != "getOpenSubsonicExtensions" {
+		user, err := subsonicUser(r)
+		if err != nil {
+			subsonic.Write(w, r, subsonic.Failed(err))
+			return
+		}
+		r = middleware.WithUser(r, user)
+	}
+
+	resp, err := handler(w, r)
+	if err != nil {
+		var failure *subsonic.Error
+		if !errors.As(err, &failure) {
+			log.Printf("Error serving Subsonic %s: %v", method, err)
+			failure = subsonic.Errorf(subsonic.CodeGeneric, "Internal error")
+		}
+		resp = subsonic.Failed(failure)
+	}
+	if resp != nil {
+		subsonic.Write(w, r, resp)
+	}
+}
+
+// subsonicUser authenticates a Subsonic request. Without AUTH_USERS every
+// request acts as the default user, whatever it sends.
+func subsonicUser(r *http.Request) (string, *subsonic.Error) {
+	if !middleware.AuthEnabled() {
+		return middleware.DefaultUser, nil
+	}
+	name := r.FormValue("u")
+	if name == "" {
+		if basicName, password, ok := r.BasicAuth(); ok && middleware.CheckPassword(basicName, password) {
+			return basicName, nil
+		}
+		return "", subsonic.MissingParameter("u")
+	}
+	var ok bool
+	switch token, password := r.FormValue("t"), r.FormValue("p"); {
+	case token != "":
+		ok = middleware.CheckToken(name, token, r.FormValue("s"))
+	case password != "":
+		if encoded, found := strings.CutPrefix(password, "enc:"); found {
+			decoded, err := hex.DecodeString(encoded)
+			if err != nil {
+				return "", subsonic.Errorf(subsonic.CodeWrongCredentials, "Wrong username or password")
+			}
+			password = string(decoded)
+		}
+		ok = middleware.CheckPassword(name, password)
+	default:
+		return "", subsonic.MissingParameter("t")
+	}
+	if !ok {
+		return "", subsonic.Errorf(subsonic.CodeWrongCredentials, "Wrong username or password")
+	}
+	return name, nil
+}
+
+// formInt returns an integer parameter, defaultVal when absent and an
+// error when malformed
+func formInt(r *http.Request, name string, defaultVal int) (int, error) {
+	value := r.FormValue(name)
+	if value == "" {
+		return defaultVal, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, subsonic.Errorf(subsonic.CodeGeneric, "Invalid %s: %q", name, value)
+	}
+	return n, nil
+}
+
+// requiredForm returns a parameter that must be present
+func requiredForm(r *http.Request, name string) (string, error) {
+	value := r.FormValue(name)
+	if value == "" {
+		return "", subsonic.MissingParameter(name)
+	}
+	return value, nil
+}
+
+func subsonicPing(w http.ResponseWriter, r *http.Request) (*subsonic.Response, error) {
+	return subsonic.NewResponse(), nil
+}
+
+func subsonicLicense(w http.ResponseWriter, r *http.Request) (*subsonic.Response, error) {
+	resp := subsonic.NewResponse()
+	resp.License = &subsonic.License{Valid: true}
+	return resp, nil
+}
+
+func subsonicExtensions(w http.ResponseWriter, r *http.Request) (*subsonic.Response, error) {
+	resp := subsonic.NewResponse()
+	resp.OpenSubsonicExtensions = &[]subsonic.Extension{
+		{Name: "formPost", Versions: []int{1}},
+	}
+	return resp, nil
+}
+
+// subsonicGetUser describes the signed-in user; other users are private
+func subsonicGetUser(w http.ResponseWriter, r *http.Request) (*subsonic.Response, error) {
+	user := middleware.User(r)
+	name, err := requiredForm(r, "username")
+	if err != nil {
+		return nil, err
+	}
+	if name != user {
+		return nil, subsonic.Errorf(subsonic.CodeNotAuthorized, "Only your own user can be read")
+	}
+	resp := subsonic.NewResponse()
+	resp.User = &subsonic.User{
+		Username:          user,
+		ScrobblingEnabled: true,
+		DownloadRole:      true,
+		PlaylistRole:      true,
+		CoverArtRole:      true,
+		StreamRole:        true,
+		Folder:            []int{subsonic.RootFolderID},
+	}
+	return resp, nil
+}
+
+func subsonicMusicFolders(w http.ResponseWriter, r *http.Request) (*subsonic.Response, error) {
+	resp := subsonic.NewResponse()
+	resp.MusicFolders = &subsonic.MusicFolders{
+		Folders: []subsonic.MusicFolder{{ID: subsonic.RootFolderID, Name: "Music"}},
+	}
+	return resp, nil
+}
+
+// scanStatus reports whether a library scan is queued or running
+func scanStatus() *subsonic.ScanStatus {
+	status := &subsonic.ScanStatus{Count: len(library.Tracks())}
+	for _, job := range jobs.List() {
+		if job.Kind == "library-scan" && (job.Status == jobs.StatusQueued || job.Status == jobs.StatusRunning) {
+			status.Scanning = true
+		}
+	}
+	return status
+}
+
+func subsonicScanStatus(w http.ResponseWriter, r *http.Request) (*subsonic.Response, error) {
+	resp := subsonic.NewResponse()
+	resp.ScanStatus = scanStatus()
+	return resp, nil
+}
+
+func subsonicStartScan(w http.ResponseWriter, r *http.Request) (*subsonic.Response, error) {
+	library.ScanJob()
+	resp := subsonic.NewResponse()
+	resp.ScanStatus = scanStatus()
+	return resp, nil
+}
+
+// subsonicNowPlaying lists what every user is listening to
+func subsonicNowPlaying(w http.ResponseWriter, r *http.Request) (*subsonic.Response, error) {
+	view := newSubsonicView(middleware.User(r))
+	entries := []subsonic.NowPlayingEntry{}
+	for _, user := range middleware.UserNames() {
+		np, ok := history.CurrentlyPlaying(user)
+		if !ok {
+			continue
+		}
+		entries = append(entries, subsonic.NowPlayingEntry{
+			Child:      view.song(np.Track),
+			Username:   user,
+			MinutesAgo: int(time.Since(np.Started).Minutes()),
+			PlayerName: np.Client,
+		})
+	}
+	resp := subsonic.NewResponse()
+	resp.NowPlaying = &subsonic.NowPlaying{Entry: entries}
+	return resp, nil
+}
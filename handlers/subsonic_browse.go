@@ -0,0 +1,684 @@
+package handlers
+
+import (
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"MediaBackend/history"
+	"MediaBackend/library"
+	"MediaBackend/middleware"
+	"MediaBackend/subsonic"
+)
+
+// maxSubsonicList bounds the size of album and song lists
+const maxSubsonicList = 500
+
+// subsonicView presents library tracks as Subsonic entities, with the play
+// statistics of the requesting user
+type subsonicView struct {
+	catalog *subsonic.Catalog
+	stats   map[string]history.TrackStats
+}
+
+func newSubsonicView(user string) *subsonicView {
+	return &subsonicView{
+		catalog: subsonic.NewCatalog(library.Tracks()),
+		stats:   history.AllStats(user),
+	}
+}
+
+// subsonicTime formats times as the API expects, empty when unset
+func subsonicTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// subsonicParent returns the directory ID of the folder holding a path
+func subsonicParent(p string) string {
+	if dir := subsonic.ParentDir(p); dir != "" {
+		return subsonic.DirID(dir)
+	}
+	return "1"
+}
+
+// folderPath is where a track is listed when browsing folders. Cue tracks,
+// whose paths continue below their album file, are listed beside it.
+func folderPath(t library.Track) string {
+	if t.Cue != nil {
+		return path.Join(subsonic.ParentDir(subsonic.ParentDir(t.Path)), path.Base(t.Path))
+	}
+	return t.Path
+}
+
+// song converts a track
+func (v *subsonicView) song(t library.Track) subsonic.Child {
+	title := t.Title
+	if title == "" {
+		title = strings.TrimSuffix(path.Base(t.Path), path.Ext(t.Path))
+	}
+	kind := t.Kind
+	if kind == "" {
+		kind = "music"
+	}
+	song := subsonic.Child{
+		ID:           t.ID,
+		Parent:       subsonicParent(folderPath(t)),
+		Title:        title,
+		Album:        t.Album,
+		Artist:       t.Artist,
+		Track:        t.TrackNumber,
+		Year:         t.Year,
+		Genre:        t.Genre,
+		CoverArt:     t.ID,
+		Size:         t.Size,
+		ContentType:  getContentType(t.Path),
+		Suffix:       strings.TrimPrefix(strings.ToLower(path.Ext(t.Path)), "."),
+		Duration:     int(math.Round(t.Duration)),
+		BitRate:      t.Bitrate / 1000,
+		Path:         t.Path,
+		DiscNumber:   t.DiscNumber,
+		Created:      subsonicTime(t.Added),
+		Type:         kind,
+		MediaType:    "song",
+		SamplingRate: t.SampleRate,
+		ChannelCount: t.Channels,
+	}
+	if album, ok := v.catalog.AlbumOf(t.ID); ok {
+		song.AlbumID = album.ID
+		song.ArtistID = album.Artist.ID
+	}
+	if stats, ok := v.stats[t.ID]; ok {
+		song.PlayCount = stats.PlayCount
+		song.Played = subsonicTime(stats.LastPlayed)
+	}
+	if t.Tempo != nil {
+		song.BPM = int(math.Round(*t.Tempo))
+	}
+	if l := t.Loudness; l != nil {
+		song.ReplayGain = &subsonic.ReplayGain{TrackGain: l.TrackGain, TrackPeak: l.TrackPeak}
+		if l.AlbumGain != nil && l.AlbumPeak != nil {
+			song.ReplayGain.AlbumGain = *l.AlbumGain
+			song.ReplayGain.AlbumPeak = *l.AlbumPeak
+		}
+	}
+	return song
+}
+
+func (v *subsonicView) songs(tracks []library.Track) []subsonic.Child {
+	list := make([]subsonic.Child, len(tracks))
+	for i, t := range tracks {
+		list[i] = v.song(t)
+	}
+	return list
+}
+
+// albumPlays sums the plays of an album's tracks and finds the last
+func (v *subsonicView) albumPlays(a *subsonic.Album) (int, time.Time) {
+	var count int
+	var last time.Time
+	for _, t := range a.Tracks {
+		stats := v.stats[t.ID]
+		count += stats.PlayCount
+		if stats.LastPlayed.After(last) {
+			last = stats.LastPlayed
+		}
+	}
+	return count, last
+}
+
+// album converts an album for the ID3 endpoints
+func (v *subsonicView) album(a *subsonic.Album) subsonic.AlbumID3 {
+	plays, played := v.albumPlays(a)
+	return subsonic.AlbumID3{
+		ID:        a.ID,
+		Name:      a.Name,
+		Artist:    a.Artist.Name,
+		ArtistID:  a.Artist.ID,
+		CoverArt:  a.ID,
+		SongCount: len(a.Tracks),
+		Duration:  int(math.Round(a.Duration)),
+		PlayCount: plays,
+		Created:   subsonicTime(a.Added),
+		Played:    subsonicTime(played),
+		Year:      a.Year,
+		Genre:     a.Genre,
+	}
+}
+
+func (v *subsonicView) albums(list []*subsonic.Album) []subsonic.AlbumID3 {
+	albums := make([]subsonic.AlbumID3, len(list))
+	for i, a := range list {
+		albums[i] = v.album(a)
+	}
+	return albums
+}
+
+// albumDir converts an album for the folder endpoints, as a directory that
+// getMusicDirectory lists
+func (v *subsonicView) albumDir(a *subsonic.Album) subsonic.Child {
+	plays, played := v.albumPlays(a)
+	return subsonic.Child{
+		ID:        a.ID,
+		Parent:    a.Artist.ID,
+		IsDir:     true,
+		Title:     a.Name,
+		Album:     a.Name,
+		Artist:    a.Artist.Name,
+		Year:      a.Year,
+		Genre:     a.Genre,
+		CoverArt:  a.ID,
+		Duration:  int(math.Round(a.Duration)),
+		PlayCount: plays,
+		Played:    subsonicTime(played),
+		Created:   subsonicTime(a.Added),
+		AlbumID:   a.ID,
+		ArtistID:  a.Artist.ID,
+		MediaType: "album",
+	}
+}
+
+func (v *subsonicView) albumDirs(list []*subsonic.Album) []subsonic.Child {
+	dirs := make([]subsonic.Child, len(list))
+	for i, a := range list {
+		dirs[i] = v.albumDir(a)
+	}
+	return dirs
+}
+
+// artist converts an album artist
+func (v *subsonicView) artist(a *subsonic.Artist) subsonic.ArtistID3 {
+	artist := subsonic.ArtistID3{ID: a.ID, Name: a.Name, AlbumCount: len(a.Albums)}
+	if len(a.Albums) > 0 {
+		artist.CoverArt = a.ID
+	}
+	return artist
+}
+
+// subsonicIndexes lists the top-level folders of the music bucket by
+// initial, and the tracks at its root
+func subsonicIndexes(w http.ResponseWriter, r *http.Request) (*subsonic.Response, error) {
+	since, err := formInt(r, "ifModifiedSince", 0)
+	if err != nil {
+		return nil, err
+	}
+	tracks := library.Tracks()
+	var modified time.Time
+	for _, t := range tracks {
+		if t.Modified.After(modified) {
+			modified = t.Modified
+		}
+	}
+	indexes := &subsonic.Indexes{LastModified: modified.UnixMilli(), IgnoredArticles: subsonic.IgnoredArticles}
+	resp := subsonic.NewResponse()
+	resp.Indexes = indexes
+	if since > 0 && int64(since) >= indexes.LastModified {
+		return resp, nil
+	}
+
+	view := newSubsonicView(middleware.User(r))
+	seen := map[string]bool{}
+	byInitial := map[string][]subsonic.IndexArtist{}
+	for _, t := range tracks {
+		dir, _, nested := strings.Cut(folderPath(t), "/")
+		if !nested {
+			indexes.Child = append(indexes.Child, view.song(t))
+			continue
+		}
+		if !seen[dir] {
+			seen[dir] = true
+			initial := subsonic.IndexName(dir)
+			byInitial[initial] = append(byInitial[initial], subsonic.IndexArtist{ID: subsonic.DirID(dir), Name: dir})
+		}
+	}
+	indexes.Index = groupIndex(byInitial, func(a subsonic.IndexArtist) string { return a.Name },
+		func(name string, list []subsonic.IndexArtist) subsonic.Index {
+			return subsonic.Index{Name: name, Artist: list}
+		})
+	return resp, nil
+}
+
+// groupIndex sorts the entries under each initial and the initials, "#"
+// last
+func groupIndex[E, I any](byInitial map[string][]E, name func(E) string, index func(string, []E) I) []I {
+	initials := make([]string, 0, len(byInitial))
+	for initial := range byInitial {
+		initials = append(initials, initial)
+	}
+	sort.Slice(initials, func(i, j int) bool {
+		if (initials[i] == "#") != (initials[j] == "#") {
+			return initials[j] == "#"
+		}
+		return initials[i] < initials[j]
+	})
+	list := make([]I, 0, len(initials))
+	for _, initial := range initials {
+		entries := byInitial[initial]
+		sort.SliceStable(entries, func(i, j int) bool {
+			return subsonic.SortName(name(entries[i])) < subsonic.SortName(name(entries[j]))
+		})
+		list = append(list, index(initial, entries))
+	}
+	return list
+}
+
+// subsonicMusicDirectory lists a folder of the music bucket. Album and
+// artist IDs work too, so that folder based clients can follow the albums
+// of getAlbumList and search2.
+func subsonicMusicDirectory(w http.ResponseWriter, r *http.Request) (*subsonic.Response, error) {
+	id, err := requiredForm(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	view := newSubsonicView(middleware.User(r))
+	resp := subsonic.NewResponse()
+
+	if album, ok := view.catalog.Album(id); ok {
+		resp.Directory = &subsonic.Directory{ID: id, Parent: album.Artist.ID, Name: album.Name, Child: view.songs(album.Tracks)}
+		return resp, nil
+	}
+	if artist, ok := view.catalog.Artist(id); ok {
+		resp.Directory = &subsonic.Directory{ID: id, Parent: "1", Name: artist.Name, Child: view.albumDirs(artist.Albums)}
+		return resp, nil
+	}
+	dir, ok := subsonic.ParseDirID(id)
+	if !ok {
+		return nil, subsonic.NotFound("Directory")
+	}
+
+	prefix := ""
+	directory := &subsonic.Directory{ID: id, Name: "Music"}
+	if dir != "" {
+		prefix = dir + "/"
+		directory.Name = path.Base(dir)
+		directory.Parent = subsonicParent(dir)
+	}
+	seen := map[string]bool{}
+	var dirs, files []subsonic.Child
+	for _, t := range library.Tracks() {
+		rest, ok := strings.CutPrefix(folderPath(t), prefix)
+		if !ok {
+			continue
+		}
+		name, _, nested := strings.Cut(rest, "/")
+		if !nested {
+			files = append(files, view.song(t))
+			continue
+		}
+		if !seen[name] {
+			seen[name] = true
+			dirs = append(dirs, subsonic.Child{
+				ID:     subsonic.DirID(prefix + name),
+				Parent: id,
+				IsDir:  true,
+				Title:  name,
+			})
+		}
+	}
+	if dir != "" && len(dirs)+len(files) == 0 {
+		return nil, subsonic.NotFound("Directory")
+	}
+	directory.Child = append(dirs, files...)
+	resp.Directory = directory
+	return resp, nil
+}
+
+// subsonicArtists lists the album artists by initial
+func subsonicArtists(w http.ResponseWriter, r *http.Request) (*subsonic.Response, error) {
+	view := newSubsonicView(middleware.User(r))
+	byInitial := map[string][]subsonic.ArtistID3{}
+	for _, a := range view.catalog.Artists {
+		initial := subsonic.IndexName(a.Name)
+		byInitial[initial] = append(byInitial[initial], view.artist(a))
+	}
+	resp := subsonic.NewResponse()
+	resp.Artists = &subsonic.Artists{
+		IgnoredArticles: subsonic.IgnoredArticles,
+		Index: groupIndex(byInitial, func(a subsonic.ArtistID3) string { return a.Name },
+			func(name string, list []subsonic.ArtistID3) subsonic.IndexID3 {
+				return subsonic.IndexID3{Name: name, Artist: list}
+			}),
+	}
+	return resp, nil
+}
+
+func subsonicArtist(w http.ResponseWriter, r *http.Request) (*subsonic.Response, error) {
+	id, err := requiredForm(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	view := newSubsonicView(middleware.User(r))
+	artist, ok := view.catalog.Artist(id)
+	if !ok {
+		return nil, subsonic.NotFound("Artist")
+	}
+	resp := subsonic.NewResponse()
+	resp.Artist = &subsonic.ArtistWithAlbums{ArtistID3: view.artist(artist), Album: view.albums(artist.Albums)}
+	return resp, nil
+}
+
+func subsonicAlbum(w http.ResponseWriter, r *http.Request) (*subsonic.Response, error) {
+	id, err := requiredForm(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	view := newSubsonicView(middleware.User(r))
+	album, ok := view.catalog.Album(id)
+	if !ok {
+		return nil, subsonic.NotFound("Album")
+	}
+	resp := subsonic.NewResponse()
+	resp.Album = &subsonic.AlbumWithSongs{AlbumID3: view.album(album), Song: view.songs(album.Tracks)}
+	return resp, nil
+}
+
+func subsonicSong(w http.ResponseWriter, r *http.Request) (*subsonic.Response, error) {
+	id, err := requiredForm(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	track, ok := library.Get(id)
+	if !ok {
+		return nil, subsonic.NotFound("Song")
+	}
+	song := newSubsonicView(middleware.User(r)).song(track)
+	resp := subsonic.NewResponse()
+	resp.Song = &song
+	return resp, nil
+}
+
+// subsonicGenres counts the tracks and albums of every genre
+func subsonicGenres(w http.ResponseWriter, r *http.Request) (*subsonic.Response, error) {
+	catalog := subsonic.NewCatalog(library.Tracks())
+	counts := map[string]*subsonic.Genre{}
+	for _, album := range catalog.Albums {
+		inAlbum := map[string]bool{}
+		for _, t := range album.Tracks {
+			if t.Genre == "" {
+				continue
+			}
+			g, ok := counts[t.Genre]
+			if !ok {
+				g = &subsonic.Genre{Value: t.Genre}
+				counts[t.Genre] = g
+			}
+			g.SongCount++
+			if !inAlbum[t.Genre] {
+				inAlbum[t.Genre] = true
+				g.AlbumCount++
+			}
+		}
+	}
+	genres := make([]subsonic.Genre, 0, len(counts))
+	for _, g := range counts {
+		genres = append(genres, *g)
+	}
+	sort.Slice(genres, func(i, j int) bool { return strings.ToLower(genres[i].Value) < strings.ToLower(genres[j].Value) })
+	resp := subsonic.NewResponse()
+	resp.Genres = &subsonic.Genres{Genre: genres}
+	return resp, nil
+}
+
+// page returns the items of list selected by the size and offset parameters
+func page[T any](r *http.Request, list []T, sizeParam, offsetParam string, defaultSize int) ([]T, error) {
+	size, err := formInt(r, sizeParam, defaultSize)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := formInt(r, offsetParam, 0)
+	if err != nil {
+		return nil, err
+	}
+	size = min(max(size, 0), maxSubsonicList)
+	offset = min(max(offset, 0), len(list))
+	return list[offset:min(offset+size, len(list))], nil
+}
+
+// subsonicAlbumList serves getAlbumList, with albums as folders, or
+// getAlbumList2 with albums by tags. Starred and highest rated lists are
+// empty since the server keeps neither stars nor ratings.
+func subsonicAlbumList(byTags bool) subsonicHandler {
+	return func(w http.ResponseWriter, r *http.Request) (*subsonic.Response, error) {
+		return albumList(r, byTags)
+	}
+}
+
+func albumList(r *http.Request, byTags bool) (*subsonic.Response, error) {
+	listType, err := requiredForm(r, "type")
+	if err != nil {
+		return nil, err
+	}
+	view := newSubsonicView(middleware.User(r))
+	albums := append([]*subsonic.Album(nil), view.catalog.Albums...)
+
+	switch listType {
+	case "random":
+		rand.Shuffle(len(albums), func(i, j int) { albums[i], albums[j] = albums[j], albums[i] })
+	case "newest":
+		sort.SliceStable(albums, func(i, j int) bool { return albums[i].Added.After(albums[j].Added) })
+	case "alphabeticalByName":
+		// Catalog order
+	case "alphabeticalByArtist":
+		sort.SliceStable(albums, func(i, j int) bool {
+			return subsonic.SortName(albums[i].Artist.Name) < subsonic.SortName(albums[j].Artist.Name)
+		})
+	case "frequent", "recent":
+		type played struct {
+			album *subsonic.Album
+			count int
+			last  time.Time
+		}
+		var list []played
+		for _, a := range albums {
+			if count, last := view.albumPlays(a); count > 0 {
+				list = append(list, played{a, count, last})
+			}
+		}
+		sort.SliceStable(list, func(i, j int) bool {
+			if listType == "frequent" {
+				return list[i].count > list[j].count
+			}
+			return list[i].last.After(list[j].last)
+		})
+		albums = albums[:0]
+		for _, p := range list {
+			albums = append(albums, p.album)
+		}
+	case "byYear":
+		from, err := formInt(r, "fromYear", 0)
+		if err != nil {
+			return nil, err
+		}
+		to, err := formInt(r, "toYear", 9999)
+		if err != nil {
+			return nil, err
+		}
+		low, high := min(from, to), max(from, to)
+		albums = filterAlbums(albums, func(a *subsonic.Album) bool { return a.Year >= low && a.Year <= high })
+		sort.SliceStable(albums, func(i, j int) bool {
+			if from > to {
+				return albums[i].Year > albums[j].Year
+			}
+			return albums[i].Year < albums[j].Year
+		})
+	case "byGenre":
+		genre, err := requiredForm(r, "genre")
+		if err != nil {
+			return nil, err
+		}
+		albums = filterAlbums(albums, func(a *subsonic.Album) bool { return strings.EqualFold(a.Genre, genre) })
+	case "starred", "highest":
+		albums = nil
+	default:
+		return nil, subsonic.Errorf(subsonic.CodeGeneric, "Unknown list type %q", listType)
+	}
+
+	albums, err = page(r, albums, "size", "offset", 10)
+	if err != nil {
+		return nil, err
+	}
+	resp := subsonic.NewResponse()
+	if byTags {
+		resp.AlbumList2 = &subsonic.AlbumList2{Album: view.albums(albums)}
+	} else {
+		resp.AlbumList = &subsonic.AlbumList{Album: view.albumDirs(albums)}
+	}
+	return resp, nil
+}
+
+func filterAlbums(albums []*subsonic.Album, keep func(*subsonic.Album) bool) []*subsonic.Album {
+	var kept []*subsonic.Album
+	for _, a := range albums {
+		if keep(a) {
+			kept = append(kept, a)
+		}
+	}
+	return kept
+}
+
+// subsonicRandomSongs picks tracks at random, optionally of a genre or
+// within a range of years
+func subsonicRandomSongs(w http.ResponseWriter, r *http.Request) (*subsonic.Response, error) {
+	from, err := formInt(r, "fromYear", 0)
+	if err != nil {
+		return nil, err
+	}
+	to, err := formInt(r, "toYear", 9999)
+	if err != nil {
+		return nil, err
+	}
+	genre := r.FormValue("genre")
+	var tracks []library.Track
+	for _, t := range library.Tracks() {
+		if genre != "" && !strings.EqualFold(t.Genre, genre) {
+			continue
+		}
+		if (r.FormValue("fromYear") != "" || r.FormValue("toYear") != "") && (t.Year < from || t.Year > to) {
+			continue
+		}
+		tracks = append(tracks, t)
+	}
+	rand.Shuffle(len(tracks), func(i, j int) { tracks[i], tracks[j] = tracks[j], tracks[i] })
+	tracks, err = page(r, tracks, "size", "", 10)
+	if err != nil {
+		return nil, err
+	}
+	resp := subsonic.NewResponse()
+	resp.RandomSongs = &subsonic.Songs{Song: newSubsonicView(middleware.User(r)).songs(tracks)}
+	return resp, nil
+}
+
+func subsonicSongsByGenre(w http.ResponseWriter, r *http.Request) (*subsonic.Response, error) {
+	genre, err := requiredForm(r, "genre")
+	if err != nil {
+		return nil, err
+	}
+	var tracks []library.Track
+	for _, t := range library.Tracks() {
+		if strings.EqualFold(t.Genre, genre) {
+			tracks = append(tracks, t)
+		}
+	}
+	tracks, err = page(r, tracks, "count", "offset", 10)
+	if err != nil {
+		return nil, err
+	}
+	resp := subsonic.NewResponse()
+	resp.SongsByGenre = &subsonic.Songs{Song: newSubsonicView(middleware.User(r)).songs(tracks)}
+	return resp, nil
+}
+
+// matchesQuery reports whether every word of the query occurs in one of
+// the fields, ignoring case
+func matchesQuery(words []string, fields ...string) bool {
+	text := strings.ToLower(strings.Join(fields, "\x00"))
+	for _, word := range words {
+		if !strings.Contains(text, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// subsonicSearch serves search2, or search3 with artists and albums by
+// tags. An empty query matches everything, which clients use to page
+// through the whole library.
+func subsonicSearch(byTags bool) subsonicHandler {
+	return func(w http.ResponseWriter, r *http.Request) (*subsonic.Response, error) {
+		return search(r, byTags)
+	}
+}
+
+func search(r *http.Request, byTags bool) (*subsonic.Response, error) {
+	query := strings.Trim(r.FormValue("query"), `"`)
+	words := strings.Fields(strings.ToLower(query))
+	view := newSubsonicView(middleware.User(r))
+
+	var artists []*subsonic.Artist
+	for _, a := range view.catalog.Artists {
+		if matchesQuery(words, a.Name) {
+			artists = append(artists, a)
+		}
+	}
+	var albums []*subsonic.Album
+	for _, a := range view.catalog.Albums {
+		if matchesQuery(words, a.Name, a.Artist.Name) {
+			albums = append(albums, a)
+		}
+	}
+	var tracks []library.Track
+	for _, t := range library.Tracks() {
+		if matchesQuery(words, t.Title, t.Artist, t.Album, path.Base(t.Path)) {
+			tracks = append(tracks, t)
+		}
+	}
+
+	artists, err := page(r, artists, "artistCount", "artistOffset", 20)
+	if err != nil {
+		return nil, err
+	}
+	albums, err = page(r, albums, "albumCount", "albumOffset", 20)
+	if err != nil {
+		return nil, err
+	}
+	tracks, err = page(r, tracks, "songCount", "songOffset", 20)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := subsonic.NewResponse()
+	if !byTags {
+		result := &subsonic.SearchResult2{Album: view.albumDirs(albums), Song: view.songs(tracks)}
+		for _, a := range artists {
+			result.Artist = append(result.Artist, subsonic.IndexArtist{ID: a.ID, Name: a.Name})
+		}
+		resp.SearchResult2 = result
+		return resp, nil
+	}
+	result := &subsonic.SearchResult3{Album: view.albums(albums), Song: view.songs(tracks)}
+	for _, a := range artists {
+		result.Artist = append(result.Artist, view.artist(a))
+	}
+	resp.SearchResult3 = result
+	return resp, nil
+}
+
+// subsonicStarred answers getStarred, or getStarred2 when byTags is set,
+// with empty lists as starring is not supported
+func subsonicStarred(byTags bool) subsonicHandler {
+	return func(w http.ResponseWriter, r *http.Request) (*subsonic.Response, error) {
+		resp := subsonic.NewResponse()
+		if byTags {
+			resp.Starred2 = &subsonic.Starred2{}
+		} else {
+			resp.Starred = &subsonic.Starred{}
+		}
+		return resp, nil
+	}
+}
@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"MediaBackend/audio"
+	"MediaBackend/history"
+	"MediaBackend/library"
+	"MediaBackend/middleware"
+	minioClient "MediaBackend/minio"
+	"MediaBackend/playlists"
+	"MediaBackend/subsonic"
+	"MediaBackend/transcode"
+)
+
+// coverImageNames are the folder images used as cover art when a track has
+// no embedded picture
+var coverImageNames = []string{"cover.jpg", "cover.png", "folder.jpg", "folder.png", "front.jpg", "front.png"}
+
+// serveLibraryTrack streams a track through the music endpoint, so that
+// ranges, cue tracks, seeking and transcoding behave the same for the
+// Subsonic and DLNA endpoints
+func serveLibraryTrack(w http.ResponseWriter, r *http.Request, track library.Track, query url.Values) {
+	forward := r.Clone(r.Context())
+	forward.URL.Path = "/gomedia/api/music/" + track.Path
+	forward.URL.RawPath = ""
+	forward.URL.RawQuery = query.Encode()
+	forward.Form, forward.PostForm = nil, nil
+	StreamMinIOMusic(w, forward)
+}
+
+// subsonicStream streams a track. It is transcoded when format names
+// another output format (mp3, opus or aac) or when maxBitRate is below the
+// track's bitrate; format=raw never transcodes. timeOffset starts MP3 and
+// cue sheet tracks at a given second.
+func subsonicStream(w http.ResponseWriter, r *http.Request) (*subsonic.Response, error) {
+	id, err := requiredForm(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	track, ok := library.Get(id)
+	if !ok {
+		return nil, subsonic.NotFound("Song")
+	}
+	maxBitRate, err := formInt(r, "maxBitRate", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	format := strings.ToLower(r.FormValue("format"))
+	target := ""
+	if _, ok := transcode.Formats[format]; ok && format != track.Format {
+		target = format
+	}
+	if target == "" && maxBitRate > 0 && track.Bitrate/1000 > maxBitRate {
+		target = "mp3"
+		if _, ok := transcode.Formats[format]; ok {
+			target = format
+		}
+	}
+	// Cue tracks are cut from their album file and cannot be transcoded
+	if format == "raw" || track.Cue != nil || transcode.Default == nil {
+		target = ""
+	}
+
+	query := url.Values{}
+	if target != "" {
+		query.Set("format", target)
+		if maxBitRate > 0 {
+			query.Set("bitrate", strconv.Itoa(maxBitRate))
+		}
+	} else if offset := r.FormValue("timeOffset"); offset != "" && offset != "0" && (track.Cue != nil || track.Format == "mp3") {
+		query.Set("t", offset)
+	}
+	serveLibraryTrack(w, r, track, query)
+	return nil, nil
+}
+
+// subsonicDownload returns the original file of a track
+func subsonicDownload(w http.ResponseWriter, r *http.Request) (*subsonic.Response, error) {
+	id, err := requiredForm(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	track, ok := library.Get(id)
+	if !ok {
+		return nil, subsonic.NotFound("Song")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(track.Path)))
+	serveLibraryTrack(w, r, track, url.Values{})
+	return nil, nil
+}
+
+// subsonicCoverArt returns the cover of a track, album, artist or playlist.
+// Track covers are the embedded front cover or else a cover, folder or
+// front image beside the file; albums and artists use their first track's.
+// Images are served at their stored size whatever size is asked for.
+func subsonicCoverArt(w http.ResponseWriter, r *http.Request) (*subsonic.Response, error) {
+	id, err := requiredForm(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	ctx := r.Context()
+	user := middleware.User(r)
+
+	if playlistID, ok := subsonic.ParsePlaylistCoverID(id); ok {
+		object, info, err := playlists.OpenCover(ctx, user, playlistID)
+		if err != nil {
+			return nil, subsonic.NotFound("Cover art")
+		}
+		defer object.Close()
+		w.Header().Set("Content-Type", info.ContentType)
+		w.Header().Set("Cache-Control", "private, max-age=3600")
+		http.ServeContent(w, r, "", info.LastModified, object)
+		return nil, nil
+	}
+
+	track, ok := library.Get(id)
+	if subsonic.IsAlbumID(id) || subsonic.IsArtistID(id) {
+		catalog := subsonic.NewCatalog(library.Tracks())
+		if album, found := catalog.Album(id); found {
+			track, ok = album.Tracks[0], true
+		} else if artist, found := catalog.Artist(id); found && len(artist.Albums) > 0 {
+			track, ok = artist.Albums[0].Tracks[0], true
+		}
+	}
+	if !ok {
+		return nil, subsonic.NotFound("Cover art")
+	}
+	found, err := serveTrackCover(w, r, track)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, subsonic.NotFound("Cover art")
+	}
+	return nil, nil
+}
+
+// serveTrackCover serves the artwork of a track: its embedded front cover,
+// or else a cover, folder or front image beside the file. It reports
+// whether the track has any.
+func serveTrackCover(w http.ResponseWriter, r *http.Request, track library.Track) (bool, error) {
+	ctx := r.Context()
+	// Cue tracks share the artwork of their album file
+	if track.Cue != nil {
+		if parent, found := library.Get(track.Cue.Parent); found {
+			track = parent
+		}
+	}
+
+	if picture, err := embeddedPicture(ctx, track); err == nil {
+		w.Header().Set("Content-Type", picture.MIMEType)
+		w.Header().Set("Cache-Control", "private, max-age=3600")
+		http.ServeContent(w, r, "", track.Modified, bytes.NewReader(picture.Data))
+		return true, nil
+	} else if !errors.Is(err, audio.ErrNoPicture) {
+		log.Printf("Error reading picture of %s: %v", track.Path, err)
+	}
+
+	dir := subsonic.ParentDir(track.Path)
+	for _, name := range coverImageNames {
+		key := path.Join(dir, name)
+		info, err := minioClient.StatObject(ctx, minioClient.MusicBucket, key)
+		if err != nil {
+			continue
+		}
+		object, err := minioClient.GetObject(ctx, minioClient.MusicBucket, key)
+		if err != nil {
+			return false, err
+		}
+		defer object.Close()
+		w.Header().Set("Content-Type", getImageContentType(name))
+		w.Header().Set("Cache-Control", "private, max-age=3600")
+		w.Header().Set("ETag", info.ETag)
+		http.ServeContent(w, r, "", info.LastModified, object)
+		return true, nil
+	}
+	return false, nil
+}
+
+// embeddedPicture reads the artwork embedded in a track, cached per file
+// version in the cache bucket
+func embeddedPicture(ctx context.Context, track library.Track) (*audio.Picture, error) {
+	key := minioClient.CacheKey("covers", track.ETag, "cover")
+	if object, info, err := minioClient.OpenCached(ctx, key); err == nil {
+		defer object.Close()
+		data, err := io.ReadAll(object)
+		if err == nil {
+			return &audio.Picture{MIMEType: info.ContentType, Data: data}, nil
+		}
+	}
+
+	object, err := minioClient.GetObject(ctx, minioClient.MusicBucket, track.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+	picture, err := audio.ReadPicture(audio.NewBlockReaderAt(object, track.Size), track.Size, audio.Format(track.Format))
+	if err != nil {
+		return nil, err
+	}
+	if err := minioClient.PutCached(ctx, key, picture.Data, picture.MIMEType); err != nil {
+		log.Printf("Error caching picture of %s: %v", track.Path, err)
+	}
+	return picture, nil
+}
+
+// subsonicScrobble records listens of one or more tracks (id, with time in
+// Unix milliseconds), or reports what is playing when submission=false
+func subsonicScrobble(w http.ResponseWriter, r *http.Request) (*subsonic.Response, error) {
+	ids := r.Form["id"]
+	if len(ids) == 0 {
+		return nil, subsonic.MissingParameter("id")
+	}
+	times := r.Form["time"]
+	nowPlaying := r.FormValue("submission") == "false"
+	user := middleware.User(r)
+
+	for i, id := range ids {
+		s := history.Submission{Type: history.TypeListen, TrackID: id, Client: r.FormValue("c")}
+		if nowPlaying {
+			s.Type = history.TypeNowPlaying
+		} else if i < len(times) {
+			ms, err := strconv.ParseInt(times[i], 10, 64)
+			if err != nil {
+				return nil, subsonic.Errorf(subsonic.CodeGeneric, "Invalid time: %q", times[i])
+			}
+			s.Time = time.UnixMilli(ms).UTC()
+		}
+		if _, err := history.Submit(r.Context(), user, s); err != nil {
+			if errors.Is(err, history.ErrInvalid) {
+				return nil, subsonic.Errorf(subsonic.CodeGeneric, "%s", err.Error())
+			}
+			return nil, err
+		}
+	}
+	return subsonic.NewResponse(), nil
+}
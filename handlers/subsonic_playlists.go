@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"MediaBackend/library"
+	"MediaBackend/middleware"
+	"MediaBackend/playlists"
+	"MediaBackend/subsonic"
+)
+
+// subsonicPlaylistError maps playlist errors to Subsonic failures
+func subsonicPlaylistError(err error) error {
+	switch {
+	case errors.Is(err, playlists.ErrNotFound):
+		return subsonic.NotFound("Playlist")
+	case errors.Is(err, playlists.ErrInvalid):
+		return subsonic.Errorf(subsonic.CodeGeneric, "%s", err.Error())
+	}
+	return err
+}
+
+// convertPlaylist converts a playlist. Tracks no longer in the library are
+// left out of the count and the entries.
+func convertPlaylist(p playlists.Playlist) (subsonic.Playlist, []library.Track) {
+	var tracks []library.Track
+	var duration float64
+	for _, id := range p.Tracks {
+		if t, ok := library.Get(id); ok {
+			tracks = append(tracks, t)
+			duration += t.Duration
+		}
+	}
+	view := subsonic.Playlist{
+		ID:        p.ID,
+		Name:      p.Name,
+		Comment:   p.Description,
+		Owner:     p.Owner,
+		SongCount: len(tracks),
+		Duration:  int(math.Round(duration)),
+		Created:   subsonicTime(p.Created),
+		Changed:   subsonicTime(p.Updated),
+		Readonly:  p.Rules != nil,
+	}
+	if p.CoverType != "" {
+		view.CoverArt = subsonic.PlaylistCoverID(p.ID)
+	} else if len(tracks) > 0 {
+		view.CoverArt = tracks[0].ID
+	}
+	return view, tracks
+}
+
+// playlistWithSongs converts a playlist with its entries
+func (v *subsonicView) playlistWithSongs(p playlists.Playlist) *subsonic.PlaylistWithSongs {
+	view, tracks := convertPlaylist(p)
+	return &subsonic.PlaylistWithSongs{Playlist: view, Entry: v.songs(tracks)}
+}
+
+// subsonicPlaylists lists the user's playlists; those of other users are
+// private
+func subsonicPlaylists(w http.ResponseWriter, r *http.Request) (*subsonic.Response, error) {
+	user := middleware.User(r)
+	if name := r.FormValue("username"); name != "" && name != user {
+		return nil, subsonic.Errorf(subsonic.CodeNotAuthorized, "Only your own playlists can be listed")
+	}
+	list := []subsonic.Playlist{}
+	for _, p := range playlists.List(user) {
+		converted, _ := convertPlaylist(p)
+		list = append(list, converted)
+	}
+	resp := subsonic.NewResponse()
+	resp.Playlists = &subsonic.Playlists{Playlist: list}
+	return resp, nil
+}
+
+func subsonicPlaylist(w http.ResponseWriter, r *http.Request) (*subsonic.Response, error) {
+	id, err := requiredForm(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	user := middleware.User(r)
+	p, err := playlists.Get(user, id)
+	if err != nil {
+		return nil, subsonicPlaylistError(err)
+	}
+	resp := subsonic.NewResponse()
+	resp.Playlist = newSubsonicView(user).playlistWithSongs(p)
+	return resp, nil
+}
+
+// subsonicCreatePlaylist creates a playlist named name with the songId
+// tracks, or replaces the tracks of playlistId
+func subsonicCreatePlaylist(w http.ResponseWriter, r *http.Request) (*subsonic.Response, error) {
+	ctx := r.Context()
+	user := middleware.User(r)
+	ids := r.Form["songId"]
+	if ids == nil {
+		ids = []string{}
+	}
+
+	var p playlists.Playlist
+	var err error
+	if id := r.FormValue("playlistId"); id != "" {
+		p, err = playlists.Update(ctx, user, id, func(p *playlists.Playlist) error {
+			if name := r.FormValue("name"); name != "" {
+				p.Name = name
+			}
+			p.Tracks = ids
+			return nil
+		})
+	} else {
+		name, missing := requiredForm(r, "name")
+		if missing != nil {
+			return nil, missing
+		}
+		p, err = playlists.Create(ctx, user, playlists.Playlist{Name: name, Tracks: ids})
+	}
+	if err != nil {
+		return nil, subsonicPlaylistError(err)
+	}
+	resp := subsonic.NewResponse()
+	resp.Playlist = newSubsonicView(user).playlistWithSongs(p)
+	return resp, nil
+}
+
+// subsonicUpdatePlaylist renames a playlist, sets its comment, removes the
+// entries at songIndexToRemove and appends songIdToAdd
+func subsonicUpdatePlaylist(w http.ResponseWriter, r *http.Request) (*subsonic.Response, error) {
+	id, err := requiredForm(r, "playlistId")
+	if err != nil {
+		return nil, err
+	}
+	var remove []int
+	for _, value := range r.Form["songIndexToRemove"] {
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, subsonic.Errorf(subsonic.CodeGeneric, "Invalid songIndexToRemove: %q", value)
+		}
+		remove = append(remove, i)
+	}
+	_, err = playlists.Update(r.Context(), middleware.User(r), id, func(p *playlists.Playlist) error {
+		if name := r.FormValue("name"); name != "" {
+			p.Name = name
+		}
+		if _, ok := r.Form["comment"]; ok {
+			p.Description = r.FormValue("comment")
+		}
+		if len(remove) > 0 {
+			if err := p.RemoveAt(remove...); err != nil {
+				return err
+			}
+		}
+		if add := r.Form["songIdToAdd"]; len(add) > 0 {
+			p.Insert(-1, add...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, subsonicPlaylistError(err)
+	}
+	return subsonic.NewResponse(), nil
+}
+
+func subsonicDeletePlaylist(w http.ResponseWriter, r *http.Request) (*subsonic.Response, error) {
+	id, err := requiredForm(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	if err := playlists.Delete(r.Context(), middleware.User(r), id); err != nil {
+		return nil, subsonicPlaylistError(err)
+	}
+	return subsonic.NewResponse(), nil
+}
@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"MediaBackend/audio"
+	"MediaBackend/library"
+	"MediaBackend/middleware"
+	minioClient "MediaBackend/minio"
+	"MediaBackend/randid"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// errTagsNotApplied is returned when the rewritten file does not read back
+// with the requested values
+var errTagsNotApplied = errors.New("rewritten file does not carry the new tags")
+
+// ServeMusicTags returns the tags stored in a track (GET) or rewrites them
+// (PATCH). The edited file is written to a temporary object and checked
+// before it replaces the original, so a failed edit leaves the track as it
+// was. If-Match guards against overwriting a change since the client read
+// the tags, and a change during the edit fails it with 409 Conflict.
+// Without authentication the tags are read-only, as WebDAV is.
+func ServeMusicTags(w http.ResponseWriter, r *http.Request, filename string) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		getTags(w, r, filename)
+	case http.MethodPatch:
+		if !middleware.AuthEnabled() {
+			http.Error(w, "Tag editing requires authentication", http.StatusForbidden)
+			return
+		}
+		patchTags(w, r, filename)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func getTags(w http.ResponseWriter, r *http.Request, filename string) {
+	ctx := r.Context()
+
+	objectInfo, err := minioClient.StatObject(ctx, minioClient.MusicBucket, filename)
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		log.Printf("Error getting object info for %s: %v", filename, err)
+		return
+	}
+	meta, err := readObjectMetadata(ctx, minioClient.MusicBucket, objectInfo, audio.FormatFromName(filename))
+	if err != nil {
+		http.Error(w, "Error reading tags", http.StatusUnprocessableEntity)
+		log.Printf("Error reading tags of %s: %v", filename, err)
+		return
+	}
+	w.Header().Set("ETag", quoteETag(objectInfo.ETag))
+	writeJSON(w, http.StatusOK, meta.Tags)
+}
+
+func patchTags(w http.ResponseWriter, r *http.Request, filename string) {
+	ctx := r.Context()
+
+	var update audio.TagUpdate
+	if err := decodeJSONBody(w, r, &update); err != nil {
+		http.Error(w, "Invalid tag update: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if update.Empty() {
+		http.Error(w, "No tags to update", http.StatusBadRequest)
+		return
+	}
+	if err := update.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format := audio.FormatFromName(filename)
+	if !audio.CanWriteTags(format) {
+		http.Error(w, "Tag editing is only available for MP3, AAC, FLAC, Ogg and M4A", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	// Other writers of the track wait until the new version is stored
+	unlock := minioClient.LockObject(minioClient.MusicBucket, filename)
+	defer unlock()
+
+	objectInfo, err := minioClient.StatObject(ctx, minioClient.MusicBucket, filename)
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		log.Printf("Error getting object info for %s: %v", filename, err)
+		return
+	}
+	if !etagMatches(r.Header.Get("If-Match"), objectInfo.ETag) {
+		http.Error(w, "Track was modified", http.StatusPreconditionFailed)
+		return
+	}
+
+	tmpKey := minioClient.CacheKey("tagedit", objectInfo.ETag, randid.Hex(8))
+	defer func() {
+		if err := minioClient.RemoveObject(context.WithoutCancel(ctx), minioClient.CacheBucket, tmpKey); err != nil {
+			log.Printf("Error removing temporary object %s: %v", tmpKey, err)
+		}
+	}()
+	tmpInfo, err := writeRetaggedObject(ctx, objectInfo, tmpKey, format, update)
+	if errors.Is(err, audio.ErrUnsupportedTag) {
+		http.Error(w, "Tags cannot be edited without losing data: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	} else if err != nil {
+		http.Error(w, "Error writing tags", http.StatusUnprocessableEntity)
+		log.Printf("Error writing tags of %s: %v", filename, err)
+		return
+	}
+
+	// Writers outside this server, such as other S3 clients, may have
+	// changed the original while the copy was written
+	current, err := minioClient.StatObject(ctx, minioClient.MusicBucket, filename)
+	if err != nil || current.ETag != objectInfo.ETag {
+		http.Error(w, "Track was modified during the update", http.StatusConflict)
+		return
+	}
+	if err := replaceWithObject(ctx, filename, objectInfo.ETag, tmpInfo); err != nil {
+		if minioClient.IsPreconditionFailed(err) {
+			http.Error(w, "Track was modified during the update", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Error saving tags", http.StatusInternalServerError)
+		log.Printf("Error replacing %s: %v", filename, err)
+		return
+	}
+
+	track, err := library.Reindex(ctx, filename, false)
+	if err != nil {
+		http.Error(w, "Error updating library", http.StatusInternalServerError)
+		log.Printf("Error reindexing %s: %v", filename, err)
+		return
+	}
+	log.Printf("Updated tags: %s", filename)
+	w.Header().Set("ETag", quoteETag(track.ETag))
+	writeJSON(w, http.StatusOK, track)
+}
+
+// writeRetaggedObject streams a retagged copy of a track into a temporary
+// object in the cache bucket and checks that it reads back with the update
+// applied
+func writeRetaggedObject(ctx context.Context, objectInfo minio.ObjectInfo, tmpKey string, format audio.Format, update audio.TagUpdate) (minio.ObjectInfo, error) {
+	before, err := readObjectMetadata(ctx, minioClient.MusicBucket, objectInfo, format)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	object, err := minioClient.GetObject(ctx, minioClient.MusicBucket, objectInfo.Key)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	defer object.Close()
+
+	body, size, err := audio.RewriteTags(audio.NewBlockReaderAt(object, objectInfo.Size), objectInfo.Size, format, update)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	contentType := objectInfo.ContentType
+	if contentType == "" {
+		contentType = getContentType(objectInfo.Key)
+	}
+	if _, err := minioClient.PutObject(ctx, minioClient.CacheBucket, tmpKey, body, size, contentType); err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	tmpInfo, err := minioClient.StatObject(ctx, minioClient.CacheBucket, tmpKey)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	after, err := readObjectMetadata(ctx, minioClient.CacheBucket, tmpInfo, format)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	if update.Apply(after.Tags) != after.Tags || after.Duration != before.Duration {
+		return minio.ObjectInfo{}, errTagsNotApplied
+	}
+	return tmpInfo, nil
+}
+
+// replaceWithObject stores the checked temporary object as the track,
+// provided the track still has the ETag it was read with. The write is
+// conditional, so it goes through the server rather than copying.
+func replaceWithObject(ctx context.Context, filename, etag string, tmpInfo minio.ObjectInfo) error {
+	object, err := minioClient.GetObject(ctx, minioClient.CacheBucket, tmpInfo.Key)
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+
+	_, err = minioClient.ReplaceObject(ctx, minioClient.MusicBucket, filename, object, tmpInfo.Size, tmpInfo.ContentType, etag)
+	return err
+}
+
+// readObjectMetadata reads the tags and stream properties of an object
+func readObjectMetadata(ctx context.Context, bucket string, objectInfo minio.ObjectInfo, format audio.Format) (*audio.Metadata, error) {
+	object, err := minioClient.GetObject(ctx, bucket, objectInfo.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	return audio.ReadMetadata(audio.NewBlockReaderAt(object, objectInfo.Size), objectInfo.Size, format)
+}
+
+// quoteETag formats an object ETag for the ETag header
+func quoteETag(etag string) string {
+	return fmt.Sprintf(`"%s"`, strings.Trim(etag, `"`))
+}
+
+// etagMatches evaluates an If-Match header against an object ETag
+func etagMatches(ifMatch, etag string) bool {
+	if ifMatch == "" {
+		return true
+	}
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == quoteETag(etag) {
+			return true
+		}
+	}
+	return false
+}
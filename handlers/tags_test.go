@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"MediaBackend/middleware"
+)
+
+func TestPatchTagsWithoutAuth(t *testing.T) {
+	if middleware.AuthEnabled() {
+		t.Skip("AUTH_USERS is set")
+	}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPatch, "/api/music/a.mp3/tags", strings.NewReader(`{"title":"x"}`))
+	ServeMusicTags(w, r, "a.mp3")
+	if w.Code != http.StatusForbidden {
+		t.Errorf("PATCH without authentication = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
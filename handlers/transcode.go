@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"MediaBackend/audio"
+	"MediaBackend/jobs"
+	minioClient "MediaBackend/minio"
+	"MediaBackend/transcode"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// transcodeTimeout bounds how long a stream request waits for conversion
+const transcodeTimeout = 5 * time.Minute
+
+// serveTranscoded streams a track converted to ?format= (mp3, opus or aac)
+// at ?bitrate= kbit/s, snapped to the format's bitrate ladder. Conversion
+// runs as a job on the transcode pool and the request waits for its output,
+// so that players can use the URL directly. With async=1 the response is
+// 202 Accepted with the job status until the output is cached; wait=N then
+// blocks for up to N seconds.
+func serveTranscoded(w http.ResponseWriter, r *http.Request, filename string, objectInfo minio.ObjectInfo) {
+	ctx := r.Context()
+
+	format, ok := transcode.Formats[strings.ToLower(r.URL.Query().Get("format"))]
+	if !ok {
+		http.Error(w, "format must be mp3, opus or aac", http.StatusBadRequest)
+		return
+	}
+	if transcode.Default == nil {
+		http.Error(w, "Transcoding is not available", http.StatusServiceUnavailable)
+		return
+	}
+	bitrate := format.Bitrate(queryInt(r, "bitrate", 0, 0, 1024))
+
+	cacheKey := minioClient.CacheKey("transcodes", objectInfo.ETag, fmt.Sprintf("%d.%s", bitrate, format.Ext))
+	object, cached, err := minioClient.OpenCached(ctx, cacheKey)
+	if errors.Is(err, minioClient.ErrCacheMiss) {
+		job := transcode.Pool.Submit("transcode", cacheKey, func(ctx context.Context, job *jobs.Job) error {
+			return transcodeTrack(ctx, job, objectInfo, format, bitrate, cacheKey)
+		})
+		if r.URL.Query().Get("async") == "1" {
+			if !waitForJob(r, job, transcodeTimeout) {
+				writeJobAccepted(w, job)
+				return
+			}
+		} else if !awaitJob(r, job, transcodeTimeout) {
+			w.Header().Set("Retry-After", "10")
+			http.Error(w, "Transcoding is taking too long", http.StatusServiceUnavailable)
+			return
+		}
+		if err := job.Err(); err != nil {
+			http.Error(w, "Error transcoding file", http.StatusUnprocessableEntity)
+			return
+		}
+		object, cached, err = minioClient.OpenCached(ctx, cacheKey)
+	}
+	if err != nil {
+		http.Error(w, "Error reading transcoded file", http.StatusInternalServerError)
+		log.Printf("Error opening transcoded file %s: %v", cacheKey, err)
+		return
+	}
+	defer object.Close()
+
+	w.Header().Set("Content-Type", format.ContentType)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("ETag", fmt.Sprintf(`"%s-%s%d"`, strings.Trim(objectInfo.ETag, `"`), format.Name, bitrate))
+	// ServeContent handles Range and If-None-Match on the cached output
+	http.ServeContent(w, r, "", cached.LastModified, object)
+}
+
+// transcodeTrack converts a track and streams the output into the cache bucket
+func transcodeTrack(ctx context.Context, job *jobs.Job, objectInfo minio.ObjectInfo, format transcode.Format, bitrate int, cacheKey string) error {
+	object, err := minioClient.GetObject(ctx, minioClient.MusicBucket, objectInfo.Key)
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+
+	opts := transcode.Options{
+		Format:  format,
+		Bitrate: bitrate,
+		Input:   audio.FormatFromName(objectInfo.Key),
+	}
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := transcode.Default.Transcode(ctx, &progressReader{r: object, job: job, size: objectInfo.Size}, pw, opts)
+		// A failed transcode aborts the upload rather than caching partial output
+		pw.CloseWithError(err)
+		done <- err
+	}()
+
+	size, err := minioClient.PutCachedStream(ctx, cacheKey, pr, format.ContentType)
+	// Unblock the transcoder if the upload stopped early
+	pr.CloseWithError(err)
+	if transcodeErr := <-done; transcodeErr != nil {
+		return transcodeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	job.SetResult(map[string]any{"format": format.Name, "bitrate": bitrate, "bytes": size})
+	log.Printf("Transcoded %s to %s %dk (%d bytes)", objectInfo.Key, format.Name, bitrate, size)
+	return nil
+}
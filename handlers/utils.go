@@ -3,10 +3,14 @@ package handlers
 import (
 	"encoding/json"
 	"fmt"
+	"math"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+
+	"MediaBackend/library"
 )
 
 // MediaFile represents a media file with metadata
@@ -16,6 +20,9 @@ type MediaFile struct {
 	Path        string `json:"path,omitempty"` // Omit path in JSON response for security if desired, but keeping for now as per previous
 	Url         string `json:"url"`
 	ContentType string `json:"contentType"`
+	// Library fields, set for music files that have been indexed
+	ID       string            `json:"id,omitempty"`
+	Loudness *library.Loudness `json:"loudness,omitempty"`
 }
 
 // listMediaFiles lists all files in a directory and generates metadata
@@ -74,8 +81,10 @@ func getContentType(filename string) string {
 		return "audio/wav"
 	case ".ogg":
 		return "audio/ogg"
-	case ".m4a":
+	case ".m4a", ".m4b":
 		return "audio/mp4"
+	case ".aac":
+		return "audio/aac"
 	case ".flac":
 		return "audio/flac"
 	default:
@@ -160,3 +169,72 @@ func parseInt64(s string, defaultVal int64) int64 {
 	}
 	return val
 }
+
+// queryInt reads an integer query parameter, clamped to [minVal, maxVal]
+func queryInt(r *http.Request, name string, defaultVal, minVal, maxVal int) int {
+	val := int(parseInt64(r.URL.Query().Get(name), int64(defaultVal)))
+	if val < minVal {
+		return minVal
+	}
+	if val > maxVal {
+		return maxVal
+	}
+	return val
+}
+
+// queryFloat reads a decimal query parameter, clamped to [minVal, maxVal]
+func queryFloat(r *http.Request, name string, defaultVal, minVal, maxVal float64) float64 {
+	val, err := strconv.ParseFloat(r.URL.Query().Get(name), 64)
+	if err != nil || math.IsNaN(val) {
+		val = defaultVal
+	}
+	return min(max(val, minVal), maxVal)
+}
+
+// writeJSON encodes v as the JSON response body
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// maxJSONBodySize bounds JSON request bodies
+const maxJSONBodySize = 1 << 20
+
+// decodeJSONBody decodes a JSON request body into v, rejecting unknown
+// fields so that typos are reported rather than ignored
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v any) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJSONBodySize))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// publicURL is the externally visible origin of the server, e.g.
+// https://media.example.com, for absolute links behind a reverse proxy
+var publicURL = strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/")
+
+// publicBaseURL returns the scheme and host that clients reach the server
+// at, from PUBLIC_URL or else the request and its forwarding headers
+func publicBaseURL(r *http.Request) string {
+	if publicURL != "" {
+		return publicURL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	host := r.Host
+	if forwarded := r.Header.Get("X-Forwarded-Host"); forwarded != "" {
+		host, _, _ = strings.Cut(forwarded, ",")
+		host = strings.TrimSpace(host)
+	}
+	return scheme + "://" + host
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"MediaBackend/audio"
+	"MediaBackend/jobs"
+	minioClient "MediaBackend/minio"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// waveformTimeout bounds how long a request waits for the peaks
+const waveformTimeout = 2 * time.Minute
+
+// ServeMusicWaveform returns min/max peaks for a track as audiowaveform JSON
+// or .dat. Decoding runs as a job shared by requests for the same peaks,
+// which the request waits for; with async=1 the response is 202 Accepted
+// with the job status until the peaks are cached.
+//
+// Query parameters:
+//   - points: number of min/max pairs (default 1000)
+//   - bits: 8 or 16 (default 8)
+//   - format: json or dat (default json, or dat when Accept is application/octet-stream)
+//   - wait: with async=1, seconds to wait for the job before answering 202 (default 0)
+func ServeMusicWaveform(w http.ResponseWriter, r *http.Request, filename string) {
+	ctx := r.Context()
+
+	format := audio.FormatFromName(filename)
+	if !audio.CanDecode(format) {
+		http.Error(w, "Waveforms are only available for MP3, WAV and FLAC", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	points := queryInt(r, "points", 1000, 1, 65536)
+	// Other sample sizes are rejected rather than clamped to the nearest one
+	bits := 8
+	switch r.URL.Query().Get("bits") {
+	case "", "8":
+	case "16":
+		bits = 16
+	default:
+		http.Error(w, "bits must be 8 or 16", http.StatusBadRequest)
+		return
+	}
+	output := r.URL.Query().Get("format")
+	if output == "" {
+		output = "json"
+		if strings.Contains(r.Header.Get("Accept"), "application/octet-stream") {
+			output = "dat"
+		}
+	}
+	if output != "json" && output != "dat" {
+		http.Error(w, "format must be json or dat", http.StatusBadRequest)
+		return
+	}
+
+	objectInfo, err := minioClient.StatObject(ctx, minioClient.MusicBucket, filename)
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		log.Printf("Error getting object info for %s: %v", filename, err)
+		return
+	}
+
+	// Cached waveforms are keyed by the source ETag, so edits invalidate them
+	cacheKey := minioClient.CacheKey("waveforms", objectInfo.ETag, fmt.Sprintf("%d-%d.dat", points, bits))
+	etag := fmt.Sprintf(`"%s-wf%d-%d"`, strings.Trim(objectInfo.ETag, `"`), points, bits)
+	if match := r.Header.Get("If-None-Match"); match != "" && match == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	data, err := minioClient.GetCached(ctx, cacheKey)
+	if errors.Is(err, minioClient.ErrCacheMiss) {
+		job := jobs.Default.Submit("waveform", cacheKey, func(ctx context.Context, job *jobs.Job) error {
+			return computeWaveform(ctx, job, objectInfo, format, cacheKey, points, bits)
+		})
+		if r.URL.Query().Get("async") == "1" {
+			if !waitForJob(r, job, waveformTimeout) {
+				writeJobAccepted(w, job)
+				return
+			}
+		} else if !awaitJob(r, job, waveformTimeout) {
+			w.Header().Set("Retry-After", "10")
+			http.Error(w, "Computing the waveform is taking too long", http.StatusServiceUnavailable)
+			return
+		}
+		if err := job.Err(); err != nil {
+			http.Error(w, "Error decoding file", http.StatusUnprocessableEntity)
+			return
+		}
+		data, err = minioClient.GetCached(ctx, cacheKey)
+	}
+	if err != nil {
+		http.Error(w, "Error reading waveform", http.StatusInternalServerError)
+		log.Printf("Error reading cached waveform %s: %v", cacheKey, err)
+		return
+	}
+	wf, err := audio.ParseWaveformDat(data)
+	if err != nil {
+		// Dropping it lets the next request compute the peaks again
+		if err := minioClient.RemoveObject(ctx, minioClient.CacheBucket, cacheKey); err != nil {
+			log.Printf("Error removing cached waveform %s: %v", cacheKey, err)
+		}
+		http.Error(w, "Error reading waveform", http.StatusInternalServerError)
+		log.Printf("Discarding corrupt cached waveform %s: %v", cacheKey, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+
+	if output == "dat" {
+		data := wf.MarshalDat()
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+		return
+	}
+	writeJSON(w, http.StatusOK, wf)
+}
+
+// computeWaveform decodes a track and stores its peaks in the cache as .dat
+func computeWaveform(ctx context.Context, job *jobs.Job, objectInfo minio.ObjectInfo, format audio.Format,
+	cacheKey string, points, bits int) error {
+	object, err := minioClient.GetObject(ctx, minioClient.MusicBucket, objectInfo.Key)
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+
+	dec, err := audio.NewDecoder(&progressReader{r: object, job: job, size: objectInfo.Size}, format)
+	if err != nil {
+		return err
+	}
+	wf, err := audio.ComputeWaveform(dec, points, bits)
+	if err != nil {
+		return err
+	}
+	if err := minioClient.PutCached(ctx, cacheKey, wf.MarshalDat(), "application/octet-stream"); err != nil {
+		return err
+	}
+	log.Printf("Computed waveform: %s (%d points)", objectInfo.Key, wf.Length())
+	return nil
+}
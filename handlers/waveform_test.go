@@ -0,0 +1,18 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWaveformRejectsBits(t *testing.T) {
+	for _, bits := range []string{"4", "12", "24", "32", "x"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api/music/a.mp3/waveform?bits="+bits, nil)
+		ServeMusicWaveform(w, r, "a.mp3")
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("bits=%s: status %d, want %d", bits, w.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	minioClient "MediaBackend/minio"
+	"MediaBackend/webdav"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// davMounts are the top-level collections, one per bucket
+var davMounts = []string{"music", "images"}
+
+// davBucket returns the bucket mounted at a top-level collection
+func davBucket(mount string) (string, bool) {
+	switch mount {
+	case "music":
+		return minioClient.MusicBucket, true
+	case "images":
+		return minioClient.ImageBucket, true
+	}
+	return "", false
+}
+
+// davTarget is a resource addressed by a WebDAV path: the root, a bucket,
+// or an object or prefix within it
+type davTarget struct {
+	// mount is empty for the root
+	mount  string
+	bucket string
+	// key has no trailing slash and is empty for the root of a bucket
+	key string
+}
+
+// parseDAVPath resolves a path below webdav.Prefix. Dot segments are
+// cleaned first, so that keys cannot climb out of their bucket.
+func parseDAVPath(p string) (davTarget, bool) {
+	rest := strings.Trim(path.Clean("/"+strings.TrimPrefix(p, webdav.Prefix)), "/")
+	if rest == "" {
+		return davTarget{}, true
+	}
+	mount, key, _ := strings.Cut(rest, "/")
+	bucket, ok := davBucket(mount)
+	if !ok {
+		return davTarget{}, false
+	}
+	return davTarget{mount: mount, bucket: bucket, key: key}, true
+}
+
+// path returns the path of the target below webdav.Prefix; collections end
+// in "/"
+func (t davTarget) path(collection bool) string {
+	p := t.mount
+	if t.key != "" {
+		p += "/" + t.key
+	}
+	if collection && p != "" {
+		p += "/"
+	}
+	return p
+}
+
+// prefix returns the key prefix of the objects in the target's collection
+func (t davTarget) prefix() string {
+	if t.key == "" {
+		return ""
+	}
+	return t.key + "/"
+}
+
+// parent returns the collection containing the target
+func (t davTarget) parent() davTarget {
+	if t.key == "" {
+		return davTarget{}
+	}
+	parent := t
+	parent.key = path.Dir(t.key)
+	if parent.key == "." {
+		parent.key = ""
+	}
+	return parent
+}
+
+// contentType returns the MIME type of an object, as the REST handlers
+// serve it
+func (t davTarget) contentType() string {
+	if t.bucket == minioClient.ImageBucket {
+		return getImageContentType(t.key)
+	}
+	return getContentType(t.key)
+}
+
+// WebDAV serves the music and image buckets under /gomedia/dav/ so that
+// they can be mounted as network drives. Collections map onto key
+// prefixes, and writes go through the same storage layer as the REST
+// handlers.
+func WebDAV(w http.ResponseWriter, r *http.Request) {
+	if !webdav.Enabled() {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("DAV", webdav.Compliance())
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", webdav.Allow())
+		// Microsoft clients only write to servers announcing this
+		w.Header().Set("MS-Author-Via", "DAV")
+		return
+	}
+	if webdav.ReadOnly() && webdav.IsWrite(r.Method) {
+		http.Error(w, "WebDAV access is read-only", http.StatusForbidden)
+		return
+	}
+	t, ok := parseDAVPath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case "PROPFIND":
+		davPropfind(w, r, t)
+	case http.MethodGet, http.MethodHead:
+		davGet(w, r, t)
+	case http.MethodPut:
+		davPut(w, r, t)
+	case http.MethodDelete:
+		davDelete(w, r, t)
+	case "MKCOL":
+		davMkcol(w, r, t)
+	case "COPY", "MOVE":
+		davCopy(w, r, t, r.Method == "MOVE")
+	case "PROPPATCH":
+		davProppatch(w, r, t)
+	case "LOCK":
+		davLock(w, r, t)
+	case "UNLOCK":
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", webdav.Allow())
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// davStat describes a target, returning nil when nothing is stored there.
+// An object is a file; a key with a marker object left by MKCOL, or with
+// objects below it, is a collection.
+func davStat(ctx context.Context, t davTarget) (*webdav.Resource, error) {
+	if t.key == "" {
+		return &webdav.Resource{Path: t.path(true), Collection: true}, nil
+	}
+	info, err := minioClient.StatObject(ctx, t.bucket, t.key)
+	if err == nil {
+		return davFile(t, info), nil
+	}
+	if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for object := range minioClient.ListPrefix(ctx, t.bucket, t.prefix(), false) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		return &webdav.Resource{Path: t.path(true), Collection: true}, nil
+	}
+	return nil, nil
+}
+
+// davFile describes an object
+func davFile(t davTarget, info minio.ObjectInfo) *webdav.Resource {
+	return &webdav.Resource{
+		Path:        t.path(false),
+		Size:        info.Size,
+		Modified:    info.LastModified,
+		ETag:        info.ETag,
+		ContentType: t.contentType(),
+	}
+}
+
+// davChildren describes the members of a collection
+func davChildren(ctx context.Context, t davTarget) ([]*webdav.Resource, error) {
+	if t.mount == "" {
+		children := make([]*webdav.Resource, len(davMounts))
+		for i, mount := range davMounts {
+			children[i] = &webdav.Resource{Path: mount + "/", Collection: true}
+		}
+		return children, nil
+	}
+	var children []*webdav.Resource
+	// Some servers list a marker both as an object and as a prefix
+	seen := map[string]bool{t.prefix(): true}
+	for object := range minioClient.ListPrefix(ctx, t.bucket, t.prefix(), false) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		if seen[object.Key] {
+			continue
+		}
+		seen[object.Key] = true
+		child := davTarget{mount: t.mount, bucket: t.bucket, key: strings.TrimSuffix(object.Key, "/")}
+		if strings.HasSuffix(object.Key, "/") {
+			children = append(children, &webdav.Resource{Path: child.path(true), Collection: true})
+		} else {
+			children = append(children, davFile(child, object))
+		}
+	}
+	return children, nil
+}
+
+// davPropfind describes a resource and, at depth 1, its members. Infinite
+// depth is refused, as RFC 4918 allows, since it would list whole buckets.
+func davPropfind(w http.ResponseWriter, r *http.Request, t davTarget) {
+	depth, err := webdav.ParseDepth(r.Header.Get("Depth"), webdav.DepthInfinity)
+	if err != nil {
+		http.Error(w, "Invalid Depth header", http.StatusBadRequest)
+		return
+	}
+	if depth == webdav.DepthInfinity {
+		webdav.WriteError(w, http.StatusForbidden, "propfind-finite-depth")
+		return
+	}
+	pf, err := webdav.ReadPropfind(r)
+	if err != nil {
+		http.Error(w, "Invalid PROPFIND body", http.StatusBadRequest)
+		return
+	}
+
+	res, err := davStat(r.Context(), t)
+	if err != nil {
+		http.Error(w, "Error reading storage", http.StatusInternalServerError)
+		log.Printf("Error describing %s: %v", t.path(false), err)
+		return
+	}
+	if res == nil {
+		http.NotFound(w, r)
+		return
+	}
+	ms := webdav.NewMultistatus()
+	ms.AddResource(res, pf)
+	if depth == webdav.Depth1 && res.Collection {
+		children, err := davChildren(r.Context(), t)
+		if err != nil {
+			http.Error(w, "Error reading storage", http.StatusInternalServerError)
+			log.Printf("Error listing %s: %v", t.path(true), err)
+			return
+		}
+		for _, child := range children {
+			ms.AddResource(child, pf)
+		}
+	}
+	ms.WriteTo(w)
+}
+
+// davGet downloads a file, honouring ranges and conditional requests
+func davGet(w http.ResponseWriter, r *http.Request, t davTarget) {
+	res, err := davStat(r.Context(), t)
+	if err != nil {
+		http.Error(w, "Error reading storage", http.StatusInternalServerError)
+		log.Printf("Error describing %s: %v", t.path(false), err)
+		return
+	}
+	if res == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if res.Collection {
+		w.Header().Set("Allow", "OPTIONS, PROPFIND")
+		http.Error(w, "Collections cannot be downloaded", http.StatusMethodNotAllowed)
+		return
+	}
+
+	object, err := minioClient.GetObject(r.Context(), t.bucket, t.key)
+	if err != nil {
+		http.Error(w, "Error retrieving file", http.StatusInternalServerError)
+		log.Printf("Error getting object %s: %v", t.key, err)
+		return
+	}
+	defer object.Close()
+
+	w.Header().Set("Content-Type", res.ContentType)
+	w.Header().Set("ETag", `"`+res.ETag+`"`)
+	http.ServeContent(w, r, "", res.Modified, object)
+}
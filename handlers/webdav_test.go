@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"testing"
+
+	minioClient "MediaBackend/minio"
+	"MediaBackend/webdav"
+)
+
+func TestParseDAVPath(t *testing.T) {
+	for _, tt := range []struct {
+		path string
+		want davTarget
+		ok   bool
+	}{
+		{webdav.Prefix, davTarget{}, true},
+		{webdav.Prefix + "music", davTarget{mount: "music", bucket: minioClient.MusicBucket}, true},
+		{webdav.Prefix + "music/Artist/Album/", davTarget{mount: "music", bucket: minioClient.MusicBucket, key: "Artist/Album"}, true},
+		{webdav.Prefix + "images/a/../b.jpg", davTarget{mount: "images", bucket: minioClient.ImageBucket, key: "b.jpg"}, true},
+		// Dot segments resolve as in a URL and never above the root
+		{webdav.Prefix + "music/../../images/b.jpg", davTarget{mount: "images", bucket: minioClient.ImageBucket, key: "b.jpg"}, true},
+		{webdav.Prefix + "music/../../../etc/passwd", davTarget{}, false},
+		{webdav.Prefix + "meta/index.json", davTarget{}, false},
+	} {
+		got, ok := parseDAVPath(tt.path)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseDAVPath(%q) = %+v, %v, want %+v, %v", tt.path, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestDAVTarget(t *testing.T) {
+	target, _ := parseDAVPath(webdav.Prefix + "music/Artist/Album/01.mp3")
+	if got := target.path(false); got != "music/Artist/Album/01.mp3" {
+		t.Errorf("path = %q", got)
+	}
+	parent := target.parent()
+	if got := parent.path(true); got != "music/Artist/Album/" {
+		t.Errorf("parent path = %q", got)
+	}
+	if got := parent.prefix(); got != "Artist/Album/" {
+		t.Errorf("parent prefix = %q", got)
+	}
+	bucket := davTarget{mount: "music", bucket: minioClient.MusicBucket}
+	if bucket.path(true) != "music/" || bucket.prefix() != "" || bucket.parent() != (davTarget{}) {
+		t.Errorf("bucket root = %q, %q, %+v", bucket.path(true), bucket.prefix(), bucket.parent())
+	}
+	if (davTarget{}).path(true) != "" {
+		t.Error("root path is not empty")
+	}
+}
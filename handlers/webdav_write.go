@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"MediaBackend/library"
+	minioClient "MediaBackend/minio"
+	"MediaBackend/webdav"
+)
+
+// davChanged rescans the library after the music bucket changes
+func davChanged(bucket string) {
+	if bucket == minioClient.MusicBucket {
+		library.ScanJob()
+	}
+}
+
+// davStatParent checks that the collection a target would be created in
+// exists, writing 409 Conflict when it does not
+func davStatParent(w http.ResponseWriter, r *http.Request, t davTarget) bool {
+	parent, err := davStat(r.Context(), t.parent())
+	if err != nil {
+		http.Error(w, "Error reading storage", http.StatusInternalServerError)
+		log.Printf("Error describing %s: %v", t.parent().path(true), err)
+		return false
+	}
+	if parent == nil || !parent.Collection {
+		http.Error(w, "Parent collection does not exist", http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// davPut uploads a file, streaming the body to storage
+func davPut(w http.ResponseWriter, r *http.Request, t davTarget) {
+	if t.key == "" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	unlock := minioClient.LockObject(t.bucket, t.key)
+	defer unlock()
+
+	existing, err := davStat(r.Context(), t)
+	if err != nil {
+		http.Error(w, "Error reading storage", http.StatusInternalServerError)
+		log.Printf("Error describing %s: %v", t.path(false), err)
+		return
+	}
+	if existing != nil && existing.Collection {
+		http.Error(w, "Cannot replace a collection", http.StatusMethodNotAllowed)
+		return
+	}
+	if existing == nil && !davStatParent(w, r, t) {
+		return
+	}
+
+	// Finder streams uploads in chunks and sends the length separately
+	size := r.ContentLength
+	if size < 0 {
+		if expected, err := strconv.ParseInt(r.Header.Get("X-Expected-Entity-Length"), 10, 64); err == nil {
+			size = expected
+		}
+	}
+	if _, err := minioClient.PutObject(r.Context(), t.bucket, t.key, r.Body, size, t.contentType()); err != nil {
+		http.Error(w, "Error storing file", http.StatusInternalServerError)
+		log.Printf("Error uploading %s: %v", t.path(false), err)
+		return
+	}
+	davChanged(t.bucket)
+	if existing == nil {
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// davMkcol creates a collection as an empty marker object named after the
+// prefix
+func davMkcol(w http.ResponseWriter, r *http.Request, t davTarget) {
+	if r.ContentLength > 0 {
+		http.Error(w, "MKCOL bodies are not supported", http.StatusUnsupportedMediaType)
+		return
+	}
+	if t.key == "" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	existing, err := davStat(r.Context(), t)
+	if err != nil {
+		http.Error(w, "Error reading storage", http.StatusInternalServerError)
+		log.Printf("Error describing %s: %v", t.path(false), err)
+		return
+	}
+	if existing != nil {
+		http.Error(w, "Resource already exists", http.StatusMethodNotAllowed)
+		return
+	}
+	if !davStatParent(w, r, t) {
+		return
+	}
+	if err := davMakeCollection(r.Context(), t); err != nil {
+		http.Error(w, "Error creating collection", http.StatusInternalServerError)
+		log.Printf("Error creating %s: %v", t.path(true), err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// davMakeCollection stores the marker object of a collection
+func davMakeCollection(ctx context.Context, t davTarget) error {
+	_, err := minioClient.PutObject(ctx, t.bucket, t.prefix(), strings.NewReader(""), 0, "application/x-directory")
+	return err
+}
+
+// davDelete removes a file, or a collection with everything below it
+func davDelete(w http.ResponseWriter, r *http.Request, t davTarget) {
+	if t.key == "" {
+		http.Error(w, "Buckets cannot be deleted", http.StatusForbidden)
+		return
+	}
+	res, err := davStat(r.Context(), t)
+	if err != nil {
+		http.Error(w, "Error reading storage", http.StatusInternalServerError)
+		log.Printf("Error describing %s: %v", t.path(false), err)
+		return
+	}
+	if res == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if err := davRemove(r.Context(), t, res.Collection); err != nil {
+		http.Error(w, "Error deleting resource", http.StatusInternalServerError)
+		log.Printf("Error deleting %s: %v", res.Path, err)
+		return
+	}
+	davChanged(t.bucket)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// davRemove deletes the object of a file, or every object below a
+// collection's prefix including its marker
+func davRemove(ctx context.Context, t davTarget, collection bool) error {
+	if !collection {
+		return davRemoveObject(ctx, t.bucket, t.key)
+	}
+	for object := range minioClient.ListPrefix(ctx, t.bucket, t.prefix(), true) {
+		if object.Err != nil {
+			return object.Err
+		}
+		if err := davRemoveObject(ctx, t.bucket, object.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// davRemoveObject deletes an object once no other request is writing it
+func davRemoveObject(ctx context.Context, bucket, key string) error {
+	unlock := minioClient.LockObject(bucket, key)
+	defer unlock()
+	return minioClient.RemoveObject(ctx, bucket, key)
+}
+
+// davCopyObject copies an object once no other request is writing the
+// destination
+func davCopyObject(ctx context.Context, dstBucket, dstKey, srcBucket, srcKey string) error {
+	unlock := minioClient.LockObject(dstBucket, dstKey)
+	defer unlock()
+	_, err := minioClient.CopyObject(ctx, dstBucket, dstKey, srcBucket, srcKey)
+	return err
+}
+
+// davCopy copies or moves a resource to the Destination header, server-side
+// and across buckets if need be. Moves copy and then delete the source.
+func davCopy(w http.ResponseWriter, r *http.Request, src davTarget, move bool) {
+	depth, err := webdav.ParseDepth(r.Header.Get("Depth"), webdav.DepthInfinity)
+	if err != nil || (move && depth != webdav.DepthInfinity) || depth == webdav.Depth1 {
+		http.Error(w, "Invalid Depth header", http.StatusBadRequest)
+		return
+	}
+	destination, err := webdav.ParseDestination(r)
+	if err != nil {
+		http.Error(w, "Invalid Destination header", http.StatusBadRequest)
+		return
+	}
+	dst, ok := parseDAVPath(destination)
+	if !ok || src.key == "" || dst.key == "" {
+		http.Error(w, "Buckets cannot be copied or replaced", http.StatusForbidden)
+		return
+	}
+
+	res, err := davStat(r.Context(), src)
+	if err != nil {
+		http.Error(w, "Error reading storage", http.StatusInternalServerError)
+		log.Printf("Error describing %s: %v", src.path(false), err)
+		return
+	}
+	if res == nil {
+		http.NotFound(w, r)
+		return
+	}
+	// Neither may contain the other, or replacing the destination would
+	// destroy the source
+	if src.bucket == dst.bucket && (strings.HasPrefix(src.key+"/", dst.key+"/") || strings.HasPrefix(dst.key+"/", src.key+"/")) {
+		http.Error(w, "Source and destination overlap", http.StatusForbidden)
+		return
+	}
+
+	existing, err := davStat(r.Context(), dst)
+	if err != nil {
+		http.Error(w, "Error reading storage", http.StatusInternalServerError)
+		log.Printf("Error describing %s: %v", dst.path(false), err)
+		return
+	}
+	if existing != nil {
+		if !webdav.Overwrite(r) {
+			http.Error(w, "Destination exists", http.StatusPreconditionFailed)
+			return
+		}
+		if err := davRemove(r.Context(), dst, existing.Collection); err != nil {
+			http.Error(w, "Error replacing destination", http.StatusInternalServerError)
+			log.Printf("Error deleting %s: %v", existing.Path, err)
+			return
+		}
+	} else if !davStatParent(w, r, dst) {
+		return
+	}
+
+	if err := davCopyObjects(r.Context(), src, dst, res.Collection, depth == webdav.DepthInfinity); err != nil {
+		http.Error(w, "Error copying resource", http.StatusInternalServerError)
+		log.Printf("Error copying %s to %s: %v", src.path(res.Collection), dst.path(res.Collection), err)
+		return
+	}
+	if move {
+		if err := davRemove(r.Context(), src, res.Collection); err != nil {
+			http.Error(w, "Error removing source", http.StatusInternalServerError)
+			log.Printf("Error deleting %s: %v", res.Path, err)
+			return
+		}
+		davChanged(src.bucket)
+	}
+	davChanged(dst.bucket)
+
+	if existing == nil {
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// davCopyObjects copies a file, or a collection's marker and, unless
+// shallow, every object below it
+func davCopyObjects(ctx context.Context, src, dst davTarget, collection, recursive bool) error {
+	if !collection {
+		return davCopyObject(ctx, dst.bucket, dst.key, src.bucket, src.key)
+	}
+	if err := davMakeCollection(ctx, dst); err != nil {
+		return err
+	}
+	if !recursive {
+		return nil
+	}
+	for object := range minioClient.ListPrefix(ctx, src.bucket, src.prefix(), true) {
+		if object.Err != nil {
+			return object.Err
+		}
+		rel := strings.TrimPrefix(object.Key, src.prefix())
+		if rel == "" {
+			continue
+		}
+		if err := davCopyObject(ctx, dst.bucket, dst.prefix()+rel, src.bucket, object.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// davProppatch pretends to store the properties a client sets, such as the
+// timestamps Windows Explorer writes after every upload
+func davProppatch(w http.ResponseWriter, r *http.Request, t davTarget) {
+	res, err := davStat(r.Context(), t)
+	if err != nil {
+		http.Error(w, "Error reading storage", http.StatusInternalServerError)
+		log.Printf("Error describing %s: %v", t.path(false), err)
+		return
+	}
+	if res == nil {
+		http.NotFound(w, r)
+		return
+	}
+	names, err := webdav.ReadProppatch(r)
+	if err != nil {
+		http.Error(w, "Invalid PROPPATCH body", http.StatusBadRequest)
+		return
+	}
+	ms := webdav.NewMultistatus()
+	ms.AddProppatch(res.Path, names)
+	ms.WriteTo(w)
+}
+
+// davLock hands out a lock token. Locks are not enforced; clients such as
+// Finder and Office only need one before writing. Locking an unmapped path
+// creates an empty file there, as RFC 4918 asks.
+func davLock(w http.ResponseWriter, r *http.Request, t davTarget) {
+	if t.key != "" {
+		unlock := minioClient.LockObject(t.bucket, t.key)
+		defer unlock()
+	}
+	res, err := davStat(r.Context(), t)
+	if err != nil {
+		http.Error(w, "Error reading storage", http.StatusInternalServerError)
+		log.Printf("Error describing %s: %v", t.path(false), err)
+		return
+	}
+	created := false
+	if res == nil {
+		if !davStatParent(w, r, t) {
+			return
+		}
+		if _, err := minioClient.PutObject(r.Context(), t.bucket, t.key, strings.NewReader(""), 0, t.contentType()); err != nil {
+			http.Error(w, "Error creating file", http.StatusInternalServerError)
+			log.Printf("Error creating %s: %v", t.path(false), err)
+			return
+		}
+		created = true
+	}
+	webdav.WriteLock(w, r, t.path(res != nil && res.Collection), created)
+}
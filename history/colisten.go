@@ -0,0 +1,123 @@
+package history
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+const (
+	// coListenWindow is how close two plays of a user must be to count as
+	// listened together
+	coListenWindow = 30 * time.Minute
+	// coListenMonths is how far back co-listening is taken from
+	coListenMonths = 12
+)
+
+// coListens counts, for each pair of tracks, how often they were played
+// together by any user. It is derived from the listens on first use and
+// kept up to date by Submit; nil until built.
+var coListens map[string]map[string]int
+
+// CoListened returns how often each track was played together with any of
+// trackIDs, across all users
+func CoListened(ctx context.Context, trackIDs ...string) (map[string]int, error) {
+	if err := buildCoListens(ctx); err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	result := map[string]int{}
+	for _, id := range trackIDs {
+		for other, n := range coListens[id] {
+			result[other] += n
+		}
+	}
+	return result, nil
+}
+
+// buildCoListens loads the recent months of every user and counts the
+// pairs in them
+func buildCoListens(ctx context.Context) error {
+	mu.Lock()
+	built := coListens != nil
+	type userMonth struct{ user, month string }
+	var pending []userMonth
+	cutoff := monthOf(time.Now().AddDate(0, -coListenMonths, 0))
+	for user, byMonth := range months {
+		for month := range byMonth {
+			if month >= cutoff {
+				pending = append(pending, userMonth{user, month})
+			}
+		}
+	}
+	mu.Unlock()
+	if built {
+		return nil
+	}
+
+	for _, p := range pending {
+		if _, err := loadMonth(ctx, p.user, p.month); err != nil {
+			return err
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if coListens != nil {
+		return nil
+	}
+	coListens = map[string]map[string]int{}
+	for _, byMonth := range months {
+		var listens []Listen
+		for month, m := range byMonth {
+			if m != nil && month >= cutoff {
+				listens = append(listens, m.listens...)
+			}
+		}
+		sort.Slice(listens, func(i, j int) bool { return listens[i].Time.Before(listens[j].Time) })
+		for i, l := range listens {
+			if l.Skipped {
+				continue
+			}
+			for _, next := range listens[i+1:] {
+				if next.Time.Sub(l.Time) > coListenWindow {
+					break
+				}
+				if !next.Skipped {
+					pairListens(l.TrackID, next.TrackID)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// coListen counts a new listen together with its neighbours in the month.
+// mu must be held.
+func coListen(m *monthLog, l Listen) {
+	if coListens == nil || l.Skipped {
+		return
+	}
+	for _, other := range m.listens {
+		if other.ID == l.ID || other.Skipped {
+			continue
+		}
+		if d := other.Time.Sub(l.Time); d <= coListenWindow && d >= -coListenWindow {
+			pairListens(l.TrackID, other.TrackID)
+		}
+	}
+}
+
+// pairListens counts two tracks played together. mu must be held.
+func pairListens(a, b string) {
+	if a == b {
+		return
+	}
+	for _, p := range [][2]string{{a, b}, {b, a}} {
+		if coListens[p[0]] == nil {
+			coListens[p[0]] = map[string]int{}
+		}
+		coListens[p[0]][p[1]]++
+	}
+}
@@ -0,0 +1,48 @@
+package history
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCoListened(t *testing.T) {
+	resetHistory(t)
+	start := time.Now().UTC().Add(-24 * time.Hour).Truncate(time.Second)
+	addListens("alice",
+		Listen{ID: "a", TrackID: "one", Time: start},
+		Listen{ID: "b", TrackID: "two", Time: start.Add(10 * time.Minute)},
+		Listen{ID: "c", TrackID: "skip", Time: start.Add(15 * time.Minute), Skipped: true},
+		Listen{ID: "d", TrackID: "three", Time: start.Add(35 * time.Minute)},
+		Listen{ID: "e", TrackID: "one", Time: start.Add(2 * time.Hour)},
+	)
+	addListens("bob",
+		Listen{ID: "f", TrackID: "three", Time: start},
+		Listen{ID: "g", TrackID: "one", Time: start.Add(time.Minute)},
+	)
+	addListens("carol", Listen{ID: "h", TrackID: "two", Time: time.Now().AddDate(-2, 0, 0)},
+		Listen{ID: "i", TrackID: "one", Time: time.Now().AddDate(-2, 0, 0)})
+
+	ctx := context.Background()
+	got, err := CoListened(ctx, "one")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"two": 1, "three": 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("CoListened(one) = %v, want %v", got, want)
+	}
+	if got, _ := CoListened(ctx, "two", "three"); !reflect.DeepEqual(got, map[string]int{"one": 2, "two": 1, "three": 1}) {
+		t.Errorf("CoListened(two, three) = %v", got)
+	}
+
+	// New listens are paired with their neighbours once the counts exist
+	l := Listen{ID: "j", TrackID: "four", Time: start.Add(2*time.Hour + time.Minute)}
+	addListens("alice", l)
+	mu.Lock()
+	coListen(months["alice"][monthOf(l.Time)], l)
+	mu.Unlock()
+	if got, _ := CoListened(ctx, "four"); !reflect.DeepEqual(got, map[string]int{"one": 1}) {
+		t.Errorf("CoListened(four) = %v", got)
+	}
+}
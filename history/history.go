@@ -0,0 +1,560 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"MediaBackend/library"
+	minioClient "MediaBackend/minio"
+	"MediaBackend/randid"
+)
+
+// Keys of the history documents in the meta bucket. Listens are stored in
+// one document per user and month.
+const (
+	statsKey      = "history/stats.json"
+	listensPrefix = "history/listens/"
+)
+
+// saveDelay batches the saves of a burst of submissions
+const saveDelay = 2 * time.Second
+
+const (
+	// maxPlayThreshold is how many seconds count as a play at most. As
+	// with Last.fm and ListenBrainz, a listen of half of a shorter track
+	// is enough.
+	maxPlayThreshold = 4 * 60
+	// futureTolerance allows for clocks of clients running ahead
+	futureTolerance = 5 * time.Minute
+	// nowPlayingTimeout ends now playing for tracks of unknown duration
+	nowPlayingTimeout = 10 * time.Minute
+)
+
+// Submission types
+const (
+	TypeListen     = "listen"
+	TypeSkip       = "skip"
+	TypeNowPlaying = "nowPlaying"
+)
+
+// ErrInvalid wraps invalid submissions and queries
+var ErrInvalid = errors.New("invalid request")
+
+// Listen is a play or skip of a track. The tags are recorded with it, so
+// that history stays readable after the track leaves the library.
+type Listen struct {
+	ID      string    `json:"id"`
+	TrackID string    `json:"trackId"`
+	Time    time.Time `json:"time"`
+	// Played is the number of seconds listened, 0 when not reported
+	Played  float64 `json:"played,omitempty"`
+	Skipped bool    `json:"skipped,omitempty"`
+	Client  string  `json:"client,omitempty"`
+
+	Title       string  `json:"title,omitempty"`
+	Artist      string  `json:"artist,omitempty"`
+	Album       string  `json:"album,omitempty"`
+	AlbumArtist string  `json:"albumArtist,omitempty"`
+	Genre       string  `json:"genre,omitempty"`
+	Duration    float64 `json:"duration,omitempty"`
+}
+
+// TrackStats are the play statistics of a track for a user
+type TrackStats struct {
+	PlayCount   int       `json:"playCount"`
+	SkipCount   int       `json:"skipCount"`
+	LastPlayed  time.Time `json:"lastPlayed,omitzero"`
+	LastSkipped time.Time `json:"lastSkipped,omitzero"`
+}
+
+// NowPlaying is the track a user is currently listening to
+type NowPlaying struct {
+	TrackID string        `json:"trackId"`
+	Track   library.Track `json:"track"`
+	Started time.Time     `json:"started"`
+	Client  string        `json:"client,omitempty"`
+}
+
+// Submission is a scrobble sent by a client
+type Submission struct {
+	// Type is "listen" (the default), "skip" or "nowPlaying"
+	Type    string `json:"type"`
+	TrackID string `json:"trackId"`
+	// Time is when playback started, defaulting to now
+	Time time.Time `json:"time"`
+	// Played is the number of seconds listened; a listen shorter than the
+	// play threshold counts as a skip
+	Played *float64 `json:"played"`
+	Client string   `json:"client"`
+}
+
+// statsDocument is the persisted form of the play statistics
+type statsDocument struct {
+	Users map[string]map[string]*TrackStats `json:"users"`
+}
+
+// monthDocument is the persisted form of a month of listens
+type monthDocument struct {
+	Listens []Listen `json:"listens"`
+}
+
+// monthLog holds the listens of a user in a month, oldest first and by ID
+// within the same second
+type monthLog struct {
+	listens []Listen
+	dirty   bool
+}
+
+var (
+	mu    sync.Mutex
+	stats = map[string]map[string]*TrackStats{}
+	// months maps users to the months with listens, loaded or not
+	months     = map[string]map[string]*monthLog{}
+	nowPlaying = map[string]NowPlaying{}
+	statsDirty bool
+	saver      = minioClient.NewSaver("listening history", saveDelay, saveDocuments)
+)
+
+// Start loads the play statistics and the list of stored months, and starts
+// the ListenBrainz forwarder when it is configured
+func Start(ctx context.Context) error {
+	if err := load(ctx); err != nil {
+		return err
+	}
+	startForwarder(ctx)
+	return nil
+}
+
+func load(ctx context.Context) error {
+	var doc statsDocument
+	if err := minioClient.LoadJSON(ctx, statsKey, &doc); err != nil && !errors.Is(err, minioClient.ErrNotFound) {
+		return err
+	}
+	keys, err := minioClient.ListJSON(ctx, listensPrefix)
+	if err != nil {
+		return err
+	}
+	rollupKeys, err := minioClient.ListJSON(ctx, rollupsPrefix)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if doc.Users != nil {
+		stats = doc.Users
+	}
+	total := 0
+	for _, key := range keys {
+		user, month, ok := parseDocumentKey(key, listensPrefix)
+		if !ok {
+			continue
+		}
+		if months[user] == nil {
+			months[user] = map[string]*monthLog{}
+		}
+		// nil until loaded
+		months[user][month] = nil
+		total++
+	}
+	for _, key := range rollupKeys {
+		user, year, ok := parseDocumentKey(key, rollupsPrefix)
+		if !ok {
+			continue
+		}
+		if rollups[user] == nil {
+			rollups[user] = map[string]*yearRollup{}
+		}
+		rollups[user][year] = nil
+	}
+	log.Printf("✓ Loaded listening history (%d users, %d months)", len(stats), total)
+	return nil
+}
+
+// monthOf returns the key of the month of t, e.g. "2024-03"
+func monthOf(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+func monthKey(user, month string) string {
+	return listensPrefix + url.PathEscape(user) + "/" + month + ".json"
+}
+
+// parseDocumentKey splits the key of a per-user document into the user
+// and the period, e.g. the month of a listens document
+func parseDocumentKey(key, prefix string) (user, period string, ok bool) {
+	rest, ok := strings.CutPrefix(key, prefix)
+	if !ok {
+		return "", "", false
+	}
+	escaped, file, ok := strings.Cut(rest, "/")
+	if !ok || !strings.HasSuffix(file, ".json") {
+		return "", "", false
+	}
+	user, err := url.PathUnescape(escaped)
+	if err != nil {
+		return "", "", false
+	}
+	return user, strings.TrimSuffix(file, ".json"), true
+}
+
+// loadMonth returns the listens of a user in a month, reading them from the
+// meta bucket the first time. mu must not be held.
+func loadMonth(ctx context.Context, user, month string) (*monthLog, error) {
+	mu.Lock()
+	m, known := months[user][month]
+	mu.Unlock()
+	if m != nil {
+		return m, nil
+	}
+
+	loaded := &monthLog{}
+	if known {
+		var doc monthDocument
+		if err := minioClient.LoadJSON(ctx, monthKey(user, month), &doc); err != nil && !errors.Is(err, minioClient.ErrNotFound) {
+			return nil, fmt.Errorf("loading listens of %s: %w", month, err)
+		}
+		loaded.listens = doc.Listens
+		sort.Slice(loaded.listens, func(i, j int) bool {
+			return listenBefore(loaded.listens[i], loaded.listens[j].Time, loaded.listens[j].ID)
+		})
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if months[user] == nil {
+		months[user] = map[string]*monthLog{}
+	}
+	// Another request may have loaded it meanwhile
+	if m := months[user][month]; m != nil {
+		return m, nil
+	}
+	months[user][month] = loaded
+	return loaded, nil
+}
+
+// Submit records a scrobble for a user. Listens and skips are added to the
+// history; a listen repeating the track and start time of an earlier one is
+// ignored, so clients can safely resubmit. nowPlaying only updates what the
+// user is listening to.
+func Submit(ctx context.Context, user string, s Submission) (*Listen, error) {
+	track, ok := library.Get(s.TrackID)
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown track %q", ErrInvalid, s.TrackID)
+	}
+	now := time.Now().UTC()
+	if s.Time.IsZero() {
+		s.Time = now
+	}
+	if s.Time.After(now.Add(futureTolerance)) {
+		return nil, fmt.Errorf("%w: time is in the future", ErrInvalid)
+	}
+	if s.Played != nil && *s.Played < 0 {
+		return nil, fmt.Errorf("%w: played must not be negative", ErrInvalid)
+	}
+
+	switch s.Type {
+	case TypeNowPlaying:
+		setNowPlaying(user, track, s.Time.UTC(), s.Client)
+		return nil, nil
+	case TypeListen, TypeSkip, "":
+	default:
+		return nil, fmt.Errorf("%w: unknown type %q", ErrInvalid, s.Type)
+	}
+
+	listen := Listen{
+		ID:          randid.Hex(8),
+		TrackID:     track.ID,
+		Time:        s.Time.UTC().Truncate(time.Second),
+		Skipped:     s.Type == TypeSkip,
+		Client:      s.Client,
+		Title:       track.Title,
+		Artist:      track.Artist,
+		Album:       track.Album,
+		AlbumArtist: track.AlbumArtist,
+		Genre:       track.Genre,
+		Duration:    track.Duration,
+	}
+	if s.Played != nil {
+		listen.Played = *s.Played
+		if track.Duration > 0 && listen.Played < min(track.Duration/2, maxPlayThreshold) {
+			listen.Skipped = true
+		}
+	}
+
+	m, err := loadMonth(ctx, user, monthOf(listen.Time))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := loadRollup(ctx, user, listen.Time.Format("2006")); err != nil {
+		return nil, err
+	}
+
+	mu.Lock()
+	for _, existing := range m.listens {
+		if existing.TrackID == listen.TrackID && existing.Time.Equal(listen.Time) {
+			mu.Unlock()
+			return &existing, nil
+		}
+	}
+	i := sort.Search(len(m.listens), func(i int) bool { return listenBefore(listen, m.listens[i].Time, m.listens[i].ID) })
+	m.listens = append(m.listens, Listen{})
+	copy(m.listens[i+1:], m.listens[i:])
+	m.listens[i] = listen
+	m.dirty = true
+	countListen(user, listen)
+	rollUp(user, listen)
+	coListen(m, listen)
+	if np, ok := nowPlaying[user]; ok && np.TrackID == listen.TrackID && !listen.Time.Before(np.Started.Truncate(time.Second)) {
+		delete(nowPlaying, user)
+	}
+	mu.Unlock()
+
+	saver.Schedule()
+	if !listen.Skipped {
+		forwardListen(user, listen)
+	}
+	return &listen, nil
+}
+
+// countListen adds a listen to the play statistics. mu must be held.
+func countListen(user string, l Listen) {
+	if stats[user] == nil {
+		stats[user] = map[string]*TrackStats{}
+	}
+	s := stats[user][l.TrackID]
+	if s == nil {
+		s = &TrackStats{}
+		stats[user][l.TrackID] = s
+	}
+	if l.Skipped {
+		s.SkipCount++
+		if l.Time.After(s.LastSkipped) {
+			s.LastSkipped = l.Time
+		}
+	} else {
+		s.PlayCount++
+		if l.Time.After(s.LastPlayed) {
+			s.LastPlayed = l.Time
+		}
+	}
+	statsDirty = true
+}
+
+func setNowPlaying(user string, track library.Track, started time.Time, client string) {
+	mu.Lock()
+	nowPlaying[user] = NowPlaying{TrackID: track.ID, Track: track, Started: started, Client: client}
+	mu.Unlock()
+	forwardNowPlaying(user, track)
+}
+
+// CurrentlyPlaying returns what a user is listening to, if the track
+// reported as now playing has not ended yet
+func CurrentlyPlaying(user string) (NowPlaying, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	np, ok := nowPlaying[user]
+	if !ok {
+		return NowPlaying{}, false
+	}
+	timeout := nowPlayingTimeout
+	if np.Track.Duration > 0 {
+		timeout = time.Duration(np.Track.Duration * float64(time.Second))
+	}
+	if time.Since(np.Started) > timeout {
+		delete(nowPlaying, user)
+		return NowPlaying{}, false
+	}
+	return np, true
+}
+
+// Stats returns the play statistics of a track for a user
+func Stats(user, trackID string) TrackStats {
+	mu.Lock()
+	defer mu.Unlock()
+	if s := stats[user][trackID]; s != nil {
+		return *s
+	}
+	return TrackStats{}
+}
+
+// AllStats returns the play statistics of every track a user has listened to
+func AllStats(user string) map[string]TrackStats {
+	mu.Lock()
+	defer mu.Unlock()
+	list := make(map[string]TrackStats, len(stats[user]))
+	for id, s := range stats[user] {
+		list[id] = *s
+	}
+	return list
+}
+
+// Counts provides play statistics to smart playlists
+type Counts struct{}
+
+// PlayStats returns how often a user played a track and when last
+func (Counts) PlayStats(user, trackID string) (int, time.Time) {
+	s := Stats(user, trackID)
+	return s.PlayCount, s.LastPlayed
+}
+
+// Query selects listens from the history of a user
+type Query struct {
+	// From and To bound the start time of listens; To is exclusive and
+	// either may be zero
+	From, To time.Time
+	TrackID  string
+	// Skips includes skipped tracks
+	Skips bool
+	Limit int
+	// Cursor continues a previous query from its last result
+	Cursor string
+}
+
+// Listens returns the listens of a user matching q, newest first, and a
+// cursor for the next page when there are more
+func Listens(ctx context.Context, user string, q Query) ([]Listen, string, error) {
+	var afterTime time.Time
+	afterID := ""
+	if q.Cursor != "" {
+		var err error
+		afterTime, afterID, err = parseCursor(q.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	mu.Lock()
+	var keys []string
+	for month := range months[user] {
+		keys = append(keys, month)
+	}
+	mu.Unlock()
+	sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+
+	var result []Listen
+	for _, month := range keys {
+		if !q.From.IsZero() && month < monthOf(q.From) {
+			break
+		}
+		if !q.To.IsZero() && month > monthOf(q.To) {
+			continue
+		}
+		m, err := loadMonth(ctx, user, month)
+		if err != nil {
+			return nil, "", err
+		}
+
+		mu.Lock()
+		for i := len(m.listens) - 1; i >= 0; i-- {
+			l := m.listens[i]
+			switch {
+			case !q.From.IsZero() && l.Time.Before(q.From),
+				!q.To.IsZero() && !l.Time.Before(q.To),
+				q.TrackID != "" && l.TrackID != q.TrackID,
+				l.Skipped && !q.Skips:
+				continue
+			}
+			if afterID != "" && !listenBefore(l, afterTime, afterID) {
+				continue
+			}
+			result = append(result, l)
+			if len(result) > q.Limit {
+				break
+			}
+		}
+		mu.Unlock()
+		if len(result) > q.Limit {
+			break
+		}
+	}
+
+	next := ""
+	if len(result) > q.Limit {
+		result = result[:q.Limit]
+		last := result[len(result)-1]
+		next = fmt.Sprintf("%d-%s", last.Time.UnixNano(), last.ID)
+	}
+	return result, next, nil
+}
+
+// listenBefore reports whether l is older than the listen at t with id,
+// which orders listens of the same second by ID
+func listenBefore(l Listen, t time.Time, id string) bool {
+	if !l.Time.Equal(t) {
+		return l.Time.Before(t)
+	}
+	return l.ID < id
+}
+
+func parseCursor(cursor string) (time.Time, string, error) {
+	nanos, id, ok := strings.Cut(cursor, "-")
+	var n int64
+	if _, err := fmt.Sscanf(nanos, "%d", &n); !ok || err != nil || id == "" {
+		return time.Time{}, "", fmt.Errorf("%w: invalid cursor", ErrInvalid)
+	}
+	return time.Unix(0, n).UTC(), id, nil
+}
+
+// Save writes changed months, rollups and the play statistics to the meta
+// bucket
+func Save(ctx context.Context) error {
+	return saver.Save(ctx)
+}
+
+// saveDocuments writes the documents changed since the last save
+func saveDocuments(ctx context.Context) error {
+	mu.Lock()
+	var changed []minioClient.Change
+	for user, byMonth := range months {
+		for month, m := range byMonth {
+			if m != nil && m.dirty {
+				doc := monthDocument{Listens: append([]Listen(nil), m.listens...)}
+				changed = append(changed, minioClient.Change{Key: monthKey(user, month), Doc: doc, Retry: func() { markDirty(&m.dirty) }})
+				m.dirty = false
+			}
+		}
+	}
+	for user, byYear := range rollups {
+		for year, y := range byYear {
+			if y != nil && y.dirty {
+				doc := rollupDocument{Days: make(map[string]*dayRollup, len(y.days))}
+				for date, d := range y.days {
+					c := d.clone()
+					doc.Days[date] = &c
+				}
+				changed = append(changed, minioClient.Change{Key: rollupKey(user, year), Doc: doc, Retry: func() { markDirty(&y.dirty) }})
+				y.dirty = false
+			}
+		}
+	}
+	if statsDirty {
+		statsDoc := statsDocument{Users: make(map[string]map[string]*TrackStats, len(stats))}
+		for user, byTrack := range stats {
+			copied := make(map[string]*TrackStats, len(byTrack))
+			for id, s := range byTrack {
+				c := *s
+				copied[id] = &c
+			}
+			statsDoc.Users[user] = copied
+		}
+		changed = append(changed, minioClient.Change{Key: statsKey, Doc: statsDoc, Retry: func() { markDirty(&statsDirty) }})
+		statsDirty = false
+	}
+	mu.Unlock()
+	return minioClient.SaveChanges(ctx, changed)
+}
+
+// markDirty sets a changed flag under mu, for saves to retry
+func markDirty(dirty *bool) {
+	mu.Lock()
+	*dirty = true
+	mu.Unlock()
+}
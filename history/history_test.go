@@ -0,0 +1,167 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"MediaBackend/library"
+)
+
+// resetHistory clears the in-memory history when the test ends
+func resetHistory(t *testing.T) {
+	t.Helper()
+	reset := func() {
+		mu.Lock()
+		stats = map[string]map[string]*TrackStats{}
+		months = map[string]map[string]*monthLog{}
+		nowPlaying = map[string]NowPlaying{}
+		rollups = map[string]map[string]*yearRollup{}
+		coListens = nil
+		statsDirty = false
+		mu.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+// addListens stores listens as loaded months, counting and rolling them up
+func addListens(user string, listens ...Listen) {
+	mu.Lock()
+	defer mu.Unlock()
+	if months[user] == nil {
+		months[user] = map[string]*monthLog{}
+		rollups[user] = map[string]*yearRollup{}
+	}
+	for _, l := range listens {
+		month := monthOf(l.Time)
+		m := months[user][month]
+		if m == nil {
+			m = &monthLog{}
+			months[user][month] = m
+		}
+		m.listens = append(m.listens, l)
+		countListen(user, l)
+		if year := l.Time.UTC().Format("2006"); rollups[user][year] == nil {
+			rollups[user][year] = &yearRollup{days: map[string]*dayRollup{}}
+		}
+		rollUp(user, l)
+	}
+}
+
+func TestMonthKey(t *testing.T) {
+	key := monthKey("a/b c", "2024-03")
+	if key != "history/listens/a%2Fb%20c/2024-03.json" {
+		t.Errorf("monthKey = %q", key)
+	}
+	if user, month, ok := parseDocumentKey(key, listensPrefix); !ok || user != "a/b c" || month != "2024-03" {
+		t.Errorf("parseDocumentKey = %q, %q, %v", user, month, ok)
+	}
+	for _, key := range []string{"history/stats.json", "history/listens/alice", "history/listens/alice/2024-03.txt"} {
+		if _, _, ok := parseDocumentKey(key, listensPrefix); ok {
+			t.Errorf("parseDocumentKey(%q) accepted", key)
+		}
+	}
+}
+
+func TestListens(t *testing.T) {
+	resetHistory(t)
+	at := func(day, hour int) time.Time { return time.Date(2024, 3, day, hour, 0, 0, 0, time.UTC) }
+	addListens("alice",
+		Listen{ID: "a", TrackID: "one", Time: at(-1, 12)},
+		Listen{ID: "b", TrackID: "two", Time: at(2, 8)},
+		Listen{ID: "c", TrackID: "one", Time: at(2, 8)},
+		Listen{ID: "d", TrackID: "two", Time: at(5, 20), Skipped: true},
+		Listen{ID: "e", TrackID: "one", Time: at(9, 7)},
+	)
+	addListens("bob", Listen{ID: "x", TrackID: "one", Time: at(3, 1)})
+
+	ids := func(listens []Listen) []string {
+		var ids []string
+		for _, l := range listens {
+			ids = append(ids, l.ID)
+		}
+		return ids
+	}
+	ctx := context.Background()
+
+	var pages [][]string
+	q := Query{Limit: 2}
+	for {
+		listens, next, err := Listens(ctx, "alice", q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, ids(listens))
+		if next == "" {
+			break
+		}
+		q.Cursor = next
+	}
+	if want := [][]string{{"e", "c"}, {"b", "a"}}; !reflect.DeepEqual(pages, want) {
+		t.Errorf("pages = %v, want %v", pages, want)
+	}
+
+	tests := []struct {
+		q    Query
+		want []string
+	}{
+		{Query{Limit: 10, Skips: true}, []string{"e", "d", "c", "b", "a"}},
+		{Query{Limit: 10, TrackID: "one"}, []string{"e", "c", "a"}},
+		{Query{Limit: 10, From: at(1, 0), To: at(9, 0), Skips: true}, []string{"d", "c", "b"}},
+	}
+	for _, tt := range tests {
+		listens, next, err := Listens(ctx, "alice", tt.q)
+		if err != nil || next != "" || !reflect.DeepEqual(ids(listens), tt.want) {
+			t.Errorf("Listens(%+v) = %v, %q, %v, want %v", tt.q, ids(listens), next, err, tt.want)
+		}
+	}
+
+	if _, _, err := Listens(ctx, "alice", Query{Limit: 1, Cursor: "garbage"}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("invalid cursor: %v", err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	resetHistory(t)
+	first := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	addListens("alice",
+		Listen{ID: "a", TrackID: "one", Time: first.Add(time.Hour)},
+		Listen{ID: "b", TrackID: "one", Time: first},
+		Listen{ID: "c", TrackID: "one", Time: first.Add(2 * time.Hour), Skipped: true},
+	)
+	want := TrackStats{PlayCount: 2, SkipCount: 1, LastPlayed: first.Add(time.Hour), LastSkipped: first.Add(2 * time.Hour)}
+	if s := Stats("alice", "one"); s != want {
+		t.Errorf("Stats = %+v, want %+v", s, want)
+	}
+	if s := Stats("bob", "one"); s != (TrackStats{}) {
+		t.Errorf("Stats of another user = %+v", s)
+	}
+	if count, last := (Counts{}).PlayStats("alice", "one"); count != 2 || !last.Equal(want.LastPlayed) {
+		t.Errorf("PlayStats = %d, %v", count, last)
+	}
+}
+
+func TestCurrentlyPlaying(t *testing.T) {
+	resetHistory(t)
+	mu.Lock()
+	nowPlaying["alice"] = NowPlaying{TrackID: "one", Track: library.Track{Duration: 60}, Started: time.Now().Add(-30 * time.Second)}
+	nowPlaying["bob"] = NowPlaying{TrackID: "two", Track: library.Track{Duration: 60}, Started: time.Now().Add(-2 * time.Minute)}
+	nowPlaying["carol"] = NowPlaying{TrackID: "three", Started: time.Now().Add(-5 * time.Minute)}
+	mu.Unlock()
+
+	for user, want := range map[string]bool{"alice": true, "bob": false, "carol": true, "dave": false} {
+		if _, ok := CurrentlyPlaying(user); ok != want {
+			t.Errorf("CurrentlyPlaying(%s) = %v, want %v", user, ok, want)
+		}
+	}
+}
+
+func TestParseTokens(t *testing.T) {
+	got := parseTokens(" alice:abc , bob: ,:x,carol:d:e,")
+	if want := map[string]string{"alice": "abc", "carol": "d:e"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("parseTokens = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,306 @@
+package history
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"MediaBackend/env"
+	"MediaBackend/library"
+	minioClient "MediaBackend/minio"
+)
+
+// queueKey is the meta bucket object holding listens waiting to be forwarded
+const queueKey = "history/listenbrainz-queue.json"
+
+const (
+	// maxQueueLength bounds the retry queue; the oldest listens are dropped
+	maxQueueLength = 10000
+	// maxBatchSize is the number of listens ListenBrainz accepts per request
+	maxBatchSize = 100
+	// retryDelay grows exponentially up to maxRetryDelay
+	retryDelay    = time.Minute
+	maxRetryDelay = time.Hour
+)
+
+// ListenBrainz forwarding is configured by LISTENBRAINZ_TOKENS as
+// "user:token" pairs. LISTENBRAINZ_URL selects a compatible server.
+var (
+	listenBrainzURL    = strings.TrimSuffix(env.Or("LISTENBRAINZ_URL", "https://api.listenbrainz.org"), "/")
+	listenBrainzTokens = parseTokens(os.Getenv("LISTENBRAINZ_TOKENS"))
+	listenBrainzClient = &http.Client{Timeout: 15 * time.Second}
+)
+
+// queuedListen is a listen waiting to be forwarded
+type queuedListen struct {
+	User        string    `json:"user"`
+	Listen      Listen    `json:"listen"`
+	Attempts    int       `json:"attempts,omitempty"`
+	NextAttempt time.Time `json:"nextAttempt,omitzero"`
+}
+
+var (
+	queueMu    sync.Mutex
+	queue      []queuedListen
+	queueDirty bool
+	// forwarding is set once the forwarder runs
+	forwarding bool
+	wake       = make(chan struct{}, 1)
+)
+
+// permanentError is a rejection that retrying will not fix
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+
+// parseTokens parses "user:token,user:token"
+func parseTokens(spec string) map[string]string {
+	tokens := map[string]string{}
+	for _, entry := range strings.Split(spec, ",") {
+		user, token, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if ok && user != "" && token != "" {
+			tokens[user] = token
+		}
+	}
+	return tokens
+}
+
+// startForwarder loads the retry queue and forwards listens in the
+// background while ctx is alive
+func startForwarder(ctx context.Context) {
+	if len(listenBrainzTokens) == 0 {
+		return
+	}
+	var saved []queuedListen
+	if err := minioClient.LoadJSON(ctx, queueKey, &saved); err != nil && !errors.Is(err, minioClient.ErrNotFound) {
+		log.Printf("⚠️  Loading ListenBrainz queue failed: %v", err)
+	}
+	queueMu.Lock()
+	queue = append(saved, queue...)
+	forwarding = true
+	queueMu.Unlock()
+
+	log.Printf("✓ Forwarding listens to %s for %d users (%d queued)", listenBrainzURL, len(listenBrainzTokens), len(saved))
+	go runForwarder(ctx)
+	signalForwarder()
+}
+
+func signalForwarder() {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// forwardListen queues a listen for users with a ListenBrainz token.
+// Listens without artist or title cannot be submitted.
+func forwardListen(user string, l Listen) {
+	if listenBrainzTokens[user] == "" || l.Artist == "" || l.Title == "" {
+		return
+	}
+	queueMu.Lock()
+	if !forwarding {
+		queueMu.Unlock()
+		return
+	}
+	queue = append(queue, queuedListen{User: user, Listen: l})
+	if len(queue) > maxQueueLength {
+		log.Printf("ListenBrainz queue full, dropping %d listens", len(queue)-maxQueueLength)
+		queue = queue[len(queue)-maxQueueLength:]
+	}
+	queueDirty = true
+	queueMu.Unlock()
+	signalForwarder()
+}
+
+// forwardNowPlaying reports the current track once, without retrying
+func forwardNowPlaying(user string, track library.Track) {
+	token := listenBrainzTokens[user]
+	if token == "" || track.Artist == "" || track.Title == "" {
+		return
+	}
+	listen := Listen{Title: track.Title, Artist: track.Artist, Album: track.Album, Duration: track.Duration}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), listenBrainzClient.Timeout)
+		defer cancel()
+		if err := submitListens(ctx, token, "playing_now", []Listen{listen}); err != nil {
+			log.Printf("Error sending now playing to ListenBrainz: %v", err)
+		}
+	}()
+}
+
+func runForwarder(ctx context.Context) {
+	for {
+		next := forwardDue(ctx)
+		saveQueue(ctx)
+
+		wait := time.Until(next)
+		if next.IsZero() {
+			wait = maxRetryDelay
+		}
+		timer := time.NewTimer(max(wait, time.Second))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// forwardDue submits the queued listens that are due, a batch per user, and
+// returns when the next retry is due, or zero when the queue is empty
+func forwardDue(ctx context.Context) time.Time {
+	now := time.Now()
+	queueMu.Lock()
+	batches := map[string][]queuedListen{}
+	for _, q := range queue {
+		if !q.NextAttempt.After(now) && len(batches[q.User]) < maxBatchSize {
+			batches[q.User] = append(batches[q.User], q)
+		}
+	}
+	queueMu.Unlock()
+
+	// Outcome of each forwarded listen by user and listen ID
+	sent := map[string]bool{}
+	failed := map[string]bool{}
+	for user, batch := range batches {
+		listens := make([]Listen, len(batch))
+		for i, q := range batch {
+			listens[i] = q.Listen
+		}
+		listenType := "single"
+		if len(listens) > 1 {
+			listenType = "import"
+		}
+		err := submitListens(ctx, listenBrainzTokens[user], listenType, listens)
+		var permanent permanentError
+		switch {
+		case err == nil:
+		case errors.As(err, &permanent):
+			log.Printf("ListenBrainz rejected %d listens of %s, dropping them: %v", len(batch), user, err)
+		default:
+			log.Printf("Error forwarding %d listens of %s to ListenBrainz, will retry: %v", len(batch), user, err)
+			for _, q := range batch {
+				failed[user+"/"+q.Listen.ID] = true
+			}
+			continue
+		}
+		for _, q := range batch {
+			sent[user+"/"+q.Listen.ID] = true
+		}
+	}
+
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	var next time.Time
+	kept := queue[:0]
+	for _, q := range queue {
+		key := q.User + "/" + q.Listen.ID
+		if sent[key] {
+			queueDirty = true
+			continue
+		}
+		if failed[key] {
+			q.Attempts++
+			q.NextAttempt = now.Add(min(retryDelay<<min(q.Attempts-1, 6), maxRetryDelay))
+			queueDirty = true
+		}
+		if listenBrainzTokens[q.User] == "" {
+			queueDirty = true
+			continue
+		}
+		if next.IsZero() || q.NextAttempt.Before(next) {
+			next = q.NextAttempt
+		}
+		kept = append(kept, q)
+	}
+	queue = kept
+	return next
+}
+
+func saveQueue(ctx context.Context) {
+	queueMu.Lock()
+	if !queueDirty {
+		queueMu.Unlock()
+		return
+	}
+	saved := append([]queuedListen{}, queue...)
+	queueDirty = false
+	queueMu.Unlock()
+
+	if err := minioClient.SaveJSON(ctx, queueKey, saved); err != nil {
+		log.Printf("Error saving ListenBrainz queue: %v", err)
+		queueMu.Lock()
+		queueDirty = true
+		queueMu.Unlock()
+	}
+}
+
+// listenBrainzListen is a listen in the ListenBrainz submission format
+type listenBrainzListen struct {
+	ListenedAt    int64 `json:"listened_at,omitempty"`
+	TrackMetadata struct {
+		ArtistName     string         `json:"artist_name"`
+		TrackName      string         `json:"track_name"`
+		ReleaseName    string         `json:"release_name,omitempty"`
+		AdditionalInfo map[string]any `json:"additional_info,omitempty"`
+	} `json:"track_metadata"`
+}
+
+// submitListens posts listens to /1/submit-listens. Rejections other than
+// rate limiting are returned as permanentError.
+func submitListens(ctx context.Context, token, listenType string, listens []Listen) error {
+	payload := make([]listenBrainzListen, len(listens))
+	for i, l := range listens {
+		p := &payload[i]
+		if listenType != "playing_now" {
+			p.ListenedAt = l.Time.Unix()
+		}
+		p.TrackMetadata.ArtistName = l.Artist
+		p.TrackMetadata.TrackName = l.Title
+		p.TrackMetadata.ReleaseName = l.Album
+		p.TrackMetadata.AdditionalInfo = map[string]any{"submission_client": "MediaBackend"}
+		if l.Duration > 0 {
+			p.TrackMetadata.AdditionalInfo["duration_ms"] = int64(l.Duration * 1000)
+		}
+		if l.Client != "" {
+			p.TrackMetadata.AdditionalInfo["media_player"] = l.Client
+		}
+	}
+	body, err := json.Marshal(map[string]any{"listen_type": listenType, "payload": payload})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, listenBrainzURL+"/1/submit-listens", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := listenBrainzClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("status %s", resp.Status)
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return permanentError{err}
+	}
+	return err
+}
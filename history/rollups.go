@@ -0,0 +1,443 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"MediaBackend/library"
+	minioClient "MediaBackend/minio"
+)
+
+// rollupsPrefix holds one document of daily rollups per user and year.
+// Rollups are derived from the listens and rebuilt from them when missing.
+const rollupsPrefix = "history/rollups/"
+
+// dayRollup aggregates the listens of a user on a day (UTC)
+type dayRollup struct {
+	Plays int `json:"plays"`
+	Skips int `json:"skips,omitempty"`
+	// Seconds is the listening time, including the played part of skips
+	Seconds float64 `json:"seconds"`
+	// Hours counts plays by hour of the day
+	Hours   [24]int        `json:"hours"`
+	Tracks  map[string]int `json:"tracks,omitempty"`
+	Artists map[string]int `json:"artists,omitempty"`
+	// Albums are keyed by albumKey
+	Albums map[string]int `json:"albums,omitempty"`
+	Genres map[string]int `json:"genres,omitempty"`
+}
+
+// yearRollup holds the day rollups of a user in a year, keyed by date
+type yearRollup struct {
+	days  map[string]*dayRollup
+	dirty bool
+}
+
+// rollupDocument is the persisted form of a yearRollup
+type rollupDocument struct {
+	Days map[string]*dayRollup `json:"days"`
+}
+
+// rollups maps users to years, nil until loaded
+var rollups = map[string]map[string]*yearRollup{}
+
+func rollupKey(user, year string) string {
+	return rollupsPrefix + url.PathEscape(user) + "/" + year + ".json"
+}
+
+// listenSeconds is how long a listen lasted, the whole track when the
+// client did not report it
+func listenSeconds(l Listen) float64 {
+	switch {
+	case l.Played > 0:
+		return l.Played
+	case l.Skipped:
+		return 0
+	}
+	return l.Duration
+}
+
+// albumKey identifies an album by its album artist, or artist, and title
+func albumKey(l Listen) string {
+	artist := l.AlbumArtist
+	if artist == "" {
+		artist = l.Artist
+	}
+	return artist + "\x1f" + l.Album
+}
+
+// add counts a listen into the rollup
+func (d *dayRollup) add(l Listen) {
+	d.Seconds += listenSeconds(l)
+	if l.Skipped {
+		d.Skips++
+		return
+	}
+	d.Plays++
+	d.Hours[l.Time.UTC().Hour()]++
+	increment(&d.Tracks, l.TrackID)
+	if l.Artist != "" {
+		increment(&d.Artists, l.Artist)
+	}
+	if l.Album != "" {
+		increment(&d.Albums, albumKey(l))
+	}
+	if l.Genre != "" {
+		increment(&d.Genres, l.Genre)
+	}
+}
+
+func increment(m *map[string]int, key string) {
+	if *m == nil {
+		*m = map[string]int{}
+	}
+	(*m)[key]++
+}
+
+// rollUp adds a listen to the loaded rollup of its year. mu must be held.
+func rollUp(user string, l Listen) {
+	y := rollups[user][l.Time.UTC().Format("2006")]
+	if y == nil {
+		return
+	}
+	date := l.Time.UTC().Format(time.DateOnly)
+	d := y.days[date]
+	if d == nil {
+		d = &dayRollup{}
+		y.days[date] = d
+	}
+	d.add(l)
+	y.dirty = true
+}
+
+// loadRollup returns the rollups of a user in a year, reading them from the
+// meta bucket or rebuilding them from the listens. mu must not be held.
+func loadRollup(ctx context.Context, user, year string) (*yearRollup, error) {
+	mu.Lock()
+	y, known := rollups[user][year]
+	mu.Unlock()
+	if y != nil {
+		return y, nil
+	}
+
+	loaded := &yearRollup{days: map[string]*dayRollup{}}
+	if known {
+		var doc rollupDocument
+		if err := minioClient.LoadJSON(ctx, rollupKey(user, year), &doc); err != nil && !errors.Is(err, minioClient.ErrNotFound) {
+			return nil, fmt.Errorf("loading rollups of %s: %w", year, err)
+		}
+		if doc.Days != nil {
+			loaded.days = doc.Days
+		}
+	} else {
+		// Listens recorded before rollups existed
+		mu.Lock()
+		var inYear []string
+		for month := range months[user] {
+			if strings.HasPrefix(month, year+"-") {
+				inYear = append(inYear, month)
+			}
+		}
+		mu.Unlock()
+		for _, month := range inYear {
+			if _, err := loadMonth(ctx, user, month); err != nil {
+				return nil, err
+			}
+		}
+		defer func() {
+			if len(inYear) > 0 {
+				saver.Schedule()
+			}
+		}()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if rollups[user] == nil {
+		rollups[user] = map[string]*yearRollup{}
+	}
+	if y := rollups[user][year]; y != nil {
+		return y, nil
+	}
+	if !known {
+		for month, m := range months[user] {
+			if m == nil || !strings.HasPrefix(month, year+"-") {
+				continue
+			}
+			for _, l := range m.listens {
+				date := l.Time.UTC().Format(time.DateOnly)
+				if loaded.days[date] == nil {
+					loaded.days[date] = &dayRollup{}
+				}
+				loaded.days[date].add(l)
+			}
+			loaded.dirty = true
+		}
+	}
+	rollups[user][year] = loaded
+	return loaded, nil
+}
+
+// days returns copies of the day rollups of a user from from (inclusive)
+// to to (exclusive), both dates
+func days(ctx context.Context, user string, from, to time.Time) (map[string]dayRollup, error) {
+	result := map[string]dayRollup{}
+	first, last := from.UTC().Format(time.DateOnly), to.UTC().Format(time.DateOnly)
+	for year := from.UTC().Year(); year <= to.UTC().Year(); year++ {
+		y, err := loadRollup(ctx, user, strconv.Itoa(year))
+		if err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		for date, d := range y.days {
+			if date >= first && date < last {
+				result[date] = d.clone()
+			}
+		}
+		mu.Unlock()
+	}
+	return result, nil
+}
+
+func (d *dayRollup) clone() dayRollup {
+	c := *d
+	c.Tracks = cloneCounts(d.Tracks)
+	c.Artists = cloneCounts(d.Artists)
+	c.Albums = cloneCounts(d.Albums)
+	c.Genres = cloneCounts(d.Genres)
+	return c
+}
+
+func cloneCounts(m map[string]int) map[string]int {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]int, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// Count is an entry of a top list
+type Count struct {
+	Name string `json:"name"`
+	// Artist is set for albums
+	Artist string `json:"artist,omitempty"`
+	Plays  int    `json:"plays"`
+	// Share is the fraction of all plays
+	Share float64 `json:"share"`
+}
+
+// TrackCount is an entry of the top tracks
+type TrackCount struct {
+	TrackID string `json:"trackId"`
+	// Track is nil once the track has left the library
+	Track *library.Track `json:"track,omitempty"`
+	Plays int            `json:"plays"`
+}
+
+// DayTotal is the listening of a day
+type DayTotal struct {
+	Date    string  `json:"date"`
+	Plays   int     `json:"plays"`
+	Seconds float64 `json:"seconds"`
+}
+
+// Summary is the listening of a user over a period
+type Summary struct {
+	From  time.Time `json:"from"`
+	To    time.Time `json:"to"`
+	Plays int       `json:"plays"`
+	Skips int       `json:"skips"`
+	// ListeningTime is in seconds
+	ListeningTime   float64      `json:"listeningTime"`
+	DistinctTracks  int          `json:"distinctTracks"`
+	DistinctArtists int          `json:"distinctArtists"`
+	TopArtists      []Count      `json:"topArtists"`
+	TopAlbums       []Count      `json:"topAlbums"`
+	TopTracks       []TrackCount `json:"topTracks"`
+	Genres          []Count      `json:"genres"`
+	// Days lists every day of the period, including those without plays
+	Days  []DayTotal `json:"days"`
+	Hours [24]int    `json:"hours"`
+
+	tracks map[string]int
+}
+
+// Summarize aggregates the listening of a user from from (inclusive) to to
+// (exclusive), truncated to days, keeping top lists of up to limit entries
+func Summarize(ctx context.Context, user string, from, to time.Time, limit int) (*Summary, error) {
+	from = from.UTC().Truncate(24 * time.Hour)
+	to = to.UTC().Truncate(24 * time.Hour)
+	if !to.After(from) {
+		return nil, fmt.Errorf("%w: the period must span at least a day", ErrInvalid)
+	}
+	byDate, err := days(ctx, user, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Summary{From: from, To: to, Days: []DayTotal{}, tracks: map[string]int{}}
+	artists, albums, genres := map[string]int{}, map[string]int{}, map[string]int{}
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		date := day.Format(time.DateOnly)
+		d, ok := byDate[date]
+		s.Days = append(s.Days, DayTotal{Date: date, Plays: d.Plays, Seconds: d.Seconds})
+		if !ok {
+			continue
+		}
+		s.Plays += d.Plays
+		s.Skips += d.Skips
+		s.ListeningTime += d.Seconds
+		for h, n := range d.Hours {
+			s.Hours[h] += n
+		}
+		addCounts(s.tracks, d.Tracks)
+		addCounts(artists, d.Artists)
+		addCounts(albums, d.Albums)
+		addCounts(genres, d.Genres)
+	}
+
+	s.DistinctTracks = len(s.tracks)
+	s.DistinctArtists = len(artists)
+	s.TopArtists = topCounts(artists, s.Plays, limit)
+	s.TopAlbums = topCounts(albums, s.Plays, limit)
+	for i, c := range s.TopAlbums {
+		s.TopAlbums[i].Artist, s.TopAlbums[i].Name, _ = strings.Cut(c.Name, "\x1f")
+	}
+	s.Genres = topCounts(genres, s.Plays, 0)
+	for _, c := range topCounts(s.tracks, s.Plays, limit) {
+		tc := TrackCount{TrackID: c.Name, Plays: c.Plays}
+		if track, ok := library.Get(c.Name); ok {
+			tc.Track = &track
+		}
+		s.TopTracks = append(s.TopTracks, tc)
+	}
+	if s.TopTracks == nil {
+		s.TopTracks = []TrackCount{}
+	}
+	return s, nil
+}
+
+func addCounts(dst, src map[string]int) {
+	for k, v := range src {
+		dst[k] += v
+	}
+}
+
+// topCounts sorts counts by plays, keeping up to limit entries (all when 0)
+func topCounts(counts map[string]int, total, limit int) []Count {
+	list := make([]Count, 0, len(counts))
+	for name, plays := range counts {
+		c := Count{Name: name, Plays: plays}
+		if total > 0 {
+			c.Share = float64(plays) / float64(total)
+		}
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Plays != list[j].Plays {
+			return list[i].Plays > list[j].Plays
+		}
+		return list[i].Name < list[j].Name
+	})
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return list
+}
+
+// MonthTotal is the listening of a month
+type MonthTotal struct {
+	Month   string  `json:"month"`
+	Plays   int     `json:"plays"`
+	Seconds float64 `json:"seconds"`
+}
+
+// Wrapped is the year in review of a user
+type Wrapped struct {
+	Year int `json:"year"`
+	*Summary
+	Months []MonthTotal `json:"months"`
+	// BusiestDay is the day with the longest listening time
+	BusiestDay *DayTotal `json:"busiestDay,omitempty"`
+	// LongestStreak is the most consecutive days with plays
+	LongestStreak int `json:"longestStreak"`
+	// NewTracks counts tracks first played this year
+	NewTracks int `json:"newTracks"`
+	// TopHour is the hour of the day (UTC) with the most plays
+	TopHour int `json:"topHour"`
+}
+
+// YearInReview builds the wrapped report of a user for a year
+func YearInReview(ctx context.Context, user string, year, limit int) (*Wrapped, error) {
+	from := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	summary, err := Summarize(ctx, user, from, from.AddDate(1, 0, 0), limit)
+	if err != nil {
+		return nil, err
+	}
+	w := &Wrapped{Year: year, Summary: summary, Months: make([]MonthTotal, 12)}
+
+	streak := 0
+	for _, d := range summary.Days {
+		month, _ := strconv.Atoi(d.Date[5:7])
+		m := &w.Months[month-1]
+		m.Plays += d.Plays
+		m.Seconds += d.Seconds
+		if d.Plays > 0 {
+			streak++
+			w.LongestStreak = max(w.LongestStreak, streak)
+		} else {
+			streak = 0
+		}
+		if d.Seconds > 0 && (w.BusiestDay == nil || d.Seconds > w.BusiestDay.Seconds) {
+			busiest := d
+			w.BusiestDay = &busiest
+		}
+	}
+	for i := range w.Months {
+		w.Months[i].Month = fmt.Sprintf("%04d-%02d", year, i+1)
+	}
+	for h, n := range summary.Hours {
+		if n > summary.Hours[w.TopHour] {
+			w.TopHour = h
+		}
+	}
+
+	// Tracks played in any earlier year are not new
+	earlier := map[string]bool{}
+	years := map[string]bool{}
+	mu.Lock()
+	for month := range months[user] {
+		if y := month[:4]; y < strconv.Itoa(year) {
+			years[y] = true
+		}
+	}
+	mu.Unlock()
+	for y := range years {
+		r, err := loadRollup(ctx, user, y)
+		if err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		for _, d := range r.days {
+			for id := range d.Tracks {
+				earlier[id] = true
+			}
+		}
+		mu.Unlock()
+	}
+	for id := range summary.tracks {
+		if !earlier[id] {
+			w.NewTracks++
+		}
+	}
+	return w, nil
+}
@@ -0,0 +1,108 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDayRollupAdd(t *testing.T) {
+	var d dayRollup
+	at := time.Date(2024, 5, 1, 21, 30, 0, 0, time.UTC)
+	d.add(Listen{TrackID: "one", Time: at, Duration: 200, Artist: "Artist", Album: "Album", AlbumArtist: "Various", Genre: "Jazz"})
+	d.add(Listen{TrackID: "one", Time: at, Duration: 200, Played: 150, Artist: "Artist"})
+	d.add(Listen{TrackID: "two", Time: at, Duration: 300, Played: 20, Skipped: true, Artist: "Other"})
+	d.add(Listen{TrackID: "three", Time: at, Duration: 100, Skipped: true})
+
+	if d.Plays != 2 || d.Skips != 2 || d.Seconds != 370 || d.Hours[21] != 2 {
+		t.Errorf("rollup = %+v", d)
+	}
+	if !reflect.DeepEqual(d.Tracks, map[string]int{"one": 2}) || !reflect.DeepEqual(d.Artists, map[string]int{"Artist": 2}) ||
+		!reflect.DeepEqual(d.Albums, map[string]int{"Various\x1fAlbum": 1}) || !reflect.DeepEqual(d.Genres, map[string]int{"Jazz": 1}) {
+		t.Errorf("counts = %v %v %v %v", d.Tracks, d.Artists, d.Albums, d.Genres)
+	}
+
+	c := d.clone()
+	c.Tracks["one"]++
+	if d.Tracks["one"] != 2 {
+		t.Error("clone shares the track counts")
+	}
+}
+
+func TestTopCounts(t *testing.T) {
+	got := topCounts(map[string]int{"b": 2, "a": 2, "c": 5, "d": 1}, 10, 3)
+	want := []Count{{Name: "c", Plays: 5, Share: 0.5}, {Name: "a", Plays: 2, Share: 0.2}, {Name: "b", Plays: 2, Share: 0.2}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("topCounts = %+v, want %+v", got, want)
+	}
+	if got := topCounts(map[string]int{"a": 1}, 0, 0); len(got) != 1 || got[0].Share != 0 {
+		t.Errorf("topCounts without plays = %+v", got)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	resetHistory(t)
+	day := func(d, hour int) time.Time { return time.Date(2024, 5, d, hour, 0, 0, 0, time.UTC) }
+	addListens("alice",
+		Listen{ID: "a", TrackID: "one", Time: day(1, 8), Duration: 100, Artist: "A", Album: "X"},
+		Listen{ID: "b", TrackID: "two", Time: day(1, 9), Duration: 50, Artist: "B", Album: "Y", AlbumArtist: "V"},
+		Listen{ID: "c", TrackID: "one", Time: day(3, 8), Duration: 100, Artist: "A", Album: "X"},
+		Listen{ID: "d", TrackID: "two", Time: day(3, 9), Played: 10, Skipped: true, Artist: "B"},
+		Listen{ID: "e", TrackID: "one", Time: day(5, 8), Duration: 100, Artist: "A"},
+	)
+
+	s, err := Summarize(context.Background(), "alice", day(1, 12), day(4, 0), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Plays != 3 || s.Skips != 1 || s.ListeningTime != 260 || s.DistinctTracks != 2 || s.DistinctArtists != 2 || s.Hours[8] != 2 {
+		t.Errorf("summary = %+v", s)
+	}
+	wantDays := []DayTotal{{"2024-05-01", 2, 150}, {"2024-05-02", 0, 0}, {"2024-05-03", 1, 110}}
+	if !reflect.DeepEqual(s.Days, wantDays) {
+		t.Errorf("days = %+v", s.Days)
+	}
+	if len(s.TopArtists) != 1 || s.TopArtists[0].Name != "A" || s.TopArtists[0].Plays != 2 {
+		t.Errorf("top artists = %+v", s.TopArtists)
+	}
+	if len(s.TopAlbums) != 1 || s.TopAlbums[0].Artist != "A" || s.TopAlbums[0].Name != "X" {
+		t.Errorf("top albums = %+v", s.TopAlbums)
+	}
+	if len(s.TopTracks) != 1 || s.TopTracks[0].TrackID != "one" || s.TopTracks[0].Plays != 2 || s.TopTracks[0].Track != nil {
+		t.Errorf("top tracks = %+v", s.TopTracks)
+	}
+
+	if _, err := Summarize(context.Background(), "alice", day(2, 0), day(2, 23), 1); !errors.Is(err, ErrInvalid) {
+		t.Errorf("period within a day: %v", err)
+	}
+}
+
+func TestYearInReview(t *testing.T) {
+	resetHistory(t)
+	at := func(month time.Month, d, hour int) time.Time {
+		return time.Date(2024, month, d, hour, 0, 0, 0, time.UTC)
+	}
+	addListens("alice",
+		Listen{ID: "a", TrackID: "old", Time: time.Date(2023, 12, 31, 23, 0, 0, 0, time.UTC), Duration: 60},
+		Listen{ID: "b", TrackID: "old", Time: at(1, 1, 7), Duration: 60},
+		Listen{ID: "c", TrackID: "new", Time: at(1, 2, 7), Duration: 60},
+		Listen{ID: "d", TrackID: "new", Time: at(1, 3, 22), Duration: 600},
+		Listen{ID: "e", TrackID: "new", Time: at(3, 10, 7), Duration: 60},
+	)
+
+	w, err := YearInReview(context.Background(), "alice", 2024, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Plays != 4 || w.LongestStreak != 3 || w.NewTracks != 1 || w.TopHour != 7 {
+		t.Errorf("wrapped = %+v", w)
+	}
+	if w.BusiestDay == nil || w.BusiestDay.Date != "2024-01-03" {
+		t.Errorf("busiest day = %+v", w.BusiestDay)
+	}
+	if len(w.Months) != 12 || w.Months[0] != (MonthTotal{"2024-01", 3, 720}) || w.Months[2] != (MonthTotal{"2024-03", 1, 60}) {
+		t.Errorf("months = %+v", w.Months)
+	}
+}
@@ -0,0 +1,324 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"MediaBackend/randid"
+)
+
+// Status is the lifecycle state of a job
+type Status string
+
+const (
+	StatusQueued  Status = "queued"
+	StatusRunning Status = "running"
+	StatusDone    Status = "done"
+	StatusFailed  Status = "failed"
+)
+
+// retention is how long finished jobs remain visible through the job API
+const retention = time.Hour
+
+// Func performs the work of a job. It may report progress through job.
+type Func func(ctx context.Context, job *Job) error
+
+// Job is a unit of background work tracked by a Pool
+type Job struct {
+	ID   string
+	Kind string
+	Key  string
+	Pool string
+
+	mu       sync.Mutex
+	status   Status
+	progress float64
+	result   any
+	err      error
+	created  time.Time
+	started  time.Time
+	finished time.Time
+	done     chan struct{}
+
+	// ctx is passed to the job's function and cancelled by Cancel or once
+	// the job has finished
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Info is a point-in-time view of a job, suitable for JSON responses
+type Info struct {
+	ID       string     `json:"id"`
+	Kind     string     `json:"kind"`
+	Key      string     `json:"key,omitempty"`
+	Pool     string     `json:"pool"`
+	Status   Status     `json:"status"`
+	Progress float64    `json:"progress"`
+	Result   any        `json:"result,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// SetProgress records completion as a fraction between 0 and 1
+func (j *Job) SetProgress(p float64) {
+	if p < 0 {
+		p = 0
+	}
+	if p > 1 {
+		p = 1
+	}
+	j.mu.Lock()
+	j.progress = p
+	j.mu.Unlock()
+}
+
+// SetResult attaches a JSON-encodable result to the job
+func (j *Job) SetResult(v any) {
+	j.mu.Lock()
+	j.result = v
+	j.mu.Unlock()
+}
+
+// Cancel stops the job: a running job sees its context cancelled and a
+// queued one fails without running
+func (j *Job) Cancel() {
+	j.cancel()
+}
+
+// Done is closed once the job has finished, successfully or not
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Err returns the failure of a finished job
+func (j *Job) Err() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.err
+}
+
+// Wait blocks until the job finishes or ctx is cancelled, reporting whether it finished
+func (j *Job) Wait(ctx context.Context) bool {
+	select {
+	case <-j.done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Info returns a snapshot of the job state
+func (j *Job) Info() Info {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	info := Info{
+		ID:       j.ID,
+		Kind:     j.Kind,
+		Key:      j.Key,
+		Pool:     j.Pool,
+		Status:   j.status,
+		Progress: j.progress,
+		Result:   j.result,
+		Created:  j.created,
+	}
+	if j.err != nil {
+		info.Error = j.err.Error()
+	}
+	if !j.started.IsZero() {
+		started := j.started
+		info.Started = &started
+	}
+	if !j.finished.IsZero() {
+		finished := j.finished
+		info.Finished = &finished
+	}
+	return info
+}
+
+func (j *Job) finishedBefore(t time.Time) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return !j.finished.IsZero() && j.finished.Before(t)
+}
+
+// Pool runs jobs on a bounded number of workers
+type Pool struct {
+	name string
+	sem  chan struct{}
+
+	mu     sync.Mutex
+	jobs   map[string]*Job
+	active map[string]*Job
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []*Pool
+)
+
+// Default runs analysis work such as spectrogram rendering
+var Default = NewPool("default", WorkersFromEnv("JOB_WORKERS", runtime.NumCPU()))
+
+// NewPool creates a pool with the given concurrency and registers it with the job API
+func NewPool(name string, workers int) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &Pool{
+		name:   name,
+		sem:    make(chan struct{}, workers),
+		jobs:   make(map[string]*Job),
+		active: make(map[string]*Job),
+	}
+
+	registryMu.Lock()
+	registry = append(registry, p)
+	registryMu.Unlock()
+	return p
+}
+
+// WorkersFromEnv reads a worker count from the environment
+func WorkersFromEnv(key string, defaultValue int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil && n > 0 {
+		return n
+	}
+	return defaultValue
+}
+
+// Submit queues fn unless a job with the same key is already queued or
+// running, in which case that job is returned instead
+func (p *Pool) Submit(kind, key string, fn Func) *Job {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.prune()
+	if key != "" {
+		if job, ok := p.active[key]; ok {
+			return job
+		}
+	}
+
+	job := &Job{
+		ID:      randid.Hex(8),
+		Kind:    kind,
+		Key:     key,
+		Pool:    p.name,
+		status:  StatusQueued,
+		created: time.Now(),
+		done:    make(chan struct{}),
+	}
+	job.ctx, job.cancel = context.WithCancel(context.Background())
+	p.jobs[job.ID] = job
+	if key != "" {
+		p.active[key] = job
+	}
+
+	go p.run(job, fn)
+	return job
+}
+
+func (p *Pool) run(job *Job, fn Func) {
+	p.sem <- struct{}{}
+	defer func() { <-p.sem }()
+
+	err := job.ctx.Err()
+	if err == nil {
+		job.mu.Lock()
+		job.status = StatusRunning
+		job.started = time.Now()
+		job.mu.Unlock()
+
+		err = safeCall(job.ctx, fn, job)
+	}
+	job.cancel()
+
+	job.mu.Lock()
+	job.finished = time.Now()
+	if err != nil {
+		job.status = StatusFailed
+		job.err = err
+	} else {
+		job.status = StatusDone
+		job.progress = 1
+	}
+	job.mu.Unlock()
+
+	p.mu.Lock()
+	if job.Key != "" && p.active[job.Key] == job {
+		delete(p.active, job.Key)
+	}
+	p.mu.Unlock()
+	close(job.done)
+
+	if err != nil {
+		log.Printf("Job %s (%s) failed: %v", job.ID, job.Kind, err)
+	}
+}
+
+// safeCall runs fn, converting a panic into a job failure
+func safeCall(ctx context.Context, fn Func, job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn(ctx, job)
+}
+
+// prune forgets finished jobs older than the retention period. p.mu must be held.
+func (p *Pool) prune() {
+	cutoff := time.Now().Add(-retention)
+	for id, job := range p.jobs {
+		if job.finishedBefore(cutoff) {
+			delete(p.jobs, id)
+		}
+	}
+}
+
+// Get looks up a job by ID across all pools
+func Get(id string) (*Job, bool) {
+	registryMu.Lock()
+	pools := append([]*Pool(nil), registry...)
+	registryMu.Unlock()
+
+	for _, p := range pools {
+		p.mu.Lock()
+		job, ok := p.jobs[id]
+		p.mu.Unlock()
+		if ok {
+			return job, true
+		}
+	}
+	return nil, false
+}
+
+// List returns a snapshot of all known jobs, newest first
+func List() []Info {
+	registryMu.Lock()
+	pools := append([]*Pool(nil), registry...)
+	registryMu.Unlock()
+
+	var infos []Info
+	for _, p := range pools {
+		p.mu.Lock()
+		p.prune()
+		for _, job := range p.jobs {
+			infos = append(infos, job.Info())
+		}
+		p.mu.Unlock()
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Created.After(infos[j].Created)
+	})
+	return infos
+}
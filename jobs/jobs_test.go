@@ -0,0 +1,133 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestSubmitRunsJob(t *testing.T) {
+	pool := NewPool("test", 2)
+	job := pool.Submit("kind", "", func(ctx context.Context, job *Job) error {
+		job.SetProgress(0.5)
+		job.SetResult("ok")
+		return nil
+	})
+	if !job.Wait(context.Background()) {
+		t.Fatal("job did not finish")
+	}
+	info := job.Info()
+	if info.Status != StatusDone || info.Progress != 1 || info.Result != "ok" {
+		t.Errorf("info = %+v, want done with result", info)
+	}
+	if info.Started == nil || info.Finished == nil {
+		t.Error("times not recorded")
+	}
+	if found, ok := Get(job.ID); !ok || found != job {
+		t.Error("job not found by ID")
+	}
+}
+
+func TestSubmitFailure(t *testing.T) {
+	pool := NewPool("test", 1)
+	failure := errors.New("failed")
+	job := pool.Submit("kind", "", func(context.Context, *Job) error { return failure })
+	job.Wait(context.Background())
+	if job.Err() != failure || job.Info().Status != StatusFailed {
+		t.Errorf("err = %v, status = %s", job.Err(), job.Info().Status)
+	}
+}
+
+func TestSubmitPanic(t *testing.T) {
+	pool := NewPool("test", 1)
+	job := pool.Submit("kind", "", func(context.Context, *Job) error { panic("boom") })
+	job.Wait(context.Background())
+	if job.Err() == nil || job.Err().Error() != "panic: boom" {
+		t.Errorf("err = %v, want panic: boom", job.Err())
+	}
+}
+
+func TestSubmitSharesKey(t *testing.T) {
+	pool := NewPool("test", 1)
+	release := make(chan struct{})
+	runs := 0
+	fn := func(context.Context, *Job) error {
+		<-release
+		runs++
+		return nil
+	}
+	a := pool.Submit("kind", "key", fn)
+	b := pool.Submit("kind", "key", fn)
+	c := pool.Submit("kind", "other", fn)
+	if a != b {
+		t.Error("same key started a second job")
+	}
+	if a == c {
+		t.Error("different keys shared a job")
+	}
+	close(release)
+	a.Wait(context.Background())
+	c.Wait(context.Background())
+	if runs != 2 {
+		t.Errorf("runs = %d, want 2", runs)
+	}
+}
+
+func TestSetProgressClamps(t *testing.T) {
+	tests := []struct {
+		in, want float64
+	}{
+		{-1, 0},
+		{0.25, 0.25},
+		{2, 1},
+	}
+	for _, tt := range tests {
+		job := &Job{}
+		job.SetProgress(tt.in)
+		if got := job.Info().Progress; got != tt.want {
+			t.Errorf("SetProgress(%v) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWaitCancelled(t *testing.T) {
+	pool := NewPool("test", 1)
+	release := make(chan struct{})
+	defer close(release)
+	job := pool.Submit("kind", "", func(context.Context, *Job) error {
+		<-release
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if job.Wait(ctx) {
+		t.Error("Wait reported a running job as finished")
+	}
+}
+
+func TestCancel(t *testing.T) {
+	pool := NewPool("test", 1)
+	started := make(chan struct{})
+	running := pool.Submit("kind", "", func(ctx context.Context, job *Job) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	<-started
+	ran := false
+	queued := pool.Submit("kind", "", func(context.Context, *Job) error {
+		ran = true
+		return nil
+	})
+	queued.Cancel()
+	running.Cancel()
+	running.Wait(context.Background())
+	queued.Wait(context.Background())
+
+	if !errors.Is(running.Err(), context.Canceled) {
+		t.Errorf("running job err = %v, want context.Canceled", running.Err())
+	}
+	if ran || !errors.Is(queued.Err(), context.Canceled) || queued.Info().Started != nil {
+		t.Errorf("queued job ran = %v, err = %v", ran, queued.Err())
+	}
+}
@@ -0,0 +1,188 @@
+package library
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"MediaBackend/audio"
+	minioClient "MediaBackend/minio"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// maxCueSheetSize bounds how much of a .cue object is read
+const maxCueSheetSize = 1 << 20
+
+// CueSlice locates a virtual track defined by a cue sheet within the file
+// holding the whole album
+type CueSlice struct {
+	// Sheet is the path of the cue sheet and SheetETag its version
+	Sheet     string `json:"sheet"`
+	SheetETag string `json:"sheetEtag"`
+	// Parent is the ID of the track holding the audio
+	Parent string `json:"parent"`
+	// Start and End bound the slice in seconds from the start of the parent
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// isCueSheet reports whether an object is a cue sheet
+func isCueSheet(key string) bool {
+	return strings.EqualFold(path.Ext(key), ".cue")
+}
+
+// CueTrackPath returns the path of the virtual track numbered n within the
+// parent file, e.g. "Album/album.flac/track-03.flac"
+func CueTrackPath(parent string, n int) string {
+	return fmt.Sprintf("%s/track-%02d%s", parent, n, strings.ToLower(path.Ext(parent)))
+}
+
+// audioStem returns the lower-cased path without its extension, used to
+// match FILE entries that name the file before it was re-encoded
+func audioStem(key string) string {
+	return strings.ToLower(strings.TrimSuffix(key, path.Ext(key)))
+}
+
+// scanCueSheets adds a virtual track for every track of every sheet whose
+// audio file is in the index. Sheets are only read again when they or their
+// audio files changed. stems maps audioStem of each audio object to its key.
+func scanCueSheets(ctx context.Context, sheets []minio.ObjectInfo, stems map[string]string, reprobe bool, seen map[string]bool, result *ScanResult) {
+	for _, sheet := range sheets {
+		existing := cueTracksOf(sheet.Key)
+		if !reprobe && cueTracksCurrent(existing, sheet.ETag, seen) {
+			for _, t := range existing {
+				seen[t.Path] = true
+			}
+			result.Unchanged += len(existing)
+			continue
+		}
+
+		data, err := readCueSheet(ctx, sheet.Key)
+		if err != nil {
+			// Keep the tracks of a sheet that could not be fetched
+			result.Failed++
+			log.Printf("Error reading cue sheet %s: %v", sheet.Key, err)
+			for _, t := range existing {
+				seen[t.Path] = true
+			}
+			continue
+		}
+		parsed, err := audio.ParseCueSheet(bytes.NewReader(data))
+		if err != nil {
+			result.Failed++
+			log.Printf("Error parsing cue sheet %s: %v", sheet.Key, err)
+			continue
+		}
+
+		previous := make(map[string]Track, len(existing))
+		for _, t := range existing {
+			previous[t.Path] = t
+		}
+
+		dir := path.Dir(sheet.Key)
+		for _, file := range parsed.Files {
+			key := path.Join(dir, file.Name)
+			if !seen[key] {
+				key = stems[audioStem(key)]
+			}
+			// Files that were not listed by this scan are about to be removed
+			parent, ok := ByPath(key)
+			if !ok || !seen[key] {
+				log.Printf("Cue sheet %s: no audio file for %q", sheet.Key, file.Name)
+				continue
+			}
+
+			for i, ct := range file.Tracks {
+				end := parent.Duration
+				if i+1 < len(file.Tracks) && (end == 0 || file.Tracks[i+1].Start < end) {
+					end = file.Tracks[i+1].Start
+				}
+				if end <= ct.Start {
+					log.Printf("Cue sheet %s: track %d starts past the end of %s", sheet.Key, ct.Number, parent.Path)
+					continue
+				}
+
+				vpath := CueTrackPath(parent.Path, ct.Number)
+				track := &Track{Added: time.Now().UTC()}
+				if old, ok := previous[vpath]; ok {
+					track.ID = old.ID
+					track.Added = old.Added
+					result.Updated++
+				} else {
+					result.Added++
+				}
+				track.Path = vpath
+				track.URL = trackURL(vpath)
+				track.Format = parent.Format
+				track.ETag = parent.ETag
+				track.Modified = parent.Modified
+				track.Tags = parsed.TrackTags(ct, parent.Tags)
+				track.Duration = end - ct.Start
+				track.SampleRate = parent.SampleRate
+				track.Channels = parent.Channels
+				track.Bitrate = parent.Bitrate
+				track.Kind = parent.Kind
+				track.Cue = &CueSlice{
+					Sheet:     sheet.Key,
+					SheetETag: sheet.ETag,
+					Parent:    parent.ID,
+					Start:     ct.Start,
+					End:       end,
+				}
+
+				mu.Lock()
+				if track.ID == "" {
+					track.ID = newTrackID(vpath)
+				}
+				put(track)
+				mu.Unlock()
+				seen[vpath] = true
+			}
+		}
+	}
+}
+
+// cueTracksOf returns the virtual tracks defined by a sheet
+func cueTracksOf(sheet string) []Track {
+	mu.RLock()
+	defer mu.RUnlock()
+	var list []Track
+	for _, t := range tracks {
+		if t.Cue != nil && t.Cue.Sheet == sheet {
+			list = append(list, *t)
+		}
+	}
+	return list
+}
+
+// cueTracksCurrent reports whether virtual tracks were built from the
+// given version of their sheet and the current version of their parents,
+// which must have been seen by the running scan
+func cueTracksCurrent(list []Track, sheetETag string, seen map[string]bool) bool {
+	if len(list) == 0 {
+		return false
+	}
+	for _, t := range list {
+		parent, ok := Get(t.Cue.Parent)
+		if t.Cue.SheetETag != sheetETag || !ok || !seen[parent.Path] || parent.ETag != t.ETag {
+			return false
+		}
+	}
+	return true
+}
+
+// readCueSheet fetches a cue sheet from the music bucket
+func readCueSheet(ctx context.Context, key string) ([]byte, error) {
+	obj, err := minioClient.GetObject(ctx, minioClient.MusicBucket, key)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	return io.ReadAll(io.LimitReader(obj, maxCueSheetSize))
+}
@@ -0,0 +1,55 @@
+package library
+
+import (
+	"path"
+	"strings"
+
+	"MediaBackend/audio"
+	"MediaBackend/env"
+)
+
+// Spoken word kinds of tracks
+const (
+	KindAudiobook = audio.KindAudiobook
+	KindPodcast   = audio.KindPodcast
+)
+
+// Folders of the music bucket holding audiobooks and podcasts, configured
+// as comma-separated prefixes
+var (
+	audiobookPaths = parsePrefixes(env.LookupOr("AUDIOBOOK_PATHS", "Audiobooks/"))
+	podcastPaths   = parsePrefixes(env.LookupOr("PODCAST_PATHS", "Podcasts/"))
+)
+
+func parsePrefixes(spec string) []string {
+	var prefixes []string
+	for _, p := range strings.Split(spec, ",") {
+		if p = strings.Trim(strings.TrimSpace(p), "/"); p != "" {
+			prefixes = append(prefixes, strings.ToLower(p)+"/")
+		}
+	}
+	return prefixes
+}
+
+// kindOf classifies a track by the kind its tags record, then by its folder
+// and finally by the .m4b extension of audiobooks
+func kindOf(key string, meta *audio.Metadata) string {
+	if meta.Kind != "" {
+		return meta.Kind
+	}
+	lower := strings.ToLower(key)
+	for _, prefix := range audiobookPaths {
+		if strings.HasPrefix(lower, prefix) {
+			return KindAudiobook
+		}
+	}
+	for _, prefix := range podcastPaths {
+		if strings.HasPrefix(lower, prefix) {
+			return KindPodcast
+		}
+	}
+	if path.Ext(lower) == ".m4b" {
+		return KindAudiobook
+	}
+	return ""
+}
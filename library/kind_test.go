@@ -0,0 +1,42 @@
+package library
+
+import (
+	"reflect"
+	"testing"
+
+	"MediaBackend/audio"
+)
+
+func TestParsePrefixes(t *testing.T) {
+	got := parsePrefixes(" Audiobooks/ ,/Spoken Word,, ")
+	if want := []string{"audiobooks/", "spoken word/"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("parsePrefixes = %q, want %q", got, want)
+	}
+	if got := parsePrefixes(""); got != nil {
+		t.Errorf("parsePrefixes of an empty setting = %q", got)
+	}
+}
+
+func TestKindOf(t *testing.T) {
+	saved := [2][]string{audiobookPaths, podcastPaths}
+	t.Cleanup(func() { audiobookPaths, podcastPaths = saved[0], saved[1] })
+	audiobookPaths, podcastPaths = parsePrefixes("Audiobooks"), parsePrefixes("Podcasts")
+
+	tests := []struct {
+		key  string
+		kind string
+		want string
+	}{
+		{"Audiobooks/Novel/01.mp3", "", KindAudiobook},
+		{"podcasts/Show/ep1.mp3", "", KindPodcast},
+		{"Music/Album/book.M4B", "", KindAudiobook},
+		{"Music/Album/song.mp3", "", ""},
+		{"Audiobooks/Novel/01.mp3", KindPodcast, KindPodcast},
+		{"AudiobooksExtra/x.mp3", "", ""},
+	}
+	for _, tt := range tests {
+		if got := kindOf(tt.key, &audio.Metadata{Kind: tt.kind}); got != tt.want {
+			t.Errorf("kindOf(%q, %q) = %q, want %q", tt.key, tt.kind, got, tt.want)
+		}
+	}
+}
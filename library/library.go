@@ -0,0 +1,243 @@
+package library
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"MediaBackend/audio"
+	minioClient "MediaBackend/minio"
+)
+
+// indexKey is the meta bucket object holding the library index
+const indexKey = "library/index.json"
+
+// indexVersion is bumped whenever probing starts extracting new fields, so
+// that the first scan after an upgrade reads every track again
+const indexVersion = 3
+
+// saveDelay batches index writes after incremental updates
+const saveDelay = 5 * time.Second
+
+// Track is an audio file in the music bucket together with its metadata
+type Track struct {
+	ID       string    `json:"id"`
+	Path     string    `json:"path"`
+	URL      string    `json:"url"`
+	Format   string    `json:"format"`
+	Size     int64     `json:"size"`
+	ETag     string    `json:"etag"`
+	Modified time.Time `json:"modified"`
+	Added    time.Time `json:"added"`
+
+	audio.Tags
+	Duration   float64 `json:"duration"`
+	SampleRate int     `json:"sampleRate,omitempty"`
+	Channels   int     `json:"channels,omitempty"`
+	Bitrate    int     `json:"bitrate,omitempty"`
+	// Gapless holds the encoder delay and padding when the file records them
+	Gapless *audio.Gapless `json:"gapless,omitempty"`
+	// Cue is set on virtual tracks cut from a single album file by a cue sheet
+	Cue *CueSlice `json:"cue,omitempty"`
+	// Kind is KindAudiobook or KindPodcast for spoken word recordings and
+	// empty for music
+	Kind     string          `json:"kind,omitempty"`
+	Chapters []audio.Chapter `json:"chapters,omitempty"`
+
+	Loudness *Loudness `json:"loudness,omitempty"`
+	// Tempo is the estimated BPM, measured with the loudness. It is 0 for
+	// tracks without a discernible beat and nil until analysed.
+	Tempo *float64 `json:"tempo,omitempty"`
+}
+
+// Loudness is the EBU R128 analysis of a track, with ReplayGain 2.0 style
+// gains relative to -18 LUFS
+type Loudness struct {
+	// Integrated is the gated loudness in LUFS
+	Integrated float64 `json:"integrated"`
+	// TruePeak is the maximum inter-sample peak in dBTP
+	TruePeak float64 `json:"truePeak"`
+	// TrackGain is the gain in dB to apply for track normalisation
+	TrackGain float64 `json:"trackGain"`
+	// TrackPeak is the linear sample peak, for clipping prevention
+	TrackPeak float64 `json:"trackPeak"`
+	// AlbumGain and AlbumPeak are set once every track of the album is analysed
+	AlbumGain   *float64  `json:"albumGain,omitempty"`
+	AlbumPeak   *float64  `json:"albumPeak,omitempty"`
+	GatedBlocks int       `json:"gatedBlocks"`
+	Analyzed    time.Time `json:"analyzed"`
+}
+
+// indexDocument is the persisted form of the index
+type indexDocument struct {
+	Version int      `json:"version"`
+	Tracks  []*Track `json:"tracks"`
+}
+
+var (
+	mu     sync.RWMutex
+	tracks = map[string]*Track{}
+	byPath = map[string]string{}
+	saver  = minioClient.NewSaver("library index", saveDelay, saveIndex)
+
+	// loadedVersion is the version of the persisted index. Tracks from
+	// an older version are probed again by the next scan.
+	loadedVersion = indexVersion
+
+	// changeListeners are notified when a scan or reindex changes tracks
+	changeListeners []func()
+)
+
+// Load reads the persisted index from the meta bucket
+func Load(ctx context.Context) error {
+	var doc indexDocument
+	if err := minioClient.LoadJSON(ctx, indexKey, &doc); err != nil {
+		if errors.Is(err, minioClient.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	loadedVersion = doc.Version
+	tracks = make(map[string]*Track, len(doc.Tracks))
+	byPath = make(map[string]string, len(doc.Tracks))
+	for _, t := range doc.Tracks {
+		tracks[t.ID] = t
+		byPath[t.Path] = t.ID
+	}
+	log.Printf("✓ Loaded library index (%d tracks)", len(tracks))
+	return nil
+}
+
+// Save writes the index to the meta bucket
+func Save(ctx context.Context) error {
+	return saver.Save(ctx)
+}
+
+// saveIndex writes the whole index
+func saveIndex(ctx context.Context) error {
+	mu.RLock()
+	doc := indexDocument{Version: indexVersion, Tracks: make([]*Track, 0, len(tracks))}
+	for _, t := range tracks {
+		copied := *t
+		doc.Tracks = append(doc.Tracks, &copied)
+	}
+	mu.RUnlock()
+
+	sort.Slice(doc.Tracks, func(i, j int) bool { return doc.Tracks[i].Path < doc.Tracks[j].Path })
+	return minioClient.SaveJSON(ctx, indexKey, doc)
+}
+
+// OnChange registers fn to be called, in its own goroutine, whenever a
+// scan or reindex has changed the index
+func OnChange(fn func()) {
+	mu.Lock()
+	defer mu.Unlock()
+	changeListeners = append(changeListeners, fn)
+}
+
+// notifyChange calls the registered change listeners
+func notifyChange() {
+	mu.RLock()
+	listeners := changeListeners
+	mu.RUnlock()
+	for _, fn := range listeners {
+		go fn()
+	}
+}
+
+// Tracks returns a copy of every track, sorted by path
+func Tracks() []Track {
+	mu.RLock()
+	list := make([]Track, 0, len(tracks))
+	for _, t := range tracks {
+		list = append(list, *t)
+	}
+	mu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	return list
+}
+
+// Get returns the track with the given ID
+func Get(id string) (Track, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	t, ok := tracks[id]
+	if !ok {
+		return Track{}, false
+	}
+	return *t, true
+}
+
+// ByPath returns the track stored at an object path
+func ByPath(path string) (Track, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	id, ok := byPath[path]
+	if !ok {
+		return Track{}, false
+	}
+	return *tracks[id], true
+}
+
+// Update applies fn to a track and schedules the index to be saved.
+// It reports whether the track exists.
+func Update(id string, fn func(t *Track)) bool {
+	mu.Lock()
+	t, ok := tracks[id]
+	if ok {
+		fn(t)
+	}
+	mu.Unlock()
+
+	if ok {
+		saver.Schedule()
+	}
+	return ok
+}
+
+// put inserts or replaces a track. mu must be held.
+func put(t *Track) {
+	if old, ok := tracks[t.ID]; ok && old.Path != t.Path {
+		delete(byPath, old.Path)
+	}
+	tracks[t.ID] = t
+	byPath[t.Path] = t.ID
+}
+
+// remove deletes a track. mu must be held.
+func remove(id string) {
+	if t, ok := tracks[id]; ok {
+		delete(byPath, t.Path)
+		delete(tracks, id)
+	}
+}
+
+// newTrackID derives an ID for a path seen for the first time. A moved
+// track keeps its ID, so a later file at its old path may need another one.
+// mu must be held.
+func newTrackID(path string) string {
+	seed := path
+	for n := 1; ; n++ {
+		sum := sha1.Sum([]byte(seed))
+		id := hex.EncodeToString(sum[:8])
+		if t, ok := tracks[id]; !ok || t.Path == path {
+			return id
+		}
+		seed = fmt.Sprintf("%s#%d", path, n)
+	}
+}
+
+// trackURL returns the streaming URL of a music object
+func trackURL(path string) string {
+	return "/gomedia/api/music/" + path
+}
@@ -0,0 +1,181 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"path"
+	"strings"
+	"time"
+
+	"MediaBackend/audio"
+	"MediaBackend/jobs"
+	minioClient "MediaBackend/minio"
+)
+
+// silenceFloor is reported as the loudness of tracks with no gated blocks
+const silenceFloor = -70.0
+
+// AnalyzeLoudness queues a job that measures the loudness and tempo of every
+// decodable track lacking them, or of every decodable track when force is set
+func AnalyzeLoudness(force bool) *jobs.Job {
+	return jobs.Default.Submit("loudness", "library-loudness", func(ctx context.Context, job *jobs.Job) error {
+		// Keep collecting until no work is left, so tracks added by a scan
+		// while this job was running are not missed
+		attempted := map[string]bool{}
+		analyzed, failed := 0, 0
+		for {
+			var pending []Track
+			for _, t := range Tracks() {
+				if !attempted[t.ID] && t.Cue == nil && audio.CanDecode(audio.Format(t.Format)) && (force || t.Loudness == nil || t.Tempo == nil) {
+					pending = append(pending, t)
+				}
+			}
+			if len(pending) == 0 {
+				break
+			}
+
+			for i, t := range pending {
+				attempted[t.ID] = true
+				loudness, tempo, err := measureTrack(ctx, t)
+				if err != nil {
+					failed++
+					log.Printf("Error measuring loudness of %s: %v", t.Path, err)
+				} else {
+					analyzed++
+					etag := t.ETag
+					Update(t.ID, func(current *Track) {
+						// Skip results for a file that changed while it was analysed
+						if current.ETag == etag {
+							current.Loudness = loudness
+							current.Tempo = &tempo
+						}
+					})
+				}
+				job.SetProgress(float64(i+1) / float64(len(pending)))
+			}
+		}
+
+		updateAlbumLoudness()
+		job.SetResult(map[string]int{"analyzed": analyzed, "failed": failed})
+		if failed > 0 && analyzed == 0 {
+			return fmt.Errorf("all %d tracks failed analysis", failed)
+		}
+		return nil
+	})
+}
+
+// tempoDecoder feeds the samples read from a decoder to a tempo meter
+type tempoDecoder struct {
+	audio.Decoder
+	meter *audio.TempoMeter
+}
+
+func (d tempoDecoder) Read(buf []float32) (int, error) {
+	n, err := d.Decoder.Read(buf)
+	d.meter.Write(buf[:n])
+	return n, err
+}
+
+// measureTrack decodes a track and computes its loudness and tempo
+func measureTrack(ctx context.Context, t Track) (*Loudness, float64, error) {
+	object, err := minioClient.GetObject(ctx, minioClient.MusicBucket, t.Path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer object.Close()
+
+	dec, err := audio.NewDecoder(object, audio.Format(t.Format))
+	if err != nil {
+		return nil, 0, err
+	}
+	meter := audio.NewTempoMeter(dec.SampleRate(), dec.Channels())
+	m, err := audio.MeasureLoudness(tempoDecoder{dec, meter})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	l := &Loudness{
+		Integrated:  m.Integrated,
+		TruePeak:    m.TruePeak,
+		TrackPeak:   m.SamplePeak,
+		GatedBlocks: m.GatedBlocks,
+		Analyzed:    time.Now().UTC(),
+	}
+	if m.GatedBlocks == 0 {
+		// Digital silence has no meaningful gain
+		l.Integrated = silenceFloor
+	} else {
+		l.TrackGain = round2(audio.ReplayGain(m.Integrated))
+	}
+	if math.IsInf(l.TruePeak, -1) {
+		l.TruePeak = silenceFloor
+	}
+	l.Integrated = round2(l.Integrated)
+	l.TruePeak = round2(l.TruePeak)
+	return l, meter.Tempo(), nil
+}
+
+// albumKey groups tracks into albums by album artist and title, falling
+// back to the directory for albums without an album artist
+func albumKey(t *Track) string {
+	if t.Album == "" {
+		return ""
+	}
+	owner := t.AlbumArtist
+	if owner == "" {
+		owner = path.Dir(t.Path)
+	}
+	return strings.ToLower(owner) + "\x00" + strings.ToLower(t.Album)
+}
+
+// updateAlbumLoudness sets album gain and peak on every album whose
+// decodable tracks have all been analysed
+func updateAlbumLoudness() {
+	mu.Lock()
+	albums := map[string][]*Track{}
+	for _, t := range tracks {
+		if key := albumKey(t); key != "" && t.Cue == nil && audio.CanDecode(audio.Format(t.Format)) {
+			albums[key] = append(albums[key], t)
+		}
+	}
+
+	for _, members := range albums {
+		parts := make([]audio.Loudness, 0, len(members))
+		peak := 0.0
+		complete := true
+		for _, t := range members {
+			if t.Loudness == nil {
+				complete = false
+				break
+			}
+			parts = append(parts, audio.Loudness{Integrated: t.Loudness.Integrated, GatedBlocks: t.Loudness.GatedBlocks})
+			peak = max(peak, t.Loudness.TrackPeak)
+		}
+		if !complete {
+			continue
+		}
+
+		integrated := audio.CombineLoudness(parts)
+		for _, t := range members {
+			l := *t.Loudness
+			if math.IsInf(integrated, -1) {
+				l.AlbumGain = nil
+			} else {
+				gain := round2(audio.ReplayGain(integrated))
+				l.AlbumGain = &gain
+			}
+			albumPeak := peak
+			l.AlbumPeak = &albumPeak
+			t.Loudness = &l
+		}
+	}
+	mu.Unlock()
+
+	saver.Schedule()
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
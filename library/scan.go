@@ -0,0 +1,279 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"MediaBackend/audio"
+	"MediaBackend/jobs"
+	minioClient "MediaBackend/minio"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// ScanResult summarises the changes made by a library scan
+type ScanResult struct {
+	Added   int `json:"added"`
+	Updated int `json:"updated"`
+	Removed int `json:"removed"`
+	// Moved counts tracks found at a new path with unchanged content
+	Moved     int `json:"moved"`
+	Unchanged int `json:"unchanged"`
+	Failed    int `json:"failed"`
+}
+
+// Changed reports whether the scan modified the index
+func (r ScanResult) Changed() bool {
+	return r.Added+r.Updated+r.Removed+r.Moved > 0
+}
+
+// Scan walks the music bucket, reading metadata for new or modified audio
+// files and cue sheets and dropping tracks whose objects no longer exist
+func Scan(ctx context.Context) (ScanResult, error) {
+	var result ScanResult
+	seen := map[string]bool{}
+	mu.RLock()
+	reprobe := loadedVersion < indexVersion
+	mu.RUnlock()
+	var sheets []minio.ObjectInfo
+	stems := map[string]string{}
+	// New tracks by content, to recognise moved files
+	fresh := map[string]string{}
+
+	for object := range minioClient.ListObjects(ctx, minioClient.MusicBucket) {
+		if object.Err != nil {
+			// A partial listing must not be mistaken for deleted files
+			return result, fmt.Errorf("listing music bucket: %w", object.Err)
+		}
+		if isCueSheet(object.Key) {
+			sheets = append(sheets, object)
+			continue
+		}
+		format := audio.FormatFromName(object.Key)
+		if format == audio.FormatUnknown {
+			continue
+		}
+		seen[object.Key] = true
+		stems[audioStem(object.Key)] = object.Key
+
+		existing, exists := ByPath(object.Key)
+		if exists && existing.ETag == object.ETag && !reprobe {
+			result.Unchanged++
+			continue
+		}
+
+		meta, err := probe(ctx, object, format)
+		if err != nil {
+			result.Failed++
+			log.Printf("Error reading metadata for %s: %v", object.Key, err)
+			continue
+		}
+
+		track := &Track{Added: time.Now().UTC()}
+		if exists {
+			track = &existing
+			result.Updated++
+		} else {
+			result.Added++
+		}
+		applyObject(track, object, format, meta)
+
+		mu.Lock()
+		if track.ID == "" {
+			track.ID = newTrackID(object.Key)
+			fresh[contentKey(track)] = track.ID
+		}
+		put(track)
+		mu.Unlock()
+	}
+
+	mu.Lock()
+	detectMoves(seen, fresh, &result)
+	mu.Unlock()
+
+	// After the audio files, so that sheets can refer to new ones
+	scanCueSheets(ctx, sheets, stems, reprobe, seen, &result)
+
+	mu.Lock()
+	for id, t := range tracks {
+		if !seen[t.Path] {
+			remove(id)
+			result.Removed++
+		}
+	}
+	if reprobe {
+		loadedVersion = indexVersion
+	}
+	mu.Unlock()
+
+	if result.Changed() {
+		if err := Save(ctx); err != nil {
+			return result, fmt.Errorf("saving library index: %w", err)
+		}
+		notifyChange()
+	}
+	return result, nil
+}
+
+// detectMoves hands the ID of each vanished track over to a new track with
+// the same content, so that references such as playlist entries follow a
+// moved file. fresh maps content keys to the IDs of tracks added by this
+// scan. mu must be held.
+func detectMoves(seen map[string]bool, fresh map[string]string, result *ScanResult) {
+	var gone []*Track
+	for _, t := range tracks {
+		if !seen[t.Path] && t.Cue == nil && t.ETag != "" {
+			gone = append(gone, t)
+		}
+	}
+	for _, old := range gone {
+		moved, ok := tracks[fresh[contentKey(old)]]
+		if !ok {
+			continue
+		}
+		delete(fresh, contentKey(old))
+		remove(old.ID)
+		remove(moved.ID)
+		moved.ID = old.ID
+		moved.Added = old.Added
+		moved.Loudness = old.Loudness
+		moved.Tempo = old.Tempo
+		put(moved)
+		result.Added--
+		result.Moved++
+	}
+}
+
+// contentKey identifies the content of a track for move detection
+func contentKey(t *Track) string {
+	return fmt.Sprintf("%s/%d", t.ETag, t.Size)
+}
+
+// applyObject copies object and metadata fields onto a track
+func applyObject(t *Track, object minio.ObjectInfo, format audio.Format, meta *audio.Metadata) {
+	t.Path = object.Key
+	t.URL = trackURL(object.Key)
+	t.Format = string(format)
+	t.Size = object.Size
+	t.Modified = object.LastModified
+	t.Tags = meta.Tags
+	t.Duration = meta.Duration
+	t.SampleRate = meta.SampleRate
+	t.Channels = meta.Channels
+	t.Bitrate = meta.Bitrate
+	t.Gapless = meta.Gapless
+	t.Kind = kindOf(object.Key, meta)
+	t.Chapters = meta.Chapters
+	if t.ETag != object.ETag {
+		// The audio changed, so the analysis must be redone
+		t.Loudness = nil
+		t.Tempo = nil
+	}
+	t.ETag = object.ETag
+}
+
+// Reindex reads one music object again after it was rewritten, without
+// waiting for the next scan. When only its tags changed, audioChanged is
+// false and the loudness analysis is kept although the ETag differs.
+func Reindex(ctx context.Context, key string, audioChanged bool) (Track, error) {
+	format := audio.FormatFromName(key)
+	object, err := minioClient.StatObject(ctx, minioClient.MusicBucket, key)
+	if err != nil {
+		return Track{}, err
+	}
+	meta, err := probe(ctx, object, format)
+	if err != nil {
+		return Track{}, err
+	}
+
+	track, exists := ByPath(key)
+	if !exists {
+		track = Track{Added: time.Now().UTC()}
+	}
+	if !audioChanged {
+		track.ETag = object.ETag
+	}
+	applyObject(&track, object, format, meta)
+
+	mu.Lock()
+	if track.ID == "" {
+		track.ID = newTrackID(key)
+	}
+	stored := track
+	put(&stored)
+	mu.Unlock()
+
+	// Album gains depend on which tracks share an album
+	updateAlbumLoudness()
+	notifyChange()
+	return track, nil
+}
+
+// probe reads tags and stream properties of a music object
+func probe(ctx context.Context, object minio.ObjectInfo, format audio.Format) (*audio.Metadata, error) {
+	obj, err := minioClient.GetObject(ctx, minioClient.MusicBucket, object.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	return audio.ReadMetadata(audio.NewBlockReaderAt(obj, object.Size), object.Size, format)
+}
+
+// ScanJob runs a scan in the background, followed by loudness analysis of
+// any new or modified tracks
+func ScanJob() *jobs.Job {
+	return jobs.Default.Submit("library-scan", "library-scan", func(ctx context.Context, job *jobs.Job) error {
+		result, err := Scan(ctx)
+		job.SetResult(result)
+		if err != nil {
+			return err
+		}
+		log.Printf("Library scan: %d added, %d updated, %d moved, %d removed, %d failed",
+			result.Added, result.Updated, result.Moved, result.Removed, result.Failed)
+		if result.Added+result.Updated > 0 {
+			AnalyzeLoudness(false)
+		} else if result.Removed+result.Moved > 0 {
+			updateAlbumLoudness()
+		}
+		return nil
+	})
+}
+
+// Start loads the persisted index and keeps it in sync with the music bucket
+// by rescanning every LIBRARY_SCAN_INTERVAL (default 15m, 0 disables)
+func Start(ctx context.Context) {
+	if err := Load(ctx); err != nil {
+		log.Printf("⚠️  Loading library index failed: %v", err)
+	}
+
+	interval := 15 * time.Minute
+	if v := os.Getenv("LIBRARY_SCAN_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Printf("⚠️  Invalid LIBRARY_SCAN_INTERVAL %q: %v", v, err)
+		} else {
+			interval = d
+		}
+	}
+
+	ScanJob()
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ScanJob()
+			}
+		}
+	}()
+}
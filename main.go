@@ -1,14 +1,22 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 
+	"MediaBackend/dlna"
 	"MediaBackend/handlers"
+	"MediaBackend/history"
+	"MediaBackend/library"
 	"MediaBackend/middleware"
 	minioClient "MediaBackend/minio"
+	"MediaBackend/playlists"
+	"MediaBackend/transcode"
+	"MediaBackend/webdav"
 )
 
 func main() {
@@ -16,6 +24,27 @@ func main() {
 	if err := minioClient.InitMinIO(); err != nil {
 		log.Printf("⚠️  MinIO initialization failed: %v", err)
 		log.Printf("⚠️  MinIO endpoints will not be available")
+	} else {
+		if err := playlists.Load(context.Background()); err != nil {
+			log.Printf("⚠️  Loading playlists failed: %v", err)
+		}
+		if err := history.Start(context.Background()); err != nil {
+			log.Printf("⚠️  Loading listening history failed: %v", err)
+		}
+		playlists.History = history.Counts{}
+		// Smart playlists follow library changes
+		library.OnChange(func() {
+			if err := playlists.Refresh(context.Background()); err != nil {
+				log.Printf("Error refreshing smart playlists: %v", err)
+			}
+		})
+		// Index the music bucket in the background
+		library.Start(context.Background())
+	}
+
+	// Select the transcoder for on-demand format conversion
+	if err := transcode.Init(); err != nil {
+		log.Printf("⚠️  Transcoding disabled: %v", err)
 	}
 
 	// Set up routes
@@ -33,11 +62,59 @@ func main() {
 	mux.HandleFunc("/gomedia/api/music", handlers.ListMinIOMusic)
 	mux.HandleFunc("/gomedia/api/images", handlers.ListMinIOImages)
 
+	// Library index
+	mux.HandleFunc("/gomedia/api/library/tracks", handlers.ListLibraryTracks)
+	mux.HandleFunc("/gomedia/api/library/tracks/", handlers.GetLibraryTrack)
+	mux.HandleFunc("/gomedia/api/library/scan", handlers.ScanLibrary)
+	mux.HandleFunc("/gomedia/api/library/loudness", handlers.AnalyzeLibraryLoudness)
+
+	// Playlists
+	mux.HandleFunc("/gomedia/api/playlists", handlers.Playlists)
+	mux.HandleFunc("/gomedia/api/playlists/", handlers.PlaylistResource)
+	mux.HandleFunc("/gomedia/api/playlists/preview", handlers.PreviewPlaylistRules)
+	mux.HandleFunc("/gomedia/api/playlists/import", handlers.ImportPlaylist)
+	mux.HandleFunc("/gomedia/api/scrobble", handlers.Scrobble)
+	mux.HandleFunc("/gomedia/api/history", handlers.History)
+	mux.HandleFunc("/gomedia/api/history/", handlers.HistoryResource)
+	mux.HandleFunc("/gomedia/api/stats", handlers.Stats)
+	mux.HandleFunc("/gomedia/api/stats/wrapped", handlers.StatsWrapped)
+	mux.HandleFunc("/gomedia/api/stats/wrapped/", handlers.StatsWrapped)
+	mux.HandleFunc("/gomedia/api/radio", handlers.Radio)
+	mux.HandleFunc("/gomedia/api/audiobooks", handlers.Audiobooks)
+	mux.HandleFunc("/gomedia/api/positions", handlers.Positions)
+	mux.HandleFunc("/gomedia/api/positions/", handlers.PositionResource)
+	mux.HandleFunc("/gomedia/api/bookmarks", handlers.Bookmarks)
+	mux.HandleFunc("/gomedia/api/bookmarks/", handlers.BookmarkResource)
+	mux.HandleFunc("/gomedia/api/session", handlers.PlaybackSession)
+	mux.HandleFunc("/gomedia/api/session/heartbeat", handlers.PlaybackHeartbeat)
+	mux.HandleFunc("/gomedia/api/devices", handlers.ListDevices)
+	mux.HandleFunc("/gomedia/api/devices/", handlers.DeviceCommand)
+	mux.HandleFunc("/gomedia/api/devices/ws", handlers.DeviceSocket)
+	mux.HandleFunc("/gomedia/api/rooms", handlers.Rooms)
+	mux.HandleFunc("/gomedia/api/rooms/", handlers.RoomResource)
+	mux.HandleFunc("/gomedia/api/stations", handlers.ListStations)
+
+	// Subsonic API for existing clients
+	mux.HandleFunc("/rest/", handlers.Subsonic)
+
+	// Internet radio stations
+	mux.HandleFunc("/gomedia/radio/", handlers.ServeStation)
+
+	// UPnP media server for players on the local network
+	mux.HandleFunc(dlna.Prefix, handlers.DLNA)
+
+	// Network drive access to the buckets
+	mux.HandleFunc(webdav.Prefix, handlers.WebDAV)
+
+	// Background job status
+	mux.HandleFunc("/gomedia/api/jobs", handlers.ListJobs)
+	mux.HandleFunc("/gomedia/api/jobs/", handlers.GetJob)
+
 	// Serve test client
 	mux.HandleFunc("/", handlers.ServeTestClient)
 
 	// Apply middleware
-	handler := middleware.CORS(middleware.Logging(mux))
+	handler := middleware.CORS(middleware.Logging(middleware.Auth(mux, dlnaClient)), webdavRequest)
 
 	// Get port from environment or use default
 	port := os.Getenv("PORT")
@@ -49,8 +126,45 @@ func main() {
 	log.Printf("🎵 Media Streaming Server starting on http://localhost%s", addr)
 	log.Printf("☁️  MinIO Music: %s", minioClient.MusicBucket)
 	log.Printf("☁️  MinIO Images: %s", minioClient.ImageBucket)
+	if !middleware.AuthEnabled() {
+		log.Printf("⚠️  AUTH_USERS is not set, all requests act as user %q", middleware.DefaultUser)
+	}
+
+	if dlna.Enabled() {
+		library.OnChange(dlna.Changed)
+		if err := dlna.Start(port); err != nil {
+			log.Printf("⚠️  DLNA discovery disabled: %v", err)
+		} else {
+			log.Printf("📺 DLNA media server %q announced on the local network", dlna.FriendlyName())
+		}
+	}
+
+	if webdav.Enabled() {
+		mode := "read-write"
+		if webdav.ReadOnly() {
+			mode = "read-only"
+		}
+		log.Printf("🗂️  WebDAV %s at http://localhost%s%s", mode, addr, webdav.Prefix)
+		if !middleware.AuthEnabled() {
+			log.Printf("⚠️  WebDAV is read-only because AUTH_USERS is not set")
+		}
+	}
 
 	if err := http.ListenAndServe(addr, handler); err != nil {
 		log.Fatalf("Server failed to start: %v", err)
 	}
 }
+
+// dlnaClient reports whether a request comes from a DLNA player on an
+// allowed network. Players cannot sign in, so the opt-in, read-only media
+// server is open to them; other clients still need credentials.
+func dlnaClient(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, dlna.Prefix) && dlna.AllowsClient(r.RemoteAddr)
+}
+
+// webdavRequest reports whether a request is for the WebDAV share. WebDAV
+// clients send OPTIONS to discover the server, so those reach the handler
+// instead of being answered as CORS preflights.
+func webdavRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, webdav.Prefix)
+}
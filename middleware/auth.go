@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// DefaultUser owns all per-user data when authentication is disabled
+const DefaultUser = "default"
+
+type userKey struct{}
+
+// users maps user names to passwords, loaded from AUTH_USERS
+var users = loadUsers(os.Getenv("AUTH_USERS"))
+
+// loadUsers parses "name:password,name:password"
+func loadUsers(spec string) map[string]string {
+	list := map[string]string{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, password, ok := strings.Cut(entry, ":")
+		if !ok || name == "" {
+			log.Printf("⚠️  Ignoring malformed AUTH_USERS entry %q", name)
+			continue
+		}
+		list[name] = password
+	}
+	return list
+}
+
+// AuthEnabled reports whether AUTH_USERS configures any user
+func AuthEnabled() bool {
+	return len(users) > 0
+}
+
+// UserNames returns the configured users in name order, or DefaultUser when
+// authentication is disabled
+func UserNames() []string {
+	if !AuthEnabled() {
+		return []string{DefaultUser}
+	}
+	names := make([]string, 0, len(users))
+	for name := range users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CheckPassword reports whether name and password match a configured user
+func CheckPassword(name, password string) bool {
+	expected, ok := users[name]
+	return ok && subtle.ConstantTimeCompare([]byte(expected), []byte(password)) == 1
+}
+
+// CheckToken reports whether token is the MD5 of a user's password followed
+// by salt, as sent by Subsonic clients
+func CheckToken(name, token, salt string) bool {
+	password, ok := users[name]
+	if !ok || salt == "" {
+		return false
+	}
+	sum := md5.Sum([]byte(password + salt))
+	expected := hex.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(token))) == 1
+}
+
+// Auth middleware requires HTTP basic authentication when AUTH_USERS is
+// set and records the user for handlers. Without users every request acts
+// as DefaultUser, as do requests for which open returns true.
+func Auth(next http.Handler, open func(*http.Request) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !AuthEnabled() || r.URL.Path == "/health" {
+			next.ServeHTTP(w, WithUser(r, DefaultUser))
+			return
+		}
+		// The Subsonic API sends credentials as parameters and checks them itself
+		if strings.HasPrefix(r.URL.Path, "/rest/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if open != nil && open(r) {
+			next.ServeHTTP(w, WithUser(r, DefaultUser))
+			return
+		}
+		name, password, ok := r.BasicAuth()
+		if !ok || !CheckPassword(name, password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="MediaBackend", charset="UTF-8"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, WithUser(r, name))
+	})
+}
+
+// WithUser returns r carrying the given user
+func WithUser(r *http.Request, name string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userKey{}, name))
+}
+
+// User returns the user a request acts for
+func User(r *http.Request) string {
+	if name, ok := r.Context().Value(userKey{}).(string); ok {
+		return name
+	}
+	return DefaultUser
+}
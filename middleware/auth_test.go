@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// withUsers configures users for the duration of a test
+func withUsers(t *testing.T, spec string) {
+	t.Helper()
+	saved := users
+	users = loadUsers(spec)
+	t.Cleanup(func() { users = saved })
+}
+
+func TestLoadUsers(t *testing.T) {
+	got := loadUsers(" alice:secret , bob:a:b,:nameless,broken,carol:")
+	if len(got) != 3 || got["alice"] != "secret" || got["bob"] != "a:b" || got["carol"] != "" {
+		t.Errorf("loadUsers = %v", got)
+	}
+}
+
+func TestCheckToken(t *testing.T) {
+	withUsers(t, "alice:sesame")
+	// The example from the Subsonic API documentation
+	for _, tt := range []struct {
+		name, token, salt string
+		want              bool
+	}{
+		{"alice", "26719a1196d2a940705a59634eb18eab", "c19b2d", true},
+		{"alice", "26719A1196D2A940705A59634EB18EAB", "c19b2d", true},
+		{"alice", "26719a1196d2a940705a59634eb18eab", "c19b2e", false},
+		{"alice", "26719a1196d2a940705a59634eb18eab", "", false},
+		{"bob", "26719a1196d2a940705a59634eb18eab", "c19b2d", false},
+	} {
+		if got := CheckToken(tt.name, tt.token, tt.salt); got != tt.want {
+			t.Errorf("CheckToken(%q, %q, %q) = %v, want %v", tt.name, tt.token, tt.salt, got, tt.want)
+		}
+	}
+}
+
+func TestAuth(t *testing.T) {
+	var user string
+	// Stands in for the DLNA exemption that main passes
+	open := func(r *http.Request) bool { return strings.HasPrefix(r.URL.Path, "/open/") }
+	handler := Auth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user = User(r)
+	}), open)
+	serve := func(path, name, password string) int {
+		user = ""
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if name != "" {
+			r.SetBasicAuth(name, password)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	withUsers(t, "")
+	if code := serve("/gomedia/api/tracks", "", ""); code != http.StatusOK || user != DefaultUser {
+		t.Errorf("without users: %d as %q, want the default user", code, user)
+	}
+
+	withUsers(t, "alice:sesame")
+	if code := serve("/gomedia/api/tracks", "", ""); code != http.StatusUnauthorized {
+		t.Errorf("missing credentials: %d, want 401", code)
+	}
+	if code := serve("/gomedia/api/tracks", "alice", "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("wrong password: %d, want 401", code)
+	}
+	if code := serve("/gomedia/api/tracks", "alice", "sesame"); code != http.StatusOK || user != "alice" {
+		t.Errorf("valid credentials: %d as %q", code, user)
+	}
+	if code := serve("/open/device.xml", "", ""); code != http.StatusOK || user != DefaultUser {
+		t.Errorf("exempt request: %d as %q, want the default user", code, user)
+	}
+	// Subsonic checks its own parameters, so no user is set on the way
+	if code := serve("/rest/ping.view", "", ""); code != http.StatusOK || user != DefaultUser {
+		t.Errorf("Subsonic request: %d as %q", code, user)
+	}
+}
+
+func TestUserNames(t *testing.T) {
+	withUsers(t, "")
+	if got := UserNames(); len(got) != 1 || got[0] != DefaultUser {
+		t.Errorf("UserNames without users = %v", got)
+	}
+	withUsers(t, "bob:x,alice:y")
+	if got := UserNames(); len(got) != 2 || got[0] != "alice" || got[1] != "bob" {
+		t.Errorf("UserNames = %v", got)
+	}
+}
@@ -4,17 +4,18 @@ import (
 	"net/http"
 )
 
-// CORS middleware adds CORS headers to all responses
-func CORS(next http.Handler) http.Handler {
+// CORS middleware adds CORS headers to all responses and answers preflight
+// requests, except OPTIONS requests for which forward returns true
+func CORS(next http.Handler, forward func(*http.Request) bool) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Set CORS headers
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Range")
-		w.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Range, Accept-Ranges")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Range, If-Match")
+		w.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Range, Accept-Ranges, ETag, X-Seek-Time, X-Seek-Approximate")
 
 		// Handle preflight requests
-		if r.Method == "OPTIONS" {
+		if r.Method == "OPTIONS" && (forward == nil || !forward(r)) {
 			w.WriteHeader(http.StatusOK)
 			return
 		}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	reached := false
+	handler := CORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+		w.WriteHeader(http.StatusNoContent)
+	}), func(r *http.Request) bool { return strings.HasPrefix(r.URL.Path, "/dav/") })
+
+	tests := []struct {
+		method, path string
+		reached      bool
+		code         int
+	}{
+		{http.MethodOptions, "/gomedia/api/tracks", false, http.StatusOK},
+		{http.MethodOptions, "/dav/music/", true, http.StatusNoContent},
+		{http.MethodGet, "/gomedia/api/tracks", true, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		reached = false
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if reached != tt.reached || w.Code != tt.code {
+			t.Errorf("%s %s: reached %v with %d, want %v with %d", tt.method, tt.path, reached, w.Code, tt.reached, tt.code)
+		}
+		if w.Header().Get("Access-Control-Allow-Origin") != "*" {
+			t.Errorf("%s %s: no CORS headers", tt.method, tt.path)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package minio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// ErrCacheMiss is returned when a derived artifact has not been cached yet
+var ErrCacheMiss = errors.New("cache miss")
+
+// CacheKey builds the cache object name for an artifact derived from a
+// specific version (ETag) of a source object
+func CacheKey(kind, etag, name string) string {
+	return path.Join(kind, strings.Trim(etag, `"`), name)
+}
+
+// GetCached reads a cached artifact from the cache bucket
+func GetCached(ctx context.Context, key string) ([]byte, error) {
+	object, err := Client.GetObject(ctx, CacheBucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	data, err := io.ReadAll(object)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ErrCacheMiss
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// PutCached stores an artifact in the cache bucket
+func PutCached(ctx context.Context, key string, data []byte, contentType string) error {
+	_, err := Client.PutObject(ctx, CacheBucket, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	return err
+}
+
+// OpenCached opens a cached artifact for streaming. The returned object
+// implements io.ReadSeeker, so it can be served with range support.
+func OpenCached(ctx context.Context, key string) (*minio.Object, minio.ObjectInfo, error) {
+	info, err := Client.StatObject(ctx, CacheBucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, info, ErrCacheMiss
+		}
+		return nil, info, err
+	}
+	object, err := Client.GetObject(ctx, CacheBucket, key, minio.GetObjectOptions{})
+	return object, info, err
+}
+
+// PutCachedStream stores an artifact of unknown length read from r and
+// returns its size
+func PutCachedStream(ctx context.Context, key string, r io.Reader, contentType string) (int64, error) {
+	info, err := Client.PutObject(ctx, CacheBucket, key, r, -1, minio.PutObjectOptions{
+		ContentType: contentType,
+		// Bound the multipart buffer, which defaults to the maximum part size
+		PartSize: 16 << 20,
+	})
+	return info.Size, err
+}
@@ -0,0 +1,19 @@
+package minio
+
+import "testing"
+
+func TestCacheKey(t *testing.T) {
+	tests := []struct {
+		kind, etag, name string
+		want             string
+	}{
+		{"transcodes", "abc123", "128.mp3", "transcodes/abc123/128.mp3"},
+		{"transcodes", `"abc123"`, "96.opus", "transcodes/abc123/96.opus"},
+		{"waveforms", `"abc-2"`, "1000-8.json", "waveforms/abc-2/1000-8.json"},
+	}
+	for _, tt := range tests {
+		if got := CacheKey(tt.kind, tt.etag, tt.name); got != tt.want {
+			t.Errorf("CacheKey(%q, %q, %q) = %q, want %q", tt.kind, tt.etag, tt.name, got, tt.want)
+		}
+	}
+}
@@ -3,8 +3,11 @@ package minio
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
-	"os"
+	"strings"
+
+	"MediaBackend/env"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
@@ -17,6 +20,8 @@ var (
 	// Configuration
 	MusicBucket string
 	ImageBucket string
+	CacheBucket string
+	MetaBucket  string
 )
 
 // Config holds MinIO configuration
@@ -27,17 +32,21 @@ type Config struct {
 	UseSSL          bool
 	MusicBucket     string
 	ImageBucket     string
+	CacheBucket     string
+	MetaBucket      string
 }
 
 // InitMinIO initializes the MinIO client with configuration from environment variables
 func InitMinIO() error {
 	config := Config{
-		Endpoint:        getEnv("MINIO_ENDPOINT", "0.0.0.0:9100"),
-		AccessKeyID:     getEnv("MINIO_ACCESS_KEY", "duylongadmin"),
-		SecretAccessKey: getEnv("MINIO_SECRET_KEY", "duylongpass"),
-		UseSSL:          getEnv("MINIO_USE_SSL", "false") == "true",
-		MusicBucket:     getEnv("MINIO_MUSIC_BUCKET", "music"),
-		ImageBucket:     getEnv("MINIO_IMAGE_BUCKET", "images"),
+		Endpoint:        env.Or("MINIO_ENDPOINT", "0.0.0.0:9100"),
+		AccessKeyID:     env.Or("MINIO_ACCESS_KEY", "duylongadmin"),
+		SecretAccessKey: env.Or("MINIO_SECRET_KEY", "duylongpass"),
+		UseSSL:          env.Or("MINIO_USE_SSL", "false") == "true",
+		MusicBucket:     env.Or("MINIO_MUSIC_BUCKET", "music"),
+		ImageBucket:     env.Or("MINIO_IMAGE_BUCKET", "images"),
+		CacheBucket:     env.Or("MINIO_CACHE_BUCKET", "media-cache"),
+		MetaBucket:      env.Or("MINIO_META_BUCKET", "media-meta"),
 	}
 
 	// Initialize MinIO client
@@ -52,6 +61,8 @@ func InitMinIO() error {
 	Client = client
 	MusicBucket = config.MusicBucket
 	ImageBucket = config.ImageBucket
+	CacheBucket = config.CacheBucket
+	MetaBucket = config.MetaBucket
 
 	// Test connection
 	ctx := context.Background()
@@ -69,6 +80,12 @@ func InitMinIO() error {
 	if err := ensureBucket(ctx, config.ImageBucket); err != nil {
 		log.Printf("Warning: Image bucket '%s' check failed: %v", config.ImageBucket, err)
 	}
+	if err := ensureBucket(ctx, config.CacheBucket); err != nil {
+		log.Printf("Warning: Cache bucket '%s' check failed: %v", config.CacheBucket, err)
+	}
+	if err := ensureBucket(ctx, config.MetaBucket); err != nil {
+		log.Printf("Warning: Meta bucket '%s' check failed: %v", config.MetaBucket, err)
+	}
 
 	return nil
 }
@@ -94,15 +111,6 @@ func ensureBucket(ctx context.Context, bucketName string) error {
 	return nil
 }
 
-// getEnv gets an environment variable with a default value
-func getEnv(key, defaultValue string) string {
-	value := os.Getenv(key)
-	if value == "" {
-		return defaultValue
-	}
-	return value
-}
-
 // GetObject retrieves an object from MinIO
 func GetObject(ctx context.Context, bucketName, objectName string) (*minio.Object, error) {
 	return Client.GetObject(ctx, bucketName, objectName, minio.GetObjectOptions{})
@@ -119,3 +127,64 @@ func ListObjects(ctx context.Context, bucketName string) <-chan minio.ObjectInfo
 		Recursive: true,
 	})
 }
+
+// ListPrefix lists the objects below a prefix. Unless recursive, deeper
+// objects are summarized as common prefixes, keys ending in "/".
+func ListPrefix(ctx context.Context, bucketName, prefix string, recursive bool) <-chan minio.ObjectInfo {
+	return Client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: recursive,
+	})
+}
+
+// streamPartSize is the part size for bodies of unknown length. Each part is
+// buffered in memory, and the client would otherwise pick parts of over
+// 500 MiB to reach its maximum object size.
+const streamPartSize = 16 << 20
+
+// PutObject uploads an object. A size of -1 streams a body of unknown
+// length in parts of streamPartSize, up to 10,000 of them (156 GiB).
+func PutObject(ctx context.Context, bucketName, objectName string, r io.Reader, size int64, contentType string) (minio.UploadInfo, error) {
+	return Client.PutObject(ctx, bucketName, objectName, r, size, putOptions(size, contentType))
+}
+
+// ReplaceObject uploads an object in place of the version with the given
+// ETag. Servers with conditional writes, such as MinIO, refuse it when the
+// object changed, which IsPreconditionFailed detects; others ignore the
+// condition.
+func ReplaceObject(ctx context.Context, bucketName, objectName string, r io.Reader, size int64, contentType, etag string) (minio.UploadInfo, error) {
+	opts := putOptions(size, contentType)
+	opts.SetMatchETag(strings.Trim(etag, `"`))
+	return Client.PutObject(ctx, bucketName, objectName, r, size, opts)
+}
+
+// IsPreconditionFailed reports whether a conditional write was refused
+func IsPreconditionFailed(err error) bool {
+	return minio.ToErrorResponse(err).Code == "PreconditionFailed"
+}
+
+func putOptions(size int64, contentType string) minio.PutObjectOptions {
+	opts := minio.PutObjectOptions{
+		ContentType: contentType,
+		// Bodies of unknown or zero length are sent unsigned; some S3
+		// servers reject them with streaming signatures
+		DisableContentSha256: size <= 0,
+	}
+	if size < 0 {
+		opts.PartSize = streamPartSize
+	}
+	return opts
+}
+
+// CopyObject copies an object server-side, replacing any object at the destination
+func CopyObject(ctx context.Context, dstBucket, dstName, srcBucket, srcName string) (minio.UploadInfo, error) {
+	return Client.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: dstBucket, Object: dstName},
+		minio.CopySrcOptions{Bucket: srcBucket, Object: srcName},
+	)
+}
+
+// RemoveObject deletes an object
+func RemoveObject(ctx context.Context, bucketName, objectName string) error {
+	return Client.RemoveObject(ctx, bucketName, objectName, minio.RemoveObjectOptions{})
+}
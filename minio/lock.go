@@ -0,0 +1,40 @@
+package minio
+
+import "sync"
+
+// objectLock is held by the writer of an object; refs counts the writers
+// holding or waiting for it
+type objectLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+var (
+	objectLocksMu sync.Mutex
+	objectLocks   = map[string]*objectLock{}
+)
+
+// LockObject waits until no other request of this server is writing an
+// object and returns the function that releases it. Read-modify-write
+// updates hold it from reading the object to storing the new version.
+func LockObject(bucketName, objectName string) (unlock func()) {
+	key := bucketName + "/" + objectName
+	objectLocksMu.Lock()
+	l := objectLocks[key]
+	if l == nil {
+		l = &objectLock{}
+		objectLocks[key] = l
+	}
+	l.refs++
+	objectLocksMu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		objectLocksMu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(objectLocks, key)
+		}
+		objectLocksMu.Unlock()
+	}
+}
@@ -0,0 +1,33 @@
+package minio
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockObject(t *testing.T) {
+	unlock := LockObject("music", "a.mp3")
+
+	// Other objects are not held up
+	LockObject("music", "b.mp3")()
+	LockObject("images", "a.mp3")()
+
+	acquired := make(chan struct{})
+	go func() {
+		LockObject("music", "a.mp3")()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("second writer acquired a held lock")
+	case <-time.After(20 * time.Millisecond):
+	}
+	unlock()
+	<-acquired
+
+	objectLocksMu.Lock()
+	defer objectLocksMu.Unlock()
+	if len(objectLocks) != 0 {
+		t.Errorf("%d locks left after release", len(objectLocks))
+	}
+}
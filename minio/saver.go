@@ -0,0 +1,80 @@
+package minio
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// Saver persists changes to the meta bucket shortly after a burst of
+// updates, and tries again later when saving fails
+type Saver struct {
+	name  string
+	delay time.Duration
+	save  func(ctx context.Context) error
+
+	mu    sync.Mutex
+	timer *time.Timer
+}
+
+// NewSaver returns a Saver that calls save; name describes what is saved in
+// error logs
+func NewSaver(name string, delay time.Duration, save func(ctx context.Context) error) *Saver {
+	return &Saver{name: name, delay: delay, save: save}
+}
+
+// Schedule saves after the delay, unless a save is already scheduled
+func (s *Saver) Schedule() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timer != nil {
+		return
+	}
+	s.timer = time.AfterFunc(s.delay, func() {
+		s.mu.Lock()
+		s.timer = nil
+		s.mu.Unlock()
+		if err := s.Save(context.Background()); err != nil {
+			log.Printf("Error saving %s: %v", s.name, err)
+		}
+	})
+}
+
+// Save saves now, scheduling another attempt when it fails
+func (s *Saver) Save(ctx context.Context) error {
+	err := s.save(ctx)
+	if err != nil {
+		s.Schedule()
+	}
+	return err
+}
+
+// Change is a changed document of the meta bucket
+type Change struct {
+	Key string
+	// Doc is the new content, or nil to remove the document
+	Doc any
+	// Retry marks the document as changed again after a failed write
+	Retry func()
+}
+
+// SaveChanges writes or removes changed documents, calling Retry for those
+// that could not be
+func SaveChanges(ctx context.Context, changes []Change) error {
+	var errs []error
+	for _, c := range changes {
+		var err error
+		if c.Doc != nil {
+			err = SaveJSON(ctx, c.Key, c.Doc)
+		} else {
+			err = DeleteJSON(ctx, c.Key)
+		}
+		if err != nil {
+			c.Retry()
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,37 @@
+package minio
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSaverBatchesAndRetries(t *testing.T) {
+	var calls atomic.Int32
+	saved := make(chan struct{}, 4)
+	s := NewSaver("test documents", 10*time.Millisecond, func(ctx context.Context) error {
+		saved <- struct{}{}
+		if calls.Add(1) == 1 {
+			return errors.New("unavailable")
+		}
+		return nil
+	})
+
+	// A burst of changes is saved once; the failed save is tried again
+	for range 5 {
+		s.Schedule()
+	}
+	for range 2 {
+		select {
+		case <-saved:
+		case <-time.After(time.Second):
+			t.Fatalf("%d saves, want 2", calls.Load())
+		}
+	}
+	time.Sleep(30 * time.Millisecond)
+	if n := calls.Load(); n != 2 {
+		t.Errorf("%d saves, want 2", n)
+	}
+}
@@ -0,0 +1,61 @@
+package minio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// ErrNotFound is returned when a stored document does not exist
+var ErrNotFound = errors.New("document not found")
+
+// LoadJSON decodes a JSON document from the meta bucket into v
+func LoadJSON(ctx context.Context, key string, v any) error {
+	object, err := Client.GetObject(ctx, MetaBucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+
+	data, err := io.ReadAll(object)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return ErrNotFound
+		}
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// SaveJSON stores v as a JSON document in the meta bucket
+func SaveJSON(ctx context.Context, key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = Client.PutObject(ctx, MetaBucket, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: "application/json",
+	})
+	return err
+}
+
+// DeleteJSON removes a document from the meta bucket
+func DeleteJSON(ctx context.Context, key string) error {
+	return Client.RemoveObject(ctx, MetaBucket, key, minio.RemoveObjectOptions{})
+}
+
+// ListJSON returns the keys of the documents in the meta bucket under prefix
+func ListJSON(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	for object := range Client.ListObjects(ctx, MetaBucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		keys = append(keys, object.Key)
+	}
+	return keys, nil
+}
@@ -0,0 +1,316 @@
+// Package playback keeps each user's now playing session, the queue and
+// where in it playback is, so that listening moves between devices
+package playback
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"MediaBackend/library"
+	minioClient "MediaBackend/minio"
+)
+
+// sessionPrefix holds one document per user
+const sessionPrefix = "playback/"
+
+const (
+	// MaxQueue limits the length of a queue
+	MaxQueue = 5000
+	// maxClockSkew is how far in the future a client timestamp may lie
+	maxClockSkew = time.Minute
+	// saveDelay batches heartbeats, which devices send every few seconds
+	saveDelay = 10 * time.Second
+)
+
+var (
+	// ErrNotFound is returned when the user has no session
+	ErrNotFound = errors.New("no playback session")
+	// ErrInvalid wraps validation errors
+	ErrInvalid = errors.New("invalid playback session")
+)
+
+// Session is what a user is listening to
+type Session struct {
+	// Queue lists track IDs in play order
+	Queue []string `json:"queue"`
+	// Index is the current entry of the queue
+	Index int `json:"index"`
+	// TrackID is the track of the current entry
+	TrackID string `json:"trackId"`
+	// Position is in seconds from the start of the current track
+	Position float64 `json:"position"`
+	Playing  bool    `json:"playing"`
+	// Updated is when the device reached this state, as it reported it
+	Updated time.Time `json:"updated"`
+	// Device is the device that reported the state
+	Device string `json:"device,omitempty"`
+}
+
+// PositionAt estimates the position at a time, assuming playback went on
+// since the last update
+func (s Session) PositionAt(t time.Time) float64 {
+	if !s.Playing || t.Before(s.Updated) {
+		return s.Position
+	}
+	position := s.Position + t.Sub(s.Updated).Seconds()
+	if track, ok := library.Get(s.TrackID); ok && track.Duration > 0 {
+		position = min(position, track.Duration)
+	}
+	return position
+}
+
+// Heartbeat reports progress within the current queue
+type Heartbeat struct {
+	// Index and TrackID name the entry playing, which may have moved on
+	Index    int     `json:"index"`
+	TrackID  string  `json:"trackId"`
+	Position float64 `json:"position"`
+	// Playing keeps its value when unset
+	Playing *bool     `json:"playing"`
+	Updated time.Time `json:"updated"`
+	Device  string    `json:"device"`
+}
+
+// StaleError is returned for updates older than the stored session, and
+// for heartbeats about a queue that another device has since replaced
+type StaleError struct {
+	Current Session
+}
+
+func (e *StaleError) Error() string {
+	return fmt.Sprintf("the session was changed by %s at %s", deviceName(e.Current.Device), e.Current.Updated.Format(time.RFC3339))
+}
+
+func deviceName(device string) string {
+	if device == "" {
+		return "another device"
+	}
+	return device
+}
+
+// entry is the loaded session of a user, nil when there is none
+type entry struct {
+	session *Session
+	dirty   bool
+}
+
+var (
+	mu       sync.Mutex
+	sessions = map[string]*entry{}
+	saver    = minioClient.NewSaver("playback sessions", saveDelay, saveSessions)
+)
+
+func documentKey(user string) string {
+	return sessionPrefix + url.PathEscape(user) + ".json"
+}
+
+// load returns the session entry of a user, reading it on first use. mu
+// must not be held.
+func load(ctx context.Context, user string) (*entry, error) {
+	mu.Lock()
+	e := sessions[user]
+	mu.Unlock()
+	if e != nil {
+		return e, nil
+	}
+
+	loaded := &entry{}
+	var s Session
+	err := minioClient.LoadJSON(ctx, documentKey(user), &s)
+	switch {
+	case err == nil:
+		loaded.session = &s
+	case !errors.Is(err, minioClient.ErrNotFound):
+		return nil, fmt.Errorf("loading playback session of %s: %w", user, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if e := sessions[user]; e != nil {
+		return e, nil
+	}
+	sessions[user] = loaded
+	return loaded, nil
+}
+
+// checkUpdated defaults a missing timestamp to now and rejects timestamps
+// from the future
+func checkUpdated(updated time.Time) (time.Time, error) {
+	now := time.Now().UTC()
+	if updated.IsZero() {
+		return now, nil
+	}
+	if updated.After(now.Add(maxClockSkew)) {
+		return time.Time{}, fmt.Errorf("%w: updated is in the future", ErrInvalid)
+	}
+	return updated.UTC(), nil
+}
+
+// checkPosition validates a position within a track
+func checkPosition(trackID string, position float64) error {
+	track, _ := library.Get(trackID)
+	if position < 0 || (track.Duration > 0 && position > track.Duration+1) {
+		return fmt.Errorf("%w: position must be between 0 and the duration", ErrInvalid)
+	}
+	return nil
+}
+
+// Get returns the session of a user
+func Get(ctx context.Context, user string) (Session, error) {
+	e, err := load(ctx, user)
+	if err != nil {
+		return Session{}, err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if e.session == nil {
+		return Session{}, ErrNotFound
+	}
+	return copySession(*e.session), nil
+}
+
+// Set replaces the session of a user, typically when a device starts
+// playing a new queue or takes over playback. The latest state wins: a
+// session reached before the stored one is rejected with a *StaleError.
+func Set(ctx context.Context, user string, s Session) (Session, error) {
+	if len(s.Queue) == 0 {
+		return Session{}, fmt.Errorf("%w: queue is empty", ErrInvalid)
+	}
+	if len(s.Queue) > MaxQueue {
+		return Session{}, fmt.Errorf("%w: at most %d queued tracks", ErrInvalid, MaxQueue)
+	}
+	for _, id := range s.Queue {
+		if _, ok := library.Get(id); !ok {
+			return Session{}, fmt.Errorf("%w: unknown track %q", ErrInvalid, id)
+		}
+	}
+	if s.Index < 0 || s.Index >= len(s.Queue) {
+		return Session{}, fmt.Errorf("%w: index must be within the queue", ErrInvalid)
+	}
+	s.TrackID = s.Queue[s.Index]
+	if err := checkPosition(s.TrackID, s.Position); err != nil {
+		return Session{}, err
+	}
+	updated, err := checkUpdated(s.Updated)
+	if err != nil {
+		return Session{}, err
+	}
+	s.Updated = updated
+	s.Queue = append([]string(nil), s.Queue...)
+
+	e, err := load(ctx, user)
+	if err != nil {
+		return Session{}, err
+	}
+	mu.Lock()
+	if e.session != nil && e.session.Updated.After(s.Updated) {
+		current := copySession(*e.session)
+		mu.Unlock()
+		return Session{}, &StaleError{Current: current}
+	}
+	e.session = &s
+	e.dirty = true
+	mu.Unlock()
+
+	saver.Schedule()
+	return copySession(s), nil
+}
+
+// Beat applies a heartbeat to the session of a user. It is rejected with a
+// *StaleError when older than the session or when the entry it names is not
+// in the stored queue, so that the device fetches the session again.
+func Beat(ctx context.Context, user string, h Heartbeat) (Session, error) {
+	if err := checkPosition(h.TrackID, h.Position); err != nil {
+		return Session{}, err
+	}
+	updated, err := checkUpdated(h.Updated)
+	if err != nil {
+		return Session{}, err
+	}
+
+	e, err := load(ctx, user)
+	if err != nil {
+		return Session{}, err
+	}
+	mu.Lock()
+	s := e.session
+	if s == nil {
+		mu.Unlock()
+		return Session{}, ErrNotFound
+	}
+	if s.Updated.After(updated) || h.Index < 0 || h.Index >= len(s.Queue) || s.Queue[h.Index] != h.TrackID {
+		current := copySession(*s)
+		mu.Unlock()
+		return Session{}, &StaleError{Current: current}
+	}
+	s.Index = h.Index
+	s.TrackID = h.TrackID
+	s.Position = h.Position
+	if h.Playing != nil {
+		s.Playing = *h.Playing
+	}
+	s.Updated = updated
+	s.Device = h.Device
+	e.dirty = true
+	result := copySession(*s)
+	mu.Unlock()
+
+	saver.Schedule()
+	return result, nil
+}
+
+// Delete ends the session of a user
+func Delete(ctx context.Context, user string) error {
+	e, err := load(ctx, user)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	found := e.session != nil
+	e.session = nil
+	e.dirty = e.dirty || found
+	mu.Unlock()
+	if !found {
+		return ErrNotFound
+	}
+	saver.Schedule()
+	return nil
+}
+
+func copySession(s Session) Session {
+	s.Queue = append([]string{}, s.Queue...)
+	return s
+}
+
+// Save writes the changed sessions to the meta bucket and removes ended ones
+func Save(ctx context.Context) error {
+	return saver.Save(ctx)
+}
+
+// saveSessions writes the sessions changed since the last save
+func saveSessions(ctx context.Context) error {
+	mu.Lock()
+	var changed []minioClient.Change
+	for user, e := range sessions {
+		if !e.dirty {
+			continue
+		}
+		c := minioClient.Change{Key: documentKey(user), Retry: func() {
+			mu.Lock()
+			e.dirty = true
+			mu.Unlock()
+		}}
+		if e.session != nil {
+			s := copySession(*e.session)
+			c.Doc = &s
+		}
+		changed = append(changed, c)
+		e.dirty = false
+	}
+	mu.Unlock()
+	return minioClient.SaveChanges(ctx, changed)
+}
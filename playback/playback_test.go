@@ -0,0 +1,88 @@
+package playback
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// setSession stores a session in memory for the test
+func setSession(t *testing.T, user string, s *Session) {
+	t.Helper()
+	mu.Lock()
+	sessions[user] = &entry{session: s}
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		delete(sessions, user)
+		mu.Unlock()
+	})
+}
+
+func TestPositionAt(t *testing.T) {
+	updated := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := Session{TrackID: "unknown", Position: 30, Playing: true, Updated: updated}
+	if got := s.PositionAt(updated.Add(15 * time.Second)); got != 45 {
+		t.Errorf("PositionAt while playing = %v, want 45", got)
+	}
+	if got := s.PositionAt(updated.Add(-time.Minute)); got != 30 {
+		t.Errorf("PositionAt before the update = %v, want 30", got)
+	}
+	s.Playing = false
+	if got := s.PositionAt(updated.Add(time.Hour)); got != 30 {
+		t.Errorf("PositionAt while paused = %v, want 30", got)
+	}
+}
+
+func TestBeat(t *testing.T) {
+	start := time.Now().UTC().Add(-time.Minute)
+	setSession(t, "alice", &Session{Queue: []string{"a", "b", "c"}, TrackID: "a", Updated: start, Device: "phone"})
+	ctx := context.Background()
+
+	playing := true
+	s, err := Beat(ctx, "alice", Heartbeat{Index: 1, TrackID: "b", Position: 12, Playing: &playing, Updated: start.Add(time.Second), Device: "laptop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Index != 1 || s.TrackID != "b" || s.Position != 12 || !s.Playing || s.Device != "laptop" {
+		t.Errorf("session = %+v", s)
+	}
+	s.Queue[0] = "changed"
+	if stored, _ := Get(ctx, "alice"); stored.Queue[0] != "a" || !stored.Playing {
+		t.Errorf("stored session = %+v", stored)
+	}
+
+	stale := []Heartbeat{
+		{Index: 1, TrackID: "b", Updated: start},
+		{Index: 2, TrackID: "b", Updated: start.Add(2 * time.Second)},
+		{Index: 3, TrackID: "d", Updated: start.Add(2 * time.Second)},
+	}
+	for _, h := range stale {
+		var staleErr *StaleError
+		if _, err := Beat(ctx, "alice", h); !errors.As(err, &staleErr) || staleErr.Current.Device != "laptop" {
+			t.Errorf("Beat(%+v) = %v, want a StaleError", h, err)
+		}
+	}
+	if _, err := Beat(ctx, "alice", Heartbeat{Index: 1, TrackID: "b", Position: -1}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("negative position: %v", err)
+	}
+	if _, err := Beat(ctx, "alice", Heartbeat{Index: 1, TrackID: "b", Updated: time.Now().Add(time.Hour)}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("future heartbeat: %v", err)
+	}
+
+	setSession(t, "bob", nil)
+	if _, err := Beat(ctx, "bob", Heartbeat{TrackID: "a"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("heartbeat without a session: %v", err)
+	}
+	if _, err := Get(ctx, "bob"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get without a session: %v", err)
+	}
+}
+
+func TestStaleErrorMessage(t *testing.T) {
+	updated := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	if msg := (&StaleError{Current: Session{Updated: updated}}).Error(); msg != "the session was changed by another device at 2024-01-01T12:00:00Z" {
+		t.Errorf("message = %q", msg)
+	}
+}
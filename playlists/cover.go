@@ -0,0 +1,81 @@
+package playlists
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+
+	minioClient "MediaBackend/minio"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// MaxCoverSize bounds cover image uploads
+const MaxCoverSize = 5 << 20
+
+// coverKey returns the meta bucket object holding a playlist's cover image
+func coverKey(id string) string {
+	return "playlists/covers/" + id
+}
+
+// SetCover stores the cover image of a playlist. The content type is
+// sniffed from the data, which must be a JPEG, PNG, GIF or WebP image.
+func SetCover(ctx context.Context, owner, id string, data []byte) (Playlist, error) {
+	contentType := http.DetectContentType(data)
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+	default:
+		return Playlist{}, fmt.Errorf("%w: cover must be a JPEG, PNG, GIF or WebP image, not %s", ErrInvalid, contentType)
+	}
+
+	return Update(ctx, owner, id, func(p *Playlist) error {
+		if _, err := minioClient.PutObject(ctx, minioClient.MetaBucket, coverKey(id), bytes.NewReader(data), int64(len(data)), contentType); err != nil {
+			return fmt.Errorf("saving cover: %w", err)
+		}
+		p.CoverType = contentType
+		return nil
+	})
+}
+
+// DeleteCover removes the cover image of a playlist
+func DeleteCover(ctx context.Context, owner, id string) (Playlist, error) {
+	return Update(ctx, owner, id, func(p *Playlist) error {
+		if p.CoverType == "" {
+			return ErrNotFound
+		}
+		if err := minioClient.RemoveObject(ctx, minioClient.MetaBucket, coverKey(id)); err != nil {
+			return fmt.Errorf("removing cover: %w", err)
+		}
+		p.CoverType = ""
+		return nil
+	})
+}
+
+// OpenCover opens the cover image of a playlist
+func OpenCover(ctx context.Context, owner, id string) (*minio.Object, minio.ObjectInfo, error) {
+	p, err := Get(owner, id)
+	if err != nil {
+		return nil, minio.ObjectInfo{}, err
+	}
+	if p.CoverType == "" {
+		return nil, minio.ObjectInfo{}, ErrNotFound
+	}
+	info, err := minioClient.StatObject(ctx, minioClient.MetaBucket, coverKey(id))
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, info, ErrNotFound
+		}
+		return nil, info, err
+	}
+	object, err := minioClient.GetObject(ctx, minioClient.MetaBucket, coverKey(id))
+	return object, info, err
+}
+
+// CoverURL returns the URL of a playlist's cover image, or "" without one
+func (p *Playlist) CoverURL() string {
+	if p.CoverType == "" {
+		return ""
+	}
+	return "/gomedia/api/playlists/" + p.ID + "/cover"
+}
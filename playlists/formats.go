@@ -0,0 +1,305 @@
+package playlists
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Format is a playlist file format
+type Format string
+
+const (
+	FormatM3U  Format = "m3u8"
+	FormatPLS  Format = "pls"
+	FormatXSPF Format = "xspf"
+)
+
+// FormatFromName returns the playlist format for a file name or extension
+func FormatFromName(name string) (Format, bool) {
+	ext := name
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		ext = name[i+1:]
+	}
+	switch strings.ToLower(ext) {
+	case "m3u8", "m3u":
+		return FormatM3U, true
+	case "pls":
+		return FormatPLS, true
+	case "xspf":
+		return FormatXSPF, true
+	}
+	return "", false
+}
+
+// ContentType returns the MIME type of the format
+func (f Format) ContentType() string {
+	switch f {
+	case FormatPLS:
+		return "audio/x-scpls"
+	case FormatXSPF:
+		return "application/xspf+xml"
+	}
+	return "audio/x-mpegurl; charset=utf-8"
+}
+
+// Entry is a track of a playlist file. Only Location is required; the
+// other fields are set when the file records them.
+type Entry struct {
+	// Line is the line of the location in the file, or the position of the
+	// entry for XSPF
+	Line     int     `json:"line"`
+	Location string  `json:"location"`
+	Title    string  `json:"title,omitempty"`
+	Artist   string  `json:"artist,omitempty"`
+	Album    string  `json:"album,omitempty"`
+	Duration float64 `json:"duration,omitempty"`
+}
+
+// Parse reads a playlist file, detecting the format from its content
+func Parse(r io.Reader) (name string, entries []Entry, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", nil, err
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		return parseXSPF(data)
+	case len(trimmed) >= 10 && strings.EqualFold(string(trimmed[:10]), "[playlist]"):
+		entries, err := parsePLS(data)
+		return "", entries, err
+	default:
+		return parseM3U(data)
+	}
+}
+
+// parseM3U reads plain and extended M3U, taking titles from #EXTINF
+func parseM3U(data []byte) (string, []Entry, error) {
+	var name string
+	var entries []Entry
+	var pending Entry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		switch {
+		case text == "":
+		case strings.HasPrefix(text, "#EXTINF:"):
+			pending = Entry{}
+			info, title, _ := strings.Cut(strings.TrimPrefix(text, "#EXTINF:"), ",")
+			// Attributes such as tvg-id may follow the duration
+			seconds, _, _ := strings.Cut(info, " ")
+			if d, err := strconv.ParseFloat(seconds, 64); err == nil && d > 0 {
+				pending.Duration = d
+			}
+			pending.Artist, pending.Title = splitArtistTitle(strings.TrimSpace(title))
+		case strings.HasPrefix(text, "#EXTALB:"):
+			pending.Album = strings.TrimSpace(strings.TrimPrefix(text, "#EXTALB:"))
+		case strings.HasPrefix(text, "#PLAYLIST:"):
+			name = strings.TrimSpace(strings.TrimPrefix(text, "#PLAYLIST:"))
+		case strings.HasPrefix(text, "#"):
+		default:
+			pending.Line = line
+			pending.Location = text
+			entries = append(entries, pending)
+			pending = Entry{}
+		}
+	}
+	return name, entries, scanner.Err()
+}
+
+// parsePLS reads the FileN, TitleN and LengthN keys of a PLS file
+func parsePLS(data []byte) ([]Entry, error) {
+	byNumber := map[int]*Entry{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		var field string
+		for _, prefix := range []string{"file", "title", "length"} {
+			if strings.HasPrefix(key, prefix) {
+				field = prefix
+				break
+			}
+		}
+		n, err := strconv.Atoi(strings.TrimPrefix(key, field))
+		if field == "" || err != nil {
+			continue
+		}
+		entry := byNumber[n]
+		if entry == nil {
+			entry = &Entry{}
+			byNumber[n] = entry
+		}
+		switch field {
+		case "file":
+			entry.Line = line
+			entry.Location = value
+		case "title":
+			entry.Artist, entry.Title = splitArtistTitle(value)
+		case "length":
+			if d, err := strconv.ParseFloat(value, 64); err == nil && d > 0 {
+				entry.Duration = d
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	numbers := make([]int, 0, len(byNumber))
+	for n, entry := range byNumber {
+		if entry.Location != "" {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+	entries := make([]Entry, len(numbers))
+	for i, n := range numbers {
+		entries[i] = *byNumber[n]
+	}
+	return entries, nil
+}
+
+// xspfPlaylist is the XML form of an XSPF playlist
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"http://xspf.org/ns/0/ playlist"`
+	Version string      `xml:"version,attr"`
+	Title   string      `xml:"title,omitempty"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location []string `xml:"location"`
+	Title    string   `xml:"title,omitempty"`
+	Creator  string   `xml:"creator,omitempty"`
+	Album    string   `xml:"album,omitempty"`
+	TrackNum int      `xml:"trackNum,omitempty"`
+	// Duration is in milliseconds
+	Duration int64 `xml:"duration,omitempty"`
+}
+
+func parseXSPF(data []byte) (string, []Entry, error) {
+	var doc xspfPlaylist
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return "", nil, fmt.Errorf("reading XSPF: %w", err)
+	}
+	entries := make([]Entry, 0, len(doc.Tracks))
+	for i, t := range doc.Tracks {
+		entry := Entry{Line: i + 1, Title: t.Title, Artist: t.Creator, Album: t.Album}
+		if len(t.Location) > 0 {
+			entry.Location = strings.TrimSpace(t.Location[0])
+		}
+		if t.Duration > 0 {
+			entry.Duration = float64(t.Duration) / 1000
+		}
+		entries = append(entries, entry)
+	}
+	return doc.Title, entries, nil
+}
+
+// splitArtistTitle splits the "Artist - Title" form used by M3U and PLS
+func splitArtistTitle(s string) (artist, title string) {
+	if artist, title, ok := strings.Cut(s, " - "); ok {
+		return strings.TrimSpace(artist), strings.TrimSpace(title)
+	}
+	return "", s
+}
+
+// ExportTrack is a track written to a playlist file
+type ExportTrack struct {
+	Location    string
+	Title       string
+	Artist      string
+	Album       string
+	TrackNumber int
+	Duration    float64
+}
+
+// displayTitle is the "Artist - Title" form used by M3U and PLS
+func (t ExportTrack) displayTitle() string {
+	if t.Artist == "" {
+		return t.Title
+	}
+	return t.Artist + " - " + t.Title
+}
+
+// Write writes a playlist file in the given format
+func Write(w io.Writer, format Format, name string, tracks []ExportTrack) error {
+	switch format {
+	case FormatPLS:
+		return writePLS(w, tracks)
+	case FormatXSPF:
+		return writeXSPF(w, name, tracks)
+	default:
+		return writeM3U(w, name, tracks)
+	}
+}
+
+func writeM3U(w io.Writer, name string, tracks []ExportTrack) error {
+	b := bufio.NewWriter(w)
+	b.WriteString("#EXTM3U\n")
+	fmt.Fprintf(b, "#PLAYLIST:%s\n", singleLine(name))
+	for _, t := range tracks {
+		fmt.Fprintf(b, "#EXTINF:%d,%s\n", int(math.Round(t.Duration)), singleLine(t.displayTitle()))
+		if t.Album != "" {
+			fmt.Fprintf(b, "#EXTALB:%s\n", singleLine(t.Album))
+		}
+		fmt.Fprintf(b, "%s\n", t.Location)
+	}
+	return b.Flush()
+}
+
+func writePLS(w io.Writer, tracks []ExportTrack) error {
+	b := bufio.NewWriter(w)
+	b.WriteString("[playlist]\n")
+	for i, t := range tracks {
+		fmt.Fprintf(b, "File%d=%s\n", i+1, t.Location)
+		fmt.Fprintf(b, "Title%d=%s\n", i+1, singleLine(t.displayTitle()))
+		fmt.Fprintf(b, "Length%d=%d\n", i+1, int(math.Round(t.Duration)))
+	}
+	fmt.Fprintf(b, "NumberOfEntries=%d\nVersion=2\n", len(tracks))
+	return b.Flush()
+}
+
+func writeXSPF(w io.Writer, name string, tracks []ExportTrack) error {
+	doc := xspfPlaylist{Version: "1", Title: name, Tracks: make([]xspfTrack, len(tracks))}
+	for i, t := range tracks {
+		doc.Tracks[i] = xspfTrack{
+			Location: []string{t.Location},
+			Title:    t.Title,
+			Creator:  t.Artist,
+			Album:    t.Album,
+			TrackNum: t.TrackNumber,
+			Duration: int64(math.Round(t.Duration * 1000)),
+		}
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// singleLine keeps values from breaking the line-based formats
+func singleLine(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
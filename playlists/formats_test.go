@@ -0,0 +1,105 @@
+package playlists
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseM3U(t *testing.T) {
+	name, entries, err := Parse(strings.NewReader("\xef\xbb\xbf#EXTM3U\r\n#PLAYLIST:Road Trip\r\n" +
+		"#EXTINF:215 tvg-id=\"x\",Artist - Song\r\n#EXTALB:Album\r\nmusic/song.mp3\r\n\r\n# comment\r\nC:\\Music\\other.flac\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entry{
+		{Line: 5, Location: "music/song.mp3", Title: "Song", Artist: "Artist", Album: "Album", Duration: 215},
+		{Line: 8, Location: "C:\\Music\\other.flac"},
+	}
+	if name != "Road Trip" || !reflect.DeepEqual(entries, want) {
+		t.Errorf("Parse = %q, %+v", name, entries)
+	}
+}
+
+func TestParsePLS(t *testing.T) {
+	_, entries, err := Parse(strings.NewReader("[playlist]\nFile2=b.mp3\nTitle2=Only Title\nLength2=-1\n" +
+		"File1=a.mp3\nTitle1=Artist - First\nLength1=61\nTitle3=No file\nNumberOfEntries=3\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entry{
+		{Line: 5, Location: "a.mp3", Title: "First", Artist: "Artist", Duration: 61},
+		{Line: 2, Location: "b.mp3", Title: "Only Title"},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("Parse = %+v", entries)
+	}
+}
+
+func TestParseXSPF(t *testing.T) {
+	name, entries, err := Parse(strings.NewReader(`<?xml version="1.0" encoding="UTF-8"?>
+<playlist version="1" xmlns="http://xspf.org/ns/0/">
+  <title>Mix</title>
+  <trackList>
+    <track><location> file:///music/a.ogg </location><title>A</title><creator>Artist</creator><duration>90500</duration></track>
+    <track><title>No location</title></track>
+  </trackList>
+</playlist>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entry{
+		{Line: 1, Location: "file:///music/a.ogg", Title: "A", Artist: "Artist", Duration: 90.5},
+		{Line: 2, Title: "No location"},
+	}
+	if name != "Mix" || !reflect.DeepEqual(entries, want) {
+		t.Errorf("Parse = %q, %+v", name, entries)
+	}
+	if _, _, err := Parse(strings.NewReader("<playlist>")); err == nil {
+		t.Error("broken XSPF parsed")
+	}
+}
+
+func TestWriteRoundTrip(t *testing.T) {
+	tracks := []ExportTrack{
+		{Location: "http://host/a.mp3", Title: "Song", Artist: "Artist", Album: "Album", TrackNumber: 1, Duration: 200.4},
+		{Location: "http://host/b.mp3", Title: "Line\nbreak", Duration: 30},
+	}
+	for _, format := range []Format{FormatM3U, FormatPLS, FormatXSPF} {
+		var b strings.Builder
+		if err := Write(&b, format, "Mix", tracks); err != nil {
+			t.Fatal(err)
+		}
+		name, entries, err := Parse(strings.NewReader(b.String()))
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if format != FormatPLS && name != "Mix" {
+			t.Errorf("%s: name = %q", format, name)
+		}
+		if len(entries) != 2 {
+			t.Fatalf("%s: entries = %+v", format, entries)
+		}
+		first, second := entries[0], entries[1]
+		if first.Location != tracks[0].Location || first.Title != "Song" || first.Artist != "Artist" || second.Title != "Line break" && second.Title != "Line\nbreak" {
+			t.Errorf("%s: entries = %+v", format, entries)
+		}
+		if format == FormatXSPF && (first.Duration != 200.4 || first.Album != "Album") {
+			t.Errorf("%s: first entry = %+v", format, first)
+		}
+		if format != FormatXSPF && first.Duration != 200 {
+			t.Errorf("%s: duration = %v, want whole seconds", format, first.Duration)
+		}
+	}
+}
+
+func TestFormatFromName(t *testing.T) {
+	for name, want := range map[string]Format{"a.M3U": FormatM3U, "m3u8": FormatM3U, "x.pls": FormatPLS, "list.xspf": FormatXSPF} {
+		if got, ok := FormatFromName(name); !ok || got != want {
+			t.Errorf("FormatFromName(%q) = %q, %v", name, got, ok)
+		}
+	}
+	if _, ok := FormatFromName("list.txt"); ok {
+		t.Error("txt accepted")
+	}
+}
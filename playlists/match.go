@@ -0,0 +1,123 @@
+package playlists
+
+import (
+	"math"
+	"net/url"
+	"strings"
+
+	"MediaBackend/library"
+)
+
+// durationTolerance is how far, in seconds, the duration recorded in a
+// playlist file may be from a track matched by its tags
+const durationTolerance = 10
+
+// Match is the library track found for a playlist file entry
+type Match struct {
+	Entry
+	// TrackID is empty when no track matched
+	TrackID string `json:"trackId,omitempty"`
+	// By is "path" or "tags"
+	By string `json:"by,omitempty"`
+}
+
+// matcherIndex looks up library tracks by path and by tags
+type matcherIndex struct {
+	byPath  map[string]string
+	byTags  map[string][]library.Track
+	byTitle map[string][]library.Track
+}
+
+// MatchEntries finds the library track of each entry, first by path and
+// then by artist, title and album. Paths match when the library path is a
+// trailing part of the location, so that playlists exported from another
+// machine or player resolve against the music bucket.
+func MatchEntries(entries []Entry) []Match {
+	index := matcherIndex{
+		byPath:  map[string]string{},
+		byTags:  map[string][]library.Track{},
+		byTitle: map[string][]library.Track{},
+	}
+	for _, t := range library.Tracks() {
+		index.byPath[strings.ToLower(t.Path)] = t.ID
+		if t.Title == "" {
+			continue
+		}
+		title := normalizeTag(t.Title)
+		index.byTitle[title] = append(index.byTitle[title], t)
+		for _, artist := range []string{t.Artist, t.AlbumArtist} {
+			if artist != "" {
+				key := normalizeTag(artist) + "\x00" + title
+				index.byTags[key] = append(index.byTags[key], t)
+			}
+		}
+	}
+
+	matches := make([]Match, len(entries))
+	for i, entry := range entries {
+		matches[i] = Match{Entry: entry}
+		if id := index.matchPath(entry.Location); id != "" {
+			matches[i].TrackID, matches[i].By = id, "path"
+		} else if id := index.matchTags(entry); id != "" {
+			matches[i].TrackID, matches[i].By = id, "tags"
+		}
+	}
+	return matches
+}
+
+// matchPath returns the track whose path is the longest trailing part of
+// a location, which may be a URL, a Windows path or a relative path
+func (index matcherIndex) matchPath(location string) string {
+	if u, err := url.Parse(location); err == nil && u.Scheme != "" && len(u.Scheme) > 1 {
+		location = u.Path
+	}
+	location = strings.ToLower(strings.ReplaceAll(location, "\\", "/"))
+	segments := strings.Split(strings.Trim(location, "/"), "/")
+	for i := range segments {
+		if id, ok := index.byPath[strings.Join(segments[i:], "/")]; ok {
+			return id
+		}
+	}
+	return ""
+}
+
+// matchTags returns the track with the entry's artist and title, preferring
+// the same album and the closest duration
+func (index matcherIndex) matchTags(entry Entry) string {
+	if entry.Title == "" {
+		return ""
+	}
+	title := normalizeTag(entry.Title)
+	candidates := index.byTitle[title]
+	if entry.Artist != "" {
+		candidates = index.byTags[normalizeTag(entry.Artist)+"\x00"+title]
+	}
+
+	var best *library.Track
+	bestScore := math.Inf(1)
+	for i := range candidates {
+		t := &candidates[i]
+		score := 0.0
+		if entry.Duration > 0 && t.Duration > 0 {
+			score = math.Abs(entry.Duration - t.Duration)
+			if score > durationTolerance {
+				continue
+			}
+		}
+		if entry.Album != "" && normalizeTag(entry.Album) != normalizeTag(t.Album) {
+			score += durationTolerance
+		}
+		if score < bestScore {
+			best, bestScore = t, score
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	return best.ID
+}
+
+// normalizeTag folds case and whitespace for comparing tag values
+func normalizeTag(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
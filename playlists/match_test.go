@@ -0,0 +1,57 @@
+package playlists
+
+import (
+	"testing"
+
+	"MediaBackend/audio"
+	"MediaBackend/library"
+)
+
+func testMatcherIndex() matcherIndex {
+	song := library.Track{ID: "song", Path: "Artist/Album/Song.mp3", Duration: 200,
+		Tags: audio.Tags{Title: "Song", Artist: "The  Artist", Album: "Album"}}
+	live := library.Track{ID: "live", Path: "Artist/Live/Song.mp3", Duration: 260,
+		Tags: audio.Tags{Title: "Song", Artist: "The Artist", Album: "Live"}}
+	return matcherIndex{
+		byPath:  map[string]string{"artist/album/song.mp3": "song", "artist/live/song.mp3": "live"},
+		byTags:  map[string][]library.Track{"the artist\x00song": {song, live}},
+		byTitle: map[string][]library.Track{"song": {song, live}},
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	index := testMatcherIndex()
+	tests := map[string]string{
+		"Artist/Album/Song.mp3":                       "song",
+		`C:\Users\me\Music\artist\live\SONG.mp3`:      "live",
+		"file:///home/me/Music/Artist/Album/Song.mp3": "song",
+		"http://host/music/Artist/Live/Song.mp3":      "live",
+		"Song.mp3":                                    "",
+		"Other/Album/Song.mp3/extra":                  "",
+	}
+	for location, want := range tests {
+		if got := index.matchPath(location); got != want {
+			t.Errorf("matchPath(%q) = %q, want %q", location, got, want)
+		}
+	}
+}
+
+func TestMatchTags(t *testing.T) {
+	index := testMatcherIndex()
+	tests := []struct {
+		entry Entry
+		want  string
+	}{
+		{Entry{Title: "song", Artist: "the artist", Album: "Live"}, "live"},
+		{Entry{Title: "Song", Artist: "The Artist", Duration: 255}, "live"},
+		{Entry{Title: "Song", Duration: 198}, "song"},
+		{Entry{Title: "Song", Artist: "The Artist", Duration: 400}, ""},
+		{Entry{Title: "Song", Artist: "Someone Else"}, ""},
+		{Entry{Artist: "The Artist"}, ""},
+	}
+	for _, tt := range tests {
+		if got := index.matchTags(tt.entry); got != tt.want {
+			t.Errorf("matchTags(%+v) = %q, want %q", tt.entry, got, tt.want)
+		}
+	}
+}
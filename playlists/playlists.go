@@ -0,0 +1,477 @@
+package playlists
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"MediaBackend/library"
+	minioClient "MediaBackend/minio"
+	"MediaBackend/randid"
+)
+
+// indexKey is the meta bucket object holding every playlist
+const indexKey = "playlists/index.json"
+
+// Limits on playlist fields
+const (
+	MaxNameLength        = 200
+	MaxDescriptionLength = 4000
+	MaxTracks            = 10000
+)
+
+// ErrNotFound is returned for playlists that do not exist or belong to
+// another user
+var ErrNotFound = errors.New("playlist not found")
+
+// ErrInvalid wraps validation errors
+var ErrInvalid = errors.New("invalid playlist")
+
+// Playlist is an ordered list of library tracks owned by a user. Tracks
+// are referenced by library ID, which follows a file when it is moved, and
+// may list the same track more than once.
+type Playlist struct {
+	ID          string `json:"id"`
+	Owner       string `json:"owner"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// CoverType is the content type of the cover image, empty without one
+	CoverType string   `json:"coverType,omitempty"`
+	Tracks    []string `json:"tracks"`
+	// Rules make a smart playlist, whose tracks are chosen by evaluating
+	// them rather than edited directly
+	Rules *Rules `json:"rules,omitempty"`
+	// Seed fixes the order of smart playlists sorted at random
+	Seed    int64     `json:"seed,omitempty"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// ErrSmartPlaylist is returned when the tracks of a smart playlist are
+// edited directly
+var ErrSmartPlaylist = fmt.Errorf("%w: the tracks of a smart playlist are chosen by its rules", ErrInvalid)
+
+// indexDocument is the persisted form of all playlists
+type indexDocument struct {
+	Playlists []*Playlist `json:"playlists"`
+}
+
+var (
+	mu        sync.RWMutex
+	playlists = map[string]*Playlist{}
+
+	// writeMu serialises changes, which are saved before they are applied
+	writeMu sync.Mutex
+)
+
+// Load reads the persisted playlists from the meta bucket
+func Load(ctx context.Context) error {
+	var doc indexDocument
+	if err := minioClient.LoadJSON(ctx, indexKey, &doc); err != nil {
+		if errors.Is(err, minioClient.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	playlists = make(map[string]*Playlist, len(doc.Playlists))
+	for _, p := range doc.Playlists {
+		playlists[p.ID] = p
+	}
+	log.Printf("✓ Loaded playlists (%d)", len(playlists))
+	return nil
+}
+
+// List returns the playlists of a user, most recently updated first
+func List(owner string) []Playlist {
+	mu.RLock()
+	var list []Playlist
+	for _, p := range playlists {
+		if p.Owner == owner {
+			list = append(list, p.clone())
+		}
+	}
+	mu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Updated.After(list[j].Updated) })
+	return list
+}
+
+// Get returns a playlist of a user. The tracks of a smart playlist are
+// evaluated again, as they depend on the listening history and the time.
+func Get(owner, id string) (Playlist, error) {
+	p, err := stored(owner, id)
+	if err != nil {
+		return Playlist{}, err
+	}
+	if p.Rules != nil {
+		if err := p.evaluate(); err != nil {
+			return Playlist{}, err
+		}
+	}
+	return p, nil
+}
+
+// Lookup returns a playlist by ID whoever owns it, like Get, for playlists
+// the server itself plays such as those of radio stations
+func Lookup(id string) (Playlist, error) {
+	mu.RLock()
+	p, ok := playlists[id]
+	owner := ""
+	if ok {
+		owner = p.Owner
+	}
+	mu.RUnlock()
+	if !ok {
+		return Playlist{}, ErrNotFound
+	}
+	return Get(owner, id)
+}
+
+// stored returns a copy of a playlist of a user as last saved
+func stored(owner, id string) (Playlist, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	p, ok := playlists[id]
+	if !ok || p.Owner != owner {
+		return Playlist{}, ErrNotFound
+	}
+	return p.clone(), nil
+}
+
+// Create stores a new playlist for a user
+func Create(ctx context.Context, owner string, p Playlist) (Playlist, error) {
+	p.ID = randid.Hex(8)
+	p.Owner = owner
+	p.CoverType = ""
+	p.Created = time.Now().UTC()
+	p.Updated = p.Created
+	if p.Tracks == nil {
+		p.Tracks = []string{}
+	}
+	if p.Rules != nil {
+		if len(p.Tracks) > 0 {
+			return Playlist{}, ErrSmartPlaylist
+		}
+		p.Seed = newSeed()
+		if err := p.evaluate(); err != nil {
+			return Playlist{}, err
+		}
+	}
+	if err := p.validate(nil); err != nil {
+		return Playlist{}, err
+	}
+
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	if err := commit(ctx, "", &p); err != nil {
+		return Playlist{}, err
+	}
+	return p, nil
+}
+
+// Update applies fn to a copy of a playlist and stores the result when it
+// is valid. Tracks added by fn must exist in the library. fn may not edit
+// the tracks of a smart playlist, which are evaluated from its rules; when
+// it removes the rules, the playlist keeps its current tracks.
+func Update(ctx context.Context, owner, id string, fn func(p *Playlist) error) (Playlist, error) {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+
+	p, err := Get(owner, id)
+	if err != nil {
+		return Playlist{}, err
+	}
+	before := p.clone()
+	if err := fn(&p); err != nil {
+		return Playlist{}, err
+	}
+	p.ID, p.Owner, p.Created = before.ID, before.Owner, before.Created
+	if p.Rules != nil {
+		if !slices.Equal(p.Tracks, before.Tracks) {
+			return Playlist{}, ErrSmartPlaylist
+		}
+		if p.Seed == 0 {
+			p.Seed = newSeed()
+		}
+		if err := p.evaluate(); err != nil {
+			return Playlist{}, err
+		}
+	} else {
+		p.Seed = 0
+	}
+	if err := p.validate(before.Tracks); err != nil {
+		return Playlist{}, err
+	}
+	p.Updated = time.Now().UTC()
+	if err := commit(ctx, "", &p); err != nil {
+		return Playlist{}, err
+	}
+	return p, nil
+}
+
+// Delete removes a playlist and its cover image
+func Delete(ctx context.Context, owner, id string) error {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+
+	p, err := stored(owner, id)
+	if err != nil {
+		return err
+	}
+	if err := commit(ctx, id); err != nil {
+		return err
+	}
+	if p.CoverType != "" {
+		if err := minioClient.RemoveObject(ctx, minioClient.MetaBucket, coverKey(id)); err != nil {
+			log.Printf("Error removing playlist cover %s: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// commit saves the index with the playlist deleted removed, if set, and
+// the given playlists stored, and then applies the change in memory.
+// writeMu must be held.
+func commit(ctx context.Context, deleted string, changed ...*Playlist) error {
+	replaced := make(map[string]bool, len(changed)+1)
+	for _, p := range changed {
+		replaced[p.ID] = true
+	}
+	replaced[deleted] = true
+
+	mu.RLock()
+	doc := indexDocument{Playlists: make([]*Playlist, 0, len(playlists)+1)}
+	for id, existing := range playlists {
+		if !replaced[id] {
+			doc.Playlists = append(doc.Playlists, existing)
+		}
+	}
+	mu.RUnlock()
+	doc.Playlists = append(doc.Playlists, changed...)
+	sort.Slice(doc.Playlists, func(i, j int) bool { return doc.Playlists[i].ID < doc.Playlists[j].ID })
+
+	if err := minioClient.SaveJSON(ctx, indexKey, doc); err != nil {
+		return fmt.Errorf("saving playlists: %w", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if deleted != "" {
+		delete(playlists, deleted)
+	}
+	for _, p := range changed {
+		stored := p.clone()
+		playlists[p.ID] = &stored
+	}
+	return nil
+}
+
+// Refresh evaluates the smart playlists again and saves those whose tracks
+// changed, e.g. after a library scan
+func Refresh(ctx context.Context) error {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+
+	mu.RLock()
+	var smart []Playlist
+	for _, p := range playlists {
+		if p.Rules != nil {
+			smart = append(smart, p.clone())
+		}
+	}
+	mu.RUnlock()
+
+	var changed []*Playlist
+	for i := range smart {
+		p := &smart[i]
+		before := p.Tracks
+		if err := p.evaluate(); err != nil {
+			log.Printf("Error evaluating smart playlist %s: %v", p.ID, err)
+			continue
+		}
+		if !slices.Equal(before, p.Tracks) {
+			changed = append(changed, p)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	return commit(ctx, "", changed...)
+}
+
+// Reshuffle picks a new random order for a smart playlist sorted at random
+func Reshuffle(ctx context.Context, owner, id string) (Playlist, error) {
+	return Update(ctx, owner, id, func(p *Playlist) error {
+		if p.Rules == nil {
+			return fmt.Errorf("%w: not a smart playlist", ErrInvalid)
+		}
+		p.Seed = newSeed()
+		return nil
+	})
+}
+
+// evaluate sets the tracks of a smart playlist from its rules
+func (p *Playlist) evaluate() error {
+	ids, err := p.Rules.Evaluate(p.Owner, p.Seed)
+	if err != nil {
+		return err
+	}
+	p.Tracks = ids
+	return nil
+}
+
+// validate checks the fields of a playlist. Tracks not in previous must
+// exist in the library; entries already in the playlist may refer to
+// tracks that have since been deleted.
+func (p *Playlist) validate(previous []string) error {
+	p.Name = strings.TrimSpace(p.Name)
+	switch {
+	case p.Name == "":
+		return fmt.Errorf("%w: name is required", ErrInvalid)
+	case utf8.RuneCountInString(p.Name) > MaxNameLength:
+		return fmt.Errorf("%w: name is longer than %d characters", ErrInvalid, MaxNameLength)
+	case utf8.RuneCountInString(p.Description) > MaxDescriptionLength:
+		return fmt.Errorf("%w: description is longer than %d characters", ErrInvalid, MaxDescriptionLength)
+	case len(p.Tracks) > MaxTracks:
+		return fmt.Errorf("%w: more than %d tracks", ErrInvalid, MaxTracks)
+	}
+
+	known := make(map[string]bool, len(previous))
+	for _, id := range previous {
+		known[id] = true
+	}
+	var unknown []string
+	for _, id := range p.Tracks {
+		if known[id] {
+			continue
+		}
+		if _, ok := library.Get(id); !ok {
+			unknown = append(unknown, id)
+		}
+		known[id] = true
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("%w: unknown tracks %s", ErrInvalid, strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+func (p *Playlist) clone() Playlist {
+	c := *p
+	c.Tracks = append([]string{}, p.Tracks...)
+	if p.Rules != nil {
+		rules := p.Rules.clone()
+		c.Rules = &rules
+	}
+	return c
+}
+
+// Insert adds tracks at position, or at the end when position is negative
+// or past the end
+func (p *Playlist) Insert(position int, ids ...string) {
+	if position < 0 || position > len(p.Tracks) {
+		position = len(p.Tracks)
+	}
+	tracks := make([]string, 0, len(p.Tracks)+len(ids))
+	tracks = append(tracks, p.Tracks[:position]...)
+	tracks = append(tracks, ids...)
+	p.Tracks = append(tracks, p.Tracks[position:]...)
+}
+
+// RemoveAt removes the entries at the given positions
+func (p *Playlist) RemoveAt(positions ...int) error {
+	drop := make(map[int]bool, len(positions))
+	for _, i := range positions {
+		if i < 0 || i >= len(p.Tracks) {
+			return fmt.Errorf("%w: position %d out of range", ErrInvalid, i)
+		}
+		drop[i] = true
+	}
+	kept := p.Tracks[:0]
+	for i, id := range p.Tracks {
+		if !drop[i] {
+			kept = append(kept, id)
+		}
+	}
+	p.Tracks = kept
+	return nil
+}
+
+// RemoveTrack removes every entry of a track and returns how many there were
+func (p *Playlist) RemoveTrack(id string) int {
+	kept := p.Tracks[:0]
+	for _, t := range p.Tracks {
+		if t != id {
+			kept = append(kept, t)
+		}
+	}
+	removed := len(p.Tracks) - len(kept)
+	p.Tracks = kept
+	return removed
+}
+
+// Move moves count entries starting at from so that the first of them ends
+// up at position to of the resulting list
+func (p *Playlist) Move(from, count, to int) error {
+	if count < 1 || from < 0 || from+count > len(p.Tracks) {
+		return fmt.Errorf("%w: range %d+%d out of range", ErrInvalid, from, count)
+	}
+	if to < 0 || to > len(p.Tracks)-count {
+		return fmt.Errorf("%w: target position %d out of range", ErrInvalid, to)
+	}
+	moved := p.Tracks[from : from+count]
+	rest := make([]string, 0, len(p.Tracks)-count)
+	rest = append(rest, p.Tracks[:from]...)
+	rest = append(rest, p.Tracks[from+count:]...)
+
+	tracks := make([]string, 0, len(p.Tracks))
+	tracks = append(tracks, rest[:to]...)
+	tracks = append(tracks, moved...)
+	p.Tracks = append(tracks, rest[to:]...)
+	return nil
+}
+
+// Dedupe removes repeated entries, keeping the first of each, and returns
+// how many were removed
+func (p *Playlist) Dedupe() int {
+	seen := make(map[string]bool, len(p.Tracks))
+	kept := p.Tracks[:0]
+	for _, id := range p.Tracks {
+		if !seen[id] {
+			seen[id] = true
+			kept = append(kept, id)
+		}
+	}
+	removed := len(p.Tracks) - len(kept)
+	p.Tracks = kept
+	return removed
+}
+
+// Contains reports whether a track is in the playlist
+func (p *Playlist) Contains(id string) bool {
+	for _, t := range p.Tracks {
+		if t == id {
+			return true
+		}
+	}
+	return false
+}
+
+// newSeed returns a random non-zero seed for random order
+func newSeed() int64 {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return int64(binary.BigEndian.Uint64(b)>>1) | 1
+}
@@ -0,0 +1,105 @@
+package playlists
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInsert(t *testing.T) {
+	tests := []struct {
+		position int
+		want     []string
+	}{
+		{0, []string{"x", "y", "a", "b"}},
+		{1, []string{"a", "x", "y", "b"}},
+		{2, []string{"a", "b", "x", "y"}},
+		{-1, []string{"a", "b", "x", "y"}},
+		{9, []string{"a", "b", "x", "y"}},
+	}
+	for _, tt := range tests {
+		p := Playlist{Tracks: []string{"a", "b"}}
+		p.Insert(tt.position, "x", "y")
+		if !reflect.DeepEqual(p.Tracks, tt.want) {
+			t.Errorf("Insert(%d) = %v, want %v", tt.position, p.Tracks, tt.want)
+		}
+	}
+}
+
+func TestRemove(t *testing.T) {
+	p := Playlist{Tracks: []string{"a", "b", "a", "c", "a"}}
+	if err := p.RemoveAt(1, 3); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "a", "a"}; !reflect.DeepEqual(p.Tracks, want) {
+		t.Errorf("after RemoveAt: %v, want %v", p.Tracks, want)
+	}
+	if err := p.RemoveAt(0, 3); !errors.Is(err, ErrInvalid) || len(p.Tracks) != 3 {
+		t.Errorf("out of range RemoveAt: %v, tracks %v", err, p.Tracks)
+	}
+	if n := p.RemoveTrack("a"); n != 3 || len(p.Tracks) != 0 {
+		t.Errorf("RemoveTrack removed %d, left %v", n, p.Tracks)
+	}
+}
+
+func TestMove(t *testing.T) {
+	tests := []struct {
+		from, count, to int
+		want            []string
+	}{
+		{0, 1, 4, []string{"b", "c", "d", "e", "a"}},
+		{3, 2, 0, []string{"d", "e", "a", "b", "c"}},
+		{1, 2, 2, []string{"a", "d", "b", "c", "e"}},
+		{2, 1, 2, []string{"a", "b", "c", "d", "e"}},
+	}
+	for _, tt := range tests {
+		p := Playlist{Tracks: []string{"a", "b", "c", "d", "e"}}
+		if err := p.Move(tt.from, tt.count, tt.to); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(p.Tracks, tt.want) {
+			t.Errorf("Move(%d, %d, %d) = %v, want %v", tt.from, tt.count, tt.to, p.Tracks, tt.want)
+		}
+	}
+	p := Playlist{Tracks: []string{"a", "b", "c"}}
+	for _, args := range [][3]int{{0, 0, 0}, {2, 2, 0}, {0, 1, 3}, {-1, 1, 0}} {
+		if err := p.Move(args[0], args[1], args[2]); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Move%v = %v, want ErrInvalid", args, err)
+		}
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	p := Playlist{Tracks: []string{"a", "b", "a", "c", "b"}}
+	if n := p.Dedupe(); n != 2 || !reflect.DeepEqual(p.Tracks, []string{"a", "b", "c"}) {
+		t.Errorf("Dedupe removed %d, left %v", n, p.Tracks)
+	}
+	if !p.Contains("c") || p.Contains("d") {
+		t.Error("Contains is wrong after Dedupe")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	p := Playlist{Name: "  Mix  ", Tracks: []string{"gone", "gone"}}
+	if err := p.validate([]string{"gone"}); err != nil || p.Name != "Mix" {
+		t.Errorf("validate = %v with name %q", err, p.Name)
+	}
+
+	tests := []struct {
+		p    Playlist
+		want string
+	}{
+		{Playlist{Name: " "}, "name is required"},
+		{Playlist{Name: strings.Repeat("é", MaxNameLength+1)}, "name is longer"},
+		{Playlist{Name: "x", Description: strings.Repeat("d", MaxDescriptionLength+1)}, "description is longer"},
+		{Playlist{Name: "x", Tracks: make([]string, MaxTracks+1)}, "more than"},
+		{Playlist{Name: "x", Tracks: []string{"gone", "missing"}}, "unknown tracks missing"},
+	}
+	for _, tt := range tests {
+		err := tt.p.validate([]string{"gone"})
+		if !errors.Is(err, ErrInvalid) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("validate(%.20q) = %v, want %q", tt.p.Name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,479 @@
+package playlists
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"MediaBackend/library"
+)
+
+// Rules select the tracks of a smart playlist, e.g.
+//
+//	{"match": "all", "conditions": [
+//	  {"field": "genre", "op": "is", "value": "Jazz"},
+//	  {"field": "year", "op": "lt", "value": 1970},
+//	  {"field": "lastPlayed", "op": "notInLast", "value": "30d"}
+//	], "sort": "random", "limit": 50}
+type Rules struct {
+	// Match is "all" (the default) or "any"
+	Match      string      `json:"match,omitempty"`
+	Conditions []Condition `json:"conditions"`
+	// Sort lists comma-separated fields, each descending with a "-" prefix,
+	// or is "random"
+	Sort  string `json:"sort,omitempty"`
+	Limit int    `json:"limit,omitempty"`
+}
+
+// Condition compares a track field with a value, or groups conditions
+// when Conditions is set
+type Condition struct {
+	Field string          `json:"field,omitempty"`
+	Op    string          `json:"op,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+
+	Match      string      `json:"match,omitempty"`
+	Conditions []Condition `json:"conditions,omitempty"`
+}
+
+// PlayHistory provides the listening statistics that rules can refer to
+type PlayHistory interface {
+	// PlayStats returns how often a user played a track and when last
+	PlayStats(user, trackID string) (count int, last time.Time)
+}
+
+// History is consulted for the playCount and lastPlayed fields. While it
+// is nil every track counts as never played.
+var History PlayHistory
+
+// Problem is a validation error at a position in the rules, such as
+// "conditions[1].value"
+type Problem struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// RulesError lists every problem found in a set of rules
+type RulesError struct {
+	Problems []Problem
+}
+
+func (e *RulesError) Error() string {
+	parts := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		parts[i] = p.Path + ": " + p.Message
+	}
+	return "invalid rules: " + strings.Join(parts, "; ")
+}
+
+// Is makes RulesError match ErrInvalid
+func (e *RulesError) Is(target error) bool {
+	return target == ErrInvalid
+}
+
+// fieldKind is the type of value a field holds
+type fieldKind int
+
+const (
+	textField fieldKind = iota
+	numberField
+	timeField
+)
+
+// ruleTrack is a track together with the user's statistics for it
+type ruleTrack struct {
+	*library.Track
+	playCount  int
+	lastPlayed time.Time
+}
+
+type ruleField struct {
+	kind   fieldKind
+	text   func(t *ruleTrack) string
+	number func(t *ruleTrack) float64
+	time   func(t *ruleTrack) time.Time
+}
+
+// ruleFields are the fields rules and sorting can use
+var ruleFields = map[string]ruleField{
+	"title":       {kind: textField, text: func(t *ruleTrack) string { return t.Title }},
+	"artist":      {kind: textField, text: func(t *ruleTrack) string { return t.Artist }},
+	"album":       {kind: textField, text: func(t *ruleTrack) string { return t.Album }},
+	"albumArtist": {kind: textField, text: func(t *ruleTrack) string { return t.AlbumArtist }},
+	"genre":       {kind: textField, text: func(t *ruleTrack) string { return t.Genre }},
+	"comment":     {kind: textField, text: func(t *ruleTrack) string { return t.Comment }},
+	"path":        {kind: textField, text: func(t *ruleTrack) string { return t.Path }},
+	"format":      {kind: textField, text: func(t *ruleTrack) string { return t.Format }},
+	"year":        {kind: numberField, number: func(t *ruleTrack) float64 { return float64(t.Year) }},
+	"trackNumber": {kind: numberField, number: func(t *ruleTrack) float64 { return float64(t.TrackNumber) }},
+	"discNumber":  {kind: numberField, number: func(t *ruleTrack) float64 { return float64(t.DiscNumber) }},
+	"duration":    {kind: numberField, number: func(t *ruleTrack) float64 { return t.Duration }},
+	"bitrate":     {kind: numberField, number: func(t *ruleTrack) float64 { return float64(t.Bitrate) }},
+	"sampleRate":  {kind: numberField, number: func(t *ruleTrack) float64 { return float64(t.SampleRate) }},
+	"channels":    {kind: numberField, number: func(t *ruleTrack) float64 { return float64(t.Channels) }},
+	"playCount":   {kind: numberField, number: func(t *ruleTrack) float64 { return float64(t.playCount) }},
+	"added":       {kind: timeField, time: func(t *ruleTrack) time.Time { return t.Added }},
+	"modified":    {kind: timeField, time: func(t *ruleTrack) time.Time { return t.Modified }},
+	"lastPlayed":  {kind: timeField, time: func(t *ruleTrack) time.Time { return t.lastPlayed }},
+}
+
+// ruleOps lists the operators of each field kind
+var ruleOps = map[fieldKind][]string{
+	textField:   {"is", "isNot", "contains", "notContains", "startsWith", "endsWith"},
+	numberField: {"eq", "ne", "lt", "lte", "gt", "gte", "between"},
+	timeField:   {"inLast", "notInLast", "before", "after"},
+}
+
+// clone copies rules, so that stored playlists are not shared
+func (r *Rules) clone() Rules {
+	c := *r
+	c.Conditions = cloneConditions(r.Conditions)
+	return c
+}
+
+func cloneConditions(conditions []Condition) []Condition {
+	if conditions == nil {
+		return nil
+	}
+	list := make([]Condition, len(conditions))
+	for i, c := range conditions {
+		c.Value = append(json.RawMessage(nil), c.Value...)
+		c.Conditions = cloneConditions(c.Conditions)
+		list[i] = c
+	}
+	return list
+}
+
+// matcher tests a track against compiled rules
+type matcher func(t *ruleTrack, now time.Time) bool
+
+// Validate checks rules and returns a *RulesError listing every problem
+func (r *Rules) Validate() error {
+	_, err := r.compile()
+	return err
+}
+
+// compile validates the rules and builds the matcher
+func (r *Rules) compile() (matcher, error) {
+	var problems []Problem
+	add := func(path, format string, args ...any) {
+		problems = append(problems, Problem{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	match := compileGroup(r.Match, r.Conditions, "", add)
+	if r.Limit < 0 || r.Limit > MaxTracks {
+		add("limit", "must be between 0 and %d", MaxTracks)
+	}
+	if _, err := parseSort(r.Sort); err != nil {
+		add("sort", "%v", err)
+	}
+	if len(problems) > 0 {
+		return nil, &RulesError{Problems: problems}
+	}
+	return match, nil
+}
+
+func compileGroup(mode string, conditions []Condition, path string, add func(path, format string, args ...any)) matcher {
+	prefix := path
+	if prefix != "" {
+		prefix += "."
+	}
+	if mode != "" && mode != "all" && mode != "any" {
+		add(prefix+"match", `must be "all" or "any"`)
+	}
+	if len(conditions) == 0 {
+		add(prefix+"conditions", "at least one condition is required")
+	}
+
+	matchers := make([]matcher, 0, len(conditions))
+	for i, c := range conditions {
+		at := fmt.Sprintf("%sconditions[%d]", prefix, i)
+		if c.Conditions != nil || c.Match != "" {
+			if c.Field != "" || c.Op != "" || c.Value != nil {
+				add(at, "a group cannot also have a field, op or value")
+			}
+			matchers = append(matchers, compileGroup(c.Match, c.Conditions, at, add))
+			continue
+		}
+		if m := compileCondition(c, at, add); m != nil {
+			matchers = append(matchers, m)
+		}
+	}
+
+	if mode == "any" {
+		return func(t *ruleTrack, now time.Time) bool {
+			for _, m := range matchers {
+				if m(t, now) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return func(t *ruleTrack, now time.Time) bool {
+		for _, m := range matchers {
+			if !m(t, now) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func compileCondition(c Condition, at string, add func(path, format string, args ...any)) matcher {
+	field, ok := ruleFields[c.Field]
+	if !ok {
+		add(at+".field", "unknown field %q", c.Field)
+		return nil
+	}
+	known := false
+	for _, op := range ruleOps[field.kind] {
+		known = known || op == c.Op
+	}
+	if !known {
+		add(at+".op", "field %q supports %s", c.Field, strings.Join(ruleOps[field.kind], ", "))
+		return nil
+	}
+	if c.Value == nil {
+		add(at+".value", "a value is required")
+		return nil
+	}
+
+	switch field.kind {
+	case textField:
+		var value string
+		if err := json.Unmarshal(c.Value, &value); err != nil {
+			add(at+".value", "must be a string")
+			return nil
+		}
+		return textMatcher(field.text, c.Op, strings.ToLower(value))
+
+	case numberField:
+		if c.Op == "between" {
+			var bounds []float64
+			if err := json.Unmarshal(c.Value, &bounds); err != nil || len(bounds) != 2 || bounds[0] > bounds[1] {
+				add(at+".value", "must be [min, max]")
+				return nil
+			}
+			return func(t *ruleTrack, _ time.Time) bool {
+				v := field.number(t)
+				return v >= bounds[0] && v <= bounds[1]
+			}
+		}
+		var value float64
+		if err := json.Unmarshal(c.Value, &value); err != nil {
+			add(at+".value", "must be a number")
+			return nil
+		}
+		return numberMatcher(field.number, c.Op, value)
+
+	default:
+		var value string
+		if err := json.Unmarshal(c.Value, &value); err != nil {
+			add(at+".value", "must be a string")
+			return nil
+		}
+		if c.Op == "inLast" || c.Op == "notInLast" {
+			d, err := parseRuleDuration(value)
+			if err != nil {
+				add(at+".value", "%v", err)
+				return nil
+			}
+			// Times never set, e.g. tracks never played, are not in any period
+			inLast := func(t *ruleTrack, now time.Time) bool {
+				v := field.time(t)
+				return !v.IsZero() && v.After(now.Add(-d))
+			}
+			if c.Op == "notInLast" {
+				return func(t *ruleTrack, now time.Time) bool { return !inLast(t, now) }
+			}
+			return inLast
+		}
+		at2, err := parseRuleTime(value)
+		if err != nil {
+			add(at+".value", "%v", err)
+			return nil
+		}
+		if c.Op == "before" {
+			return func(t *ruleTrack, _ time.Time) bool {
+				v := field.time(t)
+				return !v.IsZero() && v.Before(at2)
+			}
+		}
+		return func(t *ruleTrack, _ time.Time) bool { return field.time(t).After(at2) }
+	}
+}
+
+func textMatcher(get func(t *ruleTrack) string, op, value string) matcher {
+	var test func(s string) bool
+	switch op {
+	case "is":
+		test = func(s string) bool { return s == value }
+	case "isNot":
+		test = func(s string) bool { return s != value }
+	case "contains":
+		test = func(s string) bool { return strings.Contains(s, value) }
+	case "notContains":
+		test = func(s string) bool { return !strings.Contains(s, value) }
+	case "startsWith":
+		test = func(s string) bool { return strings.HasPrefix(s, value) }
+	default:
+		test = func(s string) bool { return strings.HasSuffix(s, value) }
+	}
+	return func(t *ruleTrack, _ time.Time) bool { return test(strings.ToLower(get(t))) }
+}
+
+func numberMatcher(get func(t *ruleTrack) float64, op string, value float64) matcher {
+	var test func(v float64) bool
+	switch op {
+	case "eq":
+		test = func(v float64) bool { return v == value }
+	case "ne":
+		test = func(v float64) bool { return v != value }
+	case "lt":
+		test = func(v float64) bool { return v < value }
+	case "lte":
+		test = func(v float64) bool { return v <= value }
+	case "gt":
+		test = func(v float64) bool { return v > value }
+	default:
+		test = func(v float64) bool { return v >= value }
+	}
+	return func(t *ruleTrack, _ time.Time) bool { return test(get(t)) }
+}
+
+// parseRuleDuration parses periods such as "30d", "2w" or "12h"
+func parseRuleDuration(s string) (time.Duration, error) {
+	if len(s) >= 2 {
+		n, err := strconv.Atoi(s[:len(s)-1])
+		if err == nil && n > 0 {
+			switch s[len(s)-1] {
+			case 'h':
+				return time.Duration(n) * time.Hour, nil
+			case 'd':
+				return time.Duration(n) * 24 * time.Hour, nil
+			case 'w':
+				return time.Duration(n) * 7 * 24 * time.Hour, nil
+			case 'y':
+				return time.Duration(n) * 365 * 24 * time.Hour, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("period %q must be a number followed by h, d, w or y", s)
+}
+
+// parseRuleTime parses a date ("2024-01-31") or an RFC 3339 time
+func parseRuleTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("time %q must be a date (2006-01-02) or RFC 3339 time", s)
+}
+
+// sortKey is a field to sort by
+type sortKey struct {
+	field ruleField
+	desc  bool
+}
+
+// parseSort parses the sort order; a nil result with no error means random
+func parseSort(s string) ([]sortKey, error) {
+	if s == "" {
+		return []sortKey{}, nil
+	}
+	if s == "random" {
+		return nil, nil
+	}
+	var keys []sortKey
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		desc := strings.HasPrefix(name, "-")
+		field, ok := ruleFields[strings.TrimPrefix(name, "-")]
+		if !ok {
+			return nil, fmt.Errorf("unknown sort field %q", name)
+		}
+		keys = append(keys, sortKey{field, desc})
+	}
+	return keys, nil
+}
+
+// Evaluate returns the IDs of the library tracks matching the rules for a
+// user. Random order is derived from seed, so it stays stable until the
+// seed changes and new tracks slot in without reshuffling the rest.
+func (r *Rules) Evaluate(user string, seed int64) ([]string, error) {
+	match, err := r.compile()
+	if err != nil {
+		return nil, err
+	}
+	keys, _ := parseSort(r.Sort)
+
+	now := time.Now()
+	tracks := library.Tracks()
+	var matched []*ruleTrack
+	for i := range tracks {
+		t := &ruleTrack{Track: &tracks[i]}
+		if History != nil {
+			t.playCount, t.lastPlayed = History.PlayStats(user, t.ID)
+		}
+		if match(t, now) {
+			matched = append(matched, t)
+		}
+	}
+
+	if keys == nil {
+		order := make(map[string]uint64, len(matched))
+		for _, t := range matched {
+			h := fnv.New64a()
+			fmt.Fprintf(h, "%d/%s", seed, t.ID)
+			order[t.ID] = h.Sum64()
+		}
+		sort.Slice(matched, func(i, j int) bool { return order[matched[i].ID] < order[matched[j].ID] })
+	} else {
+		sort.SliceStable(matched, func(i, j int) bool {
+			for _, k := range keys {
+				if c := compareField(k.field, matched[i], matched[j]); c != 0 {
+					return (c < 0) != k.desc
+				}
+			}
+			return false
+		})
+	}
+
+	limit := r.Limit
+	if limit == 0 {
+		limit = MaxTracks
+	}
+	if len(matched) > limit {
+		matched = matched[:limit]
+	}
+	ids := make([]string, len(matched))
+	for i, t := range matched {
+		ids[i] = t.ID
+	}
+	return ids, nil
+}
+
+func compareField(f ruleField, a, b *ruleTrack) int {
+	switch f.kind {
+	case textField:
+		return strings.Compare(strings.ToLower(f.text(a)), strings.ToLower(f.text(b)))
+	case numberField:
+		x, y := f.number(a), f.number(b)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	default:
+		return f.time(a).Compare(f.time(b))
+	}
+}
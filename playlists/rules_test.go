@@ -0,0 +1,208 @@
+package playlists
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"MediaBackend/audio"
+	"MediaBackend/library"
+)
+
+// parseRules decodes rules written as JSON
+func parseRules(t *testing.T, s string) Rules {
+	t.Helper()
+	var r Rules
+	if err := json.Unmarshal([]byte(s), &r); err != nil {
+		t.Fatalf("decoding %s: %v", s, err)
+	}
+	return r
+}
+
+func TestValidateProblems(t *testing.T) {
+	tests := []struct {
+		rules string
+		paths []string
+	}{
+		{`{"conditions": [{"field": "genre", "op": "is", "value": "Jazz"}]}`, nil},
+		{`{"conditions": []}`, []string{"conditions"}},
+		{`{"match": "some", "conditions": [{"field": "year", "op": "lt", "value": 1970}]}`, []string{"match"}},
+		{`{"conditions": [{"field": "mood", "op": "is", "value": "calm"}]}`, []string{"conditions[0].field"}},
+		{`{"conditions": [{"field": "year", "op": "contains", "value": 1}]}`, []string{"conditions[0].op"}},
+		{`{"conditions": [{"field": "year", "op": "lt"}]}`, []string{"conditions[0].value"}},
+		{`{"conditions": [{"field": "title", "op": "is", "value": 1}]}`, []string{"conditions[0].value"}},
+		{`{"conditions": [{"field": "year", "op": "between", "value": [2000, 1990]}]}`, []string{"conditions[0].value"}},
+		{`{"conditions": [{"field": "added", "op": "inLast", "value": "soon"}]}`, []string{"conditions[0].value"}},
+		{`{"conditions": [{"field": "added", "op": "after", "value": "yesterday"}]}`, []string{"conditions[0].value"}},
+		{`{"conditions": [{"match": "any", "field": "year", "conditions": [{"field": "year", "op": "gt", "value": 1}]}]}`, []string{"conditions[0]"}},
+		{`{"conditions": [{"match": "any", "conditions": [{"field": "x", "op": "is", "value": ""}]}]}`, []string{"conditions[0].conditions[0].field"}},
+		{`{"conditions": [{"field": "year", "op": "gt", "value": 1}], "sort": "mood", "limit": -1}`, []string{"limit", "sort"}},
+	}
+	for _, tt := range tests {
+		r := parseRules(t, tt.rules)
+		err := r.Validate()
+		if tt.paths == nil {
+			if err != nil {
+				t.Errorf("%s: %v", tt.rules, err)
+			}
+			continue
+		}
+		var rerr *RulesError
+		if !errors.As(err, &rerr) || !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: err = %v, want a RulesError", tt.rules, err)
+			continue
+		}
+		var paths []string
+		for _, p := range rerr.Problems {
+			paths = append(paths, p.Path)
+		}
+		if len(paths) != len(tt.paths) {
+			t.Errorf("%s: problems at %v, want %v", tt.rules, paths, tt.paths)
+			continue
+		}
+		for i := range paths {
+			if paths[i] != tt.paths[i] {
+				t.Errorf("%s: problems at %v, want %v", tt.rules, paths, tt.paths)
+				break
+			}
+		}
+	}
+}
+
+func TestRulesMatch(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	jazz := &ruleTrack{
+		Track: &library.Track{
+			Path:     "Jazz/So What.flac",
+			Added:    now.Add(-48 * time.Hour),
+			Duration: 545,
+			Tags:     audio.Tags{Title: "So What", Artist: "Miles Davis", Genre: "Jazz", Year: 1959},
+		},
+		playCount:  3,
+		lastPlayed: now.Add(-time.Hour),
+	}
+	unplayed := &ruleTrack{
+		Track: &library.Track{
+			Path:  "Rock/Song.mp3",
+			Added: now.AddDate(-1, 0, 0),
+			Tags:  audio.Tags{Title: "Song", Genre: "Rock", Year: 1994},
+		},
+	}
+
+	tests := []struct {
+		rules          string
+		jazz, unplayed bool
+	}{
+		{`{"conditions": [{"field": "genre", "op": "is", "value": "jazz"}]}`, true, false},
+		{`{"conditions": [{"field": "genre", "op": "isNot", "value": "JAZZ"}]}`, false, true},
+		{`{"conditions": [{"field": "artist", "op": "contains", "value": "davis"}]}`, true, false},
+		{`{"conditions": [{"field": "artist", "op": "notContains", "value": "davis"}]}`, false, true},
+		{`{"conditions": [{"field": "title", "op": "startsWith", "value": "so "}]}`, true, false},
+		{`{"conditions": [{"field": "path", "op": "endsWith", "value": ".MP3"}]}`, false, true},
+		{`{"conditions": [{"field": "year", "op": "lt", "value": 1970}]}`, true, false},
+		{`{"conditions": [{"field": "year", "op": "gte", "value": 1994}]}`, false, true},
+		{`{"conditions": [{"field": "year", "op": "between", "value": [1950, 1959]}]}`, true, false},
+		{`{"conditions": [{"field": "duration", "op": "gt", "value": 300}]}`, true, false},
+		{`{"conditions": [{"field": "playCount", "op": "eq", "value": 0}]}`, false, true},
+		{`{"conditions": [{"field": "playCount", "op": "ne", "value": 0}]}`, true, false},
+		{`{"conditions": [{"field": "added", "op": "inLast", "value": "1w"}]}`, true, false},
+		{`{"conditions": [{"field": "lastPlayed", "op": "inLast", "value": "30d"}]}`, true, false},
+		// Never played tracks are not in any period
+		{`{"conditions": [{"field": "lastPlayed", "op": "notInLast", "value": "30d"}]}`, false, true},
+		{`{"conditions": [{"field": "lastPlayed", "op": "before", "value": "2030-01-01"}]}`, true, false},
+		{`{"conditions": [{"field": "added", "op": "after", "value": "2025-01-01T00:00:00Z"}]}`, true, false},
+		{`{"match": "any", "conditions": [
+			{"field": "genre", "op": "is", "value": "rock"},
+			{"field": "year", "op": "lt", "value": 1960}
+		]}`, true, true},
+		{`{"conditions": [
+			{"field": "year", "op": "gt", "value": 1900},
+			{"match": "any", "conditions": [
+				{"field": "genre", "op": "is", "value": "rock"},
+				{"field": "playCount", "op": "gt", "value": 5}
+			]}
+		]}`, false, true},
+	}
+	for _, tt := range tests {
+		r := parseRules(t, tt.rules)
+		match, err := r.compile()
+		if err != nil {
+			t.Errorf("%s: %v", tt.rules, err)
+			continue
+		}
+		if got := match(jazz, now); got != tt.jazz {
+			t.Errorf("%s: jazz track matched %v, want %v", tt.rules, got, tt.jazz)
+		}
+		if got := match(unplayed, now); got != tt.unplayed {
+			t.Errorf("%s: unplayed track matched %v, want %v", tt.rules, got, tt.unplayed)
+		}
+	}
+}
+
+func TestParseRuleDuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"12h", 12 * time.Hour},
+		{"30d", 30 * 24 * time.Hour},
+		{"2w", 14 * 24 * time.Hour},
+		{"1y", 365 * 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got, err := parseRuleDuration(tt.in); err != nil || got != tt.want {
+			t.Errorf("parseRuleDuration(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "d", "0d", "-1d", "3m", "1.5d"} {
+		if _, err := parseRuleDuration(in); err == nil {
+			t.Errorf("parseRuleDuration(%q) succeeded", in)
+		}
+	}
+}
+
+func TestParseRuleTime(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"2024-01-31", time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)},
+		{"2024-01-31T10:30:00Z", time.Date(2024, 1, 31, 10, 30, 0, 0, time.UTC)},
+		{"2024-01-31T10:30:00+02:00", time.Date(2024, 1, 31, 8, 30, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got, err := parseRuleTime(tt.in); err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseRuleTime(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "31/01/2024", "2024-01-31 10:30"} {
+		if _, err := parseRuleTime(in); err == nil {
+			t.Errorf("parseRuleTime(%q) succeeded", in)
+		}
+	}
+}
+
+func TestParseSort(t *testing.T) {
+	if keys, err := parseSort(""); err != nil || keys == nil || len(keys) != 0 {
+		t.Errorf(`parseSort("") = %v, %v, want no keys`, keys, err)
+	}
+	if keys, err := parseSort("random"); err != nil || keys != nil {
+		t.Errorf(`parseSort("random") = %v, %v, want nil`, keys, err)
+	}
+
+	keys, err := parseSort("-year, title")
+	if err != nil || len(keys) != 2 {
+		t.Fatalf(`parseSort("-year, title") = %v, %v`, keys, err)
+	}
+	if !keys[0].desc || keys[0].field.kind != numberField {
+		t.Errorf("first key = %+v, want year descending", keys[0])
+	}
+	if keys[1].desc || keys[1].field.kind != textField {
+		t.Errorf("second key = %+v, want title ascending", keys[1])
+	}
+
+	if _, err := parseSort("year,mood"); err == nil {
+		t.Error("unknown sort field accepted")
+	}
+}
@@ -0,0 +1,414 @@
+// Package progress keeps per-user playback positions and bookmarks of
+// audiobooks and podcasts, so that listening resumes on any device
+package progress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"MediaBackend/library"
+	minioClient "MediaBackend/minio"
+	"MediaBackend/randid"
+)
+
+// progressPrefix holds one document per user
+const progressPrefix = "progress/"
+
+const (
+	// finishedMargin is how close to the end a position counts as finished
+	finishedMargin = 15.0
+	// MaxNoteLength limits bookmark notes
+	MaxNoteLength = 2000
+	// maxBookmarks limits the bookmarks of a user
+	maxBookmarks = 5000
+	// saveDelay batches position updates sent every few seconds
+	saveDelay = 2 * time.Second
+)
+
+var (
+	// ErrNotFound is returned for unknown positions and bookmarks
+	ErrNotFound = errors.New("not found")
+	// ErrInvalid wraps validation errors
+	ErrInvalid = errors.New("invalid progress")
+)
+
+// Position is where a user stopped in a track
+type Position struct {
+	TrackID string `json:"trackId"`
+	// Position is in seconds from the start of the track
+	Position float64 `json:"position"`
+	// Chapter is the index of the chapter holding the position, if any
+	Chapter  *int `json:"chapter,omitempty"`
+	Finished bool `json:"finished"`
+	// Updated is when the position was reached, as reported by the client
+	Updated time.Time `json:"updated"`
+	Client  string    `json:"client,omitempty"`
+}
+
+// Bookmark is a saved place in a track with an optional note
+type Bookmark struct {
+	ID       string    `json:"id"`
+	TrackID  string    `json:"trackId"`
+	Position float64   `json:"position"`
+	Chapter  *int      `json:"chapter,omitempty"`
+	Note     string    `json:"note,omitempty"`
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+}
+
+// StaleError is returned when a position update is older than the stored
+// position, which another device reported later
+type StaleError struct {
+	Current Position
+}
+
+func (e *StaleError) Error() string {
+	return fmt.Sprintf("a newer position was saved at %s", e.Current.Updated.Format(time.RFC3339))
+}
+
+// document is the persisted progress of a user
+type document struct {
+	Positions map[string]*Position `json:"positions"`
+	Bookmarks []*Bookmark          `json:"bookmarks"`
+	dirty     bool
+}
+
+var (
+	mu        sync.Mutex
+	documents = map[string]*document{}
+	saver     = minioClient.NewSaver("playback progress", saveDelay, saveDocuments)
+)
+
+func documentKey(user string) string {
+	return progressPrefix + url.PathEscape(user) + ".json"
+}
+
+// load returns the document of a user, reading it on first use. mu must not
+// be held.
+func load(ctx context.Context, user string) (*document, error) {
+	mu.Lock()
+	doc := documents[user]
+	mu.Unlock()
+	if doc != nil {
+		return doc, nil
+	}
+
+	loaded := &document{}
+	if err := minioClient.LoadJSON(ctx, documentKey(user), loaded); err != nil && !errors.Is(err, minioClient.ErrNotFound) {
+		return nil, fmt.Errorf("loading progress of %s: %w", user, err)
+	}
+	if loaded.Positions == nil {
+		loaded.Positions = map[string]*Position{}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if doc := documents[user]; doc != nil {
+		return doc, nil
+	}
+	documents[user] = loaded
+	return loaded, nil
+}
+
+// chapterAt returns the index of the chapter of a track holding a position
+func chapterAt(track library.Track, position float64) *int {
+	for i, c := range track.Chapters {
+		if position >= c.Start && (position < c.End || i == len(track.Chapters)-1) {
+			return &i
+		}
+	}
+	return nil
+}
+
+// checkPosition validates a position within a track
+func checkPosition(trackID string, position float64) (library.Track, error) {
+	track, ok := library.Get(trackID)
+	if !ok {
+		return library.Track{}, fmt.Errorf("%w: unknown track %q", ErrInvalid, trackID)
+	}
+	if position < 0 || (track.Duration > 0 && position > track.Duration+1) {
+		return library.Track{}, fmt.Errorf("%w: position must be between 0 and the duration", ErrInvalid)
+	}
+	return track, nil
+}
+
+// Positions returns the positions of a user, most recently updated first
+func Positions(ctx context.Context, user string) ([]Position, error) {
+	doc, err := load(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	list := make([]Position, 0, len(doc.Positions))
+	for _, p := range doc.Positions {
+		list = append(list, *p)
+	}
+	mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Updated.After(list[j].Updated) })
+	return list, nil
+}
+
+// Get returns the position of a user in a track
+func Get(ctx context.Context, user, trackID string) (Position, error) {
+	doc, err := load(ctx, user)
+	if err != nil {
+		return Position{}, err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	p, ok := doc.Positions[trackID]
+	if !ok {
+		return Position{}, ErrNotFound
+	}
+	return *p, nil
+}
+
+// Set stores the position of a user in a track. The latest position wins:
+// an update reached before the stored one, e.g. sent late by a device that
+// was offline, is rejected with a *StaleError holding the stored position.
+// A zero Updated means now; positions near the end mark the track finished.
+func Set(ctx context.Context, user string, p Position) (Position, error) {
+	track, err := checkPosition(p.TrackID, p.Position)
+	if err != nil {
+		return Position{}, err
+	}
+	now := time.Now().UTC()
+	if p.Updated.IsZero() {
+		p.Updated = now
+	}
+	p.Updated = p.Updated.UTC()
+	if p.Updated.After(now.Add(time.Minute)) {
+		return Position{}, fmt.Errorf("%w: updated is in the future", ErrInvalid)
+	}
+	p.Position = min(p.Position, track.Duration)
+	if track.Duration > 0 && p.Position >= track.Duration-min(finishedMargin, track.Duration/10) {
+		p.Finished = true
+	}
+	p.Chapter = chapterAt(track, p.Position)
+
+	doc, err := load(ctx, user)
+	if err != nil {
+		return Position{}, err
+	}
+	mu.Lock()
+	if current, ok := doc.Positions[p.TrackID]; ok && current.Updated.After(p.Updated) {
+		mu.Unlock()
+		return Position{}, &StaleError{Current: *current}
+	}
+	stored := p
+	doc.Positions[p.TrackID] = &stored
+	doc.dirty = true
+	mu.Unlock()
+
+	saver.Schedule()
+	return p, nil
+}
+
+// Delete forgets the position of a user in a track
+func Delete(ctx context.Context, user, trackID string) error {
+	doc, err := load(ctx, user)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	_, ok := doc.Positions[trackID]
+	delete(doc.Positions, trackID)
+	doc.dirty = doc.dirty || ok
+	mu.Unlock()
+	if !ok {
+		return ErrNotFound
+	}
+	saver.Schedule()
+	return nil
+}
+
+// Bookmarks returns the bookmarks of a user, of one track when trackID is
+// set, ordered by track and position
+func Bookmarks(ctx context.Context, user, trackID string) ([]Bookmark, error) {
+	doc, err := load(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	list := []Bookmark{}
+	for _, b := range doc.Bookmarks {
+		if trackID == "" || b.TrackID == trackID {
+			list = append(list, *b)
+		}
+	}
+	mu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].TrackID != list[j].TrackID {
+			return list[i].TrackID < list[j].TrackID
+		}
+		return list[i].Position < list[j].Position
+	})
+	return list, nil
+}
+
+// AddBookmark stores a new bookmark for a user
+func AddBookmark(ctx context.Context, user string, b Bookmark) (Bookmark, error) {
+	track, err := checkPosition(b.TrackID, b.Position)
+	if err != nil {
+		return Bookmark{}, err
+	}
+	if err := checkNote(b.Note); err != nil {
+		return Bookmark{}, err
+	}
+	doc, err := load(ctx, user)
+	if err != nil {
+		return Bookmark{}, err
+	}
+
+	now := time.Now().UTC()
+	b.ID = randid.Hex(8)
+	b.Chapter = chapterAt(track, b.Position)
+	b.Created, b.Updated = now, now
+	mu.Lock()
+	if len(doc.Bookmarks) >= maxBookmarks {
+		mu.Unlock()
+		return Bookmark{}, fmt.Errorf("%w: at most %d bookmarks", ErrInvalid, maxBookmarks)
+	}
+	stored := b
+	doc.Bookmarks = append(doc.Bookmarks, &stored)
+	doc.dirty = true
+	mu.Unlock()
+
+	saver.Schedule()
+	return b, nil
+}
+
+// BookmarkUpdate changes the fields of a bookmark that are set
+type BookmarkUpdate struct {
+	Position *float64 `json:"position"`
+	Note     *string  `json:"note"`
+}
+
+// UpdateBookmark changes a bookmark of a user
+func UpdateBookmark(ctx context.Context, user, id string, u BookmarkUpdate) (Bookmark, error) {
+	doc, err := load(ctx, user)
+	if err != nil {
+		return Bookmark{}, err
+	}
+	mu.Lock()
+	b := findBookmark(doc, id)
+	var trackID string
+	if b != nil {
+		trackID = b.TrackID
+	}
+	mu.Unlock()
+	if b == nil {
+		return Bookmark{}, ErrNotFound
+	}
+
+	var track library.Track
+	if u.Position != nil {
+		if track, err = checkPosition(trackID, *u.Position); err != nil {
+			return Bookmark{}, err
+		}
+	}
+	if u.Note != nil {
+		if err := checkNote(*u.Note); err != nil {
+			return Bookmark{}, err
+		}
+	}
+
+	mu.Lock()
+	if b = findBookmark(doc, id); b == nil {
+		mu.Unlock()
+		return Bookmark{}, ErrNotFound
+	}
+	if u.Position != nil {
+		b.Position = *u.Position
+		b.Chapter = chapterAt(track, b.Position)
+	}
+	if u.Note != nil {
+		b.Note = *u.Note
+	}
+	b.Updated = time.Now().UTC()
+	doc.dirty = true
+	updated := *b
+	mu.Unlock()
+
+	saver.Schedule()
+	return updated, nil
+}
+
+// DeleteBookmark removes a bookmark of a user
+func DeleteBookmark(ctx context.Context, user, id string) error {
+	doc, err := load(ctx, user)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	removed := false
+	for i, b := range doc.Bookmarks {
+		if b.ID == id {
+			doc.Bookmarks = append(doc.Bookmarks[:i], doc.Bookmarks[i+1:]...)
+			doc.dirty = true
+			removed = true
+			break
+		}
+	}
+	mu.Unlock()
+	if !removed {
+		return ErrNotFound
+	}
+	saver.Schedule()
+	return nil
+}
+
+// findBookmark returns a bookmark by ID. mu must be held.
+func findBookmark(doc *document, id string) *Bookmark {
+	for _, b := range doc.Bookmarks {
+		if b.ID == id {
+			return b
+		}
+	}
+	return nil
+}
+
+func checkNote(note string) error {
+	if len(note) > MaxNoteLength {
+		return fmt.Errorf("%w: note must be at most %d bytes", ErrInvalid, MaxNoteLength)
+	}
+	return nil
+}
+
+// Save writes the changed documents to the meta bucket
+func Save(ctx context.Context) error {
+	return saver.Save(ctx)
+}
+
+// saveDocuments writes the documents changed since the last save
+func saveDocuments(ctx context.Context) error {
+	mu.Lock()
+	var changed []minioClient.Change
+	for user, doc := range documents {
+		if !doc.dirty {
+			continue
+		}
+		copied := document{Positions: make(map[string]*Position, len(doc.Positions)), Bookmarks: make([]*Bookmark, len(doc.Bookmarks))}
+		for id, p := range doc.Positions {
+			c := *p
+			copied.Positions[id] = &c
+		}
+		for i, b := range doc.Bookmarks {
+			c := *b
+			copied.Bookmarks[i] = &c
+		}
+		changed = append(changed, minioClient.Change{Key: documentKey(user), Doc: copied, Retry: func() {
+			mu.Lock()
+			doc.dirty = true
+			mu.Unlock()
+		}})
+		doc.dirty = false
+	}
+	mu.Unlock()
+	return minioClient.SaveChanges(ctx, changed)
+}
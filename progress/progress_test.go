@@ -0,0 +1,86 @@
+package progress
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"MediaBackend/audio"
+	"MediaBackend/library"
+)
+
+func TestChapterAt(t *testing.T) {
+	track := library.Track{Chapters: []audio.Chapter{
+		{Title: "One", Start: 0, End: 60},
+		{Title: "Two", Start: 60, End: 120},
+		{Title: "Three", Start: 120, End: 180},
+	}}
+	tests := map[float64]int{0: 0, 59.9: 0, 60: 1, 150: 2, 180: 2, 200: 2}
+	for position, want := range tests {
+		if got := chapterAt(track, position); got == nil || *got != want {
+			t.Errorf("chapterAt(%v) = %v, want %d", position, got, want)
+		}
+	}
+	if got := chapterAt(library.Track{}, 10); got != nil {
+		t.Errorf("chapterAt without chapters = %d", *got)
+	}
+}
+
+func TestReadProgress(t *testing.T) {
+	t.Cleanup(func() {
+		mu.Lock()
+		delete(documents, "alice")
+		mu.Unlock()
+	})
+	now := time.Now().UTC()
+	mu.Lock()
+	documents["alice"] = &document{
+		Positions: map[string]*Position{
+			"a": {TrackID: "a", Position: 10, Updated: now.Add(-time.Hour)},
+			"b": {TrackID: "b", Position: 20, Updated: now},
+		},
+		Bookmarks: []*Bookmark{
+			{ID: "3", TrackID: "b", Position: 5},
+			{ID: "2", TrackID: "a", Position: 30},
+			{ID: "1", TrackID: "a", Position: 10},
+		},
+	}
+	mu.Unlock()
+	ctx := context.Background()
+
+	positions, err := Positions(ctx, "alice")
+	if err != nil || len(positions) != 2 || positions[0].TrackID != "b" || positions[1].TrackID != "a" {
+		t.Errorf("Positions = %+v, %v", positions, err)
+	}
+	if p, err := Get(ctx, "alice", "a"); err != nil || p.Position != 10 {
+		t.Errorf("Get = %+v, %v", p, err)
+	}
+	if _, err := Get(ctx, "alice", "c"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of an unknown track: %v", err)
+	}
+
+	ids := func(list []Bookmark) string {
+		var s string
+		for _, b := range list {
+			s += b.ID
+		}
+		return s
+	}
+	if list, err := Bookmarks(ctx, "alice", ""); err != nil || ids(list) != "123" {
+		t.Errorf("Bookmarks = %+v, %v", list, err)
+	}
+	if list, err := Bookmarks(ctx, "alice", "b"); err != nil || ids(list) != "3" {
+		t.Errorf("Bookmarks of b = %+v, %v", list, err)
+	}
+}
+
+func TestCheckNote(t *testing.T) {
+	if err := checkNote(strings.Repeat("x", MaxNoteLength)); err != nil {
+		t.Error(err)
+	}
+	if err := checkNote(strings.Repeat("x", MaxNoteLength+1)); !errors.Is(err, ErrInvalid) {
+		t.Errorf("long note: %v", err)
+	}
+}
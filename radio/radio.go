@@ -0,0 +1,389 @@
+// Package radio builds endless queues of tracks similar to a seed track,
+// artist or genre
+package radio
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"MediaBackend/history"
+	"MediaBackend/library"
+)
+
+var (
+	// ErrInvalid wraps malformed seeds and tokens
+	ErrInvalid = errors.New("invalid radio request")
+	// ErrNoSeed is returned when no track matches the seed
+	ErrNoSeed = errors.New("no tracks match the seed")
+)
+
+const (
+	// recentQueued is how many queued tracks a token remembers, so that
+	// they are not repeated
+	recentQueued = 100
+	// Tracks the user played within skipPlayed are left out and those
+	// played within avoidPlayed are less likely
+	skipPlayed  = 2 * time.Hour
+	avoidPlayed = 24 * time.Hour
+)
+
+// Weights of the similarity signals
+const (
+	coListenWeight = 0.45
+	tagWeight      = 0.35
+	featureWeight  = 0.2
+	// baseWeight keeps every track possible, so that the queue never ends
+	baseWeight = 0.05
+)
+
+// Seed is what a radio station is built around: a track, an artist or a
+// genre
+type Seed struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+func (s Seed) String() string {
+	return s.Kind + ":" + s.Value
+}
+
+// ParseSeed parses "track:{id}", "artist:{name}" or "genre:{name}". A bare
+// value is taken as a track ID.
+func ParseSeed(s string) (Seed, error) {
+	kind, value, ok := strings.Cut(s, ":")
+	if !ok {
+		kind, value = "track", s
+	}
+	value = strings.TrimSpace(value)
+	switch {
+	case kind != "track" && kind != "artist" && kind != "genre":
+		return Seed{}, fmt.Errorf("%w: seed must be track, artist or genre", ErrInvalid)
+	case value == "":
+		return Seed{}, fmt.Errorf("%w: empty seed", ErrInvalid)
+	}
+	return Seed{Kind: kind, Value: value}, nil
+}
+
+// matches reports whether a track belongs to the seed
+func (s Seed) matches(t *library.Track) bool {
+	switch s.Kind {
+	case "track":
+		return t.ID == s.Value
+	case "artist":
+		return strings.EqualFold(t.Artist, s.Value) || strings.EqualFold(t.AlbumArtist, s.Value)
+	case "genre":
+		for _, g := range genres(t.Genre) {
+			if strings.EqualFold(g, s.Value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// state is carried from page to page in the continuation token
+type state struct {
+	Seed string `json:"s"`
+	// Salt varies the queue between stations with the same seed
+	Salt uint64 `json:"k"`
+	Page int    `json:"p"`
+	// Recent holds the most recently queued tracks, oldest first
+	Recent []string `json:"r,omitempty"`
+}
+
+func encodeToken(st state) string {
+	data, _ := json.Marshal(st)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeToken(token string) (state, error) {
+	var st state
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil {
+		err = json.Unmarshal(data, &st)
+	}
+	if err != nil || st.Seed == "" {
+		return state{}, fmt.Errorf("%w: malformed token", ErrInvalid)
+	}
+	return st, nil
+}
+
+// Entry is a queued track with the signals that selected it
+type Entry struct {
+	Track library.Track `json:"track"`
+	Score float64       `json:"score"`
+	// Reasons lists the strongest signals, such as "played together"
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// Page is a part of the endless queue
+type Page struct {
+	Seed    Seed    `json:"seed"`
+	Entries []Entry `json:"entries"`
+	// Next fetches the following page
+	Next string `json:"next,omitempty"`
+}
+
+// Start returns the first page of a station for the user
+func Start(ctx context.Context, user string, seed Seed, limit int) (*Page, error) {
+	return next(ctx, user, seed, state{Seed: seed.String(), Salt: rand.Uint64()}, limit)
+}
+
+// Continue returns the page following the one that returned token
+func Continue(ctx context.Context, user, token string, limit int) (*Page, error) {
+	st, err := decodeToken(token)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := ParseSeed(st.Seed)
+	if err != nil {
+		return nil, err
+	}
+	return next(ctx, user, seed, st, limit)
+}
+
+func next(ctx context.Context, user string, seed Seed, st state, limit int) (*Page, error) {
+	tracks := library.Tracks()
+	var seeds []*library.Track
+	for i := range tracks {
+		if seed.matches(&tracks[i]) {
+			seeds = append(seeds, &tracks[i])
+		}
+	}
+	if len(seeds) == 0 {
+		return nil, ErrNoSeed
+	}
+	p := newProfile(seeds)
+	kinds := map[string]bool{}
+	for _, t := range seeds {
+		kinds[t.Kind] = true
+	}
+
+	ids := make([]string, len(seeds))
+	for i, t := range seeds {
+		ids[i] = t.ID
+	}
+	together, err := history.CoListened(ctx, ids...)
+	if err != nil {
+		return nil, err
+	}
+	mostTogether := 0
+	for _, n := range together {
+		mostTogether = max(mostTogether, n)
+	}
+
+	now := time.Now()
+	var candidates []*candidate
+	for i := range tracks {
+		t := &tracks[i]
+		// Music stations stay free of audiobooks and podcasts, and the other
+		// way round
+		if seed.Kind == "track" && t.ID == seed.Value || !kinds[t.Kind] {
+			continue
+		}
+		c := &candidate{track: t}
+		c.score(p, together[t.ID], mostTogether)
+		if played := history.Stats(user, t.ID).LastPlayed; !played.IsZero() {
+			switch since := now.Sub(played); {
+			case since < skipPlayed:
+				c.playedRecently = true
+			case since < avoidPlayed:
+				c.weight *= 0.3
+			}
+		}
+		candidates = append(candidates, c)
+	}
+
+	pool := available(candidates, st.Recent)
+	rng := rand.New(rand.NewPCG(st.Salt, uint64(st.Page)))
+	page := &Page{Seed: seed, Entries: []Entry{}}
+	previousArtist := ""
+	for len(page.Entries) < limit && len(pool) > 0 {
+		total := 0.0
+		weights := make([]float64, len(pool))
+		for i, c := range pool {
+			// Sharpen the preference for similar tracks and avoid the same
+			// artist twice in a row
+			weights[i] = c.weight * c.weight
+			if previousArtist != "" && strings.EqualFold(c.track.Artist, previousArtist) {
+				weights[i] *= 0.2
+			}
+			total += weights[i]
+		}
+		pick := len(pool) - 1
+		for i, r := 0, rng.Float64()*total; i < len(pool); i++ {
+			if r -= weights[i]; r < 0 {
+				pick = i
+				break
+			}
+		}
+		c := pool[pick]
+		pool = append(pool[:pick], pool[pick+1:]...)
+		page.Entries = append(page.Entries, Entry{Track: *c.track, Score: math.Round(c.weight*1000) / 1000, Reasons: c.reasons})
+		previousArtist = c.track.Artist
+		st.Recent = append(st.Recent, c.track.ID)
+	}
+	if len(page.Entries) > 0 {
+		if len(st.Recent) > recentQueued {
+			st.Recent = st.Recent[len(st.Recent)-recentQueued:]
+		}
+		st.Page++
+		page.Next = encodeToken(st)
+	}
+	return page, nil
+}
+
+// available returns the candidates that were neither queued recently nor
+// played by the user lately. Once a small library runs out, the oldest
+// queued tracks and then recently played ones come back, so that the queue
+// goes on.
+func available(candidates []*candidate, recent []string) []*candidate {
+	for keep := len(recent); ; keep /= 2 {
+		excluded := map[string]bool{}
+		for _, id := range recent[len(recent)-keep:] {
+			excluded[id] = true
+		}
+		var pool []*candidate
+		for _, c := range candidates {
+			if !excluded[c.track.ID] && !c.playedRecently {
+				pool = append(pool, c)
+			}
+		}
+		if len(pool) > 0 {
+			return pool
+		}
+		if keep == 0 {
+			break
+		}
+	}
+
+	// Keep only the last queued track out, so it does not repeat at once
+	var pool []*candidate
+	for _, c := range candidates {
+		if len(recent) == 0 || c.track.ID != recent[len(recent)-1] || len(candidates) == 1 {
+			pool = append(pool, c)
+		}
+	}
+	return pool
+}
+
+// profile summarizes the seed tracks
+type profile struct {
+	artists map[string]float64
+	genres  map[string]float64
+	albums  map[string]float64
+	year    float64
+	tempo   float64
+	// loudness is the integrated loudness in LUFS
+	loudness float64
+}
+
+func newProfile(seeds []*library.Track) profile {
+	p := profile{artists: map[string]float64{}, genres: map[string]float64{}, albums: map[string]float64{}}
+	share := 1 / float64(len(seeds))
+	var years, tempos, loudness []float64
+	for _, t := range seeds {
+		if t.Artist != "" {
+			p.artists[strings.ToLower(t.Artist)] += share
+		}
+		for _, g := range genres(t.Genre) {
+			p.genres[strings.ToLower(g)] += share
+		}
+		if t.Album != "" {
+			p.albums[strings.ToLower(t.Album)] += share
+		}
+		if t.Year > 0 {
+			years = append(years, float64(t.Year))
+		}
+		if t.Tempo != nil && *t.Tempo > 0 {
+			tempos = append(tempos, *t.Tempo)
+		}
+		if t.Loudness != nil && t.Loudness.GatedBlocks > 0 {
+			loudness = append(loudness, t.Loudness.Integrated)
+		}
+	}
+	p.year, p.tempo, p.loudness = mean(years), mean(tempos), mean(loudness)
+	return p
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// genres splits a genre tag holding several genres
+func genres(tag string) []string {
+	var result []string
+	for _, g := range strings.FieldsFunc(tag, func(r rune) bool { return r == ';' || r == ',' || r == '/' }) {
+		if g = strings.TrimSpace(g); g != "" {
+			result = append(result, g)
+		}
+	}
+	return result
+}
+
+type candidate struct {
+	track          *library.Track
+	weight         float64
+	reasons        []string
+	playedRecently bool
+}
+
+// score weighs a candidate by how often it was played together with the
+// seed, how much its tags have in common with it and how close its tempo
+// and loudness are
+func (c *candidate) score(p profile, together, mostTogether int) {
+	t := c.track
+	coListen := 0.0
+	if mostTogether > 0 {
+		coListen = float64(together) / float64(mostTogether)
+	}
+
+	tags := 0.5*p.artists[strings.ToLower(t.Artist)] + 0.1*p.albums[strings.ToLower(t.Album)]
+	genre := 0.0
+	for _, g := range genres(t.Genre) {
+		genre = max(genre, p.genres[strings.ToLower(g)])
+	}
+	tags += 0.3 * genre
+	if p.year > 0 && t.Year > 0 {
+		tags += 0.1 * max(0, 1-math.Abs(float64(t.Year)-p.year)/10)
+	}
+
+	var features []float64
+	if p.tempo > 0 && t.Tempo != nil && *t.Tempo > 0 {
+		// Half and double tempo feel alike
+		octaves := math.Abs(math.Log2(*t.Tempo / p.tempo))
+		octaves = min(octaves, math.Abs(octaves-1))
+		features = append(features, max(0, 1-4*octaves))
+	}
+	if p.loudness != 0 && t.Loudness != nil && t.Loudness.GatedBlocks > 0 {
+		features = append(features, max(0, 1-math.Abs(t.Loudness.Integrated-p.loudness)/12))
+	}
+	audio := mean(features)
+
+	c.weight = baseWeight + coListenWeight*coListen + tagWeight*tags + featureWeight*audio
+	if coListen > 0.3 {
+		c.reasons = append(c.reasons, "played together")
+	}
+	if p.artists[strings.ToLower(t.Artist)] > 0 {
+		c.reasons = append(c.reasons, "same artist")
+	} else if genre > 0 {
+		c.reasons = append(c.reasons, "same genre")
+	}
+	if len(features) > 0 && audio > 0.7 {
+		c.reasons = append(c.reasons, "similar sound")
+	}
+}
@@ -0,0 +1,125 @@
+package radio
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"MediaBackend/audio"
+	"MediaBackend/library"
+)
+
+func TestParseSeed(t *testing.T) {
+	tests := map[string]Seed{
+		"track:abc":       {"track", "abc"},
+		"abc":             {"track", "abc"},
+		"artist: Miles ":  {"artist", "Miles"},
+		"genre:Jazz:Cool": {"genre", "Jazz:Cool"},
+	}
+	for in, want := range tests {
+		if got, err := ParseSeed(in); err != nil || got != want {
+			t.Errorf("ParseSeed(%q) = %+v, %v", in, got, err)
+		}
+	}
+	for _, in := range []string{"album:x", "artist:", "  "} {
+		if _, err := ParseSeed(in); !errors.Is(err, ErrInvalid) {
+			t.Errorf("ParseSeed(%q) = %v, want ErrInvalid", in, err)
+		}
+	}
+}
+
+func TestSeedMatches(t *testing.T) {
+	track := &library.Track{ID: "t1", Tags: audio.Tags{Artist: "A", AlbumArtist: "Various", Genre: "Jazz; Bossa Nova"}}
+	tests := map[Seed]bool{
+		{"track", "t1"}:         true,
+		{"track", "t2"}:         false,
+		{"artist", "a"}:         true,
+		{"artist", "various"}:   true,
+		{"genre", "bossa nova"}: true,
+		{"genre", "Bossa"}:      false,
+	}
+	for seed, want := range tests {
+		if got := seed.matches(track); got != want {
+			t.Errorf("%v matches = %v, want %v", seed, got, want)
+		}
+	}
+}
+
+func TestToken(t *testing.T) {
+	st := state{Seed: "artist:Miles Davis", Salt: 1<<63 + 5, Page: 3, Recent: []string{"a", "b"}}
+	got, err := decodeToken(encodeToken(st))
+	if err != nil || !reflect.DeepEqual(got, st) {
+		t.Errorf("decodeToken = %+v, %v", got, err)
+	}
+	for _, token := range []string{"", "not base64!", "e30", encodeToken(state{Page: 1})} {
+		if _, err := decodeToken(token); !errors.Is(err, ErrInvalid) {
+			t.Errorf("decodeToken(%q) = %v, want ErrInvalid", token, err)
+		}
+	}
+}
+
+func TestAvailable(t *testing.T) {
+	candidates := func(ids ...string) []*candidate {
+		var list []*candidate
+		for _, id := range ids {
+			list = append(list, &candidate{track: &library.Track{ID: id}})
+		}
+		return list
+	}
+	ids := func(pool []*candidate) []string {
+		var list []string
+		for _, c := range pool {
+			list = append(list, c.track.ID)
+		}
+		return list
+	}
+
+	all := candidates("a", "b", "c", "d")
+	if got := ids(available(all, []string{"a", "c"})); !reflect.DeepEqual(got, []string{"b", "d"}) {
+		t.Errorf("pool = %v", got)
+	}
+	// The oldest half of the queued tracks come back first
+	if got := ids(available(all, []string{"a", "b", "c", "d"})); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("exhausted pool = %v", got)
+	}
+	played := candidates("a", "b")
+	played[0].playedRecently, played[1].playedRecently = true, true
+	if got := ids(available(played, []string{"a"})); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Errorf("pool of played tracks = %v", got)
+	}
+	if got := ids(available(candidates("a"), []string{"a"})); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("pool of one track = %v", got)
+	}
+}
+
+func TestScore(t *testing.T) {
+	tempo := func(bpm float64) *float64 { return &bpm }
+	seed := &library.Track{ID: "seed", Tempo: tempo(120), Tags: audio.Tags{Artist: "A", Album: "X", Genre: "Jazz", Year: 1960}}
+	p := newProfile([]*library.Track{seed})
+
+	sameArtist := &candidate{track: &library.Track{Tempo: tempo(60), Tags: audio.Tags{Artist: "a", Genre: "Jazz", Year: 1960}}}
+	sameArtist.score(p, 0, 10)
+	together := &candidate{track: &library.Track{Tags: audio.Tags{Artist: "B", Genre: "Rock"}}}
+	together.score(p, 10, 10)
+	stranger := &candidate{track: &library.Track{Tempo: tempo(87), Tags: audio.Tags{Artist: "C", Genre: "Metal", Year: 2010}}}
+	stranger.score(p, 0, 10)
+
+	if stranger.weight != baseWeight {
+		t.Errorf("unrelated track weight = %v, want %v", stranger.weight, baseWeight)
+	}
+	if !(sameArtist.weight > together.weight && together.weight > stranger.weight) {
+		t.Errorf("weights = %v, %v, %v", sameArtist.weight, together.weight, stranger.weight)
+	}
+	if want := []string{"same artist", "similar sound"}; !reflect.DeepEqual(sameArtist.reasons, want) {
+		t.Errorf("reasons = %v, want %v", sameArtist.reasons, want)
+	}
+	if want := []string{"played together"}; !reflect.DeepEqual(together.reasons, want) {
+		t.Errorf("reasons = %v, want %v", together.reasons, want)
+	}
+}
+
+func TestGenres(t *testing.T) {
+	if got := genres(" Jazz;Soul, R&B / Funk ;;"); !reflect.DeepEqual(got, []string{"Jazz", "Soul", "R&B", "Funk"}) {
+		t.Errorf("genres = %q", got)
+	}
+}
@@ -0,0 +1,21 @@
+// Package randid generates random identifiers and tokens
+package randid
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+// Hex returns n random bytes in hex
+func Hex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// UUID returns 16 random bytes in the 8-4-4-4-12 UUID layout
+func UUID() string {
+	id := Hex(16)
+	return fmt.Sprintf("%s-%s-%s-%s-%s", id[0:8], id[8:12], id[12:16], id[16:20], id[20:32])
+}
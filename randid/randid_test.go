@@ -0,0 +1,20 @@
+package randid
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestHex(t *testing.T) {
+	a, b := Hex(8), Hex(8)
+	if len(a) != 16 || a == b {
+		t.Errorf("Hex(8) = %q then %q", a, b)
+	}
+}
+
+func TestUUID(t *testing.T) {
+	layout := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+	if id := UUID(); !layout.MatchString(id) {
+		t.Errorf("UUID() = %q", id)
+	}
+}
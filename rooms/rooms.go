@@ -0,0 +1,660 @@
+// Package rooms runs listening rooms, where a group plays the same queue in
+// sync. The server owns the playback clock: members learn where the room
+// is from timestamped playback messages and estimate the offset of their
+// own clock with sync round trips.
+package rooms
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"MediaBackend/library"
+	"MediaBackend/randid"
+)
+
+const (
+	// startLead delays every start so that members can buffer the track
+	startLead = 2 * time.Second
+	// positionInterval is how often the playback position is broadcast
+	positionInterval = 5 * time.Second
+	// idleTimeout closes rooms nobody has been in for a while
+	idleTimeout = 15 * time.Minute
+	// maxRoomsPerHost limits the open rooms of a user
+	maxRoomsPerHost = 5
+	// MaxMembers limits the connections to a room
+	MaxMembers = 50
+	// MaxQueue limits the queue of a room
+	MaxQueue = 500
+	// MaxNameLength limits room names
+	MaxNameLength = 100
+	// outboxSize is how many messages may wait for a slow connection before
+	// it is dropped
+	outboxSize = 64
+)
+
+// Message types. Members send sync, add, vote, unvote and skip; the host
+// also play, pause, seek and remove. The server sends room, playback, sync,
+// error and closed.
+const (
+	// MessageSync is a clock sync round trip
+	MessageSync = "sync"
+	// MessageAdd appends a track to the queue
+	MessageAdd = "add"
+	// MessageVote and MessageUnvote vote for the queue entry to play next;
+	// each member has one vote
+	MessageVote   = "vote"
+	MessageUnvote = "unvote"
+	// MessageSkip skips the current track when sent by the host, and counts
+	// as a vote otherwise; a majority of the members skips
+	MessageSkip   = "skip"
+	MessagePlay   = "play"
+	MessagePause  = "pause"
+	MessageSeek   = "seek"
+	MessageRemove = "remove"
+	// MessageRoom carries the state of the room on joining and on changes
+	MessageRoom = "room"
+	// MessagePlayback carries the playback clock, on changes and every few
+	// seconds
+	MessagePlayback = "playback"
+	MessageError    = "error"
+	// MessageClosed tells members that the host closed the room
+	MessageClosed = "closed"
+)
+
+var (
+	// ErrNotFound is returned for unknown rooms
+	ErrNotFound = errors.New("room not found")
+	// ErrForbidden is returned for actions only the host may take
+	ErrForbidden = errors.New("only the host may do that")
+	// ErrInvalid wraps validation errors
+	ErrInvalid = errors.New("invalid room request")
+)
+
+// Entry is a track in the queue of a room
+type Entry struct {
+	ID      string        `json:"id"`
+	Track   library.Track `json:"track"`
+	AddedBy string        `json:"addedBy"`
+	// Votes lists the users who want the entry next
+	Votes []string `json:"votes"`
+}
+
+// Playback is the authoritative playback clock of a room. Times are Unix
+// milliseconds on the server's clock.
+type Playback struct {
+	Entry   *Entry `json:"entry"`
+	Playing bool   `json:"playing"`
+	// Position is the position in seconds at ServerTime
+	Position   float64 `json:"position"`
+	ServerTime int64   `json:"serverTime"`
+	// StartAt is when the track's start plays (or played) while playing;
+	// it may lie ahead of ServerTime when a track is about to start
+	StartAt int64 `json:"startAt,omitempty"`
+	// SkipVotes lists the members who voted to skip the current track
+	SkipVotes []string `json:"skipVotes"`
+}
+
+// Member is a user in a room
+type Member struct {
+	User        string `json:"user"`
+	Host        bool   `json:"host"`
+	Connections int    `json:"connections"`
+}
+
+// State describes a room
+type State struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Host     string    `json:"host"`
+	Created  time.Time `json:"created"`
+	Queue    []Entry   `json:"queue"`
+	Members  []Member  `json:"members"`
+	Playback Playback  `json:"playback"`
+}
+
+// Message is exchanged with members over their connection
+type Message struct {
+	Type string `json:"type"`
+	// Ref echoes the ref of the message being answered
+	Ref      string   `json:"ref,omitempty"`
+	TrackID  string   `json:"trackId,omitempty"`
+	EntryID  string   `json:"entryId,omitempty"`
+	Position *float64 `json:"position,omitempty"`
+	// ClientTime is echoed in sync replies, with ReceiveTime and ServerTime
+	// for the member to estimate its clock offset as
+	// ((ReceiveTime - ClientTime) + (ServerTime - arrival)) / 2
+	ClientTime  *float64  `json:"clientTime,omitempty"`
+	ReceiveTime int64     `json:"receiveTime,omitempty"`
+	ServerTime  int64     `json:"serverTime,omitempty"`
+	Room        *State    `json:"room,omitempty"`
+	Playback    *Playback `json:"playback,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Client is the connection of a member, which relays the messages of its
+// outbox until the room closes it
+type Client struct {
+	room   *room
+	user   string
+	outbox chan Message
+	closed bool
+}
+
+// room is an open room. Its fields are guarded by mu.
+type room struct {
+	id      string
+	name    string
+	host    string
+	created time.Time
+	queue   []*Entry
+	current *Entry
+	playing bool
+	// startedAt is when position 0 of the current track plays, while playing
+	startedAt time.Time
+	// pausedAt is the position while paused
+	pausedAt  float64
+	skipVotes map[string]bool
+	clients   map[*Client]bool
+	endTimer  *time.Timer
+	tickTimer *time.Timer
+	idleTimer *time.Timer
+}
+
+var (
+	mu    sync.Mutex
+	rooms = map[string]*room{}
+)
+
+// Create opens a room hosted by user with a queue of track IDs, the first
+// of which is ready to play
+func Create(user, name string, trackIDs []string) (State, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = user + "'s room"
+	}
+	if len(name) > MaxNameLength {
+		return State{}, fmt.Errorf("%w: name must be at most %d bytes", ErrInvalid, MaxNameLength)
+	}
+	if len(trackIDs) > MaxQueue {
+		return State{}, fmt.Errorf("%w: at most %d queued tracks", ErrInvalid, MaxQueue)
+	}
+	r := &room{
+		id:        randid.Hex(16),
+		name:      name,
+		host:      user,
+		created:   time.Now().UTC(),
+		skipVotes: map[string]bool{},
+		clients:   map[*Client]bool{},
+	}
+	for _, id := range trackIDs {
+		track, ok := library.Get(id)
+		if !ok {
+			return State{}, fmt.Errorf("%w: unknown track %q", ErrInvalid, id)
+		}
+		r.queue = append(r.queue, &Entry{ID: randid.Hex(8), Track: track, AddedBy: user, Votes: []string{}})
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	hosted := 0
+	for _, other := range rooms {
+		if other.host == user {
+			hosted++
+		}
+	}
+	if hosted >= maxRoomsPerHost {
+		return State{}, fmt.Errorf("%w: at most %d open rooms", ErrInvalid, maxRoomsPerHost)
+	}
+	if len(r.queue) > 0 {
+		r.current, r.queue = r.queue[0], r.queue[1:]
+	}
+	rooms[r.id] = r
+	r.idleTimer = time.AfterFunc(idleTimeout, func() { closeIdle(r) })
+	return r.state(time.Now()), nil
+}
+
+// Get returns the state of a room
+func Get(id string) (State, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	r := rooms[id]
+	if r == nil {
+		return State{}, ErrNotFound
+	}
+	return r.state(time.Now()), nil
+}
+
+// Hosted returns the open rooms of a host
+func Hosted(user string) []State {
+	mu.Lock()
+	defer mu.Unlock()
+	list := []State{}
+	now := time.Now()
+	for _, r := range rooms {
+		if r.host == user {
+			list = append(list, r.state(now))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return list
+}
+
+// Close ends a room on behalf of its host and disconnects its members
+func Close(id, user string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	r := rooms[id]
+	if r == nil {
+		return ErrNotFound
+	}
+	if r.host != user {
+		return ErrForbidden
+	}
+	r.close()
+	return nil
+}
+
+// closeIdle closes a room that nobody joined for idleTimeout
+func closeIdle(r *room) {
+	mu.Lock()
+	defer mu.Unlock()
+	if rooms[r.id] == r && len(r.clients) == 0 {
+		r.close()
+	}
+}
+
+// close removes the room. mu must be held.
+func (r *room) close() {
+	for c := range r.clients {
+		c.send(Message{Type: MessageClosed})
+		c.close()
+	}
+	r.clients = map[*Client]bool{}
+	r.stopClock()
+	if r.idleTimer != nil {
+		r.idleTimer.Stop()
+	}
+	delete(rooms, r.id)
+}
+
+// Join connects a member to a room. Anyone with the room's ID may join.
+func Join(id, user string) (*Client, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	r := rooms[id]
+	if r == nil {
+		return nil, ErrNotFound
+	}
+	if len(r.clients) >= MaxMembers {
+		return nil, fmt.Errorf("%w: the room is full", ErrInvalid)
+	}
+	if r.idleTimer != nil {
+		r.idleTimer.Stop()
+		r.idleTimer = nil
+	}
+	c := &Client{room: r, user: user, outbox: make(chan Message, outboxSize)}
+	r.clients[c] = true
+	r.broadcastRoom(time.Now())
+	return c, nil
+}
+
+// Outbox returns the messages for the member, closed when the connection
+// should end
+func (c *Client) Outbox() <-chan Message {
+	return c.outbox
+}
+
+// Leave disconnects a member. Rooms left empty close after a while.
+func (c *Client) Leave() {
+	mu.Lock()
+	defer mu.Unlock()
+	c.close()
+	r := c.room
+	if !r.clients[c] {
+		return
+	}
+	delete(r.clients, c)
+	// Votes of a user who left with their last connection no longer count
+	if !r.isMember(c.user) {
+		delete(r.skipVotes, c.user)
+		for _, e := range r.queue {
+			e.Votes = removeString(e.Votes, c.user)
+		}
+	}
+	if len(r.clients) == 0 {
+		r.idleTimer = time.AfterFunc(idleTimeout, func() { closeIdle(r) })
+	}
+	r.broadcastRoom(time.Now())
+}
+
+// send queues a message for the member, dropping connections that do not
+// keep up. mu must be held.
+func (c *Client) send(m Message) {
+	if c.closed {
+		return
+	}
+	select {
+	case c.outbox <- m:
+	default:
+		c.close()
+	}
+}
+
+// close ends the outbox. mu must be held.
+func (c *Client) close() {
+	if !c.closed {
+		c.closed = true
+		close(c.outbox)
+	}
+}
+
+// Handle processes a message received from the member
+func (c *Client) Handle(m Message) {
+	received := time.Now()
+	mu.Lock()
+	defer mu.Unlock()
+	r := c.room
+	if !r.clients[c] {
+		return
+	}
+	if err := r.handle(c, m, received); err != nil {
+		c.send(Message{Type: MessageError, Ref: m.Ref, Error: err.Error()})
+	}
+}
+
+// handle applies a member's message. mu must be held.
+func (r *room) handle(c *Client, m Message, received time.Time) error {
+	host := c.user == r.host
+	switch m.Type {
+	case MessageSync:
+		if m.ClientTime == nil {
+			return fmt.Errorf("%w: missing clientTime", ErrInvalid)
+		}
+		c.send(Message{Type: MessageSync, Ref: m.Ref, ClientTime: m.ClientTime, ReceiveTime: millis(received), ServerTime: millis(time.Now())})
+		return nil
+
+	case MessageAdd:
+		if len(r.queue) >= MaxQueue {
+			return fmt.Errorf("%w: at most %d queued tracks", ErrInvalid, MaxQueue)
+		}
+		track, ok := library.Get(m.TrackID)
+		if !ok {
+			return fmt.Errorf("%w: unknown track %q", ErrInvalid, m.TrackID)
+		}
+		r.queue = append(r.queue, &Entry{ID: randid.Hex(8), Track: track, AddedBy: c.user, Votes: []string{}})
+		if r.current == nil {
+			r.advance(time.Now())
+			return nil
+		}
+
+	case MessageVote, MessageUnvote:
+		e := r.entry(m.EntryID)
+		if e == nil {
+			return fmt.Errorf("%w: no queue entry %q", ErrInvalid, m.EntryID)
+		}
+		// Each member has one vote, which moves to the entry voted for
+		if m.Type == MessageVote {
+			for _, other := range r.queue {
+				other.Votes = removeString(other.Votes, c.user)
+			}
+		}
+		e.Votes = removeString(e.Votes, c.user)
+		if m.Type == MessageVote {
+			e.Votes = append(e.Votes, c.user)
+		}
+
+	case MessageRemove:
+		e := r.entry(m.EntryID)
+		if e == nil {
+			return fmt.Errorf("%w: no queue entry %q", ErrInvalid, m.EntryID)
+		}
+		if !host && e.AddedBy != c.user {
+			return ErrForbidden
+		}
+		for i, other := range r.queue {
+			if other == e {
+				r.queue = append(r.queue[:i], r.queue[i+1:]...)
+				break
+			}
+		}
+
+	case MessageSkip:
+		if r.current == nil {
+			return fmt.Errorf("%w: nothing is playing", ErrInvalid)
+		}
+		r.skipVotes[c.user] = true
+		if host || len(r.skipVotes)*2 > r.memberCount() {
+			r.advance(time.Now())
+			return nil
+		}
+		r.broadcastPlayback(time.Now())
+		return nil
+
+	case MessagePlay, MessagePause, MessageSeek:
+		if !host {
+			return ErrForbidden
+		}
+		if r.current == nil {
+			return fmt.Errorf("%w: nothing is playing", ErrInvalid)
+		}
+		now := time.Now()
+		position := r.position(now)
+		if m.Type == MessageSeek {
+			if m.Position == nil || *m.Position < 0 || (r.current.Track.Duration > 0 && *m.Position >= r.current.Track.Duration) {
+				return fmt.Errorf("%w: position must be within the track", ErrInvalid)
+			}
+			position = *m.Position
+		}
+		switch m.Type {
+		case MessagePlay:
+			r.playing = true
+		case MessagePause:
+			r.playing = false
+		}
+		r.setPosition(position, now)
+		r.broadcastPlayback(now)
+		return nil
+
+	default:
+		return fmt.Errorf("%w: unknown message type %q", ErrInvalid, m.Type)
+	}
+	r.broadcastRoom(time.Now())
+	return nil
+}
+
+// position returns the position of the current track at a time, which is
+// 0 until a starting track begins. mu must be held.
+func (r *room) position(t time.Time) float64 {
+	if !r.playing {
+		return r.pausedAt
+	}
+	return max(0, t.Sub(r.startedAt).Seconds())
+}
+
+// setPosition moves the clock to a position, leaving members time to
+// buffer when playing, and schedules the end of the track. mu must be held.
+func (r *room) setPosition(position float64, now time.Time) {
+	r.stopClock()
+	if !r.playing {
+		r.pausedAt = position
+		return
+	}
+	r.startedAt = now.Add(startLead).Add(-time.Duration(position * float64(time.Second)))
+	if duration := r.current.Track.Duration; duration > 0 {
+		r.endTimer = time.AfterFunc(r.startedAt.Add(time.Duration(duration*float64(time.Second))).Sub(now), func() {
+			mu.Lock()
+			defer mu.Unlock()
+			if rooms[r.id] == r && r.playing {
+				r.advance(time.Now())
+			}
+		})
+	}
+	var tick *time.Timer
+	tick = time.AfterFunc(positionInterval, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.tickTimer == tick {
+			r.broadcastPlayback(time.Now())
+			tick.Reset(positionInterval)
+		}
+	})
+	r.tickTimer = tick
+}
+
+// stopClock cancels the end of track timer and position broadcasts. mu
+// must be held.
+func (r *room) stopClock() {
+	if r.endTimer != nil {
+		r.endTimer.Stop()
+		r.endTimer = nil
+	}
+	if r.tickTimer != nil {
+		r.tickTimer.Stop()
+		r.tickTimer = nil
+	}
+}
+
+// advance starts the queue entry with the most votes, the earliest added
+// among equals, or stops when the queue is empty. mu must be held.
+func (r *room) advance(now time.Time) {
+	r.skipVotes = map[string]bool{}
+	r.current = nil
+	best := -1
+	for i, e := range r.queue {
+		if best < 0 || len(e.Votes) > len(r.queue[best].Votes) {
+			best = i
+		}
+	}
+	if best < 0 {
+		r.playing = false
+		r.stopClock()
+		r.pausedAt = 0
+	} else {
+		r.current = r.queue[best]
+		r.current.Votes = []string{}
+		r.queue = append(r.queue[:best], r.queue[best+1:]...)
+		r.playing = true
+		r.setPosition(0, now)
+	}
+	r.broadcastRoom(now)
+}
+
+// entry returns a queue entry by ID. mu must be held.
+func (r *room) entry(id string) *Entry {
+	for _, e := range r.queue {
+		if e.ID == id {
+			return e
+		}
+	}
+	return nil
+}
+
+// isMember reports whether a user has a connection to the room. mu must be
+// held.
+func (r *room) isMember(user string) bool {
+	for c := range r.clients {
+		if c.user == user {
+			return true
+		}
+	}
+	return false
+}
+
+// memberCount returns the number of distinct users in the room. mu must be
+// held.
+func (r *room) memberCount() int {
+	users := map[string]bool{}
+	for c := range r.clients {
+		users[c.user] = true
+	}
+	return len(users)
+}
+
+// playback returns the playback clock at a time. mu must be held.
+func (r *room) playback(now time.Time) Playback {
+	p := Playback{Playing: r.playing, Position: r.position(now), ServerTime: millis(now), SkipVotes: []string{}}
+	if r.current != nil {
+		e := copyEntry(r.current)
+		p.Entry = &e
+	}
+	if r.playing {
+		p.StartAt = millis(r.startedAt)
+	}
+	for user := range r.skipVotes {
+		p.SkipVotes = append(p.SkipVotes, user)
+	}
+	sort.Strings(p.SkipVotes)
+	return p
+}
+
+// state describes the room at a time. mu must be held.
+func (r *room) state(now time.Time) State {
+	s := State{
+		ID:       r.id,
+		Name:     r.name,
+		Host:     r.host,
+		Created:  r.created,
+		Queue:    make([]Entry, len(r.queue)),
+		Members:  []Member{},
+		Playback: r.playback(now),
+	}
+	for i, e := range r.queue {
+		s.Queue[i] = copyEntry(e)
+	}
+	connections := map[string]int{}
+	for c := range r.clients {
+		connections[c.user]++
+	}
+	for user, n := range connections {
+		s.Members = append(s.Members, Member{User: user, Host: user == r.host, Connections: n})
+	}
+	sort.Slice(s.Members, func(i, j int) bool {
+		if s.Members[i].Host != s.Members[j].Host {
+			return s.Members[i].Host
+		}
+		return s.Members[i].User < s.Members[j].User
+	})
+	return s
+}
+
+// broadcastRoom sends the state of the room to its members. mu must be
+// held.
+func (r *room) broadcastRoom(now time.Time) {
+	state := r.state(now)
+	for c := range r.clients {
+		c.send(Message{Type: MessageRoom, Room: &state, ServerTime: state.Playback.ServerTime})
+	}
+}
+
+// broadcastPlayback sends the playback clock to the members. mu must be
+// held.
+func (r *room) broadcastPlayback(now time.Time) {
+	p := r.playback(now)
+	for c := range r.clients {
+		c.send(Message{Type: MessagePlayback, Playback: &p, ServerTime: p.ServerTime})
+	}
+}
+
+func copyEntry(e *Entry) Entry {
+	c := *e
+	c.Votes = append([]string{}, e.Votes...)
+	return c
+}
+
+func removeString(list []string, s string) []string {
+	out := list[:0]
+	for _, v := range list {
+		if v != s {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixMilli()
+}
@@ -0,0 +1,230 @@
+package rooms
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"MediaBackend/library"
+)
+
+// testRoom opens a room hosted by "host" with queued entries e1, e2, ...
+func testRoom(t *testing.T, entries int) *room {
+	t.Helper()
+	s, err := Create("host", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	r := rooms[s.ID]
+	for i := 1; i <= entries; i++ {
+		id := string(rune('0' + i))
+		r.queue = append(r.queue, &Entry{ID: "e" + id, Track: library.Track{ID: "t" + id}, AddedBy: "host", Votes: []string{}})
+	}
+	t.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		r.close()
+	})
+	return r
+}
+
+func join(t *testing.T, r *room, user string) *Client {
+	t.Helper()
+	c, err := Join(r.id, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// handle applies a message and returns the error sent back, if any
+func handle(c *Client, m Message) error {
+	mu.Lock()
+	defer mu.Unlock()
+	return c.room.handle(c, m, time.Now())
+}
+
+func votes(r *room) map[string][]string {
+	mu.Lock()
+	defer mu.Unlock()
+	v := map[string][]string{}
+	for _, e := range r.queue {
+		v[e.ID] = append([]string{}, e.Votes...)
+	}
+	return v
+}
+
+func TestVote(t *testing.T) {
+	r := testRoom(t, 3)
+	alice := join(t, r, "alice")
+	bob := join(t, r, "bob")
+
+	if err := handle(alice, Message{Type: MessageVote, EntryID: "e2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := handle(alice, Message{Type: MessageVote, EntryID: "e3"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := handle(bob, Message{Type: MessageVote, EntryID: "e3"}); err != nil {
+		t.Fatal(err)
+	}
+	v := votes(r)
+	if len(v["e2"]) != 0 || len(v["e3"]) != 2 {
+		t.Errorf("votes = %v, want alice's vote moved to e3", v)
+	}
+
+	if err := handle(bob, Message{Type: MessageUnvote, EntryID: "e3"}); err != nil {
+		t.Fatal(err)
+	}
+	if v := votes(r); len(v["e3"]) != 1 || v["e3"][0] != "alice" {
+		t.Errorf("votes after unvote = %v", v)
+	}
+	if err := handle(bob, Message{Type: MessageVote, EntryID: "nope"}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("vote for unknown entry = %v, want ErrInvalid", err)
+	}
+}
+
+func TestAdvance(t *testing.T) {
+	r := testRoom(t, 3)
+	mu.Lock()
+	defer mu.Unlock()
+	r.queue[2].Votes = []string{"alice"}
+	r.skipVotes["bob"] = true
+
+	now := time.Now()
+	r.advance(now)
+	if r.current == nil || r.current.ID != "e3" || !r.playing {
+		t.Fatalf("advanced to %+v, want the voted entry e3 playing", r.current)
+	}
+	if len(r.current.Votes) != 0 || len(r.skipVotes) != 0 {
+		t.Error("votes were not reset when the entry started")
+	}
+	// Equal votes play in the order they were added
+	r.advance(now)
+	if r.current.ID != "e1" {
+		t.Errorf("advanced to %s, want e1", r.current.ID)
+	}
+	r.advance(now)
+	r.advance(now)
+	if r.current != nil || r.playing || r.position(now) != 0 {
+		t.Errorf("empty queue left current %+v, playing %v", r.current, r.playing)
+	}
+}
+
+func TestClock(t *testing.T) {
+	r := testRoom(t, 1)
+	mu.Lock()
+	defer mu.Unlock()
+	now := time.Now()
+	r.advance(now)
+
+	// A starting track waits startLead for members to buffer
+	if p := r.playback(now); p.Position != 0 || p.StartAt != millis(now.Add(startLead)) {
+		t.Errorf("starting playback = %+v, want position 0 starting after %v", p, startLead)
+	}
+	later := now.Add(startLead + 3*time.Second)
+	if got := r.position(later); got < 2.999 || got > 3.001 {
+		t.Errorf("position after 3s = %v", got)
+	}
+
+	position := r.position(later)
+	r.playing = false
+	r.setPosition(position, later)
+	if got := r.position(later.Add(time.Minute)); got < 2.999 || got > 3.001 {
+		t.Errorf("paused position moved to %v", got)
+	}
+	if r.endTimer != nil || r.tickTimer != nil {
+		t.Error("clock timers kept running while paused")
+	}
+
+	r.playing = true
+	r.setPosition(10, later)
+	if got := r.position(later.Add(startLead)); got < 9.999 || got > 10.001 {
+		t.Errorf("seeked position = %v, want 10 once buffered", got)
+	}
+}
+
+func TestSkip(t *testing.T) {
+	r := testRoom(t, 2)
+	host := join(t, r, "host")
+	alice := join(t, r, "alice")
+	bob := join(t, r, "bob")
+	join(t, r, "bob")
+	mu.Lock()
+	r.advance(time.Now())
+	mu.Unlock()
+
+	// Three members, so two votes are a majority whatever the connections
+	if err := handle(alice, Message{Type: MessageSkip}); err != nil {
+		t.Fatal(err)
+	}
+	if r.current.ID != "e1" {
+		t.Fatal("one vote of three skipped")
+	}
+	if err := handle(bob, Message{Type: MessageSkip}); err != nil {
+		t.Fatal(err)
+	}
+	if r.current.ID != "e2" {
+		t.Fatalf("current = %s after a majority, want e2", r.current.ID)
+	}
+
+	if err := handle(alice, Message{Type: MessagePause}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("member pause = %v, want ErrForbidden", err)
+	}
+	if err := handle(host, Message{Type: MessageSkip}); err != nil || r.current != nil {
+		t.Errorf("host skip = %v, current %+v", err, r.current)
+	}
+}
+
+func TestSync(t *testing.T) {
+	r := testRoom(t, 0)
+	c := join(t, r, "alice")
+	for range len(c.outbox) {
+		<-c.outbox
+	}
+	if err := handle(c, Message{Type: MessageSync}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("sync without clientTime = %v", err)
+	}
+	clientTime := 1234.5
+	before := millis(time.Now())
+	if err := handle(c, Message{Type: MessageSync, Ref: "s", ClientTime: &clientTime}); err != nil {
+		t.Fatal(err)
+	}
+	m := <-c.outbox
+	if m.Type != MessageSync || m.Ref != "s" || *m.ClientTime != clientTime || m.ReceiveTime < before || m.ServerTime < m.ReceiveTime {
+		t.Errorf("sync reply = %+v", m)
+	}
+}
+
+func TestLeave(t *testing.T) {
+	r := testRoom(t, 2)
+	join(t, r, "host")
+	alice := join(t, r, "alice")
+	again := join(t, r, "alice")
+	bob := join(t, r, "bob")
+	mu.Lock()
+	r.skipVotes["alice"] = true
+	mu.Unlock()
+	for _, c := range []*Client{alice, bob} {
+		if err := handle(c, Message{Type: MessageVote, EntryID: "e2"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	alice.Leave()
+	if !r.skipVotes["alice"] || len(votes(r)["e2"]) != 2 {
+		t.Errorf("votes dropped while alice is still connected: skip %v, queue %v", r.skipVotes["alice"], votes(r))
+	}
+	again.Leave()
+	if r.skipVotes["alice"] {
+		t.Error("skip vote kept after alice left")
+	}
+	if v := votes(r)["e2"]; len(v) != 1 || v[0] != "bob" {
+		t.Errorf("votes for e2 = %v, want only bob's after alice left", v)
+	}
+	if !again.closed {
+		t.Error("connection not closed on leaving")
+	}
+}
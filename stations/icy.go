@@ -0,0 +1,72 @@
+package stations
+
+import (
+	"io"
+	"strings"
+)
+
+// MetaInt is the number of audio bytes between ICY metadata blocks,
+// announced to clients in the icy-metaint header
+const MetaInt = 16000
+
+// maxMetadata is the largest metadata block, whose length is sent as a
+// single byte counting 16 byte units
+const maxMetadata = 255 * 16
+
+// ICYWriter interleaves ICY metadata blocks carrying the StreamTitle with
+// the audio of a stream, for clients that sent Icy-MetaData: 1
+type ICYWriter struct {
+	w io.Writer
+	// remaining is the number of audio bytes until the next block
+	remaining int
+	announced string
+}
+
+// NewICYWriter returns an ICYWriter writing to w
+func NewICYWriter(w io.Writer) *ICYWriter {
+	return &ICYWriter{w: w, remaining: MetaInt}
+}
+
+// Write writes the audio of a chunk, inserting a metadata block every
+// MetaInt bytes. Blocks are empty unless the title changed since the last.
+func (iw *ICYWriter) Write(c Chunk) error {
+	data := c.Data
+	for len(data) > 0 {
+		n := min(len(data), iw.remaining)
+		if _, err := iw.w.Write(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+		iw.remaining -= n
+		if iw.remaining > 0 {
+			continue
+		}
+		block := []byte{0}
+		if c.Title != iw.announced {
+			block = metadataBlock(c.Title)
+			iw.announced = c.Title
+		}
+		if _, err := iw.w.Write(block); err != nil {
+			return err
+		}
+		iw.remaining = MetaInt
+	}
+	return nil
+}
+
+// metadataBlock encodes StreamTitle='title'; as a length byte followed by
+// the text padded with zeros to a multiple of 16 bytes
+func metadataBlock(title string) []byte {
+	// Clients read the title up to the closing quote and semicolon
+	title = strings.ReplaceAll(title, "';", "'")
+	const prefix, suffix = "StreamTitle='", "';"
+	if limit := maxMetadata - len(prefix) - len(suffix); len(title) > limit {
+		title = strings.ToValidUTF8(title[:limit], "")
+	}
+	text := prefix + title + suffix
+	units := (len(text) + 15) / 16
+	block := make([]byte, 1+units*16)
+	block[0] = byte(units)
+	copy(block[1:], text)
+	return block
+}
@@ -0,0 +1,85 @@
+package stations
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMetadataBlock(t *testing.T) {
+	tests := []struct {
+		title string
+		text  string
+		units int
+	}{
+		{"", "StreamTitle='';", 1},
+		{"A", "StreamTitle='A';", 1},
+		{"Artist - Title", "StreamTitle='Artist - Title';", 2},
+		{"Rock';n roll", "StreamTitle='Rock'n roll';", 2},
+	}
+	for _, tt := range tests {
+		block := metadataBlock(tt.title)
+		if int(block[0]) != tt.units || len(block) != 1+tt.units*16 {
+			t.Errorf("metadataBlock(%q) has %d units in %d bytes, want %d", tt.title, block[0], len(block), tt.units)
+			continue
+		}
+		text := string(bytes.TrimRight(block[1:], "\x00"))
+		if text != tt.text {
+			t.Errorf("metadataBlock(%q) = %q, want %q", tt.title, text, tt.text)
+		}
+	}
+}
+
+func TestMetadataBlockTruncates(t *testing.T) {
+	// A multi-byte character straddling the limit is dropped whole
+	title := strings.Repeat("a", maxMetadata-18) + strings.Repeat("é", 10)
+	block := metadataBlock(title)
+	if block[0] != 255 || len(block) != 1+maxMetadata {
+		t.Fatalf("block has %d units in %d bytes, want 255", block[0], len(block))
+	}
+	text := string(bytes.TrimRight(block[1:], "\x00"))
+	if !strings.HasPrefix(text, "StreamTitle='aaa") || !strings.HasSuffix(text, "é';") {
+		t.Errorf("truncated block ends %q", text[len(text)-8:])
+	}
+	if !strings.Contains(text, "é") || strings.ContainsRune(text, '�') {
+		t.Error("truncation split a character")
+	}
+}
+
+func TestICYWriter(t *testing.T) {
+	var out bytes.Buffer
+	iw := NewICYWriter(&out)
+	audio := bytes.Repeat([]byte{0xAA}, MetaInt*2+100)
+	// Chunks that do not line up with the metadata interval
+	if err := iw.Write(Chunk{Data: audio[:MetaInt-10], Title: "First"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := iw.Write(Chunk{Data: audio[MetaInt-10 : MetaInt*2], Title: "First"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := iw.Write(Chunk{Data: audio[MetaInt*2:], Title: "Second"}); err != nil {
+		t.Fatal(err)
+	}
+
+	stream := out.Bytes()
+	first := metadataBlock("First")
+	if !bytes.Equal(stream[:MetaInt], audio[:MetaInt]) {
+		t.Fatal("first interval is not the audio")
+	}
+	stream = stream[MetaInt:]
+	if !bytes.HasPrefix(stream, first) {
+		t.Fatalf("first block = % x, want the title", stream[:len(first)])
+	}
+	stream = stream[len(first):]
+	if !bytes.Equal(stream[:MetaInt], audio[MetaInt:MetaInt*2]) {
+		t.Fatal("second interval is not the audio")
+	}
+	stream = stream[MetaInt:]
+	// The title is unchanged, so the block is empty
+	if stream[0] != 0 {
+		t.Fatalf("second block length = %d, want 0", stream[0])
+	}
+	if rest := stream[1:]; !bytes.Equal(rest, audio[MetaInt*2:]) {
+		t.Errorf("%d bytes after the second block, want the remaining %d of audio", len(rest), len(audio)-MetaInt*2)
+	}
+}
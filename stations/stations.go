@@ -0,0 +1,354 @@
+// Package stations runs internet radio stations that play playlists as
+// endless MP3 streams. A station reads its tracks once, in real time, and
+// hands the frames to every listener, so that its cost does not grow with
+// the audience. Stations are configured with
+// RADIO_STATIONS=name=playlistId,... and only read while someone listens.
+package stations
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"MediaBackend/audio"
+	"MediaBackend/library"
+	minioClient "MediaBackend/minio"
+	"MediaBackend/playlists"
+)
+
+const (
+	// idleStop is how long a station keeps reading after its last
+	// listener leaves, so that reconnecting players resume seamlessly
+	idleStop = 10 * time.Second
+	// burstBytes of the most recent audio are sent to a new listener at
+	// once, so that its player can start without waiting to buffer
+	burstBytes = 64 * 1024
+	// listenerBuffer is how many frames a listener may fall behind before
+	// it is disconnected, about 13 seconds of audio
+	listenerBuffer = 512
+	// maxLag is how far reading may fall behind real time before the
+	// station stops catching up and carries on from now
+	maxLag = time.Second
+	// retryDelay spaces attempts when a station has nothing to play
+	retryDelay = 30 * time.Second
+)
+
+// ErrNotFound is returned for stations that are not configured
+var ErrNotFound = errors.New("station not found")
+
+var validName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// Chunk is a piece of a station's stream, with the title of the track it
+// belongs to for ICY metadata
+type Chunk struct {
+	Data  []byte
+	Title string
+}
+
+// Info describes a station and what it is playing
+type Info struct {
+	Name     string `json:"name"`
+	Playlist string `json:"playlist"`
+	TrackID  string `json:"trackId,omitempty"`
+	Title    string `json:"title,omitempty"`
+	// Bitrate is the bitrate in kbps of the track playing
+	Bitrate   int `json:"bitrate,omitempty"`
+	Listeners int `json:"listeners"`
+}
+
+// Station plays a playlist to its listeners
+type Station struct {
+	Name       string
+	PlaylistID string
+
+	mu        sync.Mutex
+	listeners map[*Listener]bool
+	cancel    context.CancelFunc
+	stopTimer *time.Timer
+	burst     []Chunk
+	burstSize int
+	trackID   string
+	title     string
+	bitrate   int
+}
+
+// Listener receives a station's stream
+type Listener struct {
+	chunks chan Chunk
+	closed bool
+}
+
+// Chunks delivers the stream. It is closed when the listener falls too far
+// behind to keep up.
+func (l *Listener) Chunks() <-chan Chunk {
+	return l.chunks
+}
+
+var stations = loadStations(os.Getenv("RADIO_STATIONS"))
+
+// loadStations parses RADIO_STATIONS, a comma separated list of
+// name=playlistId pairs
+func loadStations(spec string) map[string]*Station {
+	list := map[string]*Station{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, id, ok := strings.Cut(entry, "=")
+		name, id = strings.TrimSpace(name), strings.TrimSpace(id)
+		if !ok || !validName.MatchString(name) || id == "" {
+			log.Printf("⚠️  Ignoring malformed RADIO_STATIONS entry %q", entry)
+			continue
+		}
+		list[name] = &Station{Name: name, PlaylistID: id, listeners: map[*Listener]bool{}}
+	}
+	return list
+}
+
+// Get returns a configured station
+func Get(name string) (*Station, error) {
+	s, ok := stations[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return s, nil
+}
+
+// List describes the configured stations by name
+func List() []Info {
+	list := make([]Info, 0, len(stations))
+	for _, s := range stations {
+		list = append(list, s.Info())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Info describes the station and what it is playing
+func (s *Station) Info() Info {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Info{
+		Name:      s.Name,
+		Playlist:  s.PlaylistID,
+		TrackID:   s.trackID,
+		Title:     s.title,
+		Bitrate:   s.bitrate,
+		Listeners: len(s.listeners),
+	}
+}
+
+// Listen adds a listener, starting the station if it is off air. The
+// listener first receives the last few seconds played and must Leave when
+// done.
+func (s *Station) Listen() *Listener {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := &Listener{chunks: make(chan Chunk, listenerBuffer+len(s.burst))}
+	for _, c := range s.burst {
+		l.chunks <- c
+	}
+	s.listeners[l] = true
+	if s.stopTimer != nil {
+		s.stopTimer.Stop()
+		s.stopTimer = nil
+	}
+	if s.cancel == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		s.cancel = cancel
+		log.Printf("📻 Station %s on air", s.Name)
+		go s.run(ctx)
+	}
+	return l
+}
+
+// Leave removes a listener. The station stops reading shortly after the
+// last one leaves.
+func (s *Station) Leave(l *Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.listeners, l)
+	if len(s.listeners) > 0 || s.cancel == nil || s.stopTimer != nil {
+		return
+	}
+	s.stopTimer = time.AfterFunc(idleStop, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if len(s.listeners) > 0 || s.cancel == nil {
+			return
+		}
+		s.cancel()
+		s.cancel = nil
+		s.stopTimer = nil
+		s.burst, s.burstSize = nil, 0
+		log.Printf("📻 Station %s off air", s.Name)
+	})
+}
+
+// run plays the station's playlist in a loop until ctx is cancelled. The
+// playlist is read again before each track, so that edits and smart
+// playlist changes are heard without restarting.
+func (s *Station) run(ctx context.Context) {
+	clock := time.Now()
+	// Resume after the track playing when the station last went off air
+	s.mu.Lock()
+	last := s.trackID
+	s.mu.Unlock()
+	for ctx.Err() == nil {
+		track, err := s.nextTrack(last)
+		if err != nil {
+			log.Printf("Error choosing track for station %s: %v", s.Name, err)
+			if !sleep(ctx, retryDelay) {
+				return
+			}
+			continue
+		}
+		last = track.ID
+		if err := s.play(ctx, track, &clock); err != nil && ctx.Err() == nil {
+			log.Printf("Error playing %s on station %s: %v", track.Path, s.Name, err)
+			// Avoid spinning through a playlist whose files all fail
+			if !sleep(ctx, time.Second) {
+				return
+			}
+		}
+	}
+}
+
+// nextTrack returns the playable track following last in the playlist,
+// or its first when last is no longer in it
+func (s *Station) nextTrack(last string) (library.Track, error) {
+	p, err := playlists.Lookup(s.PlaylistID)
+	if err != nil {
+		return library.Track{}, err
+	}
+	var playable []library.Track
+	next := 0
+	for _, id := range p.Tracks {
+		track, ok := library.Get(id)
+		// Cue tracks are cut from a larger file and other formats cannot be
+		// mixed into an MP3 stream
+		if !ok || track.Format != "mp3" || track.Cue != nil {
+			continue
+		}
+		playable = append(playable, track)
+		if id == last {
+			next = len(playable)
+		}
+	}
+	if len(playable) == 0 {
+		return library.Track{}, errors.New("playlist has no MP3 tracks")
+	}
+	return playable[next%len(playable)], nil
+}
+
+// play streams the frames of a track in real time, advancing clock by the
+// duration of each
+func (s *Station) play(ctx context.Context, track library.Track, clock *time.Time) error {
+	object, err := minioClient.GetObject(ctx, minioClient.MusicBucket, track.Path)
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+	info, err := audio.ReadMP3Info(object, track.Size)
+	if err != nil {
+		return err
+	}
+	start := info.FirstAudioFrame()
+	reader := bufio.NewReaderSize(io.NewSectionReader(object, start, info.AudioEnd-start), 64*1024)
+
+	title := trackTitle(track)
+	s.mu.Lock()
+	s.trackID, s.title, s.bitrate = track.ID, title, info.Header.Bitrate/1000
+	s.mu.Unlock()
+
+	for {
+		header, err := reader.Peek(4)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		h, ok := audio.ParseMP3FrameHeader(header)
+		if !ok || h.FrameSize() <= 4 {
+			// Resynchronise on the next frame
+			reader.Discard(1)
+			continue
+		}
+		frame := make([]byte, h.FrameSize())
+		if _, err := io.ReadFull(reader, frame); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return err
+		}
+
+		*clock = clock.Add(time.Duration(h.Duration() * float64(time.Second)))
+		if wait := time.Until(*clock); wait > 0 {
+			if !sleep(ctx, wait) {
+				return ctx.Err()
+			}
+		} else if -wait > maxLag {
+			*clock = time.Now()
+		}
+		s.broadcast(Chunk{Data: frame, Title: title})
+	}
+}
+
+// broadcast hands a chunk to every listener, dropping those whose buffer is
+// full, and keeps it for the burst sent to new listeners
+func (s *Station) broadcast(c Chunk) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for l := range s.listeners {
+		if l.closed {
+			continue
+		}
+		select {
+		case l.chunks <- c:
+		default:
+			l.closed = true
+			close(l.chunks)
+		}
+	}
+	s.burst = append(s.burst, c)
+	s.burstSize += len(c.Data)
+	for s.burstSize > burstBytes {
+		s.burstSize -= len(s.burst[0].Data)
+		s.burst = s.burst[1:]
+	}
+}
+
+// trackTitle is the "Artist - Title" announced for a track
+func trackTitle(t library.Track) string {
+	title := t.Title
+	if title == "" {
+		title = strings.TrimSuffix(path.Base(t.Path), path.Ext(t.Path))
+	}
+	if t.Artist != "" {
+		return t.Artist + " - " + title
+	}
+	return title
+}
+
+// sleep waits for d, reporting false when ctx is cancelled first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,52 @@
+package stations
+
+import (
+	"testing"
+
+	"MediaBackend/audio"
+	"MediaBackend/library"
+)
+
+func TestLoadStations(t *testing.T) {
+	got := loadStations(" jazz = p1 ,,Bad Name=p2,rock=,noequals, lo-fi_2=p3")
+	if len(got) != 2 || got["jazz"].PlaylistID != "p1" || got["lo-fi_2"].PlaylistID != "p3" {
+		t.Errorf("loadStations = %v", got)
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	s := &Station{Name: "test", listeners: map[*Listener]bool{}}
+	fast := &Listener{chunks: make(chan Chunk, 10)}
+	slow := &Listener{chunks: make(chan Chunk, 1)}
+	s.listeners[fast] = true
+	s.listeners[slow] = true
+
+	frame := make([]byte, burstBytes/2)
+	for range 3 {
+		s.broadcast(Chunk{Data: frame, Title: "A - B"})
+	}
+	if len(fast.chunks) != 3 {
+		t.Errorf("fast listener got %d chunks, want 3", len(fast.chunks))
+	}
+	if !slow.closed {
+		t.Error("listener that fell behind was not dropped")
+	}
+	if s.burstSize != burstBytes || len(s.burst) != 2 {
+		t.Errorf("burst = %d chunks of %d bytes, want the last %d bytes", len(s.burst), s.burstSize, burstBytes)
+	}
+}
+
+func TestTrackTitle(t *testing.T) {
+	for _, tt := range []struct {
+		track library.Track
+		want  string
+	}{
+		{library.Track{Tags: audio.Tags{Title: "Song", Artist: "Artist"}}, "Artist - Song"},
+		{library.Track{Tags: audio.Tags{Title: "Song"}}, "Song"},
+		{library.Track{Path: "music/Artist/01 Song.mp3", Tags: audio.Tags{Artist: "Artist"}}, "Artist - 01 Song"},
+	} {
+		if got := trackTitle(tt.track); got != tt.want {
+			t.Errorf("trackTitle(%+v) = %q, want %q", tt.track, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package subsonic
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"MediaBackend/library"
+)
+
+// ID prefixes tell the kinds of entity apart; tracks use their library ID
+// and playlists their playlist ID
+const (
+	albumPrefix    = "al-"
+	artistPrefix   = "ar-"
+	dirPrefix      = "dir-"
+	playlistPrefix = "pl-"
+)
+
+// RootFolderID is the ID of the only music folder, the music bucket
+const RootFolderID = 1
+
+// IgnoredArticles are skipped when sorting and indexing artists
+const IgnoredArticles = "The El La Los Las Le Les"
+
+// Unknown names stand in for missing tags
+const (
+	UnknownArtist = "[Unknown Artist]"
+	UnknownAlbum  = "[Unknown Album]"
+)
+
+// Catalog is the library grouped into album artists and albums by tags, as
+// the ID3 endpoints browse it
+type Catalog struct {
+	Artists []*Artist
+	Albums  []*Album
+	artists map[string]*Artist
+	albums  map[string]*Album
+	byTrack map[string]*Album
+}
+
+// Artist is an album artist
+type Artist struct {
+	ID     string
+	Name   string
+	Albums []*Album
+}
+
+// Album is the tracks sharing an album title and album artist
+type Album struct {
+	ID       string
+	Name     string
+	Artist   *Artist
+	Year     int
+	Genre    string
+	Duration float64
+	// Added is when the first of its tracks was added to the library
+	Added  time.Time
+	Tracks []library.Track
+}
+
+// NewCatalog groups tracks into albums and artists, sorted by name
+func NewCatalog(tracks []library.Track) *Catalog {
+	c := &Catalog{
+		artists: map[string]*Artist{},
+		albums:  map[string]*Album{},
+		byTrack: map[string]*Album{},
+	}
+	for _, t := range tracks {
+		artistName := t.AlbumArtist
+		if artistName == "" {
+			artistName = t.Artist
+		}
+		if artistName == "" {
+			artistName = UnknownArtist
+		}
+		albumName := t.Album
+		if albumName == "" {
+			albumName = UnknownAlbum
+		}
+
+		artist, ok := c.artists[ArtistID(artistName)]
+		if !ok {
+			artist = &Artist{ID: ArtistID(artistName), Name: artistName}
+			c.artists[artist.ID] = artist
+			c.Artists = append(c.Artists, artist)
+		}
+		id := AlbumID(artistName, albumName)
+		album, ok := c.albums[id]
+		if !ok {
+			album = &Album{ID: id, Name: albumName, Artist: artist, Added: t.Added}
+			c.albums[id] = album
+			c.Albums = append(c.Albums, album)
+			artist.Albums = append(artist.Albums, album)
+		}
+		album.Tracks = append(album.Tracks, t)
+		album.Duration += t.Duration
+		album.Year = max(album.Year, t.Year)
+		if album.Genre == "" {
+			album.Genre = t.Genre
+		}
+		if t.Added.Before(album.Added) {
+			album.Added = t.Added
+		}
+		c.byTrack[t.ID] = album
+	}
+
+	for _, album := range c.Albums {
+		sort.SliceStable(album.Tracks, func(i, j int) bool {
+			a, b := album.Tracks[i], album.Tracks[j]
+			if a.DiscNumber != b.DiscNumber {
+				return a.DiscNumber < b.DiscNumber
+			}
+			if a.TrackNumber != b.TrackNumber {
+				return a.TrackNumber < b.TrackNumber
+			}
+			return a.Path < b.Path
+		})
+	}
+	for _, artist := range c.Artists {
+		sort.SliceStable(artist.Albums, func(i, j int) bool {
+			a, b := artist.Albums[i], artist.Albums[j]
+			if a.Year != b.Year {
+				return a.Year < b.Year
+			}
+			return SortName(a.Name) < SortName(b.Name)
+		})
+	}
+	sort.SliceStable(c.Artists, func(i, j int) bool {
+		return SortName(c.Artists[i].Name) < SortName(c.Artists[j].Name)
+	})
+	sort.SliceStable(c.Albums, func(i, j int) bool {
+		return SortName(c.Albums[i].Name) < SortName(c.Albums[j].Name)
+	})
+	return c
+}
+
+// Artist returns an artist by ID
+func (c *Catalog) Artist(id string) (*Artist, bool) {
+	a, ok := c.artists[id]
+	return a, ok
+}
+
+// Album returns an album by ID
+func (c *Catalog) Album(id string) (*Album, bool) {
+	a, ok := c.albums[id]
+	return a, ok
+}
+
+// AlbumOf returns the album a track belongs to
+func (c *Catalog) AlbumOf(trackID string) (*Album, bool) {
+	a, ok := c.byTrack[trackID]
+	return a, ok
+}
+
+// ArtistID derives the ID of an album artist from its name
+func ArtistID(name string) string {
+	return artistPrefix + hashID(strings.ToLower(name))
+}
+
+// AlbumID derives the ID of an album from its artist and title
+func AlbumID(artist, album string) string {
+	return albumPrefix + hashID(strings.ToLower(artist)+"\x00"+strings.ToLower(album))
+}
+
+// IsAlbumID and IsArtistID tell entity IDs apart, e.g. for cover art
+func IsAlbumID(id string) bool  { return strings.HasPrefix(id, albumPrefix) }
+func IsArtistID(id string) bool { return strings.HasPrefix(id, artistPrefix) }
+
+// PlaylistCoverID is the cover art ID of a playlist
+func PlaylistCoverID(playlistID string) string {
+	return playlistPrefix + playlistID
+}
+
+// ParsePlaylistCoverID returns the playlist of a cover art ID
+func ParsePlaylistCoverID(id string) (string, bool) {
+	return strings.CutPrefix(id, playlistPrefix)
+}
+
+func hashID(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return hex.EncodeToString(sum[:8])
+}
+
+// DirID returns the ID of a folder of the music bucket, "" being the root
+func DirID(dir string) string {
+	return dirPrefix + base64.RawURLEncoding.EncodeToString([]byte(dir))
+}
+
+// ParseDirID returns the folder of a DirID. The music folder ID stands for
+// the root.
+func ParseDirID(id string) (string, bool) {
+	if id == "1" {
+		return "", true
+	}
+	encoded, ok := strings.CutPrefix(id, dirPrefix)
+	if !ok {
+		return "", false
+	}
+	dir, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", false
+	}
+	return string(dir), true
+}
+
+// ParentDir returns the folder holding a path, "" at the root
+func ParentDir(p string) string {
+	dir := path.Dir(p)
+	if dir == "." || dir == "/" {
+		return ""
+	}
+	return dir
+}
+
+// SortName returns a name lower-cased and without a leading article, for
+// sorting
+func SortName(name string) string {
+	lower := strings.ToLower(name)
+	for _, article := range strings.Fields(IgnoredArticles) {
+		if rest, ok := strings.CutPrefix(lower, strings.ToLower(article)+" "); ok {
+			return rest
+		}
+	}
+	return lower
+}
+
+// IndexName returns the index heading a name is listed under: its initial
+// letter, or "#" for other characters
+func IndexName(name string) string {
+	for _, r := range SortName(name) {
+		if unicode.IsLetter(r) {
+			return string(unicode.ToUpper(r))
+		}
+		return "#"
+	}
+	return "#"
+}
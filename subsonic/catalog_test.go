@@ -0,0 +1,80 @@
+package subsonic
+
+import (
+	"testing"
+	"time"
+
+	"MediaBackend/audio"
+	"MediaBackend/library"
+)
+
+func TestNewCatalog(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	track := func(id, artist, albumArtist, album string, year, disc, number int, added time.Time) library.Track {
+		return library.Track{ID: id, Added: added, Duration: 60, Tags: audio.Tags{
+			Artist: artist, AlbumArtist: albumArtist, Album: album, Year: year, DiscNumber: disc, TrackNumber: number,
+		}}
+	}
+	c := NewCatalog([]library.Track{
+		track("t1", "The Band", "", "Second", 2001, 1, 2, day(3)),
+		track("t2", "Guest", "The Band", "Second", 2001, 1, 1, day(2)),
+		track("t3", "THE BAND", "", "First", 1999, 1, 1, day(5)),
+		track("t4", "Band", "", "Second", 2001, 2, 1, day(1)),
+		track("t5", "", "", "", 0, 0, 0, day(4)),
+	})
+
+	if len(c.Artists) != 3 {
+		t.Fatalf("%d artists, want The Band, Band and unknown", len(c.Artists))
+	}
+	band, ok := c.Artist(ArtistID("the band"))
+	if !ok || len(band.Albums) != 2 || band.Albums[0].Name != "First" {
+		t.Fatalf("The Band = %+v, want its albums by year", band)
+	}
+	second := band.Albums[1]
+	if len(second.Tracks) != 2 || second.Tracks[0].ID != "t2" || second.Duration != 120 || !second.Added.Equal(day(2)) {
+		t.Errorf("Second = %+v", second)
+	}
+	if album, ok := c.AlbumOf("t5"); !ok || album.Name != UnknownAlbum || album.Artist.Name != UnknownArtist {
+		t.Errorf("untagged track in %+v", album)
+	}
+	if _, ok := c.Album(AlbumID("band", "second")); !ok {
+		t.Error("Band's Second merged with The Band's")
+	}
+}
+
+func TestSortName(t *testing.T) {
+	for _, tt := range []struct{ name, sort, index string }{
+		{"The Beatles", "beatles", "B"},
+		{"Los Lobos", "lobos", "L"},
+		{"Theory", "theory", "T"},
+		{"2Pac", "2pac", "#"},
+		{"émile", "émile", "É"},
+		{"", "", "#"},
+	} {
+		if got := SortName(tt.name); got != tt.sort {
+			t.Errorf("SortName(%q) = %q, want %q", tt.name, got, tt.sort)
+		}
+		if got := IndexName(tt.name); got != tt.index {
+			t.Errorf("IndexName(%q) = %q, want %q", tt.name, got, tt.index)
+		}
+	}
+}
+
+func TestDirID(t *testing.T) {
+	for _, dir := range []string{"", "Artist/Album", "a b/ü?"} {
+		if got, ok := ParseDirID(DirID(dir)); !ok || got != dir {
+			t.Errorf("ParseDirID(DirID(%q)) = %q, %v", dir, got, ok)
+		}
+	}
+	if dir, ok := ParseDirID("1"); !ok || dir != "" {
+		t.Error("music folder ID is not the root")
+	}
+	for _, id := range []string{"al-123", "dir-!!"} {
+		if _, ok := ParseDirID(id); ok {
+			t.Errorf("ParseDirID(%q) accepted", id)
+		}
+	}
+	if ParentDir("a/b/c.mp3") != "a/b" || ParentDir("c.mp3") != "" {
+		t.Error("ParentDir")
+	}
+}
@@ -0,0 +1,261 @@
+package subsonic
+
+// License is always valid; the API predates free servers
+type License struct {
+	Valid bool `xml:"valid,attr" json:"valid"`
+}
+
+// Extension is an OpenSubsonic extension and the versions supported
+type Extension struct {
+	Name     string `xml:"name,attr" json:"name"`
+	Versions []int  `xml:"versions" json:"versions"`
+}
+
+// User describes the signed-in user and what they may do
+type User struct {
+	Username          string `xml:"username,attr" json:"username"`
+	ScrobblingEnabled bool   `xml:"scrobblingEnabled,attr" json:"scrobblingEnabled"`
+	AdminRole         bool   `xml:"adminRole,attr" json:"adminRole"`
+	SettingsRole      bool   `xml:"settingsRole,attr" json:"settingsRole"`
+	DownloadRole      bool   `xml:"downloadRole,attr" json:"downloadRole"`
+	UploadRole        bool   `xml:"uploadRole,attr" json:"uploadRole"`
+	PlaylistRole      bool   `xml:"playlistRole,attr" json:"playlistRole"`
+	CoverArtRole      bool   `xml:"coverArtRole,attr" json:"coverArtRole"`
+	CommentRole       bool   `xml:"commentRole,attr" json:"commentRole"`
+	PodcastRole       bool   `xml:"podcastRole,attr" json:"podcastRole"`
+	StreamRole        bool   `xml:"streamRole,attr" json:"streamRole"`
+	JukeboxRole       bool   `xml:"jukeboxRole,attr" json:"jukeboxRole"`
+	ShareRole         bool   `xml:"shareRole,attr" json:"shareRole"`
+	Folder            []int  `xml:"folder" json:"folder"`
+}
+
+// MusicFolders lists the top-level folders; the music bucket is the only one
+type MusicFolders struct {
+	Folders []MusicFolder `xml:"musicFolder" json:"musicFolder"`
+}
+
+// MusicFolder is a top-level folder
+type MusicFolder struct {
+	ID   int    `xml:"id,attr" json:"id"`
+	Name string `xml:"name,attr" json:"name"`
+}
+
+// Indexes lists the top-level directories by initial, with the files at
+// the root of the music folder
+type Indexes struct {
+	LastModified    int64   `xml:"lastModified,attr" json:"lastModified"`
+	IgnoredArticles string  `xml:"ignoredArticles,attr" json:"ignoredArticles"`
+	Index           []Index `xml:"index" json:"index,omitempty"`
+	Child           []Child `xml:"child" json:"child,omitempty"`
+}
+
+// Index groups directories under an initial
+type Index struct {
+	Name   string        `xml:"name,attr" json:"name"`
+	Artist []IndexArtist `xml:"artist" json:"artist"`
+}
+
+// IndexArtist is a directory listed in the indexes or found by search2
+type IndexArtist struct {
+	ID   string `xml:"id,attr" json:"id"`
+	Name string `xml:"name,attr" json:"name"`
+}
+
+// Directory is a folder with its subfolders and tracks
+type Directory struct {
+	ID     string  `xml:"id,attr" json:"id"`
+	Parent string  `xml:"parent,attr,omitempty" json:"parent,omitempty"`
+	Name   string  `xml:"name,attr" json:"name"`
+	Child  []Child `xml:"child" json:"child,omitempty"`
+}
+
+// Child is a track, or a folder when IsDir is set
+type Child struct {
+	ID          string `xml:"id,attr" json:"id"`
+	Parent      string `xml:"parent,attr,omitempty" json:"parent,omitempty"`
+	IsDir       bool   `xml:"isDir,attr" json:"isDir"`
+	Title       string `xml:"title,attr" json:"title"`
+	Album       string `xml:"album,attr,omitempty" json:"album,omitempty"`
+	Artist      string `xml:"artist,attr,omitempty" json:"artist,omitempty"`
+	Track       int    `xml:"track,attr,omitempty" json:"track,omitempty"`
+	Year        int    `xml:"year,attr,omitempty" json:"year,omitempty"`
+	Genre       string `xml:"genre,attr,omitempty" json:"genre,omitempty"`
+	CoverArt    string `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	Size        int64  `xml:"size,attr,omitempty" json:"size,omitempty"`
+	ContentType string `xml:"contentType,attr,omitempty" json:"contentType,omitempty"`
+	Suffix      string `xml:"suffix,attr,omitempty" json:"suffix,omitempty"`
+	Duration    int    `xml:"duration,attr,omitempty" json:"duration,omitempty"`
+	BitRate     int    `xml:"bitRate,attr,omitempty" json:"bitRate,omitempty"`
+	Path        string `xml:"path,attr,omitempty" json:"path,omitempty"`
+	PlayCount   int    `xml:"playCount,attr,omitempty" json:"playCount,omitempty"`
+	Played      string `xml:"played,attr,omitempty" json:"played,omitempty"`
+	DiscNumber  int    `xml:"discNumber,attr,omitempty" json:"discNumber,omitempty"`
+	Created     string `xml:"created,attr,omitempty" json:"created,omitempty"`
+	AlbumID     string `xml:"albumId,attr,omitempty" json:"albumId,omitempty"`
+	ArtistID    string `xml:"artistId,attr,omitempty" json:"artistId,omitempty"`
+	Type        string `xml:"type,attr,omitempty" json:"type,omitempty"`
+	// OpenSubsonic additions
+	MediaType    string      `xml:"mediaType,attr,omitempty" json:"mediaType,omitempty"`
+	BPM          int         `xml:"bpm,attr,omitempty" json:"bpm,omitempty"`
+	SamplingRate int         `xml:"samplingRate,attr,omitempty" json:"samplingRate,omitempty"`
+	ChannelCount int         `xml:"channelCount,attr,omitempty" json:"channelCount,omitempty"`
+	ReplayGain   *ReplayGain `xml:"replayGain,omitempty" json:"replayGain,omitempty"`
+}
+
+// ReplayGain carries the loudness analysis of a track (OpenSubsonic)
+type ReplayGain struct {
+	TrackGain float64 `xml:"trackGain,attr" json:"trackGain"`
+	TrackPeak float64 `xml:"trackPeak,attr" json:"trackPeak"`
+	AlbumGain float64 `xml:"albumGain,attr,omitempty" json:"albumGain,omitempty"`
+	AlbumPeak float64 `xml:"albumPeak,attr,omitempty" json:"albumPeak,omitempty"`
+}
+
+// Artists lists the album artists by initial
+type Artists struct {
+	IgnoredArticles string     `xml:"ignoredArticles,attr" json:"ignoredArticles"`
+	Index           []IndexID3 `xml:"index" json:"index,omitempty"`
+}
+
+// IndexID3 groups artists under an initial
+type IndexID3 struct {
+	Name   string      `xml:"name,attr" json:"name"`
+	Artist []ArtistID3 `xml:"artist" json:"artist"`
+}
+
+// ArtistID3 is an album artist
+type ArtistID3 struct {
+	ID         string `xml:"id,attr" json:"id"`
+	Name       string `xml:"name,attr" json:"name"`
+	CoverArt   string `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	AlbumCount int    `xml:"albumCount,attr" json:"albumCount"`
+}
+
+// ArtistWithAlbums is an artist with its albums
+type ArtistWithAlbums struct {
+	ArtistID3
+	Album []AlbumID3 `xml:"album" json:"album,omitempty"`
+}
+
+// AlbumID3 is an album grouped by its tags
+type AlbumID3 struct {
+	ID        string `xml:"id,attr" json:"id"`
+	Name      string `xml:"name,attr" json:"name"`
+	Artist    string `xml:"artist,attr,omitempty" json:"artist,omitempty"`
+	ArtistID  string `xml:"artistId,attr,omitempty" json:"artistId,omitempty"`
+	CoverArt  string `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	SongCount int    `xml:"songCount,attr" json:"songCount"`
+	Duration  int    `xml:"duration,attr" json:"duration"`
+	PlayCount int    `xml:"playCount,attr,omitempty" json:"playCount,omitempty"`
+	Created   string `xml:"created,attr" json:"created"`
+	Played    string `xml:"played,attr,omitempty" json:"played,omitempty"`
+	Year      int    `xml:"year,attr,omitempty" json:"year,omitempty"`
+	Genre     string `xml:"genre,attr,omitempty" json:"genre,omitempty"`
+}
+
+// AlbumWithSongs is an album with its tracks
+type AlbumWithSongs struct {
+	AlbumID3
+	Song []Child `xml:"song" json:"song,omitempty"`
+}
+
+// AlbumList is a page of albums as folders
+type AlbumList struct {
+	Album []Child `xml:"album" json:"album,omitempty"`
+}
+
+// AlbumList2 is a page of albums grouped by tags
+type AlbumList2 struct {
+	Album []AlbumID3 `xml:"album" json:"album,omitempty"`
+}
+
+// Songs is a list of tracks
+type Songs struct {
+	Song []Child `xml:"song" json:"song,omitempty"`
+}
+
+// Genres lists the genres with their track and album counts
+type Genres struct {
+	Genre []Genre `xml:"genre" json:"genre,omitempty"`
+}
+
+// Genre is a genre name with its counts
+type Genre struct {
+	Value      string `xml:",chardata" json:"value"`
+	SongCount  int    `xml:"songCount,attr" json:"songCount"`
+	AlbumCount int    `xml:"albumCount,attr" json:"albumCount"`
+}
+
+// SearchResult2 holds the folders, albums and tracks matching a search
+type SearchResult2 struct {
+	Artist []IndexArtist `xml:"artist" json:"artist,omitempty"`
+	Album  []Child       `xml:"album" json:"album,omitempty"`
+	Song   []Child       `xml:"song" json:"song,omitempty"`
+}
+
+// SearchResult3 holds the artists, albums and tracks matching a search
+type SearchResult3 struct {
+	Artist []ArtistID3 `xml:"artist" json:"artist,omitempty"`
+	Album  []AlbumID3  `xml:"album" json:"album,omitempty"`
+	Song   []Child     `xml:"song" json:"song,omitempty"`
+}
+
+// Starred lists starred items; starring is not supported so it is empty
+type Starred struct {
+	Artist []IndexArtist `xml:"artist" json:"artist,omitempty"`
+	Album  []Child       `xml:"album" json:"album,omitempty"`
+	Song   []Child       `xml:"song" json:"song,omitempty"`
+}
+
+// Starred2 is Starred for the tag based endpoints
+type Starred2 struct {
+	Artist []ArtistID3 `xml:"artist" json:"artist,omitempty"`
+	Album  []AlbumID3  `xml:"album" json:"album,omitempty"`
+	Song   []Child     `xml:"song" json:"song,omitempty"`
+}
+
+// NowPlaying lists what users are listening to
+type NowPlaying struct {
+	Entry []NowPlayingEntry `xml:"entry" json:"entry,omitempty"`
+}
+
+// NowPlayingEntry is a track being played by a user
+type NowPlayingEntry struct {
+	Child
+	Username   string `xml:"username,attr" json:"username"`
+	MinutesAgo int    `xml:"minutesAgo,attr" json:"minutesAgo"`
+	PlayerID   int    `xml:"playerId,attr" json:"playerId"`
+	PlayerName string `xml:"playerName,attr,omitempty" json:"playerName,omitempty"`
+}
+
+// Playlists lists the user's playlists
+type Playlists struct {
+	Playlist []Playlist `xml:"playlist" json:"playlist,omitempty"`
+}
+
+// Playlist describes a playlist
+type Playlist struct {
+	ID        string `xml:"id,attr" json:"id"`
+	Name      string `xml:"name,attr" json:"name"`
+	Comment   string `xml:"comment,attr,omitempty" json:"comment,omitempty"`
+	Owner     string `xml:"owner,attr" json:"owner"`
+	Public    bool   `xml:"public,attr" json:"public"`
+	SongCount int    `xml:"songCount,attr" json:"songCount"`
+	Duration  int    `xml:"duration,attr" json:"duration"`
+	Created   string `xml:"created,attr" json:"created"`
+	Changed   string `xml:"changed,attr" json:"changed"`
+	CoverArt  string `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	// Readonly is set on smart playlists, whose tracks follow their rules
+	Readonly bool `xml:"readonly,attr,omitempty" json:"readonly,omitempty"`
+}
+
+// PlaylistWithSongs is a playlist with its tracks
+type PlaylistWithSongs struct {
+	Playlist
+	Entry []Child `xml:"entry" json:"entry,omitempty"`
+}
+
+// ScanStatus reports whether a library scan is running
+type ScanStatus struct {
+	Scanning bool `xml:"scanning,attr" json:"scanning"`
+	Count    int  `xml:"count,attr" json:"count"`
+}
@@ -0,0 +1,150 @@
+// Package subsonic holds the data model of the Subsonic REST API, with the
+// OpenSubsonic extensions, so that existing clients such as DSub, Symfonium
+// and Feishin can browse and play the library. The handlers package serves
+// it at /rest/.
+package subsonic
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+)
+
+const (
+	// Version is the Subsonic API version implemented
+	Version = "1.16.1"
+	// ServerType and ServerVersion identify the server to OpenSubsonic clients
+	ServerType    = "MediaBackend"
+	ServerVersion = "1.0.0"
+
+	namespace = "http://subsonic.org/restapi"
+)
+
+// Error codes defined by the API
+const (
+	CodeGeneric          = 0
+	CodeMissingParameter = 10
+	CodeWrongCredentials = 40
+	CodeNotAuthorized    = 50
+	CodeNotFound         = 70
+)
+
+// Error is a failed request, reported in a response with status "failed"
+type Error struct {
+	Code    int    `xml:"code,attr" json:"code"`
+	Message string `xml:"message,attr" json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("subsonic error %d: %s", e.Code, e.Message)
+}
+
+// Errorf returns an Error with the given code and message
+func Errorf(code int, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// MissingParameter reports a required parameter that was not sent
+func MissingParameter(name string) *Error {
+	return Errorf(CodeMissingParameter, "Required parameter is missing: %s", name)
+}
+
+// NotFound reports an unknown ID
+func NotFound(what string) *Error {
+	return Errorf(CodeNotFound, "%s not found", what)
+}
+
+// Response is the subsonic-response envelope. Endpoints set the one field
+// holding their result.
+type Response struct {
+	XMLName       xml.Name `xml:"subsonic-response" json:"-"`
+	Namespace     string   `xml:"xmlns,attr" json:"-"`
+	Status        string   `xml:"status,attr" json:"status"`
+	Version       string   `xml:"version,attr" json:"version"`
+	Type          string   `xml:"type,attr" json:"type"`
+	ServerVersion string   `xml:"serverVersion,attr" json:"serverVersion"`
+	OpenSubsonic  bool     `xml:"openSubsonic,attr" json:"openSubsonic"`
+
+	Error                  *Error             `xml:"error,omitempty" json:"error,omitempty"`
+	License                *License           `xml:"license,omitempty" json:"license,omitempty"`
+	OpenSubsonicExtensions *[]Extension       `xml:"openSubsonicExtensions,omitempty" json:"openSubsonicExtensions,omitempty"`
+	User                   *User              `xml:"user,omitempty" json:"user,omitempty"`
+	MusicFolders           *MusicFolders      `xml:"musicFolders,omitempty" json:"musicFolders,omitempty"`
+	Indexes                *Indexes           `xml:"indexes,omitempty" json:"indexes,omitempty"`
+	Directory              *Directory         `xml:"directory,omitempty" json:"directory,omitempty"`
+	Artists                *Artists           `xml:"artists,omitempty" json:"artists,omitempty"`
+	Artist                 *ArtistWithAlbums  `xml:"artist,omitempty" json:"artist,omitempty"`
+	Album                  *AlbumWithSongs    `xml:"album,omitempty" json:"album,omitempty"`
+	Song                   *Child             `xml:"song,omitempty" json:"song,omitempty"`
+	Genres                 *Genres            `xml:"genres,omitempty" json:"genres,omitempty"`
+	AlbumList              *AlbumList         `xml:"albumList,omitempty" json:"albumList,omitempty"`
+	AlbumList2             *AlbumList2        `xml:"albumList2,omitempty" json:"albumList2,omitempty"`
+	RandomSongs            *Songs             `xml:"randomSongs,omitempty" json:"randomSongs,omitempty"`
+	SongsByGenre           *Songs             `xml:"songsByGenre,omitempty" json:"songsByGenre,omitempty"`
+	SearchResult2          *SearchResult2     `xml:"searchResult2,omitempty" json:"searchResult2,omitempty"`
+	SearchResult3          *SearchResult3     `xml:"searchResult3,omitempty" json:"searchResult3,omitempty"`
+	Starred                *Starred           `xml:"starred,omitempty" json:"starred,omitempty"`
+	Starred2               *Starred2          `xml:"starred2,omitempty" json:"starred2,omitempty"`
+	NowPlaying             *NowPlaying        `xml:"nowPlaying,omitempty" json:"nowPlaying,omitempty"`
+	Playlists              *Playlists         `xml:"playlists,omitempty" json:"playlists,omitempty"`
+	Playlist               *PlaylistWithSongs `xml:"playlist,omitempty" json:"playlist,omitempty"`
+	ScanStatus             *ScanStatus        `xml:"scanStatus,omitempty" json:"scanStatus,omitempty"`
+}
+
+// NewResponse returns an empty successful response
+func NewResponse() *Response {
+	return &Response{
+		Namespace:     namespace,
+		Status:        "ok",
+		Version:       Version,
+		Type:          ServerType,
+		ServerVersion: ServerVersion,
+		OpenSubsonic:  true,
+	}
+}
+
+// Failed returns a response reporting err
+func Failed(err *Error) *Response {
+	resp := NewResponse()
+	resp.Status = "failed"
+	resp.Error = err
+	return resp
+}
+
+// validCallback restricts JSONP callbacks to JavaScript identifiers
+var validCallback = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$.]*$`)
+
+// Write encodes a response as XML, or as JSON or JSONP when the request
+// asks for f=json or f=jsonp. Errors are reported in the body with status
+// 200, as clients expect.
+func Write(w http.ResponseWriter, r *http.Request, resp *Response) {
+	var (
+		data        []byte
+		err         error
+		contentType string
+	)
+	switch format := r.FormValue("f"); format {
+	case "json", "jsonp":
+		data, err = json.Marshal(map[string]*Response{"subsonic-response": resp})
+		contentType = "application/json"
+		if callback := r.FormValue("callback"); format == "jsonp" && validCallback.MatchString(callback) {
+			data = append(append([]byte(callback+"("), data...), ");"...)
+			contentType = "application/javascript"
+		}
+	default:
+		data, err = xml.Marshal(resp)
+		data = append([]byte(xml.Header), data...)
+		contentType = "application/xml"
+	}
+	if err != nil {
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		log.Printf("Error encoding Subsonic response: %v", err)
+		return
+	}
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
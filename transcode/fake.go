@@ -0,0 +1,55 @@
+package transcode
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// Fake is a Transcoder for tests and development without ffmpeg. Its output
+// is a one-line header naming the format and bitrate followed by the input.
+type Fake struct {
+	// Calls counts completed transcodes
+	Calls atomic.Int64
+	// Err, when set, is returned instead of transcoding
+	Err error
+}
+
+// NewFake returns a fake transcoder
+func NewFake() *Fake {
+	return &Fake{}
+}
+
+// Name identifies the implementation in logs
+func (f *Fake) Name() string {
+	return "fake"
+}
+
+// Transcode copies the input after a header line
+func (f *Fake) Transcode(ctx context.Context, in io.Reader, out io.Writer, opts Options) error {
+	if f.Err != nil {
+		return f.Err
+	}
+	if _, err := fmt.Fprintf(out, "FAKE %s %dk\n", opts.Format.Name, opts.Bitrate); err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, readerWithContext{ctx, in}); err != nil {
+		return err
+	}
+	f.Calls.Add(1)
+	return nil
+}
+
+// readerWithContext stops reading once the context is cancelled
+type readerWithContext struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r readerWithContext) Read(b []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(b)
+}
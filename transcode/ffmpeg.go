@@ -0,0 +1,118 @@
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"MediaBackend/audio"
+)
+
+// ffmpegCodecs maps output formats to the ffmpeg encoder and muxer
+var ffmpegCodecs = map[string][2]string{
+	"mp3":  {"libmp3lame", "mp3"},
+	"opus": {"libopus", "ogg"},
+	"aac":  {"aac", "adts"},
+}
+
+// DefaultFFmpegTimeout bounds one ffmpeg run unless FFMPEG_TIMEOUT is set
+const DefaultFFmpegTimeout = 30 * time.Minute
+
+// FFmpeg transcodes by running the ffmpeg binary with stdin and stdout pipes
+type FFmpeg struct {
+	Path string
+	// Timeout kills ffmpeg when a conversion runs longer; 0 disables it
+	Timeout time.Duration
+}
+
+// NewFFmpeg locates the ffmpeg binary, using path when it is set
+func NewFFmpeg(path string) (*FFmpeg, error) {
+	if path == "" {
+		path = "ffmpeg"
+	}
+	resolved, err := exec.LookPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg not found: %w", err)
+	}
+	return &FFmpeg{Path: resolved, Timeout: DefaultFFmpegTimeout}, nil
+}
+
+// Name identifies the implementation in logs
+func (f *FFmpeg) Name() string {
+	return "ffmpeg (" + f.Path + ")"
+}
+
+// Transcode runs one ffmpeg process for the conversion
+func (f *FFmpeg) Transcode(ctx context.Context, in io.Reader, out io.Writer, opts Options) error {
+	codec, ok := ffmpegCodecs[opts.Format.Name]
+	if !ok {
+		return fmt.Errorf("ffmpeg: unsupported output format %q", opts.Format.Name)
+	}
+
+	input := "pipe:0"
+	if opts.Input == audio.FormatM4A {
+		// MP4 files often keep their index at the end, which ffmpeg cannot
+		// reach through a pipe
+		tmp, err := spool(in)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp)
+		input, in = tmp, nil
+	}
+
+	args := []string{
+		"-hide_banner", "-nostdin", "-loglevel", "error",
+		"-i", input,
+		"-map", "0:a:0", "-map_metadata", "0",
+		"-c:a", codec[0], "-b:a", strconv.Itoa(opts.Bitrate) + "k",
+		"-f", codec[1], "pipe:1",
+	}
+	if f.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.Timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, f.Path, args...)
+	cmd.Stdin = in
+	cmd.Stdout = out
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	// Don't wait for a stalled source or sink once ffmpeg is killed
+	cmd.WaitDelay = 5 * time.Second
+
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("ffmpeg: timed out after %v", f.Timeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("ffmpeg: %w: %s", err, msg)
+		}
+		return fmt.Errorf("ffmpeg: %w", err)
+	}
+	return nil
+}
+
+// spool copies a stream to a temporary file and returns its name
+func spool(in io.Reader) (string, error) {
+	tmp, err := os.CreateTemp("", "transcode-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(tmp, in)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
@@ -0,0 +1,127 @@
+package transcode
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"MediaBackend/audio"
+	"MediaBackend/jobs"
+)
+
+// ErrUnavailable is returned when no transcoder is configured
+var ErrUnavailable = errors.New("transcoding is not available")
+
+// Format is an output format with its bitrate ladder
+type Format struct {
+	Name        string
+	Ext         string
+	ContentType string
+	// Bitrates are the offered bitrates in kbit/s, in ascending order
+	Bitrates       []int
+	DefaultBitrate int
+}
+
+// Formats lists the supported output formats by name
+var Formats = map[string]Format{
+	"mp3": {
+		Name:           "mp3",
+		Ext:            "mp3",
+		ContentType:    "audio/mpeg",
+		Bitrates:       []int{96, 128, 192, 256, 320},
+		DefaultBitrate: 192,
+	},
+	"opus": {
+		Name:           "opus",
+		Ext:            "opus",
+		ContentType:    "audio/ogg; codecs=opus",
+		Bitrates:       []int{48, 64, 96, 128, 160},
+		DefaultBitrate: 96,
+	},
+	"aac": {
+		Name:           "aac",
+		Ext:            "aac",
+		ContentType:    "audio/aac",
+		Bitrates:       []int{64, 96, 128, 192, 256},
+		DefaultBitrate: 128,
+	},
+}
+
+// Bitrate snaps a requested bitrate in kbit/s to the nearest rung of the
+// ladder, so that similar requests share cached outputs. 0 selects the default.
+func (f Format) Bitrate(requested int) int {
+	if requested <= 0 {
+		return f.DefaultBitrate
+	}
+	best := f.Bitrates[0]
+	for _, b := range f.Bitrates {
+		if abs(b-requested) < abs(best-requested) {
+			best = b
+		}
+	}
+	return best
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// Options describes a transcoding request
+type Options struct {
+	Format Format
+	// Bitrate in kbit/s, already snapped to the format's ladder
+	Bitrate int
+	// Input is the format of the source, when known
+	Input audio.Format
+}
+
+// Transcoder converts an audio stream to another format
+type Transcoder interface {
+	// Name identifies the implementation in logs
+	Name() string
+	// Transcode reads the source from in and writes the encoded output to out
+	Transcode(ctx context.Context, in io.Reader, out io.Writer, opts Options) error
+}
+
+var (
+	// Default is the transcoder used by the HTTP handlers, nil when unavailable
+	Default Transcoder
+
+	// Pool bounds concurrent transcodes by TRANSCODE_WORKERS (default 2),
+	// separately from the default job pool as encoding is CPU heavy
+	Pool = jobs.NewPool("transcode", jobs.WorkersFromEnv("TRANSCODE_WORKERS", 2))
+)
+
+// Init selects the transcoder from TRANSCODER (ffmpeg or fake, default
+// ffmpeg). The ffmpeg binary is taken from FFMPEG_PATH or the PATH, and
+// FFMPEG_TIMEOUT bounds each run (default 30m, 0 disables).
+func Init() error {
+	switch name := os.Getenv("TRANSCODER"); name {
+	case "", "ffmpeg":
+		ff, err := NewFFmpeg(os.Getenv("FFMPEG_PATH"))
+		if err != nil {
+			return err
+		}
+		if v := os.Getenv("FFMPEG_TIMEOUT"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				log.Printf("⚠️  Invalid FFMPEG_TIMEOUT %q: %v", v, err)
+			} else {
+				ff.Timeout = d
+			}
+		}
+		Default = ff
+	case "fake":
+		Default = NewFake()
+	default:
+		return errors.New("unknown TRANSCODER " + name)
+	}
+	log.Printf("✓ Transcoder: %s", Default.Name())
+	return nil
+}
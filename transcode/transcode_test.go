@@ -0,0 +1,143 @@
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"MediaBackend/jobs"
+)
+
+func TestFormatBitrate(t *testing.T) {
+	tests := []struct {
+		format    string
+		requested int
+		want      int
+	}{
+		{"mp3", 0, 192},
+		{"mp3", -5, 192},
+		{"mp3", 100, 96},
+		{"mp3", 150, 128},
+		{"mp3", 170, 192},
+		{"mp3", 1000, 320},
+		{"opus", 1, 48},
+		{"opus", 80, 64},
+		{"aac", 160, 128},
+		{"aac", 256, 256},
+	}
+	for _, tt := range tests {
+		if got := Formats[tt.format].Bitrate(tt.requested); got != tt.want {
+			t.Errorf("%s.Bitrate(%d) = %d, want %d", tt.format, tt.requested, got, tt.want)
+		}
+	}
+}
+
+func TestFakeTranscode(t *testing.T) {
+	fake := NewFake()
+	var out bytes.Buffer
+	opts := Options{Format: Formats["opus"], Bitrate: 96}
+	if err := fake.Transcode(context.Background(), strings.NewReader("audio"), &out, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "FAKE opus 96k\naudio"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if fake.Calls.Load() != 1 {
+		t.Errorf("Calls = %d, want 1", fake.Calls.Load())
+	}
+
+	fake.Err = errors.New("broken")
+	if err := fake.Transcode(context.Background(), strings.NewReader("audio"), &out, opts); err != fake.Err {
+		t.Errorf("err = %v, want %v", err, fake.Err)
+	}
+	if fake.Calls.Load() != 1 {
+		t.Errorf("failed transcode counted, Calls = %d", fake.Calls.Load())
+	}
+}
+
+func TestFakeTranscodeCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var out bytes.Buffer
+	err := NewFake().Transcode(ctx, strings.NewReader("audio"), &out, Options{Format: Formats["mp3"], Bitrate: 128})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+}
+
+func TestPoolSharesTranscodesByKey(t *testing.T) {
+	fake := NewFake()
+	pool := jobs.NewPool("transcode-test", 1)
+	release := make(chan struct{})
+	var out bytes.Buffer
+	run := func(ctx context.Context, job *jobs.Job) error {
+		<-release
+		return fake.Transcode(ctx, strings.NewReader("audio"), &out, Options{Format: Formats["aac"], Bitrate: 128})
+	}
+
+	first := pool.Submit("transcode", "transcodes/etag/128.aac", run)
+	second := pool.Submit("transcode", "transcodes/etag/128.aac", run)
+	if first != second {
+		t.Fatal("requests for the same output started separate jobs")
+	}
+	close(release)
+	if !first.Wait(context.Background()) {
+		t.Fatal("job did not finish")
+	}
+	if err := first.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if fake.Calls.Load() != 1 {
+		t.Errorf("Calls = %d, want 1", fake.Calls.Load())
+	}
+	if got := first.Info().Status; got != jobs.StatusDone {
+		t.Errorf("status = %s, want %s", got, jobs.StatusDone)
+	}
+
+	// Once finished, the key can run again
+	third := pool.Submit("transcode", "transcodes/etag/128.aac", run)
+	if third == first {
+		t.Error("finished job was reused")
+	}
+	third.Wait(context.Background())
+}
+
+func TestPoolReportsTranscodeFailure(t *testing.T) {
+	fake := &Fake{Err: errors.New("unsupported input")}
+	pool := jobs.NewPool("transcode-test", 1)
+	job := pool.Submit("transcode", "transcodes/etag/96.mp3", func(ctx context.Context, job *jobs.Job) error {
+		return fake.Transcode(ctx, strings.NewReader("audio"), &bytes.Buffer{}, Options{Format: Formats["mp3"], Bitrate: 96})
+	})
+	job.Wait(context.Background())
+	if job.Err() != fake.Err {
+		t.Errorf("err = %v, want %v", job.Err(), fake.Err)
+	}
+	info := job.Info()
+	if info.Status != jobs.StatusFailed || info.Error != "unsupported input" {
+		t.Errorf("info = %+v, want failed with the transcoder's error", info)
+	}
+}
+
+func TestFFmpegTimeout(t *testing.T) {
+	// A stand-in for ffmpeg that never finishes
+	path := filepath.Join(t.TempDir(), "ffmpeg")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\nexec sleep 60\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	ff := &FFmpeg{Path: path, Timeout: 100 * time.Millisecond}
+
+	start := time.Now()
+	var out bytes.Buffer
+	err := ff.Transcode(context.Background(), strings.NewReader("audio"), &out, Options{Format: Formats["mp3"], Bitrate: 128})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("err = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Transcode returned after %v", elapsed)
+	}
+}
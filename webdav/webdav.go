@@ -0,0 +1,147 @@
+// Package webdav implements the protocol side of a WebDAV class 1 and 2
+// server (RFC 4918), so that Finder, Windows Explorer and other clients can
+// mount the music and image buckets as network drives: request headers,
+// PROPFIND and PROPPATCH bodies, multistatus responses and lock tokens.
+// Locks are stubs that always succeed, which is enough for clients that
+// refuse to write without them. The handlers package serves it at
+// /gomedia/dav/. It is enabled with WEBDAV_ENABLED=true and kept read-only
+// with WEBDAV_READONLY=true or when authentication is off.
+package webdav
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"MediaBackend/middleware"
+	"MediaBackend/randid"
+)
+
+// Prefix is the path the buckets are mounted under
+const Prefix = "/gomedia/dav/"
+
+// Depth values; Infinity is the default where a request may omit it
+const (
+	Depth0        = 0
+	Depth1        = 1
+	DepthInfinity = -1
+)
+
+var (
+	// ErrInvalidDepth is returned for Depth headers other than 0, 1 and
+	// infinity
+	ErrInvalidDepth = errors.New("invalid depth")
+	// ErrInvalidDestination is returned for Destination headers outside
+	// the server
+	ErrInvalidDestination = errors.New("invalid destination")
+)
+
+var (
+	enabled = strings.EqualFold(os.Getenv("WEBDAV_ENABLED"), "true")
+	// Without AUTH_USERS anyone who reaches the server could change or
+	// delete the buckets, so the endpoint is then read-only regardless
+	readOnly = strings.EqualFold(os.Getenv("WEBDAV_READONLY"), "true") || !middleware.AuthEnabled()
+)
+
+// Enabled reports whether WEBDAV_ENABLED turns the endpoint on
+func Enabled() bool {
+	return enabled
+}
+
+// ReadOnly reports whether changes are rejected, because of WEBDAV_READONLY
+// or because AUTH_USERS is not set
+func ReadOnly() bool {
+	return readOnly
+}
+
+// IsWrite reports whether a method changes the storage
+func IsWrite(method string) bool {
+	switch method {
+	case http.MethodPut, http.MethodDelete, "MKCOL", "COPY", "MOVE", "PROPPATCH", "LOCK", "UNLOCK":
+		return true
+	}
+	return false
+}
+
+// Allow lists the methods supported, for OPTIONS and 405 responses
+func Allow() string {
+	if readOnly {
+		return "OPTIONS, GET, HEAD, PROPFIND"
+	}
+	return "OPTIONS, GET, HEAD, PROPFIND, PROPPATCH, PUT, DELETE, MKCOL, COPY, MOVE, LOCK, UNLOCK"
+}
+
+// Compliance is the DAV header value. Read-only servers leave out class 2
+// so that clients mount them read-only instead of failing to lock.
+func Compliance() string {
+	if readOnly {
+		return "1"
+	}
+	return "1, 2"
+}
+
+// ParseDepth parses a Depth header, returning fallback when it is absent
+func ParseDepth(header string, fallback int) (int, error) {
+	switch strings.ToLower(strings.TrimSpace(header)) {
+	case "":
+		return fallback, nil
+	case "0":
+		return Depth0, nil
+	case "1":
+		return Depth1, nil
+	case "infinity":
+		return DepthInfinity, nil
+	}
+	return 0, ErrInvalidDepth
+}
+
+// ParseDestination returns the path of a COPY or MOVE Destination header,
+// which may be an absolute URL or a path, below Prefix. The host is not
+// compared, since proxies may rewrite it.
+func ParseDestination(r *http.Request) (string, error) {
+	header := r.Header.Get("Destination")
+	if header == "" {
+		return "", ErrInvalidDestination
+	}
+	u, err := url.Parse(header)
+	if err != nil {
+		return "", ErrInvalidDestination
+	}
+	if !strings.HasPrefix(u.Path, Prefix) {
+		return "", ErrInvalidDestination
+	}
+	return u.Path, nil
+}
+
+// Overwrite reports whether the Overwrite header allows replacing the
+// destination; it defaults to true
+func Overwrite(r *http.Request) bool {
+	return !strings.EqualFold(strings.TrimSpace(r.Header.Get("Overwrite")), "F")
+}
+
+// Href escapes a path for use in a response
+func Href(p string) string {
+	return (&url.URL{Path: p}).EscapedPath()
+}
+
+// NewLockToken returns a random opaquelocktoken URI
+func NewLockToken() string {
+	return "opaquelocktoken:" + randid.UUID()
+}
+
+// SubmittedLockToken returns the lock token of an If header, as sent to
+// refresh a lock, e.g. (<opaquelocktoken:...>)
+func SubmittedLockToken(r *http.Request) string {
+	header := r.Header.Get("If")
+	start := strings.Index(header, "<opaquelocktoken:")
+	if start < 0 {
+		return ""
+	}
+	end := strings.IndexByte(header[start:], '>')
+	if end < 0 {
+		return ""
+	}
+	return header[start+1 : start+end]
+}
@@ -0,0 +1,88 @@
+package webdav
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"MediaBackend/middleware"
+)
+
+func TestParseDepth(t *testing.T) {
+	for header, want := range map[string]int{"": Depth1, "0": Depth0, " 1 ": Depth1, "Infinity": DepthInfinity} {
+		if got, err := ParseDepth(header, Depth1); err != nil || got != want {
+			t.Errorf("ParseDepth(%q) = %d, %v, want %d", header, got, err, want)
+		}
+	}
+	if _, err := ParseDepth("2", Depth0); !errors.Is(err, ErrInvalidDepth) {
+		t.Errorf("ParseDepth(2) = %v, want ErrInvalidDepth", err)
+	}
+}
+
+func TestParseDestination(t *testing.T) {
+	for _, tt := range []struct {
+		header, want string
+		ok           bool
+	}{
+		{"http://proxy.example/gomedia/dav/music/New%20Album/a.mp3", "/gomedia/dav/music/New Album/a.mp3", true},
+		{"/gomedia/dav/images/cover.jpg", "/gomedia/dav/images/cover.jpg", true},
+		{"http://host/elsewhere/a.mp3", "", false},
+		{"", "", false},
+		{"http://host/%zz", "", false},
+	} {
+		r := httptest.NewRequest("MOVE", Prefix+"music/a.mp3", nil)
+		if tt.header != "" {
+			r.Header.Set("Destination", tt.header)
+		}
+		got, err := ParseDestination(r)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseDestination(%q) = %q, %v", tt.header, got, err)
+		}
+	}
+}
+
+func TestHref(t *testing.T) {
+	if got := Href(Prefix + "music/AC/DC & co/#1 100%.mp3"); got != "/gomedia/dav/music/AC/DC%20&%20co/%231%20100%25.mp3" {
+		t.Errorf("Href = %q", got)
+	}
+}
+
+func TestLockToken(t *testing.T) {
+	token := NewLockToken()
+	if !strings.HasPrefix(token, "opaquelocktoken:") || len(token) != len("opaquelocktoken:")+36 || token == NewLockToken() {
+		t.Errorf("NewLockToken = %q", token)
+	}
+	r := httptest.NewRequest("LOCK", Prefix+"music/a.mp3", nil)
+	r.Header.Set("If", "(<"+token+">)")
+	if got := SubmittedLockToken(r); got != token {
+		t.Errorf("SubmittedLockToken = %q, want %q", got, token)
+	}
+	r.Header.Set("If", "(<opaquelocktoken:unterminated)")
+	if got := SubmittedLockToken(r); got != "" {
+		t.Errorf("SubmittedLockToken of a malformed header = %q", got)
+	}
+}
+
+func TestOverwrite(t *testing.T) {
+	for header, want := range map[string]bool{"": true, "T": true, "F": false, " f ": false} {
+		r := httptest.NewRequest("COPY", Prefix+"music/a.mp3", nil)
+		r.Header.Set("Overwrite", header)
+		if got := Overwrite(r); got != want {
+			t.Errorf("Overwrite(%q) = %v", header, got)
+		}
+	}
+	if !IsWrite(http.MethodPut) || !IsWrite("MOVE") || IsWrite("PROPFIND") || IsWrite(http.MethodGet) {
+		t.Error("IsWrite")
+	}
+}
+
+func TestReadOnlyWithoutAuth(t *testing.T) {
+	if middleware.AuthEnabled() {
+		t.Skip("AUTH_USERS is set")
+	}
+	if !ReadOnly() || Compliance() != "1" || strings.Contains(Allow(), "PUT") {
+		t.Errorf("without authentication ReadOnly = %v, DAV %q, Allow %q", ReadOnly(), Compliance(), Allow())
+	}
+}
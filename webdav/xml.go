@@ -0,0 +1,309 @@
+package webdav
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxBodySize limits the size of PROPFIND, PROPPATCH and LOCK bodies
+const maxBodySize = 64 << 10
+
+// davNS is the namespace of the properties defined by WebDAV
+const davNS = "DAV:"
+
+// ErrInvalidBody is returned for request bodies that are not well-formed
+var ErrInvalidBody = errors.New("invalid request body")
+
+// Resource is a file or collection described in a multistatus response
+type Resource struct {
+	// Path is the unescaped path below Prefix; collections end in "/"
+	Path        string
+	Collection  bool
+	Size        int64
+	Modified    time.Time
+	ETag        string
+	ContentType string
+}
+
+// Propfind is a parsed PROPFIND body
+type Propfind struct {
+	// PropName asks for the names of the properties only
+	PropName bool
+	// Props are the properties asked for; none means all of them
+	Props []xml.Name
+}
+
+// ReadPropfind parses a PROPFIND body. An empty body asks for all
+// properties.
+func ReadPropfind(r *http.Request) (*Propfind, error) {
+	var body struct {
+		AllProp  *struct{} `xml:"DAV: allprop"`
+		PropName *struct{} `xml:"DAV: propname"`
+		Prop     struct {
+			Names []struct {
+				XMLName xml.Name
+			} `xml:",any"`
+		} `xml:"DAV: prop"`
+	}
+	if err := decodeBody(r, &body); err != nil {
+		if err == io.EOF {
+			return &Propfind{}, nil
+		}
+		return nil, err
+	}
+	pf := &Propfind{PropName: body.PropName != nil}
+	if body.AllProp == nil {
+		for _, name := range body.Prop.Names {
+			pf.Props = append(pf.Props, name.XMLName)
+		}
+	}
+	return pf, nil
+}
+
+// ReadProppatch returns the names of the properties a PROPPATCH body sets
+// or removes
+func ReadProppatch(r *http.Request) ([]xml.Name, error) {
+	var body struct {
+		Updates []struct {
+			Prop struct {
+				Names []struct {
+					XMLName xml.Name
+				} `xml:",any"`
+			} `xml:"DAV: prop"`
+		} `xml:",any"`
+	}
+	if err := decodeBody(r, &body); err != nil {
+		if err == io.EOF {
+			return nil, ErrInvalidBody
+		}
+		return nil, err
+	}
+	var names []xml.Name
+	for _, update := range body.Updates {
+		for _, name := range update.Prop.Names {
+			names = append(names, name.XMLName)
+		}
+	}
+	return names, nil
+}
+
+// decodeBody decodes an XML request body, returning io.EOF when it is empty
+func decodeBody(r *http.Request, v any) error {
+	err := xml.NewDecoder(io.LimitReader(r.Body, maxBodySize)).Decode(v)
+	if err != nil && err != io.EOF {
+		return ErrInvalidBody
+	}
+	return err
+}
+
+// property is a live property of a resource
+type property struct {
+	name  string
+	value string
+}
+
+// properties returns the properties of a resource, as XML fragments
+func (res *Resource) properties() []property {
+	name := strings.TrimSuffix(res.Path, "/")
+	name = name[strings.LastIndexByte(name, '/')+1:]
+	props := []property{{name: "displayname", value: escape(name)}}
+	if res.Collection {
+		props = append(props, property{name: "resourcetype", value: "<D:collection/>"})
+	} else {
+		props = append(props,
+			property{name: "resourcetype"},
+			property{name: "getcontentlength", value: strconv.FormatInt(res.Size, 10)},
+			property{name: "getcontenttype", value: escape(res.ContentType)},
+		)
+		if res.ETag != "" {
+			props = append(props, property{name: "getetag", value: escape(strconv.Quote(res.ETag))})
+		}
+	}
+	if !res.Modified.IsZero() {
+		props = append(props,
+			property{name: "getlastmodified", value: res.Modified.UTC().Format(http.TimeFormat)},
+			property{name: "creationdate", value: res.Modified.UTC().Format(time.RFC3339)},
+		)
+	}
+	if !readOnly {
+		props = append(props, property{name: "supportedlock", value: supportedLock})
+	}
+	return append(props, property{name: "lockdiscovery"})
+}
+
+// supportedLock advertises the exclusive and shared write locks LOCK hands out
+const supportedLock = `<D:lockentry><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockentry>` +
+	`<D:lockentry><D:lockscope><D:shared/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockentry>`
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// Multistatus builds a 207 Multi-Status response
+type Multistatus struct {
+	b strings.Builder
+}
+
+// NewMultistatus starts an empty response
+func NewMultistatus() *Multistatus {
+	m := &Multistatus{}
+	m.b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n" + `<D:multistatus xmlns:D="DAV:">`)
+	return m
+}
+
+// AddResource adds the properties of a resource a PROPFIND asked for. Those
+// it does not have are reported as not found.
+func (m *Multistatus) AddResource(res *Resource, pf *Propfind) {
+	props := res.properties()
+	var found, missing strings.Builder
+	switch {
+	case pf.PropName:
+		for _, p := range props {
+			fmt.Fprintf(&found, "<D:%s/>", p.name)
+		}
+	case len(pf.Props) == 0:
+		for _, p := range props {
+			writeProperty(&found, p)
+		}
+	default:
+		for _, name := range pf.Props {
+			if p, ok := lookup(props, name); ok {
+				writeProperty(&found, p)
+			} else {
+				writeName(&missing, name)
+			}
+		}
+	}
+	m.b.WriteString("<D:response><D:href>" + escape(Href(Prefix+res.Path)) + "</D:href>")
+	writePropstat(&m.b, found.String(), http.StatusOK)
+	writePropstat(&m.b, missing.String(), http.StatusNotFound)
+	m.b.WriteString("</D:response>")
+}
+
+// AddProppatch acknowledges the properties a PROPPATCH changed. Dead
+// properties are not stored, but clients such as Windows Explorer give up
+// on files whose timestamps they cannot set.
+func (m *Multistatus) AddProppatch(p string, names []xml.Name) {
+	var props strings.Builder
+	for _, name := range names {
+		writeName(&props, name)
+	}
+	m.b.WriteString("<D:response><D:href>" + escape(Href(Prefix+p)) + "</D:href>")
+	writePropstat(&m.b, props.String(), http.StatusOK)
+	m.b.WriteString("</D:response>")
+}
+
+// WriteTo writes the response with status 207
+func (m *Multistatus) WriteTo(w http.ResponseWriter) {
+	m.b.WriteString("</D:multistatus>\n")
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, m.b.String())
+}
+
+func lookup(props []property, name xml.Name) (property, bool) {
+	if name.Space != davNS {
+		return property{}, false
+	}
+	for _, p := range props {
+		if p.name == name.Local {
+			return p, true
+		}
+	}
+	return property{}, false
+}
+
+func writeProperty(b *strings.Builder, p property) {
+	if p.value == "" {
+		fmt.Fprintf(b, "<D:%s/>", p.name)
+		return
+	}
+	fmt.Fprintf(b, "<D:%s>%s</D:%s>", p.name, p.value, p.name)
+}
+
+// writeName writes an empty element for a property of any namespace
+func writeName(b *strings.Builder, name xml.Name) {
+	if name.Space == davNS {
+		fmt.Fprintf(b, "<D:%s/>", name.Local)
+		return
+	}
+	fmt.Fprintf(b, `<%s xmlns="%s"/>`, name.Local, escape(name.Space))
+}
+
+func writePropstat(b *strings.Builder, props string, status int) {
+	if props == "" {
+		return
+	}
+	fmt.Fprintf(b, "<D:propstat><D:prop>%s</D:prop><D:status>HTTP/1.1 %d %s</D:status></D:propstat>",
+		props, status, http.StatusText(status))
+}
+
+// WriteLock answers a LOCK request, creating or refreshing a lock on a path.
+// Locks are not enforced; the token only satisfies the client.
+func WriteLock(w http.ResponseWriter, r *http.Request, p string, created bool) {
+	var body struct {
+		LockScope struct {
+			Shared *struct{} `xml:"DAV: shared"`
+		} `xml:"DAV: lockscope"`
+		Owner struct {
+			Href string `xml:"DAV: href"`
+			Text string `xml:",chardata"`
+		} `xml:"DAV: owner"`
+	}
+	token := SubmittedLockToken(r)
+	if err := decodeBody(r, &body); err != nil && err != io.EOF {
+		http.Error(w, "Invalid lock request", http.StatusBadRequest)
+		return
+	}
+	if token == "" {
+		token = NewLockToken()
+	}
+	scope := "<D:exclusive/>"
+	if body.LockScope.Shared != nil {
+		scope = "<D:shared/>"
+	}
+	depth := "infinity"
+	if d, err := ParseDepth(r.Header.Get("Depth"), DepthInfinity); err == nil && d == Depth0 {
+		depth = "0"
+	}
+
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n" + `<D:prop xmlns:D="DAV:"><D:lockdiscovery><D:activelock>`)
+	fmt.Fprintf(&b, "<D:locktype><D:write/></D:locktype><D:lockscope>%s</D:lockscope><D:depth>%s</D:depth>", scope, depth)
+	// The owner is echoed so that clients recognize their locks
+	if owner := strings.TrimSpace(body.Owner.Href); owner != "" {
+		fmt.Fprintf(&b, "<D:owner><D:href>%s</D:href></D:owner>", escape(owner))
+	} else if owner := strings.TrimSpace(body.Owner.Text); owner != "" {
+		fmt.Fprintf(&b, "<D:owner>%s</D:owner>", escape(owner))
+	}
+	fmt.Fprintf(&b, "<D:timeout>Second-%d</D:timeout>", lockTimeout)
+	fmt.Fprintf(&b, "<D:locktoken><D:href>%s</D:href></D:locktoken>", escape(token))
+	fmt.Fprintf(&b, "<D:lockroot><D:href>%s</D:href></D:lockroot>", escape(Href(Prefix+p)))
+	b.WriteString("</D:activelock></D:lockdiscovery></D:prop>\n")
+
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.Header().Set("Lock-Token", "<"+token+">")
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	}
+	io.WriteString(w, b.String())
+}
+
+// lockTimeout is the lifetime of a lock in seconds; clients refresh them
+const lockTimeout = 3600
+
+// WriteError writes an error response naming the precondition that failed,
+// such as "propfind-finite-depth"
+func WriteError(w http.ResponseWriter, status int, condition string) {
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>`+"\n"+`<D:error xmlns:D="DAV:"><D:%s/></D:error>`+"\n", condition)
+}
@@ -0,0 +1,82 @@
+package webdav
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func propfindRequest(body string) *http.Request {
+	return httptest.NewRequest("PROPFIND", Prefix, strings.NewReader(body))
+}
+
+func TestReadPropfind(t *testing.T) {
+	pf, err := ReadPropfind(propfindRequest(""))
+	if err != nil || pf.PropName || len(pf.Props) != 0 {
+		t.Errorf("empty PROPFIND = %+v, %v, want all properties", pf, err)
+	}
+	pf, err = ReadPropfind(propfindRequest(`<?xml version="1.0"?><propfind xmlns="DAV:" xmlns:x="urn:x"><prop><getetag/><x:color/></prop></propfind>`))
+	want := []xml.Name{{Space: "DAV:", Local: "getetag"}, {Space: "urn:x", Local: "color"}}
+	if err != nil || len(pf.Props) != 2 || pf.Props[0] != want[0] || pf.Props[1] != want[1] {
+		t.Errorf("PROPFIND prop = %+v, %v", pf, err)
+	}
+	if _, err := ReadPropfind(propfindRequest("<propfind")); !errors.Is(err, ErrInvalidBody) {
+		t.Errorf("malformed PROPFIND = %v, want ErrInvalidBody", err)
+	}
+}
+
+func TestMultistatus(t *testing.T) {
+	modified := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	m := NewMultistatus()
+	m.AddResource(&Resource{Path: "music/A & B/", Collection: true, Modified: modified}, &Propfind{})
+	m.AddResource(&Resource{Path: "music/A & B/song.mp3", Size: 42, ETag: "abc", ContentType: "audio/mpeg"},
+		&Propfind{Props: []xml.Name{{Space: "DAV:", Local: "getcontentlength"}, {Space: "urn:x", Local: "color"}}})
+	w := httptest.NewRecorder()
+	m.WriteTo(w)
+	body := w.Body.String()
+	if w.Code != http.StatusMultiStatus {
+		t.Errorf("status = %d", w.Code)
+	}
+	for _, want := range []string{
+		"<D:href>/gomedia/dav/music/A%20&amp;%20B/</D:href>",
+		"<D:displayname>A &amp; B</D:displayname><D:resourcetype><D:collection/></D:resourcetype>",
+		"<D:getlastmodified>Fri, 01 Mar 2024 12:00:00 GMT</D:getlastmodified>",
+		"<D:prop><D:getcontentlength>42</D:getcontentlength></D:prop><D:status>HTTP/1.1 200 OK</D:status>",
+		`<D:prop><color xmlns="urn:x"/></D:prop><D:status>HTTP/1.1 404 Not Found</D:status>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("multistatus lacks %s:\n%s", want, body)
+		}
+	}
+}
+
+func TestWriteLock(t *testing.T) {
+	r := httptest.NewRequest("LOCK", Prefix+"music/a.mp3", strings.NewReader(`<?xml version="1.0"?>`+
+		`<lockinfo xmlns="DAV:"><lockscope><shared/></lockscope><locktype><write/></locktype><owner><href>me &amp; you</href></owner></lockinfo>`))
+	r.Header.Set("Depth", "0")
+	w := httptest.NewRecorder()
+	WriteLock(w, r, "music/a.mp3", true)
+	body := w.Body.String()
+	token := strings.Trim(w.Header().Get("Lock-Token"), "<>")
+	if w.Code != http.StatusCreated || !strings.HasPrefix(token, "opaquelocktoken:") {
+		t.Fatalf("LOCK = %d with token %q", w.Code, token)
+	}
+	for _, want := range []string{"<D:shared/>", "<D:depth>0</D:depth>", "<D:owner><D:href>me &amp; you</D:href></D:owner>", "<D:href>" + token + "</D:href>"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("lock response lacks %s:\n%s", want, body)
+		}
+	}
+
+	// Refreshing keeps the submitted token
+	r = httptest.NewRequest("LOCK", Prefix+"music/a.mp3", nil)
+	r.Header.Set("If", "(<"+token+">)")
+	w = httptest.NewRecorder()
+	WriteLock(w, r, "music/a.mp3", false)
+	if w.Code != http.StatusOK || w.Header().Get("Lock-Token") != "<"+token+">" {
+		t.Errorf("refresh = %d with %q", w.Code, w.Header().Get("Lock-Token"))
+	}
+}